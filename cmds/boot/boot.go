@@ -43,6 +43,7 @@ import (
 
 	"github.com/u-root/u-root/pkg/cmdline"
 	"github.com/u-root/u-root/pkg/kexec"
+	"github.com/u-root/u-root/pkg/netlog"
 )
 
 const (
@@ -479,6 +480,7 @@ func Localboot() error {
 		if *dryRun {
 			continue
 		}
+		netlog.Flush()
 		if err := kexec.Reboot(); err != nil {
 			log.Printf("Kexec Reboot %v failed, %v. Sorry", u, err)
 		}
@@ -493,8 +495,11 @@ func main() {
 	if *verbose {
 		debug = log.Printf
 	}
+	netlog.Mirror("boot")
 
 	if err := Localboot(); err != nil {
-		log.Fatal(err)
+		log.Print(err)
+		netlog.Flush()
+		os.Exit(1)
 	}
 }
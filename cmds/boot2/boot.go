@@ -8,12 +8,19 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"net/url"
+	"os"
 	"strconv"
+	"strings"
 
 	"github.com/u-root/u-root/pkg/diskboot"
 	"github.com/u-root/u-root/pkg/kexec"
-	"github.com/u-root/u-root/pkg/mount"
+	"github.com/u-root/u-root/pkg/netlog"
+	"github.com/u-root/u-root/pkg/pxe"
+	"github.com/u-root/u-root/pkg/uio"
 )
 
 var (
@@ -26,11 +33,54 @@ var (
 	sConfigIndex  = flag.String("c", "", "Config index")
 	sEntryIndex   = flag.String("n", "", "Entry index")
 	appendCmdline = flag.String("append", "", "Additional kernel params")
+	iso           = flag.String("iso", "", "ISO image to boot instead of the devices, a file or a URL")
+	isoPath       = flag.String("iso-path", "", "Path of the ISO image for the booted kernel, passed as iso-scan/filename (default: -iso, if it is a file)")
 
 	devices []*diskboot.Device
 )
 
+// getISO downloads the image at the URL u to a temporary file, and
+// returns its name.
+func getISO(u *url.URL) (string, error) {
+	r, err := pxe.GetFile(u)
+	if err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile("", "boot-*.iso")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	verbose("Downloading %s to %s", u, f.Name())
+	if _, err := io.Copy(f, uio.Reader(r)); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+func getISODevice() (*diskboot.Device, error) {
+	file, p := *iso, *isoPath
+	if u, err := url.Parse(*iso); err == nil && u.Scheme != "" {
+		if file, err = getISO(u); err != nil {
+			return nil, fmt.Errorf("cannot download %s: %v", u, err)
+		}
+		defer os.Remove(file)
+	} else if p == "" {
+		p = file
+	}
+	device, err := diskboot.FindISO(file, p)
+	if err != nil {
+		return nil, err
+	}
+	devices = append(devices, device)
+	return device, nil
+}
+
 func getDevice() (*diskboot.Device, error) {
+	if *iso != "" {
+		return getISODevice()
+	}
 	devices = diskboot.FindDevices(*devGlob)
 	if len(devices) == 0 {
 		return nil, errors.New("No devices found")
@@ -99,9 +149,15 @@ func getEntry(config *diskboot.Config) (*diskboot.Entry, error) {
 	return &config.Entries[entryIndex], nil
 }
 
-func bootEntry(config *diskboot.Config, entry *diskboot.Entry) error {
+func bootEntry(device *diskboot.Device, config *diskboot.Config, entry *diskboot.Entry) error {
 	verbose("Booting entry: %v", entry)
-	err := entry.KexecLoad(config.MountPath, *appendCmdline, *dryrun)
+	cmdline := *appendCmdline
+	// Installers and live systems look for the image they were booted
+	// from by this parameter, which loopback.cfg entries already have.
+	if device.ISOPath != "" && len(entry.Modules) > 0 && !strings.Contains(entry.Modules[0].Params, "iso-scan/filename=") {
+		cmdline = strings.TrimSpace(cmdline + " iso-scan/filename=" + device.ISOPath)
+	}
+	err := entry.KexecLoad(config.MountPath, cmdline, *dryrun)
 	if err != nil {
 		return fmt.Errorf("wrror doing kexec load: %v", err)
 	}
@@ -110,6 +166,7 @@ func bootEntry(config *diskboot.Config, entry *diskboot.Entry) error {
 		return nil
 	}
 
+	netlog.Flush()
 	err = kexec.Reboot()
 	if err != nil {
 		return fmt.Errorf("error doing kexec reboot: %v", err)
@@ -119,7 +176,7 @@ func bootEntry(config *diskboot.Config, entry *diskboot.Entry) error {
 
 func cleanDevices() {
 	for _, device := range devices {
-		if err := mount.Unmount(device.MountPath, true, false); err != nil {
+		if err := device.Unmount(); err != nil {
 			log.Printf("Error unmounting device %v: %v", device.DevPath, err)
 		}
 	}
@@ -130,6 +187,8 @@ func main() {
 	if *v {
 		verbose = log.Printf
 	}
+	netlog.Mirror("boot2")
+	defer netlog.Flush()
 	defer cleanDevices()
 
 	device, err := getDevice()
@@ -144,7 +203,7 @@ func main() {
 	if err != nil {
 		log.Panic(err)
 	}
-	if err := bootEntry(config, entry); err != nil {
+	if err := bootEntry(device, config, entry); err != nil {
 		log.Panic(err)
 	}
 }
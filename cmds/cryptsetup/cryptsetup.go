@@ -0,0 +1,138 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Manage LUKS encrypted volumes.
+//
+// Synopsis:
+//     cryptsetup [-key-file FILE] [-readonly] open DEVICE NAME
+//     cryptsetup close NAME
+//     cryptsetup luksDump DEVICE
+//
+// Description:
+//     open unlocks the LUKS1 or LUKS2 volume on DEVICE and maps its
+//     decrypted contents to /dev/mapper/NAME. The passphrase is read from
+//     the key file, or from the terminal or stdin.
+//
+//     close removes the mapping /dev/mapper/NAME.
+//
+//     luksDump prints the LUKS header of DEVICE.
+//
+// Options:
+//     -key-file: read the passphrase from FILE ("-" for stdin)
+//     -readonly: set up a read-only mapping
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/u-root/u-root/pkg/luks"
+	"github.com/u-root/u-root/pkg/termios"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	keyFile  = flag.String("key-file", "", "Read the passphrase from this file")
+	readOnly = flag.Bool("readonly", false, "Set up a read-only mapping")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage:\n")
+	fmt.Fprintf(os.Stderr, "  cryptsetup [options] open DEVICE NAME\n")
+	fmt.Fprintf(os.Stderr, "  cryptsetup close NAME\n")
+	fmt.Fprintf(os.Stderr, "  cryptsetup luksDump DEVICE\n")
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
+// passphrase reads the passphrase from the key file, or prompts for it on
+// stdin with echo turned off when stdin is a terminal.
+func passphrase(device string) ([]byte, error) {
+	switch *keyFile {
+	case "":
+	case "-":
+		return ioutil.ReadAll(os.Stdin)
+	default:
+		// Key files are used verbatim, including any trailing newline.
+		return ioutil.ReadFile(*keyFile)
+	}
+
+	if t, err := termios.GetTermios(0); err == nil {
+		fmt.Fprintf(os.Stderr, "Enter passphrase for %s: ", device)
+		noecho := *t
+		noecho.Lflag &^= unix.ECHO
+		if err := termios.SetTermios(0, &noecho); err != nil {
+			return nil, err
+		}
+		defer fmt.Fprintln(os.Stderr)
+		defer termios.SetTermios(0, t)
+	}
+	l, err := bufio.NewReader(os.Stdin).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return bytes.TrimRight(l, "\r\n"), nil
+}
+
+func luksDump(device string) error {
+	f, err := os.Open(device)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	v, err := luks.Read(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", device, err)
+	}
+	v.Dump(os.Stdout)
+	return nil
+}
+
+func open(device, name string) error {
+	p, err := passphrase(device)
+	if err != nil {
+		return err
+	}
+	_, err = luks.Open(device, name, p, *readOnly)
+	return err
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	a := flag.Args()
+	if len(a) == 0 {
+		usage()
+	}
+
+	var err error
+	switch a[0] {
+	case "open", "luksOpen":
+		if len(a) != 3 {
+			usage()
+		}
+		err = open(a[1], a[2])
+	case "close", "luksClose":
+		if len(a) != 2 {
+			usage()
+		}
+		err = luks.Close(a[1])
+	case "luksDump":
+		if len(a) != 2 {
+			usage()
+		}
+		err = luksDump(a[1])
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -7,19 +7,33 @@
 // Synopsis:
 //     dhclient [OPTIONS...]
 //
+// Description:
+//     Without -daemon, dhclient configures the matching interfaces once and
+//     exits. With -daemon, it keeps running to renew and rebind the DHCPv4
+//     leases, and releases them when it gets SIGINT or SIGTERM.
+//
 // Options:
-//     -timeout:  lease timeout in seconds
-//     -renewals: number of DHCP renewals before exiting
-//     -verbose:  verbose output
+//     -timeout:   lease timeout in seconds
+//     -renewals:  number of DHCP renewals before exiting
+//     -verbose:   verbose output
+//     -daemon:    keep DHCPv4 leases up to date until killed
+//     -lease-dir: save DHCPv4 leases in this directory and request them again on start
+//     -release:   in daemon mode, release DHCPv4 leases on exit
 package main
 
 import (
 	"context"
 	"flag"
 	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"regexp"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/u-root/u-root/pkg/dhclient"
 	"github.com/vishvananda/netlink"
 )
@@ -32,6 +46,9 @@ var (
 	ipv4    = flag.Bool("ipv4", true, "use IPV4")
 	ipv6    = flag.Bool("ipv6", true, "use IPV6")
 	test    = flag.Bool("test", false, "Test mode")
+	daemon  = flag.Bool("daemon", false, "Keep DHCPv4 leases up to date until killed")
+	leases  = flag.String("lease-dir", "", "Save DHCPv4 leases in this directory and request them again on start")
+	release = flag.Bool("release", true, "In daemon mode, release DHCPv4 leases on exit")
 	debug   = func(string, ...interface{}) {}
 )
 
@@ -67,16 +84,20 @@ func main() {
 		log.Fatalf("No interfaces match %s", ifName)
 	}
 
-	configureAll(filteredIfs)
+	if *daemon {
+		runDaemon(filteredIfs)
+	} else {
+		configureAll(filteredIfs, *ipv4, *ipv6)
+	}
 }
 
-func configureAll(ifs []netlink.Link) {
+func configureAll(ifs []netlink.Link, ipv4, ipv6 bool) {
 	packetTimeout := time.Duration(*timeout) * time.Second
 
 	ctx, cancel := context.WithTimeout(context.Background(), packetTimeout*time.Duration(*retry))
 	defer cancel()
 
-	r := dhclient.SendRequests(ctx, ifs, packetTimeout, *retry, *ipv4, *ipv6)
+	r := dhclient.SendRequests(ctx, ifs, packetTimeout, *retry, ipv4, ipv6)
 
 	for {
 		select {
@@ -99,3 +120,50 @@ func configureAll(ifs []netlink.Link) {
 		}
 	}
 }
+
+// runDaemon manages the DHCPv4 leases of ifs until it is signalled to stop.
+// IPv6 is configured once, as without -daemon.
+func runDaemon(ifs []netlink.Link) {
+	if *ipv6 {
+		configureAll(ifs, false, true)
+	}
+	if !*ipv4 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		s := <-sig
+		log.Printf("Got %v, exiting", s)
+		cancel()
+	}()
+
+	var wg sync.WaitGroup
+	for _, iface := range ifs {
+		name := iface.Attrs().Name
+		if _, err := dhclient.IfUp(name); err != nil {
+			log.Printf("Could not bring up interface %s: %v", name, err)
+			continue
+		}
+		m := &dhclient.LeaseManager4{
+			Iface:     iface,
+			Timeout:   time.Duration(*timeout) * time.Second,
+			Retries:   *retry,
+			Release:   *release,
+			Modifiers: []dhcpv4.Modifier{dhcpv4.WithNetboot},
+		}
+		if *leases != "" {
+			m.LeaseFile = filepath.Join(*leases, name+".lease")
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := m.Run(ctx); err != nil {
+				log.Printf("Managing DHCPv4 lease on %s: %v", name, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
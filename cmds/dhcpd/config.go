@@ -0,0 +1,324 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// Config is the server's configuration, as read from a JSON file.
+type Config struct {
+	// Interface is the interface to serve on.
+	Interface string `json:"interface,omitempty"`
+
+	// ServerIP is the server identifier. It defaults to the first IPv4
+	// address of Interface.
+	ServerIP string `json:"server_ip,omitempty"`
+
+	// Subnet is the network served in CIDR notation. It defaults to the
+	// network of ServerIP's address on Interface.
+	Subnet string `json:"subnet,omitempty"`
+
+	// RangeStart and RangeEnd bound the pool of dynamic addresses. Without
+	// them, only Hosts get addresses.
+	RangeStart string `json:"range_start,omitempty"`
+	RangeEnd   string `json:"range_end,omitempty"`
+
+	Routers     []string `json:"routers,omitempty"`
+	Nameservers []string `json:"nameservers,omitempty"`
+	Domain      string   `json:"domain,omitempty"`
+
+	// LeaseTime is a duration such as "12h". It defaults to an hour.
+	LeaseTime string `json:"lease_time,omitempty"`
+
+	// LeaseFile, if set, is where leases are kept across restarts.
+	LeaseFile string `json:"lease_file,omitempty"`
+
+	// NextServer is the server to boot from. It defaults to ServerIP.
+	NextServer string `json:"next_server,omitempty"`
+
+	// BootFile is the boot file for clients not matched by Boot.
+	BootFile string `json:"boot_file,omitempty"`
+
+	// Boot picks boot files by client type. The first matching rule is
+	// used.
+	Boot []BootRule `json:"boot,omitempty"`
+
+	// Hosts are per-MAC reservations.
+	Hosts []Host `json:"hosts,omitempty"`
+}
+
+// BootRule selects a boot file for the clients it matches. Empty fields
+// match anything.
+type BootRule struct {
+	// VendorClass is a prefix of the vendor class identifier (option 60),
+	// e.g. "PXEClient" or "HTTPClient".
+	VendorClass string `json:"vendor_class,omitempty"`
+
+	// UserClass is a user class (option 77) the client sends, e.g. "iPXE".
+	UserClass string `json:"user_class,omitempty"`
+
+	// Arch, if set, is a client system architecture (option 93), e.g. 0
+	// for BIOS or 7 for x64 UEFI.
+	Arch *int `json:"arch,omitempty"`
+
+	NextServer string `json:"next_server,omitempty"`
+	BootFile   string `json:"boot_file"`
+}
+
+// Host is a reservation.
+type Host struct {
+	MAC      string `json:"mac"`
+	IP       string `json:"ip"`
+	Hostname string `json:"hostname,omitempty"`
+
+	// BootFile, if set, overrides the boot rules for this host.
+	BootFile string `json:"boot_file,omitempty"`
+}
+
+// Load reads the JSON configuration in file.
+func Load(file string) (*Config, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&c); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return &c, nil
+}
+
+// settings is a parsed and checked Config.
+type settings struct {
+	serverIP   net.IP
+	subnet     *net.IPNet
+	start, end net.IP
+	routers    []net.IP
+	dns        []net.IP
+	domain     string
+	leaseTime  time.Duration
+	leaseFile  string
+	nextServer net.IP
+	bootFile   string
+	rules      []bootRule
+	hosts      map[string]host
+}
+
+type bootRule struct {
+	vendorClass string
+	userClass   string
+	arch        int // -1 for any
+	nextServer  net.IP
+	bootFile    string
+}
+
+type host struct {
+	ip       net.IP
+	hostname string
+	bootFile string
+}
+
+func parseIP4(what, s string) (net.IP, error) {
+	ip := net.ParseIP(s).To4()
+	if ip == nil {
+		return nil, fmt.Errorf("bad %s %q", what, s)
+	}
+	return ip, nil
+}
+
+func parseIP4s(what string, ss []string) ([]net.IP, error) {
+	var ips []net.IP
+	for _, s := range ss {
+		ip, err := parseIP4(what, s)
+		if err != nil {
+			return nil, err
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+// ifaceAddr returns the first IPv4 address of the interface called name.
+func ifaceAddr(name string) (*net.IPNet, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.To4() != nil {
+			return &net.IPNet{IP: n.IP.To4(), Mask: n.Mask}, nil
+		}
+	}
+	return nil, fmt.Errorf("%s has no IPv4 address", name)
+}
+
+// parse checks c and fills in defaults.
+func (c *Config) parse() (*settings, error) {
+	s := &settings{
+		domain:    c.Domain,
+		leaseTime: time.Hour,
+		leaseFile: c.LeaseFile,
+		bootFile:  c.BootFile,
+		hosts:     make(map[string]host),
+	}
+	var err error
+
+	var addr *net.IPNet
+	if c.Interface != "" && (c.ServerIP == "" || c.Subnet == "") {
+		if addr, err = ifaceAddr(c.Interface); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case c.ServerIP != "":
+		if s.serverIP, err = parseIP4("server IP", c.ServerIP); err != nil {
+			return nil, err
+		}
+	case addr != nil:
+		s.serverIP = addr.IP
+	default:
+		return nil, fmt.Errorf("need an interface or server IP")
+	}
+	switch {
+	case c.Subnet != "":
+		var ip net.IP
+		if ip, s.subnet, err = net.ParseCIDR(c.Subnet); err != nil || ip.To4() == nil {
+			return nil, fmt.Errorf("bad subnet %q", c.Subnet)
+		}
+		s.subnet.IP = s.subnet.IP.To4()
+	case addr != nil:
+		s.subnet = &net.IPNet{IP: addr.IP.Mask(addr.Mask), Mask: addr.Mask}
+	default:
+		return nil, fmt.Errorf("need an interface or subnet")
+	}
+
+	if (c.RangeStart == "") != (c.RangeEnd == "") {
+		return nil, fmt.Errorf("need both range start and end")
+	}
+	if c.RangeStart != "" {
+		if s.start, err = parseIP4("range start", c.RangeStart); err != nil {
+			return nil, err
+		}
+		if s.end, err = parseIP4("range end", c.RangeEnd); err != nil {
+			return nil, err
+		}
+		if !s.subnet.Contains(s.start) || !s.subnet.Contains(s.end) || ip4Int(s.start) > ip4Int(s.end) {
+			return nil, fmt.Errorf("range %s-%s is not in %s", s.start, s.end, s.subnet)
+		}
+	}
+
+	if s.routers, err = parseIP4s("router", c.Routers); err != nil {
+		return nil, err
+	}
+	if s.dns, err = parseIP4s("nameserver", c.Nameservers); err != nil {
+		return nil, err
+	}
+	if c.LeaseTime != "" {
+		if s.leaseTime, err = time.ParseDuration(c.LeaseTime); err != nil || s.leaseTime < time.Minute {
+			return nil, fmt.Errorf("bad lease time %q", c.LeaseTime)
+		}
+	}
+
+	s.nextServer = s.serverIP
+	if c.NextServer != "" {
+		if s.nextServer, err = parseIP4("next server", c.NextServer); err != nil {
+			return nil, err
+		}
+	}
+	for i, r := range c.Boot {
+		b := bootRule{
+			vendorClass: r.VendorClass,
+			userClass:   r.UserClass,
+			arch:        -1,
+			nextServer:  s.nextServer,
+			bootFile:    r.BootFile,
+		}
+		if r.Arch != nil {
+			b.arch = *r.Arch
+		}
+		if r.NextServer != "" {
+			if b.nextServer, err = parseIP4("next server", r.NextServer); err != nil {
+				return nil, err
+			}
+		}
+		if b.bootFile == "" {
+			return nil, fmt.Errorf("boot rule %d has no boot file", i)
+		}
+		s.rules = append(s.rules, b)
+	}
+
+	ips := make(map[string]bool)
+	for _, h := range c.Hosts {
+		mac, err := net.ParseMAC(h.MAC)
+		if err != nil {
+			return nil, fmt.Errorf("host %q: %v", h.MAC, err)
+		}
+		ip, err := parseIP4("host IP", h.IP)
+		if err != nil {
+			return nil, fmt.Errorf("host %s: %v", mac, err)
+		}
+		if !s.subnet.Contains(ip) {
+			return nil, fmt.Errorf("host %s: %s is not in %s", mac, ip, s.subnet)
+		}
+		if _, ok := s.hosts[mac.String()]; ok {
+			return nil, fmt.Errorf("host %s is reserved twice", mac)
+		}
+		if ips[ip.String()] {
+			return nil, fmt.Errorf("host %s: %s is reserved twice", mac, ip)
+		}
+		ips[ip.String()] = true
+		s.hosts[mac.String()] = host{ip: ip, hostname: h.Hostname, bootFile: h.BootFile}
+	}
+	return s, nil
+}
+
+// match reports whether r applies to req.
+func (r *bootRule) match(req *dhcpv4.DHCPv4) bool {
+	if r.vendorClass != "" && !strings.HasPrefix(req.ClassIdentifier(), r.vendorClass) {
+		return false
+	}
+	// iPXE sends its user class as a plain string rather than in the
+	// RFC 3004 format, so look for it either way.
+	if r.userClass != "" && !bytes.Contains(req.GetOneOption(dhcpv4.OptionUserClassInformation), []byte(r.userClass)) {
+		return false
+	}
+	if r.arch < 0 {
+		return true
+	}
+	for _, a := range req.ClientArch() {
+		if int(a) == r.arch {
+			return true
+		}
+	}
+	return false
+}
+
+// boot returns the next server and boot file for req, if any.
+func (s *settings) boot(req *dhcpv4.DHCPv4, h *host) (net.IP, string) {
+	if h != nil && h.bootFile != "" {
+		return s.nextServer, h.bootFile
+	}
+	for _, r := range s.rules {
+		if r.match(req) {
+			return r.nextServer, r.bootFile
+		}
+	}
+	return s.nextServer, s.bootFile
+}
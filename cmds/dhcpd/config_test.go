@@ -0,0 +1,49 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestParseErrors(t *testing.T) {
+	for name, modify := range map[string]func(c *Config){
+		"no server":       func(c *Config) { c.ServerIP = "" },
+		"no subnet":       func(c *Config) { c.Subnet = "" },
+		"v6 subnet":       func(c *Config) { c.Subnet = "2001:db8::/64" },
+		"half range":      func(c *Config) { c.RangeEnd = "" },
+		"range outside":   func(c *Config) { c.RangeEnd = "10.0.1.5" },
+		"range backwards": func(c *Config) { c.RangeStart, c.RangeEnd = c.RangeEnd, c.RangeStart },
+		"bad router":      func(c *Config) { c.Routers = []string{"router"} },
+		"short lease":     func(c *Config) { c.LeaseTime = "10s" },
+		"rule without file": func(c *Config) {
+			c.Boot = []BootRule{{VendorClass: "PXEClient"}}
+		},
+		"host outside": func(c *Config) {
+			c.Hosts = []Host{{MAC: "02:00:00:00:00:01", IP: "10.0.1.1"}}
+		},
+		"host twice": func(c *Config) {
+			c.Hosts = []Host{{MAC: "02:00:00:00:00:01", IP: "10.0.0.2"}, {MAC: "02:00:00:00:00:01", IP: "10.0.0.3"}}
+		},
+		"address twice": func(c *Config) {
+			c.Hosts = []Host{{MAC: "02:00:00:00:00:01", IP: "10.0.0.2"}, {MAC: "02:00:00:00:00:02", IP: "10.0.0.2"}}
+		},
+	} {
+		c := testConfig
+		modify(&c)
+		if _, err := c.parse(); err == nil {
+			t.Errorf("%s: parse() succeeded, want error", name)
+		}
+	}
+
+	c := Config{ServerIP: "10.0.0.1", Subnet: "10.0.0.1/24"}
+	s, err := c.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.subnet.String() != "10.0.0.0/24" || !s.nextServer.Equal(s.serverIP) || s.start != nil {
+		t.Errorf("parse(%+v) = %+v, want static-only 10.0.0.0/24 booting from the server", c, s)
+	}
+}
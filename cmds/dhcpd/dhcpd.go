@@ -0,0 +1,152 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// dhcpd is a DHCPv4 server for network booting.
+//
+// Synopsis:
+//     dhcpd [OPTIONS...] [IFACE]
+//
+// Description:
+//     dhcpd gives out addresses from a pool and per-MAC reservations on
+//     IFACE, along with the next server and boot file to network boot
+//     from. The settings come from the JSON file given with -config, e.g.
+//
+//     {
+//         "interface": "eth0",
+//         "range_start": "10.0.0.100", "range_end": "10.0.0.199",
+//         "routers": ["10.0.0.1"], "nameservers": ["10.0.0.1"],
+//         "lease_time": "12h", "lease_file": "/var/lib/dhcpd/leases",
+//         "boot_file": "pxelinux.0",
+//         "boot": [
+//             {"user_class": "iPXE", "boot_file": "http://10.0.0.1/boot.ipxe"},
+//             {"vendor_class": "HTTPClient", "boot_file": "http://10.0.0.1/bootx64.efi"},
+//             {"vendor_class": "PXEClient", "arch": 7, "boot_file": "ipxe.efi"}
+//         ],
+//         "hosts": [{"mac": "52:54:00:12:34:56", "ip": "10.0.0.10", "hostname": "node1"}]
+//     }
+//
+//     and the flags below, which override the file. The server address
+//     and subnet default to the first IPv4 address of IFACE, and the next
+//     server to the server address. The first boot rule matching a
+//     client's vendor class, user class and architecture picks its boot
+//     file; clients matching none get boot_file.
+//
+// Options:
+//     -config:      JSON configuration file
+//     -range:       pool of addresses, as START-END
+//     -router:      default router
+//     -dns:         comma-separated DNS servers
+//     -domain:      domain name
+//     -lease-time:  lease duration
+//     -lease-file:  file to keep leases in across restarts
+//     -next-server: server to boot from
+//     -bootfile:    boot file name or URL
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	config     = flag.String("config", "", "JSON configuration file")
+	pool       = flag.String("range", "", "Pool of addresses, as START-END")
+	router     = flag.String("router", "", "Default router")
+	dns        = flag.String("dns", "", "Comma-separated DNS servers")
+	domain     = flag.String("domain", "", "Domain name")
+	leaseTime  = flag.String("lease-time", "", "Lease duration; default 1h")
+	leaseFile  = flag.String("lease-file", "", "File to keep leases in across restarts")
+	nextServer = flag.String("next-server", "", "Server to boot from")
+	bootFile   = flag.String("bootfile", "", "Boot file name or URL")
+)
+
+// applyFlags overrides c with the flags that were given.
+func applyFlags(c *Config) {
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "range":
+			r := strings.SplitN(*pool, "-", 2)
+			c.RangeStart = r[0]
+			if len(r) == 2 {
+				c.RangeEnd = r[1]
+			}
+		case "router":
+			c.Routers = []string{*router}
+		case "dns":
+			c.Nameservers = strings.Split(*dns, ",")
+		case "domain":
+			c.Domain = *domain
+		case "lease-time":
+			c.LeaseTime = *leaseTime
+		case "lease-file":
+			c.LeaseFile = *leaseFile
+		case "next-server":
+			c.NextServer = *nextServer
+		case "bootfile":
+			c.BootFile = *bootFile
+		}
+	})
+}
+
+// listen opens the server port on iface, with broadcasts allowed.
+func listen(iface string) (net.PacketConn, error) {
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var err error
+			cerr := c.Control(func(fd uintptr) {
+				if err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+					return
+				}
+				if err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_BROADCAST, 1); err != nil {
+					return
+				}
+				if iface != "" {
+					err = dhcpv4.BindToInterface(int(fd), iface)
+				}
+			})
+			if cerr != nil {
+				return cerr
+			}
+			return err
+		},
+	}
+	return lc.ListenPacket(context.Background(), "udp4", ":67")
+}
+
+func main() {
+	flag.Parse()
+	c := &Config{}
+	if *config != "" {
+		var err error
+		if c, err = Load(*config); err != nil {
+			log.Fatal(err)
+		}
+	}
+	switch flag.NArg() {
+	case 0:
+	case 1:
+		c.Interface = flag.Arg(0)
+	default:
+		log.Fatal("usage: dhcpd [OPTIONS...] [IFACE]")
+	}
+	applyFlags(c)
+
+	srv, err := newServer(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	conn, err := listen(c.Interface)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Serving %s as %s", srv.s.subnet, srv.s.serverIP)
+	log.Fatal(srv.serve(conn))
+}
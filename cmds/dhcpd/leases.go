@@ -0,0 +1,230 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// offerHold is how long an offered address is kept for the client it was
+// offered to.
+const offerHold = time.Minute
+
+// lease is an address given to a client. Declined addresses are leases
+// without a MAC.
+type lease struct {
+	MAC      string    `json:"mac,omitempty"`
+	IP       net.IP    `json:"ip"`
+	Expiry   time.Time `json:"expiry"`
+	Hostname string    `json:"hostname,omitempty"`
+}
+
+// leases keeps track of the addresses given out. Addresses of expired
+// leases are given to the same client again if it comes back before they
+// are needed for others.
+type leases struct {
+	s   *settings
+	now func() time.Time
+
+	mu   sync.Mutex
+	byIP map[string]*lease
+}
+
+func ip4Int(ip net.IP) uint32 {
+	return binary.BigEndian.Uint32(ip.To4())
+}
+
+func intIP4(i uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, i)
+	return ip
+}
+
+// newLeases returns the leases for s, reading them from s.leaseFile if it
+// exists.
+func newLeases(s *settings) (*leases, error) {
+	l := &leases{s: s, now: time.Now, byIP: make(map[string]*lease)}
+	if s.leaseFile == "" {
+		return l, nil
+	}
+	b, err := ioutil.ReadFile(s.leaseFile)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved []*lease
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return nil, err
+	}
+	for _, le := range saved {
+		if ip := le.IP.To4(); ip != nil && s.subnet.Contains(ip) {
+			le.IP = ip
+			l.byIP[ip.String()] = le
+		}
+	}
+	return l, nil
+}
+
+// save writes the leases to the lease file. It is written to a temporary
+// file first, so that a crash does not leave a truncated file behind.
+func (l *leases) save() error {
+	if l.s.leaseFile == "" {
+		return nil
+	}
+	var all []*lease
+	for _, le := range l.byIP {
+		all = append(all, le)
+	}
+	b, err := json.MarshalIndent(all, "", "\t")
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(l.s.leaseFile), filepath.Base(l.s.leaseFile))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), l.s.leaseFile)
+}
+
+func (l *leases) inPool(ip net.IP) bool {
+	if l.s.start == nil {
+		return false
+	}
+	i := ip4Int(ip)
+	return i >= ip4Int(l.s.start) && i <= ip4Int(l.s.end)
+}
+
+// allowed reports whether the client with mac may use ip.
+func (l *leases) allowed(mac string, ip net.IP) bool {
+	if ip.To4() == nil {
+		return false
+	}
+	if h, ok := l.s.hosts[mac]; ok {
+		return h.ip.Equal(ip)
+	}
+	if !l.inPool(ip) || ip.Equal(l.s.serverIP) {
+		return false
+	}
+	for _, h := range l.s.hosts {
+		if h.ip.Equal(ip) {
+			return false
+		}
+	}
+	le := l.byIP[ip.To4().String()]
+	return le == nil || le.MAC == mac || !l.now().Before(le.Expiry)
+}
+
+// offer picks an address for mac and holds it for a while. The client's
+// reservation comes first, then its previous address, then the address it
+// asks for. It returns nil if there is no address left.
+func (l *leases) offer(mac string, requested net.IP) net.IP {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ip := l.pick(mac, requested)
+	if ip == nil {
+		return nil
+	}
+	le := l.byIP[ip.String()]
+	if le == nil || le.MAC != mac {
+		le = &lease{MAC: mac, IP: ip}
+		l.byIP[ip.String()] = le
+	}
+	if hold := l.now().Add(offerHold); le.Expiry.Before(hold) {
+		le.Expiry = hold
+	}
+	return ip
+}
+
+func (l *leases) pick(mac string, requested net.IP) net.IP {
+	if h, ok := l.s.hosts[mac]; ok {
+		return h.ip
+	}
+	for _, le := range l.byIP {
+		if le.MAC == mac && l.allowed(mac, le.IP) {
+			return le.IP
+		}
+	}
+	if requested != nil && l.allowed(mac, requested) {
+		return requested.To4()
+	}
+	if l.s.start == nil {
+		return nil
+	}
+	// Prefer addresses that were never given out, so that clients
+	// coming back after their lease expired get their old address.
+	var expired net.IP
+	for i := ip4Int(l.s.start); i <= ip4Int(l.s.end) && i != 0; i++ {
+		ip := intIP4(i)
+		if !l.allowed(mac, ip) {
+			continue
+		}
+		if l.byIP[ip.String()] == nil {
+			return ip
+		}
+		if expired == nil {
+			expired = ip
+		}
+	}
+	return expired
+}
+
+// commit gives ip to mac for the lease time. It returns nil if mac may not
+// use ip.
+func (l *leases) commit(mac string, ip net.IP, hostname string) (*lease, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.allowed(mac, ip) {
+		return nil, nil
+	}
+	le := &lease{MAC: mac, IP: ip.To4(), Expiry: l.now().Add(l.s.leaseTime), Hostname: hostname}
+	l.byIP[le.IP.String()] = le
+	return le, l.save()
+}
+
+// release ends the lease of mac on ip, if there is one.
+func (l *leases) release(mac string, ip net.IP) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	le := l.byIP[ip.String()]
+	if le == nil || le.MAC != mac {
+		return nil
+	}
+	le.Expiry = l.now()
+	return l.save()
+}
+
+// decline marks ip as used by someone else for the lease time, after the
+// client with mac found it in use.
+func (l *leases) decline(mac string, ip net.IP) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	le := l.byIP[ip.String()]
+	if le == nil || le.MAC != mac {
+		return nil
+	}
+	l.byIP[ip.String()] = &lease{IP: le.IP, Expiry: l.now().Add(l.s.leaseTime)}
+	return l.save()
+}
@@ -0,0 +1,129 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	macA = "02:00:00:00:00:0a"
+	macB = "02:00:00:00:00:0b"
+	macC = "02:00:00:00:00:0c"
+)
+
+func testLeases(t *testing.T, file string) (*leases, *time.Time) {
+	c := testConfig
+	c.LeaseFile = file
+	s, err := c.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := newLeases(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func mustCommit(t *testing.T, l *leases, mac string, ip net.IP) {
+	le, err := l.commit(mac, ip, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if le == nil {
+		t.Fatalf("commit(%s, %s) refused", mac, ip)
+	}
+}
+
+func TestLeases(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dhcpd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "leases")
+
+	l, now := testLeases(t, file)
+	a := l.offer(macA, nil)
+	if a.String() != "10.0.0.100" {
+		t.Fatalf("offer(A) = %s, want 10.0.0.100", a)
+	}
+	// The offer is held for A.
+	if b := l.offer(macB, a); b.String() != "10.0.0.101" {
+		t.Errorf("offer(B, %s) = %s, want 10.0.0.101", a, b)
+	}
+	if le, _ := l.commit(macB, a, ""); le != nil {
+		t.Errorf("commit(B, %s) = %+v, want refusal", a, le)
+	}
+	mustCommit(t, l, macA, a)
+	if got := l.offer(macA, net.IPv4(10, 0, 0, 101)); !got.Equal(a) {
+		t.Errorf("offer(A) again = %s, want %s", got, a)
+	}
+
+	// Reservations and addresses outside of the pool are not given out.
+	for _, ip := range []net.IP{net.IPv4(10, 0, 0, 10), net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 102), net.IPv4(10, 1, 0, 100)} {
+		if le, _ := l.commit(macC, ip, ""); le != nil {
+			t.Errorf("commit(C, %s) = %+v, want refusal", ip, le)
+		}
+	}
+	if le, _ := l.commit("02:00:00:00:00:10", net.IPv4(10, 0, 0, 10), ""); le == nil {
+		t.Errorf("commit(reservation) refused")
+	}
+
+	// Leases survive a restart.
+	l2, now2 := testLeases(t, file)
+	*now2 = now.Add(time.Minute)
+	if le, _ := l2.commit(macB, a, ""); le != nil {
+		t.Errorf("commit(B, %s) after restart = %+v, want refusal", a, le)
+	}
+	if got := l2.offer(macA, nil); !got.Equal(a) {
+		t.Errorf("offer(A) after restart = %s, want %s", got, a)
+	}
+
+	// B's offer has run out, and A's address is only given to others
+	// once it is released.
+	*now = now.Add(2 * offerHold)
+	if got := l.offer(macC, nil); got.String() != "10.0.0.101" {
+		t.Errorf("offer(C) = %s, want 10.0.0.101", got)
+	}
+	if got := l.offer(macB, nil); got != nil {
+		t.Errorf("offer(B) from an empty pool = %s, want nil", got)
+	}
+	if err := l.release(macA, a); err != nil {
+		t.Fatal(err)
+	}
+	if got := l.offer(macB, nil); !got.Equal(a) {
+		t.Errorf("offer(B) after release = %s, want %s", got, a)
+	}
+}
+
+func TestDecline(t *testing.T) {
+	l, now := testLeases(t, "")
+	a := l.offer(macA, nil)
+	if err := l.decline(macB, a); err != nil {
+		t.Fatal(err)
+	}
+	if got := l.offer(macA, nil); !got.Equal(a) {
+		t.Errorf("offer(A) after B's decline = %s, want %s", got, a)
+	}
+	if err := l.decline(macA, a); err != nil {
+		t.Fatal(err)
+	}
+	if got := l.offer(macA, nil); got.Equal(a) {
+		t.Errorf("offer(A) after decline = %s, want another address", got)
+	}
+	*now = now.Add(time.Hour)
+	if got := l.offer(macC, a); !got.Equal(a) {
+		t.Errorf("offer(C) after the decline ran out = %s, want %s", got, a)
+	}
+}
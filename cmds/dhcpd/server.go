@@ -0,0 +1,204 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// DHCP ports, RFC 2131, Section 4.1.
+const (
+	serverPort = 67
+	clientPort = 68
+)
+
+// server answers DHCPv4 requests.
+type server struct {
+	s      *settings
+	leases *leases
+
+	// broadcast replaces the limited broadcast address in tests.
+	broadcast *net.UDPAddr
+}
+
+func newServer(c *Config) (*server, error) {
+	s, err := c.parse()
+	if err != nil {
+		return nil, err
+	}
+	l, err := newLeases(s)
+	if err != nil {
+		return nil, err
+	}
+	return &server{
+		s:         s,
+		leases:    l,
+		broadcast: &net.UDPAddr{IP: net.IPv4bcast, Port: clientPort},
+	}, nil
+}
+
+// serve answers requests on conn until it is closed.
+func (srv *server) serve(conn net.PacketConn) error {
+	b := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(b)
+		if err != nil {
+			return err
+		}
+		req, err := dhcpv4.FromBytes(b[:n])
+		if err != nil {
+			log.Printf("%v: %v", peer, err)
+			continue
+		}
+		resp := srv.handle(req)
+		if resp == nil {
+			continue
+		}
+		if _, err := conn.WriteTo(resp.ToBytes(), srv.replyAddr(req, resp)); err != nil {
+			log.Printf("Answering %s: %v", req.ClientHWAddr, err)
+		}
+	}
+}
+
+// replyAddr returns where to send resp, as described in RFC 2131, Section
+// 4.1. Replies that would be unicast to a client without an address are
+// broadcast instead, which saves adding ARP entries.
+func (srv *server) replyAddr(req, resp *dhcpv4.DHCPv4) net.Addr {
+	switch {
+	case req.GatewayIPAddr != nil && !req.GatewayIPAddr.IsUnspecified():
+		return &net.UDPAddr{IP: req.GatewayIPAddr, Port: serverPort}
+	case resp.MessageType() != dhcpv4.MessageTypeNak && req.ClientIPAddr != nil && !req.ClientIPAddr.IsUnspecified():
+		return &net.UDPAddr{IP: req.ClientIPAddr, Port: clientPort}
+	default:
+		return srv.broadcast
+	}
+}
+
+// handle returns the reply to req, or nil if there is none.
+func (srv *server) handle(req *dhcpv4.DHCPv4) *dhcpv4.DHCPv4 {
+	if req.OpCode != dhcpv4.OpcodeBootRequest {
+		return nil
+	}
+	mac := req.ClientHWAddr.String()
+	h, ok := srv.s.hosts[mac]
+	if !ok {
+		h = host{}
+	}
+
+	switch req.MessageType() {
+	case dhcpv4.MessageTypeDiscover:
+		ip := srv.leases.offer(mac, req.RequestedIPAddress())
+		if ip == nil {
+			log.Printf("No address left for %s", mac)
+			return nil
+		}
+		log.Printf("Offering %s to %s", ip, mac)
+		return srv.reply(req, dhcpv4.MessageTypeOffer, ip, &h)
+
+	case dhcpv4.MessageTypeRequest:
+		// A client that picked another server's offer tells us with
+		// that server's identifier.
+		if id := req.ServerIdentifier(); id != nil && !id.Equal(srv.s.serverIP) {
+			return nil
+		}
+		ip := req.RequestedIPAddress()
+		if ip == nil {
+			ip = req.ClientIPAddr
+		}
+		hostname := h.hostname
+		if hostname == "" {
+			hostname = req.HostName()
+		}
+		le, err := srv.leases.commit(mac, ip, hostname)
+		if err != nil {
+			log.Printf("Saving leases: %v", err)
+		}
+		if le == nil {
+			log.Printf("Refusing %s to %s", ip, mac)
+			return srv.reply(req, dhcpv4.MessageTypeNak, nil, nil)
+		}
+		log.Printf("Leasing %s to %s until %v", ip, mac, le.Expiry.Format(time.RFC3339))
+		return srv.reply(req, dhcpv4.MessageTypeAck, le.IP, &h)
+
+	case dhcpv4.MessageTypeRelease:
+		log.Printf("%s released %s", mac, req.ClientIPAddr)
+		if err := srv.leases.release(mac, req.ClientIPAddr); err != nil {
+			log.Printf("Saving leases: %v", err)
+		}
+
+	case dhcpv4.MessageTypeDecline:
+		log.Printf("%s declined %s", mac, req.RequestedIPAddress())
+		if ip := req.RequestedIPAddress(); ip != nil {
+			if err := srv.leases.decline(mac, ip); err != nil {
+				log.Printf("Saving leases: %v", err)
+			}
+		}
+
+	case dhcpv4.MessageTypeInform:
+		return srv.reply(req, dhcpv4.MessageTypeAck, nil, &h)
+	}
+	return nil
+}
+
+// reply builds a reply of type typ giving ip to the client. A nil ip
+// leaves out the address and lease time, and a nil h leaves out the
+// configuration.
+func (srv *server) reply(req *dhcpv4.DHCPv4, typ dhcpv4.MessageType, ip net.IP, h *host) *dhcpv4.DHCPv4 {
+	s := srv.s
+	mods := []dhcpv4.Modifier{
+		dhcpv4.WithMessageType(typ),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(s.serverIP)),
+	}
+	if ip != nil {
+		mods = append(mods,
+			dhcpv4.WithYourIP(ip),
+			dhcpv4.WithOption(dhcpv4.OptIPAddressLeaseTime(s.leaseTime)))
+	}
+	if h != nil {
+		mods = append(mods, dhcpv4.WithNetmask(s.subnet.Mask))
+		if len(s.routers) > 0 {
+			mods = append(mods, dhcpv4.WithOption(dhcpv4.OptRouter(s.routers...)))
+		}
+		if len(s.dns) > 0 {
+			mods = append(mods, dhcpv4.WithOption(dhcpv4.OptDNS(s.dns...)))
+		}
+		if s.domain != "" {
+			mods = append(mods, dhcpv4.WithOption(dhcpv4.OptDomainName(s.domain)))
+		}
+		if h.hostname != "" {
+			mods = append(mods, dhcpv4.WithOption(dhcpv4.OptHostName(h.hostname)))
+		}
+		if next, file := s.boot(req, h); file != "" {
+			mods = append(mods, withBoot(next, file))
+			// UEFI HTTP boot clients ignore offers that do not say
+			// they are for them.
+			if strings.HasPrefix(req.ClassIdentifier(), "HTTPClient") {
+				mods = append(mods, dhcpv4.WithOption(dhcpv4.OptClassIdentifier("HTTPClient")))
+			}
+		}
+	}
+	resp, err := dhcpv4.NewReplyFromRequest(req, mods...)
+	if err != nil {
+		log.Printf("Answering %s: %v", req.ClientHWAddr, err)
+		return nil
+	}
+	return resp
+}
+
+// withBoot sets the next server and boot file. The next server goes in
+// the server host name as well, since some clients only look there.
+func withBoot(next net.IP, file string) dhcpv4.Modifier {
+	return func(d *dhcpv4.DHCPv4) {
+		d.ServerIPAddr = next
+		d.ServerHostName = next.String()
+		d.BootFileName = file
+		d.UpdateOption(dhcpv4.OptBootFileName(file))
+	}
+}
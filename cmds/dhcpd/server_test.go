@@ -0,0 +1,264 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/u-root/u-root/pkg/dhclient"
+	"github.com/vishvananda/netlink"
+)
+
+var testConfig = Config{
+	ServerIP:    "10.0.0.1",
+	Subnet:      "10.0.0.0/24",
+	RangeStart:  "10.0.0.100",
+	RangeEnd:    "10.0.0.101",
+	Routers:     []string{"10.0.0.1"},
+	Nameservers: []string{"10.0.0.53"},
+	Domain:      "lab.example.com",
+	LeaseTime:   "10m",
+	BootFile:    "pxelinux.0",
+	Boot: []BootRule{
+		{UserClass: "iPXE", BootFile: "http://10.0.0.1/boot.ipxe"},
+		{VendorClass: "HTTPClient", BootFile: "http://10.0.0.1/bootx64.efi"},
+		{VendorClass: "PXEClient", Arch: intp(7), NextServer: "10.0.0.2", BootFile: "ipxe.efi"},
+	},
+	Hosts: []Host{
+		{MAC: "02:00:00:00:00:10", IP: "10.0.0.10", Hostname: "node10", BootFile: "node10.efi"},
+	},
+}
+
+func intp(i int) *int {
+	return &i
+}
+
+// startServer runs a server on a loopback socket and returns a client
+// talking to it, and a function to stop both.
+func startServer(t *testing.T, c *Config, mac string) (*nclient4.Client, func()) {
+	srv, err := newServer(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sconn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cconn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.broadcast = cconn.LocalAddr().(*net.UDPAddr)
+	go srv.serve(sconn)
+
+	hwaddr, err := net.ParseMAC(mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := nclient4.NewWithConn(cconn, hwaddr,
+		nclient4.WithTimeout(time.Second),
+		nclient4.WithRetry(2),
+		nclient4.WithServerAddr(sconn.LocalAddr().(*net.UDPAddr)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, func() {
+		client.Close()
+		sconn.Close()
+	}
+}
+
+func TestRequest(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		mac        string
+		mods       []dhcpv4.Modifier
+		ip         string
+		hostname   string
+		nextServer string
+		bootFile   string
+		class      string
+	}{
+		{
+			name:       "bios",
+			mac:        "02:00:00:00:00:01",
+			mods:       []dhcpv4.Modifier{dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient:Arch:00000:UNDI:002001")), dhcpv4.WithOption(dhcpv4.OptClientArch(iana.INTEL_X86PC))},
+			ip:         "10.0.0.100",
+			nextServer: "10.0.0.1",
+			bootFile:   "pxelinux.0",
+		},
+		{
+			name:       "uefi",
+			mac:        "02:00:00:00:00:01",
+			mods:       []dhcpv4.Modifier{dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient:Arch:00007:UNDI:003016")), dhcpv4.WithOption(dhcpv4.OptClientArch(iana.EFI_BC))},
+			ip:         "10.0.0.100",
+			nextServer: "10.0.0.2",
+			bootFile:   "ipxe.efi",
+		},
+		{
+			name:       "ipxe",
+			mac:        "02:00:00:00:00:01",
+			mods:       []dhcpv4.Modifier{dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient:Arch:00007:UNDI:003016")), dhcpv4.WithOption(dhcpv4.OptUserClass("iPXE"))},
+			ip:         "10.0.0.100",
+			nextServer: "10.0.0.1",
+			bootFile:   "http://10.0.0.1/boot.ipxe",
+		},
+		{
+			name:       "http",
+			mac:        "02:00:00:00:00:01",
+			mods:       []dhcpv4.Modifier{dhcpv4.WithOption(dhcpv4.OptClassIdentifier("HTTPClient:Arch:00016:UNDI:003016"))},
+			ip:         "10.0.0.100",
+			nextServer: "10.0.0.1",
+			bootFile:   "http://10.0.0.1/bootx64.efi",
+			class:      "HTTPClient",
+		},
+		{
+			name:       "reserved",
+			mac:        "02:00:00:00:00:10",
+			ip:         "10.0.0.10",
+			hostname:   "node10",
+			nextServer: "10.0.0.1",
+			bootFile:   "node10.efi",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testConfig
+			client, stop := startServer(t, &c, tt.mac)
+			defer stop()
+
+			offer, ack, err := client.Request(context.Background(), tt.mods...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if offer.MessageType() != dhcpv4.MessageTypeOffer || ack.MessageType() != dhcpv4.MessageTypeAck {
+				t.Fatalf("got %s and %s, want OFFER and ACK", offer.MessageType(), ack.MessageType())
+			}
+			if got := ack.YourIPAddr.String(); got != tt.ip {
+				t.Errorf("address = %s, want %s", got, tt.ip)
+			}
+			if got := ack.HostName(); got != tt.hostname {
+				t.Errorf("host name = %q, want %q", got, tt.hostname)
+			}
+			if got := ack.ServerIPAddr.String(); got != tt.nextServer {
+				t.Errorf("next server = %s, want %s", got, tt.nextServer)
+			}
+			if ack.BootFileName != tt.bootFile || ack.BootFileNameOption() != tt.bootFile {
+				t.Errorf("boot file = %q and option %q, want %q", ack.BootFileName, ack.BootFileNameOption(), tt.bootFile)
+			}
+			if got := ack.ClassIdentifier(); got != tt.class {
+				t.Errorf("class identifier = %q, want %q", got, tt.class)
+			}
+			if got, want := ack.IPAddressLeaseTime(0), 10*time.Minute; got != want {
+				t.Errorf("lease time = %v, want %v", got, want)
+			}
+			if got := ack.DomainName(); got != "lab.example.com" {
+				t.Errorf("domain = %q, want lab.example.com", got)
+			}
+
+			// The lease is what pkg/dhclient configures and boots from.
+			p := dhclient.NewPacket4(&netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "test0"}}, ack)
+			if got, want := p.Lease().String(), tt.ip+"/24"; got != want {
+				t.Errorf("dhclient lease = %s, want %s", got, want)
+			}
+			u, err := p.Boot()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.Scheme == "tftp" && (u.Host != tt.nextServer || u.Path != tt.bootFile) {
+				t.Errorf("dhclient boot URL = %s, want %s on %s", u, tt.bootFile, tt.nextServer)
+			}
+		})
+	}
+}
+
+// getLease gets a lease for mac from srv, or returns the reply that was
+// not an ACK.
+func getLease(t *testing.T, srv *server, mac net.HardwareAddr) *dhcpv4.DHCPv4 {
+	discover, err := dhcpv4.NewDiscovery(mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offer := srv.handle(discover)
+	if offer == nil {
+		return nil
+	}
+	req, err := dhcpv4.NewRequestFromOffer(offer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv.handle(req)
+}
+
+func TestPoolExhausted(t *testing.T) {
+	c := testConfig
+	srv, err := newServer(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []string{"10.0.0.100", "10.0.0.101"} {
+		mac := net.HardwareAddr{2, 0, 0, 0, 1, byte(i)}
+		ack := getLease(t, srv, mac)
+		if ack == nil || ack.MessageType() != dhcpv4.MessageTypeAck || ack.YourIPAddr.String() != want {
+			t.Errorf("lease for %s = %v, want ACK for %s", mac, ack, want)
+		}
+	}
+	if resp := getLease(t, srv, net.HardwareAddr{2, 0, 0, 0, 1, 2}); resp != nil {
+		t.Errorf("lease from an empty pool = %v, want no answer", resp)
+	}
+
+	// Asking for an address that belongs to someone else is refused.
+	req, err := dhcpv4.New(
+		dhcpv4.WithHwAddr(net.HardwareAddr{2, 0, 0, 0, 0, 1}),
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(net.IPv4(10, 0, 0, 100))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := srv.handle(req); resp == nil || resp.MessageType() != dhcpv4.MessageTypeNak {
+		t.Errorf("REQUEST for a leased address = %v, want NAK", resp)
+	}
+
+	// A request for another server is ignored.
+	req.UpdateOption(dhcpv4.OptServerIdentifier(net.IPv4(10, 0, 0, 2)))
+	if resp := srv.handle(req); resp != nil {
+		t.Errorf("REQUEST for another server = %v, want no answer", resp)
+	}
+}
+
+func TestReplyAddr(t *testing.T) {
+	c := testConfig
+	srv, err := newServer(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ack, _ := dhcpv4.New(dhcpv4.WithMessageType(dhcpv4.MessageTypeAck))
+	nak, _ := dhcpv4.New(dhcpv4.WithMessageType(dhcpv4.MessageTypeNak))
+	for _, tt := range []struct {
+		name string
+		req  dhcpv4.Modifier
+		resp *dhcpv4.DHCPv4
+		want string
+	}{
+		{"relayed", dhcpv4.WithRelay(net.IPv4(10, 1, 0, 1)), ack, "10.1.0.1:67"},
+		{"renewing", dhcpv4.WithClientIP(net.IPv4(10, 0, 0, 100)), ack, "10.0.0.100:68"},
+		{"nak", dhcpv4.WithClientIP(net.IPv4(10, 0, 0, 100)), nak, "255.255.255.255:68"},
+		{"new", dhcpv4.WithBroadcast(false), ack, "255.255.255.255:68"},
+	} {
+		req, err := dhcpv4.New(tt.req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := srv.replyAddr(req, tt.resp).String(); got != tt.want {
+			t.Errorf("%s: replyAddr() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Manage device-mapper devices.
+//
+// Synopsis:
+//     dmsetup [-readonly] [-uuid UUID] [-table TABLE] create NAME
+//     dmsetup [-readonly] [-table TABLE] load NAME
+//     dmsetup remove|suspend|resume|clear NAME
+//     dmsetup info|status|table [NAME]
+//     dmsetup ls
+//     dmsetup version
+//
+// Description:
+//     create makes a new device /dev/mapper/NAME with the given table, which
+//     is read from stdin if -table is not given. Each line of a table is
+//     "start length type params...", with start and length in 512-byte
+//     sectors. load replaces the inactive table of an existing device,
+//     which takes effect on resume.
+//
+// Options:
+//     -readonly: create or load a read-only table
+//     -table:    the table, instead of reading it from stdin
+//     -uuid:     the device UUID
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/dm"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	readOnly = flag.Bool("readonly", false, "Create or load a read-only table")
+	table    = flag.String("table", "", "The table, instead of reading it from stdin")
+	uuid     = flag.String("uuid", "", "The device UUID")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage:\n")
+	fmt.Fprintf(os.Stderr, "  dmsetup [options] create|load NAME\n")
+	fmt.Fprintf(os.Stderr, "  dmsetup remove|suspend|resume|clear NAME\n")
+	fmt.Fprintf(os.Stderr, "  dmsetup info|status|table [NAME]\n")
+	fmt.Fprintf(os.Stderr, "  dmsetup ls|version\n")
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
+func readTable() ([]dm.Target, error) {
+	var r io.Reader = os.Stdin
+	if *table != "" {
+		r = strings.NewReader(*table)
+	}
+	t, err := dm.ParseTable(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(t) == 0 {
+		return nil, fmt.Errorf("empty table")
+	}
+	return t, nil
+}
+
+// names returns the names in args, or all devices if there are none.
+func names(args []string) ([]string, error) {
+	if len(args) > 0 {
+		return args, nil
+	}
+	l, err := dm.List()
+	if err != nil {
+		return nil, err
+	}
+	var n []string
+	for name := range l {
+		n = append(n, name)
+	}
+	sort.Strings(n)
+	return n, nil
+}
+
+func info(name string) error {
+	i, err := dm.DeviceInfo(name)
+	if err != nil {
+		return err
+	}
+	state := "ACTIVE"
+	if i.Suspended {
+		state = "SUSPENDED"
+	}
+	access := "read-write"
+	if i.ReadOnly {
+		access = "read-only"
+	}
+	fmt.Printf("Name:              %s\n", i.Name)
+	fmt.Printf("State:             %s\n", state)
+	fmt.Printf("Access:            %s\n", access)
+	fmt.Printf("Tables present:    %s\n", tables(i))
+	fmt.Printf("Open count:        %d\n", i.OpenCount)
+	fmt.Printf("Event number:      %d\n", i.EventNr)
+	fmt.Printf("Major, minor:      %d, %d\n", unix.Major(i.Dev), unix.Minor(i.Dev))
+	fmt.Printf("Number of targets: %d\n", i.TargetCount)
+	if i.UUID != "" {
+		fmt.Printf("UUID: %s\n", i.UUID)
+	}
+	fmt.Println()
+	return nil
+}
+
+func tables(i *dm.Info) string {
+	var t []string
+	if i.ActiveTable {
+		t = append(t, "LIVE")
+	}
+	if i.InactiveTable {
+		t = append(t, "INACTIVE")
+	}
+	if len(t) == 0 {
+		return "None"
+	}
+	return strings.Join(t, " & ")
+}
+
+func printTargets(args []string, get func(string) ([]dm.Target, error)) error {
+	n, err := names(args)
+	if err != nil {
+		return err
+	}
+	for _, name := range n {
+		t, err := get(name)
+		if err != nil {
+			return err
+		}
+		for _, tg := range t {
+			if len(args) == 0 {
+				fmt.Printf("%s: ", name)
+			}
+			fmt.Println(tg)
+		}
+	}
+	return nil
+}
+
+func dmsetup(cmd string, args []string) error {
+	one := func() (string, error) {
+		if len(args) != 1 {
+			return "", fmt.Errorf("%s: need exactly one device name", cmd)
+		}
+		return args[0], nil
+	}
+	switch cmd {
+	case "create":
+		name, err := one()
+		if err != nil {
+			return err
+		}
+		t, err := readTable()
+		if err != nil {
+			return err
+		}
+		_, err = dm.Create(name, *uuid, *readOnly, t)
+		return err
+	case "load", "reload":
+		name, err := one()
+		if err != nil {
+			return err
+		}
+		t, err := readTable()
+		if err != nil {
+			return err
+		}
+		return dm.LoadTable(name, *readOnly, t)
+	case "remove":
+		name, err := one()
+		if err != nil {
+			return err
+		}
+		return dm.Remove(name)
+	case "suspend":
+		name, err := one()
+		if err != nil {
+			return err
+		}
+		return dm.Suspend(name)
+	case "resume":
+		name, err := one()
+		if err != nil {
+			return err
+		}
+		return dm.Resume(name)
+	case "clear":
+		name, err := one()
+		if err != nil {
+			return err
+		}
+		return dm.ClearTable(name)
+	case "info":
+		n, err := names(args)
+		if err != nil {
+			return err
+		}
+		for _, name := range n {
+			if err := info(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "status":
+		return printTargets(args, dm.Status)
+	case "table":
+		return printTargets(args, dm.Table)
+	case "ls":
+		l, err := dm.List()
+		if err != nil {
+			return err
+		}
+		if len(l) == 0 {
+			fmt.Println("No devices found")
+		}
+		var n []string
+		for name := range l {
+			n = append(n, name)
+		}
+		sort.Strings(n)
+		for _, name := range n {
+			fmt.Printf("%s\t(%d:%d)\n", name, unix.Major(l[name]), unix.Minor(l[name]))
+		}
+		return nil
+	case "version":
+		v, err := dm.Version()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Driver version:    %d.%d.%d\n", v[0], v[1], v[2])
+		return nil
+	default:
+		usage()
+	}
+	return nil
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	a := flag.Args()
+	if len(a) == 0 {
+		usage()
+	}
+	if err := dmsetup(a[0], a[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/u-root/u-root/cmds/elvish/eval/vals"
 	"github.com/u-root/u-root/cmds/elvish/sys"
+	"github.com/u-root/u-root/pkg/measure"
 )
 
 func execFn(fm *Frame, args ...interface{}) error {
@@ -31,7 +32,7 @@ func execFn(fm *Frame, args ...interface{}) error {
 
 	preExit(fm)
 
-	return syscall.Exec(argstrings[0], argstrings, os.Environ())
+	return measure.Exec(argstrings[0], argstrings, os.Environ())
 }
 
 func fg(pids ...int) error {
@@ -11,6 +11,7 @@ import (
 	"github.com/u-root/u-root/cmds/elvish/parse"
 	"github.com/u-root/u-root/cmds/elvish/util"
 	"github.com/u-root/u-root/cmds/elvish/hash"
+	"github.com/u-root/u-root/pkg/measure"
 )
 
 var (
@@ -75,7 +76,7 @@ func (e ExternalCmd) Call(fm *Frame, argVals []interface{}, opts map[string]inte
 	args[0] = path
 
 	sys := makeSysProcAttr(fm.background)
-	proc, err := os.StartProcess(path, args, &os.ProcAttr{Files: files, Sys: sys})
+	proc, err := measure.StartProcess(path, args, &os.ProcAttr{Files: files, Sys: sys})
 
 	if err != nil {
 		return err
@@ -5,11 +5,11 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
-	"syscall"
 
 	"github.com/u-root/u-root/cmds/elvish/runtime"
 	"github.com/u-root/u-root/cmds/elvish/sys"
 	"github.com/u-root/u-root/cmds/elvish/util"
+	"github.com/u-root/u-root/pkg/measure"
 )
 
 var logger = util.GetLogger("[shell] ")
@@ -58,7 +58,7 @@ func rescue() {
 		fmt.Println(r)
 		print(sys.DumpStack())
 		println("\nexecing recovery shell /bin/sh")
-		syscall.Exec("/bin/sh", []string{"/bin/sh"}, os.Environ())
+		measure.Exec("/bin/sh", []string{"/bin/sh"}, os.Environ())
 	}
 }
 
@@ -10,11 +10,11 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
 	"runtime"
 	"sort"
 
 	"github.com/u-root/u-root/pkg/cmdline"
+	"github.com/u-root/u-root/pkg/measure"
 )
 
 // Commands are built approximately in order from smallest to largest length of
@@ -39,7 +39,7 @@ func (p cmdSlice) Swap(i, j int) {
 func worker(cmds chan string) {
 	for cmdName := range cmds {
 		args := []string{"--onlybuild", "--noforce", "--lowpri", cmdName}
-		cmd := exec.Command("installcommand", args...)
+		cmd := measure.Command("installcommand", args...)
 		if err := cmd.Start(); err != nil {
 			log.Println("Cannot start:", err)
 			continue
@@ -16,24 +16,25 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"syscall"
 
+	"github.com/u-root/u-root/pkg/measure"
 	"github.com/u-root/u-root/pkg/uroot/util"
 )
 
 var (
-	verbose  = flag.Bool("v", false, "print all build commands")
-	test     = flag.Bool("test", false, "Test mode: don't try to set control tty")
-	debug    = func(string, ...interface{}) {}
-	osInitGo = func() {}
-	cmdList  []string
-	cmdCount int
-	envs     []string
+	verbose   = flag.Bool("v", false, "print all build commands")
+	test      = flag.Bool("test", false, "Test mode: don't try to set control tty")
+	debug     = func(string, ...interface{}) {}
+	osInitGo  = func() {}
+	osInitLog = func() {}
+	cmdList   []string
+	cmdCount  int
+	envs      []string
 )
 
 func init() {
@@ -60,6 +61,7 @@ func main() {
 	fmt.Println(`   \__,_|    |_|  \___/ \___/ \__|`)
 	fmt.Println()
 	util.Rootfs()
+	osInitLog()
 	log.Printf("Done Rootfs")
 
 	if *verbose {
@@ -176,7 +178,7 @@ func main() {
 		}
 
 		cmdCount++
-		cmd := exec.Command(v)
+		cmd := measure.Command(v)
 		cmd.Env = envs
 		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
 		if *test {
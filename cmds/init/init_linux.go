@@ -5,15 +5,47 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
 	"strconv"
-	"syscall"
+	"time"
 
 	"github.com/u-root/u-root/pkg/cmdline"
+	"github.com/u-root/u-root/pkg/measure"
+	"github.com/u-root/u-root/pkg/netconf"
+	"github.com/u-root/u-root/pkg/netlog"
+)
+
+const (
+	// netconfFile is a network configuration in the initramfs, applied
+	// before the one on the kernel command line.
+	netconfFile = "/etc/netconf.json"
+
+	// netconfTimeout bounds how long boot waits for DHCP, which is long
+	// enough for a server on the link to answer but does not hold up
+	// boots on links without one.
+	netconfTimeout = 10 * time.Second
 )
 
 func init() {
 	osInitGo = runOSInitGo
+	osInitLog = mirrorLog
+}
+
+// mirrorLog sends the log, and kernel messages with uroot.netlog.kmsg=1,
+// to the collector of uroot.netlog=, if any. Messages are buffered until
+// the network is up.
+func mirrorLog() {
+	s := netlog.Mirror("init")
+	if s == nil {
+		return
+	}
+	if v, _ := cmdline.Flag(netlog.KmsgFlag); v == "1" {
+		if err := s.ForwardKmsg(); err != nil {
+			log.Printf("Forwarding kernel messages: %v", err)
+		}
+	}
 }
 
 func runOSInitGo() {
@@ -28,11 +60,44 @@ func runOSInitGo() {
 	if present && boolErr == nil && systemdEnabled == true {
 		v := cmdList[0]
 		debug("Exec %v", v)
-		if err := syscall.Exec(v, []string{v}, envs); err != nil {
+		if err := measure.Exec(v, []string{v}, envs); err != nil {
 			log.Printf("Lucky you, systemd failed: %v", err)
 		}
 		// well, what a shame.
 		cmdList = cmdList[1:]
 		cmdCount++
 	}
+
+	configureNetwork()
+}
+
+// configureNetwork applies the network configuration in netconfFile and
+// on the kernel command line, if any, so uinit starts with the network up.
+func configureNetwork() {
+	var configs []*netconf.Config
+	if _, err := os.Stat(netconfFile); err == nil {
+		c, err := netconf.Load(netconfFile)
+		if err != nil {
+			log.Printf("Network configuration: %v", err)
+		} else {
+			configs = append(configs, c)
+		}
+	}
+	if line := cmdline.FullCmdLine(); netconf.HasCmdline(line) {
+		c, err := netconf.ParseCmdline(line)
+		if err != nil {
+			log.Printf("Network configuration on the kernel command line: %v", err)
+		} else {
+			// The kernel may have configured ip= itself.
+			configs = append(configs, c.Unconfigured())
+		}
+	}
+
+	for _, c := range configs {
+		ctx, cancel := context.WithTimeout(context.Background(), netconfTimeout)
+		if err := netconf.Apply(ctx, c); err != nil {
+			log.Printf("Configuring network: %v", err)
+		}
+		cancel()
+	}
 }
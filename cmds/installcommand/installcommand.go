@@ -36,6 +36,7 @@ import (
 	"syscall"
 
 	"github.com/u-root/u-root/pkg/golang"
+	"github.com/u-root/u-root/pkg/measure"
 	"github.com/u-root/u-root/pkg/uroot/util"
 )
 
@@ -104,7 +105,7 @@ func parseCommandLine() form {
 // it should never return in any other case. Hence, if all goes well
 // at the end, we os.Exit(0)
 func run(n string, form form) {
-	cmd := exec.Command(n, form.cmdArgs...)
+	cmd := measure.Command(n, form.cmdArgs...)
 	cmd.Stdin = os.Stdin
 	cmd.Stderr = os.Stderr
 	cmd.Stdout = os.Stdout
@@ -7,7 +7,6 @@ package main
 import (
 	"errors"
 	"fmt"
-	"io/ioutil"
 	l "log"
 	"net"
 	"os"
@@ -18,7 +17,10 @@ import (
 	"github.com/vishvananda/netlink"
 )
 
-var inet6 = flag.BoolP("6", "6", false, "use ipv6")
+var (
+	inet6   = flag.BoolP("6", "6", false, "use ipv6")
+	jsonOut = flag.BoolP("json", "j", false, "output JSON")
+)
 
 // The language implemented by the standard 'ip' is not super consistent
 // and has lots of convenience shortcuts.
@@ -92,18 +94,48 @@ func dev() (netlink.Link, error) {
 	return netlink.LinkByName(arg[cursor])
 }
 
+// family returns the address family selected on the command line.
+func family() int {
+	if *inet6 {
+		return netlink.FAMILY_V6
+	}
+	return netlink.FAMILY_V4
+}
+
+// show shows links, with their addresses if withAddresses is set, after an
+// optional [dev] DEV.
+func show(withAddresses bool) error {
+	var name string
+	if more() {
+		cursor++
+		whatIWant = []string{"dev", "device name"}
+		if arg[cursor] == "dev" {
+			cursor++
+			whatIWant = []string{"device name"}
+		}
+		name = arg[cursor]
+	}
+	ifaces, err := linkList(name)
+	if err != nil {
+		return err
+	}
+	return showLinks(os.Stdout, ifaces, withAddresses)
+}
+
 func addrip() error {
 	var err error
 	var addr *netlink.Addr
 	if len(arg) == 1 {
-		return showLinks(os.Stdout, true)
+		return show(true)
 	}
 	cursor++
-	whatIWant = []string{"add", "del"}
+	whatIWant = []string{"add", "del", "show"}
 	cmd := arg[cursor]
 
 	c := one(cmd, whatIWant)
 	switch c {
+	case "show":
+		return show(true)
 	case "add", "del":
 		cursor++
 		whatIWant = []string{"CIDR format address"}
@@ -140,15 +172,6 @@ func neigh() error {
 	return showNeighbours(os.Stdout, true)
 }
 
-func linkshow() error {
-	cursor++
-	whatIWant = []string{"<nothing>", "<device name>"}
-	if len(arg[cursor:]) == 0 {
-		return showLinks(os.Stdout, false)
-	}
-	return nil
-}
-
 func setHardwareAddress(iface netlink.Link) error {
 	cursor++
 	hwAddr, err := net.ParseMAC(arg[cursor])
@@ -169,141 +192,76 @@ func linkset() error {
 		return err
 	}
 
-	cursor++
-	whatIWant = []string{"address", "up", "down"}
-	switch one(arg[cursor], whatIWant) {
-	case "address":
-		return setHardwareAddress(iface)
-	case "up":
-		if err := netlink.LinkSetUp(iface); err != nil {
-			return fmt.Errorf("%v can't make it up: %v", iface, err)
+	for {
+		cursor++
+		whatIWant = []string{"address", "up", "down", "master", "nomaster", "mtu"}
+		switch one(arg[cursor], whatIWant) {
+		case "address":
+			if err := setHardwareAddress(iface); err != nil {
+				return err
+			}
+		case "up":
+			if err := netlink.LinkSetUp(iface); err != nil {
+				return fmt.Errorf("%v can't make it up: %v", iface, err)
+			}
+		case "down":
+			if err := netlink.LinkSetDown(iface); err != nil {
+				return fmt.Errorf("%v can't make it down: %v", iface, err)
+			}
+		case "master":
+			master, err := dev()
+			if err != nil {
+				return err
+			}
+			if err := netlink.LinkSetMasterByIndex(iface, master.Attrs().Index); err != nil {
+				return fmt.Errorf("%v can't set master %v: %v", iface, master.Attrs().Name, err)
+			}
+		case "nomaster":
+			if err := netlink.LinkSetNoMaster(iface); err != nil {
+				return fmt.Errorf("%v can't remove master: %v", iface, err)
+			}
+		case "mtu":
+			mtu, err := number("MTU")
+			if err != nil {
+				return err
+			}
+			if err := netlink.LinkSetMTU(iface, mtu); err != nil {
+				return fmt.Errorf("%v can't set mtu %d: %v", iface, mtu, err)
+			}
+		default:
+			return usage()
 		}
-	case "down":
-		if err := netlink.LinkSetDown(iface); err != nil {
-			return fmt.Errorf("%v can't make it down: %v", iface, err)
+		if !more() {
+			return nil
 		}
-	default:
-		return usage()
 	}
-	return nil
 }
 
 func link() error {
 	if len(arg) == 1 {
-		return linkshow()
+		return show(false)
 	}
 
 	cursor++
-	whatIWant = []string{"show", "set"}
+	whatIWant = []string{"show", "set", "add", "delete"}
 	cmd := arg[cursor]
 
 	switch one(cmd, whatIWant) {
 	case "show":
-		return linkshow()
+		return show(false)
 	case "set":
 		return linkset()
-	}
-	return usage()
-}
-
-func routeshow() error {
-	path := "/proc/net/route"
-	if *inet6 {
-		path = "/proc/net/ipv6_route"
-	}
-	b, err := ioutil.ReadFile(path)
-	if err != nil {
-		return fmt.Errorf("Route show failed: %v", err)
-	}
-	log.Printf("%s", string(b))
-	return nil
-}
-
-func nodespec() string {
-	cursor++
-	whatIWant = []string{"default", "CIDR"}
-	return arg[cursor]
-}
-
-func nexthop() (string, *netlink.Addr, error) {
-	cursor++
-	whatIWant = []string{"via"}
-	if arg[cursor] != "via" {
-		return "", nil, usage()
-	}
-	nh := arg[cursor]
-	cursor++
-	whatIWant = []string{"Gateway CIDR"}
-	addr, err := netlink.ParseAddr(arg[cursor])
-	if err != nil {
-		return "", nil, fmt.Errorf("Gateway CIDR: %v", err)
-	}
-	return nh, addr, nil
-}
-
-func routeadddefault() error {
-	nh, nhval, err := nexthop()
-	if err != nil {
-		return err
-	}
-	// TODO: NHFLAGS.
-	l, err := dev()
-	if err != nil {
-		return err
-	}
-	switch nh {
-	case "via":
-		log.Printf("Add default route %v via %v", nhval, l)
-		r := &netlink.Route{LinkIndex: l.Attrs().Index, Gw: nhval.IPNet.IP}
-		if err := netlink.RouteAdd(r); err != nil {
-			return fmt.Errorf("Add default route: %v", err)
-		}
-		return nil
-	}
-	return usage()
-}
-
-func routeadd() error {
-	ns := nodespec()
-	switch ns {
-	case "default":
-		return routeadddefault()
-	default:
-		addr, err := netlink.ParseAddr(arg[cursor])
-		if err != nil {
-			return usage()
-		}
-		d, err := dev()
-		if err != nil {
-			return usage()
-		}
-		r := &netlink.Route{LinkIndex: d.Attrs().Index, Dst: addr.IPNet}
-		if err := netlink.RouteAdd(r); err != nil {
-			return fmt.Errorf("error adding route %s -> %s: %v", addr, d, err)
-		}
-		return nil
-	}
-}
-
-func route() error {
-	cursor++
-	if len(arg[cursor:]) == 0 {
-		return routeshow()
-	}
-
-	whatIWant = []string{"show", "add"}
-	switch one(arg[cursor], whatIWant) {
-	case "show":
-		return routeshow()
 	case "add":
-		return routeadd()
+		return linkadd()
+	case "delete":
+		return linkdel()
 	}
 	return usage()
 }
 
 func main() {
 	// When this is embedded in busybox we need to reinit some things.
-	whatIWant = []string{"addr", "route", "link", "neigh"}
+	whatIWant = []string{"addr", "route", "rule", "link", "neigh"}
 	cursor = 0
 	flag.Parse()
 	arg = flag.Args()
@@ -333,6 +291,8 @@ func main() {
 		err = link()
 	case "route":
 		err = route()
+	case "rule":
+		err = rule()
 	case "neigh":
 		err = neigh()
 	default:
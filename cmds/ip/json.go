@@ -0,0 +1,243 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// The types below produce the same JSON as iproute2's ip -j.
+
+type linkInfoJSON struct {
+	Kind string                 `json:"info_kind"`
+	Data map[string]interface{} `json:"info_data,omitempty"`
+}
+
+type linkJSON struct {
+	Index     int           `json:"ifindex"`
+	Name      string        `json:"ifname"`
+	Link      string        `json:"link,omitempty"`
+	Flags     []string      `json:"flags"`
+	MTU       int           `json:"mtu"`
+	Master    string        `json:"master,omitempty"`
+	OperState string        `json:"operstate"`
+	LinkType  string        `json:"link_type"`
+	Address   string        `json:"address,omitempty"`
+	Broadcast string        `json:"broadcast,omitempty"`
+	LinkInfo  *linkInfoJSON `json:"linkinfo,omitempty"`
+}
+
+type addrJSON struct {
+	Family            string `json:"family"`
+	Local             string `json:"local"`
+	PrefixLen         int    `json:"prefixlen"`
+	Broadcast         string `json:"broadcast,omitempty"`
+	Scope             string `json:"scope"`
+	Label             string `json:"label,omitempty"`
+	ValidLifeTime     uint32 `json:"valid_life_time"`
+	PreferredLifeTime uint32 `json:"preferred_life_time"`
+}
+
+type linkAddrJSON struct {
+	linkJSON
+	AddrInfo []addrJSON `json:"addr_info"`
+}
+
+type routeJSON struct {
+	Type     string   `json:"type,omitempty"`
+	Dst      string   `json:"dst"`
+	Gateway  string   `json:"gateway,omitempty"`
+	Dev      string   `json:"dev,omitempty"`
+	Table    string   `json:"table,omitempty"`
+	Protocol string   `json:"protocol,omitempty"`
+	Scope    string   `json:"scope,omitempty"`
+	PrefSrc  string   `json:"prefsrc,omitempty"`
+	Metric   int      `json:"metric,omitempty"`
+	Flags    []string `json:"flags"`
+}
+
+var routeProtocols = map[int]string{
+	unix.RTPROT_REDIRECT: "redirect",
+	unix.RTPROT_KERNEL:   "kernel",
+	unix.RTPROT_BOOT:     "boot",
+	unix.RTPROT_STATIC:   "static",
+	unix.RTPROT_RA:       "ra",
+	unix.RTPROT_DHCP:     "dhcp",
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
+
+// linkFlags returns the flags of l as ip shows them.
+func linkFlags(l *netlink.LinkAttrs) []string {
+	flags := []string{}
+	if l.Flags != 0 {
+		flags = strings.Split(strings.ToUpper(l.Flags.String()), "|")
+	}
+	if l.RawFlags&unix.IFF_LOWER_UP != 0 {
+		flags = append(flags, "LOWER_UP")
+	}
+	return flags
+}
+
+// linkName returns the name of the link with index i, or "".
+func linkName(i int) string {
+	if i == 0 {
+		return ""
+	}
+	l, err := netlink.LinkByIndex(i)
+	if err != nil {
+		return fmt.Sprintf("if%d", i)
+	}
+	return l.Attrs().Name
+}
+
+func toLinkJSON(link netlink.Link) linkJSON {
+	l := link.Attrs()
+	j := linkJSON{
+		Index:     l.Index,
+		Name:      l.Name,
+		Link:      linkName(l.ParentIndex),
+		Flags:     linkFlags(l),
+		MTU:       l.MTU,
+		Master:    linkName(l.MasterIndex),
+		OperState: strings.ToUpper(l.OperState.String()),
+		LinkType:  l.EncapType,
+	}
+	if len(l.HardwareAddr) > 0 {
+		j.Address = l.HardwareAddr.String()
+		if l.EncapType == "ether" {
+			j.Broadcast = "ff:ff:ff:ff:ff:ff"
+		}
+	}
+
+	switch v := link.(type) {
+	case *netlink.Vlan:
+		j.LinkInfo = &linkInfoJSON{Kind: "vlan", Data: map[string]interface{}{
+			"protocol": strings.ToUpper(v.VlanProtocol.String()),
+			"id":       v.VlanId,
+		}}
+	case *netlink.Bond:
+		j.LinkInfo = &linkInfoJSON{Kind: "bond", Data: map[string]interface{}{
+			"mode":   v.Mode.String(),
+			"miimon": v.Miimon,
+		}}
+	case *netlink.Macvlan:
+		mode := ""
+		for name, m := range macvlanModes {
+			if m == v.Mode {
+				mode = name
+			}
+		}
+		j.LinkInfo = &linkInfoJSON{Kind: "macvlan", Data: map[string]interface{}{"mode": mode}}
+	case *netlink.Bridge, *netlink.Veth, *netlink.Dummy:
+		j.LinkInfo = &linkInfoJSON{Kind: link.Type()}
+	}
+	return j
+}
+
+func toAddrJSON(addr netlink.Addr) addrJSON {
+	family := "inet6"
+	if addr.IP.To4() != nil {
+		family = "inet"
+	}
+	ones, _ := addr.Mask.Size()
+	j := addrJSON{
+		Family:            family,
+		Local:             addr.IP.String(),
+		PrefixLen:         ones,
+		Scope:             addrScopes[netlink.Scope(addr.Scope)],
+		Label:             addr.Label,
+		ValidLifeTime:     uint32(addr.ValidLft),
+		PreferredLifeTime: uint32(addr.PreferedLft),
+	}
+	if addr.Broadcast != nil {
+		j.Broadcast = addr.Broadcast.String()
+	}
+	return j
+}
+
+func toRouteJSON(r netlink.Route) routeJSON {
+	j := routeJSON{
+		Dst:    "default",
+		Dev:    linkName(r.LinkIndex),
+		Metric: r.Priority,
+		Flags:  []string{},
+	}
+	// Like iproute2, leave out the default protocol.
+	if r.Protocol != unix.RTPROT_UNSPEC && r.Protocol != unix.RTPROT_BOOT {
+		j.Protocol = protoName(r.Protocol)
+	}
+	if r.Dst != nil {
+		switch ones, bits := r.Dst.Mask.Size(); ones {
+		case 0:
+		case bits:
+			j.Dst = r.Dst.IP.String()
+		default:
+			j.Dst = r.Dst.String()
+		}
+	}
+	if t := r.Type; t != 0 && t != unix.RTN_UNICAST {
+		j.Type = strconv.Itoa(t)
+		for name, v := range routeTypes {
+			if v == t {
+				j.Type = name
+			}
+		}
+	}
+	if r.Table != 0 && r.Table != unix.RT_TABLE_MAIN {
+		j.Table = tableName(r.Table)
+	}
+	if r.Gw != nil {
+		j.Gateway = r.Gw.String()
+	}
+	if r.Src != nil {
+		j.PrefSrc = r.Src.String()
+	}
+	if r.Scope != netlink.SCOPE_UNIVERSE {
+		j.Scope = addrScopes[r.Scope]
+	}
+	if r.Flags&unix.RTNH_F_ONLINK != 0 {
+		j.Flags = append(j.Flags, "onlink")
+	}
+	return j
+}
+
+func showLinksJSON(w io.Writer, links []netlink.Link, withAddresses bool) error {
+	if !withAddresses {
+		j := []linkJSON{}
+		for _, l := range links {
+			j = append(j, toLinkJSON(l))
+		}
+		return writeJSON(w, j)
+	}
+
+	j := []linkAddrJSON{}
+	for _, l := range links {
+		addrs, err := netlink.AddrList(l, netlink.FAMILY_ALL)
+		if err != nil {
+			return fmt.Errorf("Can't enumerate addresses: %v", err)
+		}
+		la := linkAddrJSON{linkJSON: toLinkJSON(l), AddrInfo: []addrJSON{}}
+		for _, a := range addrs {
+			la.AddrInfo = append(la.AddrInfo, toAddrJSON(a))
+		}
+		j = append(j, la)
+	}
+	return writeJSON(w, j)
+}
@@ -0,0 +1,65 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func TestToAddrJSON(t *testing.T) {
+	addr, err := netlink.ParseAddr("192.168.1.2/24 eth0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr.Broadcast = net.IPv4(192, 168, 1, 255)
+	addr.ValidLft = math.MaxUint32
+	addr.PreferedLft = 30
+
+	want := addrJSON{
+		Family:            "inet",
+		Local:             "192.168.1.2",
+		PrefixLen:         24,
+		Broadcast:         "192.168.1.255",
+		Scope:             "global",
+		Label:             "eth0",
+		ValidLifeTime:     math.MaxUint32,
+		PreferredLifeTime: 30,
+	}
+	if got := toAddrJSON(*addr); !reflect.DeepEqual(got, want) {
+		t.Errorf("toAddrJSON() = %+v, want %+v", got, want)
+	}
+}
+
+func TestToRouteJSON(t *testing.T) {
+	_, dst, _ := net.ParseCIDR("10.0.0.0/8")
+	_, host, _ := net.ParseCIDR("2001:db8::1/128")
+	for _, tt := range []struct {
+		route netlink.Route
+		want  routeJSON
+	}{
+		{
+			route: netlink.Route{Gw: net.IPv4(10, 0, 0, 1), Protocol: unix.RTPROT_DHCP, Priority: 100},
+			want:  routeJSON{Dst: "default", Gateway: "10.0.0.1", Protocol: "dhcp", Metric: 100, Flags: []string{}},
+		},
+		{
+			route: netlink.Route{Dst: dst, Scope: netlink.SCOPE_LINK, Src: net.IPv4(10, 0, 0, 2), Protocol: unix.RTPROT_KERNEL},
+			want:  routeJSON{Dst: "10.0.0.0/8", Protocol: "kernel", Scope: "link", PrefSrc: "10.0.0.2", Flags: []string{}},
+		},
+		{
+			route: netlink.Route{Dst: host, Flags: unix.RTNH_F_ONLINK},
+			want:  routeJSON{Dst: "2001:db8::1", Flags: []string{"onlink"}},
+		},
+	} {
+		if got := toRouteJSON(tt.route); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("toRouteJSON(%v) = %+v, want %+v", tt.route, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+)
+
+var linkTypes = []string{"vlan", "bond", "bridge", "veth", "macvlan", "dummy"}
+
+var macvlanModes = map[string]netlink.MacvlanMode{
+	"private":  netlink.MACVLAN_MODE_PRIVATE,
+	"vepa":     netlink.MACVLAN_MODE_VEPA,
+	"bridge":   netlink.MACVLAN_MODE_BRIDGE,
+	"passthru": netlink.MACVLAN_MODE_PASSTHRU,
+	"source":   netlink.MACVLAN_MODE_SOURCE,
+}
+
+// more reports whether there are arguments after the cursor.
+func more() bool {
+	return cursor+1 < len(arg)
+}
+
+func number(what string) (int, error) {
+	cursor++
+	whatIWant = []string{what}
+	n, err := strconv.Atoi(arg[cursor])
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", what, err)
+	}
+	return n, nil
+}
+
+// ip link add [link DEV] [name] NAME [address LLADDR] [mtu MTU] type TYPE [ARGS]
+func linkadd() error {
+	attrs := netlink.NewLinkAttrs()
+	var parent netlink.Link
+	var typ string
+	for typ == "" {
+		cursor++
+		whatIWant = []string{"link", "name", "address", "mtu", "type"}
+		switch arg[cursor] {
+		case "link":
+			cursor++
+			whatIWant = []string{"device name"}
+			l, err := netlink.LinkByName(arg[cursor])
+			if err != nil {
+				return err
+			}
+			parent = l
+		case "name":
+			cursor++
+			whatIWant = []string{"name"}
+			attrs.Name = arg[cursor]
+		case "address":
+			cursor++
+			whatIWant = []string{"link layer address"}
+			hwAddr, err := net.ParseMAC(arg[cursor])
+			if err != nil {
+				return err
+			}
+			attrs.HardwareAddr = hwAddr
+		case "mtu":
+			mtu, err := number("MTU")
+			if err != nil {
+				return err
+			}
+			attrs.MTU = mtu
+		case "type":
+			cursor++
+			whatIWant = linkTypes
+			typ = arg[cursor]
+		default:
+			if attrs.Name != "" {
+				return usage()
+			}
+			attrs.Name = arg[cursor]
+		}
+	}
+	if attrs.Name == "" {
+		return fmt.Errorf("link add: no name given")
+	}
+	if parent != nil {
+		attrs.ParentIndex = parent.Attrs().Index
+	}
+
+	var link netlink.Link
+	var err error
+	switch typ {
+	case "vlan":
+		link, err = vlan(attrs)
+	case "bond":
+		link, err = bond(attrs)
+	case "bridge":
+		link = &netlink.Bridge{LinkAttrs: attrs}
+	case "veth":
+		link, err = veth(attrs)
+	case "macvlan":
+		link, err = macvlan(attrs)
+	case "dummy":
+		link = &netlink.Dummy{LinkAttrs: attrs}
+	default:
+		return usage()
+	}
+	if err != nil {
+		return err
+	}
+	if (typ == "vlan" || typ == "macvlan") && parent == nil {
+		return fmt.Errorf("%s link needs a parent: link DEV", typ)
+	}
+	if more() {
+		cursor++
+		return usage()
+	}
+	if err := netlink.LinkAdd(link); err != nil {
+		return fmt.Errorf("adding %s link %s: %v", typ, attrs.Name, err)
+	}
+	return nil
+}
+
+// ... type vlan id VLANID [protocol {802.1q|802.1ad}]
+func vlan(attrs netlink.LinkAttrs) (netlink.Link, error) {
+	v := &netlink.Vlan{LinkAttrs: attrs, VlanId: -1}
+	for more() {
+		cursor++
+		whatIWant = []string{"id", "protocol"}
+		switch arg[cursor] {
+		case "id":
+			id, err := number("VLAN ID")
+			if err != nil {
+				return nil, err
+			}
+			v.VlanId = id
+		case "protocol":
+			cursor++
+			whatIWant = []string{"802.1q", "802.1ad"}
+			p := netlink.StringToVlanProtocol(strings.ToLower(arg[cursor]))
+			if p == netlink.VLAN_PROTOCOL_UNKNOWN {
+				return nil, usage()
+			}
+			v.VlanProtocol = p
+		default:
+			return nil, usage()
+		}
+	}
+	if v.VlanId < 0 {
+		return nil, fmt.Errorf("vlan link needs an id")
+	}
+	return v, nil
+}
+
+// ... type bond [mode MODE] [miimon MS] [updelay MS] [downdelay MS] [lacp_rate {slow|fast}]
+// [xmit_hash_policy POLICY] [min_links N]
+func bond(attrs netlink.LinkAttrs) (netlink.Link, error) {
+	b := netlink.NewLinkBond(attrs)
+	for more() {
+		cursor++
+		whatIWant = []string{"mode", "miimon", "updelay", "downdelay", "lacp_rate", "xmit_hash_policy", "min_links"}
+		var err error
+		switch opt := arg[cursor]; opt {
+		case "mode":
+			cursor++
+			whatIWant = []string{"balance-rr", "active-backup", "balance-xor", "broadcast", "802.3ad", "balance-tlb", "balance-alb"}
+			if b.Mode = netlink.StringToBondMode(arg[cursor]); b.Mode == netlink.BOND_MODE_UNKNOWN {
+				return nil, usage()
+			}
+		case "lacp_rate":
+			cursor++
+			whatIWant = []string{"slow", "fast"}
+			if b.LacpRate = netlink.StringToBondLacpRate(arg[cursor]); b.LacpRate == netlink.BOND_LACP_RATE_UNKNOWN {
+				return nil, usage()
+			}
+		case "xmit_hash_policy":
+			cursor++
+			whatIWant = []string{"layer2", "layer2+3", "layer3+4", "encap2+3", "encap3+4"}
+			if b.XmitHashPolicy = netlink.StringToBondXmitHashPolicy(arg[cursor]); b.XmitHashPolicy == netlink.BOND_XMIT_HASH_POLICY_UNKNOWN {
+				return nil, usage()
+			}
+		case "miimon":
+			b.Miimon, err = number(opt)
+		case "updelay":
+			b.UpDelay, err = number(opt)
+		case "downdelay":
+			b.DownDelay, err = number(opt)
+		case "min_links":
+			b.MinLinks, err = number(opt)
+		default:
+			return nil, usage()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// ... type veth [peer [name] NAME]
+func veth(attrs netlink.LinkAttrs) (netlink.Link, error) {
+	v := &netlink.Veth{LinkAttrs: attrs}
+	if more() {
+		cursor++
+		whatIWant = []string{"peer"}
+		if arg[cursor] != "peer" {
+			return nil, usage()
+		}
+		cursor++
+		whatIWant = []string{"name", "peer name"}
+		if arg[cursor] == "name" {
+			cursor++
+			whatIWant = []string{"peer name"}
+		}
+		v.PeerName = arg[cursor]
+	}
+	if v.PeerName == "" {
+		// netlink cannot leave naming the peer to the kernel.
+		return nil, fmt.Errorf("veth link needs a peer name: peer name NAME")
+	}
+	return v, nil
+}
+
+// ... type macvlan [mode {private|vepa|bridge|passthru|source}]
+func macvlan(attrs netlink.LinkAttrs) (netlink.Link, error) {
+	m := &netlink.Macvlan{LinkAttrs: attrs}
+	if more() {
+		cursor++
+		whatIWant = []string{"mode"}
+		if arg[cursor] != "mode" {
+			return nil, usage()
+		}
+		cursor++
+		whatIWant = []string{"private", "vepa", "bridge", "passthru", "source"}
+		mode, ok := macvlanModes[arg[cursor]]
+		if !ok {
+			return nil, usage()
+		}
+		m.Mode = mode
+	}
+	return m, nil
+}
+
+// ip link delete [dev] NAME
+func linkdel() error {
+	iface, err := dev()
+	if err != nil {
+		return err
+	}
+	if err := netlink.LinkDel(iface); err != nil {
+		return fmt.Errorf("deleting %s: %v", iface.Attrs().Name, err)
+	}
+	return nil
+}
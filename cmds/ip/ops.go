@@ -14,18 +14,37 @@ import (
 	"github.com/vishvananda/netlink"
 )
 
-func showLinks(w io.Writer, withAddresses bool) error {
+// linkList returns the link called name, or all links if name is empty.
+func linkList(name string) ([]netlink.Link, error) {
+	if name != "" {
+		l, err := netlink.LinkByName(name)
+		if err != nil {
+			return nil, err
+		}
+		return []netlink.Link{l}, nil
+	}
 	ifaces, err := netlink.LinkList()
 	if err != nil {
-		return fmt.Errorf("Can't enumerate interfaces? %v", err)
+		return nil, fmt.Errorf("Can't enumerate interfaces? %v", err)
+	}
+	return ifaces, nil
+}
+
+func showLinks(w io.Writer, ifaces []netlink.Link, withAddresses bool) error {
+	if *jsonOut {
+		return showLinksJSON(w, ifaces, withAddresses)
 	}
 
 	for _, v := range ifaces {
 		l := v.Attrs()
 
-		fmt.Fprintf(w, "%d: %s: <%s> mtu %d state %s\n", l.Index, l.Name,
+		var master string
+		if l.MasterIndex != 0 {
+			master = " master " + linkName(l.MasterIndex)
+		}
+		fmt.Fprintf(w, "%d: %s: <%s> mtu %d%s state %s\n", l.Index, l.Name,
 			strings.Replace(strings.ToUpper(fmt.Sprintf("%s", l.Flags)), "|", ",", -1),
-			l.MTU, strings.ToUpper(l.OperState.String()))
+			l.MTU, master, strings.ToUpper(l.OperState.String()))
 
 		fmt.Fprintf(w, "    link/%s %s\n", l.EncapType, l.HardwareAddr)
 
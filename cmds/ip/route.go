@@ -0,0 +1,443 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// rtTables names routing tables in addition to the reserved ones.
+const rtTables = "/etc/iproute2/rt_tables"
+
+var (
+	routeTypes = map[string]int{
+		"unicast":     unix.RTN_UNICAST,
+		"local":       unix.RTN_LOCAL,
+		"broadcast":   unix.RTN_BROADCAST,
+		"anycast":     unix.RTN_ANYCAST,
+		"multicast":   unix.RTN_MULTICAST,
+		"blackhole":   unix.RTN_BLACKHOLE,
+		"unreachable": unix.RTN_UNREACHABLE,
+		"prohibit":    unix.RTN_PROHIBIT,
+		"throw":       unix.RTN_THROW,
+	}
+
+	routeScopes = map[string]netlink.Scope{
+		"global": netlink.SCOPE_UNIVERSE,
+		"site":   netlink.SCOPE_SITE,
+		"link":   netlink.SCOPE_LINK,
+		"host":   netlink.SCOPE_HOST,
+	}
+
+	// tables maps table names to IDs. It is read from rtTables on
+	// first use.
+	tables map[string]int
+)
+
+func keys(m interface{}) []string {
+	var k []string
+	switch m := m.(type) {
+	case map[string]int:
+		for s := range m {
+			k = append(k, s)
+		}
+	case map[string]netlink.Scope:
+		for s := range m {
+			k = append(k, s)
+		}
+	}
+	sort.Strings(k)
+	return k
+}
+
+// readTables parses an rt_tables file.
+func readTables(r io.Reader) map[string]int {
+	t := map[string]int{
+		"unspec":  unix.RT_TABLE_UNSPEC,
+		"default": unix.RT_TABLE_DEFAULT,
+		"main":    unix.RT_TABLE_MAIN,
+		"local":   unix.RT_TABLE_LOCAL,
+	}
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		f := strings.Fields(s.Text())
+		if len(f) < 2 || strings.HasPrefix(f[0], "#") {
+			continue
+		}
+		if id, err := strconv.ParseUint(f[0], 0, 32); err == nil {
+			t[f[1]] = int(id)
+		}
+	}
+	return t
+}
+
+func tableIDs() map[string]int {
+	if tables == nil {
+		f, err := os.Open(rtTables)
+		if err == nil {
+			defer f.Close()
+		}
+		// A missing file still gets us the reserved tables.
+		tables = readTables(f)
+	}
+	return tables
+}
+
+// tableName returns the name of table id, or its number if it has none.
+func tableName(id int) string {
+	for name, i := range tableIDs() {
+		if i == id {
+			return name
+		}
+	}
+	return strconv.Itoa(id)
+}
+
+// table parses a table name or number. "all" is returned as
+// RT_TABLE_UNSPEC.
+func table() (int, error) {
+	cursor++
+	whatIWant = append([]string{"all", "table number"}, keys(tableIDs())...)
+	if arg[cursor] == "all" {
+		return unix.RT_TABLE_UNSPEC, nil
+	}
+	if id, ok := tableIDs()[arg[cursor]]; ok {
+		return id, nil
+	}
+	id, err := strconv.ParseUint(arg[cursor], 0, 32)
+	if err != nil {
+		return 0, usage()
+	}
+	return int(id), nil
+}
+
+// protoName returns the name of route protocol p, or its number.
+func protoName(p int) string {
+	if s, ok := routeProtocols[p]; ok {
+		return s
+	}
+	return strconv.Itoa(p)
+}
+
+func proto() (int, error) {
+	cursor++
+	whatIWant = []string{"kernel", "boot", "static", "ra", "dhcp", "protocol number"}
+	for p, name := range routeProtocols {
+		if name == arg[cursor] {
+			return p, nil
+		}
+	}
+	p, err := strconv.ParseUint(arg[cursor], 0, 8)
+	if err != nil {
+		return 0, usage()
+	}
+	return int(p), nil
+}
+
+func scope() (netlink.Scope, error) {
+	cursor++
+	whatIWant = keys(routeScopes)
+	s, ok := routeScopes[arg[cursor]]
+	if !ok {
+		return 0, usage()
+	}
+	return s, nil
+}
+
+// address parses an IP address. For compatibility, a CIDR address is
+// accepted too.
+func address(what string) (net.IP, error) {
+	cursor++
+	whatIWant = []string{what}
+	if ip := net.ParseIP(arg[cursor]); ip != nil {
+		return ip, nil
+	}
+	ip, _, err := net.ParseCIDR(arg[cursor])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", what, err)
+	}
+	return ip, nil
+}
+
+// prefix parses a route destination: default, a CIDR prefix or an
+// address, which is a host route. The default route is returned as a
+// zero-length prefix, so that the kernel knows its family.
+func prefix() (*net.IPNet, error) {
+	whatIWant = []string{"default", "CIDR", "address"}
+	if arg[cursor] == "default" {
+		if *inet6 {
+			return &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}, nil
+		}
+		return &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)}, nil
+	}
+	if ip := net.ParseIP(arg[cursor]); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, n, err := net.ParseCIDR(arg[cursor])
+	if err != nil {
+		return nil, fmt.Errorf("prefix: %v", err)
+	}
+	return n, nil
+}
+
+// routeSpec parses
+//
+//	[TYPE] PREFIX [via ADDR] [dev DEV] [src ADDR] [metric N] [table TABLE]
+//	[proto PROTO] [scope SCOPE] [mtu MTU] [onlink]
+//
+// Unless add is set, the route is used to match routes to delete, and
+// what is not given matches anything.
+func routeSpec(add bool) (*netlink.Route, error) {
+	r := &netlink.Route{}
+	if add {
+		r.Type, r.Protocol = unix.RTN_UNICAST, unix.RTPROT_BOOT
+	}
+	cursor++
+	if t, ok := routeTypes[arg[cursor]]; ok {
+		r.Type = t
+		cursor++
+	}
+	dst, err := prefix()
+	if err != nil {
+		return nil, err
+	}
+	r.Dst = dst
+
+	scopeSet := false
+	for more() {
+		cursor++
+		whatIWant = []string{"via", "dev", "src", "metric", "table", "proto", "scope", "mtu", "onlink"}
+		switch arg[cursor] {
+		case "via":
+			r.Gw, err = address("gateway address")
+		case "dev", "oif":
+			var d netlink.Link
+			if d, err = dev(); err == nil {
+				r.LinkIndex = d.Attrs().Index
+			}
+		case "src":
+			r.Src, err = address("source address")
+		case "metric", "priority", "preference":
+			r.Priority, err = number("metric")
+		case "table":
+			r.Table, err = table()
+		case "proto", "protocol":
+			r.Protocol, err = proto()
+		case "scope":
+			r.Scope, err = scope()
+			scopeSet = true
+		case "mtu":
+			r.MTU, err = number("MTU")
+		case "onlink":
+			r.Flags |= unix.RTNH_F_ONLINK
+		default:
+			// Earlier versions of ip took the device without "dev".
+			d, lerr := netlink.LinkByName(arg[cursor])
+			if lerr != nil {
+				return nil, usage()
+			}
+			r.LinkIndex = d.Attrs().Index
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Like iproute2, pick the narrowest scope that makes sense.
+	switch {
+	case scopeSet:
+	case !add:
+		r.Scope = netlink.SCOPE_NOWHERE
+	case r.Type == unix.RTN_LOCAL:
+		r.Scope = netlink.SCOPE_HOST
+	case r.Type == unix.RTN_UNICAST && r.Gw == nil && r.LinkIndex != 0:
+		r.Scope = netlink.SCOPE_LINK
+	}
+	return r, nil
+}
+
+// routeFilter parses the selectors of route show and flush:
+//
+//	[table TABLE] [dev DEV] [proto PROTO] [type TYPE] [scope SCOPE]
+//
+// The main table is selected if no table is given. It returns the number of
+// selectors given.
+func routeFilter() (*netlink.Route, uint64, int, error) {
+	filter := &netlink.Route{Table: unix.RT_TABLE_MAIN}
+	mask := netlink.RT_FILTER_TABLE
+	n := 0
+	for more() {
+		cursor++
+		whatIWant = []string{"table", "dev", "proto", "type", "scope"}
+		var err error
+		switch arg[cursor] {
+		case "table":
+			filter.Table, err = table()
+		case "dev":
+			var d netlink.Link
+			if d, err = dev(); err == nil {
+				filter.LinkIndex = d.Attrs().Index
+				mask |= netlink.RT_FILTER_OIF
+			}
+		case "proto", "protocol":
+			filter.Protocol, err = proto()
+			mask |= netlink.RT_FILTER_PROTOCOL
+		case "type":
+			cursor++
+			whatIWant = keys(routeTypes)
+			t, ok := routeTypes[arg[cursor]]
+			if !ok {
+				return nil, 0, 0, usage()
+			}
+			filter.Type = t
+			mask |= netlink.RT_FILTER_TYPE
+		case "scope":
+			filter.Scope, err = scope()
+			mask |= netlink.RT_FILTER_SCOPE
+		default:
+			return nil, 0, 0, usage()
+		}
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		n++
+	}
+	return filter, mask, n, nil
+}
+
+// routeString formats a route like ip route show does.
+func routeString(j routeJSON) string {
+	var s []string
+	if j.Type != "" {
+		s = append(s, j.Type)
+	}
+	s = append(s, j.Dst)
+	for _, o := range []struct{ name, v string }{
+		{"via", j.Gateway},
+		{"dev", j.Dev},
+		{"table", j.Table},
+		{"proto", j.Protocol},
+		{"scope", j.Scope},
+		{"src", j.PrefSrc},
+	} {
+		if o.v != "" {
+			s = append(s, o.name, o.v)
+		}
+	}
+	if j.Metric != 0 {
+		s = append(s, "metric", strconv.Itoa(j.Metric))
+	}
+	return strings.Join(append(s, j.Flags...), " ")
+}
+
+func showRoutes(w io.Writer, routes []netlink.Route) error {
+	j := []routeJSON{}
+	for _, r := range routes {
+		j = append(j, toRouteJSON(r))
+	}
+	if *jsonOut {
+		return writeJSON(w, j)
+	}
+	for _, r := range j {
+		fmt.Fprintln(w, routeString(r))
+	}
+	return nil
+}
+
+func routeshow() error {
+	filter, mask, _, err := routeFilter()
+	if err != nil {
+		return err
+	}
+	routes, err := netlink.RouteListFiltered(family(), filter, mask)
+	if err != nil {
+		return fmt.Errorf("Route show failed: %v", err)
+	}
+	return showRoutes(os.Stdout, routes)
+}
+
+func routeflush() error {
+	filter, mask, n, err := routeFilter()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("route flush needs a selector, e.g. table main")
+	}
+	routes, err := netlink.RouteListFiltered(family(), filter, mask)
+	if err != nil {
+		return fmt.Errorf("Route flush failed: %v", err)
+	}
+	for _, r := range routes {
+		if err := netlink.RouteDel(&r); err != nil {
+			return fmt.Errorf("Deleting route %s: %v", routeString(toRouteJSON(r)), err)
+		}
+	}
+	return nil
+}
+
+// ip route get ADDR
+func routeget() error {
+	ip, err := address("address")
+	if err != nil {
+		return err
+	}
+	routes, err := netlink.RouteGet(ip)
+	if err != nil {
+		return fmt.Errorf("Route get %v failed: %v", ip, err)
+	}
+	return showRoutes(os.Stdout, routes)
+}
+
+func route() error {
+	cursor++
+	if len(arg[cursor:]) == 0 {
+		return routeshow()
+	}
+
+	whatIWant = []string{"show", "list", "add", "replace", "delete", "get", "flush"}
+	c := one(arg[cursor], whatIWant)
+	switch c {
+	case "show", "list":
+		return routeshow()
+	case "get":
+		return routeget()
+	case "flush":
+		return routeflush()
+	case "add", "replace", "delete":
+	default:
+		return usage()
+	}
+
+	r, err := routeSpec(c != "delete")
+	if err != nil {
+		return err
+	}
+	switch c {
+	case "add":
+		err = netlink.RouteAdd(r)
+	case "replace":
+		err = netlink.RouteReplace(r)
+	case "delete":
+		err = netlink.RouteDel(r)
+	}
+	if err != nil {
+		return fmt.Errorf("route %s %s: %v", c, routeString(toRouteJSON(*r)), err)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func TestReadTables(t *testing.T) {
+	got := readTables(strings.NewReader(`#
+# reserved values
+#
+255	local
+254	main
+253	default
+0	unspec
+#1	inr.ruhep
+100	uplink
+0x65	backup
+bogus	entry
+`))
+	want := map[string]int{
+		"local":   255,
+		"main":    254,
+		"default": 253,
+		"unspec":  0,
+		"uplink":  100,
+		"backup":  101,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readTables() = %v, want %v", got, want)
+	}
+}
+
+func TestRouteString(t *testing.T) {
+	tables = map[string]int{"main": unix.RT_TABLE_MAIN, "local": unix.RT_TABLE_LOCAL}
+	defer func() { tables = nil }()
+
+	_, dst, _ := net.ParseCIDR("10.8.0.0/16")
+	for _, tt := range []struct {
+		route netlink.Route
+		want  string
+	}{
+		{
+			route: netlink.Route{Dst: &net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)}, Gw: net.IPv4(10, 0, 0, 1), Protocol: unix.RTPROT_BOOT},
+			want:  "default via 10.0.0.1",
+		},
+		{
+			route: netlink.Route{Dst: dst, Type: unix.RTN_BLACKHOLE, Table: 100, Priority: 5},
+			want:  "blackhole 10.8.0.0/16 table 100 metric 5",
+		},
+		{
+			route: netlink.Route{Dst: dst, Type: unix.RTN_LOCAL, Table: unix.RT_TABLE_LOCAL, Protocol: unix.RTPROT_KERNEL, Scope: netlink.SCOPE_HOST},
+			want:  "local 10.8.0.0/16 table local proto kernel scope host",
+		},
+	} {
+		if got := routeString(toRouteJSON(tt.route)); got != tt.want {
+			t.Errorf("routeString(%v) = %q, want %q", tt.route, got, tt.want)
+		}
+	}
+}
+
+func TestRuleString(t *testing.T) {
+	tables = map[string]int{"main": unix.RT_TABLE_MAIN}
+	defer func() { tables = nil }()
+
+	_, src, _ := net.ParseCIDR("10.9.0.0/16")
+	r := netlink.NewRule()
+	r.Priority, r.Src, r.Table = 1000, src, 100
+	m := netlink.NewRule()
+	m.Priority, m.Invert, m.Mark, m.Mask, m.Table = 1001, true, 0x10, 0xff, unix.RT_TABLE_MAIN
+	g := netlink.NewRule()
+	g.Goto, g.IifName = 32766, "eth1"
+
+	for _, tt := range []struct {
+		rule *netlink.Rule
+		want string
+	}{
+		{rule: r, want: "1000:\tfrom 10.9.0.0/16 lookup 100"},
+		{rule: m, want: "1001:\tnot from all fwmark 0x10/0xff lookup main"},
+		{rule: g, want: "0:\tfrom all iif eth1 goto 32766"},
+	} {
+		if got := ruleString(toRuleJSON(*tt.rule)); got != tt.want {
+			t.Errorf("ruleString(%v) = %q, want %q", tt.rule, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+type ruleJSON struct {
+	Priority int    `json:"priority"`
+	Not      bool   `json:"not,omitempty"`
+	Src      string `json:"src"`
+	Dst      string `json:"dst,omitempty"`
+	FWMark   string `json:"fwmark,omitempty"`
+	FWMask   string `json:"fwmask,omitempty"`
+	Iif      string `json:"iif,omitempty"`
+	Oif      string `json:"oif,omitempty"`
+	Table    string `json:"table,omitempty"`
+	Goto     int    `json:"goto,omitempty"`
+}
+
+func toRuleJSON(r netlink.Rule) ruleJSON {
+	j := ruleJSON{
+		Priority: r.Priority,
+		Not:      r.Invert,
+		Src:      "all",
+		Iif:      r.IifName,
+		Oif:      r.OifName,
+	}
+	if j.Priority < 0 {
+		j.Priority = 0
+	}
+	if r.Src != nil {
+		j.Src = r.Src.String()
+	}
+	if r.Dst != nil {
+		j.Dst = r.Dst.String()
+	}
+	if r.Mark >= 0 {
+		j.FWMark = fmt.Sprintf("%#x", r.Mark)
+		if r.Mask >= 0 && uint32(r.Mask) != 0xffffffff {
+			j.FWMask = fmt.Sprintf("%#x", r.Mask)
+		}
+	}
+	switch {
+	case r.Goto >= 0:
+		j.Goto = r.Goto
+	case r.Table != unix.RT_TABLE_UNSPEC:
+		j.Table = tableName(r.Table)
+	}
+	return j
+}
+
+// ruleString formats a rule like ip rule show does.
+func ruleString(j ruleJSON) string {
+	s := []string{fmt.Sprintf("%d:\t", j.Priority)}
+	if j.Not {
+		s = append(s, "not")
+	}
+	s = append(s, "from", j.Src)
+	if j.Dst != "" {
+		s = append(s, "to", j.Dst)
+	}
+	if j.FWMark != "" {
+		mark := j.FWMark
+		if j.FWMask != "" {
+			mark += "/" + j.FWMask
+		}
+		s = append(s, "fwmark", mark)
+	}
+	if j.Iif != "" {
+		s = append(s, "iif", j.Iif)
+	}
+	if j.Oif != "" {
+		s = append(s, "oif", j.Oif)
+	}
+	switch {
+	case j.Goto != 0:
+		s = append(s, "goto", strconv.Itoa(j.Goto))
+	case j.Table != "":
+		s = append(s, "lookup", j.Table)
+	}
+	return s[0] + strings.Join(s[1:], " ")
+}
+
+func showRules(w io.Writer, rules []netlink.Rule) error {
+	j := []ruleJSON{}
+	for _, r := range rules {
+		j = append(j, toRuleJSON(r))
+	}
+	if *jsonOut {
+		return writeJSON(w, j)
+	}
+	for _, r := range j {
+		fmt.Fprintln(w, ruleString(r))
+	}
+	return nil
+}
+
+// mark parses MARK[/MASK].
+func mark() (int, int, error) {
+	cursor++
+	whatIWant = []string{"MARK[/MASK]"}
+	f := strings.SplitN(arg[cursor], "/", 2)
+	m, err := strconv.ParseUint(f[0], 0, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("fwmark: %v", err)
+	}
+	mask := uint64(0xffffffff)
+	if len(f) == 2 {
+		if mask, err = strconv.ParseUint(f[1], 0, 32); err != nil {
+			return 0, 0, fmt.Errorf("fwmark mask: %v", err)
+		}
+	}
+	return int(m), int(mask), nil
+}
+
+// ruleSpec parses
+//
+//	[not] [from PREFIX] [to PREFIX] [fwmark MARK[/MASK]] [iif DEV] [oif DEV]
+//	[priority N] [table TABLE | goto N]
+func ruleSpec() (*netlink.Rule, error) {
+	r := netlink.NewRule()
+	r.Family = family()
+	for more() {
+		cursor++
+		whatIWant = []string{"not", "from", "to", "fwmark", "iif", "oif", "priority", "table", "goto"}
+		var err error
+		switch arg[cursor] {
+		case "not":
+			r.Invert = true
+		case "from", "to":
+			what := arg[cursor]
+			cursor++
+			if arg[cursor] == "all" {
+				break
+			}
+			p, perr := prefix()
+			if perr != nil {
+				return nil, perr
+			}
+			if p.IP.To4() == nil {
+				r.Family = netlink.FAMILY_V6
+			}
+			if what == "from" {
+				r.Src = p
+			} else {
+				r.Dst = p
+			}
+		case "fwmark":
+			r.Mark, r.Mask, err = mark()
+		case "iif", "dev":
+			cursor++
+			whatIWant = []string{"device name"}
+			r.IifName = arg[cursor]
+		case "oif":
+			cursor++
+			whatIWant = []string{"device name"}
+			r.OifName = arg[cursor]
+		case "priority", "preference", "pref", "order":
+			r.Priority, err = number("priority")
+		case "table", "lookup":
+			if r.Table, err = table(); err == nil && r.Table > unix.RT_TABLE_LOCAL {
+				err = fmt.Errorf("rule: tables above %d are not supported", unix.RT_TABLE_LOCAL)
+			}
+		case "goto":
+			r.Goto, err = number("rule priority")
+		default:
+			return nil, usage()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func rule() error {
+	cursor++
+	if len(arg[cursor:]) == 0 {
+		return ruleshow()
+	}
+
+	whatIWant = []string{"show", "list", "add", "delete"}
+	switch c := one(arg[cursor], whatIWant); c {
+	case "show", "list":
+		return ruleshow()
+	case "add", "delete":
+		r, err := ruleSpec()
+		if err != nil {
+			return err
+		}
+		if c == "add" {
+			err = netlink.RuleAdd(r)
+		} else {
+			err = netlink.RuleDel(r)
+		}
+		if err != nil {
+			return fmt.Errorf("rule %s %s: %v", c, ruleString(toRuleJSON(*r)), err)
+		}
+		return nil
+	}
+	return usage()
+}
+
+func ruleshow() error {
+	rules, err := netlink.RuleList(family())
+	if err != nil {
+		return fmt.Errorf("Rule show failed: %v", err)
+	}
+	return showRules(os.Stdout, rules)
+}
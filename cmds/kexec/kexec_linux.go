@@ -17,10 +17,19 @@
 //     --i=FILE or --initrd=FILE:     Use file as the kernel's initial ramdisk
 //     -l or --load:                  Load the new kernel into the current kernel
 //     -e or --exec:                  Execute a currently loaded kernel
+//     --db=FILE[,FILE...]:           Only load PE kernels whose Authenticode signature
+//                                    or hash is trusted by these signature databases
+//     --dbx=FILE[,FILE...]:          Reject PE kernels whose hash or signer is listed
+//                                    in these signature databases
+//
+// Signature databases may be PEM or DER certificates, or EFI signature lists
+// such as the firmware's db and dbx variables in /sys/firmware/efi/efivars.
 package main
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
@@ -28,6 +37,7 @@ import (
 	flag "github.com/spf13/pflag"
 
 	"github.com/u-root/u-root/pkg/acpi"
+	"github.com/u-root/u-root/pkg/authenticode"
 	"github.com/u-root/u-root/pkg/cmdline"
 	"github.com/u-root/u-root/pkg/kexec"
 	"github.com/u-root/u-root/pkg/multiboot"
@@ -42,6 +52,8 @@ type options struct {
 	debug        bool
 	acpi         string
 	modules      []string
+	db           []string
+	dbx          []string
 }
 
 func registerFlags() *options {
@@ -54,6 +66,8 @@ func registerFlags() *options {
 	flag.BoolVarP(&o.exec, "exec", "e", false, "Execute a currently loaded kernel")
 	flag.BoolVarP(&o.debug, "debug", "d", false, "Print debug info")
 	flag.StringSliceVar(&o.modules, "module", nil, `Load module with command line args (e.g --module="mod arg1")`)
+	flag.StringSliceVar(&o.db, "db", nil, "Only load kernels whose signature or hash is in these signature databases")
+	flag.StringSliceVar(&o.dbx, "dbx", nil, "Reject kernels whose hash or signer is in these signature databases")
 	return o
 }
 
@@ -63,6 +77,7 @@ type loader interface {
 
 type file struct {
 	initramfs string
+	verifier  *authenticode.Verifier
 }
 
 type mboot struct {
@@ -78,6 +93,19 @@ func (f file) Load(path, cmdLine string) error {
 	}
 	defer kernel.Close()
 
+	if f.verifier != nil {
+		image, err := ioutil.ReadAll(kernel)
+		if err != nil {
+			return fmt.Errorf("read(%q): %v", path, err)
+		}
+		if err := f.verifier.Verify(image); err != nil {
+			return fmt.Errorf("verifying %q: %v", path, err)
+		}
+		if _, err := kernel.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
 	var ramfs *os.File
 	if f.initramfs != "" {
 		ramfs, err = os.OpenFile(f.initramfs, os.O_RDONLY, 0)
@@ -89,6 +117,33 @@ func (f file) Load(path, cmdLine string) error {
 	return kexec.FileLoad(kernel, ramfs, cmdLine)
 }
 
+// verifier returns a Verifier for the given signature databases, or nil if
+// there is no db.
+func verifier(db, dbx []string) (*authenticode.Verifier, error) {
+	if len(db) == 0 {
+		if len(dbx) > 0 {
+			return nil, fmt.Errorf("--dbx requires --db")
+		}
+		return nil, nil
+	}
+	v := &authenticode.Verifier{}
+	for _, f := range db {
+		d, err := authenticode.ReadDB(f)
+		if err != nil {
+			return nil, err
+		}
+		v.DB.Add(d)
+	}
+	for _, f := range dbx {
+		d, err := authenticode.ReadDB(f)
+		if err != nil {
+			return nil, err
+		}
+		v.DBX.Add(d)
+	}
+	return v, nil
+}
+
 func (mb mboot) Load(path, cmdLine string) error {
 	// Trampoline should be a part of current binary.
 	p, err := os.Executable()
@@ -164,7 +219,14 @@ func main() {
 		log.Fatal("You can only specify -a when loading (-l) multiboot kernels")
 	}
 	if opts.load {
-		var l loader = file{initramfs: opts.initramfs}
+		v, err := verifier(opts.db, opts.dbx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var l loader = file{initramfs: opts.initramfs, verifier: v}
+		if v != nil && mbk {
+			log.Fatal("Signature verification is only supported for PE kernels, not multiboot")
+		}
 		if mbk {
 			log.Printf("%s is a multiboot v1 kernel.", kernelpath)
 			l = mboot{
@@ -1,74 +1,556 @@
-// Copyright 2012-2017 the u-root Authors. All rights reserved
+// Copyright 2012-2019 the u-root Authors. All rights reserved
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
 // Netcat pipes over the network.
+//
+// Synopsis:
+//     netcat [OPTIONS...] ADDRESS
+//     netcat [OPTIONS...] HOST PORT
+//     netcat -l [OPTIONS...] [[HOST] PORT]
+//
+// Description:
+//     Netcat connects to ADDRESS, a Go style network address such as
+//     host:port or a unix socket path, or to PORT on HOST as with OpenBSD
+//     nc, and copies its input to the connection and the connection to its
+//     output. When the input ends, the sending side of the connection is
+//     shut down and netcat waits for the other end to finish.
+//
+//     With -l, netcat listens on the address instead and serves one
+//     connection, or any number with -k.
+//
+//     With -z, netcat only checks whether anything listens. PORT may then
+//     be a list of ports and ranges, like 20-25,80.
+//
+// Options:
+//     -net: network, e.g. tcp, udp, unix
+//     -u:   use UDP
+//     -U:   use unix sockets
+//     -4:   use IPv4 only
+//     -6:   use IPv6 only
+//     -l:   listen for connections
+//     -k:   keep listening after a connection ends
+//     -z:   scan for listening daemons without sending data
+//     -w:   timeout in seconds for connecting and for idle connections
+//     -s:   source address
+//     -p:   source port
+//     -e:   run a program with its input and output connected to the network
+//     -c:   like -e, but run a /bin/sh command
+//     -x:   connect through the proxy at this address
+//     -X:   proxy protocol, 5 for SOCKS v.5 or connect for HTTPS
+//     -v:   verbose output
+//
+// Example:
+//     netcat -z -w 1 10.0.0.1 20-25,80
+//     netcat -l -k -e /bin/cat 7777
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/u-root/u-root/pkg/uroot/util"
 )
 
-const usage = "netcat [go-style network address]"
+const usage = "netcat [flags] [go-style network address | host port]"
 
 var (
-	netType = flag.String("net", "tcp", "What net type to use, e.g. tcp, unix, etc.")
-	listen  = flag.Bool("l", false, "Listen for connections.")
-	verbose = flag.Bool("v", false, "Verbose output.")
+	netType   = flag.String("net", "tcp", "What net type to use, e.g. tcp, unix, etc.")
+	listen    = flag.Bool("l", false, "Listen for connections.")
+	verbose   = flag.Bool("v", false, "Verbose output.")
+	udp       = flag.Bool("u", false, "Use UDP.")
+	unixSock  = flag.Bool("U", false, "Use unix sockets.")
+	ipv4      = flag.Bool("4", false, "Use IPv4 only.")
+	ipv6      = flag.Bool("6", false, "Use IPv6 only.")
+	keep      = flag.Bool("k", false, "Keep listening after a connection ends.")
+	zero      = flag.Bool("z", false, "Scan for listening daemons without sending data.")
+	timeout   = flag.Int("w", 0, "Timeout in seconds for connecting and for idle connections.")
+	source    = flag.String("s", "", "Source address.")
+	srcPort   = flag.String("p", "", "Source port.")
+	execProg  = flag.String("e", "", "Run a program with its input and output connected to the network.")
+	execCmd   = flag.String("c", "", "Like -e, but run a /bin/sh command.")
+	proxy     = flag.String("x", "", "Connect through the proxy at this address.")
+	proxyType = flag.String("X", "5", `Proxy protocol, "5" for SOCKS v.5 or "connect" for HTTPS.`)
 )
 
 func init() {
 	util.Usage(usage)
 }
 
-func main() {
-	var c net.Conn
-	var err error
-	if flag.Parse(); len(flag.Args()) != 1 {
-		flag.Usage()
-		os.Exit(1)
-	}
+// options says how to connect.
+type options struct {
+	network   string
+	listen    bool
+	keep      bool
+	zero      bool
+	verbose   bool
+	timeout   time.Duration
+	source    string
+	exec      []string
+	proxy     string
+	proxyType string
+}
 
-	addr := flag.Args()[0]
+// parseFlags returns the options given on the command line.
+func parseFlags() (*options, error) {
+	o := &options{
+		network:   *netType,
+		listen:    *listen,
+		keep:      *keep,
+		zero:      *zero,
+		verbose:   *verbose,
+		timeout:   time.Duration(*timeout) * time.Second,
+		proxy:     *proxy,
+		proxyType: *proxyType,
+	}
+	switch {
+	case *unixSock && *udp:
+		o.network = "unixgram"
+	case *unixSock:
+		o.network = "unix"
+	case *udp:
+		o.network = "udp"
+	}
+	if *ipv4 || *ipv6 {
+		if *ipv4 && *ipv6 {
+			return nil, errors.New("-4 and -6 exclude each other")
+		}
+		if o.network != "tcp" && o.network != "udp" {
+			return nil, fmt.Errorf("-4 and -6 do not apply to %s", o.network)
+		}
+		if *ipv4 {
+			o.network += "4"
+		} else {
+			o.network += "6"
+		}
+	}
+	switch {
+	case *srcPort != "":
+		o.source = net.JoinHostPort(*source, *srcPort)
+	case *source != "" && strings.HasPrefix(o.network, "unix"):
+		o.source = *source
+	case *source != "":
+		o.source = net.JoinHostPort(*source, "0")
+	}
+	switch {
+	case *execProg != "" && *execCmd != "":
+		return nil, errors.New("-e and -c exclude each other")
+	case *execProg != "":
+		o.exec = strings.Fields(*execProg)
+	case *execCmd != "":
+		o.exec = []string{"/bin/sh", "-c", *execCmd}
+	}
+	if o.zero && (o.listen || o.exec != nil) {
+		return nil, errors.New("-z cannot be used with -l, -e or -c")
+	}
+	if o.keep && !o.listen {
+		return nil, errors.New("-k needs -l")
+	}
+	return o, nil
+}
 
-	if *listen {
-		ln, err := net.Listen(*netType, addr)
+// portList expands a list of ports and port ranges, like 20-25,80.
+func portList(s string) ([]string, error) {
+	var ports []string
+	for _, r := range strings.Split(s, ",") {
+		if r == "" {
+			return nil, fmt.Errorf("bad port list %q", s)
+		}
+		i := strings.IndexByte(r, '-')
+		if i < 0 {
+			ports = append(ports, r)
+			continue
+		}
+		lo, err := strconv.Atoi(r[:i])
 		if err != nil {
-			log.Fatalln(err)
+			// A service name like ms-sql-s.
+			ports = append(ports, r)
+			continue
+		}
+		hi, err := strconv.Atoi(r[i+1:])
+		if err != nil || lo < 1 || hi > 65535 || lo > hi {
+			return nil, fmt.Errorf("bad port range %q", r)
 		}
-		if *verbose {
-			fmt.Fprintln(os.Stderr, "Listening on", ln.Addr())
+		for p := lo; p <= hi; p++ {
+			ports = append(ports, strconv.Itoa(p))
+		}
+	}
+	return ports, nil
+}
+
+// addresses returns the addresses named by args, which are either a Go
+// style network address or a host and a port. A listener may leave out the
+// host, and a scan may give several ports.
+func (o *options) addresses(args []string) ([]string, error) {
+	if strings.HasPrefix(o.network, "unix") {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s needs one socket path", o.network)
 		}
+		return args, nil
+	}
+	var host, port string
+	switch len(args) {
+	case 1:
+		var err error
+		if host, port, err = net.SplitHostPort(args[0]); err != nil {
+			if !o.listen {
+				return nil, err
+			}
+			host, port = "", args[0]
+		}
+	case 2:
+		host, port = args[0], args[1]
+	default:
+		return nil, errors.New("need an address, or a host and a port")
+	}
+	ports, err := portList(port)
+	if err != nil {
+		return nil, err
+	}
+	if len(ports) > 1 && !o.zero {
+		return nil, errors.New("several ports can only be scanned with -z")
+	}
+	var addrs []string
+	for _, p := range ports {
+		addrs = append(addrs, net.JoinHostPort(host, p))
+	}
+	return addrs, nil
+}
 
-		c, err = ln.Accept()
+// dial connects to addr.
+func (o *options) dial(addr string) (net.Conn, error) {
+	d := &net.Dialer{Timeout: o.timeout}
+	if o.source != "" {
+		var err error
+		switch {
+		case strings.HasPrefix(o.network, "tcp"):
+			d.LocalAddr, err = net.ResolveTCPAddr(o.network, o.source)
+		case strings.HasPrefix(o.network, "udp"):
+			d.LocalAddr, err = net.ResolveUDPAddr(o.network, o.source)
+		case strings.HasPrefix(o.network, "unix"):
+			d.LocalAddr, err = net.ResolveUnixAddr(o.network, o.source)
+		default:
+			err = fmt.Errorf("%s sockets cannot be bound to a source address", o.network)
+		}
 		if err != nil {
-			log.Fatalln(err)
+			return nil, err
 		}
-	} else {
-		if c, err = net.Dial(*netType, addr); err != nil {
-			log.Fatalln(err)
+	}
+	if o.proxy != "" {
+		return proxyDial(d, o.network, o.proxyType, o.proxy, addr)
+	}
+	return d.Dial(o.network, addr)
+}
+
+// probe finds out whether a UDP port is open. Only an ICMP port
+// unreachable message, which comes back as an error on the socket, says
+// that it is not, so a few bytes have to be sent.
+func probe(c net.Conn, timeout time.Duration) error {
+	if timeout == 0 {
+		timeout = time.Second
+	}
+	c.SetDeadline(time.Now().Add(timeout))
+	for i := 0; i < 3; i++ {
+		if _, err := c.Write([]byte("X")); err != nil {
+			return err
 		}
 	}
-	if *verbose {
-		fmt.Fprintln(os.Stderr, "Connected to", c.RemoteAddr())
+	if _, err := c.Read(make([]byte, 1)); err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return nil
+		}
+		return err
 	}
+	return nil
+}
 
+// scan tries to connect to each of addrs, and reports whether any
+// connection succeeded. With verbose, the result for each is written to w.
+func (o *options) scan(addrs []string, w io.Writer) bool {
+	open := false
+	for _, a := range addrs {
+		c, err := o.dial(a)
+		if err == nil {
+			if strings.HasPrefix(o.network, "udp") {
+				err = probe(c, o.timeout)
+			}
+			c.Close()
+		}
+		if err != nil {
+			if o.verbose {
+				fmt.Fprintf(w, "Connection to %s failed: %v\n", a, err)
+			}
+			continue
+		}
+		open = true
+		if o.verbose {
+			fmt.Fprintf(w, "Connection to %s [%s] succeeded!\n", a, o.network)
+		}
+	}
+	return open
+}
+
+// idleConn times out when no data passes in either direction for too long.
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c idleConn) Read(b []byte) (int, error) {
+	c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Read(b)
+}
+
+func (c idleConn) Write(b []byte) (int, error) {
+	c.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(b)
+}
+
+// linger is how long pipe still copies the input after the other end has
+// finished, as the other end may well still listen.
+const linger = 500 * time.Millisecond
+
+// pipe copies in to c and c to out, until the other end has nothing more
+// to say. When in ends, the sending side of c is shut down, so that the
+// other end sees the end of the stream but can still answer.
+func pipe(c net.Conn, in io.Reader, out io.Writer, timeout time.Duration) error {
+	var rw io.ReadWriter = c
+	if timeout > 0 {
+		rw = idleConn{c, timeout}
+	}
+	errc := make(chan error, 1)
 	go func() {
-		if _, err := io.Copy(c, os.Stdin); err != nil {
-			fmt.Fprintln(os.Stderr, err)
+		_, err := io.Copy(rw, in)
+		if cw, ok := c.(interface{ CloseWrite() error }); ok && err == nil {
+			err = cw.CloseWrite()
 		}
+		errc <- err
 	}()
-	if _, err = io.Copy(os.Stdout, c); err != nil {
-		fmt.Fprintln(os.Stderr, err)
+	if _, err := io.Copy(out, rw); err != nil {
+		// Like OpenBSD nc, an idle connection just ends.
+		if ne, ok := err.(net.Error); ok && ne.Timeout() && timeout > 0 {
+			return nil
+		}
+		return err
+	}
+	t := time.NewTimer(linger)
+	defer t.Stop()
+	select {
+	case err := <-errc:
+		return err
+	case <-t.C:
+		return nil
 	}
-	if *verbose {
+}
+
+// run runs argv with its input and output connected to c.
+func run(c net.Conn, argv []string) error {
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stderr = os.Stderr
+
+	// Sockets are handed over as they are, so that the program sees the
+	// other end close its side.
+	if f, ok := c.(interface{ File() (*os.File, error) }); ok {
+		if s, err := f.File(); err == nil {
+			defer s.Close()
+			cmd.Stdin, cmd.Stdout = s, s
+			return cmd.Run()
+		}
+	}
+	cmd.Stdout = c
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go func() {
+		io.Copy(w, c)
+		w.Close()
+	}()
+	return cmd.Wait()
+}
+
+// handle talks over c, and closes it when done.
+func (o *options) handle(c net.Conn) error {
+	defer c.Close()
+	var err error
+	if o.exec != nil {
+		err = run(c, o.exec)
+	} else {
+		err = pipe(c, os.Stdin, os.Stdout, o.timeout)
+	}
+	if o.verbose {
 		fmt.Fprintln(os.Stderr, "Disconnected")
 	}
+	return err
+}
+
+// serve accepts connections on l and hands them to handle: one, or with
+// keep, any number. Programs run for several connections at once; the
+// standard input and output serve one connection after the other.
+func (o *options) serve(l net.Listener, handle func(net.Conn) error) error {
+	defer l.Close()
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		if o.verbose {
+			fmt.Fprintln(os.Stderr, "Connection received from", c.RemoteAddr())
+		}
+		switch {
+		case !o.keep:
+			l.Close()
+			return handle(c)
+		case o.exec != nil:
+			go func() {
+				if err := handle(c); err != nil {
+					log.Print(err)
+				}
+			}()
+		default:
+			if err := handle(c); err != nil {
+				log.Print(err)
+			}
+		}
+	}
+}
+
+// packetConn is a listening datagram socket that, like a connected one,
+// talks to a single peer: the sender of the first datagram or, with keep,
+// of the latest.
+type packetConn struct {
+	net.PacketConn
+	keep bool
+
+	mu      sync.Mutex
+	peer    net.Addr
+	pending []byte
+}
+
+func (c *packetConn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	if c.pending != nil {
+		n := copy(b, c.pending)
+		c.pending = nil
+		c.mu.Unlock()
+		return n, nil
+	}
+	c.mu.Unlock()
+	for {
+		n, peer, err := c.ReadFrom(b)
+		if err != nil {
+			return n, err
+		}
+		c.mu.Lock()
+		ok := c.keep || peer.String() == c.peer.String()
+		if ok {
+			c.peer = peer
+		}
+		c.mu.Unlock()
+		if ok {
+			return n, nil
+		}
+	}
+}
+
+func (c *packetConn) Write(b []byte) (int, error) {
+	return c.WriteTo(b, c.RemoteAddr())
+}
+
+func (c *packetConn) RemoteAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.peer
+}
+
+// servePacket waits for a datagram on pc and hands pc to handle.
+func (o *options) servePacket(pc net.PacketConn, handle func(net.Conn) error) error {
+	b := make([]byte, 64*1024)
+	n, peer, err := pc.ReadFrom(b)
+	if err != nil {
+		pc.Close()
+		return err
+	}
+	if o.verbose {
+		fmt.Fprintln(os.Stderr, "Connection received from", peer)
+	}
+	return handle(&packetConn{PacketConn: pc, keep: o.keep, peer: peer, pending: b[:n]})
+}
+
+// listenAndServe listens on addr and serves what arrives.
+func (o *options) listenAndServe(addr string) error {
+	switch o.network {
+	case "udp", "udp4", "udp6", "unixgram":
+		pc, err := net.ListenPacket(o.network, addr)
+		if err != nil {
+			return err
+		}
+		if o.verbose {
+			fmt.Fprintln(os.Stderr, "Listening on", pc.LocalAddr())
+		}
+		return o.servePacket(pc, o.handle)
+	}
+	l, err := net.Listen(o.network, addr)
+	if err != nil {
+		return err
+	}
+	if o.verbose {
+		fmt.Fprintln(os.Stderr, "Listening on", l.Addr())
+	}
+	return o.serve(l, o.handle)
+}
+
+func main() {
+	flag.Parse()
+	o, err := parseFlags()
+	if err != nil {
+		log.Fatal(err)
+	}
+	args := flag.Args()
+	if o.listen && len(args) == 0 && o.source != "" {
+		// Like traditional nc -l -p PORT.
+		args, o.source = []string{o.source}, ""
+	}
+	addrs, err := o.addresses(args)
+	if err != nil {
+		log.Print(err)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	switch {
+	case o.zero:
+		if !o.scan(addrs, os.Stderr) {
+			os.Exit(1)
+		}
+	case o.listen:
+		if o.source != "" {
+			log.Fatal("-s and -p name the listening address when no address is given")
+		}
+		err = o.listenAndServe(addrs[0])
+	default:
+		var c net.Conn
+		if c, err = o.dial(addrs[0]); err == nil {
+			if o.verbose {
+				fmt.Fprintln(os.Stderr, "Connected to", c.RemoteAddr())
+			}
+			err = o.handle(c)
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,421 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/u-root/u-root/pkg/testutil"
+)
+
+func TestPortList(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want []string
+	}{
+		{in: "80", want: []string{"80"}},
+		{in: "http", want: []string{"http"}},
+		{in: "20-22,80", want: []string{"20", "21", "22", "80"}},
+		{in: "ms-sql-s", want: []string{"ms-sql-s"}},
+		{in: "22-20"},
+		{in: "0-2"},
+		{in: "65535-65536"},
+		{in: "80,"},
+	} {
+		got, err := portList(tt.in)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("portList(%q) = %v, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("portList(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestAddresses(t *testing.T) {
+	for _, tt := range []struct {
+		o    options
+		args []string
+		want []string
+	}{
+		{o: options{network: "tcp"}, args: []string{"localhost:80"}, want: []string{"localhost:80"}},
+		{o: options{network: "tcp"}, args: []string{"localhost", "80"}, want: []string{"localhost:80"}},
+		{o: options{network: "tcp6"}, args: []string{"::1", "80"}, want: []string{"[::1]:80"}},
+		{o: options{network: "tcp", listen: true}, args: []string{"80"}, want: []string{":80"}},
+		{o: options{network: "tcp", zero: true}, args: []string{"h", "1-2"}, want: []string{"h:1", "h:2"}},
+		{o: options{network: "unix"}, args: []string{"/tmp/s"}, want: []string{"/tmp/s"}},
+		{o: options{network: "tcp"}, args: []string{"80"}},
+		{o: options{network: "tcp"}, args: []string{"h", "1-2"}},
+		{o: options{network: "tcp"}, args: []string{"a", "b", "c"}},
+		{o: options{network: "tcp"}},
+		{o: options{network: "unix"}, args: []string{"a", "b"}},
+	} {
+		got, err := tt.o.addresses(tt.args)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("%+v.addresses(%q) = %v, want error", tt.o, tt.args, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%+v.addresses(%q) = %v, %v, want %v", tt.o, tt.args, got, err, tt.want)
+		}
+	}
+}
+
+// answer serves one connection on l: it reads until the end of the
+// stream, then answers with what it got.
+func answer(t *testing.T, l net.Listener) {
+	c, err := l.Accept()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer c.Close()
+	b, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fmt.Fprintf(c, "got %q", b)
+}
+
+func TestPipe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netcat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tt := range []struct {
+		network string
+		addr    string
+		timeout time.Duration
+	}{
+		{network: "tcp", addr: "127.0.0.1:0"},
+		{network: "tcp", addr: "127.0.0.1:0", timeout: time.Second},
+		{network: "unix", addr: filepath.Join(dir, "sock")},
+	} {
+		t.Run(tt.network, func(t *testing.T) {
+			l, err := net.Listen(tt.network, tt.addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			go answer(t, l)
+
+			o := &options{network: tt.network}
+			c, err := o.dial(l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			var out bytes.Buffer
+			if err := pipe(c, strings.NewReader("hello"), &out, tt.timeout); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := out.String(), `got "hello"`; got != want {
+				t.Errorf("pipe() got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go answer(t, l)
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	// The input never ends, so neither end says anything.
+	in, w := io.Pipe()
+	defer w.Close()
+	start := time.Now()
+	if err := pipe(c, in, ioutil.Discard, 100*time.Millisecond); err != nil {
+		t.Errorf("pipe() = %v, want nil", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("pipe() took %v, want about 100ms", d)
+	}
+}
+
+func TestSource(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	o := &options{network: "tcp", source: "127.0.0.2:0"}
+	c, err := o.dial(l.Addr().String())
+	if err != nil {
+		t.Skipf("Cannot bind to 127.0.0.2: %v", err)
+	}
+	defer c.Close()
+	s, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got := s.RemoteAddr().(*net.TCPAddr).IP.String(); got != "127.0.0.2" {
+		t.Errorf("Connection came from %s, want 127.0.0.2", got)
+	}
+}
+
+// freePort returns the address of a port nothing listens on.
+func freePort(t *testing.T, network string) string {
+	var addr string
+	if strings.HasPrefix(network, "udp") {
+		c, err := net.ListenPacket(network, "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr = c.LocalAddr().String()
+		c.Close()
+	} else {
+		l, err := net.Listen(network, "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr = l.Addr().String()
+		l.Close()
+	}
+	return addr
+}
+
+func TestScan(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	for _, tt := range []struct {
+		network string
+		addrs   []string
+		open    bool
+		lines   []string
+	}{
+		{
+			network: "tcp",
+			addrs:   []string{l.Addr().String(), freePort(t, "tcp")},
+			open:    true,
+			lines:   []string{"succeeded", "failed"},
+		},
+		{network: "tcp", addrs: []string{freePort(t, "tcp")}, lines: []string{"failed"}},
+		{
+			network: "udp",
+			addrs:   []string{pc.LocalAddr().String(), freePort(t, "udp")},
+			open:    true,
+			lines:   []string{"succeeded", "failed"},
+		},
+		{network: "udp", addrs: []string{freePort(t, "udp")}, lines: []string{"failed"}},
+	} {
+		o := &options{network: tt.network, zero: true, verbose: true, timeout: 200 * time.Millisecond}
+		var b bytes.Buffer
+		if got := o.scan(tt.addrs, &b); got != tt.open {
+			t.Errorf("scan(%s %v) = %v, want %v", tt.network, tt.addrs, got, tt.open)
+		}
+		lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+		if len(lines) != len(tt.lines) {
+			t.Errorf("scan(%s %v) said %q, want %d lines", tt.network, tt.addrs, b.String(), len(tt.lines))
+			continue
+		}
+		for i, want := range tt.lines {
+			if !strings.Contains(lines[i], want) {
+				t.Errorf("scan(%s %v) said %q, want %q", tt.network, tt.addrs, lines[i], want)
+			}
+		}
+	}
+}
+
+func TestServeKeep(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	o := &options{network: "tcp", listen: true, keep: true}
+	n := 0
+	go o.serve(l, func(c net.Conn) error {
+		defer c.Close()
+		n++
+		_, err := fmt.Fprintf(c, "connection %d", n)
+		return err
+	})
+	defer l.Close()
+
+	for i := 1; i <= 3; i++ {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(c)
+		c.Close()
+		if want := fmt.Sprintf("connection %d", i); err != nil || string(b) != want {
+			t.Errorf("Connection %d got %q, %v, want %q", i, b, err, want)
+		}
+	}
+}
+
+func TestServeOnce(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	o := &options{network: "tcp", listen: true}
+	errc := make(chan error)
+	go func() {
+		errc <- o.serve(l, func(c net.Conn) error { return c.Close() })
+	}()
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if err := <-errc; err != nil {
+		t.Errorf("serve() = %v", err)
+	}
+	if c, err := net.Dial("tcp", addr); err == nil {
+		c.Close()
+		t.Errorf("Listener still accepts connections after the first one")
+	}
+}
+
+func TestServePacket(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := &options{network: "udp", listen: true}
+	go o.servePacket(pc, func(c net.Conn) error {
+		defer c.Close()
+		b := make([]byte, 100)
+		for {
+			n, err := c.Read(b)
+			if err != nil {
+				return err
+			}
+			if _, err := c.Write(bytes.ToUpper(b[:n])); err != nil {
+				return err
+			}
+		}
+	})
+	defer pc.Close()
+
+	c, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	b := make([]byte, 100)
+	for _, s := range []string{"first", "second"} {
+		if _, err := c.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+		n, err := c.Read(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(b[:n]), strings.ToUpper(s); got != want {
+			t.Errorf("Got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := &options{network: "tcp", listen: true, keep: true, exec: []string{"/bin/sh", "-c", "tr a-z A-Z"}}
+	go o.serve(l, o.handle)
+	defer l.Close()
+
+	// The program handles several connections at once.
+	var conns []net.Conn
+	for i := 0; i < 2; i++ {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		conns = append(conns, c)
+	}
+	for i, c := range conns {
+		fmt.Fprintf(c, "hello %d", i)
+		c.(*net.TCPConn).CloseWrite()
+	}
+	for i, c := range conns {
+		b, err := ioutil.ReadAll(c)
+		if want := fmt.Sprintf("HELLO %d", i); err != nil || string(b) != want {
+			t.Errorf("Connection %d got %q, %v, want %q", i, b, err, want)
+		}
+	}
+}
+
+func TestCommand(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go answer(t, l)
+
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	c := testutil.Command(t, host, port)
+	c.Stdin = strings.NewReader("hello")
+	out, err := c.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(out), `got "hello"`; got != want {
+		t.Errorf("netcat got %q, want %q", got, want)
+	}
+
+	for _, tt := range []struct {
+		port string
+		code int
+	}{
+		{port: port, code: 0},
+		{port: strings.TrimPrefix(freePort(t, "tcp"), "127.0.0.1:"), code: 1},
+	} {
+		err := testutil.Command(t, "-z", "-w", "1", host, tt.port).Run()
+		if err := testutil.IsExitCode(err, tt.code); err != nil {
+			t.Errorf("netcat -z %s %s: %v", host, tt.port, err)
+		}
+	}
+}
+
+func TestMain(m *testing.M) {
+	testutil.Run(m, main)
+}
@@ -0,0 +1,175 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// proxyDial connects to addr through proxy, which speaks SOCKS v.5 (proto
+// "5") or HTTP CONNECT (proto "connect").
+func proxyDial(d *net.Dialer, network, proto, proxy, addr string) (net.Conn, error) {
+	if !strings.HasPrefix(network, "tcp") {
+		return nil, fmt.Errorf("proxies only carry tcp, not %s", network)
+	}
+	var port string
+	switch proto {
+	case "5":
+		port = "1080"
+	case "connect":
+		port = "3128"
+	default:
+		return nil, fmt.Errorf("unknown proxy protocol %q", proto)
+	}
+	if _, _, err := net.SplitHostPort(proxy); err != nil {
+		proxy = net.JoinHostPort(proxy, port)
+	}
+
+	c, err := d.Dial(network, proxy)
+	if err != nil {
+		return nil, err
+	}
+	if d.Timeout > 0 {
+		c.SetDeadline(time.Now().Add(d.Timeout))
+	}
+	if proto == "5" {
+		err = socks5(c, addr)
+	} else {
+		err = httpConnect(c, addr)
+	}
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("proxy %s: %v", proxy, err)
+	}
+	c.SetDeadline(time.Time{})
+	return c, nil
+}
+
+// socksErrors explains SOCKS v.5 reply codes, RFC 1928, Section 6.
+var socksErrors = []string{
+	1: "general failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// socks5 asks the SOCKS v.5 server at the other end of c to connect to
+// addr, without authentication.
+func socks5(c net.Conn, addr string) error {
+	host, portName, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := net.LookupPort("tcp", portName)
+	if err != nil {
+		return err
+	}
+
+	// Version 5, one method: no authentication.
+	if _, err := c.Write([]byte{5, 1, 0}); err != nil {
+		return err
+	}
+	b := make([]byte, 2)
+	if _, err := io.ReadFull(c, b); err != nil {
+		return err
+	}
+	if b[0] != 5 || b[1] != 0 {
+		return errors.New("SOCKS server wants authentication")
+	}
+
+	// Version 5, CONNECT, reserved, address, port.
+	req := []byte{5, 1, 0}
+	ip := net.ParseIP(host)
+	switch {
+	case ip.To4() != nil:
+		req = append(append(req, 1), ip.To4()...)
+	case ip != nil:
+		req = append(append(req, 4), ip...)
+	case len(host) > 255:
+		return fmt.Errorf("host name %q too long", host)
+	default:
+		req = append(append(req, 3, byte(len(host))), host...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := c.Write(req); err != nil {
+		return err
+	}
+
+	// The reply has the same form, with the address the server bound.
+	b = make([]byte, 4)
+	if _, err := io.ReadFull(c, b); err != nil {
+		return err
+	}
+	if b[0] != 5 {
+		return fmt.Errorf("bad SOCKS version %d in reply", b[0])
+	}
+	if b[1] != 0 {
+		if int(b[1]) < len(socksErrors) {
+			return fmt.Errorf("SOCKS connect: %s", socksErrors[b[1]])
+		}
+		return fmt.Errorf("SOCKS connect: error %d", b[1])
+	}
+	var n int
+	switch b[3] {
+	case 1:
+		n = net.IPv4len
+	case 4:
+		n = net.IPv6len
+	case 3:
+		if _, err := io.ReadFull(c, b[:1]); err != nil {
+			return err
+		}
+		n = int(b[0])
+	default:
+		return fmt.Errorf("bad SOCKS address type %d in reply", b[3])
+	}
+	_, err = io.ReadFull(c, make([]byte, n+2))
+	return err
+}
+
+// byteReader reads a byte at a time, so that a bufio.Reader on top of it
+// takes nothing from the connection beyond what it is asked for.
+type byteReader struct {
+	io.Reader
+}
+
+func (r byteReader) Read(b []byte) (int, error) {
+	if len(b) > 1 {
+		b = b[:1]
+	}
+	return r.Reader.Read(b)
+}
+
+// httpConnect asks the HTTP proxy at the other end of c to connect to addr.
+func httpConnect(c net.Conn, addr string) error {
+	if _, err := fmt.Fprintf(c, "CONNECT %s HTTP/1.0\r\nHost: %s\r\n\r\n", addr, addr); err != nil {
+		return err
+	}
+	r := textproto.NewReader(bufio.NewReaderSize(byteReader{c}, 16))
+	line, err := r.ReadLine()
+	if err != nil {
+		return err
+	}
+	f := strings.SplitN(line, " ", 2)
+	if len(f) != 2 || !strings.HasPrefix(f[0], "HTTP/") {
+		return fmt.Errorf("bad reply %q", line)
+	}
+	if !strings.HasPrefix(f[1], "200") {
+		return fmt.Errorf("CONNECT %s: %s", addr, f[1])
+	}
+	_, err = r.ReadMIMEHeader()
+	return err
+}
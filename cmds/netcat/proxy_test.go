@@ -0,0 +1,174 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/uio"
+)
+
+// socksServer serves one SOCKS v.5 CONNECT request on l.
+func socksServer(t *testing.T, l net.Listener) {
+	c, err := l.Accept()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	b := make([]byte, 262)
+	if _, err := io.ReadFull(c, b[:3]); err != nil || string(b[:3]) != "\x05\x01\x00" {
+		t.Errorf("SOCKS greeting %q, %v", b[:3], err)
+		c.Close()
+		return
+	}
+	c.Write([]byte{5, 0})
+	if _, err := io.ReadFull(c, b[:4]); err != nil {
+		t.Error(err)
+		c.Close()
+		return
+	}
+	var host string
+	switch b[3] {
+	case 1:
+		io.ReadFull(c, b[:4])
+		host = net.IP(b[:4]).String()
+	case 3:
+		io.ReadFull(c, b[:1])
+		n := int(b[0])
+		io.ReadFull(c, b[:n])
+		host = string(b[:n])
+	}
+	io.ReadFull(c, b[:2])
+	addr := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(b))))
+	s, err := net.Dial("tcp", addr)
+	if err != nil {
+		// Connection refused.
+		c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		c.Close()
+		return
+	}
+	c.Write([]byte{5, 0, 0, 3, 4, 'h', 'o', 's', 't', 0, 1})
+	uio.Relay(c, s)
+}
+
+// connectServer serves one HTTP CONNECT request on l.
+func connectServer(t *testing.T, l net.Listener) {
+	c, err := l.Accept()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	req, err := http.ReadRequest(bufio.NewReader(c))
+	if err != nil || req.Method != "CONNECT" {
+		t.Errorf("CONNECT request %v, %v", req, err)
+		c.Close()
+		return
+	}
+	s, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		io.WriteString(c, "HTTP/1.0 502 Bad Gateway\r\n\r\n")
+		c.Close()
+		return
+	}
+	// The answer to the request is sent right after the reply, so that
+	// the client must not read too much of the reply.
+	io.WriteString(c, "HTTP/1.0 200 Connection established\r\nProxy-Agent: test\r\n\r\n")
+	uio.Relay(c, s)
+}
+
+func TestProxy(t *testing.T) {
+	for _, tt := range []struct {
+		proto  string
+		server func(*testing.T, net.Listener)
+	}{
+		{proto: "5", server: socksServer},
+		{proto: "connect", server: connectServer},
+	} {
+		t.Run(tt.proto, func(t *testing.T) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			p, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+
+			// The server speaks first.
+			go func() {
+				c, err := l.Accept()
+				if err != nil {
+					return
+				}
+				io.WriteString(c, "hello from ")
+				io.Copy(c, c)
+				c.Close()
+			}()
+			go tt.server(t, p)
+
+			o := &options{network: "tcp", proxy: p.Addr().String(), proxyType: tt.proto}
+			c, err := o.dial(l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			var out strings.Builder
+			if err := pipe(c, strings.NewReader("the server"), &out, 0); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := out.String(), "hello from the server"; got != want {
+				t.Errorf("Got %q, want %q", got, want)
+			}
+
+			// Refused connections are reported.
+			go tt.server(t, p)
+			if c, err := o.dial(freePort(t, "tcp")); err == nil {
+				c.Close()
+				t.Errorf("dial(closed port) through the proxy succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestProxyErrors(t *testing.T) {
+	d := &net.Dialer{}
+	if _, err := proxyDial(d, "udp", "5", "127.0.0.1", "127.0.0.1:1"); err == nil {
+		t.Errorf("proxyDial(udp) succeeded, want error")
+	}
+	if _, err := proxyDial(d, "tcp", "4", "127.0.0.1", "127.0.0.1:1"); err == nil {
+		t.Errorf("proxyDial(SOCKS 4) succeeded, want error")
+	}
+
+	// A proxy that wants a password.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		io.ReadFull(c, make([]byte, 3))
+		c.Write([]byte{5, 0xff})
+		ioutil.ReadAll(c)
+		c.Close()
+	}()
+	_, err = proxyDial(d, "tcp", "5", l.Addr().String(), "example.com:80")
+	if err == nil || !strings.Contains(err.Error(), "authentication") {
+		t.Errorf("proxyDial() = %v, want authentication error", err)
+	}
+}
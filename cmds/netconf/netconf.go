@@ -0,0 +1,79 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// netconf configures the network from a declarative description.
+//
+// Synopsis:
+//     netconf [OPTIONS...] [FILE]
+//
+// Description:
+//     netconf applies the JSON network configuration in FILE, or, with
+//     -cmdline, the ip=, vlan=, bond=, bridge=, nameserver= and rd.route=
+//     parameters on the kernel command line. See pkg/netconf for the
+//     format of both.
+//
+// Options:
+//     -cmdline: use the kernel command line, or the given one with -line
+//     -line:    kernel command line to use instead of /proc/cmdline
+//     -n:       print the configuration as JSON instead of applying it
+//     -timeout: how long to wait for DHCP
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/u-root/u-root/pkg/cmdline"
+	"github.com/u-root/u-root/pkg/netconf"
+)
+
+var (
+	fromCmdline = flag.Bool("cmdline", false, "Use the kernel command line")
+	line        = flag.String("line", "", "Kernel command line to use instead of /proc/cmdline")
+	dryRun      = flag.Bool("n", false, "Print the configuration as JSON instead of applying it")
+	timeout     = flag.Duration("timeout", time.Minute, "How long to wait for DHCP")
+)
+
+func config() (*netconf.Config, error) {
+	switch {
+	case *fromCmdline && flag.NArg() == 0:
+		l := *line
+		if l == "" {
+			l = cmdline.FullCmdLine()
+		}
+		return netconf.ParseCmdline(l)
+	case !*fromCmdline && flag.NArg() == 1:
+		return netconf.Load(flag.Arg(0))
+	}
+	return nil, fmt.Errorf("usage: netconf [-n] [-timeout DURATION] {FILE | -cmdline [-line LINE]}")
+}
+
+func main() {
+	flag.Parse()
+	c, err := config()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *dryRun {
+		b, err := json.MarshalIndent(c, "", "\t")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s\n", b)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	if err := netconf.Apply(ctx, c); err != nil {
+		log.Print(err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,343 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// netroot attaches an iSCSI or NBD volume, usually the root file system.
+//
+// Synopsis:
+//     netroot [OPTIONS...] [netroot=...]
+//
+// Description:
+//     netroot attaches the volume of the netroot= argument, or of the
+//     netroot= parameter of the kernel command line, and prints its block
+//     device. With rd.iscsi.firmware=1 (or rd.iscsi.ibft=1) on the command
+//     line, or -ibft, the boot target of the firmware's iBFT is attached
+//     instead. The network is first configured from the ip= parameters of
+//     the command line, as netconf -cmdline does, or from the iBFT.
+//
+//     The volumes are given as dracut does:
+//         netroot=iscsi:[USER:PASSWORD@]SERVER:[PROTOCOL]:[PORT]:[LUN]:TARGET
+//         netroot=nbd:SERVER[:PORT]/EXPORT
+//     rd.iscsi.initiator= sets the initiator name, and rd.iscsi.username=
+//     and rd.iscsi.password= the CHAP credentials.
+//
+//     iSCSI volumes are served by the kernel's iscsi_tcp driver, which
+//     must be loaded. NBD volumes are served by the nbd driver with netroot
+//     running, so it does not exit until the device is disconnected.
+//
+// Options:
+//     -ibft:      attach the boot target of the iBFT
+//     -line:      kernel command line to use instead of /proc/cmdline
+//     -initiator: iSCSI initiator name
+//     -nbd:       NBD device to use
+//     -timeout:   how long to wait for the network and the volume
+//     -n:         print what would be attached instead
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/u-root/u-root/pkg/cmdline"
+	"github.com/u-root/u-root/pkg/iscsi"
+	"github.com/u-root/u-root/pkg/nbd"
+	"github.com/u-root/u-root/pkg/netconf"
+)
+
+var (
+	useIBFT   = flag.Bool("ibft", false, "Attach the boot target of the iBFT")
+	line      = flag.String("line", "", "Kernel command line to use instead of /proc/cmdline")
+	initiator = flag.String("initiator", "", "iSCSI initiator name")
+	nbdDevice = flag.String("nbd", "/dev/nbd0", "NBD device to use")
+	timeout   = flag.Duration("timeout", time.Minute, "How long to wait for the network and the volume")
+	dryRun    = flag.Bool("n", false, "Print what would be attached instead")
+)
+
+// defaultInitiator is the initiator name if none is given.
+const defaultInitiator = "iqn.2019-06.org.u-root:initiator"
+
+// nbdPort is the TCP port of NBD servers.
+const nbdPort = 10809
+
+// volume is a volume to attach.
+type volume struct {
+	iscsi     *iscsi.Target
+	initiator string
+
+	nbdAddr   string
+	nbdExport string
+
+	// net configures the network, or is nil.
+	net *netconf.Config
+}
+
+func (v *volume) String() string {
+	if v.iscsi != nil {
+		return fmt.Sprintf("iSCSI target %s LUN %d at %s as %s", v.iscsi.Name, v.iscsi.LUN, v.iscsi.Addr, v.initiator)
+	}
+	return fmt.Sprintf("NBD export %q at %s", v.nbdExport, v.nbdAddr)
+}
+
+// params returns the parameters of the command line that have values.
+func params(line string) map[string]string {
+	p := make(map[string]string)
+	for _, f := range strings.Fields(line) {
+		if kv := strings.SplitN(f, "=", 2); len(kv) == 2 {
+			p[kv[0]] = kv[1]
+		}
+	}
+	return p
+}
+
+// parseISCSI parses the rest of netroot=iscsi:.
+func parseISCSI(s string) (*iscsi.Target, error) {
+	t := &iscsi.Target{}
+	if i := strings.IndexByte(s, '@'); i >= 0 {
+		cred := strings.SplitN(s[:i], ":", 2)
+		if len(cred) != 2 {
+			return nil, fmt.Errorf("credentials are not USER:PASSWORD")
+		}
+		t.User, t.Secret = cred[0], cred[1]
+		s = s[i+1:]
+	}
+
+	var server string
+	if strings.HasPrefix(s, "[") {
+		i := strings.IndexByte(s, ']')
+		if i < 0 {
+			return nil, fmt.Errorf("missing ]")
+		}
+		server, s = s[1:i], strings.TrimPrefix(s[i+1:], ":")
+	} else {
+		i := strings.IndexByte(s, ':')
+		if i < 0 {
+			return nil, fmt.Errorf("missing fields")
+		}
+		server, s = s[:i], s[i+1:]
+	}
+	// The target name has colons too.
+	f := strings.SplitN(s, ":", 4)
+	if server == "" || len(f) != 4 || f[3] == "" {
+		return nil, fmt.Errorf("want [USER:PASSWORD@]SERVER:[PROTOCOL]:[PORT]:[LUN]:TARGET")
+	}
+	if f[0] != "" && f[0] != "6" {
+		return nil, fmt.Errorf("protocol %q is not TCP", f[0])
+	}
+	port := iscsi.DefaultPort
+	if f[1] != "" {
+		var err error
+		if port, err = strconv.Atoi(f[1]); err != nil {
+			return nil, fmt.Errorf("bad port %q", f[1])
+		}
+	}
+	if f[2] != "" {
+		lun, err := strconv.ParseUint(f[2], 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("bad LUN %q", f[2])
+		}
+		t.LUN = int(lun)
+	}
+	t.Addr = net.JoinHostPort(server, strconv.Itoa(port))
+	t.Name = f[3]
+	return t, nil
+}
+
+// parseNBD parses the rest of netroot=nbd:. Fields after the export, such
+// as the file system type, are ignored.
+func parseNBD(s string) (addr, export string, err error) {
+	hostPort := s
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		hostPort, export = s[:i], s[i+1:]
+		if j := strings.IndexByte(export, ':'); j >= 0 {
+			export = export[:j]
+		}
+	}
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		host, port = strings.Trim(hostPort, "[]"), ""
+	}
+	if port == "" {
+		port = strconv.Itoa(nbdPort)
+	}
+	if host == "" {
+		return "", "", fmt.Errorf("want SERVER[:PORT]/EXPORT")
+	}
+	return net.JoinHostPort(host, port), export, nil
+}
+
+// parseCmdline returns the volume of the netroot= parameter of line, which
+// if not empty is used instead of that of the command line.
+func parseCmdline(line, netroot string) (*volume, error) {
+	p := params(line)
+	if netroot == "" {
+		netroot = p["netroot"]
+	}
+	v := &volume{initiator: p["rd.iscsi.initiator"]}
+	if v.initiator == "" {
+		v.initiator = defaultInitiator
+	}
+	switch {
+	case strings.HasPrefix(netroot, "iscsi:"):
+		t, err := parseISCSI(strings.TrimPrefix(netroot, "iscsi:"))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", netroot, err)
+		}
+		if t.User == "" {
+			t.User, t.Secret = p["rd.iscsi.username"], p["rd.iscsi.password"]
+		}
+		v.iscsi = t
+	case strings.HasPrefix(netroot, "nbd:"):
+		var err error
+		v.nbdAddr, v.nbdExport, err = parseNBD(strings.TrimPrefix(netroot, "nbd:"))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", netroot, err)
+		}
+	case netroot == "":
+		return nil, fmt.Errorf("no netroot= parameter")
+	default:
+		return nil, fmt.Errorf("%s: not iscsi: or nbd:", netroot)
+	}
+
+	if netconf.HasCmdline(line) {
+		c, err := netconf.ParseCmdline(line)
+		if err != nil {
+			return nil, err
+		}
+		v.net = c
+	}
+	return v, nil
+}
+
+// fromIBFT returns the volume of the boot target of ibft, or of its first
+// target, and the configuration of the NIC it is reached by. interfaces
+// are the system's network interfaces.
+func fromIBFT(ibft *iscsi.IBFT, interfaces []net.Interface) (*volume, error) {
+	if len(ibft.Targets) == 0 {
+		return nil, fmt.Errorf("iBFT has no targets")
+	}
+	t := ibft.Targets[0]
+	for _, tgt := range ibft.Targets {
+		if tgt.Boot {
+			t = tgt
+			break
+		}
+	}
+	v := &volume{iscsi: &t.Target, initiator: ibft.Initiator}
+
+	for _, n := range ibft.NICs {
+		if n.Index != t.NIC {
+			continue
+		}
+		var name string
+		for _, i := range interfaces {
+			if i.HardwareAddr.String() == n.MAC.String() {
+				name = i.Name
+			}
+		}
+		if name == "" {
+			return nil, fmt.Errorf("no interface with the iBFT NIC's address %s", n.MAC)
+		}
+		i := netconf.Interface{Name: name, DHCP4: n.IP == nil}
+		if n.IP != nil {
+			i.Addresses = []string{n.IP.String()}
+		}
+		if n.Gateway != nil {
+			i.Gateway = n.Gateway.String()
+		}
+		v.net = &netconf.Config{Interfaces: []netconf.Interface{i}}
+		for _, dns := range n.DNS {
+			v.net.Nameservers = append(v.net.Nameservers, dns.String())
+		}
+	}
+	return v, nil
+}
+
+func getVolume() (*volume, error) {
+	l := *line
+	if l == "" {
+		l = cmdline.FullCmdLine()
+	}
+	p := params(l)
+	if *useIBFT || p["rd.iscsi.firmware"] == "1" || p["rd.iscsi.ibft"] == "1" {
+		ibft, err := iscsi.ReadIBFT(iscsi.IBFTPath)
+		if err != nil {
+			return nil, err
+		}
+		interfaces, err := net.Interfaces()
+		if err != nil {
+			return nil, err
+		}
+		return fromIBFT(ibft, interfaces)
+	}
+
+	switch flag.NArg() {
+	case 0:
+		return parseCmdline(l, "")
+	case 1:
+		return parseCmdline(l, strings.TrimPrefix(flag.Arg(0), "netroot="))
+	}
+	return nil, fmt.Errorf("usage: netroot [-ibft] [-line LINE] [-initiator NAME] [-nbd DEVICE] [-timeout DURATION] [-n] [netroot=...]")
+}
+
+func main() {
+	flag.Parse()
+	v, err := getVolume()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *initiator != "" {
+		v.initiator = *initiator
+	}
+	if *dryRun {
+		fmt.Println(v)
+		return
+	}
+
+	if v.net != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		// init or the kernel may have configured it already.
+		err := netconf.Apply(ctx, v.net.Unconfigured())
+		cancel()
+		if err != nil {
+			log.Fatalf("Configuring the network: %v", err)
+		}
+	}
+
+	if v.iscsi != nil {
+		s, err := iscsi.Dial(v.initiator, v.iscsi, *timeout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		h, err := s.Attach()
+		if err != nil {
+			log.Fatal(err)
+		}
+		disk, err := h.Disk(*timeout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(disk)
+		return
+	}
+
+	c, err := nbd.Dial("tcp", v.nbdAddr, v.nbdExport)
+	if err != nil {
+		log.Fatal(err)
+	}
+	d, err := c.Attach(*nbdDevice, *timeout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(d.Path)
+	os.Stdout.Sync()
+	if err := d.Wait(); err != nil {
+		log.Fatalf("%s: %v", d.Path, err)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/iscsi"
+)
+
+func TestParseCmdline(t *testing.T) {
+	for _, tt := range []struct {
+		line string
+		want string
+		tgt  *iscsi.Target
+	}{
+		{
+			line: "console=ttyS0 netroot=iscsi:192.168.1.20::::iqn.2019-06.org.example:disk",
+			want: "iSCSI target iqn.2019-06.org.example:disk LUN 0 at 192.168.1.20:3260 as " + defaultInitiator,
+			tgt:  &iscsi.Target{Addr: "192.168.1.20:3260", Name: "iqn.2019-06.org.example:disk"},
+		},
+		{
+			line: "netroot=iscsi:user:pass@target.example:6:3261:1a:iqn.2019-06.org.example:disk rd.iscsi.initiator=iqn.2019-06.org.example:host",
+			want: "iSCSI target iqn.2019-06.org.example:disk LUN 26 at target.example:3261 as iqn.2019-06.org.example:host",
+			tgt:  &iscsi.Target{Addr: "target.example:3261", Name: "iqn.2019-06.org.example:disk", LUN: 26, User: "user", Secret: "pass"},
+		},
+		{
+			line: "netroot=iscsi:[fd00::1]::::iqn.2019-06.org.example:disk rd.iscsi.username=u rd.iscsi.password=p",
+			want: "iSCSI target iqn.2019-06.org.example:disk LUN 0 at [fd00::1]:3260 as " + defaultInitiator,
+			tgt:  &iscsi.Target{Addr: "[fd00::1]:3260", Name: "iqn.2019-06.org.example:disk", User: "u", Secret: "p"},
+		},
+		{
+			line: "netroot=nbd:192.168.1.20/root",
+			want: `NBD export "root" at 192.168.1.20:10809`,
+		},
+		{
+			line: "netroot=nbd:server:10810/root:ext4:ro",
+			want: `NBD export "root" at server:10810`,
+		},
+		{
+			line: "netroot=nbd:[fd00::1]:10810",
+			want: `NBD export "" at [fd00::1]:10810`,
+		},
+	} {
+		v, err := parseCmdline(tt.line, "")
+		if err != nil {
+			t.Errorf("parseCmdline(%q) = %v", tt.line, err)
+			continue
+		}
+		if got := v.String(); got != tt.want {
+			t.Errorf("parseCmdline(%q) = %s, want %s", tt.line, got, tt.want)
+		}
+		if tt.tgt != nil && !reflect.DeepEqual(v.iscsi, tt.tgt) {
+			t.Errorf("parseCmdline(%q) target = %+v, want %+v", tt.line, v.iscsi, tt.tgt)
+		}
+		if v.net != nil {
+			t.Errorf("parseCmdline(%q) configures the network", tt.line)
+		}
+	}
+}
+
+func TestParseCmdlineErrors(t *testing.T) {
+	for _, line := range []string{
+		"",
+		"netroot=nfs:server:/root",
+		"netroot=iscsi:server",
+		"netroot=iscsi:server:17:::iqn",
+		"netroot=iscsi:server::port::iqn",
+		"netroot=iscsi:server::::",
+		"netroot=iscsi:user@server::::iqn",
+		"netroot=nbd:/root",
+	} {
+		if v, err := parseCmdline(line, ""); err == nil {
+			t.Errorf("parseCmdline(%q) = %v, want error", line, v)
+		}
+	}
+}
+
+func TestParseCmdlineNetwork(t *testing.T) {
+	v, err := parseCmdline("ip=10.0.0.2::10.0.0.1:255.255.255.0::eth0:none", "nbd:10.0.0.1/root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.net == nil || len(v.net.Interfaces) != 1 || v.net.Interfaces[0].Name != "eth0" {
+		t.Errorf("network = %+v, want eth0", v.net)
+	}
+}
+
+func TestFromIBFT(t *testing.T) {
+	mac, _ := net.ParseMAC("52:54:00:12:34:56")
+	_, ipnet, _ := net.ParseCIDR("192.168.1.0/24")
+	ipnet.IP = net.IPv4(192, 168, 1, 10)
+	ibft := &iscsi.IBFT{
+		Initiator: "iqn.2019-06.org.example:host",
+		NICs: []iscsi.IBFTNIC{
+			{Index: 0, MAC: mac, IP: ipnet, Gateway: net.IPv4(192, 168, 1, 1), DNS: []net.IP{net.IPv4(192, 168, 1, 2)}},
+		},
+		Targets: []iscsi.IBFTTarget{
+			{Target: iscsi.Target{Addr: "192.168.1.30:3260", Name: "other"}},
+			{Target: iscsi.Target{Addr: "192.168.1.20:3260", Name: "boot"}, Boot: true},
+		},
+	}
+	v, err := fromIBFT(ibft, []net.Interface{{Name: "lo"}, {Name: "eth1", HardwareAddr: mac}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.iscsi.Name != "boot" || v.initiator != ibft.Initiator {
+		t.Errorf("volume = %v, want the boot target", v)
+	}
+	i := v.net.Interfaces[0]
+	if i.Name != "eth1" || i.DHCP4 || !reflect.DeepEqual(i.Addresses, []string{"192.168.1.10/24"}) ||
+		i.Gateway != "192.168.1.1" || !reflect.DeepEqual(v.net.Nameservers, []string{"192.168.1.2"}) {
+		t.Errorf("network = %+v", v.net)
+	}
+
+	if _, err := fromIBFT(ibft, nil); err == nil {
+		t.Errorf("fromIBFT without the NIC's interface succeeded")
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// nslookup queries DNS servers.
+//
+// Synopsis:
+//     nslookup [OPTIONS...] NAME [SERVER]
+//
+// Description:
+//     nslookup looks up NAME with the resolver boot tools use: /etc/hosts
+//     first, then the servers and search domains in /etc/resolv.conf. If
+//     SERVER is given, only it is asked. If NAME is an address, its PTR
+//     records are looked up.
+//
+// Options:
+//     -type:    record type to look up; default A and AAAA
+//     -port:    port of SERVER
+//     -tcp:     use TCP
+//     -timeout: how long to wait for each answer
+//     -nohosts: do not use /etc/hosts
+//     -debug:   print the whole response
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+
+	"github.com/u-root/u-root/pkg/dns"
+)
+
+var (
+	typ     = flag.String("type", "", "Record type to look up; default A and AAAA")
+	port    = flag.Int("port", 53, "Port of SERVER")
+	tcp     = flag.Bool("tcp", false, "Use TCP")
+	timeout = flag.Duration("timeout", 0, "How long to wait for each answer; default from resolv.conf")
+	noHosts = flag.Bool("nohosts", false, "Do not use /etc/hosts")
+	debug   = flag.Bool("debug", false, "Print the whole response")
+)
+
+func resolver() (*dns.Resolver, error) {
+	r, err := dns.NewSystem()
+	if err != nil {
+		return nil, err
+	}
+	if flag.NArg() == 2 {
+		server := flag.Arg(1)
+		if net.ParseIP(server) == nil {
+			ips, err := r.LookupIP(context.Background(), server)
+			if err != nil {
+				return nil, err
+			}
+			server = ips[0].String()
+		}
+		r.Config.Servers = []string{net.JoinHostPort(server, fmt.Sprint(*port))}
+	}
+	if *timeout != 0 {
+		r.Config.Timeout = *timeout
+	}
+	if *tcp {
+		r.Config.UseTCP = true
+	}
+	if *noHosts {
+		r.Hosts = nil
+	}
+	return r, nil
+}
+
+func lookup(ctx context.Context, r *dns.Resolver, name string) error {
+	if *debug {
+		t := dns.TypeA
+		if *typ != "" {
+			var err error
+			if t, err = dns.ParseType(*typ); err != nil {
+				return err
+			}
+		}
+		m, err := dns.Exchange(ctx, r.Config.Servers[0], dns.Question{Name: dns.Fqdn(name), Type: t}, r.Config.UseTCP)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("id %d, %v, authoritative %v, truncated %v, recursion available %v\n",
+			m.ID, m.RCode, m.Authoritative, m.Truncated, m.RecursionAvailable)
+		for _, s := range []struct {
+			name    string
+			records []dns.Record
+		}{{"ANSWER", m.Answers}, {"AUTHORITY", m.Authorities}, {"ADDITIONAL", m.Additionals}} {
+			if len(s.records) > 0 {
+				fmt.Printf("\n;; %s SECTION:\n", s.name)
+			}
+			for _, rr := range s.records {
+				fmt.Println(rr)
+			}
+		}
+		return nil
+	}
+
+	if ip := net.ParseIP(name); ip != nil && *typ == "" {
+		names, err := r.LookupAddr(ctx, ip)
+		if err != nil {
+			return err
+		}
+		for _, n := range names {
+			fmt.Printf("%s\tname = %s\n", ip, n)
+		}
+		return nil
+	}
+
+	if *typ == "" {
+		ips, err := r.LookupIP(ctx, name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Name:\t%s\n", name)
+		for _, ip := range ips {
+			fmt.Printf("Address: %s\n", ip)
+		}
+		return nil
+	}
+
+	t, err := dns.ParseType(*typ)
+	if err != nil {
+		return err
+	}
+	records, err := r.Lookup(ctx, name, t)
+	if err != nil {
+		return err
+	}
+	for _, rr := range records {
+		fmt.Println(rr)
+	}
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() < 1 || flag.NArg() > 2 {
+		log.Fatal("usage: nslookup [OPTIONS...] NAME [SERVER]")
+	}
+
+	r, err := resolver()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Server:\t\t%s\n\n", r.Config.Servers[0])
+
+	// Each query is bounded by the resolver's timeout.
+	if err := lookup(context.Background(), r, flag.Arg(0)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
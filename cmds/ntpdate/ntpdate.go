@@ -1,8 +1,32 @@
-// Copyright 2016-2017 the u-root Authors. All rights reserved
+// Copyright 2016-2019 the u-root Authors. All rights reserved
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// simple ntpd daemon in Go.
+// Ntpdate sets the system clock from NTP servers.
+//
+// Synopsis:
+//     ntpdate [OPTIONS] [SERVER...]
+//
+// Description:
+//     The servers are the server and pool lines of the config file, unless
+//     some are given as arguments, as HOST or HOST:PORT. All of them are
+//     queried at once; the addresses of a pool are queried as separate
+//     servers, and servers with the iburst option get several queries, of
+//     which the one with the shortest delay is kept.
+//
+//     Servers whose time disagrees with the majority are dropped, and the
+//     offset of the best of the others, by stratum and then by distance, is
+//     applied to the clock. By default the clock is stepped; with -slew,
+//     offsets below -step are slewed with adjtimex instead.
+//
+// Options:
+//     -config:  NTP config file
+//     -q:       query only, and do not set the clock
+//     -slew:    slew the clock for small offsets
+//     -step:    smallest offset that is stepped with -slew
+//     -rtc:     set the hardware clock too
+//     -timeout: timeout of each query
+//     -verbose: verbose output
 package main
 
 import (
@@ -15,21 +39,44 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/beevik/ntp"
+	"github.com/u-root/u-root/pkg/rtc"
+	"golang.org/x/sys/unix"
 )
 
 var (
 	config  = flag.String("config", "/etc/ntp.conf", "NTP config file.")
 	verbose = flag.Bool("verbose", false, "Verbose output")
+	query   = flag.Bool("q", false, "Query only, do not set the clock")
+	slew    = flag.Bool("slew", false, "Slew the clock for offsets below -step, instead of stepping it")
+	step    = flag.Duration("step", 500*time.Millisecond, "Smallest offset that is stepped with -slew")
+	setRTC  = flag.Bool("rtc", false, "Set the hardware clock too")
+	timeout = flag.Duration("timeout", 2*time.Second, "Timeout of each query")
 	debug   = func(string, ...interface{}) {}
 )
 
 const (
 	fallback = "time.google.com"
+
+	// adjOffsetSingleshot makes adjtimex slew the clock by an offset in
+	// microseconds, like adjtime.
+	adjOffsetSingleshot = 0x8001
 )
 
-func parseServers(r *bufio.Reader) []string {
-	var uri []string
+// server is a server or pool line of the config file.
+type server struct {
+	host string
+	// pool is true if host names a pool of servers.
+	pool bool
+	// iburst is true if the server gets a burst of queries.
+	iburst bool
+}
+
+func (s server) String() string {
+	return s.host
+}
+
+func parseServers(r *bufio.Reader) []server {
+	var servers []server
 	var l string
 	var err error
 
@@ -38,57 +85,85 @@ func parseServers(r *bufio.Reader) []string {
 		// This handles the case where the last line doesn't end in \n
 		l, err = r.ReadString('\n')
 		debug("%v", l)
-		if w := strings.Fields(l); len(w) > 1 && w[0] == "server" {
-			// We look only for the server lines, we ignore options like iburst
-			// TODO(ganshun): figure out what options we want to support.
-			uri = append(uri, w[1])
+		w := strings.Fields(l)
+		if len(w) < 2 || (w[0] != "server" && w[0] != "pool") {
+			continue
 		}
+		s := server{host: w[1], pool: w[0] == "pool"}
+		// Other options, like prefer or minpoll, are of no use for a
+		// single setting of the clock.
+		for _, o := range w[2:] {
+			if o == "iburst" {
+				s.iburst = true
+			}
+		}
+		servers = append(servers, s)
 	}
 
-	return uri
+	return servers
 }
 
-func getTime(servers []string) (t time.Time, err error) {
-	for _, s := range servers {
-		debug("Getting time from %v", s)
-		if t, err = ntp.Time(s); err == nil {
-			// Right now we return on the first valid time.
-			// We can implement better heuristics here.
-			debug("Got time %v", t)
-			return
-		}
-		debug("Error getting time: %v", err)
+// setTime corrects the clock by offset.
+func setTime(offset time.Duration) (string, error) {
+	if *slew && offset > -*step && offset < *step {
+		tx := &unix.Timex{Modes: adjOffsetSingleshot, Offset: int64(offset / time.Microsecond)}
+		_, err := unix.Adjtimex(tx)
+		return "adjust", err
 	}
+	tv := syscall.NsecToTimeval(time.Now().Add(offset).UnixNano())
+	return "step", syscall.Settimeofday(&tv)
+}
 
-	err = fmt.Errorf("unable to get any time from servers %v", servers)
-	return
+func writeRTC() error {
+	r, err := rtc.OpenRTC()
+	if err != nil {
+		return err
+	}
+	return r.Set(time.Now().UTC())
 }
 
 func main() {
-	var servers []string
+	var servers []server
 	flag.Parse()
 	if *verbose {
 		debug = log.Printf
 	}
 
-	debug("Reading NTP servers from config file: %v", *config)
-	f, err := os.Open(*config)
-	if err == nil {
-		defer f.Close()
-		servers = parseServers(bufio.NewReader(f))
-		debug("Found %v servers", len(servers))
+	if flag.NArg() > 0 {
+		for _, h := range flag.Args() {
+			servers = append(servers, server{host: h})
+		}
 	} else {
-		log.Printf("Unable to open config file: %v\nFalling back to : %v", err, fallback)
-		servers = []string{fallback}
+		debug("Reading NTP servers from config file: %v", *config)
+		f, err := os.Open(*config)
+		if err == nil {
+			servers = parseServers(bufio.NewReader(f))
+			f.Close()
+			debug("Found %v servers", len(servers))
+		} else {
+			log.Printf("Unable to open config file: %v\nFalling back to : %v", err, fallback)
+			servers = []server{{host: fallback}}
+		}
 	}
 
-	t, err := getTime(servers)
+	s, err := getTime(servers)
 	if err != nil {
 		log.Fatalf("Unable to get time: %v", err)
 	}
+	if *query {
+		fmt.Printf("server %s, stratum %d, offset %+.6f, delay %.5f\n", s.addr, s.resp.Stratum, s.resp.ClockOffset.Seconds(), s.resp.RTT.Seconds())
+		return
+	}
 
-	tv := syscall.NsecToTimeval(t.UnixNano())
-	if err = syscall.Settimeofday(&tv); err != nil {
+	how, err := setTime(s.resp.ClockOffset)
+	if err != nil {
 		log.Fatalf("Unable to set system time: %v", err)
 	}
+	log.Printf("%s time server %s offset %+.6f sec", how, s.addr, s.resp.ClockOffset.Seconds())
+
+	if *setRTC {
+		if err := writeRTC(); err != nil {
+			log.Fatalf("Unable to set the hardware clock: %v", err)
+		}
+	}
 }
@@ -6,44 +6,59 @@ package main
 
 import (
 	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/beevik/ntp"
+	"github.com/u-root/u-root/pkg/testutil"
 )
 
 var configFileTests = []struct {
 	config string
-	out    []string
+	out    []server
 }{
 	{
 		config: "",
-		out:    []string{},
+		out:    []server{},
 	},
 	{
 		config: "server 127.0.0.1",
-		out:    []string{"127.0.0.1"},
+		out:    []server{{host: "127.0.0.1"}},
 	},
 	{
 		config: "server 127.0.0.1\n",
-		out:    []string{"127.0.0.1"},
+		out:    []server{{host: "127.0.0.1"}},
 	},
 	{
 		config: "servers 127.0.0.1",
-		out:    []string{},
+		out:    []server{},
 	},
 	{
 		config: "server time.google.com",
-		out:    []string{"time.google.com"},
+		out:    []server{{host: "time.google.com"}},
 	},
 	{
 		config: "server 127.0.0.1\n" +
 			"server time.google.com",
-		out: []string{"127.0.0.1", "time.google.com"},
+		out: []server{{host: "127.0.0.1"}, {host: "time.google.com"}},
 	},
 	{
 		config: "servers 127.0.0.1\n" +
 			"server time.google.com",
-		out: []string{"time.google.com"},
+		out: []server{{host: "time.google.com"}},
+	},
+	{
+		config: "# A comment\n" +
+			"pool pool.ntp.org iburst\n" +
+			"server 127.0.0.1 prefer iburst minpoll 4\n" +
+			"server 10.0.0.1 prefer\n" +
+			"pool\n",
+		out: []server{{host: "pool.ntp.org", pool: true, iburst: true}, {host: "127.0.0.1", iburst: true}, {host: "10.0.0.1"}},
 	},
 }
 
@@ -65,20 +80,24 @@ func TestConfigParsing(t *testing.T) {
 }
 
 var getTimeTests = []struct {
-	servers []string
+	servers []server
 	time    time.Time
 	err     string
 }{
 	{
-		servers: []string{},
+		servers: []server{},
 		err:     "unable to get any time from servers",
 	},
 	{
-		servers: []string{"nope.nothing.here"},
+		servers: []server{{host: "nope.nothing.here"}},
 		err:     "unable to get any time from servers",
 	},
 	{
-		servers: []string{"nope.nothing.here", "nope.nothing.here2"},
+		servers: []server{{host: "nope.nothing.here"}, {host: "nope.nothing.here2"}},
+		err:     "unable to get any time from servers",
+	},
+	{
+		servers: []server{{host: "nope.nothing.here", pool: true}},
 		err:     "unable to get any time from servers",
 	},
 }
@@ -95,3 +114,211 @@ func TestGetNoTime(t *testing.T) {
 		}
 	}
 }
+
+// ntpEpoch is the NTP time of the Unix epoch.
+const ntpEpoch = 2208988800
+
+func ntpTime(t time.Time) uint64 {
+	return uint64(t.Unix()+ntpEpoch)<<32 | uint64(t.Nanosecond())<<32/uint64(time.Second)
+}
+
+// fakeServer is an NTP server on a loopback port whose clock is off by
+// offset.
+type fakeServer struct {
+	conn    *net.UDPConn
+	offset  time.Duration
+	stratum uint8
+	queries int32
+}
+
+func startServer(t *testing.T, offset time.Duration, stratum uint8) *fakeServer {
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{conn: c, offset: offset, stratum: stratum}
+	go s.serve()
+	return s
+}
+
+func (s *fakeServer) addr() string {
+	return s.conn.LocalAddr().String()
+}
+
+func (s *fakeServer) serve() {
+	for {
+		req := make([]byte, 48)
+		n, from, err := s.conn.ReadFromUDP(req)
+		if err != nil {
+			return
+		}
+		if n < 48 {
+			continue
+		}
+		atomic.AddInt32(&s.queries, 1)
+		now := time.Now().Add(s.offset)
+		resp := make([]byte, 48)
+		// No leap second, version 4, server mode.
+		resp[0] = 4<<3 | 4
+		resp[1] = s.stratum
+		resp[2] = 6
+		resp[3] = 0xec
+		// A root dispersion of 1/16 s.
+		binary.BigEndian.PutUint32(resp[8:], 1<<12)
+		copy(resp[12:], "TEST")
+		binary.BigEndian.PutUint64(resp[16:], ntpTime(now.Add(-time.Minute)))
+		// The origin time is the transmit time of the request.
+		copy(resp[24:], req[40:48])
+		binary.BigEndian.PutUint64(resp[32:], ntpTime(now))
+		binary.BigEndian.PutUint64(resp[40:], ntpTime(now))
+		s.conn.WriteToUDP(resp, from)
+	}
+}
+
+// near reports whether d is within 50ms of want.
+func near(d, want time.Duration) bool {
+	d -= want
+	return d > -50*time.Millisecond && d < 50*time.Millisecond
+}
+
+func TestGetTime(t *testing.T) {
+	defer func(i time.Duration) { burstInterval = i }(burstInterval)
+	burstInterval = time.Millisecond
+	*timeout = 200 * time.Millisecond
+
+	good := startServer(t, 3*time.Second, 2)
+	defer good.conn.Close()
+	better := startServer(t, 3*time.Second, 1)
+	defer better.conn.Close()
+	wrong := startServer(t, time.Hour, 1)
+	defer wrong.conn.Close()
+	// A stratum of 0 is a kiss of death.
+	dead := startServer(t, 0, 0)
+	defer dead.conn.Close()
+	silent := startServer(t, 0, 1)
+	silent.conn.Close()
+
+	s, err := getTime([]server{
+		{host: good.addr(), iburst: true},
+		{host: better.addr()},
+		{host: wrong.addr()},
+		{host: dead.addr()},
+		{host: silent.addr()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.addr != better.addr() || !near(s.resp.ClockOffset, 3*time.Second) {
+		t.Errorf("Got offset %v from %s, want 3s from %s", s.resp.ClockOffset, s.addr, better.addr())
+	}
+	if n := atomic.LoadInt32(&good.queries); n != int32(burstCount) {
+		t.Errorf("Server with iburst got %d queries, want %d", n, burstCount)
+	}
+	if n := atomic.LoadInt32(&better.queries); n != 1 {
+		t.Errorf("Server without iburst got %d queries, want 1", n)
+	}
+
+	// Two servers that disagree cannot be told apart.
+	if s, err := getTime([]server{{host: good.addr()}, {host: wrong.addr()}}); err == nil {
+		t.Errorf("Got %v from servers that disagree, want error", s.resp.ClockOffset)
+	}
+
+	// The addresses of a pool are servers of their own.
+	_, port, _ := net.SplitHostPort(better.addr())
+	s, err = getTime([]server{{host: net.JoinHostPort("localhost", port), pool: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.addr != better.addr() || !near(s.resp.ClockOffset, 3*time.Second) {
+		t.Errorf("Got offset %v from %s, want 3s from %s", s.resp.ClockOffset, s.addr, better.addr())
+	}
+}
+
+func TestSelectSample(t *testing.T) {
+	newSample := func(addr string, offset, distance time.Duration, stratum uint8) *sample {
+		return &sample{addr: addr, resp: &ntp.Response{ClockOffset: offset, RootDistance: distance, Stratum: stratum}}
+	}
+	for _, tt := range []struct {
+		name    string
+		samples []*sample
+		want    string
+	}{
+		{
+			name:    "one",
+			samples: []*sample{newSample("a", time.Second, 10*time.Millisecond, 3)},
+			want:    "a",
+		},
+		{
+			name: "falseticker",
+			samples: []*sample{
+				newSample("a", time.Second, 10*time.Millisecond, 2),
+				newSample("b", 1010*time.Millisecond, 20*time.Millisecond, 2),
+				newSample("c", time.Minute, time.Millisecond, 1),
+			},
+			want: "a",
+		},
+		{
+			name: "stratum",
+			samples: []*sample{
+				newSample("a", time.Second, 10*time.Millisecond, 2),
+				newSample("b", 1010*time.Millisecond, 20*time.Millisecond, 1),
+				newSample("c", 990*time.Millisecond, 20*time.Millisecond, 3),
+			},
+			want: "b",
+		},
+		{
+			name: "touching",
+			samples: []*sample{
+				newSample("a", 0, 10*time.Millisecond, 2),
+				newSample("b", 20*time.Millisecond, 10*time.Millisecond, 2),
+			},
+			want: "a",
+		},
+		{
+			name: "no majority",
+			samples: []*sample{
+				newSample("a", 0, 10*time.Millisecond, 1),
+				newSample("b", time.Second, 10*time.Millisecond, 1),
+				newSample("c", 2*time.Second, 10*time.Millisecond, 1),
+				newSample("d", 2*time.Second, 10*time.Millisecond, 1),
+			},
+		},
+		{
+			name: "none",
+		},
+	} {
+		s, err := selectSample(tt.samples)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s: got %s, want error", tt.name, s.addr)
+			}
+			continue
+		}
+		if err != nil || s.addr != tt.want {
+			t.Errorf("%s: got %v, %v, want %s", tt.name, s, err, tt.want)
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	s := startServer(t, -2*time.Second, 2)
+	defer s.conn.Close()
+	out, err := testutil.Command(t, "-q", "-config", "/dev/null", s.addr()).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var addr string
+	var stratum int
+	var offset, delay float64
+	if _, err := fmt.Sscanf(string(out), "server %s stratum %d, offset %f, delay %f", &addr, &stratum, &offset, &delay); err != nil {
+		t.Fatalf("Parsing %q: %v", out, err)
+	}
+	d := time.Duration(offset * float64(time.Second))
+	if addr != s.addr()+"," || stratum != 2 || !near(d, -2*time.Second) {
+		t.Errorf("Got %q, want an offset of about -2s from %s at stratum 2", out, s.addr())
+	}
+}
+
+func TestMain(m *testing.M) {
+	testutil.Run(m, main)
+}
@@ -0,0 +1,197 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+var (
+	// burstCount and burstInterval are the number of queries made to
+	// servers with iburst, and the time between them.
+	burstCount    = 4
+	burstInterval = 500 * time.Millisecond
+)
+
+// maxPoolServers is the number of addresses of a pool that are queried.
+const maxPoolServers = 4
+
+// sample is the answer of a server.
+type sample struct {
+	addr string
+	resp *ntp.Response
+}
+
+// queryHost queries addr, a HOST or HOST:PORT, n times, and returns the
+// valid answer with the shortest delay.
+func queryHost(addr string, n int) (*sample, error) {
+	host, opt := addr, ntp.QueryOptions{Timeout: *timeout}
+	if h, p, err := net.SplitHostPort(addr); err == nil {
+		if port, err := strconv.Atoi(p); err == nil {
+			host, opt.Port = h, port
+		}
+	}
+
+	var best *sample
+	var err error
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			time.Sleep(burstInterval)
+		}
+		var r *ntp.Response
+		if r, err = ntp.QueryWithOptions(host, opt); err == nil {
+			err = r.Validate()
+		}
+		if err != nil {
+			debug("Error getting time from %v: %v", addr, err)
+			continue
+		}
+		debug("Server %v: stratum %d, offset %v, delay %v", addr, r.Stratum, r.ClockOffset, r.RTT)
+		if best == nil || r.RTT < best.resp.RTT {
+			best = &sample{addr: addr, resp: r}
+		}
+	}
+	if best == nil {
+		return nil, err
+	}
+	return best, nil
+}
+
+// queryServer queries s, and each of its addresses if it is a pool.
+func queryServer(s server) []*sample {
+	n := 1
+	if s.iburst {
+		n = burstCount
+	}
+	addrs := []string{s.host}
+	if s.pool {
+		host, port, err := net.SplitHostPort(s.host)
+		if err != nil {
+			host, port = s.host, ""
+		}
+		ips, err := net.LookupHost(host)
+		if err != nil {
+			debug("Error looking up pool %v: %v", host, err)
+			return nil
+		}
+		if len(ips) > maxPoolServers {
+			ips = ips[:maxPoolServers]
+		}
+		addrs = nil
+		for _, ip := range ips {
+			if port != "" {
+				ip = net.JoinHostPort(ip, port)
+			}
+			addrs = append(addrs, ip)
+		}
+	}
+
+	var samples []*sample
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, a := range addrs {
+		wg.Add(1)
+		go func(a string) {
+			defer wg.Done()
+			if s, err := queryHost(a, n); err == nil {
+				mu.Lock()
+				samples = append(samples, s)
+				mu.Unlock()
+			}
+		}(a)
+	}
+	wg.Wait()
+	return samples
+}
+
+// truechimers returns the samples whose correctness intervals, the offset
+// plus or minus the root distance, contain the offset that most of the
+// intervals contain, as in Marzullo's algorithm. The others are
+// falsetickers.
+func truechimers(samples []*sample) []*sample {
+	type edge struct {
+		offset time.Duration
+		start  bool
+	}
+	var edges []edge
+	for _, s := range samples {
+		edges = append(edges, edge{s.resp.ClockOffset - s.resp.RootDistance, true}, edge{s.resp.ClockOffset + s.resp.RootDistance, false})
+	}
+	// Intervals that touch overlap.
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].offset != edges[j].offset {
+			return edges[i].offset < edges[j].offset
+		}
+		return edges[i].start && !edges[j].start
+	})
+	var best, n int
+	var at time.Duration
+	for _, e := range edges {
+		if !e.start {
+			n--
+			continue
+		}
+		if n++; n > best {
+			best, at = n, e.offset
+		}
+	}
+
+	var t []*sample
+	for _, s := range samples {
+		if s.resp.ClockOffset-s.resp.RootDistance <= at && at <= s.resp.ClockOffset+s.resp.RootDistance {
+			t = append(t, s)
+		}
+	}
+	return t
+}
+
+// selectSample returns the best of the samples that agree with most of the
+// others: the one with the lowest stratum and then the shortest distance.
+func selectSample(samples []*sample) (*sample, error) {
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("no samples")
+	}
+	t := truechimers(samples)
+	if len(t) <= len(samples)/2 {
+		return nil, fmt.Errorf("only %d of %d servers agree on the time", len(t), len(samples))
+	}
+	sort.Slice(t, func(i, j int) bool {
+		if t[i].resp.Stratum != t[j].resp.Stratum {
+			return t[i].resp.Stratum < t[j].resp.Stratum
+		}
+		return t[i].resp.RootDistance < t[j].resp.RootDistance
+	})
+	return t[0], nil
+}
+
+// getTime queries all servers at once, and selects the best answer.
+func getTime(servers []server) (*sample, error) {
+	var samples []*sample
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, s := range servers {
+		wg.Add(1)
+		go func(s server) {
+			defer wg.Done()
+			r := queryServer(s)
+			mu.Lock()
+			samples = append(samples, r...)
+			mu.Unlock()
+		}(s)
+	}
+	wg.Wait()
+
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("unable to get any time from servers %v", servers)
+	}
+	return selectSample(samples)
+}
@@ -0,0 +1,287 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"debug/pe"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/boot"
+	"github.com/u-root/u-root/pkg/ipxe"
+	"github.com/u-root/u-root/pkg/pxe"
+	"github.com/u-root/u-root/pkg/uio"
+)
+
+// bootFile is the kind of file a boot URL points to.
+type bootFile int
+
+const (
+	// otherFile is any other file, such as pxelinux.0. Its directory is
+	// searched for pxelinux configs.
+	otherFile bootFile = iota
+	pxelinuxConfig
+	ipxeScript
+	// linuxKernel is a bare kernel, booted with -cmdline.
+	linuxKernel
+	// blsEntry is a Boot Loader Specification entry.
+	blsEntry
+	// unifiedKernelImage is a PE image with the kernel, initrd and
+	// command line in its .linux, .initrd and .cmdline sections.
+	unifiedKernelImage
+)
+
+var bootFileNames = []string{
+	otherFile:          "other file",
+	pxelinuxConfig:     "pxelinux config",
+	ipxeScript:         "iPXE script",
+	linuxKernel:        "Linux kernel",
+	blsEntry:           "BLS entry",
+	unifiedKernelImage: "unified kernel image",
+}
+
+func (b bootFile) String() string {
+	return bootFileNames[b]
+}
+
+// maxConfigSize is the largest file that is looked at as a text config.
+const maxConfigSize = 1 << 20
+
+// configKeys are the keys of text configs, lower cased.
+func configKeys(data []byte) map[string]bool {
+	keys := make(map[string]bool)
+	for _, line := range strings.Split(string(data), "\n") {
+		if f := strings.Fields(line); len(f) > 0 && f[0][0] != '#' {
+			keys[strings.ToLower(f[0])] = true
+		}
+	}
+	return keys
+}
+
+// isUKI returns true if r is a PE image with a .linux section.
+func isUKI(r io.ReaderAt) bool {
+	f, err := pe.NewFile(r)
+	if err != nil {
+		return false
+	}
+	return f.Section(".linux") != nil
+}
+
+// isKernel returns true if r starts with the header of an x86 bzImage or
+// of an arm64 Image.
+func isKernel(head []byte) bool {
+	return (len(head) >= 0x206 && string(head[0x202:0x206]) == "HdrS") ||
+		(len(head) >= 0x3c && string(head[0x38:0x3c]) == "ARM\x64")
+}
+
+// classify returns the kind of the file r.
+func classify(r io.ReaderAt) (bootFile, error) {
+	head := make([]byte, 4096)
+	n, err := r.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return otherFile, err
+	}
+	head = head[:n]
+
+	switch {
+	case bytes.HasPrefix(head, []byte("#!ipxe")):
+		return ipxeScript, nil
+	case bytes.HasPrefix(head, []byte("MZ")) && isUKI(r):
+		return unifiedKernelImage, nil
+	case isKernel(head):
+		return linuxKernel, nil
+	case bytes.IndexByte(head, 0) >= 0:
+		// Not text.
+		return otherFile, nil
+	}
+
+	keys := configKeys(head)
+	switch {
+	case keys["label"] || keys["default"] || keys["kernel"]:
+		return pxelinuxConfig, nil
+	case keys["linux"]:
+		return blsEntry, nil
+	}
+	return otherFile, nil
+}
+
+// readConfig reads the text config r.
+func readConfig(r io.ReaderAt) (string, error) {
+	data, err := uio.ReadAll(io.NewSectionReader(r, 0, maxConfigSize))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// section returns the contents of the section name of the PE image f in r,
+// or nil.
+func section(f *pe.File, r io.ReaderAt, name string) io.ReaderAt {
+	s := f.Section(name)
+	if s == nil {
+		return nil
+	}
+	// The raw size is rounded up to the file alignment.
+	size := s.Size
+	if s.VirtualSize != 0 && s.VirtualSize < size {
+		size = s.VirtualSize
+	}
+	return io.NewSectionReader(r, int64(s.Offset), int64(size))
+}
+
+// ukiImage returns the kernel, initrd and command line of the unified kernel
+// image r.
+func ukiImage(r io.ReaderAt) (*boot.LinuxImage, error) {
+	f, err := pe.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	img := &boot.LinuxImage{
+		Kernel: section(f, r, ".linux"),
+		Initrd: section(f, r, ".initrd"),
+	}
+	if c := section(f, r, ".cmdline"); c != nil {
+		b, err := uio.ReadAll(c)
+		if err != nil {
+			return nil, err
+		}
+		img.Cmdline = strings.TrimSpace(strings.TrimRight(string(b), "\x00"))
+	}
+	return img, nil
+}
+
+// blsImage returns the image of the BLS entry config, whose paths are
+// relative to the URL of the entry. Several initrds are concatenated.
+func blsImage(uri *url.URL, config string, s pxe.Schemes) (*boot.LinuxImage, error) {
+	img := &boot.LinuxImage{}
+	var initrds []io.ReaderAt
+	var options []string
+	for _, line := range strings.Split(config, "\n") {
+		f := strings.Fields(line)
+		if len(f) < 2 || f[0][0] == '#' {
+			continue
+		}
+		switch f[0] {
+		case "linux", "initrd":
+			u, err := uri.Parse(f[1])
+			if err != nil {
+				return nil, err
+			}
+			r, err := s.LazyGetFile(u)
+			if err != nil {
+				return nil, err
+			}
+			if f[0] == "linux" {
+				img.Kernel = r
+			} else {
+				initrds = append(initrds, r)
+			}
+		case "options":
+			options = append(options, strings.Join(f[1:], " "))
+		}
+	}
+	if img.Kernel == nil {
+		return nil, fmt.Errorf("BLS entry %s has no linux key", uri)
+	}
+	img.Cmdline = strings.Join(options, " ")
+
+	switch len(initrds) {
+	case 0:
+	case 1:
+		img.Initrd = initrds[0]
+	default:
+		var all []byte
+		for _, r := range initrds {
+			b, err := uio.ReadAll(r)
+			if err != nil {
+				return nil, err
+			}
+			all = append(all, b...)
+		}
+		img.Initrd = bytes.NewReader(all)
+	}
+	return img, nil
+}
+
+// bootImage returns the image to boot from uri, depending on what kind of
+// file it is. For other files, such as pxelinux.0, and files that cannot be
+// read, such as an unsigned pxelinux.0, pxelinux configs are searched for in
+// its directory by the mac and ip addresses.
+func bootImage(uri *url.URL, s pxe.Schemes, mac net.HardwareAddr, ip net.IP) (*boot.LinuxImage, error) {
+	kind := otherFile
+	r, err := s.LazyGetFile(uri)
+	if err == nil {
+		kind, err = classify(r)
+	}
+	if err != nil {
+		log.Printf("Boot file %s: %v; looking for pxelinux configs", uri, err)
+		kind = otherFile
+	} else {
+		log.Printf("Boot file %s: %s", uri, kind)
+	}
+
+	wd := &url.URL{
+		Scheme: uri.Scheme,
+		Host:   uri.Host,
+		Path:   path.Dir(uri.Path),
+	}
+	switch kind {
+	case ipxeScript:
+		config, err := readConfig(r)
+		if err != nil {
+			return nil, err
+		}
+		ipc, err := ipxe.ParseConfig(config, s)
+		if err != nil {
+			return nil, err
+		}
+		return ipc.BootImage, nil
+
+	case linuxKernel:
+		return &boot.LinuxImage{Kernel: r, Cmdline: *cmdline}, nil
+
+	case unifiedKernelImage:
+		return ukiImage(r)
+
+	case blsEntry:
+		config, err := readConfig(r)
+		if err != nil {
+			return nil, err
+		}
+		return blsImage(uri, config, s)
+
+	case pxelinuxConfig:
+		config, err := readConfig(r)
+		if err != nil {
+			return nil, err
+		}
+		pc := pxe.NewConfigWithSchemes(wd, s)
+		if err := pc.Append(config); err != nil {
+			return nil, fmt.Errorf("failed to parse pxelinux config: %v", err)
+		}
+		return defaultEntry(pc)
+	}
+
+	pc := pxe.NewConfigWithSchemes(wd, s)
+	if err := pc.FindConfigFile(mac, ip); err != nil {
+		return nil, fmt.Errorf("failed to parse pxelinux config: %v", err)
+	}
+	return defaultEntry(pc)
+}
+
+// defaultEntry returns the default label of the pxelinux config pc.
+func defaultEntry(pc *pxe.Config) (*boot.LinuxImage, error) {
+	label, ok := pc.Entries[pc.DefaultEntry]
+	if !ok {
+		return nil, fmt.Errorf("pxelinux config has no default label")
+	}
+	return label, nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/pxe"
+	"github.com/u-root/u-root/pkg/uio"
+)
+
+// testKernel returns the start of an x86 bzImage.
+func testKernel() string {
+	b := make([]byte, 0x400)
+	copy(b, "MZ")
+	copy(b[0x202:], "HdrS")
+	return string(b)
+}
+
+// testUKI returns a PE32+ image with the given sections, each padded to the
+// file alignment.
+func testUKI(sections ...[2]string) string {
+	const (
+		lfanew  = 0x40
+		optSize = 240
+		headers = 0x200
+		align   = 0x200
+	)
+	b := make([]byte, headers)
+	copy(b, "MZ")
+	binary.LittleEndian.PutUint32(b[0x3c:], lfanew)
+	copy(b[lfanew:], "PE\x00\x00")
+	coff := b[lfanew+4:]
+	binary.LittleEndian.PutUint16(coff[0:], 0x8664) // Machine
+	binary.LittleEndian.PutUint16(coff[2:], uint16(len(sections)))
+	binary.LittleEndian.PutUint16(coff[16:], optSize)
+	opt := coff[20:]
+	binary.LittleEndian.PutUint16(opt[0:], 0x20b) // PE32+
+	binary.LittleEndian.PutUint32(opt[32:], 0x1000)
+	binary.LittleEndian.PutUint32(opt[36:], align)
+	binary.LittleEndian.PutUint32(opt[60:], headers) // SizeOfHeaders
+	binary.LittleEndian.PutUint32(opt[108:], 16)     // NumberOfRvaAndSizes
+	var data []byte
+	for i, s := range sections {
+		sec := opt[optSize+40*i:]
+		raw := (len(s[1]) + align - 1) / align * align
+		copy(sec, s[0])
+		binary.LittleEndian.PutUint32(sec[8:], uint32(len(s[1])))          // VirtualSize
+		binary.LittleEndian.PutUint32(sec[12:], uint32(0x1000*(i+1)))      // VirtualAddress
+		binary.LittleEndian.PutUint32(sec[16:], uint32(raw))               // SizeOfRawData
+		binary.LittleEndian.PutUint32(sec[20:], uint32(headers+len(data))) // PointerToRawData
+		d := make([]byte, raw)
+		copy(d, s[1])
+		data = append(data, d...)
+	}
+	return string(append(b, data...))
+}
+
+func readString(t *testing.T, what string, r io.ReaderAt) string {
+	if r == nil {
+		return ""
+	}
+	b, err := uio.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading %s: %v", what, err)
+	}
+	return string(b)
+}
+
+func TestClassify(t *testing.T) {
+	for _, tt := range []struct {
+		content string
+		want    bootFile
+	}{
+		{"#!ipxe\nkernel http://x/k\n", ipxeScript},
+		{"DEFAULT linux\nLABEL linux\n  KERNEL vmlinuz\n", pxelinuxConfig},
+		{"# comment\ntitle Fedora\nlinux /vmlinuz\ninitrd /initrd\n", blsEntry},
+		{testKernel(), linuxKernel},
+		{testUKI([2]string{".linux", "kernel"}), unifiedKernelImage},
+		{testUKI([2]string{".text", "code"}), otherFile},
+		{"\x00\x01pxelinux.0", otherFile},
+		{"hello world\n", otherFile},
+		{"", otherFile},
+	} {
+		got, err := classify(strings.NewReader(tt.content))
+		if err != nil || got != tt.want {
+			t.Errorf("classify(%.20q) = %v, %v, want %v", tt.content, got, err, tt.want)
+		}
+	}
+}
+
+func TestBootImage(t *testing.T) {
+	*cmdline = "console=ttyS0"
+	defer func() { *cmdline = "" }()
+
+	fs := pxe.NewMockScheme("http")
+	fs.Add("10.0.0.1", "/kernel", "KERNEL")
+	fs.Add("10.0.0.1", "/initrd", "INITRD")
+	fs.Add("10.0.0.1", "/boot/initrd2", "INITRD2")
+	fs.Add("10.0.0.1", "/boot/vmlinuz", testKernel())
+	fs.Add("10.0.0.1", "/boot/script.ipxe", "#!ipxe\nkernel http://10.0.0.1/kernel quiet\ninitrd http://10.0.0.1/initrd\nboot\n")
+	fs.Add("10.0.0.1", "/boot/linux.efi", testUKI(
+		[2]string{".osrel", "ID=test"},
+		[2]string{".cmdline", "root=/dev/sda1 ro\x00"},
+		[2]string{".linux", "KERNEL"},
+		[2]string{".initrd", "INITRD"},
+	))
+	fs.Add("10.0.0.1", "/loader/entries/test.conf", "title Test\nlinux /kernel\ninitrd /initrd\ninitrd ../../boot/initrd2\noptions root=/dev/sda1\noptions quiet\n")
+	fs.Add("10.0.0.1", "/pxe/default.cfg", "default foo\nlabel foo\n  kernel ../kernel\n  append quiet\n")
+	fs.Add("10.0.0.1", "/pxe/pxelinux.0", "\x00binary")
+	fs.Add("10.0.0.1", "/pxe/pxelinux.cfg/default", "default bar\nlabel bar\n  kernel ../kernel\n  initrd ../initrd\n")
+	fs.Add("10.0.0.1", "/bad/entry.conf", "title No kernel\noptions quiet\nlinux\n")
+	s := make(pxe.Schemes)
+	s.Register(fs.Scheme, fs)
+
+	for _, tt := range []struct {
+		path    string
+		kernel  string
+		initrd  string
+		cmdline string
+		err     bool
+	}{
+		{path: "/boot/vmlinuz", kernel: testKernel(), cmdline: "console=ttyS0"},
+		{path: "/boot/script.ipxe", kernel: "KERNEL", initrd: "INITRD", cmdline: "quiet"},
+		{path: "/boot/linux.efi", kernel: "KERNEL", initrd: "INITRD", cmdline: "root=/dev/sda1 ro"},
+		{path: "/loader/entries/test.conf", kernel: "KERNEL", initrd: "INITRDINITRD2", cmdline: "root=/dev/sda1 quiet"},
+		{path: "/pxe/default.cfg", kernel: "KERNEL", cmdline: "quiet"},
+		{path: "/pxe/pxelinux.0", kernel: "KERNEL", initrd: "INITRD"},
+		{path: "/pxe/unreadable.0", kernel: "KERNEL", initrd: "INITRD"},
+		{path: "/bad/entry.conf", err: true},
+		{path: "/missing", err: true},
+	} {
+		t.Run(tt.path, func(t *testing.T) {
+			u := &url.URL{Scheme: "http", Host: "10.0.0.1", Path: tt.path}
+			img, err := bootImage(u, s, net.HardwareAddr{0, 1, 2, 3, 4, 5}, net.IP{10, 0, 0, 2})
+			if tt.err {
+				if err == nil {
+					t.Fatalf("bootImage(%s) = %v, want error", u, img)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("bootImage(%s) = %v", u, err)
+			}
+			if got := readString(t, "kernel", img.Kernel); got != tt.kernel {
+				t.Errorf("kernel = %.20q, want %.20q", got, tt.kernel)
+			}
+			if got := readString(t, "initrd", img.Initrd); got != tt.initrd {
+				t.Errorf("initrd = %q, want %q", got, tt.initrd)
+			}
+			if img.Cmdline != tt.cmdline {
+				t.Errorf("cmdline = %q, want %q", img.Cmdline, tt.cmdline)
+			}
+		})
+	}
+	// The boot file is fetched once.
+	if n := fs.NumCalled(&url.URL{Scheme: "http", Host: "10.0.0.1", Path: "/boot/script.ipxe"}); n != 1 {
+		t.Errorf("script fetched %d times, want 1", n)
+	}
+}
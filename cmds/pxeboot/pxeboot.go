@@ -8,23 +8,33 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"net/http"
 	"net/url"
 	"os"
-	"path"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/u-root/u-root/pkg/boot"
 	"github.com/u-root/u-root/pkg/dhclient"
-	"github.com/u-root/u-root/pkg/ipxe"
+	"github.com/u-root/u-root/pkg/dns"
+	"github.com/u-root/u-root/pkg/download"
+	"github.com/u-root/u-root/pkg/netlog"
 	"github.com/u-root/u-root/pkg/pxe"
 	"github.com/vishvananda/netlink"
+	"golang.org/x/crypto/ed25519"
 )
 
 var (
-	dryRun = flag.Bool("dry-run", false, "download kernel, but don't kexec it")
+	dryRun   = flag.Bool("dry-run", false, "download kernel, but don't kexec it")
+	pubKeys  = flag.String("pubkey", "", "comma-separated ed25519 public key files; if set, all boot files must be signed by one of them")
+	manifest = flag.String("manifest", "", "signed manifest listing the digests of all boot files, relative to the boot URI; requires -pubkey")
+	httpBoot = flag.Bool("http", false, "identify as a UEFI HTTP boot client, with the HTTPClient vendor class")
+	cmdline  = flag.String("cmdline", "", "kernel command line, if the boot URI is a bare kernel")
+	caCerts  = flag.String("ca", "", "comma-separated PEM files of CA certificates to trust for https boot URIs, in addition to the system's")
 )
 
 const (
@@ -50,7 +60,11 @@ func Netboot(ifaceNames string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dhcpTries*dhcpTimeout)
 	defer cancel()
 
-	r := dhclient.SendRequests(ctx, filteredIfs, dhcpTimeout, dhcpTries, true, true)
+	var opts []dhclient.Option
+	if *httpBoot {
+		opts = append(opts, dhclient.WithVendorClass(dhclient.HTTPClient))
+	}
+	r := dhclient.SendRequests(ctx, filteredIfs, dhcpTimeout, dhcpTries, true, true, opts...)
 
 	for {
 		select {
@@ -80,6 +94,7 @@ func Netboot(ifaceNames string) error {
 				img.ExecutionInfo(log.New(os.Stderr, "", log.LstdFlags))
 				return nil
 			}
+			netlog.Flush()
 			if err := img.Execute(); err != nil {
 				return fmt.Errorf("kexec of %v failed: %v", img, err)
 			}
@@ -90,31 +105,100 @@ func Netboot(ifaceNames string) error {
 	}
 }
 
-// getBootImage attempts to parse the file at uri as an ipxe config and returns
-// the ipxe boot image. Otherwise falls back to pxe and uses the uri directory,
-// ip, and mac address to search for pxe configs.
+// getBootImage returns the image to boot from uri. See bootImage.
 func getBootImage(uri *url.URL, mac net.HardwareAddr, ip net.IP) (*boot.LinuxImage, error) {
-	// Attempt to read the given boot path as an ipxe config file.
-	ipc, err := ipxe.NewConfig(uri)
-	if err == nil {
-		return ipc.BootImage, nil
+	s, err := schemes(uri)
+	if err != nil {
+		return nil, err
 	}
-	log.Printf("Falling back to pxe boot: %v", err)
+	return bootImage(uri, s, mac, ip)
+}
 
-	// Fallback to pxe boot.
-	wd := &url.URL{
-		Scheme: uri.Scheme,
-		Host:   uri.Host,
-		Path:   path.Dir(uri.Path),
+// resolvingScheme resolves the host of URLs with pkg/dns before getting
+// them.
+type resolvingScheme struct {
+	r  *dns.Resolver
+	fs pxe.FileScheme
+}
+
+// GetFile implements pxe.FileScheme.GetFile.
+func (s *resolvingScheme) GetFile(u *url.URL) (io.ReaderAt, error) {
+	ips, err := s.r.LookupIP(context.Background(), u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	v := *u
+	v.Host = ips[0].String()
+	if ips[0].To4() == nil {
+		v.Host = "[" + v.Host + "]"
 	}
+	if u.Port() != "" {
+		v.Host = net.JoinHostPort(ips[0].String(), u.Port())
+	}
+	return s.fs.GetFile(&v)
+}
 
-	pc := pxe.NewConfig(wd)
-	if err := pc.FindConfigFile(mac, ip); err != nil {
-		return nil, fmt.Errorf("failed to parse pxelinux config: %v", err)
+// resolvingSchemes returns the default schemes and https, except that host
+// names are resolved by pkg/dns with the DNS servers the lease configured.
+func resolvingSchemes() (pxe.Schemes, error) {
+	r, err := dns.NewSystem()
+	if err != nil {
+		return nil, err
+	}
+	var caFiles []string
+	if *caCerts != "" {
+		caFiles = strings.Split(*caCerts, ",")
+	}
+	c, err := download.NewClient(caFiles, false)
+	if err != nil {
+		return nil, err
+	}
+	c.Transport.(*http.Transport).DialContext = r.DialContext
+	hc := pxe.NewHTTPClient(c)
+	return pxe.Schemes{
+		"tftp":  &resolvingScheme{r: r, fs: pxe.DefaultTFTPClient},
+		"http":  hc,
+		"https": hc,
+		"file":  &pxe.LocalFileClient{},
+	}, nil
+}
+
+// schemes returns the schemes used to fetch boot files. If public keys are
+// given, every file must either have a valid detached signature or, with
+// -manifest, be listed in the signed manifest next to the boot URI.
+func schemes(uri *url.URL) (pxe.Schemes, error) {
+	base, err := resolvingSchemes()
+	if err != nil {
+		return nil, err
+	}
+	if *pubKeys == "" {
+		if *manifest != "" {
+			return nil, fmt.Errorf("-manifest requires -pubkey")
+		}
+		return base, nil
+	}
+
+	var keys []ed25519.PublicKey
+	for _, f := range strings.Split(*pubKeys, ",") {
+		k, err := pxe.ReadPublicKey(f)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	if *manifest == "" {
+		return base.Verifying(keys...), nil
 	}
 
-	label := pc.Entries[pc.DefaultEntry]
-	return label, nil
+	mu, err := uri.Parse(*manifest)
+	if err != nil {
+		return nil, err
+	}
+	m, err := pxe.GetManifest(base, mu, keys...)
+	if err != nil {
+		return nil, fmt.Errorf("manifest %s: %v", mu, err)
+	}
+	return base.WithManifest(m), nil
 }
 
 func Boot(lease dhclient.Lease) (*boot.LinuxImage, error) {
@@ -139,8 +223,11 @@ func Boot(lease dhclient.Lease) (*boot.LinuxImage, error) {
 
 func main() {
 	flag.Parse()
+	netlog.Mirror("pxeboot")
 
 	if err := Netboot("eth0"); err != nil {
-		log.Fatal(err)
+		log.Print(err)
+		netlog.Flush()
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,49 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+)
+
+func TestHTTPSScheme(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "KERNEL")
+	}))
+	defer ts.Close()
+
+	f, err := ioutil.TempFile("", "pxeboot-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	f.Close()
+
+	*caCerts = f.Name()
+	defer func() { *caCerts = "" }()
+	s, err := resolvingSchemes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(ts.URL + "/vmlinuz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.GetFile(u)
+	if err != nil {
+		t.Fatalf("GetFile(%s) = %v", u, err)
+	}
+	if got := readString(t, "kernel", r); got != "KERNEL" {
+		t.Errorf("GetFile(%s) = %q, want KERNEL", u, got)
+	}
+}
@@ -5,125 +5,41 @@
 // Scp copies files between hosts on a network.
 //
 // Synopsis:
-//     scp [-t|-f] [FILE]
+//     scp [-t|-f] [-r] [-p] [-d] FILE...
 //
 // Description:
-//     If -t is given, decode SCP protocol from stdin and write to FILE.
-//     If -f is given, stream FILE over SCP protocol to stdout.
+//     Scp is the remote side of a copy made by an scp client over SSH. If
+//     -t is given, decode SCP protocol from stdin and write to FILE. If -f
+//     is given, stream the FILEs over SCP protocol to stdout.
 //
 // Options:
 //     -t: Act as the target
 //     -f: Act as the source
+//     -r: Copy directories recursively
+//     -p: Preserve modes and times
+//     -d: The target must be a directory
 //     -v: Passed if SCP is verbose, ignored
 package main
 
 import (
 	"flag"
-	"fmt"
-	"io"
 	"log"
 	"os"
-	"path"
-)
 
-const (
-	SUCCESS = 0
+	"github.com/u-root/u-root/pkg/scp"
 )
 
 var (
 	isTarget = flag.Bool("t", false, "Act as the target")
 	isSource = flag.Bool("f", false, "Act as the source")
+	opts     scp.Options
 	_        = flag.Bool("v", false, "Ignored")
 )
 
-func scpSingleSource(w io.Writer, r io.Reader, pth string) error {
-	f, err := os.Open(pth)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	s, err := f.Stat()
-	if err != nil {
-		return err
-	}
-	filename := path.Base(pth)
-	w.Write([]byte(fmt.Sprintf("C0%o %d %s\n", s.Mode(), s.Size(), filename)))
-	if response(r) != SUCCESS {
-		return fmt.Errorf("response was not success")
-	}
-	_, err = io.Copy(w, f)
-	if err != nil {
-		return fmt.Errorf("copy error: %v", err)
-	}
-	reply(w, SUCCESS)
-
-	if response(r) != SUCCESS {
-		return fmt.Errorf("response was not success")
-	}
-	return nil
-}
-
-func scpSingleSink(w io.Writer, r io.Reader, path string) error {
-	var mode os.FileMode
-	var size int64
-	filename := ""
-
-	// Ignore the filename, assume it has been provided on the command line.
-	// This will not work with directories and recursive copy, but that's not
-	// supported right now.
-	if _, err := fmt.Fscanf(r, "C0%o %d %s\n", &mode, &size, &filename); err != nil {
-		if err == io.ErrUnexpectedEOF {
-			return io.EOF
-		}
-		return fmt.Errorf("fscanf: %v", err)
-	}
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, mode)
-	if err != nil {
-		return fmt.Errorf("open error: %v", err)
-	}
-	reply(w, SUCCESS)
-	defer f.Close()
-
-	_, err = io.CopyN(f, r, size)
-	if err != nil {
-		return fmt.Errorf("copy error: %v", err)
-	}
-	if response(r) != SUCCESS {
-		return fmt.Errorf("response was not success")
-	}
-	reply(w, SUCCESS)
-	return nil
-}
-
-func scpSource(w io.Writer, r io.Reader, path string) error {
-	// Sink->Source is started with a response
-	if response(r) != SUCCESS {
-		return fmt.Errorf("response was not success")
-	}
-	return scpSingleSource(w, r, path)
-}
-
-func scpSink(w io.Writer, r io.Reader, path string) error {
-	reply(w, SUCCESS)
-	for {
-		if err := scpSingleSink(w, r, path); err != nil {
-			if err == io.EOF {
-				break
-			}
-			return err
-		}
-	}
-	return nil
-}
-
-func reply(out io.Writer, r byte) {
-	out.Write([]byte{r})
-}
-
-func response(in io.Reader) byte {
-	b := make([]byte, 1)
-	in.Read(b)
-	return b[0]
+func init() {
+	flag.BoolVar(&opts.Recursive, "r", false, "Copy directories recursively")
+	flag.BoolVar(&opts.Preserve, "p", false, "Preserve modes and times")
+	flag.BoolVar(&opts.TargetDir, "d", false, "The target must be a directory")
 }
 
 func main() {
@@ -137,13 +53,18 @@ func main() {
 		log.Fatalf("-t or -f needs to be supplied, and not both")
 	}
 
+	var err error
 	if *isSource {
-		if err := scpSource(os.Stdout, os.Stdin, flag.Args()[0]); err != nil {
-			log.Fatalf("scp: %v", err)
-		}
-	} else if *isTarget {
-		if err := scpSink(os.Stdout, os.Stdin, flag.Args()[0]); err != nil {
-			log.Fatalf("scp: %v", err)
+		err = scp.Source(os.Stdout, os.Stdin, flag.Args(), opts)
+	} else {
+		if flag.NArg() != 1 {
+			log.Fatalf("-t needs exactly one target")
 		}
+		err = scp.Sink(os.Stdout, os.Stdin, flag.Args()[0], opts)
+	}
+	// Errors with files are reported to the client over the protocol,
+	// which shows them, so they are not logged again here.
+	if err != nil {
+		os.Exit(1)
 	}
 }
@@ -5,26 +5,52 @@
 // Serve files on the network.
 //
 // Synopsis:
-//     srvfiles [--h=HOST] [--p=PORT] [--d=DIR]
+//     srvfiles [--h=HOST] [--p=PORT] [--d=DIR] [--cert=FILE --key=FILE] [--upload]
+//
+// Description:
+//     Files are served with support for range requests. Directory listings
+//     are returned as JSON when the request asks for application/json in
+//     its Accept header or has a "json" query parameter.
+//
+//     With --upload, PUT stores the request body at the requested path.
+//     The parent directory has to exist.
 //
 // Options:
-//     --h: hostname (default: 127.0.0.1)
-//     --p: port number (default: 8080)
-//     --d: directory to serve (default: .)
+//     --h:      hostname (default: 127.0.0.1)
+//     --p:      port number (default: 8080)
+//     --d:      directory to serve (default: .)
+//     --cert:   TLS certificate file; serve HTTPS
+//     --key:    TLS key file
+//     --upload: accept uploads with PUT
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
+	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 )
 
 var (
-	host = flag.String("h", "127.0.0.1", "hostname")
-	port = flag.String("p", "8080", "port number")
-	dir  = flag.String("d", ".", "directory to serve")
+	host   = flag.String("h", "127.0.0.1", "hostname")
+	port   = flag.String("p", "8080", "port number")
+	dir    = flag.String("d", ".", "directory to serve")
+	cert   = flag.String("cert", "", "TLS certificate file")
+	key    = flag.String("key", "", "TLS key file")
+	upload = flag.Bool("upload", false, "accept uploads with PUT")
 )
 
+var errOutsideRoot = errors.New("path is outside of the served directory")
+
 var cacheHeaders = []string{
 	"ETag",
 	"If-Modified-Since",
@@ -46,8 +72,191 @@ func maxAgeHandler(h http.Handler) http.Handler {
 	})
 }
 
+// entry is a directory entry in a JSON listing.
+type entry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	ModTime time.Time `json:"modTime"`
+	IsDir   bool      `json:"isDir"`
+}
+
+// fileServer serves the files below dir.
+type fileServer struct {
+	dir    string
+	upload bool
+	files  http.Handler
+}
+
+func newFileServer(dir string, upload bool) *fileServer {
+	return &fileServer{
+		dir:    dir,
+		upload: upload,
+		files:  http.FileServer(http.Dir(dir)),
+	}
+}
+
+// path returns the file system path for the URL path p, which cannot
+// be outside of dir.
+func (f *fileServer) path(p string) string {
+	return filepath.Join(f.dir, filepath.FromSlash(path.Clean("/"+p)))
+}
+
+// parent returns the directory that an upload to p goes to, with symbolic
+// links resolved. It fails if that directory is not below dir.
+func (f *fileServer) parent(p string) (string, error) {
+	root, err := filepath.EvalSymlinks(f.dir)
+	if err != nil {
+		return "", err
+	}
+	if root, err = filepath.Abs(root); err != nil {
+		return "", err
+	}
+	d, err := filepath.EvalSymlinks(filepath.Dir(p))
+	if err != nil {
+		return "", err
+	}
+	if d, err = filepath.Abs(d); err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, d)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errOutsideRoot
+	}
+	return d, nil
+}
+
+func wantJSON(r *http.Request) bool {
+	if _, ok := r.URL.Query()["json"]; ok {
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+func (f *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if wantJSON(r) && f.list(w, r) {
+			return
+		}
+		f.files.ServeHTTP(w, r)
+	case http.MethodPut:
+		if !f.upload {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "uploads are disabled", http.StatusMethodNotAllowed)
+			return
+		}
+		f.put(w, r)
+	default:
+		if f.upload {
+			w.Header().Set("Allow", "GET, HEAD, PUT")
+		} else {
+			w.Header().Set("Allow", "GET, HEAD")
+		}
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// list writes a JSON listing if the request is for a directory. It
+// returns false if it is not, so that the file is served instead.
+func (f *fileServer) list(w http.ResponseWriter, r *http.Request) bool {
+	d, err := os.Open(f.path(r.URL.Path))
+	if err != nil {
+		return false
+	}
+	defer d.Close()
+	if fi, err := d.Stat(); err != nil || !fi.IsDir() {
+		return false
+	}
+
+	fis, err := d.Readdir(-1)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+	sort.Slice(fis, func(i, j int) bool { return fis[i].Name() < fis[j].Name() })
+	entries := make([]entry, 0, len(fis))
+	for _, fi := range fis {
+		entries = append(entries, entry{
+			Name:    fi.Name(),
+			Size:    fi.Size(),
+			Mode:    fi.Mode().String(),
+			ModTime: fi.ModTime(),
+			IsDir:   fi.IsDir(),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		log.Printf("%s: %v", r.URL.Path, err)
+	}
+	return true
+}
+
+// put stores the request body. It is written to a temporary file first, so
+// that an interrupted upload does not leave a truncated file behind.
+func (f *fileServer) put(w http.ResponseWriter, r *http.Request) {
+	p := f.path(r.URL.Path)
+	if p == filepath.Clean(f.dir) || strings.HasSuffix(r.URL.Path, "/") {
+		http.Error(w, "cannot upload to a directory", http.StatusBadRequest)
+		return
+	}
+	d, err := f.parent(p)
+	switch {
+	case err == errOutsideRoot:
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case os.IsNotExist(err):
+		http.Error(w, "parent directory does not exist", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	p = filepath.Join(d, filepath.Base(p))
+	_, err = os.Lstat(p)
+	created := os.IsNotExist(err)
+
+	tmp, err := ioutil.TempFile(d, "."+filepath.Base(p))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r.Body); err != nil {
+		tmp.Close()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tmp.Close(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("%s: uploaded %s", r.RemoteAddr, r.URL.Path)
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	} else {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
 func main() {
 	flag.Parse()
-	http.Handle("/", maxAgeHandler(http.FileServer(http.Dir(*dir))))
-	log.Fatal(http.ListenAndServe(*host+":"+*port, nil))
+	if (*cert == "") != (*key == "") {
+		log.Fatal("--cert and --key have to be given together")
+	}
+	http.Handle("/", maxAgeHandler(newFileServer(*dir, *upload)))
+	addr := *host + ":" + *port
+	if *cert != "" {
+		log.Fatal(http.ListenAndServeTLS(addr, *cert, *key, nil))
+	}
+	log.Fatal(http.ListenAndServe(addr, nil))
 }
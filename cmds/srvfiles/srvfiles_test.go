@@ -0,0 +1,176 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func setup(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "srvfiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "pxelinux.cfg"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "vmlinuz"), []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func do(t *testing.T, c *http.Client, method, url, body string, header http.Header) (*http.Response, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(b)
+}
+
+func TestServe(t *testing.T) {
+	dir := setup(t)
+	defer os.RemoveAll(dir)
+	s := httptest.NewTLSServer(maxAgeHandler(newFileServer(dir, false)))
+	defer s.Close()
+	c := s.Client()
+
+	resp, body := do(t, c, "GET", s.URL+"/vmlinuz", "", http.Header{"Range": {"bytes=2-5"}})
+	if resp.StatusCode != http.StatusPartialContent || body != "2345" {
+		t.Errorf("GET with Range = %s %q, want 206 %q", resp.Status, body, "2345")
+	}
+	if got, want := resp.Header.Get("Content-Range"), "bytes 2-5/10"; got != want {
+		t.Errorf("Content-Range = %q, want %q", got, want)
+	}
+
+	for _, tt := range []struct {
+		url    string
+		header http.Header
+	}{
+		{url: "/?json"},
+		{url: "/", header: http.Header{"Accept": {"application/json, */*"}}},
+	} {
+		resp, body := do(t, c, "GET", s.URL+tt.url, "", tt.header)
+		if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+			t.Fatalf("GET %s Content-Type = %q, want application/json", tt.url, ct)
+		}
+		var entries []entry
+		if err := json.Unmarshal([]byte(body), &entries); err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 2 || entries[0].Name != "pxelinux.cfg" || !entries[0].IsDir ||
+			entries[1].Name != "vmlinuz" || entries[1].Size != 10 || entries[1].Mode != "-rw-r--r--" {
+			t.Errorf("GET %s = %+v, want pxelinux.cfg/ and vmlinuz", tt.url, entries)
+		}
+	}
+
+	// Files are served as they are, even if JSON is asked for.
+	if _, body := do(t, c, "GET", s.URL+"/vmlinuz?json", "", nil); body != "0123456789" {
+		t.Errorf("GET /vmlinuz?json = %q, want file content", body)
+	}
+
+	if resp, _ := do(t, c, "PUT", s.URL+"/new", "data", nil); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("PUT without --upload = %s, want 405", resp.Status)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "new")); !os.IsNotExist(err) {
+		t.Errorf("PUT without --upload created a file: %v", err)
+	}
+}
+
+func TestUpload(t *testing.T) {
+	dir := setup(t)
+	defer os.RemoveAll(dir)
+	s := httptest.NewServer(newFileServer(dir, true))
+	defer s.Close()
+	c := s.Client()
+
+	for _, tt := range []struct {
+		url    string
+		status int
+		file   string
+	}{
+		{url: "/pxelinux.cfg/default", status: http.StatusCreated, file: "pxelinux.cfg/default"},
+		{url: "/vmlinuz", status: http.StatusNoContent, file: "vmlinuz"},
+		{url: "/../../escape", status: http.StatusCreated, file: "escape"},
+		{url: "/missing/default", status: http.StatusNotFound},
+		{url: "/pxelinux.cfg/", status: http.StatusBadRequest},
+	} {
+		resp, _ := do(t, c, "PUT", s.URL+tt.url, "uploaded", nil)
+		if resp.StatusCode != tt.status {
+			t.Errorf("PUT %s = %s, want %d", tt.url, resp.Status, tt.status)
+		}
+		if tt.file == "" {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dir, tt.file))
+		if err != nil || string(b) != "uploaded" {
+			t.Errorf("PUT %s: %s = %q, %v, want %q", tt.url, tt.file, b, err, "uploaded")
+		}
+	}
+
+	fis, err := ioutil.ReadDir(filepath.Join(dir, "pxelinux.cfg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 1 {
+		t.Errorf("pxelinux.cfg has %d entries, want only default", len(fis))
+	}
+
+	if resp, _ := do(t, c, "DELETE", s.URL+"/vmlinuz", "", nil); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE = %s, want 405", resp.Status)
+	}
+}
+
+func TestUploadSymlinkOutside(t *testing.T) {
+	dir := setup(t)
+	defer os.RemoveAll(dir)
+	outside, err := ioutil.TempDir("", "srvfiles-outside")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+	if err := os.Symlink(outside, filepath.Join(dir, "out")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("pxelinux.cfg", filepath.Join(dir, "in")); err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewServer(newFileServer(dir, true))
+	defer s.Close()
+	c := s.Client()
+
+	if resp, _ := do(t, c, "PUT", s.URL+"/out/escape", "uploaded", nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("PUT /out/escape = %s, want 403", resp.Status)
+	}
+	if fis, err := ioutil.ReadDir(outside); err != nil || len(fis) != 0 {
+		t.Errorf("PUT /out/escape wrote %d files outside of the root, %v", len(fis), err)
+	}
+	// Links that stay below the root are fine.
+	if resp, _ := do(t, c, "PUT", s.URL+"/in/default", "uploaded", nil); resp.StatusCode != http.StatusCreated {
+		t.Errorf("PUT /in/default = %s, want 201", resp.Status)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "pxelinux.cfg/default")); err != nil || string(b) != "uploaded" {
+		t.Errorf("pxelinux.cfg/default = %q, %v, want %q", b, err, "uploaded")
+	}
+}
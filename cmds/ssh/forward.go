@@ -0,0 +1,95 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/u-root/u-root/pkg/uio"
+	"golang.org/x/crypto/ssh"
+)
+
+// splitForward splits a -L argument at the colons that are not in
+// brackets, and removes the brackets.
+func splitForward(spec string) []string {
+	var f []string
+	var cur []byte
+	inBrackets := false
+	for i := 0; i < len(spec); i++ {
+		switch c := spec[i]; {
+		case c == '[' && !inBrackets:
+			inBrackets = true
+		case c == ']' && inBrackets:
+			inBrackets = false
+		case c == ':' && !inBrackets:
+			f = append(f, string(cur))
+			cur = nil
+		default:
+			cur = append(cur, c)
+		}
+	}
+	return append(f, string(cur))
+}
+
+// parseForward parses a -L argument, [BIND_ADDRESS:]PORT:HOST:HOSTPORT, into
+// the local address to listen on and the remote address to connect to.
+// Without a bind address, only the loopback address is listened on; with
+// an empty one or *, all addresses are.
+func parseForward(spec string) (local, remote string, err error) {
+	f := splitForward(spec)
+	bind := "localhost"
+	switch len(f) {
+	case 3:
+	case 4:
+		bind, f = f[0], f[1:]
+		if bind == "*" {
+			bind = ""
+		}
+	default:
+		return "", "", fmt.Errorf("bad forwarding %q, want [BIND_ADDRESS:]PORT:HOST:HOSTPORT", spec)
+	}
+	if f[0] == "" || f[1] == "" || f[2] == "" {
+		return "", "", fmt.Errorf("bad forwarding %q, want [BIND_ADDRESS:]PORT:HOST:HOSTPORT", spec)
+	}
+	return net.JoinHostPort(bind, f[0]), net.JoinHostPort(f[1], f[2]), nil
+}
+
+// forward listens as given by spec, a -L argument, and forwards the
+// connections it gets through c. It returns the listener, which is closed
+// when c is.
+func forward(c *ssh.Client, spec string) (net.Listener, error) {
+	local, remote, err := parseForward(spec)
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("tcp", local)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		c.Wait()
+		l.Close()
+	}()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				v("Forwarding %s to %s", conn.RemoteAddr(), remote)
+				rc, err := c.Dial("tcp", remote)
+				if err != nil {
+					v("Forwarding to %s: %v", remote, err)
+					conn.Close()
+					return
+				}
+				uio.Relay(conn, rc)
+			}()
+		}
+	}()
+	return l, nil
+}
@@ -0,0 +1,228 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// knownHost is a line of a known_hosts file.
+type knownHost struct {
+	marker string
+	hosts  []string
+	key    ssh.PublicKey
+}
+
+// knownHosts holds the host keys of known_hosts files.
+type knownHosts struct {
+	// path is the file that new hosts are added to.
+	path  string
+	hosts []knownHost
+}
+
+// loadKnownHosts reads the known_hosts files in paths. Missing files are
+// taken as empty. New hosts are added to the first one.
+func loadKnownHosts(paths ...string) (*knownHosts, error) {
+	k := &knownHosts{}
+	if len(paths) > 0 {
+		k.path = paths[0]
+	}
+	for _, p := range paths {
+		b, err := ioutil.ReadFile(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Lines are parsed one by one, so that a bad line does not
+		// hide the ones after it.
+		for i, line := range bytes.Split(b, []byte("\n")) {
+			marker, hosts, key, _, _, err := ssh.ParseKnownHosts(line)
+			if err == io.EOF {
+				// A comment or a blank line.
+				continue
+			}
+			if err != nil {
+				log.Printf("%s:%d: %v", p, i+1, err)
+				continue
+			}
+			k.hosts = append(k.hosts, knownHost{marker: marker, hosts: hosts, key: key})
+		}
+	}
+	return k, nil
+}
+
+// knownHostName returns the name of addr, a host:port, in known_hosts
+// files.
+func knownHostName(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return strings.ToLower(addr)
+	}
+	host = strings.ToLower(host)
+	if port == "22" {
+		return host
+	}
+	return "[" + host + "]:" + port
+}
+
+// match reports whether pattern, with * and ? wildcards, matches all of s.
+func match(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if match(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
+
+// matchHashed reports whether the hashed host entry, |1|salt|hash, is
+// name.
+func matchHashed(entry, name string) bool {
+	f := strings.Split(entry, "|")
+	if len(f) != 4 || f[1] != "1" {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(f[2])
+	if err != nil {
+		return false
+	}
+	want, err := base64.StdEncoding.DecodeString(f[3])
+	if err != nil {
+		return false
+	}
+	h := hmac.New(sha1.New, salt)
+	h.Write([]byte(name))
+	return hmac.Equal(h.Sum(nil), want)
+}
+
+// matchHosts reports whether the host patterns of a known_hosts line match
+// name. A negated pattern, like !*.example.com, that matches wins over the
+// others.
+func matchHosts(patterns []string, name string) bool {
+	matched := false
+	for _, p := range patterns {
+		if strings.HasPrefix(p, "|") {
+			matched = matched || matchHashed(p, name)
+			continue
+		}
+		negated := strings.HasPrefix(p, "!")
+		if !match(strings.ToLower(strings.TrimPrefix(p, "!")), name) {
+			continue
+		}
+		if negated {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+// lookup returns the keys known for addr, and whether key is revoked.
+func (k *knownHosts) lookup(addr string, key ssh.PublicKey) (keys []ssh.PublicKey, revoked bool) {
+	name := knownHostName(addr)
+	for _, h := range k.hosts {
+		if h.marker == "revoked" {
+			if bytes.Equal(h.key.Marshal(), key.Marshal()) {
+				revoked = true
+			}
+			continue
+		}
+		// Certificate authorities are not supported.
+		if h.marker != "" || !matchHosts(h.hosts, name) {
+			continue
+		}
+		keys = append(keys, h.key)
+	}
+	return keys, revoked
+}
+
+// add records key as the key of addr, in memory and in the file.
+func (k *knownHosts) add(addr string, key ssh.PublicKey) error {
+	name := knownHostName(addr)
+	k.hosts = append(k.hosts, knownHost{hosts: []string{name}, key: key})
+	if err := os.MkdirAll(filepath.Dir(k.path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(k.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "%s %s", name, ssh.MarshalAuthorizedKey(key)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// callback returns a host key callback that accepts the known keys of
+// hosts. What happens with hosts that have no known key depends on strict,
+// as with StrictHostKeyChecking: with "yes" they are refused, with "no" and
+// "accept-new" their key is added, and with "ask" the user is asked first.
+// Keys that changed are always refused.
+func (k *knownHosts) callback(strict string) ssh.HostKeyCallback {
+	return func(addr string, remote net.Addr, key ssh.PublicKey) error {
+		keys, revoked := k.lookup(addr, key)
+		if revoked {
+			return fmt.Errorf("the %s host key for %s is revoked", key.Type(), addr)
+		}
+		for _, known := range keys {
+			if bytes.Equal(known.Marshal(), key.Marshal()) {
+				return nil
+			}
+		}
+		fp := ssh.FingerprintSHA256(key)
+		if len(keys) > 0 {
+			return fmt.Errorf("the host key for %s has changed to %s key %s; remove the old key from %s if this is expected", addr, key.Type(), fp, k.path)
+		}
+		switch strict {
+		case "no", "accept-new":
+		case "ask":
+			answer, err := prompt(fmt.Sprintf("The authenticity of host %s (%s) can't be established.\n%s key fingerprint is %s.\nAre you sure you want to continue connecting (yes/no)? ", addr, remote, key.Type(), fp), true)
+			if err != nil {
+				return err
+			}
+			if answer != "yes" {
+				return fmt.Errorf("host key verification failed")
+			}
+		default:
+			return fmt.Errorf("no %s host key is known for %s", key.Type(), addr)
+		}
+		if err := k.add(addr, key); err != nil {
+			return err
+		}
+		v("Added %s key %s for %s to %s", key.Type(), fp, addr, k.path)
+		return nil
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestMatch(t *testing.T) {
+	for _, tt := range []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"host", "host", true},
+		{"host", "hostname", false},
+		{"*", "", true},
+		{"*.example.com", "a.b.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"10.0.0.?", "10.0.0.7", true},
+		{"10.0.0.?", "10.0.0.17", false},
+		{"[host]:*", "[host]:2222", true},
+		{"a*b*c", "abxbyc", true},
+		{"a*b*c", "abxbyd", false},
+	} {
+		if got := match(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("match(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+// hashed returns name hashed as in known_hosts files.
+func hashed(name string) string {
+	salt := []byte("0123456789abcdefghij")
+	h := hmac.New(sha1.New, salt)
+	h.Write([]byte(name))
+	return fmt.Sprintf("|1|%s|%s", base64.StdEncoding.EncodeToString(salt), base64.StdEncoding.EncodeToString(h.Sum(nil)))
+}
+
+func TestKnownHosts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knownhosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var keys []ssh.PublicKey
+	for i := 0; i < 4; i++ {
+		_, s := newKey(t)
+		keys = append(keys, s.PublicKey())
+	}
+	line := func(hosts string, k ssh.PublicKey) string {
+		return fmt.Sprintf("%s %s", hosts, ssh.MarshalAuthorizedKey(k))
+	}
+	path := filepath.Join(dir, "known_hosts")
+	global := filepath.Join(dir, "ssh_known_hosts")
+	content := "# A comment\n\n" +
+		line("example.com,10.0.0.1", keys[0]) +
+		line("[example.com]:2222", keys[1]) +
+		"bad line\n" +
+		line(hashed("hashed.example.com"), keys[1]) +
+		line("*.wild.org,!bad.wild.org", keys[0]) +
+		line("@revoked *", keys[3])
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(global, []byte(line("global.org", keys[2])), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := loadKnownHosts(path, global, filepath.Join(dir, "nosuch"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(p func(string, bool) (string, error)) { prompt = p }(prompt)
+	answer := "no"
+	prompt = func(string, bool) (string, error) {
+		return answer, nil
+	}
+
+	addr := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 22}
+	for _, tt := range []struct {
+		strict string
+		host   string
+		key    ssh.PublicKey
+		answer string
+		ok     bool
+	}{
+		{strict: "yes", host: "example.com:22", key: keys[0], ok: true},
+		{strict: "yes", host: "EXAMPLE.com:22", key: keys[0], ok: true},
+		{strict: "yes", host: "10.0.0.1:22", key: keys[0], ok: true},
+		// Another port is another host.
+		{strict: "yes", host: "example.com:2222", key: keys[1], ok: true},
+		{strict: "yes", host: "example.com:2222", key: keys[0]},
+		{strict: "yes", host: "hashed.example.com:22", key: keys[1], ok: true},
+		{strict: "yes", host: "a.wild.org:22", key: keys[0], ok: true},
+		{strict: "yes", host: "bad.wild.org:22", key: keys[0]},
+		{strict: "yes", host: "global.org:22", key: keys[2], ok: true},
+		// Changed and revoked keys are never accepted.
+		{strict: "no", host: "example.com:22", key: keys[2]},
+		{strict: "no", host: "new.org:22", key: keys[3]},
+		// New hosts.
+		{strict: "yes", host: "new.org:22", key: keys[2]},
+		{strict: "ask", host: "new.org:22", key: keys[2], answer: "no"},
+		{strict: "ask", host: "new.org:22", key: keys[2], answer: "yes", ok: true},
+		{strict: "yes", host: "new.org:22", key: keys[2], ok: true},
+		{strict: "accept-new", host: "[::1]:2022", key: keys[2], ok: true},
+	} {
+		answer = tt.answer
+		err := k.callback(tt.strict)(tt.host, addr, tt.key)
+		if (err == nil) != tt.ok {
+			t.Errorf("With StrictHostKeyChecking=%s, callback(%s, %s key) = %v, want success %v", tt.strict, tt.host, ssh.FingerprintSHA256(tt.key), err, tt.ok)
+		}
+	}
+
+	// New hosts were added to the first file.
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := content + line("new.org", keys[2]) + line("[::1]:2022", keys[2]); string(b) != want {
+		t.Errorf("known_hosts is %q, want %q", b, want)
+	}
+	k, err = loadKnownHosts(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.callback("yes")("[::1]:2022", addr, keys[2]); err != nil {
+		t.Errorf("Host added before: %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/scp"
+	"golang.org/x/crypto/ssh"
+)
+
+// splitRemote splits arg, a [USER@]HOST:PATH, into [USER@]HOST and PATH.
+// Arguments without a colon before the first slash are local paths. IPv6
+// addresses are in brackets, as in [::1]:PATH.
+func splitRemote(arg string) (dest, path string, ok bool) {
+	host := arg
+	if i := strings.Index(arg, "@"); i >= 0 && !strings.Contains(arg[:i], "/") {
+		host = arg[i+1:]
+	}
+	start := 0
+	if strings.HasPrefix(host, "[") {
+		if start = strings.Index(host, "]"); start < 0 {
+			return "", "", false
+		}
+	}
+	i := strings.Index(host[start:], ":")
+	if i < 0 {
+		return "", "", false
+	}
+	i += start
+	if i == 0 || strings.Contains(host[:i], "/") {
+		return "", "", false
+	}
+	i += len(arg) - len(host)
+	return arg[:i], arg[i+1:], true
+}
+
+// parseCopy parses the arguments of -scp. Exactly one of them is remote.
+func parseCopy(src, dst string) (dest, local, remote string, upload bool, err error) {
+	srcDest, srcPath, srcRemote := splitRemote(src)
+	dstDest, dstPath, dstRemote := splitRemote(dst)
+	switch {
+	case srcRemote && !dstRemote:
+		return srcDest, dst, srcPath, false, nil
+	case dstRemote && !srcRemote:
+		return dstDest, src, dstPath, true, nil
+	}
+	return "", "", "", false, fmt.Errorf("one of %q and %q must be [USER@]HOST:PATH", src, dst)
+}
+
+// quote quotes s for the remote shell.
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// copyFile copies the local file to the remote path if upload is true, and
+// the other way around if not. With -r, directories are copied too. It
+// runs scp on the remote host, and speaks the SCP protocol with it.
+func copyFile(c *ssh.Client, local, remote string, upload bool) error {
+	if remote == "" {
+		// The home directory.
+		remote = "."
+	}
+	s, err := c.NewSession()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	w, err := s.StdinPipe()
+	if err != nil {
+		return err
+	}
+	r, err := s.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	s.Stderr = os.Stderr
+
+	o := scp.Options{Recursive: *recursive}
+	cmd := "scp -f "
+	if upload {
+		cmd = "scp -t "
+	}
+	if o.Recursive {
+		cmd += "-r "
+	}
+	cmd += quote(remote)
+	v("Running %s", cmd)
+	if err := s.Start(cmd); err != nil {
+		return err
+	}
+	if upload {
+		err = scp.Source(w, r, []string{local}, o)
+	} else {
+		err = scp.Sink(w, r, local, o)
+	}
+	// The remote scp is done once its input is.
+	w.Close()
+	if werr := s.Wait(); err == nil {
+		err = werr
+	}
+	return err
+}
@@ -0,0 +1,470 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Ssh logs in to a remote host and runs commands there.
+//
+// Synopsis:
+//     ssh [OPTIONS] [USER@]HOST [COMMAND...]
+//     ssh [OPTIONS] -scp [-r] SOURCE TARGET
+//
+// Description:
+//     Without a command, ssh runs a shell on HOST, on a pty if the standard
+//     input is a terminal, which is then put in raw mode. With a command,
+//     ssh runs it and exits with its exit status, or with 255 if the
+//     connection fails.
+//
+//     Host keys are checked against ~/.ssh/known_hosts and
+//     /etc/ssh/ssh_known_hosts. Users are authenticated with their keys,
+//     or else with a password read from the terminal.
+//
+//     With -scp, one of SOURCE and TARGET is [USER@]HOST:PATH and the
+//     other a local path, which is copied with the remote scp command.
+//
+// Options:
+//     -p:   port to connect to
+//     -l:   user to log in as
+//     -i:   private key file; may be repeated; by default ~/.ssh/id_rsa,
+//           ~/.ssh/id_ecdsa and ~/.ssh/id_ed25519
+//     -L:   [BIND_ADDRESS:]PORT:HOST:HOSTPORT, forward connections to the
+//           local PORT to HOST:HOSTPORT from the remote host; may be repeated
+//     -N:   do not run a command, only forward ports
+//     -t:   run the command on a pty
+//     -T:   do not use a pty
+//     -o:   OPTION=VALUE, where OPTION is one of Port, User, IdentityFile,
+//           StrictHostKeyChecking (yes, no, accept-new or ask) and
+//           UserKnownHostsFile; may be repeated
+//     -scp: copy a file
+//     -r:   with -scp, copy directories recursively
+//     -v:   verbose
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/u-root/u-root/pkg/termios"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/sys/unix"
+)
+
+// stringList is a flag that may be repeated.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+var (
+	port       = flag.Int("p", 22, "Port to connect to")
+	login      = flag.String("l", "", "User to log in as")
+	noCommand  = flag.Bool("N", false, "Do not run a command, only forward ports")
+	forcePTY   = flag.Bool("t", false, "Run the command on a pty")
+	noPTY      = flag.Bool("T", false, "Do not use a pty")
+	copyFiles  = flag.Bool("scp", false, "Copy a file, to or from [USER@]HOST:PATH")
+	recursive  = flag.Bool("r", false, "With -scp, copy directories recursively")
+	verbose    = flag.Bool("v", false, "Verbose")
+	identities stringList
+	forwards   stringList
+	sshOptions stringList
+	v          = func(string, ...interface{}) {}
+)
+
+func init() {
+	flag.Var(&identities, "i", "Private key file; may be repeated")
+	flag.Var(&forwards, "L", "[BIND_ADDRESS:]PORT:HOST:HOSTPORT to forward; may be repeated")
+	flag.Var(&sshOptions, "o", "OPTION=VALUE, as in ssh_config; may be repeated")
+}
+
+// options are the settings of a connection.
+type options struct {
+	user       string
+	host       string
+	port       int
+	identities []string
+	strict     string
+	knownHosts []string
+}
+
+// setOption sets an option given with -o, as Key=Value or "Key Value".
+func (o *options) setOption(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 {
+		kv = strings.Fields(s)
+	}
+	if len(kv) != 2 {
+		return fmt.Errorf("bad option %q, want OPTION=VALUE", s)
+	}
+	key, val := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+	switch strings.ToLower(key) {
+	case "port":
+		p, err := strconv.Atoi(val)
+		if err != nil {
+			return fmt.Errorf("bad port %q", val)
+		}
+		o.port = p
+	case "user":
+		o.user = val
+	case "identityfile":
+		o.identities = append(o.identities, expandHome(val))
+	case "stricthostkeychecking":
+		switch val {
+		case "yes", "no", "accept-new", "ask":
+		default:
+			return fmt.Errorf("bad StrictHostKeyChecking %q, want yes, no, accept-new or ask", val)
+		}
+		o.strict = val
+	case "userknownhostsfile":
+		o.knownHosts = nil
+		for _, f := range strings.Fields(val) {
+			o.knownHosts = append(o.knownHosts, expandHome(f))
+		}
+	default:
+		return fmt.Errorf("unsupported option %q", key)
+	}
+	return nil
+}
+
+// homeDir returns the home directory of the user.
+func homeDir() string {
+	if h := os.Getenv("HOME"); h != "" {
+		return h
+	}
+	if u, err := user.Current(); err == nil {
+		return u.HomeDir
+	}
+	return "/"
+}
+
+// expandHome replaces a leading ~/ in path with the home directory.
+func expandHome(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		return filepath.Join(homeDir(), path[2:])
+	}
+	return path
+}
+
+// userName returns the name of the local user.
+func userName() string {
+	if u := os.Getenv("USER"); u != "" {
+		return u
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return "root"
+}
+
+// setHost sets the user and host from dest, a [USER@]HOST.
+func (o *options) setHost(dest string) error {
+	if i := strings.LastIndex(dest, "@"); i >= 0 {
+		o.user, dest = dest[:i], dest[i+1:]
+	}
+	// IPv6 addresses may be in brackets.
+	o.host = strings.TrimSuffix(strings.TrimPrefix(dest, "["), "]")
+	if o.host == "" {
+		return fmt.Errorf("no host given")
+	}
+	return nil
+}
+
+// prompt writes text to the terminal and reads a line, without echo if
+// echo is false.
+var prompt = func(text string, echo bool) (string, error) {
+	t, err := termios.New()
+	if err != nil {
+		return "", err
+	}
+	if !echo {
+		old, err := t.Get()
+		if err != nil {
+			return "", err
+		}
+		noEcho := *old
+		noEcho.Lflag &^= unix.ECHO
+		if err := t.Set(&noEcho); err != nil {
+			return "", err
+		}
+		defer func() {
+			t.Set(old)
+			t.Write([]byte("\n"))
+		}()
+	}
+	if _, err := t.Write([]byte(text)); err != nil {
+		return "", err
+	}
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := t.Read(b); err != nil {
+			return "", err
+		}
+		if b[0] == '\n' {
+			break
+		}
+		line = append(line, b[0])
+	}
+	return strings.TrimSuffix(string(line), "\r"), nil
+}
+
+// signers returns the keys in files to log in with. Keys that are encrypted
+// are decrypted with a passphrase read from the terminal. Unless explicit
+// is true, files that are missing or cannot be used are skipped.
+func signers(files []string, explicit bool) ([]ssh.Signer, error) {
+	var s []ssh.Signer
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if os.IsNotExist(err) && !explicit {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		k, err := ssh.ParsePrivateKey(b)
+		if err != nil && strings.Contains(err.Error(), "encrypted") {
+			var pass string
+			pass, err = prompt(fmt.Sprintf("Enter passphrase for key '%s': ", f), false)
+			if err == nil {
+				k, err = ssh.ParsePrivateKeyWithPassphrase(b, []byte(pass))
+			}
+		}
+		if err != nil && !explicit {
+			// Other ways to log in may still work.
+			v("Skipping %s: %v", f, err)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f, err)
+		}
+		v("Using key %s from %s", ssh.FingerprintSHA256(k.PublicKey()), f)
+		s = append(s, k)
+	}
+	return s, nil
+}
+
+// dial connects and logs in to the host of o.
+func dial(o *options) (*ssh.Client, error) {
+	files, explicit := o.identities, true
+	if len(files) == 0 {
+		dir := filepath.Join(homeDir(), ".ssh")
+		files = []string{filepath.Join(dir, "id_rsa"), filepath.Join(dir, "id_ecdsa"), filepath.Join(dir, "id_ed25519")}
+		explicit = false
+	}
+	keys, err := signers(files, explicit)
+	if err != nil {
+		return nil, err
+	}
+	k, err := loadKnownHosts(o.knownHosts...)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := net.JoinHostPort(o.host, strconv.Itoa(o.port))
+	password := func() (string, error) {
+		return prompt(fmt.Sprintf("%s@%s's password: ", o.user, o.host), false)
+	}
+	challenge := func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+		var answers []string
+		for i, q := range questions {
+			a, err := prompt(q, echos[i])
+			if err != nil {
+				return nil, err
+			}
+			answers = append(answers, a)
+		}
+		return answers, nil
+	}
+	config := &ssh.ClientConfig{
+		User: o.user,
+		Auth: []ssh.AuthMethod{
+			ssh.PublicKeys(keys...),
+			ssh.RetryableAuthMethod(ssh.PasswordCallback(password), 3),
+			ssh.RetryableAuthMethod(ssh.KeyboardInteractive(challenge), 3),
+		},
+		HostKeyCallback: k.callback(o.strict),
+	}
+	v("Connecting to %s as %s", addr, o.user)
+	return ssh.Dial("tcp", addr, config)
+}
+
+// runSession runs cmd, or a shell if cmd is empty, on a pty if tty is
+// true.
+func runSession(c *ssh.Client, cmd string, tty bool) error {
+	s, err := c.NewSession()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	s.Stdin, s.Stdout, s.Stderr = os.Stdin, os.Stdout, os.Stderr
+
+	if tty {
+		term := os.Getenv("TERM")
+		if term == "" {
+			term = "vt100"
+		}
+		rows, cols := 24, 80
+		// Only a terminal on the standard input is put in raw
+		// mode; with -t, the input may come from elsewhere.
+		if _, err := termios.GetTermios(0); err == nil {
+			t, err := termios.New()
+			if err != nil {
+				return err
+			}
+			if w, err := t.GetWinSize(); err == nil && w.Row > 0 {
+				rows, cols = int(w.Row), int(w.Col)
+			}
+			r, err := t.Raw()
+			if err != nil {
+				return err
+			}
+			defer t.Set(r)
+
+			winch := make(chan os.Signal, 1)
+			signal.Notify(winch, syscall.SIGWINCH)
+			defer signal.Stop(winch)
+			go func() {
+				for range winch {
+					if w, err := t.GetWinSize(); err == nil {
+						s.WindowChange(int(w.Row), int(w.Col))
+					}
+				}
+			}()
+		}
+		if err := s.RequestPty(term, rows, cols, ssh.TerminalModes{}); err != nil {
+			return err
+		}
+	}
+
+	if cmd == "" {
+		if err := s.Shell(); err != nil {
+			return err
+		}
+		return s.Wait()
+	}
+	return s.Run(cmd)
+}
+
+// exitCode returns the exit status for the outcome of a session.
+func exitCode(err error) int {
+	switch e := err.(type) {
+	case nil:
+		return 0
+	case *ssh.ExitError:
+		if e.Signal() != "" {
+			log.Printf("Remote command killed by signal %s", e.Signal())
+			return 255
+		}
+		return e.ExitStatus()
+	default:
+		log.Print(err)
+		return 255
+	}
+}
+
+func run() int {
+	o := &options{
+		user:       *login,
+		port:       *port,
+		identities: identities,
+		strict:     "ask",
+		knownHosts: []string{filepath.Join(homeDir(), ".ssh", "known_hosts"), "/etc/ssh/ssh_known_hosts"},
+	}
+	for _, s := range sshOptions {
+		if err := o.setOption(s); err != nil {
+			log.Print(err)
+			return 255
+		}
+	}
+	if o.user == "" {
+		o.user = userName()
+	}
+
+	args := flag.Args()
+	var local, remote string
+	var upload bool
+	if *copyFiles {
+		if len(args) != 2 {
+			flag.Usage()
+			return 255
+		}
+		var dest string
+		var err error
+		if dest, local, remote, upload, err = parseCopy(args[0], args[1]); err != nil {
+			log.Print(err)
+			return 255
+		}
+		args = []string{dest}
+	}
+	if len(args) == 0 {
+		flag.Usage()
+		return 255
+	}
+	if err := o.setHost(args[0]); err != nil {
+		log.Print(err)
+		return 255
+	}
+
+	c, err := dial(o)
+	if err != nil {
+		log.Print(err)
+		return 255
+	}
+	defer c.Close()
+
+	for _, f := range forwards {
+		l, err := forward(c, f)
+		if err != nil {
+			log.Print(err)
+			return 255
+		}
+		v("Forwarding %s", l.Addr())
+	}
+
+	switch {
+	case *copyFiles:
+		if err := copyFile(c, local, remote, upload); err != nil {
+			log.Print(err)
+			return 1
+		}
+		return 0
+	case *noCommand:
+		return exitCode(c.Wait())
+	}
+
+	cmd := strings.Join(args[1:], " ")
+	tty := cmd == ""
+	if tty {
+		_, err := termios.GetTermios(0)
+		tty = err == nil
+	}
+	if *forcePTY {
+		tty = true
+	}
+	if *noPTY {
+		tty = false
+	}
+	return exitCode(runSession(c, cmd, tty))
+}
+
+func main() {
+	flag.Parse()
+	if *verbose {
+		v = log.Printf
+	}
+	os.Exit(run())
+}
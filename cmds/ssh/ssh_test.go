@@ -0,0 +1,491 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/scp"
+	"github.com/u-root/u-root/pkg/testutil"
+	"golang.org/x/crypto/ssh"
+)
+
+const password = "secret"
+
+// newKey returns a new private key, in PEM, and its signer.
+func newKey(t *testing.T) ([]byte, ssh.Signer) {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := ssh.NewSignerFromKey(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), s
+}
+
+// testServer is an SSH server that accepts a key and a password. It runs
+// commands with sh, except for scp -t and scp -f, and forwards ports.
+type testServer struct {
+	l       net.Listener
+	hostKey ssh.Signer
+	// key is the private key of the client, in PEM.
+	key []byte
+}
+
+func startServer(t *testing.T) *testServer {
+	key, client := newKey(t)
+	_, host := newKey(t)
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(c ssh.ConnMetadata, k ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(k.Marshal(), client.PublicKey().Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown key")
+		},
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if string(pass) == password {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("wrong password")
+		},
+	}
+	config.AddHostKey(host)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(c, config)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for nc := range chans {
+					switch nc.ChannelType() {
+					case "session":
+						ch, reqs, err := nc.Accept()
+						if err != nil {
+							continue
+						}
+						go session(ch, reqs)
+					case "direct-tcpip":
+						go directTCPIP(nc)
+					default:
+						nc.Reject(ssh.UnknownChannelType, "unknown channel type")
+					}
+				}
+			}()
+		}
+	}()
+	return &testServer{l: l, hostKey: host, key: key}
+}
+
+func (s *testServer) port() string {
+	return strconv.Itoa(s.l.Addr().(*net.TCPAddr).Port)
+}
+
+// session serves a session channel.
+func session(ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+	tty := false
+	for req := range reqs {
+		switch req.Type {
+		case "pty-req":
+			tty = true
+			req.Reply(true, nil)
+		case "exec":
+			var r struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &r); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			go ssh.DiscardRequests(reqs)
+			status := runCommand(ch, r.Command, tty)
+			ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+			return
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// runCommand runs cmd for ch and returns its exit status.
+func runCommand(ch ssh.Channel, cmd string, tty bool) uint32 {
+	if f := strings.Fields(cmd); len(f) >= 3 && f[0] == "scp" {
+		path := strings.Trim(f[len(f)-1], "'")
+		var o scp.Options
+		for _, flag := range f[2 : len(f)-1] {
+			o.Recursive = o.Recursive || flag == "-r"
+		}
+		var err error
+		switch f[1] {
+		case "-t":
+			err = scp.Sink(ch, ch, path, o)
+		case "-f":
+			err = scp.Source(ch, ch, []string{path}, o)
+		}
+		if err != nil {
+			fmt.Fprintln(ch.Stderr(), err)
+			return 1
+		}
+		return 0
+	}
+
+	c := exec.Command("sh", "-c", cmd)
+	c.Stdout, c.Stderr = ch, ch.Stderr()
+	if tty {
+		c.Env = append(os.Environ(), "PTY=1")
+	}
+	w, err := c.StdinPipe()
+	if err != nil {
+		return 255
+	}
+	if err := c.Start(); err != nil {
+		return 255
+	}
+	go func() {
+		io.Copy(w, ch)
+		w.Close()
+	}()
+	if err := c.Wait(); err != nil {
+		if e, ok := err.(*exec.ExitError); ok {
+			return uint32(e.Sys().(syscall.WaitStatus).ExitStatus())
+		}
+		return 255
+	}
+	return 0
+}
+
+func directTCPIP(nc ssh.NewChannel) {
+	var r struct {
+		Host       string
+		Port       uint32
+		OriginIP   string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(nc.ExtraData(), &r); err != nil {
+		nc.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	c, err := net.Dial("tcp", net.JoinHostPort(r.Host, strconv.Itoa(int(r.Port))))
+	if err != nil {
+		nc.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	ch, reqs, err := nc.Accept()
+	if err != nil {
+		c.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	go func() {
+		io.Copy(ch, c)
+		ch.CloseWrite()
+	}()
+	io.Copy(c, ch)
+	c.(*net.TCPConn).CloseWrite()
+}
+
+// setup starts a server and returns it, a directory with the client key
+// in it, and the arguments to log in to the server with that key.
+func setup(t *testing.T) (*testServer, string, []string) {
+	s := startServer(t)
+	dir, err := ioutil.TempDir("", "ssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := filepath.Join(dir, "id_ecdsa")
+	if err := ioutil.WriteFile(key, s.key, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return s, dir, []string{
+		"-p", s.port(),
+		"-i", key,
+		"-o", "UserKnownHostsFile=" + filepath.Join(dir, "known_hosts"),
+	}
+}
+
+func TestCommand(t *testing.T) {
+	s, dir, args := setup(t)
+	defer s.l.Close()
+	defer os.RemoveAll(dir)
+
+	// The host is unknown.
+	c := testutil.Command(t, append(args, "-o", "StrictHostKeyChecking=yes", "127.0.0.1", "true")...)
+	if out, err := c.CombinedOutput(); testutil.IsExitCode(err, 255) != nil {
+		t.Errorf("Login to an unknown host: got %v, %q, want exit status 255", err, out)
+	}
+
+	// And then it is added.
+	c = testutil.Command(t, append(args, "-o", "StrictHostKeyChecking=accept-new", "127.0.0.1", "echo", "hello;", "exit 3")...)
+	out, err := c.Output()
+	if err := testutil.IsExitCode(err, 3); err != nil {
+		t.Error(err)
+	}
+	if string(out) != "hello\n" {
+		t.Errorf("Got %q, want %q", out, "hello\n")
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "known_hosts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("[127.0.0.1]:%s %s", s.port(), ssh.MarshalAuthorizedKey(s.hostKey.PublicKey())); string(b) != want {
+		t.Errorf("known_hosts has %q, want %q", b, want)
+	}
+
+	// Now it is known, and a pty can be asked for.
+	c = testutil.Command(t, append(args, "-o", "StrictHostKeyChecking=yes", "-t", "127.0.0.1", "echo $PTY")...)
+	if out, err := c.CombinedOutput(); err != nil || string(out) != "1\n" {
+		t.Errorf("With -t: got %q, %v, want %q", out, err, "1\n")
+	}
+}
+
+func TestSCP(t *testing.T) {
+	s, dir, args := setup(t)
+	defer s.l.Close()
+	defer os.RemoveAll(dir)
+	args = append(args, "-o", "StrictHostKeyChecking=no", "-scp")
+
+	src := filepath.Join(dir, "src")
+	if err := ioutil.WriteFile(src, []byte("some data"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	remote := filepath.Join(dir, "remote")
+	if out, err := testutil.Command(t, append(args, src, "root@127.0.0.1:"+remote)...).CombinedOutput(); err != nil {
+		t.Fatalf("Upload: %v, %s", err, out)
+	}
+	if b, err := ioutil.ReadFile(remote); err != nil || string(b) != "some data" {
+		t.Errorf("Uploaded %q, %v, want %q", b, err, "some data")
+	}
+
+	dst := filepath.Join(dir, "dst")
+	if out, err := testutil.Command(t, append(args, "127.0.0.1:"+remote, dst)...).CombinedOutput(); err != nil {
+		t.Fatalf("Download: %v, %s", err, out)
+	}
+	if b, err := ioutil.ReadFile(dst); err != nil || string(b) != "some data" {
+		t.Errorf("Downloaded %q, %v, want %q", b, err, "some data")
+	}
+
+	// Directories need -r.
+	tree := filepath.Join(dir, "tree")
+	if err := os.MkdirAll(filepath.Join(tree, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tree, "sub", "file"), []byte("deep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := testutil.Command(t, append(args, tree, "127.0.0.1:"+filepath.Join(dir, "copy"))...)
+	if err := testutil.IsExitCode(c.Run(), 1); err != nil {
+		t.Errorf("Upload of a directory without -r: %v", err)
+	}
+	if out, err := testutil.Command(t, append(args, "-r", "127.0.0.1:"+tree, filepath.Join(dir, "copy"))...).CombinedOutput(); err != nil {
+		t.Fatalf("Recursive download: %v, %s", err, out)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "copy", "sub", "file")); err != nil || string(b) != "deep" {
+		t.Errorf("Downloaded %q, %v, want %q", b, err, "deep")
+	}
+}
+
+// testOptions returns the options to log in to s, with the known_hosts
+// file in dir.
+func testOptions(s *testServer, dir string) *options {
+	p, _ := strconv.Atoi(s.port())
+	return &options{
+		user:       "root",
+		host:       "127.0.0.1",
+		port:       p,
+		strict:     "no",
+		knownHosts: []string{filepath.Join(dir, "known_hosts")},
+	}
+}
+
+func TestForward(t *testing.T) {
+	s, dir, _ := setup(t)
+	defer s.l.Close()
+	defer os.RemoveAll(dir)
+	o := testOptions(s, dir)
+	o.identities = []string{filepath.Join(dir, "id_ecdsa")}
+	c, err := dial(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// The service answers in upper case.
+	ul, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ul.Close()
+	go func() {
+		for {
+			c, err := ul.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				b, _ := ioutil.ReadAll(c)
+				c.Write(bytes.ToUpper(b))
+				c.Close()
+			}()
+		}
+	}()
+
+	l, err := forward(c, "127.0.0.1:0:"+ul.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(conn, "hello %d", i)
+		conn.(*net.TCPConn).CloseWrite()
+		b, err := ioutil.ReadAll(conn)
+		conn.Close()
+		if want := fmt.Sprintf("HELLO %d", i); err != nil || string(b) != want {
+			t.Errorf("Got %q, %v, want %q", b, err, want)
+		}
+	}
+
+	// Forwarding ends with the connection.
+	c.Close()
+	c.Wait()
+	if conn, err := net.Dial("tcp", l.Addr().String()); err == nil {
+		conn.Close()
+		t.Errorf("Dial(%s) succeeded after closing the connection", l.Addr())
+	}
+}
+
+func TestPassword(t *testing.T) {
+	s, dir, _ := setup(t)
+	defer s.l.Close()
+	defer os.RemoveAll(dir)
+	defer func(h string) { os.Setenv("HOME", h) }(os.Getenv("HOME"))
+	// No keys are found there.
+	os.Setenv("HOME", dir)
+
+	defer func(p func(string, bool) (string, error)) { prompt = p }(prompt)
+	var prompts []string
+	answer := "wrong"
+	prompt = func(text string, echo bool) (string, error) {
+		if echo {
+			t.Errorf("Prompt %q echoes the password", text)
+		}
+		prompts = append(prompts, text)
+		return answer, nil
+	}
+
+	if _, err := dial(testOptions(s, dir)); err == nil {
+		t.Errorf("Login with a wrong password succeeded")
+	}
+	if want := []string{"root@127.0.0.1's password: ", "root@127.0.0.1's password: ", "root@127.0.0.1's password: "}; !reflect.DeepEqual(prompts, want) {
+		t.Errorf("Prompts are %q, want %q", prompts, want)
+	}
+
+	answer = password
+	c, err := dial(testOptions(s, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+}
+
+func TestParseForward(t *testing.T) {
+	for _, tt := range []struct {
+		spec          string
+		local, remote string
+		err           bool
+	}{
+		{spec: "8080:web:80", local: "localhost:8080", remote: "web:80"},
+		{spec: "*:8080:web:80", local: ":8080", remote: "web:80"},
+		{spec: ":8080:web:80", local: ":8080", remote: "web:80"},
+		{spec: "10.0.0.1:8080:[fe80::1]:80", local: "10.0.0.1:8080", remote: "[fe80::1]:80"},
+		{spec: "[::1]:8080:web:80", local: "[::1]:8080", remote: "web:80"},
+		{spec: "8080:web", err: true},
+		{spec: "8080::80", err: true},
+		{spec: "a:b:c:d:e", err: true},
+	} {
+		local, remote, err := parseForward(tt.spec)
+		if (err != nil) != tt.err || local != tt.local || remote != tt.remote {
+			t.Errorf("parseForward(%q) = %q, %q, %v, want %q, %q, error %v", tt.spec, local, remote, err, tt.local, tt.remote, tt.err)
+		}
+	}
+}
+
+func TestSplitRemote(t *testing.T) {
+	for _, tt := range []struct {
+		arg        string
+		dest, path string
+		ok         bool
+	}{
+		{arg: "host:file", dest: "host", path: "file", ok: true},
+		{arg: "user@host:/tmp/a:b", dest: "user@host", path: "/tmp/a:b", ok: true},
+		{arg: "host:", dest: "host", path: "", ok: true},
+		{arg: "user@[::1]:file", dest: "user@[::1]", path: "file", ok: true},
+		{arg: "file"},
+		{arg: "./a:b"},
+		{arg: "/tmp/user@host:x"},
+		{arg: ":file"},
+	} {
+		dest, path, ok := splitRemote(tt.arg)
+		if dest != tt.dest || path != tt.path || ok != tt.ok {
+			t.Errorf("splitRemote(%q) = %q, %q, %v, want %q, %q, %v", tt.arg, dest, path, ok, tt.dest, tt.path, tt.ok)
+		}
+	}
+}
+
+func TestSetOption(t *testing.T) {
+	o := &options{}
+	for _, s := range []string{"Port=2222", "user alice", "StrictHostKeyChecking=accept-new", "UserKnownHostsFile=/a /b", "IdentityFile=/key"} {
+		if err := o.setOption(s); err != nil {
+			t.Errorf("setOption(%q) = %v", s, err)
+		}
+	}
+	want := &options{port: 2222, user: "alice", strict: "accept-new", knownHosts: []string{"/a", "/b"}, identities: []string{"/key"}}
+	if !reflect.DeepEqual(o, want) {
+		t.Errorf("Got %+v, want %+v", o, want)
+	}
+	for _, s := range []string{"Port=x", "StrictHostKeyChecking=maybe", "ForwardAgent=yes", "Port"} {
+		if err := o.setOption(s); err == nil {
+			t.Errorf("setOption(%q) succeeded, want error", s)
+		}
+	}
+}
+
+func TestMain(m *testing.M) {
+	testutil.Run(m, main)
+}
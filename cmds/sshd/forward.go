@@ -0,0 +1,189 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/u-root/u-root/pkg/uio"
+	"golang.org/x/crypto/ssh"
+)
+
+// Port forwarding messages, RFC 4254, Section 7.
+type (
+	directTCPIPReq struct {
+		Host       string
+		Port       uint32
+		OriginIP   string
+		OriginPort uint32
+	}
+	tcpipForwardReq struct {
+		BindAddr string
+		BindPort uint32
+	}
+	tcpipForwardReply struct {
+		Port uint32
+	}
+	forwardedTCPIPReq struct {
+		Addr       string
+		Port       uint32
+		OriginIP   string
+		OriginPort uint32
+	}
+)
+
+// directTCPIP serves a direct-tcpip channel, as opened by ssh -L.
+func directTCPIP(nc ssh.NewChannel) {
+	r := &directTCPIPReq{}
+	if err := ssh.Unmarshal(nc.ExtraData(), r); err != nil {
+		nc.Reject(ssh.ConnectionFailed, "bad direct-tcpip request")
+		return
+	}
+	addr := net.JoinHostPort(r.Host, strconv.Itoa(int(r.Port)))
+	dprintf("Forwarding to %s for %s:%d", addr, r.OriginIP, r.OriginPort)
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		nc.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	ch, reqs, err := nc.Accept()
+	if err != nil {
+		c.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	uio.Relay(ch, c)
+}
+
+// forwards holds the listeners of tcpip-forward requests, as made by
+// ssh -R, of a connection.
+type forwards struct {
+	conn ssh.Conn
+
+	mu        sync.Mutex
+	listeners map[string]net.Listener
+}
+
+func newForwards(conn ssh.Conn) *forwards {
+	return &forwards{conn: conn, listeners: make(map[string]net.Listener)}
+}
+
+// serve serves the global requests of the connection.
+func (f *forwards) serve(reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		dprintf("Global request %v", req.Type)
+		var reply []byte
+		var err error
+		switch req.Type {
+		case "tcpip-forward":
+			reply, err = f.add(req.Payload)
+		case "cancel-tcpip-forward":
+			err = f.cancel(req.Payload)
+		default:
+			// Like keepalive@openssh.com.
+			err = fmt.Errorf("unknown request")
+		}
+		if err != nil {
+			dprintf("%s: %v", req.Type, err)
+		}
+		if req.WantReply {
+			req.Reply(err == nil, reply)
+		}
+	}
+}
+
+// bindAddr returns the address to listen on for r.
+func bindAddr(r *tcpipForwardReq) string {
+	host := r.BindAddr
+	switch host {
+	case "", "*", "0.0.0.0", "::":
+		// All addresses, of both families.
+		host = ""
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(r.BindPort)))
+}
+
+func (f *forwards) add(payload []byte) ([]byte, error) {
+	r := &tcpipForwardReq{}
+	if err := ssh.Unmarshal(payload, r); err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("tcp", bindAddr(r))
+	if err != nil {
+		return nil, err
+	}
+	// Clients cancel with the port the server picked.
+	port := uint32(l.Addr().(*net.TCPAddr).Port)
+	f.mu.Lock()
+	f.listeners[net.JoinHostPort(r.BindAddr, strconv.Itoa(int(port)))] = l
+	f.mu.Unlock()
+	log.Printf("Forwarding %s to the client", l.Addr())
+	go f.accept(l, r.BindAddr, port)
+
+	// The port is only sent when the client let the server pick it.
+	if r.BindPort != 0 {
+		return nil, nil
+	}
+	return ssh.Marshal(tcpipForwardReply{port}), nil
+}
+
+func (f *forwards) cancel(payload []byte) error {
+	r := &tcpipForwardReq{}
+	if err := ssh.Unmarshal(payload, r); err != nil {
+		return err
+	}
+	key := net.JoinHostPort(r.BindAddr, strconv.Itoa(int(r.BindPort)))
+	f.mu.Lock()
+	l, ok := f.listeners[key]
+	delete(f.listeners, key)
+	f.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no forwarding from %s", key)
+	}
+	return l.Close()
+}
+
+// accept opens a forwarded-tcpip channel for each connection to l. The
+// client identifies the forwarding by the address it asked for and the
+// port that l listens on.
+func (f *forwards) accept(l net.Listener, addr string, port uint32) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			origin := c.RemoteAddr().(*net.TCPAddr)
+			r := forwardedTCPIPReq{
+				Addr:       addr,
+				Port:       port,
+				OriginIP:   origin.IP.String(),
+				OriginPort: uint32(origin.Port),
+			}
+			ch, reqs, err := f.conn.OpenChannel("forwarded-tcpip", ssh.Marshal(r))
+			if err != nil {
+				dprintf("forwarded-tcpip: %v", err)
+				c.Close()
+				return
+			}
+			go ssh.DiscardRequests(reqs)
+			uio.Relay(ch, c)
+		}()
+	}
+}
+
+// close stops all forwarding.
+func (f *forwards) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for k, l := range f.listeners {
+		l.Close()
+		delete(f.listeners, k)
+	}
+}
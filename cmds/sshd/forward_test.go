@@ -0,0 +1,106 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+)
+
+// upper answers each connection to l with what it got, in upper case.
+func upper(l net.Listener) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			b, _ := ioutil.ReadAll(c)
+			c.Write(bytes.ToUpper(b))
+			c.Close()
+		}()
+	}
+}
+
+// ask sends s over c and returns the answer.
+func ask(c net.Conn, s string) (string, error) {
+	defer c.Close()
+	if _, err := io.WriteString(c, s); err != nil {
+		return "", err
+	}
+	type closeWriter interface {
+		CloseWrite() error
+	}
+	if err := c.(closeWriter).CloseWrite(); err != nil {
+		return "", err
+	}
+	b, err := ioutil.ReadAll(c)
+	return string(b), err
+}
+
+func TestDirectTCPIP(t *testing.T) {
+	c := startServer(t)
+	defer c.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go upper(l)
+
+	for i := 0; i < 3; i++ {
+		conn, err := c.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := fmt.Sprintf("hello %d", i)
+		if got, err := ask(conn, s); err != nil || got != strings.ToUpper(s) {
+			t.Errorf("Got %q, %v, want %q", got, err, strings.ToUpper(s))
+		}
+	}
+
+	// Nothing listens there any more.
+	addr := l.Addr().String()
+	l.Close()
+	if conn, err := c.Dial("tcp", addr); err == nil {
+		conn.Close()
+		t.Errorf("Dial(%s) succeeded, want error", addr)
+	}
+}
+
+func TestTCPIPForward(t *testing.T) {
+	c := startServer(t)
+	defer c.Close()
+
+	// The server listens, and the client answers.
+	l, err := c.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go upper(l)
+	addr := l.Addr().String()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ask(conn, "forwarded"); err != nil || got != "FORWARDED" {
+		t.Errorf("Got %q, %v, want %q", got, err, "FORWARDED")
+	}
+
+	// Canceling the forwarding closes the port on the server.
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if conn, err := net.Dial("tcp", addr); err == nil {
+		conn.Close()
+		t.Errorf("Dial(%s) succeeded after canceling, want error", addr)
+	}
+}
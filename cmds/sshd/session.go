@@ -0,0 +1,336 @@
+// Copyright 2018-2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/u-root/u-root/pkg/pty"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/sys/unix"
+)
+
+// The ssh package does not define these things so we will
+type (
+	ptyReq struct {
+		TERM   string //TERM environment variable value (e.g., vt100)
+		Col    uint32
+		Row    uint32
+		Xpixel uint32
+		Ypixel uint32
+		Modes  string //encoded terminal modes
+	}
+	windowChangeReq struct {
+		Col    uint32
+		Row    uint32
+		Xpixel uint32
+		Ypixel uint32
+	}
+	envReq struct {
+		Name  string
+		Value string
+	}
+	execReq struct {
+		Command string
+	}
+	subsystemReq struct {
+		Name string
+	}
+	signalReq struct {
+		Signal string
+	}
+	exitStatusReq struct {
+		ExitStatus uint32
+	}
+	exitSignalReq struct {
+		Signal     string
+		CoreDumped bool
+		Error      string
+		Lang       string
+	}
+)
+
+// signals are the signals of RFC 4254, Section 6.10.
+var signals = map[string]syscall.Signal{
+	"ABRT": syscall.SIGABRT,
+	"ALRM": syscall.SIGALRM,
+	"FPE":  syscall.SIGFPE,
+	"HUP":  syscall.SIGHUP,
+	"ILL":  syscall.SIGILL,
+	"INT":  syscall.SIGINT,
+	"KILL": syscall.SIGKILL,
+	"PIPE": syscall.SIGPIPE,
+	"QUIT": syscall.SIGQUIT,
+	"SEGV": syscall.SIGSEGV,
+	"TERM": syscall.SIGTERM,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// session is a session channel, which runs one shell, command or
+// subsystem. A connection may have many sessions at once.
+type session struct {
+	c   ssh.Channel
+	env []string
+	p   *pty.Pty
+
+	mu      sync.Mutex
+	started bool
+	proc    *os.Process
+}
+
+// serveSession serves the requests on a session channel.
+func serveSession(c ssh.Channel, reqs <-chan *ssh.Request) {
+	s := &session{c: c}
+	defer s.close()
+	for req := range reqs {
+		dprintf("Request %v", req.Type)
+		err := s.request(req)
+		if err != nil {
+			log.Printf("%s request: %v", req.Type, err)
+		}
+		if req.WantReply {
+			req.Reply(err == nil, nil)
+		}
+	}
+}
+
+// close kills what still runs when the client goes away.
+func (s *session) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.proc != nil {
+		s.proc.Kill()
+	}
+	if s.p != nil && !s.started {
+		s.p.Ptm.Close()
+		s.p.Pts.Close()
+	}
+}
+
+func (s *session) request(req *ssh.Request) error {
+	switch req.Type {
+	case "pty-req":
+		r := &ptyReq{}
+		if err := ssh.Unmarshal(req.Payload, r); err != nil {
+			return err
+		}
+		return s.newPTY(r)
+
+	case "window-change":
+		r := &windowChangeReq{}
+		if err := ssh.Unmarshal(req.Payload, r); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.p == nil {
+			return fmt.Errorf("no pty")
+		}
+		return s.p.SetWinSize(&unix.Winsize{Row: uint16(r.Row), Col: uint16(r.Col), Xpixel: uint16(r.Xpixel), Ypixel: uint16(r.Ypixel)})
+
+	case "env":
+		r := &envReq{}
+		if err := ssh.Unmarshal(req.Payload, r); err != nil {
+			return err
+		}
+		s.env = append(s.env, r.Name+"="+r.Value)
+		return nil
+
+	case "signal":
+		r := &signalReq{}
+		if err := ssh.Unmarshal(req.Payload, r); err != nil {
+			return err
+		}
+		sig, ok := signals[r.Signal]
+		if !ok {
+			return fmt.Errorf("unknown signal %q", r.Signal)
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.proc == nil {
+			return fmt.Errorf("nothing runs")
+		}
+		return s.proc.Signal(sig)
+
+	case "shell":
+		return s.start(shell)
+
+	case "exec":
+		r := &execReq{}
+		if err := ssh.Unmarshal(req.Payload, r); err != nil {
+			return err
+		}
+		// Execute command using user's shell. This is what OpenSSH does
+		// so it's the least surprising to the user.
+		return s.start(shell, "-c", r.Command)
+
+	case "subsystem":
+		r := &subsystemReq{}
+		if err := ssh.Unmarshal(req.Payload, r); err != nil {
+			return err
+		}
+		if r.Name != "sftp" {
+			return fmt.Errorf("unknown subsystem %q", r.Name)
+		}
+		if err := s.setStarted(); err != nil {
+			return err
+		}
+		log.Printf("Starting sftp")
+		go func() {
+			if err := serveSFTP(s.c); err != nil {
+				log.Printf("sftp: %v", err)
+			}
+			s.exit(nil)
+		}()
+		return nil
+	}
+	return fmt.Errorf("unknown request %q", req.Type)
+}
+
+func (s *session) newPTY(r *ptyReq) error {
+	dprintf("newPTY: %q", r)
+	if s.p != nil {
+		return fmt.Errorf("pty already allocated")
+	}
+	p, err := pty.Open()
+	if err != nil {
+		return err
+	}
+	ws := &unix.Winsize{Row: uint16(r.Row), Col: uint16(r.Col), Xpixel: uint16(r.Xpixel), Ypixel: uint16(r.Ypixel)}
+	dprintf("newPTY: Set winsizes to %v", ws)
+	if err := p.SetWinSize(ws); err != nil {
+		p.Ptm.Close()
+		p.Pts.Close()
+		return err
+	}
+	s.mu.Lock()
+	s.p = p
+	s.mu.Unlock()
+	s.env = append(s.env, "TERM="+r.TERM)
+	return nil
+}
+
+// setStarted makes sure that only one thing runs in the session.
+func (s *session) setStarted() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return fmt.Errorf("session already started")
+	}
+	s.started = true
+	return nil
+}
+
+// start starts a command
+// TODO: use /etc/passwd, but the Go support for that is incomplete
+func (s *session) start(cmd string, args ...string) error {
+	if err := s.setStarted(); err != nil {
+		return err
+	}
+	env := append(os.Environ(), s.env...)
+
+	if s.p != nil {
+		p := s.p
+		log.Printf("Executing PTY command %s %v", cmd, args)
+		p.Command(cmd, args...)
+		p.C.Env = env
+		err := p.C.Start()
+		// The command has its own copy now; once it is gone, reading
+		// Ptm ends.
+		p.Pts.Close()
+		if err != nil {
+			s.mu.Lock()
+			p.Ptm.Close()
+			s.p = nil
+			s.mu.Unlock()
+			dprintf("Failed to execute: %v", err)
+			return err
+		}
+		s.setProc(p.C.Process)
+		go io.Copy(p.Ptm, s.c)
+		done := make(chan struct{})
+		go func() {
+			io.Copy(s.c, p.Ptm)
+			close(done)
+		}()
+		go func() {
+			p.C.Wait()
+			// Background jobs may keep the pty open, but do not keep
+			// the session.
+			p.Ptm.SetReadDeadline(time.Now().Add(time.Second))
+			<-done
+			s.mu.Lock()
+			p.Ptm.Close()
+			s.p = nil
+			s.mu.Unlock()
+			s.exit(p.C.ProcessState)
+		}()
+		return nil
+	}
+
+	e := exec.Command(cmd, args...)
+	e.Env = env
+	e.Stdout, e.Stderr = s.c, s.c.Stderr()
+	// Unlike with e.Stdin, e.Wait does not wait for the client to close
+	// its end when the command does not read everything.
+	in, err := e.StdinPipe()
+	if err != nil {
+		return err
+	}
+	log.Printf("Executing non-PTY command %s %v", cmd, args)
+	if err := e.Start(); err != nil {
+		dprintf("Failed to execute: %v", err)
+		return err
+	}
+	s.setProc(e.Process)
+	go func() {
+		io.Copy(in, s.c)
+		in.Close()
+	}()
+	go func() {
+		e.Wait()
+		s.exit(e.ProcessState)
+	}()
+	return nil
+}
+
+func (s *session) setProc(p *os.Process) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.proc = p
+}
+
+// exit tells the client how the command ended, and closes the session.
+func (s *session) exit(ps *os.ProcessState) {
+	s.setProc(nil)
+	var ws syscall.WaitStatus
+	if ps != nil {
+		ws = ps.Sys().(syscall.WaitStatus)
+	}
+	switch {
+	case ws.Signaled():
+		name := fmt.Sprintf("SIG%d", ws.Signal())
+		for n, sig := range signals {
+			if sig == ws.Signal() {
+				name = n
+			}
+		}
+		dprintf("Exit signal %v", name)
+		s.c.SendRequest("exit-signal", false, ssh.Marshal(exitSignalReq{Signal: name, CoreDumped: ws.CoreDump()}))
+	default:
+		code := uint32(ws.ExitStatus())
+		dprintf("Exit status %v", code)
+		s.c.SendRequest("exit-status", false, ssh.Marshal(exitStatusReq{code}))
+	}
+	s.c.Close()
+}
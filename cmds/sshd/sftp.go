@@ -0,0 +1,743 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// SFTP version 3, as in draft-ietf-secsh-filexfer-02, which is what
+// OpenSSH speaks.
+const sftpVersion = 3
+
+// SFTP packet types.
+const (
+	sshFxpInit          = 1
+	sshFxpVersion       = 2
+	sshFxpOpen          = 3
+	sshFxpClose         = 4
+	sshFxpRead          = 5
+	sshFxpWrite         = 6
+	sshFxpLstat         = 7
+	sshFxpFstat         = 8
+	sshFxpSetstat       = 9
+	sshFxpFsetstat      = 10
+	sshFxpOpendir       = 11
+	sshFxpReaddir       = 12
+	sshFxpRemove        = 13
+	sshFxpMkdir         = 14
+	sshFxpRmdir         = 15
+	sshFxpRealpath      = 16
+	sshFxpStat          = 17
+	sshFxpRename        = 18
+	sshFxpReadlink      = 19
+	sshFxpSymlink       = 20
+	sshFxpStatus        = 101
+	sshFxpHandle        = 102
+	sshFxpData          = 103
+	sshFxpName          = 104
+	sshFxpAttrs         = 105
+	sshFxpExtended      = 200
+	sshFxpExtendedReply = 201
+)
+
+// SFTP status codes.
+const (
+	sshFxOk               = 0
+	sshFxEOF              = 1
+	sshFxNoSuchFile       = 2
+	sshFxPermissionDenied = 3
+	sshFxFailure          = 4
+	sshFxBadMessage       = 5
+	sshFxOpUnsupported    = 8
+)
+
+// SFTP open flags.
+const (
+	sshFxfRead   = 0x01
+	sshFxfWrite  = 0x02
+	sshFxfAppend = 0x04
+	sshFxfCreat  = 0x08
+	sshFxfTrunc  = 0x10
+	sshFxfExcl   = 0x20
+)
+
+// SFTP attribute flags.
+const (
+	sshFileXferAttrSize        = 0x01
+	sshFileXferAttrUIDGID      = 0x02
+	sshFileXferAttrPermissions = 0x04
+	sshFileXferAttrACModTime   = 0x08
+	sshFileXferAttrExtended    = 0x80000000
+)
+
+const (
+	// maxPacket bounds the packets a client may send. OpenSSH sends
+	// writes of up to 256KiB.
+	maxPacket = 256*1024 + 1024
+	// maxRead bounds the data sent for one read.
+	maxRead = 64 * 1024
+	// readdirCount is how many names one readdir returns at most.
+	readdirCount = 128
+)
+
+// sftpExtensions are the OpenSSH extensions served.
+var sftpExtensions = []string{
+	"posix-rename@openssh.com", "1",
+	"hardlink@openssh.com", "1",
+	"fsync@openssh.com", "1",
+}
+
+var errBadMessage = errors.New("bad message")
+
+// sftpStatus is an error carrying an SFTP status code.
+type sftpStatus struct {
+	code uint32
+	msg  string
+}
+
+func (s *sftpStatus) Error() string {
+	return s.msg
+}
+
+// attrs are file attributes.
+type attrs struct {
+	flags       uint32
+	size        uint64
+	uid, gid    uint32
+	permissions uint32
+	atime       uint32
+	mtime       uint32
+}
+
+// packet decodes SFTP packet data. The first error sticks.
+type packet struct {
+	b   []byte
+	err error
+}
+
+func (p *packet) uint32() uint32 {
+	if len(p.b) < 4 {
+		p.err = errBadMessage
+		return 0
+	}
+	v := binary.BigEndian.Uint32(p.b)
+	p.b = p.b[4:]
+	return v
+}
+
+func (p *packet) uint64() uint64 {
+	if len(p.b) < 8 {
+		p.err = errBadMessage
+		return 0
+	}
+	v := binary.BigEndian.Uint64(p.b)
+	p.b = p.b[8:]
+	return v
+}
+
+func (p *packet) string() string {
+	n := p.uint32()
+	if uint32(len(p.b)) < n {
+		p.err = errBadMessage
+		return ""
+	}
+	s := string(p.b[:n])
+	p.b = p.b[n:]
+	return s
+}
+
+func (p *packet) attrs() attrs {
+	a := attrs{flags: p.uint32()}
+	if a.flags&sshFileXferAttrSize != 0 {
+		a.size = p.uint64()
+	}
+	if a.flags&sshFileXferAttrUIDGID != 0 {
+		a.uid, a.gid = p.uint32(), p.uint32()
+	}
+	if a.flags&sshFileXferAttrPermissions != 0 {
+		a.permissions = p.uint32()
+	}
+	if a.flags&sshFileXferAttrACModTime != 0 {
+		a.atime, a.mtime = p.uint32(), p.uint32()
+	}
+	if a.flags&sshFileXferAttrExtended != 0 {
+		for n := p.uint32(); n > 0 && p.err == nil; n-- {
+			p.string()
+			p.string()
+		}
+	}
+	return a
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v>>32)), uint32(v))
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(appendUint32(b, uint32(len(s))), s...)
+}
+
+func appendAttrs(b []byte, a attrs) []byte {
+	b = appendUint32(b, a.flags)
+	if a.flags&sshFileXferAttrSize != 0 {
+		b = appendUint64(b, a.size)
+	}
+	if a.flags&sshFileXferAttrUIDGID != 0 {
+		b = appendUint32(appendUint32(b, a.uid), a.gid)
+	}
+	if a.flags&sshFileXferAttrPermissions != 0 {
+		b = appendUint32(b, a.permissions)
+	}
+	if a.flags&sshFileXferAttrACModTime != 0 {
+		b = appendUint32(appendUint32(b, a.atime), a.mtime)
+	}
+	return b
+}
+
+// fileAttrs returns the attributes of fi.
+func fileAttrs(fi os.FileInfo) attrs {
+	a := attrs{
+		flags: sshFileXferAttrSize | sshFileXferAttrPermissions | sshFileXferAttrACModTime,
+		size:  uint64(fi.Size()),
+		mtime: uint32(fi.ModTime().Unix()),
+	}
+	a.atime = a.mtime
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		a.flags |= sshFileXferAttrUIDGID
+		a.uid, a.gid = st.Uid, st.Gid
+		a.permissions = st.Mode
+		a.atime = uint32(st.Atim.Sec)
+	} else {
+		a.permissions = uint32(fi.Mode().Perm())
+		if fi.IsDir() {
+			a.permissions |= unix.S_IFDIR
+		} else {
+			a.permissions |= unix.S_IFREG
+		}
+	}
+	return a
+}
+
+// longName formats fi like ls -l, which clients show as it is.
+func longName(fi os.FileInfo, a attrs) string {
+	mode := []byte("?rwxrwxrwx")
+	switch a.permissions & unix.S_IFMT {
+	case unix.S_IFREG:
+		mode[0] = '-'
+	case unix.S_IFDIR:
+		mode[0] = 'd'
+	case unix.S_IFLNK:
+		mode[0] = 'l'
+	case unix.S_IFCHR:
+		mode[0] = 'c'
+	case unix.S_IFBLK:
+		mode[0] = 'b'
+	case unix.S_IFIFO:
+		mode[0] = 'p'
+	case unix.S_IFSOCK:
+		mode[0] = 's'
+	}
+	for i := uint(0); i < 9; i++ {
+		if a.permissions&(1<<(8-i)) == 0 {
+			mode[i+1] = '-'
+		}
+	}
+	nlink := uint64(1)
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		nlink = uint64(st.Nlink)
+	}
+	t := fi.ModTime()
+	date := t.Format("Jan _2 15:04")
+	if time.Since(t) > 180*24*time.Hour {
+		date = t.Format("Jan _2  2006")
+	}
+	return fmt.Sprintf("%s %4d %-8d %-8d %8d %s %s", mode, nlink, a.uid, a.gid, a.size, date, fi.Name())
+}
+
+// dirHandle is an open directory.
+type dirHandle struct {
+	f    *os.File
+	path string
+}
+
+// fileHandle is an open file.
+type fileHandle struct {
+	f      *os.File
+	append bool
+}
+
+// sftpServer serves SFTP requests, one after the other.
+type sftpServer struct {
+	rw      io.ReadWriter
+	handles map[string]interface{}
+	next    uint64
+}
+
+// serveSFTP serves SFTP on rw until the client goes away.
+func serveSFTP(rw io.ReadWriter) error {
+	s := &sftpServer{rw: rw, handles: make(map[string]interface{})}
+	defer s.closeAll()
+	var hdr [4]byte
+	for {
+		if _, err := io.ReadFull(rw, hdr[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		n := binary.BigEndian.Uint32(hdr[:])
+		if n < 1 || n > maxPacket {
+			return fmt.Errorf("sftp: bad packet length %d", n)
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(rw, b); err != nil {
+			return err
+		}
+		if err := s.handle(b[0], &packet{b: b[1:]}); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *sftpServer) closeAll() {
+	for _, h := range s.handles {
+		switch h := h.(type) {
+		case *fileHandle:
+			h.f.Close()
+		case *dirHandle:
+			h.f.Close()
+		}
+	}
+}
+
+func (s *sftpServer) send(typ byte, b []byte) error {
+	hdr := appendUint32(nil, uint32(len(b)+1))
+	_, err := s.rw.Write(append(append(hdr, typ), b...))
+	return err
+}
+
+// status sends the status that err stands for.
+func (s *sftpServer) status(id uint32, err error) error {
+	code, msg := uint32(sshFxOk), "OK"
+	switch e := err.(type) {
+	case nil:
+	case *sftpStatus:
+		code, msg = e.code, e.msg
+	default:
+		msg = err.Error()
+		switch {
+		case err == io.EOF:
+			code = sshFxEOF
+		case os.IsNotExist(err):
+			code = sshFxNoSuchFile
+		case os.IsPermission(err):
+			code = sshFxPermissionDenied
+		default:
+			code = sshFxFailure
+		}
+	}
+	b := appendUint32(nil, id)
+	b = appendUint32(b, code)
+	b = appendString(b, msg)
+	b = appendString(b, "")
+	return s.send(sshFxpStatus, b)
+}
+
+func (s *sftpServer) sendHandle(id uint32, h interface{}) error {
+	s.next++
+	name := strconv.FormatUint(s.next, 10)
+	s.handles[name] = h
+	return s.send(sshFxpHandle, appendString(appendUint32(nil, id), name))
+}
+
+// name is one entry of a NAME reply.
+type name struct {
+	name, long string
+	attrs      attrs
+}
+
+func (s *sftpServer) sendNames(id uint32, names []name) error {
+	b := appendUint32(appendUint32(nil, id), uint32(len(names)))
+	for _, n := range names {
+		b = appendString(b, n.name)
+		b = appendString(b, n.long)
+		b = appendAttrs(b, n.attrs)
+	}
+	return s.send(sshFxpName, b)
+}
+
+func (s *sftpServer) sendAttrs(id uint32, fi os.FileInfo, err error) error {
+	if err != nil {
+		return s.status(id, err)
+	}
+	return s.send(sshFxpAttrs, appendAttrs(appendUint32(nil, id), fileAttrs(fi)))
+}
+
+func (s *sftpServer) file(h string) (*fileHandle, error) {
+	if f, ok := s.handles[h].(*fileHandle); ok {
+		return f, nil
+	}
+	return nil, &sftpStatus{sshFxFailure, "invalid handle"}
+}
+
+func (s *sftpServer) dir(h string) (*dirHandle, error) {
+	if d, ok := s.handles[h].(*dirHandle); ok {
+		return d, nil
+	}
+	return nil, &sftpStatus{sshFxFailure, "invalid handle"}
+}
+
+// setAttrs applies the attributes that a sets to the file at path, or f if
+// it is not nil.
+func setAttrs(path string, f *os.File, a attrs) error {
+	if a.flags&sshFileXferAttrSize != 0 {
+		var err error
+		if f != nil {
+			err = f.Truncate(int64(a.size))
+		} else {
+			err = os.Truncate(path, int64(a.size))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if a.flags&sshFileXferAttrPermissions != 0 {
+		var err error
+		mode := os.FileMode(a.permissions & 0777)
+		if f != nil {
+			err = f.Chmod(mode)
+		} else {
+			err = os.Chmod(path, mode)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if a.flags&sshFileXferAttrUIDGID != 0 {
+		var err error
+		if f != nil {
+			err = f.Chown(int(a.uid), int(a.gid))
+		} else {
+			err = os.Chown(path, int(a.uid), int(a.gid))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if a.flags&sshFileXferAttrACModTime != 0 {
+		if f != nil {
+			path = f.Name()
+		}
+		if err := os.Chtimes(path, time.Unix(int64(a.atime), 0), time.Unix(int64(a.mtime), 0)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handle serves one request of type typ.
+func (s *sftpServer) handle(typ byte, p *packet) error {
+	if typ == sshFxpInit {
+		// The version of the client does not matter: older ones
+		// are long gone.
+		b := appendUint32(nil, sftpVersion)
+		for _, e := range sftpExtensions {
+			b = appendString(b, e)
+		}
+		return s.send(sshFxpVersion, b)
+	}
+
+	id := p.uint32()
+	if p.err != nil {
+		return p.err
+	}
+	var err error
+	switch typ {
+	case sshFxpOpen:
+		path, pflags, a := p.string(), p.uint32(), p.attrs()
+		if p.err != nil {
+			break
+		}
+		var flags int
+		switch {
+		case pflags&sshFxfRead != 0 && pflags&sshFxfWrite != 0:
+			flags = os.O_RDWR
+		case pflags&sshFxfWrite != 0:
+			flags = os.O_WRONLY
+		}
+		if pflags&sshFxfAppend != 0 {
+			flags |= os.O_APPEND
+		}
+		if pflags&sshFxfCreat != 0 {
+			flags |= os.O_CREATE
+		}
+		if pflags&sshFxfTrunc != 0 {
+			flags |= os.O_TRUNC
+		}
+		if pflags&sshFxfExcl != 0 {
+			flags |= os.O_EXCL
+		}
+		perm := os.FileMode(0644)
+		if a.flags&sshFileXferAttrPermissions != 0 {
+			perm = os.FileMode(a.permissions & 0777)
+		}
+		var f *os.File
+		if f, err = os.OpenFile(path, flags, perm); err == nil {
+			return s.sendHandle(id, &fileHandle{f: f, append: pflags&sshFxfAppend != 0})
+		}
+
+	case sshFxpClose:
+		h := p.string()
+		if p.err != nil {
+			break
+		}
+		switch f := s.handles[h].(type) {
+		case *fileHandle:
+			err = f.f.Close()
+		case *dirHandle:
+			err = f.f.Close()
+		default:
+			err = &sftpStatus{sshFxFailure, "invalid handle"}
+		}
+		delete(s.handles, h)
+
+	case sshFxpRead:
+		h, off, n := p.string(), p.uint64(), p.uint32()
+		if p.err != nil {
+			break
+		}
+		var f *fileHandle
+		if f, err = s.file(h); err != nil {
+			break
+		}
+		if n > maxRead {
+			n = maxRead
+		}
+		b := make([]byte, n)
+		var m int
+		m, err = f.f.ReadAt(b, int64(off))
+		if m > 0 {
+			return s.send(sshFxpData, appendString(appendUint32(nil, id), string(b[:m])))
+		}
+
+	case sshFxpWrite:
+		h, off, data := p.string(), p.uint64(), p.string()
+		if p.err != nil {
+			break
+		}
+		var f *fileHandle
+		if f, err = s.file(h); err != nil {
+			break
+		}
+		if f.append {
+			_, err = io.WriteString(f.f, data)
+		} else {
+			_, err = f.f.WriteAt([]byte(data), int64(off))
+		}
+
+	case sshFxpStat:
+		path := p.string()
+		if p.err == nil {
+			fi, err := os.Stat(path)
+			return s.sendAttrs(id, fi, err)
+		}
+
+	case sshFxpLstat:
+		path := p.string()
+		if p.err == nil {
+			fi, err := os.Lstat(path)
+			return s.sendAttrs(id, fi, err)
+		}
+
+	case sshFxpFstat:
+		h := p.string()
+		if p.err != nil {
+			break
+		}
+		var f *fileHandle
+		if f, err = s.file(h); err == nil {
+			fi, err := f.f.Stat()
+			return s.sendAttrs(id, fi, err)
+		}
+
+	case sshFxpSetstat:
+		path, a := p.string(), p.attrs()
+		if p.err == nil {
+			err = setAttrs(path, nil, a)
+		}
+
+	case sshFxpFsetstat:
+		h, a := p.string(), p.attrs()
+		if p.err != nil {
+			break
+		}
+		var f *fileHandle
+		if f, err = s.file(h); err == nil {
+			err = setAttrs("", f.f, a)
+		}
+
+	case sshFxpOpendir:
+		path := p.string()
+		if p.err != nil {
+			break
+		}
+		var fi os.FileInfo
+		if fi, err = os.Stat(path); err != nil {
+			break
+		}
+		if !fi.IsDir() {
+			err = &sftpStatus{sshFxFailure, path + ": not a directory"}
+			break
+		}
+		var f *os.File
+		if f, err = os.Open(path); err == nil {
+			return s.sendHandle(id, &dirHandle{f: f, path: path})
+		}
+
+	case sshFxpReaddir:
+		h := p.string()
+		if p.err != nil {
+			break
+		}
+		var d *dirHandle
+		if d, err = s.dir(h); err != nil {
+			break
+		}
+		var names []string
+		if names, err = d.f.Readdirnames(readdirCount); err != nil {
+			break
+		}
+		var entries []name
+		for _, n := range names {
+			fi, err := os.Lstat(filepath.Join(d.path, n))
+			if err != nil {
+				// Gone in the meantime.
+				continue
+			}
+			a := fileAttrs(fi)
+			entries = append(entries, name{name: n, long: longName(fi, a), attrs: a})
+		}
+		return s.sendNames(id, entries)
+
+	case sshFxpRemove:
+		path := p.string()
+		if p.err == nil {
+			err = unix.Unlink(path)
+			if err != nil {
+				err = &os.PathError{Op: "remove", Path: path, Err: err}
+			}
+		}
+
+	case sshFxpMkdir:
+		path, a := p.string(), p.attrs()
+		if p.err != nil {
+			break
+		}
+		perm := os.FileMode(0755)
+		if a.flags&sshFileXferAttrPermissions != 0 {
+			perm = os.FileMode(a.permissions & 0777)
+		}
+		err = os.Mkdir(path, perm)
+
+	case sshFxpRmdir:
+		path := p.string()
+		if p.err == nil {
+			err = unix.Rmdir(path)
+			if err != nil {
+				err = &os.PathError{Op: "rmdir", Path: path, Err: err}
+			}
+		}
+
+	case sshFxpRealpath:
+		path := p.string()
+		if p.err != nil {
+			break
+		}
+		if path == "" {
+			path = "."
+		}
+		if path, err = filepath.Abs(path); err == nil {
+			return s.sendNames(id, []name{{name: path, long: path}})
+		}
+
+	case sshFxpRename:
+		from, to := p.string(), p.string()
+		if p.err != nil {
+			break
+		}
+		// Unlike rename(2), SFTP renames do not replace files.
+		if _, err = os.Lstat(to); err == nil {
+			err = &sftpStatus{sshFxFailure, to + ": file exists"}
+		} else if os.IsNotExist(err) {
+			err = os.Rename(from, to)
+		}
+
+	case sshFxpReadlink:
+		path := p.string()
+		if p.err != nil {
+			break
+		}
+		var target string
+		if target, err = os.Readlink(path); err == nil {
+			return s.sendNames(id, []name{{name: target, long: target}})
+		}
+
+	case sshFxpSymlink:
+		// OpenSSH sends the target first, unlike the draft; its
+		// clients depend on that.
+		target, link := p.string(), p.string()
+		if p.err == nil {
+			err = os.Symlink(target, link)
+		}
+
+	case sshFxpExtended:
+		ext := p.string()
+		switch ext {
+		case "posix-rename@openssh.com":
+			from, to := p.string(), p.string()
+			if p.err == nil {
+				err = os.Rename(from, to)
+			}
+		case "hardlink@openssh.com":
+			from, to := p.string(), p.string()
+			if p.err == nil {
+				err = os.Link(from, to)
+			}
+		case "fsync@openssh.com":
+			h := p.string()
+			if p.err != nil {
+				break
+			}
+			var f *fileHandle
+			if f, err = s.file(h); err == nil {
+				err = f.f.Sync()
+			}
+		default:
+			err = &sftpStatus{sshFxOpUnsupported, "unsupported extension " + ext}
+		}
+
+	default:
+		err = &sftpStatus{sshFxOpUnsupported, fmt.Sprintf("unsupported request %d", typ)}
+	}
+	if p.err != nil {
+		err = &sftpStatus{sshFxBadMessage, p.err.Error()}
+	}
+	return s.status(id, err)
+}
@@ -0,0 +1,296 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// sftpClient makes SFTP requests, one at a time.
+type sftpClient struct {
+	t  *testing.T
+	rw io.ReadWriter
+	id uint32
+}
+
+// call sends a request of type typ with the given arguments, which are
+// uint32, uint64, string or attrs, and returns the reply.
+func (c *sftpClient) call(typ byte, args ...interface{}) (byte, *packet) {
+	c.t.Helper()
+	var b []byte
+	if typ != sshFxpInit {
+		c.id++
+		b = appendUint32(b, c.id)
+	}
+	for _, a := range args {
+		switch a := a.(type) {
+		case uint32:
+			b = appendUint32(b, a)
+		case uint64:
+			b = appendUint64(b, a)
+		case string:
+			b = appendString(b, a)
+		case attrs:
+			b = appendAttrs(b, a)
+		default:
+			c.t.Fatalf("Bad argument %v", a)
+		}
+	}
+	msg := append(appendUint32(nil, uint32(len(b)+1)), typ)
+	if _, err := c.rw.Write(append(msg, b...)); err != nil {
+		c.t.Fatal(err)
+	}
+
+	var hdr [5]byte
+	if _, err := io.ReadFull(c.rw, hdr[:]); err != nil {
+		c.t.Fatal(err)
+	}
+	reply := make([]byte, binary.BigEndian.Uint32(hdr[:])-1)
+	if _, err := io.ReadFull(c.rw, reply); err != nil {
+		c.t.Fatal(err)
+	}
+	p := &packet{b: reply}
+	if typ != sshFxpInit {
+		if id := p.uint32(); id != c.id {
+			c.t.Fatalf("Reply for request %d, want %d", id, c.id)
+		}
+	}
+	return hdr[4], p
+}
+
+// status makes a request that is answered with a status, and returns it.
+func (c *sftpClient) status(typ byte, args ...interface{}) uint32 {
+	c.t.Helper()
+	rtyp, p := c.call(typ, args...)
+	if rtyp != sshFxpStatus {
+		c.t.Fatalf("Request %d: got reply %d, want a status", typ, rtyp)
+	}
+	return p.uint32()
+}
+
+// handle makes a request that is answered with a handle, and returns it.
+func (c *sftpClient) handle(typ byte, args ...interface{}) string {
+	c.t.Helper()
+	rtyp, p := c.call(typ, args...)
+	if rtyp != sshFxpHandle {
+		c.t.Fatalf("Request %d: got reply %d, want a handle", typ, rtyp)
+	}
+	return p.string()
+}
+
+// names makes a request that is answered with names, and returns them.
+func (c *sftpClient) names(typ byte, args ...interface{}) []string {
+	c.t.Helper()
+	rtyp, p := c.call(typ, args...)
+	if rtyp == sshFxpStatus {
+		if code := p.uint32(); code == sshFxEOF {
+			return nil
+		}
+	}
+	if rtyp != sshFxpName {
+		c.t.Fatalf("Request %d: got reply %d, want names", typ, rtyp)
+	}
+	var names []string
+	for n := p.uint32(); n > 0; n-- {
+		names = append(names, p.string())
+		p.string()
+		p.attrs()
+	}
+	if p.err != nil {
+		c.t.Fatal(p.err)
+	}
+	return names
+}
+
+// stat returns the attributes of path.
+func (c *sftpClient) stat(path string) (attrs, uint32) {
+	c.t.Helper()
+	rtyp, p := c.call(sshFxpLstat, path)
+	if rtyp == sshFxpStatus {
+		return attrs{}, p.uint32()
+	}
+	if rtyp != sshFxpAttrs {
+		c.t.Fatalf("Stat: got reply %d, want attrs", rtyp)
+	}
+	return p.attrs(), sshFxOk
+}
+
+func testSFTP(t *testing.T, c *sftpClient) {
+	dir, err := ioutil.TempDir("", "sftp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	typ, p := c.call(sshFxpInit, uint32(3))
+	if typ != sshFxpVersion || p.uint32() != sftpVersion {
+		t.Fatalf("Init: got reply %d, want version 3", typ)
+	}
+
+	// Write a file in two pieces.
+	file := filepath.Join(dir, "file")
+	h := c.handle(sshFxpOpen, file, uint32(sshFxfWrite|sshFxfCreat|sshFxfExcl), attrs{flags: sshFileXferAttrPermissions, permissions: 0600})
+	if code := c.status(sshFxpWrite, h, uint64(6), "world"); code != sshFxOk {
+		t.Errorf("Write: got status %d", code)
+	}
+	if code := c.status(sshFxpWrite, h, uint64(0), "hello "); code != sshFxOk {
+		t.Errorf("Write: got status %d", code)
+	}
+	if code := c.status(sshFxpClose, h); code != sshFxOk {
+		t.Errorf("Close: got status %d", code)
+	}
+	if code := c.status(sshFxpClose, h); code != sshFxFailure {
+		t.Errorf("Close of a closed handle: got status %d, want failure", code)
+	}
+	if b, err := ioutil.ReadFile(file); err != nil || string(b) != "hello world" {
+		t.Errorf("File has %q, %v, want %q", b, err, "hello world")
+	}
+	a, code := c.stat(file)
+	if code != sshFxOk || a.size != 11 || a.permissions != 0100600 {
+		t.Errorf("Stat: got %+v, %d, want a regular file of 11 bytes and mode 0600", a, code)
+	}
+	if _, code := c.stat(filepath.Join(dir, "nosuch")); code != sshFxNoSuchFile {
+		t.Errorf("Stat of a missing file: got %d, want %d", code, sshFxNoSuchFile)
+	}
+
+	// Read it back, to the end.
+	h = c.handle(sshFxpOpen, file, uint32(sshFxfRead), attrs{})
+	typ, p = c.call(sshFxpRead, h, uint64(6), uint32(100))
+	if data := p.string(); typ != sshFxpData || data != "world" {
+		t.Errorf("Read: got reply %d with %q, want data %q", typ, data, "world")
+	}
+	if code := c.status(sshFxpRead, h, uint64(11), uint32(100)); code != sshFxEOF {
+		t.Errorf("Read at the end: got status %d, want EOF", code)
+	}
+	c.status(sshFxpClose, h)
+
+	// Truncate it.
+	if code := c.status(sshFxpSetstat, file, attrs{flags: sshFileXferAttrSize, size: 5}); code != sshFxOk {
+		t.Errorf("Setstat: got status %d", code)
+	}
+	if a, _ := c.stat(file); a.size != 5 {
+		t.Errorf("Size after setstat: got %d, want 5", a.size)
+	}
+
+	// Directories.
+	sub := filepath.Join(dir, "sub")
+	if code := c.status(sshFxpMkdir, sub, attrs{}); code != sshFxOk {
+		t.Errorf("Mkdir: got status %d", code)
+	}
+	if code := c.status(sshFxpRename, file, filepath.Join(sub, "moved")); code != sshFxOk {
+		t.Errorf("Rename: got status %d", code)
+	}
+	if code := c.status(sshFxpSymlink, "moved", filepath.Join(sub, "link")); code != sshFxOk {
+		t.Errorf("Symlink: got status %d", code)
+	}
+	if got := c.names(sshFxpReadlink, filepath.Join(sub, "link")); !reflect.DeepEqual(got, []string{"moved"}) {
+		t.Errorf("Readlink: got %q, want moved", got)
+	}
+	if code := c.status(sshFxpRename, filepath.Join(sub, "link"), filepath.Join(sub, "moved")); code != sshFxFailure {
+		t.Errorf("Rename onto a file: got status %d, want failure", code)
+	}
+	h = c.handle(sshFxpOpendir, sub)
+	var names []string
+	for {
+		n := c.names(sshFxpReaddir, h)
+		if n == nil {
+			break
+		}
+		names = append(names, n...)
+	}
+	c.status(sshFxpClose, h)
+	sort.Strings(names)
+	if want := []string{"link", "moved"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Readdir: got %q, want %q", names, want)
+	}
+	if code := c.status(sshFxpRmdir, sub); code != sshFxFailure {
+		t.Errorf("Rmdir of a full directory: got status %d, want failure", code)
+	}
+	for _, n := range names {
+		if code := c.status(sshFxpRemove, filepath.Join(sub, n)); code != sshFxOk {
+			t.Errorf("Remove: got status %d", code)
+		}
+	}
+	if code := c.status(sshFxpRemove, sub); code == sshFxOk {
+		t.Errorf("Remove of a directory succeeded, want failure")
+	}
+	if code := c.status(sshFxpRmdir, sub); code != sshFxOk {
+		t.Errorf("Rmdir: got status %d", code)
+	}
+
+	if got := c.names(sshFxpRealpath, dir+"/./x/.."); !reflect.DeepEqual(got, []string{dir}) {
+		t.Errorf("Realpath: got %q, want %q", got, dir)
+	}
+	if code := c.status(sshFxpExtended, "nosuch@example.com"); code != sshFxOpUnsupported {
+		t.Errorf("Unknown extension: got status %d, want unsupported", code)
+	}
+}
+
+func TestSFTP(t *testing.T) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	errc := make(chan error)
+	go func() {
+		errc <- serveSFTP(struct {
+			io.Reader
+			io.Writer
+		}{sr, sw})
+	}()
+	testSFTP(t, &sftpClient{t: t, rw: struct {
+		io.Reader
+		io.Writer
+	}{cr, cw}})
+	cw.Close()
+	if err := <-errc; err != nil {
+		t.Errorf("serveSFTP() = %v", err)
+	}
+}
+
+func TestSFTPSubsystem(t *testing.T) {
+	c := startServer(t)
+	defer c.Close()
+
+	s, err := c.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.RequestSubsystem("nosuch"); err == nil {
+		t.Errorf("RequestSubsystem(nosuch) succeeded, want error")
+	}
+
+	s, err = c.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	w, err := s.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RequestSubsystem("sftp"); err != nil {
+		t.Fatal(err)
+	}
+	testSFTP(t, &sftpClient{t: t, rw: struct {
+		io.Reader
+		io.Writer
+	}{r, w}})
+	// The session ends with the input.
+	w.Close()
+	if b, err := ioutil.ReadAll(r); err != nil || len(b) != 0 {
+		t.Errorf("Reading the rest: got %q, %v, want nothing", b, err)
+	}
+}
@@ -2,41 +2,35 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
+// Sshd is an SSH server with public key authentication.
+//
+// Synopsis:
+//     sshd [OPTIONS...]
+//
+// Description:
+//     Sessions run a shell or a command, with or without a pty, or the
+//     sftp subsystem. Clients may forward ports both ways, as with ssh -L
+//     and ssh -R.
+//
+// Options:
+//     -d:          enable debug prints
+//     -keys:       path to the authorized_keys file
+//     -privatekey: path of the private host key
+//     -ip:         ip address to listen on
+//     -port:       port to listen on
 package main
 
 import (
 	"flag"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"log"
 	"net"
-	"os"
 	"os/exec"
-	"syscall"
 
-	"github.com/u-root/u-root/pkg/pty"
 	"golang.org/x/crypto/ssh"
 )
 
-// The ssh package does not define these things so we will
-type (
-	ptyReq struct {
-		TERM   string //TERM environment variable value (e.g., vt100)
-		Col    uint32
-		Row    uint32
-		Xpixel uint32
-		Ypixel uint32
-		Modes  string //encoded terminal modes
-	}
-	execReq struct {
-		Command string
-	}
-	exitStatusReq struct {
-		ExitStatus uint32
-	}
-)
-
 var (
 	shells  = [...]string{"bash", "zsh", "elvish"}
 	shell   = "/bin/sh"
@@ -48,79 +42,6 @@ var (
 	dprintf = func(string, ...interface{}) {}
 )
 
-// start a command
-// TODO: use /etc/passwd, but the Go support for that is incomplete
-func runCommand(c ssh.Channel, p *pty.Pty, cmd string, args ...string) error {
-	var ps *os.ProcessState
-	defer c.Close()
-
-	if p != nil {
-		log.Printf("Executing PTY command %s %v", cmd, args)
-		p.Command(cmd, args...)
-		if err := p.C.Start(); err != nil {
-			dprintf("Failed to execute: %v", err)
-			return err
-		}
-		defer p.C.Wait()
-		go io.Copy(p.Ptm, c)
-		go io.Copy(c, p.Ptm)
-		ps, _ = p.C.Process.Wait()
-	} else {
-		e := exec.Command(cmd, args...)
-		e.Stdin, e.Stdout, e.Stderr = c, c, c
-		log.Printf("Executing non-PTY command %s %v", cmd, args)
-		if err := e.Start(); err != nil {
-			dprintf("Failed to execute: %v", err)
-			return err
-		}
-		ps, _ = e.Process.Wait()
-	}
-
-	var ws syscall.WaitStatus
-	ws = ps.Sys().(syscall.WaitStatus)
-	if ws.Signaled() {
-		// TODO(bluecmd): If somebody wants we can send exit-signal to return
-		// information about signal termination, but leave it until somebody needs
-		// it.
-	}
-	if ws.Exited() {
-		code := uint32(ws.ExitStatus())
-		dprintf("Exit status %v", code)
-		c.SendRequest("exit-status", false, ssh.Marshal(exitStatusReq{code}))
-	}
-	return nil
-}
-
-func newPTY(b []byte) (*pty.Pty, error) {
-	ptyReq := &ptyReq{}
-	err := ssh.Unmarshal(b, ptyReq)
-	dprintf("newPTY: %q", ptyReq)
-	if err != nil {
-		return nil, err
-	}
-	p, err := pty.New()
-	if err != nil {
-		return nil, err
-	}
-	ws, err := p.TTY.GetWinSize()
-	if err != nil {
-		return nil, err
-	}
-	ws.Row = uint16(ptyReq.Row)
-	ws.Ypixel = uint16(ptyReq.Ypixel)
-	ws.Col = uint16(ptyReq.Col)
-	ws.Xpixel = uint16(ptyReq.Xpixel)
-	dprintf("newPTY: Set winsizes to %v", ws)
-	if err := p.TTY.SetWinSize(ws); err != nil {
-		return nil, err
-	}
-	dprintf("newPTY: set TERM to %q", ptyReq.TERM)
-	if err := os.Setenv("TERM", ptyReq.TERM); err != nil {
-		return nil, err
-	}
-	return p, nil
-}
-
 func init() {
 	for _, s := range shells {
 		if _, err := exec.LookPath(s); err == nil {
@@ -129,79 +50,22 @@ func init() {
 	}
 }
 
-func session(chans <-chan ssh.NewChannel) {
-	var p *pty.Pty
-	// Service the incoming Channel channel.
-	for newChannel := range chans {
-		// Channels have a type, depending on the application level
-		// protocol intended. In the case of a shell, the type is
-		// "session" and ServerShell may be used to present a simple
-		// terminal interface.
-		if newChannel.ChannelType() != "session" {
-			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
-			continue
-		}
-		channel, requests, err := newChannel.Accept()
-		if err != nil {
-			log.Printf("Could not accept channel: %v", err)
-			continue
-		}
-
-		// Sessions have out-of-band requests such as "shell",
-		// "pty-req" and "env".  Here we handle only the
-		// "shell" request.
-		go func(in <-chan *ssh.Request) {
-			for req := range in {
-				dprintf("Request %v", req.Type)
-				switch req.Type {
-				case "shell":
-					err := runCommand(channel, p, shell)
-					req.Reply(true, []byte(fmt.Sprintf("%v", err)))
-				case "exec":
-					e := &execReq{}
-					if err := ssh.Unmarshal(req.Payload, e); err != nil {
-						log.Printf("sshd: %v", err)
-						break
-					}
-					// Execute command using user's shell. This is what OpenSSH does
-					// so it's the least surprising to the user.
-					err := runCommand(channel, p, shell, "-c", e.Command)
-					req.Reply(true, []byte(fmt.Sprintf("%v", err)))
-				case "pty-req":
-					p, err = newPTY(req.Payload)
-					req.Reply(err == nil, nil)
-				default:
-					log.Printf("Not handling req %v %q", req, string(req.Payload))
-					req.Reply(false, nil)
-				}
-			}
-		}(requests)
-
-	}
-}
-
-func main() {
-	flag.Parse()
-	if *debug {
-		dprintf = log.Printf
-	}
+// newConfig returns a server configuration that accepts the keys in
+// authorizedKeys, an authorized_keys file, and presents hostKey, a PEM
+// private key.
+func newConfig(authorizedKeys, hostKey []byte) (*ssh.ServerConfig, error) {
 	// Public key authentication is done by comparing
 	// the public key of a received connection
 	// with the entries in the authorized_keys file.
-	authorizedKeysBytes, err := ioutil.ReadFile(*keys)
-	if err != nil {
-		log.Fatal(err)
-	}
-
 	authorizedKeysMap := map[string]bool{}
-	for len(authorizedKeysBytes) > 0 {
-		pubKey, _, _, rest, err := ssh.ParseAuthorizedKey(authorizedKeysBytes)
+	for len(authorizedKeys) > 0 {
+		pubKey, _, _, rest, err := ssh.ParseAuthorizedKey(authorizedKeys)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 
 		authorizedKeysMap[string(pubKey.Marshal())] = true
-		authorizedKeysBytes = rest
+		authorizedKeys = rest
 	}
 
 	// An SSH server is represented by a ServerConfig, which holds
@@ -221,43 +85,91 @@ func main() {
 		},
 	}
 
-	privateBytes, err := ioutil.ReadFile(*privkey)
+	private, err := ssh.ParsePrivateKey(hostKey)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+	config.AddHostKey(private)
+	return config, nil
+}
 
-	private, err := ssh.ParsePrivateKey(privateBytes)
+// handleConn serves an SSH connection.
+func handleConn(nConn net.Conn, config *ssh.ServerConfig) {
+	// Before use, a handshake must be performed on the incoming
+	// net.Conn.
+	conn, chans, reqs, err := ssh.NewServerConn(nConn, config)
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("failed to handshake: %v", err)
+		return
 	}
+	log.Printf("%v logged in with key %s", conn.RemoteAddr(), conn.Permissions.Extensions["pubkey-fp"])
 
-	config.AddHostKey(private)
+	// The incoming Request channel must be serviced.
+	f := newForwards(conn)
+	defer f.close()
+	go f.serve(reqs)
 
-	// Once a ServerConfig has been configured, connections can be
-	// accepted.
-	listener, err := net.Listen("tcp", *ip+":"+*port)
-	if err != nil {
-		log.Fatal(err)
+	// Service the incoming Channel channel.
+	for newChannel := range chans {
+		// Channels have a type, depending on the application level
+		// protocol intended. In the case of a shell, the type is
+		// "session" and ServerShell may be used to present a simple
+		// terminal interface.
+		switch newChannel.ChannelType() {
+		case "session":
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				log.Printf("Could not accept channel: %v", err)
+				continue
+			}
+			go serveSession(channel, requests)
+		case "direct-tcpip":
+			go directTCPIP(newChannel)
+		default:
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+		}
 	}
+	log.Printf("%v logged out", conn.RemoteAddr())
+}
+
+// serve accepts connections on l.
+func serve(l net.Listener, config *ssh.ServerConfig) error {
 	for {
-		nConn, err := listener.Accept()
-		if err != nil {
+		nConn, err := l.Accept()
+		if ne, ok := err.(net.Error); ok && ne.Temporary() {
 			log.Printf("failed to accept incoming connection: %s", err)
 			continue
 		}
-
-		// Before use, a handshake must be performed on the incoming
-		// net.Conn.
-		conn, chans, reqs, err := ssh.NewServerConn(nConn, config)
 		if err != nil {
-			log.Printf("failed to handshake: %v", err)
-			continue
+			return err
 		}
-		log.Printf("%v logged in with key %s", conn.RemoteAddr(), conn.Permissions.Extensions["pubkey-fp"])
+		go handleConn(nConn, config)
+	}
+}
 
-		// The incoming Request channel must be serviced.
-		go ssh.DiscardRequests(reqs)
+func main() {
+	flag.Parse()
+	if *debug {
+		dprintf = log.Printf
+	}
+	authorizedKeysBytes, err := ioutil.ReadFile(*keys)
+	if err != nil {
+		log.Fatal(err)
+	}
+	privateBytes, err := ioutil.ReadFile(*privkey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	config, err := newConfig(authorizedKeysBytes, privateBytes)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-		go session(chans)
+	// Once a ServerConfig has been configured, connections can be
+	// accepted.
+	listener, err := net.Listen("tcp", net.JoinHostPort(*ip, *port))
+	if err != nil {
+		log.Fatal(err)
 	}
+	log.Fatal(serve(listener, config))
 }
@@ -0,0 +1,213 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// startServer runs sshd on a loopback port and returns a client logged in
+// to it.
+func startServer(t *testing.T) *ssh.Client {
+	hostKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := newConfig(ssh.MarshalAuthorizedKey(signer.PublicKey()), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serve(l, config)
+
+	// Other keys are refused.
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ssh.NewSignerFromKey(otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ssh.Dial("tcp", l.Addr().String(), &ssh.ClientConfig{
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(other)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}); err == nil {
+		t.Errorf("Login with an unknown key succeeded")
+	}
+
+	c, err := ssh.Dial("tcp", l.Addr().String(), &ssh.ClientConfig{
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		l.Close()
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestExec(t *testing.T) {
+	c := startServer(t)
+	defer c.Close()
+
+	s, err := c.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var stdout, stderr bytes.Buffer
+	s.Stdin = strings.NewReader("input")
+	s.Stdout, s.Stderr = &stdout, &stderr
+	if err := s.Setenv("FOO", "bar"); err != nil {
+		t.Fatal(err)
+	}
+	err = s.Run("echo $FOO; cat; echo oops >&2; exit 3")
+	if e, ok := err.(*ssh.ExitError); !ok || e.ExitStatus() != 3 {
+		t.Errorf("Run() = %v, want exit status 3", err)
+	}
+	if got, want := stdout.String(), "bar\ninput"; got != want {
+		t.Errorf("stdout = %q, want %q", got, want)
+	}
+	if got, want := stderr.String(), "oops\n"; got != want {
+		t.Errorf("stderr = %q, want %q", got, want)
+	}
+}
+
+func TestConcurrentSessions(t *testing.T) {
+	c := startServer(t)
+	defer c.Close()
+
+	// Each session waits for all of them to have started.
+	dir, err := ioutil.TempDir("", "sshd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	const n = 3
+	cmd := fmt.Sprintf("touch %s/$N; while [ $(ls %s | wc -l) -lt %d ]; do sleep 0.05; done; echo $N", dir, dir, n)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s, err := c.NewSession()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer s.Close()
+			s.Setenv("N", fmt.Sprint(i))
+			out, err := s.Output(cmd)
+			if want := fmt.Sprintf("%d\n", i); err != nil || string(out) != want {
+				t.Errorf("Session %d: got %q, %v, want %q", i, out, err, want)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestPTY(t *testing.T) {
+	c := startServer(t)
+	defer c.Close()
+
+	s, err := c.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.RequestPty("xterm", 24, 80, ssh.TerminalModes{}); err != nil {
+		t.Skipf("No pty: %v", err)
+	}
+	out, err := s.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The window change is only made once the first size is out.
+	if err := s.Start(`echo $TERM; tty -s && echo tty; stty size; while [ "$(stty size)" = "24 80" ]; do sleep 0.01; done; stty size`); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	for !strings.Contains(b.String(), "24 80") {
+		buf := make([]byte, 100)
+		n, err := out.Read(buf)
+		if err != nil {
+			t.Fatalf("Reading %q: %v", b.String(), err)
+		}
+		b.Write(buf[:n])
+	}
+	if err := s.WindowChange(50, 132); err != nil {
+		t.Fatal(err)
+	}
+	rest, _ := ioutil.ReadAll(out)
+	b.Write(rest)
+	if err := s.Wait(); err != nil {
+		t.Error(err)
+	}
+	if got, want := b.String(), "xterm\r\ntty\r\n24 80\r\n50 132\r\n"; got != want {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+}
+
+func TestSignal(t *testing.T) {
+	c := startServer(t)
+	defer c.Close()
+
+	s, err := c.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	out, err := s.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start("echo started; exec sleep 10"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := out.Read(make([]byte, 8)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Signal(ssh.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	err = s.Wait()
+	if e, ok := err.(*ssh.ExitError); !ok || e.Signal() != "TERM" {
+		t.Errorf("Wait() = %v, want TERM signal", err)
+	}
+}
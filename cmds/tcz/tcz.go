@@ -5,16 +5,17 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"log"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"syscall"
+
+	"github.com/u-root/u-root/pkg/download"
 )
 
 const (
@@ -58,6 +59,7 @@ var (
 	tczServerDir       string
 	tczLocalPackageDir string
 	ignorePackage      = make(map[string]struct{})
+	downloader         = &download.Downloader{Retries: 3}
 )
 
 // consider making this a goroutine which pushes the string down the channel.
@@ -138,34 +140,20 @@ func fetch(p string) error {
 		cmd := fmt.Sprintf("http://%s:%s/%s", *host, *port, packageName)
 		debug("Fetch %v\n", cmd)
 
-		resp, err := http.Get(cmd)
-		if err != nil {
+		// Download to another name first, so that an interrupted
+		// download is continued next time rather than taken for
+		// the package.
+		part := fullpath + ".part"
+		if err := downloader.DownloadFile(context.Background(), cmd, part, true); err != nil {
+			if serr, ok := err.(*download.StatusError); ok {
+				debug("%v Not OK! %v\n", cmd, serr.Status)
+				return syscall.ENOENT
+			}
 			l.Fatalf("Get of %v failed: %v\n", cmd, err)
 		}
-		defer resp.Body.Close()
-
-		if resp.Status != "200 OK" {
-			debug("%v Not OK! %v\n", cmd, resp.Status)
-			return syscall.ENOENT
-		}
-
-		debug("resp %v err %v\n", resp, err)
-		// we have the whole tcz in resp.Body.
-		// First, save it to /tczRoot/name
-		f, err := os.Create(fullpath)
-		if err != nil {
-			l.Fatalf("Create of :%v: failed: %v\n", fullpath, err)
-		} else {
-			debug("created %v f %v\n", fullpath, f)
-		}
-
-		if c, err := io.Copy(f, resp.Body); err != nil {
+		if err := os.Rename(part, fullpath); err != nil {
 			l.Fatal(err)
-		} else {
-			/* OK, these are compressed tars ... */
-			debug("c %v err %v\n", c, err)
 		}
-		f.Close()
 	}
 	return nil
 }
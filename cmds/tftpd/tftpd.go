@@ -0,0 +1,155 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// tftpd serves a directory read-only over TFTP.
+//
+// Synopsis:
+//     tftpd [OPTIONS...] [DIR]
+//
+// Description:
+//     tftpd answers RFC 1350 read requests for files below DIR (default
+//     /tftpboot). The blksize, timeout and tsize options of RFC 2347-2349
+//     and the windowsize option of RFC 7440 are negotiated when the client
+//     asks for them. Write requests are refused.
+//
+//     Names are resolved below DIR: leading slashes are ignored, DOS-style
+//     backslashes are treated as slashes, and neither ".." nor symlinks can
+//     reach files outside of DIR.
+//
+// Options:
+//     -addr:       address to listen on (default :69)
+//     -retransmit: how often to resend a packet before giving up
+//     -singleport: serve all transfers from the listening port
+//     -v:          log every request
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"pack.ag/tftp"
+)
+
+var (
+	addr       = flag.String("addr", ":69", "Address to listen on")
+	retransmit = flag.Int("retransmit", 10, "How often to resend a packet before giving up")
+	singlePort = flag.Bool("singleport", false, "Serve all transfers from the listening port")
+	verbose    = flag.Bool("v", false, "Log every request")
+)
+
+// errOutsideRoot is returned for names that resolve outside of the root.
+var errOutsideRoot = fmt.Errorf("outside of the served directory")
+
+// fileServer is a read-only tftp.ReadHandler serving the files below root.
+type fileServer struct {
+	root    string
+	verbose bool
+}
+
+// resolve returns the path of the file called name below root.
+func (f *fileServer) resolve(name string) (string, error) {
+	name = path.Clean("/" + strings.Replace(name, "\\", "/", -1))
+	p, err := filepath.EvalSymlinks(filepath.Join(f.root, filepath.FromSlash(name)))
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(f.root, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errOutsideRoot
+	}
+	return p, nil
+}
+
+// ServeTFTP implements tftp.ReadHandler.
+func (f *fileServer) ServeTFTP(r tftp.ReadRequest) {
+	if err := f.serve(r); err != nil {
+		log.Printf("%v: read %q: %v", r.Addr(), r.Name(), err)
+		return
+	}
+	if f.verbose {
+		log.Printf("%v: read %q", r.Addr(), r.Name())
+	}
+}
+
+func (f *fileServer) serve(r tftp.ReadRequest) error {
+	p, err := f.resolve(r.Name())
+	if err == errOutsideRoot {
+		r.WriteError(tftp.ErrCodeAccessViolation, "access violation")
+		return err
+	}
+	if err != nil {
+		r.WriteError(tftp.ErrCodeFileNotFound, "file not found")
+		return err
+	}
+
+	file, err := os.Open(p)
+	if err != nil {
+		if os.IsPermission(err) {
+			r.WriteError(tftp.ErrCodeAccessViolation, "access violation")
+		} else {
+			r.WriteError(tftp.ErrCodeFileNotFound, "file not found")
+		}
+		return err
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		r.WriteError(tftp.ErrCodeNotDefined, err.Error())
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		r.WriteError(tftp.ErrCodeFileNotFound, "not a regular file")
+		return fmt.Errorf("not a regular file")
+	}
+
+	r.WriteSize(fi.Size())
+	_, err = io.Copy(r, file)
+	return err
+}
+
+// newServer returns a TFTP server listening on addr for the files below root.
+func newServer(addr, root string, verbose bool, opts ...tftp.ServerOpt) (*tftp.Server, error) {
+	// Symlinks in the root itself are fine; resolve them once so that
+	// the containment check in resolve compares like with like.
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	s, err := tftp.NewServer(addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	s.ReadHandler(&fileServer{root: root, verbose: verbose})
+	return s, nil
+}
+
+func main() {
+	flag.Parse()
+	root := "/tftpboot"
+	switch flag.NArg() {
+	case 0:
+	case 1:
+		root = flag.Arg(0)
+	default:
+		log.Fatal("usage: tftpd [OPTIONS...] [DIR]")
+	}
+
+	s, err := newServer(*addr, root, *verbose, tftp.ServerRetransmit(*retransmit), tftp.ServerSinglePort(*singlePort))
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Serving %s on %s", root, *addr)
+	log.Fatal(s.ListenAndServe())
+}
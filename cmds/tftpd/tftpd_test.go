@@ -0,0 +1,141 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/pxe"
+	"github.com/u-root/u-root/pkg/uio"
+	"pack.ag/tftp"
+)
+
+func startServer(t *testing.T, root string) (string, func()) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := newServer("", root, false, tftp.ServerRetransmit(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(conn)
+	return conn.LocalAddr().String(), func() { s.Close() }
+}
+
+func TestServe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tftpd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "root")
+	if err := os.MkdirAll(filepath.Join(root, "pxelinux.cfg"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// Larger than a few blocks and not a multiple of any block size.
+	kernel := bytes.Repeat([]byte("0123456789abcdef"), 1000)
+	kernel = append(kernel, 'x')
+	for name, content := range map[string][]byte{
+		"root/vmlinuz":              kernel,
+		"root/pxelinux.cfg/default": []byte("default linux\n"),
+		"secret":                    []byte("outside"),
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("../secret", filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("vmlinuz", filepath.Join(root, "linux")); err != nil {
+		t.Fatal(err)
+	}
+
+	addr, stop := startServer(t, root)
+	defer stop()
+
+	for _, tt := range []struct {
+		name string
+		opts []tftp.ClientOpt
+		want []byte
+	}{
+		{name: "vmlinuz", want: kernel},
+		{name: "/linux", opts: []tftp.ClientOpt{tftp.ClientBlocksize(1468)}, want: kernel},
+		{name: "vmlinuz", opts: []tftp.ClientOpt{tftp.ClientWindowsize(4), tftp.ClientTimeout(1)}, want: kernel},
+		{name: "pxelinux.cfg/default", want: []byte("default linux\n")},
+		{name: "../secret"},
+		{name: "escape"},
+		{name: "pxelinux.cfg"},
+		{name: "missing"},
+	} {
+		t.Run(fmt.Sprintf("%s%d", tt.name, len(tt.opts)), func(t *testing.T) {
+			u := &url.URL{Scheme: "tftp", Host: addr, Path: "/" + tt.name}
+			r, err := pxe.NewTFTPClient(append(tt.opts, tftp.ClientRetransmit(2))...).GetFile(u)
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("GetFile(%s) succeeded, want error", u)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := uio.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("GetFile(%s) = %d bytes, want %d", u, len(got), len(tt.want))
+			}
+		})
+	}
+
+	c, err := tftp.NewClient(tftp.ClientTransferSize(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Get(fmt.Sprintf("tftp://%s/vmlinuz", addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size, err := resp.Size(); err != nil || size != int64(len(kernel)) {
+		t.Errorf("tsize = %d, %v, want %d", size, err, len(kernel))
+	}
+	ioutil.ReadAll(resp)
+}
+
+func TestResolve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tftpd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "boot", "x86"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "boot", "x86", "wdsnbp.com"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f := &fileServer{root: dir}
+	want := filepath.Join(dir, "boot", "x86", "wdsnbp.com")
+	for _, name := range []string{
+		"boot/x86/wdsnbp.com",
+		"/boot/x86/wdsnbp.com",
+		"\\boot\\x86\\wdsnbp.com",
+		"../../boot/x86/../x86/wdsnbp.com",
+	} {
+		if got, err := f.resolve(name); err != nil || got != want {
+			t.Errorf("resolve(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+}
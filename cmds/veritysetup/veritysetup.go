@@ -0,0 +1,248 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Set up and check dm-verity devices.
+//
+// Synopsis:
+//     veritysetup [options] format DATA HASH
+//     veritysetup [options] verify DATA HASH ROOTHASH
+//     veritysetup [options] open DATA NAME HASH ROOTHASH
+//     veritysetup close NAME
+//     veritysetup [-hash-offset N] dump HASH
+//
+// Description:
+//     format computes the hash tree for DATA, writes it with a superblock to
+//     HASH and prints the root hash. verify checks all of DATA against the
+//     tree and ROOTHASH without needing device-mapper. open creates the
+//     read-only device /dev/mapper/NAME, which can then be mounted and used
+//     with switch_root.
+//
+// Options:
+//     -hash-offset:     offset of the superblock (or tree) on HASH, in bytes
+//     -no-superblock:   there is no superblock; the options below describe the tree
+//     -hash:            hash algorithm (default sha256)
+//     -data-block-size: data block size (default 4096)
+//     -hash-block-size: hash block size (default 4096)
+//     -data-blocks:     number of data blocks (default: all of DATA)
+//     -salt:            salt in hex, or "-" for none
+//     -format:          hash type, 1 normal or 0 Chrome OS
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/u-root/u-root/pkg/verity"
+)
+
+var (
+	hashOffset    = flag.Int64("hash-offset", 0, "Offset of the superblock (or tree) on the hash device, in bytes")
+	noSuperblock  = flag.Bool("no-superblock", false, "There is no superblock")
+	hashAlg       = flag.String("hash", "sha256", "Hash algorithm")
+	dataBlockSize = flag.Uint("data-block-size", 4096, "Data block size")
+	hashBlockSize = flag.Uint("hash-block-size", 4096, "Hash block size")
+	dataBlocks    = flag.Uint64("data-blocks", 0, "Number of data blocks (default: all of DATA)")
+	salt          = flag.String("salt", "", "Salt in hex, or - for none (default: random for format)")
+	hashType      = flag.Uint("format", 1, "Hash type: 1 normal, 0 Chrome OS")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage:\n")
+	fmt.Fprintf(os.Stderr, "  veritysetup [options] format DATA HASH\n")
+	fmt.Fprintf(os.Stderr, "  veritysetup [options] verify DATA HASH ROOTHASH\n")
+	fmt.Fprintf(os.Stderr, "  veritysetup [options] open DATA NAME HASH ROOTHASH\n")
+	fmt.Fprintf(os.Stderr, "  veritysetup close NAME\n")
+	fmt.Fprintf(os.Stderr, "  veritysetup [options] dump HASH\n")
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
+// paramsFromFlags describes a tree from the command line, for format and
+// for trees without a superblock.
+func paramsFromFlags(data *os.File, generateSalt bool) (*verity.Params, error) {
+	p := &verity.Params{
+		HashType:      uint32(*hashType),
+		Algorithm:     *hashAlg,
+		DataBlockSize: uint32(*dataBlockSize),
+		HashBlockSize: uint32(*hashBlockSize),
+		DataBlocks:    *dataBlocks,
+		HashOffset:    *hashOffset,
+		NoSuperblock:  *noSuperblock,
+	}
+	switch *salt {
+	case "-":
+	case "":
+		if generateSalt {
+			p.Salt = make([]byte, 32)
+			if _, err := rand.Read(p.Salt); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		s, err := hex.DecodeString(*salt)
+		if err != nil {
+			return nil, fmt.Errorf("bad salt: %v", err)
+		}
+		p.Salt = s
+	}
+	if p.DataBlocks == 0 {
+		size, err := data.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		p.DataBlocks = uint64(size) / uint64(p.DataBlockSize)
+	}
+	return p, nil
+}
+
+// params reads the superblock from hash, unless there is none.
+func params(data, hash *os.File) (*verity.Params, error) {
+	if *noSuperblock {
+		return paramsFromFlags(data, false)
+	}
+	return verity.ReadSuperblock(hash, *hashOffset)
+}
+
+func dump(p *verity.Params) {
+	fmt.Printf("VERITY header information for hash device\n")
+	fmt.Printf("UUID:            \t%s\n", uuid(p.UUID))
+	fmt.Printf("Hash type:       \t%d\n", p.HashType)
+	fmt.Printf("Data blocks:     \t%d\n", p.DataBlocks)
+	fmt.Printf("Data block size: \t%d\n", p.DataBlockSize)
+	fmt.Printf("Hash block size: \t%d\n", p.HashBlockSize)
+	fmt.Printf("Hash algorithm:  \t%s\n", p.Algorithm)
+	fmt.Printf("Salt:            \t%s\n", saltString(p.Salt))
+	fmt.Printf("Hash tree offset:\t%d\n", p.TreeOffset())
+	fmt.Printf("Hash tree size:  \t%d\n", p.TreeSize())
+}
+
+func uuid(u [16]byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+func saltString(s []byte) string {
+	if len(s) == 0 {
+		return "-"
+	}
+	return hex.EncodeToString(s)
+}
+
+func veritysetup(cmd string, a []string) error {
+	switch cmd {
+	case "format":
+		if len(a) != 2 {
+			usage()
+		}
+		d, err := os.Open(a[0])
+		if err != nil {
+			return err
+		}
+		h, err := os.OpenFile(a[1], os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		p, err := paramsFromFlags(d, true)
+		if err != nil {
+			return err
+		}
+		if _, err := rand.Read(p.UUID[:]); err != nil {
+			return err
+		}
+		// Mark the UUID as random (version 4).
+		p.UUID[6] = p.UUID[6]&0x0f | 0x40
+		p.UUID[8] = p.UUID[8]&0x3f | 0x80
+		root, err := verity.Format(d, h, p)
+		if err != nil {
+			return err
+		}
+		dump(p)
+		fmt.Printf("Root hash:       \t%x\n", root)
+		return h.Close()
+
+	case "verify":
+		if len(a) != 3 {
+			usage()
+		}
+		root, err := hex.DecodeString(a[2])
+		if err != nil {
+			return fmt.Errorf("bad root hash: %v", err)
+		}
+		d, err := os.Open(a[0])
+		if err != nil {
+			return err
+		}
+		h, err := os.Open(a[1])
+		if err != nil {
+			return err
+		}
+		p, err := params(d, h)
+		if err != nil {
+			return err
+		}
+		return verity.Verify(d, h, p, root)
+
+	case "open":
+		if len(a) != 4 {
+			usage()
+		}
+		root, err := hex.DecodeString(a[3])
+		if err != nil {
+			return fmt.Errorf("bad root hash: %v", err)
+		}
+		d, err := os.Open(a[0])
+		if err != nil {
+			return err
+		}
+		h, err := os.Open(a[2])
+		if err != nil {
+			return err
+		}
+		p, err := params(d, h)
+		if err != nil {
+			return err
+		}
+		_, err = verity.Open(a[0], a[2], a[1], p, root)
+		return err
+
+	case "close":
+		if len(a) != 1 {
+			usage()
+		}
+		return verity.Close(a[0])
+
+	case "dump":
+		if len(a) != 1 {
+			usage()
+		}
+		h, err := os.Open(a[0])
+		if err != nil {
+			return err
+		}
+		p, err := verity.ReadSuperblock(h, *hashOffset)
+		if err != nil {
+			return err
+		}
+		dump(p)
+		return nil
+	}
+	usage()
+	return nil
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	a := flag.Args()
+	if len(a) == 0 {
+		usage()
+	}
+	if err := veritysetup(a[0], a[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
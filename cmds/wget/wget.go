@@ -2,13 +2,27 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// Wget reads one file from a url and writes to stdout.
+// Wget reads one file from a url and writes it to a file or stdout.
 //
 // Synopsis:
-//     wget URL
+//     wget [OPTIONS...] URL
 //
 // Description:
-//     Returns a non-zero code on failure.
+//     Returns a non-zero code on failure. Failed requests are tried again
+//     with exponential backoff, and interrupted transfers are resumed
+//     where they stopped.
+//
+// Options:
+//     -O:                    output file, or - for stdout
+//     -c:                    continue a partially downloaded file
+//     -q:                    do not show progress
+//     -tries:                number of tries
+//     -header:               add a "Name: value" header; may be repeated
+//     -user:                 user name for basic authentication
+//     -password:             password for basic authentication
+//     -ca-certificate:       file with PEM CA certificates to trust; may be repeated
+//     -no-check-certificate: do not check server certificates
+//     -sha256:               check the file's SHA-256 sum
 //
 // Notes:
 //     There are a few differences with GNU wget:
@@ -20,6 +34,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io"
@@ -28,30 +43,105 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/download"
 )
 
+// list is a flag that may be repeated.
+type list []string
+
+func (l *list) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *list) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
 var (
-	outPath = flag.String("O", "", "output file")
+	outPath  = flag.String("O", "", "output file, or - for stdout")
+	resume   = flag.Bool("c", false, "continue a partially downloaded file")
+	quiet    = flag.Bool("q", false, "do not show progress")
+	tries    = flag.Int("tries", 5, "number of tries")
+	user     = flag.String("user", "", "user name for basic authentication")
+	password = flag.String("password", "", "password for basic authentication")
+	insecure = flag.Bool("no-check-certificate", false, "do not check server certificates")
+	sha256   = flag.String("sha256", "", "check the file's SHA-256 sum")
+	headers  list
+	caFiles  list
 )
 
+func init() {
+	flag.Var(&headers, "header", `add a "Name: value" header; may be repeated`)
+	flag.Var(&caFiles, "ca-certificate", "file with PEM CA certificates to trust; may be repeated")
+}
+
+func downloader() (*download.Downloader, error) {
+	c, err := download.NewClient(caFiles, *insecure)
+	if err != nil {
+		return nil, err
+	}
+	d := &download.Downloader{
+		Client:   c,
+		Header:   make(http.Header),
+		Username: *user,
+		Password: *password,
+		Retries:  *tries - 1,
+	}
+	for _, h := range headers {
+		i := strings.IndexByte(h, ':')
+		if i < 1 {
+			return nil, fmt.Errorf("bad header %q, want Name: value", h)
+		}
+		d.Header.Add(strings.TrimSpace(h[:i]), strings.TrimSpace(h[i+1:]))
+	}
+	return d, nil
+}
+
 func wget(arg, fileName string) error {
-	resp, err := http.Get(arg)
+	d, err := downloader()
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("non-200 HTTP status: %d", resp.StatusCode)
+	if !*quiet && fileName != "-" {
+		p := download.NewProgress(os.Stderr)
+		d.Progress = p.Update
+		defer p.Done()
 	}
+	ctx := context.Background()
 
-	w, err := os.Create(fileName)
-	if err != nil {
+	if fileName == "-" {
+		r, err := d.Open(ctx, arg, 0)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		if *sha256 != "" {
+			return download.CheckSHA256(io.TeeReader(r, os.Stdout), *sha256)
+		}
+		_, err = io.Copy(os.Stdout, r)
 		return err
 	}
-	defer w.Close()
 
-	_, err = io.Copy(w, resp.Body)
-	return err
+	if err := d.DownloadFile(ctx, arg, fileName, *resume); err != nil {
+		return err
+	}
+	if *sha256 == "" {
+		return nil
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := download.CheckSHA256(f, *sha256); err != nil {
+		// Do not leave a bad file behind to be continued with -c.
+		os.Remove(fileName)
+		return fmt.Errorf("%s: %v", fileName, err)
+	}
+	return nil
 }
 
 func usage() {
@@ -29,6 +29,11 @@ func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(content))
 	case "/302":
 		http.Redirect(w, r, "/200", 302)
+	case "/header":
+		if r.Header.Get("X-Test") != "yes" {
+			w.WriteHeader(400)
+		}
+		w.Write([]byte(content))
 	case "/500":
 		w.WriteHeader(500)
 		w.Write([]byte(content))
@@ -76,16 +81,40 @@ var tests = []struct {
 		retCode: 1,
 	}, {
 		// 5xx error
-		flags:   []string{},
+		flags:   []string{"-tries", "1"},
 		url:     "http://localhost:%[1]d/500",
 		content: "",
 		retCode: 1,
 	}, {
 		// no server
-		flags:   []string{},
+		flags:   []string{"-tries", "1"},
 		url:     "http://localhost:%[2]d/200",
 		content: "",
 		retCode: 1,
+	}, {
+		// header
+		flags:   []string{"-header", "X-Test: yes"},
+		url:     "http://localhost:%[1]d/header",
+		content: content,
+		retCode: 0,
+	}, {
+		// missing header
+		flags:   []string{},
+		url:     "http://localhost:%[1]d/header",
+		content: "",
+		retCode: 1,
+	}, {
+		// checksum
+		flags:   []string{"-sha256", "6ca789a59a530b874a3fc924bd0b02309302f66ace8144e1c486568b89a11cdd"},
+		url:     "http://localhost:%[1]d/200",
+		content: content,
+		retCode: 0,
+	}, {
+		// wrong checksum
+		flags:   []string{"-sha256", "d5c6cf6bc0e41ef8a4e0bfbdf2a3f1a3ea3d8b6c76aaf6fa6d37b7f89d3d6b06"},
+		url:     "http://localhost:%[1]d/200",
+		content: "",
+		retCode: 1,
 	}, {
 		// output file
 		flags:   []string{"-O", "/dev/null"},
@@ -115,10 +144,8 @@ func TestWget(t *testing.T) {
 	}
 	port := l.Addr().(*net.TCPAddr).Port
 
-	h := handler{}
-	go func() {
-		t.Fatal(http.Serve(l, h))
-	}()
+	defer l.Close()
+	go http.Serve(l, handler{})
 
 	for i, tt := range tests {
 		args := append(tt.flags, fmt.Sprintf(tt.url, port, unusedPort))
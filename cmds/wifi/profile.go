@@ -0,0 +1,180 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/u-root/u-root/pkg/wifi"
+)
+
+// Profile is a saved wireless network.
+type Profile struct {
+	Essid    string        `json:"essid"`
+	Security wifi.SecProto `json:"security"`
+	// Passphrase is the passphrase, or the PSK in hex, of WPA-PSK
+	// networks, and the password of WPA-EAP networks.
+	Passphrase string `json:"passphrase,omitempty"`
+	Identity   string `json:"identity,omitempty"`
+	// Networks with a higher priority are preferred.
+	Priority int `json:"priority,omitempty"`
+	// Hidden networks are tried even if scans do not find them.
+	Hidden bool `json:"hidden,omitempty"`
+}
+
+func (p Profile) validate() error {
+	switch {
+	case p.Essid == "":
+		return fmt.Errorf("profile has no essid")
+	case p.Security == wifi.WpaPsk && p.Passphrase == "":
+		return fmt.Errorf("%s: WPA-PSK needs a passphrase", p.Essid)
+	case p.Security == wifi.WpaEap && (p.Passphrase == "" || p.Identity == ""):
+		return fmt.Errorf("%s: WPA-EAP needs a passphrase and an identity", p.Essid)
+	case p.Security != wifi.NoEnc && p.Security != wifi.WpaPsk && p.Security != wifi.WpaEap:
+		return fmt.Errorf("%s: security %v is not supported", p.Essid, p.Security)
+	}
+	return nil
+}
+
+// args returns the arguments of wifi.WiFi.Connect for the profile.
+func (p Profile) args() []string {
+	switch p.Security {
+	case wifi.WpaPsk:
+		return []string{p.Essid, p.Passphrase}
+	case wifi.WpaEap:
+		return []string{p.Essid, p.Passphrase, p.Identity}
+	}
+	return []string{p.Essid}
+}
+
+func loadProfiles(path string) ([]Profile, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var profiles []Profile
+	if err := json.Unmarshal(b, &profiles); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, p := range profiles {
+		if err := p.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return profiles, nil
+}
+
+// saveProfile adds p to the profiles in path, replacing the one with the
+// same essid.
+func saveProfile(path string, p Profile) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	profiles, err := loadProfiles(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	found := false
+	for i := range profiles {
+		if profiles[i].Essid == p.Essid {
+			profiles[i], found = p, true
+		}
+	}
+	if !found {
+		profiles = append(profiles, p)
+	}
+	b, err := json.MarshalIndent(profiles, "", "\t")
+	if err != nil {
+		return err
+	}
+	// Profiles have passphrases in them.
+	return ioutil.WriteFile(path, append(b, '\n'), 0600)
+}
+
+// candidates returns the profiles to try, best first. These are the
+// profiles of the networks a scan found, with the same security, by
+// priority and then by signal, and then the hidden ones it did not find,
+// by priority.
+func candidates(profiles []Profile, opts []wifi.Option) []Profile {
+	type candidate struct {
+		Profile
+		visible bool
+		signal  int
+	}
+	var c []candidate
+	for _, p := range profiles {
+		var found *wifi.Option
+		for i, o := range opts {
+			if o.Essid == p.Essid && o.AuthSuite == p.Security {
+				found = &opts[i]
+				break
+			}
+		}
+		switch {
+		case found != nil:
+			c = append(c, candidate{p, true, found.Signal})
+		case p.Hidden:
+			c = append(c, candidate{p, false, 0})
+		}
+	}
+	// A signal of 0 is unknown, and counts as the weakest.
+	strength := func(s int) int {
+		if s == 0 {
+			return -1 << 31
+		}
+		return s
+	}
+	sort.SliceStable(c, func(i, j int) bool {
+		switch {
+		case c[i].visible != c[j].visible:
+			return c[i].visible
+		case c[i].Priority != c[j].Priority:
+			return c[i].Priority > c[j].Priority
+		}
+		return strength(c[i].signal) > strength(c[j].signal)
+	})
+	res := make([]Profile, len(c))
+	for i := range c {
+		res[i] = c[i].Profile
+	}
+	return res
+}
+
+// autoConnect connects to the best network of the profiles that is
+// around, and returns its essid.
+func autoConnect(w wifi.WiFi, profiles []Profile) (string, error) {
+	opts, err := w.Scan()
+	if err != nil {
+		return "", err
+	}
+	c := candidates(profiles, opts)
+	if len(c) == 0 {
+		return "", fmt.Errorf("no saved network found")
+	}
+	for _, p := range c {
+		log.Printf("Connecting to %s", p.Essid)
+		if err := w.Connect(p.args()...); err != nil {
+			log.Printf("%s: %v", p.Essid, err)
+			continue
+		}
+		log.Printf("Connected to %s", p.Essid)
+		return p.Essid, nil
+	}
+	return "", fmt.Errorf("could not connect to any saved network")
+}
+
+// keepConnected connects to a network of the profiles, unless w is
+// connected, and returns the essid of the network.
+func keepConnected(w wifi.WiFi, profiles []Profile) (string, error) {
+	if id, err := w.GetID(); err == nil && id != "" {
+		return id, nil
+	}
+	return autoConnect(w, profiles)
+}
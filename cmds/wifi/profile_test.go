@@ -0,0 +1,239 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/wifi"
+)
+
+func TestLoadProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wifi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tt := range []struct {
+		name string
+		json string
+		want []Profile
+		err  bool
+	}{
+		{
+			name: "good",
+			json: `[
+				{"essid": "home", "security": "WPA-PSK", "passphrase": "secret123", "priority": 2},
+				{"essid": "cafe", "security": "none"},
+				{"essid": "work", "security": "WPA-EAP", "passphrase": "pw", "identity": "me", "hidden": true}
+			]`,
+			want: []Profile{
+				{Essid: "home", Security: wifi.WpaPsk, Passphrase: "secret123", Priority: 2},
+				{Essid: "cafe", Security: wifi.NoEnc},
+				{Essid: "work", Security: wifi.WpaEap, Passphrase: "pw", Identity: "me", Hidden: true},
+			},
+		},
+		{name: "bad security", json: `[{"essid": "home", "security": "WEP"}]`, err: true},
+		{name: "unsupported", json: `[{"essid": "home", "security": "unsupported"}]`, err: true},
+		{name: "no passphrase", json: `[{"essid": "home", "security": "WPA-PSK"}]`, err: true},
+		{name: "no identity", json: `[{"essid": "home", "security": "WPA-EAP", "passphrase": "pw"}]`, err: true},
+		{name: "no essid", json: `[{"security": "none"}]`, err: true},
+		{name: "not JSON", json: `essid=home`, err: true},
+	} {
+		path := filepath.Join(dir, "wifi.json")
+		if err := ioutil.WriteFile(path, []byte(tt.json), 0600); err != nil {
+			t.Fatal(err)
+		}
+		got, err := loadProfiles(path)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: got error %v, want error %v", tt.name, err, tt.err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSaveProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wifi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "wifi.json")
+
+	home := Profile{Essid: "home", Security: wifi.WpaPsk, Passphrase: "secret123"}
+	cafe := Profile{Essid: "cafe", Security: wifi.NoEnc, Priority: -1}
+	for _, p := range []Profile{home, cafe, home} {
+		if err := saveProfile(path, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	home.Passphrase = "secret456"
+	if err := saveProfile(path, home); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveProfile(path, Profile{Essid: "bad", Security: wifi.WpaPsk}); err == nil {
+		t.Errorf("Saving a WPA-PSK profile without passphrase succeeded, want error")
+	}
+
+	got, err := loadProfiles(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Profile{home, cafe}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %+v, want %+v", got, want)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("Profiles have mode %v, want 0600", fi.Mode().Perm())
+	}
+}
+
+func TestCandidates(t *testing.T) {
+	home := Profile{Essid: "home", Security: wifi.WpaPsk, Passphrase: "secret123", Priority: 1}
+	cafe := Profile{Essid: "cafe", Security: wifi.NoEnc}
+	library := Profile{Essid: "library", Security: wifi.NoEnc}
+	fake := Profile{Essid: "fake", Security: wifi.WpaPsk, Passphrase: "secret123", Priority: 5}
+	hidden := Profile{Essid: "hidden", Security: wifi.WpaPsk, Passphrase: "secret123", Priority: 9, Hidden: true}
+	profiles := []Profile{cafe, home, library, fake, hidden}
+
+	for _, tt := range []struct {
+		name string
+		opts []wifi.Option
+		want []Profile
+	}{
+		{name: "none", want: []Profile{hidden}},
+		{
+			name: "priority and signal",
+			opts: []wifi.Option{
+				{Essid: "cafe", AuthSuite: wifi.NoEnc, Signal: -70},
+				{Essid: "library", AuthSuite: wifi.NoEnc, Signal: -40},
+				{Essid: "home", AuthSuite: wifi.WpaPsk, Signal: -80},
+				{Essid: "stranger", AuthSuite: wifi.NoEnc, Signal: -30},
+			},
+			want: []Profile{home, library, cafe, hidden},
+		},
+		{
+			name: "unknown signal",
+			opts: []wifi.Option{
+				{Essid: "cafe", AuthSuite: wifi.NoEnc},
+				{Essid: "library", AuthSuite: wifi.NoEnc, Signal: -90},
+			},
+			want: []Profile{library, cafe, hidden},
+		},
+		{
+			// A network with the name of a saved one, but other
+			// security, is not the saved one.
+			name: "other security",
+			opts: []wifi.Option{
+				{Essid: "fake", AuthSuite: wifi.NoEnc, Signal: -30},
+				{Essid: "hidden", AuthSuite: wifi.WpaPsk, Signal: -60},
+			},
+			want: []Profile{hidden},
+		},
+	} {
+		if got := candidates(profiles, tt.opts); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// refusingWorker is a StubWorker that cannot connect to some networks.
+type refusingWorker struct {
+	*wifi.StubWorker
+	refuse map[string]bool
+}
+
+func (w *refusingWorker) Connect(a ...string) error {
+	if w.refuse[a[0]] {
+		return fmt.Errorf("%s refused", a[0])
+	}
+	return w.StubWorker.Connect(a...)
+}
+
+func TestAutoConnect(t *testing.T) {
+	profiles := []Profile{
+		{Essid: "Stub1", Security: wifi.NoEnc},
+		{Essid: "Stub2", Security: wifi.WpaPsk, Passphrase: "secret123", Priority: 1},
+		{Essid: "Stub3", Security: wifi.WpaEap, Passphrase: "pw", Identity: "me", Priority: 2},
+	}
+	w := &wifi.StubWorker{Options: NearbyWifisStub}
+
+	essid, err := keepConnected(w, profiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"Stub3", "pw", "me"}}
+	if essid != "Stub3" || !reflect.DeepEqual(w.Connects, want) {
+		t.Errorf("Connected to %s with %v, want Stub3 with %v", essid, w.Connects, want)
+	}
+
+	// A connected worker is left alone.
+	if essid, err := keepConnected(w, profiles); err != nil || essid != "Stub3" || len(w.Connects) != 1 {
+		t.Errorf("Got %s, %v and %d connections, want Stub3 and 1 connection", essid, err, len(w.Connects))
+	}
+
+	// Once the connection is lost, the best network that works wins.
+	w.Disconnect()
+	r := &refusingWorker{w, map[string]bool{"Stub3": true}}
+	essid, err = keepConnected(r, profiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = append(want, []string{"Stub2", "secret123"})
+	if essid != "Stub2" || !reflect.DeepEqual(w.Connects, want) {
+		t.Errorf("Connected to %s with %v, want Stub2 with %v", essid, w.Connects, want)
+	}
+
+	w.Disconnect()
+	r.refuse = map[string]bool{"Stub1": true, "Stub2": true, "Stub3": true}
+	if _, err := keepConnected(r, profiles); err == nil {
+		t.Errorf("Connecting with all networks refusing succeeded, want error")
+	}
+	if _, err := autoConnect(w, []Profile{{Essid: "elsewhere", Security: wifi.NoEnc}}); err == nil {
+		t.Errorf("Connecting to a network that is not around succeeded, want error")
+	}
+}
+
+func TestWriteOptions(t *testing.T) {
+	var b bytes.Buffer
+	if err := writeOptions(&b, NearbyWifisStub); err != nil {
+		t.Fatal(err)
+	}
+	var got []map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]interface{}{
+		{"essid": "Stub1", "security": "none", "signal": -40.0},
+		{"essid": "Stub2", "security": "WPA-PSK", "signal": -50.0},
+		{"essid": "Stub3", "security": "WPA-EAP", "signal": -60.0},
+		{"essid": "Stub4", "security": "unsupported", "signal": -70.0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+
+	b.Reset()
+	if err := writeOptions(&b, nil); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "[]\n" {
+		t.Errorf("Got %q for no networks, want []", b.String())
+	}
+}
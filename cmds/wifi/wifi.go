@@ -5,19 +5,22 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/u-root/u-root/pkg/wifi"
 )
 
 const (
-	cmd = "wifi [options] essid [passphrase] [identity]"
+	cmd = "wifi [options] [essid [passphrase] [identity]]"
 )
 
 var (
@@ -28,15 +31,23 @@ var (
 	test  = flag.Bool("test", false, "set up a test server")
 	wType = flag.String("worker", "iwl", "What kind of wireless layer to use")
 
+	// Saved networks
+	config   = flag.String("config", "/etc/wifi.json", "file of saved networks")
+	auto     = flag.Bool("auto", false, "connect to the best saved network, and connect again when the connection is lost")
+	interval = flag.Duration("interval", 10*time.Second, "how often -auto checks the connection")
+	save     = flag.Bool("save", false, "save the network once connected")
+	priority = flag.Int("priority", 0, "priority of the saved network")
+	hidden   = flag.Bool("hidden", false, "the saved network is hidden")
+
 	// RegEx for parsing iwconfig output
 	iwconfigRE = regexp.MustCompile("(?m)^[a-zA-Z0-9]+\\s*IEEE 802.11.*$")
 
 	// Stub data for simple end-to-end interaction test
 	NearbyWifisStub = []wifi.Option{
-		{"Stub1", wifi.NoEnc},
-		{"Stub2", wifi.WpaPsk},
-		{"Stub3", wifi.WpaEap},
-		{"Stub4", wifi.NotSupportedProto},
+		{Essid: "Stub1", AuthSuite: wifi.NoEnc, Signal: -40},
+		{Essid: "Stub2", AuthSuite: wifi.WpaPsk, Signal: -50},
+		{Essid: "Stub3", AuthSuite: wifi.WpaEap, Signal: -60},
+		{Essid: "Stub4", AuthSuite: wifi.NotSupportedProto, Signal: -70},
 	}
 	workers = map[string]func(string) (wifi.WiFi, error){
 		"iwl":    wifi.NewIWLWorker,
@@ -104,21 +115,25 @@ func main() {
 		if err != nil {
 			log.Fatalf("error: %v", err)
 		}
-		for _, wifiOpt := range wifiOpts {
-			switch wifiOpt.AuthSuite {
-			case wifi.NoEnc:
-				fmt.Printf("%s: No Passphrase\n", wifiOpt.Essid)
-			case wifi.WpaPsk:
-				fmt.Printf("%s: WPA-PSK (only passphrase)\n", wifiOpt.Essid)
-			case wifi.WpaEap:
-				fmt.Printf("%s: WPA-EAP (passphrase and identity)\n", wifiOpt.Essid)
-			case wifi.NotSupportedProto:
-				fmt.Printf("%s: Not a supported protocol\n", wifiOpt.Essid)
-			}
+		if err := writeOptions(os.Stdout, wifiOpts); err != nil {
+			log.Fatal(err)
 		}
 		return
 	}
 
+	if *auto {
+		profiles, err := loadProfiles(*config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for {
+			if _, err := keepConnected(worker, profiles); err != nil {
+				log.Print(err)
+			}
+			time.Sleep(*interval)
+		}
+	}
+
 	a := flag.Args()
 	if len(a) > 3 {
 		flag.Usage()
@@ -143,4 +158,29 @@ func main() {
 	if err := worker.Connect(a...); err != nil {
 		log.Fatalf("error: %v", err)
 	}
+	if *save {
+		p := Profile{Essid: a[0], Priority: *priority, Hidden: *hidden}
+		switch len(a) {
+		case 3:
+			p.Security, p.Passphrase, p.Identity = wifi.WpaEap, a[1], a[2]
+		case 2:
+			p.Security, p.Passphrase = wifi.WpaPsk, a[1]
+		}
+		if err := saveProfile(*config, p); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// writeOptions writes the networks of a scan as JSON.
+func writeOptions(w io.Writer, opts []wifi.Option) error {
+	if opts == nil {
+		opts = []wifi.Option{}
+	}
+	b, err := json.MarshalIndent(opts, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
 }
@@ -0,0 +1,191 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package authenticode verifies the Authenticode signatures of PE/COFF
+// images, such as EFI-stub Linux kernels, the way UEFI Secure Boot does.
+//
+// A signed image carries one or more PKCS#7 SignedData blobs in the
+// certificate table of its optional header. Each one signs the Authenticode
+// hash of the image, which covers everything except the checksum field, the
+// certificate table directory entry and the certificate table itself. An
+// image is trusted if one of its signers chains to a certificate in the
+// allowed database (db), or if its hash is listed there, and neither its
+// hash nor any certificate in the chain is in the forbidden database (dbx).
+package authenticode
+
+import (
+	"bytes"
+	"crypto"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var (
+	// ErrNotSigned is returned for images without an Authenticode
+	// signature.
+	ErrNotSigned = errors.New("image is not signed")
+)
+
+const (
+	// certificateTable is the index of the certificate table in the data
+	// directory.
+	certificateTable = 4
+
+	// winCertRevision2 and winCertTypePKCS are the only kind of
+	// WIN_CERTIFICATE Secure Boot uses.
+	winCertRevision2 = 0x0200
+	winCertTypePKCS  = 0x0002
+)
+
+// layout describes the parts of a PE image Authenticode hashing treats
+// specially. All offsets are file offsets.
+type layout struct {
+	checksum      int
+	certDir       int
+	sizeOfHeaders int
+	certOffset    int
+	certSize      int
+	sections      []*pe.Section
+}
+
+func parseLayout(image []byte) (*layout, error) {
+	f, err := pe.NewFile(bytes.NewReader(image))
+	if err != nil {
+		return nil, err
+	}
+	if len(image) < 0x40 {
+		return nil, fmt.Errorf("image too short")
+	}
+	// The optional header follows the "PE\0\0" signature and the COFF file
+	// header, which are at the offset stored at 0x3c.
+	opt := int(binary.LittleEndian.Uint32(image[0x3c:])) + 4 + 20
+
+	l := &layout{
+		// CheckSum is at the same offset in PE32 and PE32+ headers.
+		checksum: opt + 64,
+		sections: f.Sections,
+	}
+	var dirs uint32
+	switch h := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		l.certDir = opt + 96
+		l.sizeOfHeaders = int(h.SizeOfHeaders)
+		dirs = h.NumberOfRvaAndSizes
+		if dirs > certificateTable {
+			l.certOffset = int(h.DataDirectory[certificateTable].VirtualAddress)
+			l.certSize = int(h.DataDirectory[certificateTable].Size)
+		}
+	case *pe.OptionalHeader64:
+		l.certDir = opt + 112
+		l.sizeOfHeaders = int(h.SizeOfHeaders)
+		dirs = h.NumberOfRvaAndSizes
+		if dirs > certificateTable {
+			l.certOffset = int(h.DataDirectory[certificateTable].VirtualAddress)
+			l.certSize = int(h.DataDirectory[certificateTable].Size)
+		}
+	default:
+		return nil, fmt.Errorf("no optional header")
+	}
+	// The certificate table's directory entry is hashed around even if
+	// the table is empty, but only if the entry exists at all.
+	l.certDir += certificateTable * 8
+	if dirs <= certificateTable {
+		l.certDir = -1
+	}
+	if l.sizeOfHeaders > len(image) || l.checksum+4 > l.sizeOfHeaders || l.certDir+8 > l.sizeOfHeaders {
+		return nil, fmt.Errorf("bad SizeOfHeaders %d", l.sizeOfHeaders)
+	}
+	if l.certSize != 0 && (l.certOffset < l.sizeOfHeaders || l.certOffset+l.certSize > len(image)) {
+		return nil, fmt.Errorf("certificate table [%#x, %#x) outside the image", l.certOffset, l.certOffset+l.certSize)
+	}
+	return l, nil
+}
+
+// Hash returns the Authenticode hash of image using h.
+func Hash(image []byte, h crypto.Hash) ([]byte, error) {
+	l, err := parseLayout(image)
+	if err != nil {
+		return nil, err
+	}
+	return l.hash(image, h)
+}
+
+func (l *layout) hash(image []byte, h crypto.Hash) ([]byte, error) {
+	if !h.Available() {
+		return nil, fmt.Errorf("hash %v is not available", h)
+	}
+	d := h.New()
+
+	// The headers, skipping the checksum and the certificate table entry.
+	d.Write(image[:l.checksum])
+	if l.certDir < 0 {
+		d.Write(image[l.checksum+4 : l.sizeOfHeaders])
+	} else {
+		d.Write(image[l.checksum+4 : l.certDir])
+		d.Write(image[l.certDir+8 : l.sizeOfHeaders])
+	}
+
+	// The sections, in file order.
+	s := make([]*pe.Section, len(l.sections))
+	copy(s, l.sections)
+	sort.Slice(s, func(i, j int) bool { return s[i].Offset < s[j].Offset })
+	hashed := l.sizeOfHeaders
+	for _, sec := range s {
+		if sec.Size == 0 {
+			continue
+		}
+		start, end := int(sec.Offset), int(sec.Offset)+int(sec.Size)
+		if start < l.sizeOfHeaders || end > len(image) {
+			return nil, fmt.Errorf("section %s [%#x, %#x) outside the image", sec.Name, start, end)
+		}
+		d.Write(image[start:end])
+		if end > hashed {
+			hashed = end
+		}
+	}
+
+	// Whatever follows the sections, up to the certificate table.
+	end := len(image)
+	if l.certSize != 0 {
+		end = l.certOffset
+	}
+	if hashed < end {
+		d.Write(image[hashed:end])
+	}
+	return d.Sum(nil), nil
+}
+
+// signedData returns the PKCS#7 blobs in the certificate table of image.
+func (l *layout) signedData(image []byte) ([][]byte, error) {
+	var blobs [][]byte
+	t := image[l.certOffset : l.certOffset+l.certSize]
+	for len(t) > 0 {
+		// WIN_CERTIFICATE: dwLength, wRevision, wCertificateType and
+		// bCertificate, padded to a multiple of 8 bytes.
+		if len(t) < 8 {
+			return nil, fmt.Errorf("truncated certificate table")
+		}
+		n := int(binary.LittleEndian.Uint32(t))
+		rev := binary.LittleEndian.Uint16(t[4:])
+		typ := binary.LittleEndian.Uint16(t[6:])
+		if n < 8 || n > len(t) {
+			return nil, fmt.Errorf("bad certificate length %d", n)
+		}
+		if rev == winCertRevision2 && typ == winCertTypePKCS {
+			blobs = append(blobs, t[8:n])
+		}
+		n = (n + 7) &^ 7
+		if n > len(t) {
+			n = len(t)
+		}
+		t = t[n:]
+	}
+	if len(blobs) == 0 {
+		return nil, ErrNotSigned
+	}
+	return blobs, nil
+}
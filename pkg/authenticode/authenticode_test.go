@@ -0,0 +1,361 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package authenticode
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testPE returns a minimal PE32+ image with one section and room for a
+// checksum and certificate table entry.
+func testPE(t *testing.T) []byte {
+	const (
+		lfanew  = 0x40
+		optSize = 240
+		headers = 0x200
+	)
+	b := make([]byte, headers+0x200)
+	copy(b, "MZ")
+	binary.LittleEndian.PutUint32(b[0x3c:], lfanew)
+	copy(b[lfanew:], "PE\x00\x00")
+	coff := b[lfanew+4:]
+	binary.LittleEndian.PutUint16(coff[0:], 0x8664) // Machine
+	binary.LittleEndian.PutUint16(coff[2:], 1)      // NumberOfSections
+	binary.LittleEndian.PutUint16(coff[16:], optSize)
+	opt := coff[20:]
+	binary.LittleEndian.PutUint16(opt[0:], 0x20b) // PE32+
+	binary.LittleEndian.PutUint32(opt[32:], 0x1000)
+	binary.LittleEndian.PutUint32(opt[36:], 0x200)
+	binary.LittleEndian.PutUint32(opt[56:], 0x2000)  // SizeOfImage
+	binary.LittleEndian.PutUint32(opt[60:], headers) // SizeOfHeaders
+	binary.LittleEndian.PutUint32(opt[64:], 0x1234)  // CheckSum
+	binary.LittleEndian.PutUint32(opt[108:], 16)     // NumberOfRvaAndSizes
+	sec := opt[optSize:]
+	copy(sec, ".text")
+	binary.LittleEndian.PutUint32(sec[8:], 0x200)   // VirtualSize
+	binary.LittleEndian.PutUint32(sec[12:], 0x1000) // VirtualAddress
+	binary.LittleEndian.PutUint32(sec[16:], 0x200)  // SizeOfRawData
+	binary.LittleEndian.PutUint32(sec[20:], headers)
+	for i := headers; i < len(b); i++ {
+		b[i] = byte(i)
+	}
+	return b
+}
+
+// attach appends a certificate table holding blobs to image.
+func attach(image []byte, blobs ...[]byte) []byte {
+	image = append([]byte(nil), image...)
+	off := len(image)
+	for _, blob := range blobs {
+		var hdr [8]byte
+		binary.LittleEndian.PutUint32(hdr[0:], uint32(8+len(blob)))
+		binary.LittleEndian.PutUint16(hdr[4:], winCertRevision2)
+		binary.LittleEndian.PutUint16(hdr[6:], winCertTypePKCS)
+		image = append(image, hdr[:]...)
+		image = append(image, blob...)
+		for len(image)%8 != 0 {
+			image = append(image, 0)
+		}
+	}
+	dir := 0x40 + 4 + 20 + 112 + certificateTable*8
+	binary.LittleEndian.PutUint32(image[dir:], uint32(off))
+	binary.LittleEndian.PutUint32(image[dir+4:], uint32(len(image)-off))
+	return image
+}
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newCert(t *testing.T, name string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	issuer, signer := tmpl, key
+	if parent != nil {
+		issuer, signer = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, issuer, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: c, key: key}
+}
+
+type testAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// sign returns a PKCS#7 SignedData blob signing image by s, including
+// certs.
+func sign(t *testing.T, image []byte, s *testCert, certs ...*testCert) []byte {
+	return signContentType(t, image, oidSpcIndirectDataContent, s, certs...)
+}
+
+// signContentType is sign with ct in the contentType attribute, which is
+// left out if ct is nil.
+func signContentType(t *testing.T, image []byte, ct asn1.ObjectIdentifier, s *testCert, certs ...*testCert) []byte {
+	digest, err := Hash(image, crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alg := pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+	idc, err := asn1.Marshal(spcIndirectDataContent{
+		Data:          asn1.RawValue{FullBytes: []byte{0x30, 0x00}},
+		MessageDigest: digestInfo{DigestAlgorithm: alg, Digest: digest},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var content asn1.RawValue
+	if _, err := asn1.Unmarshal(idc, &content); err != nil {
+		t.Fatal(err)
+	}
+	md := sha256.Sum256(content.Bytes)
+
+	mustMarshal := func(v interface{}) asn1.RawValue {
+		b, err := asn1.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return asn1.RawValue{FullBytes: b}
+	}
+	var ta []testAttribute
+	if ct != nil {
+		ta = append(ta, testAttribute{Type: oidContentType, Values: []asn1.RawValue{mustMarshal(ct)}})
+	}
+	ta = append(ta, testAttribute{Type: oidMessageDigest, Values: []asn1.RawValue{mustMarshal(md[:])}})
+	attrs, err := asn1.MarshalWithParams(ta, "set")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := sha256.Sum256(attrs)
+	sig, err := s.key.Sign(rand.Reader, h[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// In SignerInfo, the attributes are [0] IMPLICIT.
+	attrs[0] = 0xa0
+
+	var raw []byte
+	for _, c := range append([]*testCert{s}, certs...) {
+		raw = append(raw, c.cert.Raw...)
+	}
+	sd := signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{alg},
+		ContentInfo: contentInfo{
+			ContentType: oidSpcIndirectDataContent,
+			Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: idc},
+		},
+		Certificates: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: raw},
+		SignerInfos: []signerInfo{{
+			Version: 1,
+			IssuerAndSerial: issuerAndSerial{
+				Issuer: asn1.RawValue{FullBytes: s.cert.RawIssuer},
+				Serial: s.cert.SerialNumber,
+			},
+			DigestAlgorithm:           alg,
+			AuthenticatedAttributes:   asn1.RawValue{FullBytes: attrs},
+			DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256ECDSA},
+			EncryptedDigest:           sig,
+		}},
+	}
+	sdb, err := asn1.Marshal(sd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ci, err := asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sdb},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ci
+}
+
+func TestHashIgnoresChecksumAndCertTable(t *testing.T) {
+	image := testPE(t)
+	want, err := Hash(image, crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	modified := append([]byte(nil), image...)
+	binary.LittleEndian.PutUint32(modified[0x40+4+20+64:], 0xdeadbeef)
+	modified = attach(modified, []byte("not really a signature"))
+	got, err := Hash(modified, crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("hash changed with checksum and certificate table: got %x, want %x", got, want)
+	}
+
+	modified[0x300] ^= 1
+	got, err = Hash(modified, crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(got, want) {
+		t.Errorf("hash did not change with section data")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	root := newCert(t, "root", nil)
+	inter := newCert(t, "intermediate", root)
+	leaf := newCert(t, "leaf", inter)
+	other := newCert(t, "other", nil)
+
+	image := testPE(t)
+	signed := attach(image, sign(t, image, leaf, inter))
+	sha, err := Hash(image, crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var shaArr [sha256.Size]byte
+	copy(shaArr[:], sha)
+
+	tampered := append([]byte(nil), signed...)
+	tampered[0x250] ^= 0xff
+
+	for _, tt := range []struct {
+		name  string
+		image []byte
+		v     Verifier
+		want  error
+	}{
+		{"root in db", signed, Verifier{DB: DB{Certs: []*x509.Certificate{root.cert}}}, nil},
+		{"intermediate in db", signed, Verifier{DB: DB{Certs: []*x509.Certificate{inter.cert}}}, nil},
+		{"leaf in db", signed, Verifier{DB: DB{Certs: []*x509.Certificate{leaf.cert}}}, nil},
+		{"wrong root", signed, Verifier{DB: DB{Certs: []*x509.Certificate{other.cert}}}, ErrUntrusted},
+		{"hash in db", image, Verifier{DB: DB{Hashes: [][sha256.Size]byte{shaArr}}}, nil},
+		{"unsigned", image, Verifier{DB: DB{Certs: []*x509.Certificate{root.cert}}}, ErrNotSigned},
+		{"hash in dbx", signed, Verifier{
+			DB:  DB{Certs: []*x509.Certificate{root.cert}},
+			DBX: DB{Hashes: [][sha256.Size]byte{shaArr}},
+		}, ErrForbidden},
+		{"intermediate in dbx", signed, Verifier{
+			DB:  DB{Certs: []*x509.Certificate{root.cert}},
+			DBX: DB{Certs: []*x509.Certificate{inter.cert}},
+		}, ErrForbidden},
+		{"leaf hash in dbx", signed, Verifier{
+			DB:  DB{Certs: []*x509.Certificate{root.cert}},
+			DBX: DB{CertHashes: [][sha256.Size]byte{sha256.Sum256(leaf.cert.RawTBSCertificate)}},
+		}, ErrForbidden},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.v.Verify(tt.image); err != tt.want {
+				t.Errorf("Verify() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	v := Verifier{DB: DB{Certs: []*x509.Certificate{root.cert}}}
+	if err := v.Verify(tampered); err == nil {
+		t.Errorf("Verify(tampered image) = nil, want error")
+	}
+
+	// The authenticated attributes have to be for the signed content.
+	for _, ct := range []asn1.ObjectIdentifier{nil, oidSignedData} {
+		if err := v.Verify(attach(image, signContentType(t, image, ct, leaf, inter))); err == nil {
+			t.Errorf("Verify(contentType %v) = nil, want error", ct)
+		}
+	}
+
+	// A second signature by an untrusted signer does not matter.
+	twice := attach(image, sign(t, image, other), sign(t, image, leaf, inter))
+	if err := v.Verify(twice); err != nil {
+		t.Errorf("Verify(two signatures) = %v, want nil", err)
+	}
+}
+
+// signatureList returns an EFI_SIGNATURE_LIST of the given type.
+func signatureList(typ [16]byte, sigs ...[]byte) []byte {
+	var b bytes.Buffer
+	size := 16 + len(sigs[0])
+	binary.Write(&b, binary.LittleEndian, esl{
+		Type:     typ,
+		ListSize: uint32(28 + len(sigs)*size),
+		Size:     uint32(size),
+	})
+	for _, s := range sigs {
+		b.Write(make([]byte, 16))
+		b.Write(s)
+	}
+	return b.Bytes()
+}
+
+func TestReadDB(t *testing.T) {
+	c := newCert(t, "db", nil)
+	h := sha256.Sum256([]byte("image"))
+	esls := append(signatureList(certX509, c.cert.Raw), signatureList(certSHA256, h[:], h[:])...)
+
+	dir, err := ioutil.TempDir("", "authenticode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tt := range []struct {
+		name    string
+		content []byte
+		certs   int
+		hashes  int
+	}{
+		{"der", c.cert.Raw, 1, 0},
+		{"esl", esls, 1, 2},
+		{"efivar", append([]byte{0x27, 0, 0, 0}, esls...), 1, 2},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f := filepath.Join(dir, tt.name)
+			if err := ioutil.WriteFile(f, tt.content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			db, err := ReadDB(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(db.Certs) != tt.certs || len(db.Hashes) != tt.hashes {
+				t.Errorf("got %d certs and %d hashes, want %d and %d", len(db.Certs), len(db.Hashes), tt.certs, tt.hashes)
+			}
+			if tt.certs > 0 && !db.Certs[0].Equal(c.cert) {
+				t.Errorf("got certificate %v, want %v", db.Certs[0].Subject, c.cert.Subject)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package authenticode
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+)
+
+// Signature types in EFI signature lists. GUIDs are stored with their first
+// three fields little endian.
+var (
+	certSHA256     = [16]byte{0x26, 0x16, 0xc4, 0xc1, 0x4c, 0x50, 0x92, 0x40, 0xac, 0xa9, 0x41, 0xf9, 0x36, 0x93, 0x43, 0x28}
+	certX509       = [16]byte{0xa1, 0x59, 0xc0, 0xa5, 0xe4, 0x94, 0xa7, 0x4a, 0x87, 0xb5, 0xab, 0x15, 0x5c, 0x2b, 0xf0, 0x72}
+	certX509SHA256 = [16]byte{0x92, 0xa4, 0xd2, 0x3b, 0xc0, 0x96, 0x79, 0x40, 0xb4, 0x20, 0xfc, 0xf9, 0x8e, 0xf1, 0x03, 0xed}
+)
+
+// DB is a signature database like the UEFI db and dbx variables.
+type DB struct {
+	// Certs are X.509 certificates.
+	Certs []*x509.Certificate
+
+	// Hashes are SHA256 Authenticode hashes of images.
+	Hashes [][sha256.Size]byte
+
+	// CertHashes are SHA256 hashes of the TBSCertificate part of
+	// certificates.
+	CertHashes [][sha256.Size]byte
+}
+
+// Add adds the entries of o to db.
+func (db *DB) Add(o *DB) {
+	db.Certs = append(db.Certs, o.Certs...)
+	db.Hashes = append(db.Hashes, o.Hashes...)
+	db.CertHashes = append(db.CertHashes, o.CertHashes...)
+}
+
+func (db *DB) hasHash(h []byte) bool {
+	for _, d := range db.Hashes {
+		if bytes.Equal(d[:], h) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasCert returns whether c or its hash is in db.
+func (db *DB) hasCert(c *x509.Certificate) bool {
+	for _, d := range db.Certs {
+		if d.Equal(c) {
+			return true
+		}
+	}
+	h := sha256.Sum256(c.RawTBSCertificate)
+	for _, d := range db.CertHashes {
+		if d == h {
+			return true
+		}
+	}
+	return false
+}
+
+// esl is the header of an EFI_SIGNATURE_LIST.
+type esl struct {
+	Type       [16]byte
+	ListSize   uint32
+	HeaderSize uint32
+	Size       uint32
+}
+
+// ParseSignatureLists parses a sequence of EFI_SIGNATURE_LISTs, as stored
+// in the db and dbx variables. Unknown signature types are skipped.
+func ParseSignatureLists(b []byte) (*DB, error) {
+	db := &DB{}
+	for len(b) > 0 {
+		var l esl
+		if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, &l); err != nil {
+			return nil, fmt.Errorf("reading signature list: %v", err)
+		}
+		if l.ListSize < 28 || int(l.ListSize) > len(b) || l.Size <= 16 || 28+l.HeaderSize > l.ListSize {
+			return nil, fmt.Errorf("bad signature list sizes %d, %d, %d", l.ListSize, l.HeaderSize, l.Size)
+		}
+		sigs := b[28+l.HeaderSize : l.ListSize]
+		if len(sigs)%int(l.Size) != 0 {
+			return nil, fmt.Errorf("signature list of %d bytes is not a multiple of %d", len(sigs), l.Size)
+		}
+		for ; len(sigs) > 0; sigs = sigs[l.Size:] {
+			// Each EFI_SIGNATURE_DATA starts with its owner's GUID.
+			data := sigs[16:l.Size]
+			switch l.Type {
+			case certX509:
+				c, err := x509.ParseCertificate(data)
+				if err != nil {
+					return nil, err
+				}
+				db.Certs = append(db.Certs, c)
+			case certSHA256, certX509SHA256:
+				// EFI_CERT_X509_SHA256 entries are followed by a
+				// revocation time, which is ignored.
+				if len(data) < sha256.Size {
+					return nil, fmt.Errorf("short SHA256 signature")
+				}
+				var h [sha256.Size]byte
+				copy(h[:], data)
+				if l.Type == certSHA256 {
+					db.Hashes = append(db.Hashes, h)
+				} else {
+					db.CertHashes = append(db.CertHashes, h)
+				}
+			}
+		}
+		b = b[l.ListSize:]
+	}
+	return db, nil
+}
+
+// ReadDB reads a signature database from file, which may contain PEM
+// certificates, a DER certificate or EFI signature lists. Files read from
+// efivarfs start with the variable's attributes, which are skipped.
+func ReadDB(file string) (*DB, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Contains(b, []byte("-----BEGIN")) {
+		db := &DB{}
+		for {
+			var p *pem.Block
+			p, b = pem.Decode(b)
+			if p == nil {
+				break
+			}
+			if p.Type != "CERTIFICATE" {
+				continue
+			}
+			c, err := x509.ParseCertificate(p.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", file, err)
+			}
+			db.Certs = append(db.Certs, c)
+		}
+		return db, nil
+	}
+	if c, err := x509.ParseCertificate(b); err == nil {
+		return &DB{Certs: []*x509.Certificate{c}}, nil
+	}
+	db, err := ParseSignatureLists(b)
+	if err != nil && len(b) > 4 {
+		if db, err2 := ParseSignatureLists(b[4:]); err2 == nil {
+			return db, nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return db, nil
+}
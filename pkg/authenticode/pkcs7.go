@@ -0,0 +1,291 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package authenticode
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+)
+
+var (
+	oidSignedData             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidSpcIndirectDataContent = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 4}
+	oidContentType            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+
+	oidSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+
+	oidRSA         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidSHA1RSA     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 5}
+	oidSHA256RSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidSHA384RSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}
+	oidSHA512RSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}
+	oidECDSA       = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidSHA256ECDSA = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidSHA384ECDSA = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidSHA512ECDSA = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+)
+
+var digests = []struct {
+	oid  asn1.ObjectIdentifier
+	hash crypto.Hash
+}{
+	{oidSHA1, crypto.SHA1},
+	{oidSHA256, crypto.SHA256},
+	{oidSHA384, crypto.SHA384},
+	{oidSHA512, crypto.SHA512},
+}
+
+func digestHash(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	for _, d := range digests {
+		if d.oid.Equal(oid) {
+			return d.hash, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported digest algorithm %v", oid)
+}
+
+// signatureAlgorithm maps a SignerInfo's digest and signature algorithms to
+// an x509.SignatureAlgorithm. Signers may name either the bare key type or
+// the combined algorithm.
+func signatureAlgorithm(h crypto.Hash, sig asn1.ObjectIdentifier) (x509.SignatureAlgorithm, error) {
+	switch {
+	case sig.Equal(oidRSA), sig.Equal(oidSHA1RSA), sig.Equal(oidSHA256RSA), sig.Equal(oidSHA384RSA), sig.Equal(oidSHA512RSA):
+		switch h {
+		case crypto.SHA1:
+			return x509.SHA1WithRSA, nil
+		case crypto.SHA256:
+			return x509.SHA256WithRSA, nil
+		case crypto.SHA384:
+			return x509.SHA384WithRSA, nil
+		case crypto.SHA512:
+			return x509.SHA512WithRSA, nil
+		}
+	case sig.Equal(oidECDSA), sig.Equal(oidSHA256ECDSA), sig.Equal(oidSHA384ECDSA), sig.Equal(oidSHA512ECDSA):
+		switch h {
+		case crypto.SHA256:
+			return x509.ECDSAWithSHA256, nil
+		case crypto.SHA384:
+			return x509.ECDSAWithSHA384, nil
+		case crypto.SHA512:
+			return x509.ECDSAWithSHA512, nil
+		}
+	}
+	return x509.UnknownSignatureAlgorithm, fmt.Errorf("unsupported signature algorithm %v with %v", sig, h)
+}
+
+// The ASN.1 structures below are from RFC 2315 (PKCS#7) and the Authenticode
+// specification.
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type issuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerial           issuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+type spcIndirectDataContent struct {
+	Data          asn1.RawValue
+	MessageDigest digestInfo
+}
+
+type digestInfo struct {
+	DigestAlgorithm pkix.AlgorithmIdentifier
+	Digest          []byte
+}
+
+// Signature is a parsed Authenticode signature.
+type Signature struct {
+	// Hash is the algorithm the image hash was computed with.
+	Hash crypto.Hash
+
+	// Digest is the signed Authenticode hash of the image.
+	Digest []byte
+
+	// Signer signed the image. Certificates holds the other certificates
+	// in the signature, which may complete the chain to a trusted one.
+	Signer       *x509.Certificate
+	Certificates []*x509.Certificate
+}
+
+// parseSignature parses der, a PKCS#7 SignedData blob, and checks that its
+// signer signed its content. Whether the signer is trusted, or the content
+// matches an image, is up to the caller.
+func parseSignature(der []byte) (*Signature, error) {
+	var ci contentInfo
+	if rest, err := asn1.Unmarshal(der, &ci); err != nil {
+		return nil, fmt.Errorf("parsing ContentInfo: %v", err)
+	} else if len(rest) > 0 {
+		// Some signing tools pad the blob; that is harmless.
+		if len(bytes.Trim(rest, "\x00")) > 0 {
+			return nil, fmt.Errorf("trailing data after ContentInfo")
+		}
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("content type %v is not SignedData", ci.ContentType)
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("parsing SignedData: %v", err)
+	}
+	if !sd.ContentInfo.ContentType.Equal(oidSpcIndirectDataContent) {
+		return nil, fmt.Errorf("content type %v is not SpcIndirectDataContent", sd.ContentInfo.ContentType)
+	}
+	if len(sd.SignerInfos) != 1 {
+		return nil, fmt.Errorf("want 1 signer, got %d", len(sd.SignerInfos))
+	}
+
+	// The signed content is the SpcIndirectDataContent without its own
+	// tag and length.
+	var raw asn1.RawValue
+	if _, err := asn1.Unmarshal(sd.ContentInfo.Content.Bytes, &raw); err != nil {
+		return nil, fmt.Errorf("parsing SpcIndirectDataContent: %v", err)
+	}
+	content := raw.Bytes
+	var idc spcIndirectDataContent
+	if _, err := asn1.Unmarshal(raw.FullBytes, &idc); err != nil {
+		return nil, fmt.Errorf("parsing SpcIndirectDataContent: %v", err)
+	}
+	h, err := digestHash(idc.MessageDigest.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+	if len(sd.Certificates.Bytes) > 0 {
+		certs, err = x509.ParseCertificates(sd.Certificates.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing certificates: %v", err)
+		}
+	}
+
+	si := sd.SignerInfos[0]
+	var signer *x509.Certificate
+	for _, c := range certs {
+		if bytes.Equal(c.RawIssuer, si.IssuerAndSerial.Issuer.FullBytes) && c.SerialNumber.Cmp(si.IssuerAndSerial.Serial) == 0 {
+			signer = c
+			break
+		}
+	}
+	if signer == nil {
+		return nil, fmt.Errorf("signer certificate not found")
+	}
+	if err := si.verify(signer, content); err != nil {
+		return nil, err
+	}
+	return &Signature{
+		Hash:         h,
+		Digest:       idc.MessageDigest.Digest,
+		Signer:       signer,
+		Certificates: certs,
+	}, nil
+}
+
+// verify checks si's signature of content by c.
+func (si *signerInfo) verify(c *x509.Certificate, content []byte) error {
+	h, err := digestHash(si.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return err
+	}
+	alg, err := signatureAlgorithm(h, si.DigestEncryptionAlgorithm.Algorithm)
+	if err != nil {
+		return err
+	}
+
+	signed := content
+	if len(si.AuthenticatedAttributes.FullBytes) > 0 {
+		// With authenticated attributes, the signature is of their
+		// DER encoding as a SET, and they carry the content's digest.
+		attrs, err := parseAttributes(si.AuthenticatedAttributes.Bytes)
+		if err != nil {
+			return err
+		}
+		var md []byte
+		var ct asn1.ObjectIdentifier
+		for _, a := range attrs {
+			switch {
+			case a.Type.Equal(oidMessageDigest):
+				if _, err := asn1.Unmarshal(a.Values.Bytes, &md); err != nil {
+					return fmt.Errorf("parsing messageDigest: %v", err)
+				}
+			case a.Type.Equal(oidContentType):
+				if _, err := asn1.Unmarshal(a.Values.Bytes, &ct); err != nil {
+					return fmt.Errorf("parsing contentType: %v", err)
+				}
+			}
+		}
+		if md == nil {
+			return fmt.Errorf("no messageDigest attribute")
+		}
+		// The attributes must be for the content that was signed,
+		// which parseSignature only accepts as SpcIndirectDataContent.
+		if ct == nil {
+			return fmt.Errorf("no contentType attribute")
+		}
+		if !ct.Equal(oidSpcIndirectDataContent) {
+			return fmt.Errorf("contentType %v is not SpcIndirectDataContent", ct)
+		}
+		d := h.New()
+		d.Write(content)
+		if !bytes.Equal(d.Sum(nil), md) {
+			return fmt.Errorf("messageDigest does not match the signed content")
+		}
+		signed = append([]byte(nil), si.AuthenticatedAttributes.FullBytes...)
+		signed[0] = asn1.TagSet | 0x20
+	}
+	if err := c.CheckSignature(alg, signed, si.EncryptedDigest); err != nil {
+		return fmt.Errorf("bad signature by %q: %v", c.Subject, err)
+	}
+	return nil
+}
+
+func parseAttributes(b []byte) ([]attribute, error) {
+	var attrs []attribute
+	for len(b) > 0 {
+		var a attribute
+		rest, err := asn1.Unmarshal(b, &a)
+		if err != nil {
+			return nil, fmt.Errorf("parsing authenticated attributes: %v", err)
+		}
+		attrs = append(attrs, a)
+		b = rest
+	}
+	return attrs, nil
+}
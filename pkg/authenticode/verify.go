@@ -0,0 +1,132 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package authenticode
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrForbidden is returned for images whose hash or signer is in
+	// the forbidden database.
+	ErrForbidden = errors.New("image is forbidden by dbx")
+
+	// ErrUntrusted is returned for images with no signature chaining to
+	// the allowed database and whose hash is not listed there either.
+	ErrUntrusted = errors.New("image is not trusted by db")
+)
+
+// maxChain limits the length of certificate chains.
+const maxChain = 8
+
+// Verifier checks images against an allowed and a forbidden database.
+type Verifier struct {
+	DB  DB
+	DBX DB
+}
+
+// Verify returns nil if image is trusted.
+//
+// As in UEFI Secure Boot, certificate validity periods and key usages are
+// not checked: firmware has no trustworthy time and signing certificates
+// commonly outlive their nominal expiry.
+func (v *Verifier) Verify(image []byte) error {
+	l, err := parseLayout(image)
+	if err != nil {
+		return err
+	}
+	sha, err := l.hash(image, crypto.SHA256)
+	if err != nil {
+		return err
+	}
+	if v.DBX.hasHash(sha) {
+		return ErrForbidden
+	}
+
+	blobs, err := l.signedData(image)
+	if err == ErrNotSigned {
+		if v.DB.hasHash(sha) {
+			return nil
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	// Every signature must be valid and not forbidden; one trusted one
+	// is enough.
+	var trusted bool
+	for i, b := range blobs {
+		s, err := parseSignature(b)
+		if err != nil {
+			return fmt.Errorf("signature %d: %v", i, err)
+		}
+		d := sha
+		if s.Hash != crypto.SHA256 {
+			if d, err = l.hash(image, s.Hash); err != nil {
+				return err
+			}
+		}
+		if !bytes.Equal(d, s.Digest) {
+			return fmt.Errorf("signature %d: image hash does not match", i)
+		}
+		chain, err := v.chain(s)
+		for _, c := range chain {
+			if v.DBX.hasCert(c) {
+				return ErrForbidden
+			}
+		}
+		if err == nil {
+			trusted = true
+		}
+	}
+	if trusted || v.DB.hasHash(sha) {
+		return nil
+	}
+	return ErrUntrusted
+}
+
+// chain builds the chain from the signer of s to a certificate in db. It
+// returns the certificates it went through even if it fails, so they can be
+// checked against dbx.
+func (v *Verifier) chain(s *Signature) ([]*x509.Certificate, error) {
+	c := s.Signer
+	var chain []*x509.Certificate
+	for len(chain) < maxChain {
+		chain = append(chain, c)
+		if v.DB.hasCert(c) {
+			return chain, nil
+		}
+		for _, d := range v.DB.Certs {
+			if isSignedBy(c, d) {
+				return append(chain, d), nil
+			}
+		}
+		var next *x509.Certificate
+		for _, i := range s.Certificates {
+			if !i.Equal(c) && isSignedBy(c, i) {
+				next = i
+				break
+			}
+		}
+		if next == nil {
+			return chain, ErrUntrusted
+		}
+		c = next
+	}
+	return chain, fmt.Errorf("certificate chain longer than %d", maxChain)
+}
+
+func isSignedBy(c, parent *x509.Certificate) bool {
+	if !bytes.Equal(c.RawIssuer, parent.RawSubject) {
+		return false
+	}
+	return parent.CheckSignature(c.SignatureAlgorithm, c.RawTBSCertificate, c.Signature) == nil
+}
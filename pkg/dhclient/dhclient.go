@@ -4,6 +4,11 @@
 
 // Package dhclient provides a unified interface for interfacing with both
 // DHCPv4 and DHCPv6 clients.
+//
+// IPv6 configuration starts with a router solicitation. Depending on the
+// router's advertisement, addresses are then leased with DHCPv6 or
+// configured statelessly (SLAAC), with DNS servers from the advertisement or
+// a DHCPv6 information-request.
 package dhclient
 
 import (
@@ -16,6 +21,7 @@ import (
 	"net"
 	"net/url"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -112,10 +118,19 @@ func Configure6(iface netlink.Link, packet *dhcpv6.Message) error {
 
 // WriteDNSSettings writes the given IPs as nameservers to resolv.conf.
 func WriteDNSSettings(ips []net.IP) error {
+	return WriteResolvConf(ips, nil)
+}
+
+// WriteResolvConf writes the given IPs as nameservers and the search
+// domains to resolv.conf.
+func WriteResolvConf(ips []net.IP, search []string) error {
 	rc := &bytes.Buffer{}
 	for _, ip := range ips {
 		rc.WriteString(fmt.Sprintf("nameserver %s\n", ip))
 	}
+	if len(search) > 0 {
+		rc.WriteString(fmt.Sprintf("search %s\n", strings.Join(search, " ")))
+	}
 	return ioutil.WriteFile("/etc/resolv.conf", rc.Bytes(), 0644)
 }
 
@@ -126,7 +141,7 @@ type Lease interface {
 	Link() netlink.Link
 }
 
-func lease4(ctx context.Context, iface netlink.Link, timeout time.Duration, retries int) (Lease, error) {
+func lease4(ctx context.Context, iface netlink.Link, timeout time.Duration, retries int, c *config) (Lease, error) {
 	client, err := nclient4.New(iface.Attrs().Name,
 		nclient4.WithTimeout(timeout),
 		nclient4.WithRetry(retries))
@@ -135,7 +150,11 @@ func lease4(ctx context.Context, iface netlink.Link, timeout time.Duration, retr
 	}
 
 	log.Printf("Attempting to get DHCPv4 lease on %s", iface.Attrs().Name)
-	_, p, err := client.Request(ctx, dhcpv4.WithNetboot)
+	mods := []dhcpv4.Modifier{dhcpv4.WithNetboot}
+	if c.vendorClass != "" {
+		mods = append(mods, dhcpv4.WithOption(dhcpv4.OptClassIdentifier(c.vendorClass)))
+	}
+	_, p, err := client.Request(ctx, mods...)
 	if err != nil {
 		return nil, err
 	}
@@ -145,7 +164,7 @@ func lease4(ctx context.Context, iface netlink.Link, timeout time.Duration, retr
 	return packet, nil
 }
 
-func lease6(ctx context.Context, iface netlink.Link, timeout time.Duration, retries int) (Lease, error) {
+func lease6(ctx context.Context, iface netlink.Link, timeout time.Duration, retries int, c *config) (Lease, error) {
 	// For ipv6, we cannot bind to the port until Duplicate Address
 	// Detection (DAD) is complete which is indicated by the link being no
 	// longer marked as "tentative". This usually takes about a second.
@@ -163,6 +182,16 @@ func lease6(ctx context.Context, iface netlink.Link, timeout time.Duration, retr
 		}
 	}
 
+	// Routers say whether addresses come from DHCPv6 (the M flag) or
+	// are configured statelessly. Without a router, try DHCPv6 anyway.
+	log.Printf("Soliciting IPv6 routers on %s", iface.Attrs().Name)
+	ra, router, err := solicitRouter(ctx, iface)
+	if err != nil {
+		log.Printf("No IPv6 router on %s, trying DHCPv6: %v", iface.Attrs().Name, err)
+	} else if !ra.Managed {
+		return leaseRA(ctx, iface, router, ra, timeout, retries)
+	}
+
 	client, err := nclient6.New(iface.Attrs().Name,
 		nclient6.WithTimeout(timeout),
 		nclient6.WithRetry(retries))
@@ -171,23 +200,80 @@ func lease6(ctx context.Context, iface netlink.Link, timeout time.Duration, retr
 	}
 
 	log.Printf("Attempting to get DHCPv6 lease on %s", iface.Attrs().Name)
-	p, err := client.RapidSolicit(ctx, dhcpv6.WithNetboot)
+	mods := []dhcpv6.Modifier{dhcpv6.WithNetboot}
+	if c.vendorClass != "" {
+		mods = append(mods, withVendorClass6(c.vendorClass))
+	}
+	p, err := client.RapidSolicit(ctx, mods...)
 	if err != nil {
 		return nil, err
 	}
 
 	packet := NewPacket6(iface, p)
+	packet.router, packet.ra = router, ra
 	log.Printf("Got DHCPv6 lease on %s: %v", iface.Attrs().Name, p.Summary())
 	return packet, nil
 }
 
+// leaseRA returns the stateless configuration advertised by router, asking
+// DHCPv6 for the rest if the router set the O flag.
+func leaseRA(ctx context.Context, iface netlink.Link, router net.IP, ra *RouterAdvertisement, timeout time.Duration, retries int) (Lease, error) {
+	var info *dhcpv6.Message
+	if ra.Other {
+		log.Printf("Sending DHCPv6 information-request on %s", iface.Attrs().Name)
+		var err error
+		info, err = informationRequest(ctx, iface, timeout, retries)
+		if err != nil {
+			// The advertisement may still be enough to get on the
+			// network.
+			log.Printf("DHCPv6 information-request on %s: %v", iface.Attrs().Name, err)
+		}
+	}
+
+	l := NewRALease(iface, router, ra, info)
+	log.Printf("Got %s on %s", l, iface.Attrs().Name)
+	return l, nil
+}
+
+// Option changes the requests made by SendRequests.
+type Option func(*config)
+
+type config struct {
+	vendorClass string
+}
+
+// WithVendorClass identifies the client by its vendor class: option 60 in
+// DHCPv4, and the vendor class option in DHCPv6. Clients of UEFI HTTP boot
+// use HTTPClient, to which servers answer with boot file URLs.
+func WithVendorClass(class string) Option {
+	return func(c *config) {
+		c.vendorClass = class
+	}
+}
+
+// withVendorClass6 adds the DHCPv6 vendor class option. The enterprise
+// number is the one UEFI uses for HTTPClient.
+func withVendorClass6(class string) dhcpv6.Modifier {
+	return func(d dhcpv6.DHCPv6) {
+		d.AddOption(&dhcpv6.OptVendorClass{
+			EnterpriseNumber: 343,
+			Data:             [][]byte{[]byte(class)},
+		})
+	}
+}
+
 type Result struct {
 	Interface netlink.Link
 	Lease     Lease
 	Err       error
 }
 
-func SendRequests(ctx context.Context, ifs []netlink.Link, timeout time.Duration, retries int, ipv4, ipv6 bool) chan *Result {
+func SendRequests(ctx context.Context, ifs []netlink.Link, timeout time.Duration, retries int, ipv4, ipv6 bool, opts ...Option) chan *Result {
+	c := &config{}
+	for _, o := range opts {
+		o(c)
+	}
+
 	// Yeah, this is a hack, until we can cancel all leases in progress.
 	r := make(chan *Result, 3*len(ifs))
 
@@ -207,7 +293,7 @@ func SendRequests(ctx context.Context, ifs []netlink.Link, timeout time.Duration
 				wg.Add(1)
 				go func(iface netlink.Link) {
 					defer wg.Done()
-					lease, err := lease4(ctx, iface, timeout, retries)
+					lease, err := lease4(ctx, iface, timeout, retries, c)
 					r <- &Result{iface, lease, err}
 				}(iface)
 			}
@@ -216,7 +302,7 @@ func SendRequests(ctx context.Context, ifs []netlink.Link, timeout time.Duration
 				wg.Add(1)
 				go func(iface netlink.Link) {
 					defer wg.Done()
-					lease, err := lease6(ctx, iface, timeout, retries)
+					lease, err := lease6(ctx, iface, timeout, retries, c)
 					r <- &Result{iface, lease, err}
 				}(iface)
 			}
@@ -87,6 +87,19 @@ func (p *Packet4) Configure() error {
 	return nil
 }
 
+// Unconfigure removes the address this packet assigned from the interface,
+// along with the routes through it.
+func (p *Packet4) Unconfigure() error {
+	l := p.Lease()
+	if l == nil {
+		return fmt.Errorf("packet has no IP lease")
+	}
+	if err := netlink.AddrDel(p.iface, &netlink.Addr{IPNet: l}); err != nil {
+		return fmt.Errorf("delete %s from %v: %v", l, p.iface.Attrs().Name, err)
+	}
+	return nil
+}
+
 func (p *Packet4) String() string {
 	return fmt.Sprintf("IPv4 DHCP Lease IP %s", p.Lease())
 }
@@ -109,8 +122,21 @@ func (p *Packet4) Lease() *net.IPNet {
 var (
 	ErrNoBootFile       = errors.New("no boot file name present in DHCP message")
 	ErrNoServerHostName = errors.New("no server host name present in DHCP message")
+
+	// ErrNotHTTPURL is returned when an HTTPClient reply names a boot
+	// file that is not an HTTP URL.
+	ErrNotHTTPURL = errors.New("HTTPClient boot file is not an HTTP URL")
 )
 
+// HTTPClient is the vendor class of UEFI HTTP boot clients, and of the
+// servers' replies to them.
+const HTTPClient = "HTTPClient"
+
+// isHTTPURL returns true if u is an absolute HTTP or HTTPS URL.
+func isHTTPURL(u *url.URL) bool {
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
 // Boot returns the boot file assigned.
 func (p *Packet4) Boot() (*url.URL, error) {
 	// Look for dhcp option presence first, then legacy BootFileName in header.
@@ -129,6 +155,15 @@ func (p *Packet4) Boot() (*url.URL, error) {
 		return nil, err
 	}
 
+	// Replies to HTTP boot clients name the boot file with a URL, and
+	// never mean TFTP.
+	if strings.HasPrefix(p.P.ClassIdentifier(), HTTPClient) {
+		if !isHTTPURL(u) {
+			return nil, ErrNotHTTPURL
+		}
+		return u, nil
+	}
+
 	if len(u.Scheme) == 0 {
 		// Defaults to tftp is not specified.
 		u.Scheme = "tftp"
@@ -90,6 +90,31 @@ func TestBoot(t *testing.T) {
 				Path:   "pxelinux.0",
 			},
 		},
+		{
+			message: mustNew(t,
+				withNetbootInfo("http://10.0.0.1/boot/vmlinuz", ""),
+				dhcpv4.WithOption(dhcpv4.OptClassIdentifier(HTTPClient)),
+			),
+			want: &url.URL{
+				Scheme: "http",
+				Host:   "10.0.0.1",
+				Path:   "/boot/vmlinuz",
+			},
+		},
+		{
+			message: mustNew(t,
+				withNetbootInfo("bootx64.efi", "10.0.0.1"),
+				dhcpv4.WithOption(dhcpv4.OptClassIdentifier(HTTPClient)),
+			),
+			err: ErrNotHTTPURL,
+		},
+		{
+			message: mustNew(t,
+				withNetbootInfo("tftp://10.0.0.1/bootx64.efi", ""),
+				dhcpv4.WithOption(dhcpv4.OptClassIdentifier(HTTPClient)),
+			),
+			err: ErrNotHTTPURL,
+		},
 	} {
 		t.Run(fmt.Sprintf("test%d", i), func(t *testing.T) {
 			p := NewPacket4(nil, tt.message)
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"net"
 	"net/url"
+	"strings"
 
 	"github.com/insomniacslk/dhcp/dhcpv6"
 	"github.com/vishvananda/netlink"
@@ -17,6 +18,11 @@ import (
 type Packet6 struct {
 	p     *dhcpv6.Message
 	iface netlink.Link
+
+	// router and ra are the router advertisement that sent us to
+	// DHCPv6, if there was one. DHCPv6 does not give out routes.
+	router net.IP
+	ra     *RouterAdvertisement
 }
 
 // NewPacket6 wraps a DHCPv6 packet with some convenience methods.
@@ -33,7 +39,13 @@ func (p *Packet6) Link() netlink.Link {
 
 // Configure configures interface using this packet.
 func (p *Packet6) Configure() error {
-	return Configure6(p.iface, p.p)
+	if err := Configure6(p.iface, p.p); err != nil {
+		return err
+	}
+	if p.ra != nil {
+		return configureRouter(p.iface, p.router, p.ra)
+	}
+	return nil
 }
 
 func (p *Packet6) String() string {
@@ -74,11 +86,38 @@ func (p *Packet6) DNS() []net.IP {
 // they added to the packet? Are they added to an IANA?  It *seems* like it's
 // in the packet.
 func (p *Packet6) Boot() (*url.URL, error) {
-	uriOpt := p.p.GetOneOption(dhcpv6.OptionBootfileURL)
+	return bootFileURL(p.p)
+}
+
+func bootFileURL(m *dhcpv6.Message) (*url.URL, error) {
+	uriOpt := m.GetOneOption(dhcpv6.OptionBootfileURL)
 	uri, ok := uriOpt.(*dhcpv6.OptBootFileURL)
 	if !ok {
 		return nil, fmt.Errorf("packet does not contain boot file URL")
 	}
 	// Srsly, a []byte?
-	return url.Parse(string(uri.BootFileURL))
+	u, err := url.Parse(string(uri.BootFileURL))
+	if err != nil {
+		return nil, err
+	}
+	if isHTTPClient6(m) && !isHTTPURL(u) {
+		return nil, ErrNotHTTPURL
+	}
+	return u, nil
+}
+
+// isHTTPClient6 returns true if m has the HTTPClient vendor class.
+func isHTTPClient6(m *dhcpv6.Message) bool {
+	for _, o := range m.GetOption(dhcpv6.OptionVendorClass) {
+		vc, ok := o.(*dhcpv6.OptVendorClass)
+		if !ok {
+			continue
+		}
+		for _, d := range vc.Data {
+			if strings.HasPrefix(string(d), HTTPClient) {
+				return true
+			}
+		}
+	}
+	return false
 }
@@ -0,0 +1,458 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	// minRetransmit is the shortest wait between RENEW or REBIND
+	// attempts, as suggested by RFC 2131, Section 4.4.5.
+	minRetransmit = 60 * time.Second
+
+	// rediscoverInterval is how long to wait before trying to get a lease
+	// again after discovery failed.
+	rediscoverInterval = 10 * time.Second
+)
+
+// Lease4 is a DHCPv4 lease bound at a point in time.
+type Lease4 struct {
+	*Packet4
+
+	// Acquired is when the request that got the lease was sent, which is
+	// when the lease times start counting.
+	Acquired time.Time
+}
+
+// leaseTime returns the lease duration, or 0 for an infinite lease.
+func (l *Lease4) leaseTime() time.Duration {
+	d := l.P.IPAddressLeaseTime(0)
+	if d == time.Duration(0xffffffff)*time.Second {
+		return 0
+	}
+	return d
+}
+
+func (l *Lease4) optionTime(code dhcpv4.OptionCode, def time.Duration) time.Duration {
+	var d dhcpv4.Duration
+	if v := l.P.GetOneOption(code); v == nil || d.FromBytes(v) != nil {
+		return def
+	}
+	return time.Duration(d)
+}
+
+// Expiry is when the lease ends, or the zero time if it never does.
+func (l *Lease4) Expiry() time.Time {
+	if l.leaseTime() == 0 {
+		return time.Time{}
+	}
+	return l.Acquired.Add(l.leaseTime())
+}
+
+// T1 is when the client starts renewing the lease with the server that
+// granted it. It is the zero time for infinite leases.
+func (l *Lease4) T1() time.Time {
+	lt := l.leaseTime()
+	if lt == 0 {
+		return time.Time{}
+	}
+	return l.Acquired.Add(l.optionTime(dhcpv4.OptionRenewTimeValue, lt/2))
+}
+
+// T2 is when the client starts asking any server to extend the lease. It is
+// the zero time for infinite leases.
+func (l *Lease4) T2() time.Time {
+	lt := l.leaseTime()
+	if lt == 0 {
+		return time.Time{}
+	}
+	return l.Acquired.Add(l.optionTime(dhcpv4.OptionRebindingTimeValue, lt*7/8))
+}
+
+// Expired returns whether the lease has expired at t.
+func (l *Lease4) Expired(t time.Time) bool {
+	e := l.Expiry()
+	return !e.IsZero() && !t.Before(e)
+}
+
+// storedLease4 is the on-disk form of a Lease4.
+type storedLease4 struct {
+	Interface string    `json:"interface"`
+	Acquired  time.Time `json:"acquired"`
+	Ack       []byte    `json:"ack"`
+}
+
+// WriteLease4 saves l to file.
+func WriteLease4(file string, l *Lease4) error {
+	b, err := json.Marshal(storedLease4{
+		Interface: l.iface.Attrs().Name,
+		Acquired:  l.Acquired,
+		Ack:       l.P.ToBytes(),
+	})
+	if err != nil {
+		return err
+	}
+	// Write and rename, so that a crash never leaves a partial lease.
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// ReadLease4 reads a lease for iface saved by WriteLease4.
+func ReadLease4(file string, iface netlink.Link) (*Lease4, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var s storedLease4
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	if s.Interface != iface.Attrs().Name {
+		return nil, fmt.Errorf("%s: lease is for interface %q, not %q", file, s.Interface, iface.Attrs().Name)
+	}
+	p, err := dhcpv4.FromBytes(s.Ack)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return &Lease4{Packet4: NewPacket4(iface, p), Acquired: s.Acquired}, nil
+}
+
+// LeaseManager4 acquires a DHCPv4 lease and keeps it for as long as it
+// runs, renewing it at T1 and rebinding at T2 as described in RFC 2131,
+// Section 4.4.
+type LeaseManager4 struct {
+	// Iface is the interface to get a lease for.
+	Iface netlink.Link
+
+	// Timeout and Retries configure retransmission of each request.
+	Timeout time.Duration
+	Retries int
+
+	// LeaseFile, if set, is where the lease is saved. On start, a saved
+	// lease that has not expired is requested again (INIT-REBOOT) rather
+	// than discovering a new one.
+	LeaseFile string
+
+	// Release makes Run release the lease when it returns.
+	Release bool
+
+	// Modifiers are applied to every request.
+	Modifiers []dhcpv4.Modifier
+
+	// Bound is called with each new or extended lease. It defaults to
+	// configuring the interface.
+	Bound func(*Lease4) error
+
+	// Unbound is called when a lease expires, is refused or released.
+	// It defaults to removing the lease's address from the interface.
+	Unbound func(*Lease4) error
+
+	// conn, broadcast and serverPort replace the raw socket, the
+	// broadcast address and the DHCP server port in tests.
+	conn       net.PacketConn
+	broadcast  *net.UDPAddr
+	serverPort int
+}
+
+func (m *LeaseManager4) name() string {
+	return m.Iface.Attrs().Name
+}
+
+// Run gets a lease and keeps it until ctx is done.
+func (m *LeaseManager4) Run(ctx context.Context) error {
+	conn := m.conn
+	if conn == nil {
+		var err error
+		if conn, err = nclient4.NewRawUDPConn(m.name(), nclient4.ClientPort); err != nil {
+			return err
+		}
+	}
+	broadcast := m.broadcast
+	if broadcast == nil {
+		broadcast = nclient4.DefaultServers
+	}
+	client, err := nclient4.NewWithConn(conn, m.Iface.Attrs().HardwareAddr,
+		nclient4.WithTimeout(m.Timeout),
+		nclient4.WithRetry(m.Retries),
+		nclient4.WithServerAddr(broadcast))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var l *Lease4
+	if m.LeaseFile != "" {
+		saved, err := ReadLease4(m.LeaseFile, m.Iface)
+		switch {
+		case err == nil && !saved.Expired(time.Now()):
+			log.Printf("Requesting saved lease %s on %s", saved.Lease(), m.name())
+			if l, _, err = m.reboot(ctx, client, saved); err != nil {
+				return err
+			}
+		case err != nil && !os.IsNotExist(err):
+			log.Printf("Ignoring saved lease: %v", err)
+		}
+	}
+
+	var bound *Lease4
+	for {
+		if l == nil {
+			if l, err = m.discover(ctx, client); err != nil {
+				break
+			}
+		}
+		if err := m.bind(l); err != nil {
+			return err
+		}
+		bound = l
+		if l, err = m.keep(ctx, client, l); err != nil {
+			break
+		}
+		if l == nil {
+			m.unbind(bound)
+			bound = nil
+		}
+	}
+
+	if bound != nil && m.Release {
+		if err := m.release(conn, bound); err != nil {
+			log.Printf("Releasing lease on %s: %v", m.name(), err)
+		}
+		m.unbind(bound)
+	}
+	return nil
+}
+
+func (m *LeaseManager4) bind(l *Lease4) error {
+	log.Printf("Bound %s on %s until %v", l.Lease(), m.name(), l.Expiry())
+	bound := m.Bound
+	if bound == nil {
+		bound = func(l *Lease4) error { return l.Configure() }
+	}
+	if err := bound(l); err != nil {
+		return err
+	}
+	if m.LeaseFile != "" {
+		if err := os.MkdirAll(filepath.Dir(m.LeaseFile), 0755); err != nil {
+			log.Printf("Saving lease: %v", err)
+		} else if err := WriteLease4(m.LeaseFile, l); err != nil {
+			log.Printf("Saving lease: %v", err)
+		}
+	}
+	return nil
+}
+
+func (m *LeaseManager4) unbind(l *Lease4) {
+	log.Printf("Dropping lease %s on %s", l.Lease(), m.name())
+	unbound := m.Unbound
+	if unbound == nil {
+		unbound = func(l *Lease4) error { return l.Unconfigure() }
+	}
+	if err := unbound(l); err != nil {
+		log.Printf("Unconfiguring %s: %v", m.name(), err)
+	}
+	if m.LeaseFile != "" {
+		os.Remove(m.LeaseFile)
+	}
+}
+
+// discover gets a new lease, trying until it succeeds or ctx is done.
+func (m *LeaseManager4) discover(ctx context.Context, client *nclient4.Client) (*Lease4, error) {
+	for {
+		start := time.Now()
+		_, ack, err := client.Request(ctx, m.Modifiers...)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		switch {
+		case err != nil:
+			log.Printf("Getting DHCPv4 lease on %s: %v", m.name(), err)
+		case ack.MessageType() == dhcpv4.MessageTypeAck:
+			return &Lease4{Packet4: NewPacket4(m.Iface, ack), Acquired: start}, nil
+		default:
+			log.Printf("Getting DHCPv4 lease on %s: got %s", m.name(), ack.MessageType())
+		}
+		if err := sleepUntil(ctx, time.Now().Add(rediscoverInterval)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// reboot asks for a saved lease again. A nil lease means the lease was
+// refused or nobody answered, and a new one needs to be discovered.
+//
+// As RFC 2131, Section 3.2 allows, it only waits as long as the client
+// would for any request, so that moving to another network does not keep
+// the client waiting for a lease nobody will acknowledge.
+func (m *LeaseManager4) reboot(ctx context.Context, client *nclient4.Client, l *Lease4) (*Lease4, bool, error) {
+	req, err := dhcpv4.New(dhcpv4.PrependModifiers(m.Modifiers,
+		dhcpv4.WithHwAddr(m.Iface.Attrs().HardwareAddr),
+		dhcpv4.WithBroadcast(true),
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(l.P.YourIPAddr)),
+		withRequestedOptions,
+	)...)
+	if err != nil {
+		return nil, false, err
+	}
+	until := time.Now().Add(m.Timeout * time.Duration(m.Retries))
+	if e := l.Expiry(); !e.IsZero() && e.Before(until) {
+		until = e
+	}
+	return m.exchange(ctx, client, req, nil, until)
+}
+
+// keep waits until T1 and extends l. It returns the new lease, or nil if
+// the lease was refused or expired.
+func (m *LeaseManager4) keep(ctx context.Context, client *nclient4.Client, l *Lease4) (*Lease4, error) {
+	if l.Expiry().IsZero() {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if err := sleepUntil(ctx, l.T1()); err != nil {
+		return nil, err
+	}
+
+	// RENEWING: ask the server that granted the lease, until T2.
+	port := m.serverPort
+	if port == 0 {
+		port = nclient4.ServerPort
+	}
+	server := &net.UDPAddr{IP: l.P.ServerIdentifier(), Port: port}
+	if server.IP == nil {
+		server.IP = l.P.ServerIPAddr
+	}
+	for now := time.Now(); now.Before(l.T2()); now = time.Now() {
+		next, refused, err := m.extend(ctx, client, l, server, retransmit(now, l.T2()))
+		if err != nil || next != nil || refused {
+			return next, err
+		}
+	}
+
+	// REBINDING: ask any server, until the lease expires.
+	for now := time.Now(); now.Before(l.Expiry()); now = time.Now() {
+		next, refused, err := m.extend(ctx, client, l, nil, retransmit(now, l.Expiry()))
+		if err != nil || next != nil || refused {
+			return next, err
+		}
+	}
+	return nil, nil
+}
+
+// retransmit returns when to give up on a request sent at now, halving the
+// time left until end but waiting at least minRetransmit.
+func retransmit(now, end time.Time) time.Time {
+	wait := end.Sub(now) / 2
+	if wait < minRetransmit {
+		wait = minRetransmit
+	}
+	if t := now.Add(wait); t.Before(end) {
+		return t
+	}
+	return end
+}
+
+var withRequestedOptions = dhcpv4.WithRequestedOptions(
+	dhcpv4.OptionSubnetMask,
+	dhcpv4.OptionRouter,
+	dhcpv4.OptionDomainName,
+	dhcpv4.OptionDomainNameServer,
+)
+
+// extend sends a request to extend l to server, or broadcasts it if server
+// is nil.
+func (m *LeaseManager4) extend(ctx context.Context, client *nclient4.Client, l *Lease4, server *net.UDPAddr, until time.Time) (*Lease4, bool, error) {
+	req, err := dhcpv4.New(dhcpv4.PrependModifiers(m.Modifiers,
+		dhcpv4.WithHwAddr(m.Iface.Attrs().HardwareAddr),
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+		dhcpv4.WithClientIP(l.P.YourIPAddr),
+		withRequestedOptions,
+	)...)
+	if err != nil {
+		return nil, false, err
+	}
+	return m.exchange(ctx, client, req, server, until)
+}
+
+// exchange sends req to dest (or broadcasts it) and waits for an answer
+// until the given time. It returns the new lease if the server acknowledged
+// it, or whether the server refused it.
+func (m *LeaseManager4) exchange(ctx context.Context, client *nclient4.Client, req *dhcpv4.DHCPv4, dest *net.UDPAddr, until time.Time) (*Lease4, bool, error) {
+	if dest == nil {
+		dest = m.broadcast
+		if dest == nil {
+			dest = nclient4.DefaultServers
+		}
+	}
+	rctx, cancel := context.WithDeadline(ctx, until)
+	defer cancel()
+	start := time.Now()
+	resp, err := client.SendAndRead(rctx, dest, req, nil)
+	if ctx.Err() != nil {
+		return nil, false, ctx.Err()
+	}
+	if err != nil {
+		// Nobody answered; wait out the interval before retrying.
+		return nil, false, sleepUntil(ctx, until)
+	}
+	switch resp.MessageType() {
+	case dhcpv4.MessageTypeAck:
+		return &Lease4{Packet4: NewPacket4(m.Iface, resp), Acquired: start}, false, nil
+	case dhcpv4.MessageTypeNak:
+		log.Printf("Server refused lease %s on %s", req.ClientIPAddr, m.name())
+		return nil, true, nil
+	}
+	return nil, false, nil
+}
+
+// release gives l back to the server that granted it.
+func (m *LeaseManager4) release(conn net.PacketConn, l *Lease4) error {
+	port := m.serverPort
+	if port == 0 {
+		port = nclient4.ServerPort
+	}
+	server := l.P.ServerIdentifier()
+	req, err := dhcpv4.New(
+		dhcpv4.WithHwAddr(m.Iface.Attrs().HardwareAddr),
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeRelease),
+		dhcpv4.WithClientIP(l.P.YourIPAddr),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(server)),
+	)
+	if err != nil {
+		return err
+	}
+	// Servers do not answer a release.
+	log.Printf("Releasing %s on %s", l.Lease(), m.name())
+	_, err = conn.WriteTo(req.ToBytes(), &net.UDPAddr{IP: server, Port: port})
+	return err
+}
+
+// sleepUntil waits until t or until ctx is done.
+func sleepUntil(ctx context.Context, t time.Time) error {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
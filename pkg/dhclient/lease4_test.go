@@ -0,0 +1,407 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhclient
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/vishvananda/netlink"
+)
+
+var (
+	testHWAddr = net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	testIP     = net.IPv4(192, 168, 0, 10).To4()
+)
+
+// testServer is a DHCPv4 server on two loopback sockets, one standing in
+// for the server's unicast address and one for broadcasts.
+type testServer struct {
+	t         *testing.T
+	unicast   net.PacketConn
+	broadcast net.PacketConn
+	leaseTime time.Duration
+
+	// answer decides how to answer a REQUEST. It returns the message
+	// type of the reply, or MessageTypeNone to ignore the request.
+	answer func(req *dhcpv4.DHCPv4, broadcast bool) dhcpv4.MessageType
+
+	mu       sync.Mutex
+	received []received
+}
+
+type received struct {
+	typ       dhcpv4.MessageType
+	broadcast bool
+	ciaddr    net.IP
+	requested net.IP
+}
+
+func newTestServer(t *testing.T, leaseTime time.Duration) *testServer {
+	s := &testServer{t: t, leaseTime: leaseTime}
+	var err error
+	if s.unicast, err = net.ListenPacket("udp4", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	if s.broadcast, err = net.ListenPacket("udp4", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	s.answer = func(*dhcpv4.DHCPv4, bool) dhcpv4.MessageType { return dhcpv4.MessageTypeAck }
+	go s.serve(s.unicast, false)
+	go s.serve(s.broadcast, true)
+	return s
+}
+
+func (s *testServer) Close() {
+	s.unicast.Close()
+	s.broadcast.Close()
+}
+
+func (s *testServer) serve(conn net.PacketConn, broadcast bool) {
+	b := make([]byte, 1500)
+	for {
+		n, addr, err := conn.ReadFrom(b)
+		if err != nil {
+			return
+		}
+		req, err := dhcpv4.FromBytes(b[:n])
+		if err != nil {
+			continue
+		}
+		s.mu.Lock()
+		s.received = append(s.received, received{
+			typ:       req.MessageType(),
+			broadcast: broadcast,
+			ciaddr:    req.ClientIPAddr,
+			requested: req.RequestedIPAddress(),
+		})
+		answer := s.answer
+		s.mu.Unlock()
+
+		var typ dhcpv4.MessageType
+		switch req.MessageType() {
+		case dhcpv4.MessageTypeDiscover:
+			typ = dhcpv4.MessageTypeOffer
+		case dhcpv4.MessageTypeRequest:
+			typ = answer(req, broadcast)
+		}
+		if typ == dhcpv4.MessageTypeNone {
+			continue
+		}
+		resp, err := dhcpv4.NewReplyFromRequest(req,
+			dhcpv4.WithMessageType(typ),
+			dhcpv4.WithYourIP(testIP),
+			dhcpv4.WithNetmask(net.CIDRMask(24, 32)),
+			dhcpv4.WithLeaseTime(uint32(s.leaseTime/time.Second)),
+			dhcpv4.WithOption(dhcpv4.OptServerIdentifier(net.IPv4(127, 0, 0, 1))),
+		)
+		if err != nil {
+			s.t.Error(err)
+			return
+		}
+		conn.WriteTo(resp.ToBytes(), addr)
+	}
+}
+
+func (s *testServer) setAnswer(f func(req *dhcpv4.DHCPv4, broadcast bool) dhcpv4.MessageType) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.answer = f
+}
+
+// waitFor waits until the server has received a message of type typ.
+func (s *testServer) waitFor(typ dhcpv4.MessageType) {
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		for _, r := range s.messages() {
+			if r.typ == typ {
+				return
+			}
+		}
+	}
+	s.t.Errorf("server did not receive a %s", typ)
+}
+
+func (s *testServer) messages() []received {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]received(nil), s.received...)
+}
+
+// testManager returns a LeaseManager4 talking to s, which reports leases
+// and lost leases on the returned channels.
+func testManager(t *testing.T, s *testServer) (*LeaseManager4, chan *Lease4, chan *Lease4) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bound, unbound := make(chan *Lease4, 10), make(chan *Lease4, 10)
+	return &LeaseManager4{
+		Iface:   &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "test0", HardwareAddr: testHWAddr}},
+		Timeout: 100 * time.Millisecond,
+		Retries: 2,
+		Release: true,
+		Bound: func(l *Lease4) error {
+			bound <- l
+			return nil
+		},
+		Unbound: func(l *Lease4) error {
+			unbound <- l
+			return nil
+		},
+		conn:       conn,
+		broadcast:  s.broadcast.LocalAddr().(*net.UDPAddr),
+		serverPort: s.unicast.LocalAddr().(*net.UDPAddr).Port,
+	}, bound, unbound
+}
+
+func receive(t *testing.T, c chan *Lease4, what string) *Lease4 {
+	select {
+	case l := <-c:
+		return l
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+		return nil
+	}
+}
+
+func run(m *LeaseManager4) (context.CancelFunc, chan error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- m.Run(ctx)
+	}()
+	return cancel, done
+}
+
+func TestLease4Times(t *testing.T) {
+	now := time.Now()
+	for _, tt := range []struct {
+		name           string
+		opts           []dhcpv4.Modifier
+		t1, t2, expiry time.Duration
+	}{
+		{
+			name:   "defaults",
+			opts:   []dhcpv4.Modifier{dhcpv4.WithLeaseTime(800)},
+			t1:     400 * time.Second,
+			t2:     700 * time.Second,
+			expiry: 800 * time.Second,
+		},
+		{
+			name: "options",
+			opts: []dhcpv4.Modifier{
+				dhcpv4.WithLeaseTime(800),
+				dhcpv4.WithGeneric(dhcpv4.OptionRenewTimeValue, dhcpv4.Duration(100*time.Second).ToBytes()),
+				dhcpv4.WithGeneric(dhcpv4.OptionRebindingTimeValue, dhcpv4.Duration(200*time.Second).ToBytes()),
+			},
+			t1:     100 * time.Second,
+			t2:     200 * time.Second,
+			expiry: 800 * time.Second,
+		},
+		{
+			name: "infinite",
+			opts: []dhcpv4.Modifier{dhcpv4.WithLeaseTime(0xffffffff)},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &Lease4{Packet4: NewPacket4(nil, mustNew(t, tt.opts...)), Acquired: now}
+			for _, c := range []struct {
+				name string
+				got  time.Time
+				want time.Duration
+			}{
+				{"T1", l.T1(), tt.t1},
+				{"T2", l.T2(), tt.t2},
+				{"Expiry", l.Expiry(), tt.expiry},
+			} {
+				if c.want == 0 && !c.got.IsZero() {
+					t.Errorf("%s = %v, want zero time", c.name, c.got)
+				} else if c.want != 0 && !c.got.Equal(now.Add(c.want)) {
+					t.Errorf("%s = %v, want %v", c.name, c.got.Sub(now), c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestLeaseManager4Renew(t *testing.T) {
+	s := newTestServer(t, 2*time.Second)
+	defer s.Close()
+	m, bound, unbound := testManager(t, s)
+
+	cancel, done := run(m)
+	l := receive(t, bound, "lease")
+	if !l.P.YourIPAddr.Equal(testIP) {
+		t.Errorf("got lease for %v, want %v", l.P.YourIPAddr, testIP)
+	}
+	// The lease is renewed at T1, after a second.
+	receive(t, bound, "renewed lease")
+	cancel()
+	<-done
+	receive(t, unbound, "released lease")
+	s.waitFor(dhcpv4.MessageTypeRelease)
+
+	msgs := s.messages()
+	var renew, release *received
+	for i, r := range msgs {
+		switch {
+		case r.typ == dhcpv4.MessageTypeRequest && r.ciaddr.Equal(testIP) && renew == nil:
+			renew = &msgs[i]
+		case r.typ == dhcpv4.MessageTypeRelease:
+			release = &msgs[i]
+		}
+	}
+	if renew == nil || renew.broadcast {
+		t.Errorf("no unicast renewal in %+v", msgs)
+	}
+	if release == nil || release.broadcast || !release.ciaddr.Equal(testIP) {
+		t.Errorf("no unicast release in %+v", msgs)
+	}
+}
+
+func TestLeaseManager4Rebind(t *testing.T) {
+	s := newTestServer(t, 2*time.Second)
+	defer s.Close()
+	// The server that granted the lease has gone away.
+	s.setAnswer(func(req *dhcpv4.DHCPv4, broadcast bool) dhcpv4.MessageType {
+		if !broadcast {
+			return dhcpv4.MessageTypeNone
+		}
+		return dhcpv4.MessageTypeAck
+	})
+	m, bound, _ := testManager(t, s)
+	m.Release = false
+
+	cancel, done := run(m)
+	defer func() {
+		cancel()
+		<-done
+	}()
+	first := receive(t, bound, "lease")
+	second := receive(t, bound, "rebound lease")
+	if t2 := first.T2(); second.Acquired.Before(t2) {
+		t.Errorf("lease rebound at %v, before T2 at %v", second.Acquired, t2)
+	}
+}
+
+func TestLeaseManager4Nak(t *testing.T) {
+	s := newTestServer(t, 2*time.Second)
+	defer s.Close()
+	m, bound, unbound := testManager(t, s)
+	m.Release = false
+
+	cancel, done := run(m)
+	defer func() {
+		cancel()
+		<-done
+	}()
+	receive(t, bound, "lease")
+	s.setAnswer(func(req *dhcpv4.DHCPv4, broadcast bool) dhcpv4.MessageType {
+		if req.ClientIPAddr.Equal(testIP) {
+			return dhcpv4.MessageTypeNak
+		}
+		return dhcpv4.MessageTypeAck
+	})
+	receive(t, unbound, "refused lease")
+	receive(t, bound, "new lease")
+
+	var discovers int
+	for _, r := range s.messages() {
+		if r.typ == dhcpv4.MessageTypeDiscover {
+			discovers++
+		}
+	}
+	if discovers != 2 {
+		t.Errorf("got %d DISCOVERs, want 2", discovers)
+	}
+}
+
+func TestLeaseManager4InitReboot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dhclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := newTestServer(t, time.Hour)
+	defer s.Close()
+	m, bound, _ := testManager(t, s)
+	m.LeaseFile = filepath.Join(dir, "test0.lease")
+
+	ack := mustNew(t,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeAck),
+		dhcpv4.WithYourIP(testIP),
+		dhcpv4.WithLeaseTime(3600),
+	)
+	saved := &Lease4{Packet4: NewPacket4(m.Iface, ack), Acquired: time.Now()}
+	if err := WriteLease4(m.LeaseFile, saved); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel, done := run(m)
+	receive(t, bound, "lease")
+	if _, err := ReadLease4(m.LeaseFile, m.Iface); err != nil {
+		t.Errorf("lease was not saved: %v", err)
+	}
+	cancel()
+	<-done
+
+	msgs := s.messages()
+	if len(msgs) == 0 || msgs[0].typ != dhcpv4.MessageTypeRequest || !msgs[0].requested.Equal(testIP) {
+		t.Errorf("first message is not a REQUEST for %v: %+v", testIP, msgs)
+	}
+	if _, err := os.Stat(m.LeaseFile); !os.IsNotExist(err) {
+		t.Errorf("lease file still exists after release: %v", err)
+	}
+}
+
+func TestLeaseManager4InitRebootUnanswered(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dhclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := newTestServer(t, time.Hour)
+	defer s.Close()
+	// Only answer REQUESTs that follow a DISCOVER, as a server on
+	// another network would.
+	s.setAnswer(func(*dhcpv4.DHCPv4, bool) dhcpv4.MessageType {
+		for _, r := range s.messages() {
+			if r.typ == dhcpv4.MessageTypeDiscover {
+				return dhcpv4.MessageTypeAck
+			}
+		}
+		return dhcpv4.MessageTypeNone
+	})
+	m, bound, _ := testManager(t, s)
+	m.LeaseFile = filepath.Join(dir, "test0.lease")
+
+	ack := mustNew(t,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeAck),
+		dhcpv4.WithYourIP(testIP),
+		dhcpv4.WithLeaseTime(3600),
+	)
+	saved := &Lease4{Packet4: NewPacket4(m.Iface, ack), Acquired: time.Now()}
+	if err := WriteLease4(m.LeaseFile, saved); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel, done := run(m)
+	receive(t, bound, "lease")
+	cancel()
+	<-done
+
+	if msgs := s.messages(); len(msgs) == 0 || msgs[0].typ != dhcpv4.MessageTypeRequest {
+		t.Errorf("first message is not a REQUEST for the saved lease: %+v", msgs)
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhclient
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/insomniacslk/dhcp/rfc1035label"
+)
+
+// ICMPv6 message types used by Neighbor Discovery (RFC 4861).
+const (
+	icmpRouterSolicitation  = 133
+	icmpRouterAdvertisement = 134
+)
+
+// Neighbor Discovery option types (RFC 4861, RFC 8106).
+const (
+	ndpOptSourceLinkAddr = 1
+	ndpOptPrefixInfo     = 3
+	ndpOptMTU            = 5
+	ndpOptRDNSS          = 25
+	ndpOptDNSSL          = 31
+)
+
+// InfiniteLifetime is the lifetime of a prefix or DNS option that never
+// expires.
+const InfiniteLifetime = 0xffffffff
+
+// PrefixInfo is a Prefix Information option of a router advertisement.
+type PrefixInfo struct {
+	Prefix *net.IPNet
+
+	// OnLink means addresses in the prefix are reachable without going
+	// through the router.
+	OnLink bool
+
+	// Autonomous means hosts may configure addresses in the prefix
+	// themselves.
+	Autonomous bool
+
+	// ValidLifetime and PreferredLifetime are in seconds.
+	ValidLifetime     uint32
+	PreferredLifetime uint32
+}
+
+// RouterAdvertisement is an ICMPv6 router advertisement (RFC 4861).
+//
+// Lifetimes are in seconds and times are in milliseconds, as on the wire.
+type RouterAdvertisement struct {
+	HopLimit uint8
+
+	// Managed is the M flag: addresses are assigned by DHCPv6.
+	Managed bool
+
+	// Other is the O flag: other configuration, such as DNS servers, is
+	// available from DHCPv6 information-requests.
+	Other bool
+
+	// RouterLifetime is how long the router may be used as a default
+	// router. Zero means it is not a default router.
+	RouterLifetime uint16
+	ReachableTime  uint32
+	RetransTimer   uint32
+
+	SourceLinkAddr net.HardwareAddr
+	MTU            uint32
+	Prefixes       []PrefixInfo
+
+	// RDNSS and DNSSL are the recursive DNS servers and search domains
+	// of RFC 8106. Entries with a zero lifetime are left out.
+	RDNSS []net.IP
+	DNSSL []string
+}
+
+// ParseRouterAdvertisement parses the ICMPv6 message b.
+func ParseRouterAdvertisement(b []byte) (*RouterAdvertisement, error) {
+	if len(b) < 16 {
+		return nil, fmt.Errorf("router advertisement too short: %d bytes", len(b))
+	}
+	if b[0] != icmpRouterAdvertisement || b[1] != 0 {
+		return nil, fmt.Errorf("ICMPv6 type %d code %d is not a router advertisement", b[0], b[1])
+	}
+	ra := &RouterAdvertisement{
+		HopLimit:       b[4],
+		Managed:        b[5]&0x80 != 0,
+		Other:          b[5]&0x40 != 0,
+		RouterLifetime: binary.BigEndian.Uint16(b[6:8]),
+		ReachableTime:  binary.BigEndian.Uint32(b[8:12]),
+		RetransTimer:   binary.BigEndian.Uint32(b[12:16]),
+	}
+
+	for opts := b[16:]; len(opts) > 0; {
+		if len(opts) < 2 {
+			return nil, fmt.Errorf("truncated option")
+		}
+		l := int(opts[1]) * 8
+		if l == 0 || l > len(opts) {
+			return nil, fmt.Errorf("option %d has bad length %d", opts[0], l)
+		}
+		if err := ra.parseOption(opts[0], opts[2:l]); err != nil {
+			return nil, err
+		}
+		opts = opts[l:]
+	}
+	return ra, nil
+}
+
+// parseOption parses the body of an option, which follows its type and
+// length.
+func (ra *RouterAdvertisement) parseOption(typ uint8, b []byte) error {
+	switch typ {
+	case ndpOptSourceLinkAddr:
+		if len(b) >= 6 {
+			ra.SourceLinkAddr = net.HardwareAddr(append([]byte(nil), b[:6]...))
+		}
+
+	case ndpOptPrefixInfo:
+		if len(b) != 30 {
+			return fmt.Errorf("prefix information option has length %d", len(b)+2)
+		}
+		bits := int(b[0])
+		if bits > 128 {
+			return fmt.Errorf("prefix length %d", bits)
+		}
+		mask := net.CIDRMask(bits, 128)
+		prefix := make(net.IP, net.IPv6len)
+		copy(prefix, b[14:30])
+		ra.Prefixes = append(ra.Prefixes, PrefixInfo{
+			Prefix:            &net.IPNet{IP: prefix.Mask(mask), Mask: mask},
+			OnLink:            b[1]&0x80 != 0,
+			Autonomous:        b[1]&0x40 != 0,
+			ValidLifetime:     binary.BigEndian.Uint32(b[2:6]),
+			PreferredLifetime: binary.BigEndian.Uint32(b[6:10]),
+		})
+
+	case ndpOptMTU:
+		ra.MTU = binary.BigEndian.Uint32(b[2:6])
+
+	case ndpOptRDNSS:
+		if len(b) < 22 || (len(b)-6)%16 != 0 {
+			return fmt.Errorf("RDNSS option has length %d", len(b)+2)
+		}
+		if binary.BigEndian.Uint32(b[2:6]) == 0 {
+			return nil
+		}
+		for a := b[6:]; len(a) > 0; a = a[16:] {
+			ra.RDNSS = append(ra.RDNSS, net.IP(append([]byte(nil), a[:16]...)))
+		}
+
+	case ndpOptDNSSL:
+		if len(b) < 6 {
+			return fmt.Errorf("DNSSL option has length %d", len(b)+2)
+		}
+		if binary.BigEndian.Uint32(b[2:6]) == 0 {
+			return nil
+		}
+		labels, err := rfc1035label.FromBytes(b[6:])
+		if err != nil {
+			return fmt.Errorf("DNSSL option: %v", err)
+		}
+		// The option is padded with zeros, which read as empty names.
+		for _, d := range labels.Labels {
+			if d != "" {
+				ra.DNSSL = append(ra.DNSSL, d)
+			}
+		}
+	}
+	return nil
+}
+
+// routerSolicitation returns an ICMPv6 router solicitation carrying the
+// link-layer address mac. The kernel fills in the checksum.
+func routerSolicitation(mac net.HardwareAddr) []byte {
+	b := []byte{icmpRouterSolicitation, 0, 0, 0, 0, 0, 0, 0}
+	if len(mac) == 6 {
+		b = append(b, ndpOptSourceLinkAddr, 1)
+		b = append(b, mac...)
+	}
+	return b
+}
+
+// eui64Address returns the address in the /64 prefix whose interface
+// identifier is the modified EUI-64 form of mac (RFC 4291, Appendix A).
+func eui64Address(prefix net.IP, mac net.HardwareAddr) (net.IP, error) {
+	if len(mac) != 6 {
+		return nil, fmt.Errorf("cannot derive an EUI-64 interface identifier from %q", mac)
+	}
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, prefix.To16()[:8])
+	ip[8] = mac[0] ^ 0x02
+	ip[9] = mac[1]
+	ip[10] = mac[2]
+	ip[11] = 0xff
+	ip[12] = 0xfe
+	copy(ip[13:], mac[3:])
+	return ip, nil
+}
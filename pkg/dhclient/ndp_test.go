@@ -0,0 +1,209 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhclient
+
+import (
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/rfc1035label"
+	"github.com/vishvananda/netlink"
+)
+
+// ndpOption returns an NDP option of type typ with body b, padded to a
+// multiple of 8 bytes.
+func ndpOption(typ uint8, b []byte) []byte {
+	o := append([]byte{typ, 0}, b...)
+	for len(o)%8 != 0 {
+		o = append(o, 0)
+	}
+	o[1] = uint8(len(o) / 8)
+	return o
+}
+
+func prefixOption(prefix string, flags uint8, valid, preferred uint32) []byte {
+	_, n, err := net.ParseCIDR(prefix)
+	if err != nil {
+		panic(err)
+	}
+	ones, _ := n.Mask.Size()
+	b := make([]byte, 30)
+	b[0] = uint8(ones)
+	b[1] = flags
+	binary.BigEndian.PutUint32(b[2:], valid)
+	binary.BigEndian.PutUint32(b[6:], preferred)
+	copy(b[14:], n.IP)
+	return ndpOption(ndpOptPrefixInfo, b)
+}
+
+func lifetimeOption(typ uint8, lifetime uint32, data []byte) []byte {
+	b := make([]byte, 6)
+	binary.BigEndian.PutUint32(b[2:], lifetime)
+	return ndpOption(typ, append(b, data...))
+}
+
+func testRA(flags uint8, opts ...[]byte) []byte {
+	b := []byte{icmpRouterAdvertisement, 0, 0, 0, 64, flags, 0x07, 0x08, 0, 0, 0x75, 0x30, 0, 0, 0x03, 0xe8}
+	for _, o := range opts {
+		b = append(b, o...)
+	}
+	return b
+}
+
+func TestParseRouterAdvertisement(t *testing.T) {
+	mac := net.HardwareAddr{0x52, 0x54, 0, 0x12, 0x34, 0x56}
+	mtu := make([]byte, 6)
+	binary.BigEndian.PutUint32(mtu[2:], 1480)
+	dns := append(net.ParseIP("2001:db8::53"), net.ParseIP("2001:db8::54")...)
+	search := (&rfc1035label.Labels{Labels: []string{"example.com", "corp.example.com"}}).ToBytes()
+
+	b := testRA(0x40,
+		ndpOption(ndpOptSourceLinkAddr, mac),
+		prefixOption("2001:db8:1::/64", 0xc0, 86400, 14400),
+		prefixOption("2001:db8:2::/48", 0x80, InfiniteLifetime, InfiniteLifetime),
+		ndpOption(ndpOptMTU, mtu),
+		lifetimeOption(ndpOptRDNSS, 600, dns),
+		// Expired servers are ignored.
+		lifetimeOption(ndpOptRDNSS, 0, net.ParseIP("2001:db8::99")),
+		lifetimeOption(ndpOptDNSSL, 600, search),
+		// Unknown options are skipped.
+		ndpOption(200, []byte{1, 2, 3}),
+	)
+	got, err := ParseRouterAdvertisement(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &RouterAdvertisement{
+		HopLimit:       64,
+		Other:          true,
+		RouterLifetime: 1800,
+		ReachableTime:  30000,
+		RetransTimer:   1000,
+		SourceLinkAddr: mac,
+		MTU:            1480,
+		Prefixes: []PrefixInfo{
+			{
+				Prefix:            &net.IPNet{IP: net.ParseIP("2001:db8:1::"), Mask: net.CIDRMask(64, 128)},
+				OnLink:            true,
+				Autonomous:        true,
+				ValidLifetime:     86400,
+				PreferredLifetime: 14400,
+			},
+			{
+				Prefix:            &net.IPNet{IP: net.ParseIP("2001:db8:2::"), Mask: net.CIDRMask(48, 128)},
+				OnLink:            true,
+				ValidLifetime:     InfiniteLifetime,
+				PreferredLifetime: InfiniteLifetime,
+			},
+		},
+		RDNSS: []net.IP{net.ParseIP("2001:db8::53"), net.ParseIP("2001:db8::54")},
+		DNSSL: []string{"example.com", "corp.example.com"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseRouterAdvertisement() = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseRouterAdvertisementErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		b    []byte
+	}{
+		{"short", testRA(0)[:15]},
+		{"solicitation", routerSolicitation(nil)},
+		{"zero length option", append(testRA(0), ndpOptMTU, 0, 0, 0, 0, 0, 0, 0)},
+		{"truncated option", append(testRA(0), ndpOptMTU, 2, 0, 0, 0, 0, 0, 0)},
+		{"short prefix option", testRA(0, ndpOption(ndpOptPrefixInfo, make([]byte, 6)))},
+		{"bad RDNSS option", testRA(0, lifetimeOption(ndpOptRDNSS, 600, nil))},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseRouterAdvertisement(tt.b); err == nil {
+				t.Errorf("ParseRouterAdvertisement(%x) succeeded, want error", tt.b)
+			}
+		})
+	}
+}
+
+func TestRouterSolicitation(t *testing.T) {
+	mac := net.HardwareAddr{0x52, 0x54, 0, 0x12, 0x34, 0x56}
+	want := []byte{133, 0, 0, 0, 0, 0, 0, 0, 1, 1, 0x52, 0x54, 0, 0x12, 0x34, 0x56}
+	if got := routerSolicitation(mac); !reflect.DeepEqual(got, want) {
+		t.Errorf("routerSolicitation(%s) = %x, want %x", mac, got, want)
+	}
+}
+
+func TestEUI64Address(t *testing.T) {
+	mac := net.HardwareAddr{0x52, 0x54, 0, 0x12, 0x34, 0x56}
+	got, err := eui64Address(net.ParseIP("2001:db8:1::"), mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := net.ParseIP("2001:db8:1::5054:ff:fe12:3456"); !got.Equal(want) {
+		t.Errorf("eui64Address() = %s, want %s", got, want)
+	}
+	if _, err := eui64Address(net.ParseIP("2001:db8:1::"), nil); err == nil {
+		t.Errorf("eui64Address() without a MAC succeeded, want error")
+	}
+}
+
+func TestRALease(t *testing.T) {
+	iface := &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "test0", HardwareAddr: testHWAddr}}
+	ra := &RouterAdvertisement{
+		Other: true,
+		RDNSS: []net.IP{net.ParseIP("2001:db8::53")},
+		DNSSL: []string{"example.com"},
+	}
+
+	l := NewRALease(iface, net.ParseIP("fe80::1"), ra, nil)
+	if _, err := l.Boot(); err == nil {
+		t.Errorf("Boot() without DHCPv6 succeeded, want error")
+	}
+
+	info, err := dhcpv6.NewMessage(
+		dhcpv6.WithDNS(net.ParseIP("2001:db8::54")),
+		dhcpv6.WithDomainSearchList("corp.example.com"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info.AddOption(&dhcpv6.OptBootFileURL{BootFileURL: []byte("http://[2001:db8::80]/boot.ipxe")})
+	l = NewRALease(iface, net.ParseIP("fe80::1"), ra, info)
+
+	if got, want := l.DNS(), []net.IP{net.ParseIP("2001:db8::53"), net.ParseIP("2001:db8::54")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("DNS() = %v, want %v", got, want)
+	}
+	if got, want := l.SearchDomains(), []string{"example.com", "corp.example.com"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SearchDomains() = %v, want %v", got, want)
+	}
+	u, err := l.Boot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := u.String(), "http://[2001:db8::80]/boot.ipxe"; got != want {
+		t.Errorf("Boot() = %s, want %s", got, want)
+	}
+}
+
+func TestInformationRequest(t *testing.T) {
+	m, err := newInformationRequest(testHWAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.MessageType != dhcpv6.MessageTypeInformationRequest {
+		t.Errorf("message type = %s, want INFORMATION-REQUEST", m.MessageType)
+	}
+	for _, o := range []dhcpv6.OptionCode{dhcpv6.OptionDNSRecursiveNameServer, dhcpv6.OptionDomainSearchList, dhcpv6.OptionBootfileURL} {
+		if !m.IsOptionRequested(o) {
+			t.Errorf("option %s not requested", o)
+		}
+	}
+	// Information-requests do not ask for addresses.
+	if m.GetOneOption(dhcpv6.OptionIANA) != nil {
+		t.Errorf("information-request has an IA_NA option")
+	}
+}
@@ -0,0 +1,449 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhclient
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/dhcpv6/nclient6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// dadTimeout bounds the wait for Duplicate Address Detection, which
+	// takes about a second with the kernel's defaults.
+	dadTimeout = 5 * time.Second
+
+	// dadAttempts is how many addresses are tried in a prefix before
+	// giving up on it.
+	dadAttempts = 3
+
+	// maxRtrSolicitations and rtrSolicitationInterval limit router
+	// solicitation as RFC 4861, Section 10 does, independently of the
+	// DHCP timeouts, so that a link without routers leaves time for
+	// DHCPv6.
+	maxRtrSolicitations     = 3
+	rtrSolicitationInterval = 4 * time.Second
+)
+
+var allRouters = net.ParseIP("ff02::2")
+
+// ndpConn is a raw ICMPv6 socket bound to one interface.
+type ndpConn struct {
+	fd    int
+	iface netlink.Link
+}
+
+func newNDPConn(iface netlink.Link) (*ndpConn, error) {
+	fd, err := unix.Socket(unix.AF_INET6, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.IPPROTO_ICMPV6)
+	if err != nil {
+		return nil, fmt.Errorf("ICMPv6 socket: %v", err)
+	}
+	c := &ndpConn{fd: fd, iface: iface}
+	for _, o := range []struct {
+		level, opt, value int
+	}{
+		// Neighbor Discovery messages must have a hop limit of 255,
+		// which proves they come from the link.
+		{unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_HOPS, 255},
+		{unix.IPPROTO_IPV6, unix.IPV6_UNICAST_HOPS, 255},
+		{unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_IF, iface.Attrs().Index},
+		{unix.IPPROTO_IPV6, unix.IPV6_RECVHOPLIMIT, 1},
+	} {
+		if err := unix.SetsockoptInt(fd, o.level, o.opt, o.value); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("ICMPv6 socket option %d: %v", o.opt, err)
+		}
+	}
+	if err := unix.SetsockoptString(fd, unix.SOL_SOCKET, unix.SO_BINDTODEVICE, iface.Attrs().Name); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("binding ICMPv6 socket to %s: %v", iface.Attrs().Name, err)
+	}
+	return c, nil
+}
+
+func (c *ndpConn) Close() error {
+	return unix.Close(c.fd)
+}
+
+func (c *ndpConn) solicit() error {
+	to := &unix.SockaddrInet6{ZoneId: uint32(c.iface.Attrs().Index)}
+	copy(to.Addr[:], allRouters)
+	return unix.Sendto(c.fd, routerSolicitation(c.iface.Attrs().HardwareAddr), 0, to)
+}
+
+// readAdvertisement returns the first valid router advertisement received
+// before deadline, and the router that sent it.
+func (c *ndpConn) readAdvertisement(ctx context.Context, deadline time.Time) (*RouterAdvertisement, net.IP, error) {
+	b := make([]byte, 1500)
+	oob := make([]byte, 64)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return nil, nil, errNoAdvertisement
+		}
+		// Wake up now and then to notice ctx being cancelled.
+		if wait > 100*time.Millisecond {
+			wait = 100 * time.Millisecond
+		}
+		tv := unix.NsecToTimeval(wait.Nanoseconds())
+		if err := unix.SetsockoptTimeval(c.fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+			return nil, nil, err
+		}
+		n, oobn, _, from, err := unix.Recvmsg(c.fd, b, oob, 0)
+		if err == unix.EAGAIN || err == unix.EINTR {
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+		sa, ok := from.(*unix.SockaddrInet6)
+		if !ok || n == 0 || b[0] != icmpRouterAdvertisement {
+			continue
+		}
+		// RFC 4861, Section 6.1.2: routers advertise from their
+		// link-local address with a hop limit of 255.
+		router := net.IP(append([]byte(nil), sa.Addr[:]...))
+		if !router.IsLinkLocalUnicast() || hopLimit(oob[:oobn]) != 255 {
+			continue
+		}
+		ra, err := ParseRouterAdvertisement(b[:n])
+		if err != nil {
+			log.Printf("Ignoring router advertisement from %s: %v", router, err)
+			continue
+		}
+		return ra, router, nil
+	}
+}
+
+// hopLimit returns the hop limit from an IPV6_HOPLIMIT control message,
+// which is a native int, or -1.
+func hopLimit(oob []byte) int {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return -1
+	}
+	for _, m := range msgs {
+		if m.Header.Level == unix.IPPROTO_IPV6 && m.Header.Type == unix.IPV6_HOPLIMIT && len(m.Data) >= 4 {
+			return int(*(*int32)(unsafe.Pointer(&m.Data[0])))
+		}
+	}
+	return -1
+}
+
+var errNoAdvertisement = errors.New("no router advertisement received")
+
+// solicitRouter sends router solicitations on iface until a router
+// answers, at most maxRtrSolicitations times.
+func solicitRouter(ctx context.Context, iface netlink.Link) (*RouterAdvertisement, net.IP, error) {
+	c, err := newNDPConn(iface)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer c.Close()
+
+	for i := 0; i < maxRtrSolicitations; i++ {
+		if err := c.solicit(); err != nil {
+			return nil, nil, fmt.Errorf("sending router solicitation: %v", err)
+		}
+		ra, router, err := c.readAdvertisement(ctx, time.Now().Add(rtrSolicitationInterval))
+		if err != errNoAdvertisement {
+			return ra, router, err
+		}
+	}
+	return nil, nil, errNoAdvertisement
+}
+
+// newInformationRequest returns a DHCPv6 information-request asking for
+// DNS and netboot options.
+func newInformationRequest(hwaddr net.HardwareAddr) (*dhcpv6.Message, error) {
+	m, err := dhcpv6.NewMessage()
+	if err != nil {
+		return nil, err
+	}
+	m.MessageType = dhcpv6.MessageTypeInformationRequest
+	m.AddOption(&dhcpv6.OptClientId{Cid: dhcpv6.Duid{
+		Type:          dhcpv6.DUID_LLT,
+		HwType:        iana.HWTypeEthernet,
+		Time:          dhcpv6.GetTime(),
+		LinkLayerAddr: hwaddr,
+	}})
+	oro := new(dhcpv6.OptRequestedOption)
+	oro.SetRequestedOptions([]dhcpv6.OptionCode{
+		dhcpv6.OptionDNSRecursiveNameServer,
+		dhcpv6.OptionDomainSearchList,
+	})
+	m.AddOption(oro)
+	m.AddOption(&dhcpv6.OptElapsedTime{})
+	dhcpv6.WithNetboot(m)
+	return m, nil
+}
+
+// informationRequest asks DHCPv6 servers for configuration other than
+// addresses, as routers with the O flag set tell hosts to (RFC 8415,
+// Section 18.2.6).
+func informationRequest(ctx context.Context, iface netlink.Link, timeout time.Duration, retries int) (*dhcpv6.Message, error) {
+	client, err := nclient6.New(iface.Attrs().Name,
+		nclient6.WithTimeout(timeout),
+		nclient6.WithRetry(retries))
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	msg, err := newInformationRequest(iface.Attrs().HardwareAddr)
+	if err != nil {
+		return nil, err
+	}
+	return client.SendAndRead(ctx, nclient6.AllDHCPRelayAgentsAndServers, msg, nclient6.IsMessageType(dhcpv6.MessageTypeReply))
+}
+
+// RALease is IPv6 configuration learned without stateful DHCPv6: addresses
+// are generated from the prefixes in a router advertisement (SLAAC, RFC
+// 4862), the router is the default route, and DNS servers come from the
+// advertisement and, if the router set the O flag, from a DHCPv6
+// information-request.
+type RALease struct {
+	iface netlink.Link
+
+	// Router is the link-local address of the advertising router.
+	Router net.IP
+
+	// RA is the router advertisement.
+	RA *RouterAdvertisement
+
+	// Info is the reply to the information-request, if any.
+	Info *dhcpv6.Message
+}
+
+var _ Lease = &RALease{}
+
+// NewRALease returns the lease described by ra from router and the DHCPv6
+// reply info, which may be nil.
+func NewRALease(iface netlink.Link, router net.IP, ra *RouterAdvertisement, info *dhcpv6.Message) *RALease {
+	return &RALease{
+		iface:  iface,
+		Router: router,
+		RA:     ra,
+		Info:   info,
+	}
+}
+
+func (l *RALease) Link() netlink.Link {
+	return l.iface
+}
+
+func (l *RALease) String() string {
+	var prefixes []string
+	for _, p := range l.RA.Prefixes {
+		if p.Autonomous {
+			prefixes = append(prefixes, p.Prefix.String())
+		}
+	}
+	return fmt.Sprintf("IPv6 SLAAC Lease from %s prefixes %v", l.Router, prefixes)
+}
+
+// DNS returns the DNS servers from the router advertisement followed by
+// those from DHCPv6.
+func (l *RALease) DNS() []net.IP {
+	ips := append([]net.IP(nil), l.RA.RDNSS...)
+	if l.Info != nil {
+		ips = append(ips, NewPacket6(l.iface, l.Info).DNS()...)
+	}
+	return ips
+}
+
+// SearchDomains returns the DNS search domains from the router
+// advertisement followed by those from DHCPv6.
+func (l *RALease) SearchDomains() []string {
+	domains := append([]string(nil), l.RA.DNSSL...)
+	if l.Info != nil {
+		if o, ok := l.Info.GetOneOption(dhcpv6.OptionDomainSearchList).(*dhcpv6.OptDomainSearchList); ok && o.DomainSearchList != nil {
+			domains = append(domains, o.DomainSearchList.Labels...)
+		}
+	}
+	return domains
+}
+
+// Boot returns the boot file URL from the DHCPv6 information-request
+// reply.
+func (l *RALease) Boot() (*url.URL, error) {
+	if l.Info == nil {
+		return nil, fmt.Errorf("no DHCPv6 information to get a boot file URL from")
+	}
+	return bootFileURL(l.Info)
+}
+
+// Configure adds an address in each autonomous prefix, the routes and the
+// DNS servers to the system.
+func (l *RALease) Configure() error {
+	configured := 0
+	for _, p := range l.RA.Prefixes {
+		if !p.Autonomous || p.ValidLifetime == 0 {
+			continue
+		}
+		if ones, _ := p.Prefix.Mask.Size(); ones != 64 {
+			log.Printf("Not configuring an address in %s: SLAAC needs a /64", p.Prefix)
+			continue
+		}
+		addr, err := addSLAACAddress(l.iface, p)
+		if err != nil {
+			log.Printf("Not configuring an address in %s: %v", p.Prefix, err)
+			continue
+		}
+		log.Printf("Configured %s on %s", addr, l.iface.Attrs().Name)
+		configured++
+	}
+	if configured == 0 {
+		log.Printf("No SLAAC addresses configured on %s", l.iface.Attrs().Name)
+	}
+
+	if err := configureRouter(l.iface, l.Router, l.RA); err != nil {
+		return err
+	}
+	if ips := l.DNS(); ips != nil {
+		if err := WriteResolvConf(ips, l.SearchDomains()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addSLAACAddress adds an address in p to iface, waiting for Duplicate
+// Address Detection to pass. The first address tried is the EUI-64 one;
+// if it is taken, random interface identifiers are tried.
+func addSLAACAddress(iface netlink.Link, p PrefixInfo) (*net.IPNet, error) {
+	ip, err := eui64Address(p.Prefix.IP, iface.Attrs().HardwareAddr)
+	for i := 0; i < dadAttempts; i++ {
+		if err != nil {
+			if ip, err = randomAddress(p.Prefix.IP); err != nil {
+				return nil, err
+			}
+		}
+		addr := &netlink.Addr{
+			IPNet:       &net.IPNet{IP: ip, Mask: p.Prefix.Mask},
+			ValidLft:    int(p.ValidLifetime),
+			PreferedLft: int(p.PreferredLifetime),
+		}
+		if err = netlink.AddrReplace(iface, addr); err != nil {
+			return nil, fmt.Errorf("add/replace %s to %v: %v", addr, iface.Attrs().Name, err)
+		}
+		if err = waitDAD(iface, ip); err == nil {
+			return addr.IPNet, nil
+		}
+		log.Printf("Address %s: %v", ip, err)
+		netlink.AddrDel(iface, addr)
+	}
+	return nil, err
+}
+
+// randomAddress returns an address in the /64 prefix with a random
+// interface identifier.
+func randomAddress(prefix net.IP) (net.IP, error) {
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, prefix.To16()[:8])
+	if _, err := rand.Read(ip[8:]); err != nil {
+		return nil, err
+	}
+	// Clear the universal/local bit: the identifier is local.
+	ip[8] &^= 0x02
+	return ip, nil
+}
+
+// waitDAD waits for the kernel to finish Duplicate Address Detection of ip
+// on iface.
+func waitDAD(iface netlink.Link, ip net.IP) error {
+	for start := time.Now(); time.Since(start) < dadTimeout; time.Sleep(100 * time.Millisecond) {
+		addrs, err := netlink.AddrList(iface, netlink.FAMILY_V6)
+		if err != nil {
+			return err
+		}
+		var found bool
+		for _, a := range addrs {
+			if !a.IP.Equal(ip) {
+				continue
+			}
+			found = true
+			if a.Flags&unix.IFA_F_DADFAILED != 0 {
+				return fmt.Errorf("duplicate address detected")
+			}
+			if a.Flags&unix.IFA_F_TENTATIVE == 0 {
+				return nil
+			}
+		}
+		if !found {
+			return fmt.Errorf("address went away during duplicate address detection")
+		}
+	}
+	return fmt.Errorf("duplicate address detection did not finish in %v", dadTimeout)
+}
+
+// configureRouter applies the link parameters in ra and, if the router
+// offers to be one, adds it as the default route.
+func configureRouter(iface netlink.Link, router net.IP, ra *RouterAdvertisement) error {
+	name := iface.Attrs().Name
+	if ra.MTU != 0 {
+		if err := setIPv6Conf(name, "mtu", ra.MTU); err != nil {
+			return err
+		}
+	}
+	if ra.HopLimit != 0 {
+		if err := setIPv6Conf(name, "hop_limit", uint32(ra.HopLimit)); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range ra.Prefixes {
+		if !p.OnLink || p.ValidLifetime == 0 {
+			continue
+		}
+		r := &netlink.Route{
+			LinkIndex: iface.Attrs().Index,
+			Dst:       p.Prefix,
+		}
+		if err := netlink.RouteReplace(r); err != nil {
+			return fmt.Errorf("%s: add %s: %v", name, r, err)
+		}
+	}
+
+	if ra.RouterLifetime == 0 {
+		return nil
+	}
+	r := &netlink.Route{
+		LinkIndex: iface.Attrs().Index,
+		Dst:       &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)},
+		Gw:        router,
+		MTU:       int(ra.MTU),
+	}
+	if err := netlink.RouteReplace(r); err != nil {
+		return fmt.Errorf("%s: add %s: %v", name, r, err)
+	}
+	return nil
+}
+
+// setIPv6Conf sets an IPv6 sysctl of the interface name.
+func setIPv6Conf(name, key string, value uint32) error {
+	f := filepath.Join("/proc/sys/net/ipv6/conf", name, key)
+	if err := ioutil.WriteFile(f, []byte(fmt.Sprintf("%d\n", value)), 0644); err != nil {
+		return fmt.Errorf("setting %s: %v", strings.TrimPrefix(f, "/proc/sys/"), err)
+	}
+	return nil
+}
@@ -124,14 +124,18 @@ var (
 	}
 )
 
-// TODO: add iso handling along with iso_path variable replacement
-
 // FindConfigs searching the path for valid boot configuration files
 // and returns a Config for each valid instance found.
 func FindConfigs(mountPath string) []*Config {
+	return findConfigs(mountPath, locations, nil)
+}
+
+// findConfigs returns the configs at locs in mountPath. If replacer is not
+// nil, it is applied to the contents of grub configs.
+func findConfigs(mountPath string, locs []location, replacer *strings.Replacer) []*Config {
 	var configs []*Config
 
-	for _, location := range locations {
+	for _, location := range locs {
 		configPath := filepath.Join(mountPath, location.Path)
 		contents, err := ioutil.ReadFile(configPath)
 		if err != nil {
@@ -143,6 +147,9 @@ func FindConfigs(mountPath string) []*Config {
 		if location.Type == syslinux {
 			lines = loadSyslinuxLines(configPath, contents)
 		} else {
+			if replacer != nil {
+				contents = []byte(replacer.Replace(string(contents)))
+			}
 			lines = strings.Split(string(contents), "\n")
 		}
 
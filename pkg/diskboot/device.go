@@ -20,6 +20,21 @@ type Device struct {
 	MountPath string
 	Fstype    string
 	Configs   []*Config
+
+	// ISOPath is the path of an ISO image, as the booted kernel finds
+	// it, or empty.
+	ISOPath string
+
+	// unmount undoes the mount of an ISO image.
+	unmount func() error
+}
+
+// Unmount unmounts the device, and frees what mounting it took.
+func (d *Device) Unmount() error {
+	if d.unmount != nil {
+		return d.unmount()
+	}
+	return mount.Unmount(d.MountPath, true, false)
 }
 
 // fstypes returns all block file system supported by the linuxboot kernel
@@ -86,7 +101,12 @@ func mountDevice(devPath string, fstypes []string) (*Device, error) {
 			continue
 		}
 
-		return &Device{devPath, mountPath, fstype, configs}, nil
+		return &Device{
+			DevPath:   devPath,
+			MountPath: mountPath,
+			Fstype:    fstype,
+			Configs:   configs,
+		}, nil
 	}
 	return nil, fmt.Errorf("Failed to find a valid boot device with configs")
 }
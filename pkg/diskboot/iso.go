@@ -0,0 +1,156 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package diskboot
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/iso9660"
+	"github.com/u-root/u-root/pkg/loop"
+	"golang.org/x/sys/unix"
+)
+
+// isoLocations are the configs of ISO images that come before the others.
+// The grub loopback.cfg of an image boots it from a file, which it finds
+// by the iso_path variable.
+var isoLocations = append([]location{
+	{"boot/grub/loopback.cfg", grub},
+}, locations...)
+
+// FindISOConfigs returns the configs of the ISO image mounted at mountPath,
+// whose path for the booted kernel is isoPath.
+func FindISOConfigs(mountPath, isoPath string) []*Config {
+	r := strings.NewReplacer("${iso_path}", isoPath, "$iso_path", isoPath)
+	return findConfigs(mountPath, isoLocations, r)
+}
+
+// FindISO returns a device for the ISO9660 image file, with the configs
+// found in it. isoPath is the path of the image for the booted kernel.
+//
+// The image is loop mounted if the kernel can. If not, its configs and the
+// kernels and initrds they name are copied out to a directory.
+func FindISO(file, isoPath string) (*Device, error) {
+	mountPath, err := ioutil.TempDir("/tmp", "iso-")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create tmp mount directory: %v", err)
+	}
+	d := &Device{
+		DevPath:   file,
+		MountPath: mountPath,
+		Fstype:    "iso9660",
+		ISOPath:   isoPath,
+	}
+
+	l, err := loop.New(file, mountPath, "iso9660", unix.MS_RDONLY, "")
+	if err == nil {
+		if err = l.Mount(); err == nil {
+			d.unmount = func() error {
+				defer os.Remove(mountPath)
+				return l.Unmount(0)
+			}
+		} else {
+			l.Unmount(0)
+		}
+	}
+	if err != nil {
+		log.Printf("Loop mounting %s failed, copying its files out: %v", file, err)
+		d.unmount = func() error {
+			return os.RemoveAll(mountPath)
+		}
+		if err := extractISO(file, mountPath, isoPath); err != nil {
+			d.Unmount()
+			return nil, err
+		}
+	}
+
+	d.Configs = FindISOConfigs(mountPath, isoPath)
+	if len(d.Configs) == 0 {
+		d.Unmount()
+		return nil, fmt.Errorf("no boot configs found in %s", file)
+	}
+	return d, nil
+}
+
+// extractISO copies the configs of the ISO image file to dir, and then the
+// modules of their entries.
+func extractISO(file, dir, isoPath string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fs, err := iso9660.Open(f)
+	if err != nil {
+		return err
+	}
+
+	// Syslinux configs include others next to them.
+	seen := make(map[string]bool)
+	for _, l := range isoLocations {
+		d := path.Dir("/" + l.Path)
+		if seen[d] {
+			continue
+		}
+		seen[d] = true
+		files, err := fs.ReadDir(d)
+		if err != nil {
+			continue
+		}
+		for _, c := range files {
+			if !c.IsDir() && strings.HasSuffix(c.Name, ".cfg") {
+				if err := extractFile(fs, dir, path.Join(d, c.Name)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for _, c := range FindISOConfigs(dir, isoPath) {
+		for _, e := range c.Entries {
+			for _, m := range e.Modules {
+				// Entries that miss files are of no use, but
+				// others may be.
+				if err := extractFile(fs, dir, m.Path); err != nil {
+					log.Printf("Entry %q: %v", e.Name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// extractFile copies the file name of fs to the same path in dir, unless it
+// is already there. Names come from configs in the image, so they are made
+// absolute and cleaned first to stay inside dir.
+func extractFile(fs *iso9660.FS, dir, name string) error {
+	name = path.Clean("/" + name)
+	dst := filepath.Join(dir, name)
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	}
+	f, err := fs.Stat(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	w, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, io.NewSectionReader(f, 0, f.Size)); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,135 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package diskboot
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/iso9660"
+)
+
+// liveISO writes testdata/live.iso.gz, an image with grub, loopback.cfg
+// and isolinux configs, to a temporary file.
+func liveISO(t *testing.T) string {
+	f, err := os.Open("testdata/live.iso.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	z, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := ioutil.TempFile("", "live.iso")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if _, err := io.Copy(w, z); err != nil {
+		t.Fatal(err)
+	}
+	return w.Name()
+}
+
+func checkISOConfigs(t *testing.T, configs []*Config) {
+	var got []string
+	for _, c := range configs {
+		got = append(got, filepath.Base(c.ConfigPath))
+	}
+	if want := "loopback.cfg grub.cfg isolinux.cfg"; strings.Join(got, " ") != want {
+		t.Fatalf("configs = %v, want %v", got, want)
+	}
+	e := configs[0].Entries[0]
+	if e.Name != "Live from ISO" || len(e.Modules) != 2 {
+		t.Fatalf("entry = %v", e)
+	}
+	if m := e.Modules[0]; m.Path != "/casper/vmlinuz" || m.Params != "boot=casper iso-scan/filename=/isos/live.iso quiet" {
+		t.Errorf("kernel = %v", m)
+	}
+}
+
+func TestExtractISO(t *testing.T) {
+	iso := liveISO(t)
+	defer os.Remove(iso)
+	dir, err := ioutil.TempDir("", "iso")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := extractISO(iso, dir, "/isos/live.iso"); err != nil {
+		t.Fatal(err)
+	}
+	checkISOConfigs(t, FindISOConfigs(dir, "/isos/live.iso"))
+	for name, want := range map[string]string{
+		"casper/vmlinuz": "KERNEL",
+		"casper/initrd":  "INITRD",
+	} {
+		if b, err := ioutil.ReadFile(filepath.Join(dir, name)); err != nil || string(b) != want {
+			t.Errorf("%s = %q, %v, want %q", name, b, err, want)
+		}
+	}
+	// Only configs and what they boot are copied.
+	if _, err := os.Stat(filepath.Join(dir, "casper/big.bin")); !os.IsNotExist(err) {
+		t.Errorf("casper/big.bin was copied")
+	}
+}
+
+func TestExtractFileOutside(t *testing.T) {
+	iso := liveISO(t)
+	defer os.Remove(iso)
+	f, err := os.Open(iso)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fs, err := iso9660.Open(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent, err := ioutil.TempDir("", "iso")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(parent)
+	dir := filepath.Join(parent, "dir")
+
+	if err := extractFile(fs, dir, "../casper/vmlinuz"); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "casper/vmlinuz")); err != nil || string(b) != "KERNEL" {
+		t.Errorf("casper/vmlinuz = %q, %v, want KERNEL", b, err)
+	}
+	if _, err := os.Stat(filepath.Join(parent, "casper")); !os.IsNotExist(err) {
+		t.Errorf("../casper/vmlinuz was copied outside of %s", dir)
+	}
+}
+
+func TestFindISO(t *testing.T) {
+	iso := liveISO(t)
+	defer os.Remove(iso)
+
+	d, err := FindISO(iso, "/isos/live.iso")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkISOConfigs(t, d.Configs)
+	if err := d.Unmount(); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(d.MountPath); !os.IsNotExist(err) {
+		t.Errorf("%s is still there after Unmount", d.MountPath)
+	}
+
+	if _, err := FindISO("testdata/live.iso.gz", ""); err == nil {
+		t.Errorf("FindISO of a gzip file succeeded")
+	}
+}
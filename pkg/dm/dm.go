@@ -0,0 +1,198 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package dm provides an interface to the Linux device-mapper.
+//
+// A device-mapper device is a virtual block device whose sectors are mapped
+// onto other block devices by a table of targets (linear, crypt, verity, ...).
+// Devices are managed with ioctls on /dev/mapper/control.
+package dm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// SectorSize is the unit used for target offsets and lengths.
+const SectorSize = 512
+
+// Target is one line of a device-mapper table.
+type Target struct {
+	// Start is the first sector of the mapped device covered by this target.
+	Start uint64
+
+	// Length is the number of sectors covered by this target.
+	Length uint64
+
+	// Type is the target type, e.g. "linear", "crypt" or "verity".
+	Type string
+
+	// Params are the target-specific parameters.
+	Params string
+}
+
+// String formats the target the way dmsetup table does.
+func (t Target) String() string {
+	return fmt.Sprintf("%d %d %s %s", t.Start, t.Length, t.Type, t.Params)
+}
+
+// ParseTable parses a table in dmsetup format: one target per line, as
+// "start length type params...". Blank lines and lines starting with '#'
+// are ignored.
+func ParseTable(r io.Reader) ([]Target, error) {
+	var targets []Target
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		l := strings.TrimSpace(s.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		f := strings.Fields(l)
+		if len(f) < 3 {
+			return nil, fmt.Errorf("line %d: want start, length and type, got %q", n, l)
+		}
+		start, err := strconv.ParseUint(f[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad start: %v", n, err)
+		}
+		length, err := strconv.ParseUint(f[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad length: %v", n, err)
+		}
+		targets = append(targets, Target{
+			Start:  start,
+			Length: length,
+			Type:   f[2],
+			Params: strings.Join(f[3:], " "),
+		})
+	}
+	return targets, s.Err()
+}
+
+const (
+	ioctlSize = 312
+	nameLen   = 128
+	uuidLen   = 129
+	typeLen   = 16
+	specSize  = 40
+)
+
+// ioctlHeader is struct dm_ioctl from <linux/dm-ioctl.h>.
+type ioctlHeader struct {
+	Version     [3]uint32
+	DataSize    uint32
+	DataStart   uint32
+	TargetCount uint32
+	OpenCount   int32
+	Flags       uint32
+	EventNr     uint32
+	_           uint32
+	Dev         uint64
+	Name        [nameLen]byte
+	UUID        [uuidLen]byte
+	_           [7]byte
+}
+
+// targetSpec is struct dm_target_spec from <linux/dm-ioctl.h>.
+type targetSpec struct {
+	SectorStart uint64
+	Length      uint64
+	Status      int32
+	Next        uint32
+	TargetType  [typeLen]byte
+}
+
+// align8 rounds n up to a multiple of 8, the alignment the kernel requires
+// between target specs.
+func align8(n int) int {
+	return (n + 7) &^ 7
+}
+
+// marshalTargets encodes targets the way DM_TABLE_LOAD expects them: each
+// dm_target_spec is followed by its NUL-terminated parameter string, and Next
+// is the offset of the following spec relative to the current one.
+func marshalTargets(targets []Target) ([]byte, error) {
+	var b bytes.Buffer
+	for _, t := range targets {
+		if len(t.Type) >= typeLen {
+			return nil, fmt.Errorf("target type %q too long", t.Type)
+		}
+		spec := targetSpec{
+			SectorStart: t.Start,
+			Length:      t.Length,
+			Next:        uint32(align8(specSize + len(t.Params) + 1)),
+		}
+		copy(spec.TargetType[:], t.Type)
+		if err := binary.Write(&b, binary.LittleEndian, spec); err != nil {
+			return nil, err
+		}
+		b.WriteString(t.Params)
+		b.Write(make([]byte, int(spec.Next)-specSize-len(t.Params)))
+	}
+	return b.Bytes(), nil
+}
+
+// unmarshalTargets decodes count target specs returned by DM_TABLE_STATUS.
+// Unlike for DM_TABLE_LOAD, Next is relative to the start of data.
+func unmarshalTargets(data []byte, count int) ([]Target, error) {
+	var targets []Target
+	off := 0
+	for i := 0; i < count; i++ {
+		if off+specSize > len(data) {
+			return nil, fmt.Errorf("target %d: short buffer", i)
+		}
+		var spec targetSpec
+		if err := binary.Read(bytes.NewReader(data[off:off+specSize]), binary.LittleEndian, &spec); err != nil {
+			return nil, err
+		}
+		end := int(spec.Next)
+		if end < off+specSize || end > len(data) {
+			end = len(data)
+		}
+		targets = append(targets, Target{
+			Start:  spec.SectorStart,
+			Length: spec.Length,
+			Type:   cstring(spec.TargetType[:]),
+			Params: cstring(data[off+specSize : end]),
+		})
+		off = int(spec.Next)
+	}
+	return targets, nil
+}
+
+// unmarshalNames decodes the struct dm_name_list entries returned by
+// DM_LIST_DEVICES. Next is relative to the current entry and 0 on the last.
+func unmarshalNames(data []byte) (map[string]uint64, error) {
+	names := make(map[string]uint64)
+	for off := 0; off+12 <= len(data); {
+		dev := binary.LittleEndian.Uint64(data[off:])
+		next := int(binary.LittleEndian.Uint32(data[off+8:]))
+		if dev == 0 && next == 0 {
+			// An empty list is a single zeroed entry.
+			break
+		}
+		end := len(data)
+		if next != 0 && off+next <= len(data) {
+			end = off + next
+		}
+		names[cstring(data[off+12:end])] = dev
+		if next == 0 {
+			break
+		}
+		off += next
+	}
+	return names, nil
+}
+
+func cstring(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
@@ -0,0 +1,291 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// ControlPath is the device-mapper control device.
+	ControlPath = "/dev/mapper/control"
+
+	// MapperDir is where device nodes for mapped devices are created.
+	MapperDir = "/dev/mapper"
+
+	// _IOWR(0xfd, nr, struct dm_ioctl)
+	_DM_IOCTL_BASE    = 3<<30 | ioctlSize<<16 | 0xfd<<8
+	_DM_VERSION       = _DM_IOCTL_BASE | 0
+	_DM_LIST_DEVICES  = _DM_IOCTL_BASE | 2
+	_DM_DEV_CREATE    = _DM_IOCTL_BASE | 3
+	_DM_DEV_REMOVE    = _DM_IOCTL_BASE | 4
+	_DM_DEV_SUSPEND   = _DM_IOCTL_BASE | 6
+	_DM_DEV_STATUS    = _DM_IOCTL_BASE | 7
+	_DM_TABLE_LOAD    = _DM_IOCTL_BASE | 9
+	_DM_TABLE_CLEAR   = _DM_IOCTL_BASE | 10
+	_DM_TABLE_STATUS  = _DM_IOCTL_BASE | 12
+	_DM_READONLY_FLAG = 1 << 0
+	_DM_SUSPEND_FLAG  = 1 << 1
+
+	_DM_STATUS_TABLE_FLAG     = 1 << 4
+	_DM_ACTIVE_PRESENT_FLAG   = 1 << 5
+	_DM_INACTIVE_PRESENT_FLAG = 1 << 6
+	_DM_BUFFER_FULL_FLAG      = 1 << 8
+
+	// Size of the buffer first handed to the kernel. Results that do not
+	// fit are retried with a bigger one.
+	defaultBufSize = 16 * 1024
+	maxBufSize     = 16 * 1024 * 1024
+)
+
+// ioctlVersion is the interface version we speak. 4.0.0 is understood by
+// every kernel that has device-mapper at all.
+var ioctlVersion = [3]uint32{4, 0, 0}
+
+// ioctl issues a device-mapper ioctl for the device called name, with
+// payload placed after the dm_ioctl header. It returns the header and data
+// area filled in by the kernel.
+func ioctl(cmd uintptr, name, uuid string, flags uint32, targetCount int, payload []byte) (*ioctlHeader, []byte, error) {
+	if len(name) >= nameLen {
+		return nil, nil, fmt.Errorf("device name %q too long", name)
+	}
+	if len(uuid) >= uuidLen {
+		return nil, nil, fmt.Errorf("device uuid %q too long", uuid)
+	}
+	ctl, err := os.OpenFile(ControlPath, os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer ctl.Close()
+
+	size := ioctlSize + len(payload)
+	if size < defaultBufSize {
+		size = defaultBufSize
+	}
+	for {
+		hdr := ioctlHeader{
+			Version:     ioctlVersion,
+			DataSize:    uint32(size),
+			DataStart:   ioctlSize,
+			TargetCount: uint32(targetCount),
+			Flags:       flags,
+		}
+		copy(hdr.Name[:], name)
+		copy(hdr.UUID[:], uuid)
+
+		var b bytes.Buffer
+		if err := binary.Write(&b, binary.LittleEndian, hdr); err != nil {
+			return nil, nil, err
+		}
+		b.Write(payload)
+		buf := make([]byte, size)
+		copy(buf, b.Bytes())
+
+		if _, _, errno := unix.Syscall(unix.SYS_IOCTL, ctl.Fd(), cmd, uintptr(unsafe.Pointer(&buf[0]))); errno != 0 {
+			return nil, nil, errno
+		}
+
+		var out ioctlHeader
+		if err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, &out); err != nil {
+			return nil, nil, err
+		}
+		if out.Flags&_DM_BUFFER_FULL_FLAG != 0 && size < maxBufSize {
+			size *= 2
+			continue
+		}
+		if out.DataStart > out.DataSize || int(out.DataSize) > len(buf) {
+			return nil, nil, fmt.Errorf("kernel returned bad data area %d-%d", out.DataStart, out.DataSize)
+		}
+		return &out, buf[out.DataStart:out.DataSize], nil
+	}
+}
+
+// CreateDevice creates an empty mapped device and returns its device number.
+func CreateDevice(name, uuid string) (uint64, error) {
+	hdr, _, err := ioctl(_DM_DEV_CREATE, name, uuid, 0, 0, nil)
+	if err != nil {
+		return 0, fmt.Errorf("creating %q: %v", name, err)
+	}
+	return hdr.Dev, nil
+}
+
+// LoadTable loads targets into the inactive table of the device called name.
+// The table takes effect on the next Resume.
+func LoadTable(name string, readOnly bool, targets []Target) error {
+	payload, err := marshalTargets(targets)
+	if err != nil {
+		return err
+	}
+	var flags uint32
+	if readOnly {
+		flags |= _DM_READONLY_FLAG
+	}
+	if _, _, err := ioctl(_DM_TABLE_LOAD, name, "", flags, len(targets), payload); err != nil {
+		return fmt.Errorf("loading table for %q: %v", name, err)
+	}
+	return nil
+}
+
+// Resume activates the inactive table of the device called name.
+func Resume(name string) error {
+	if _, _, err := ioctl(_DM_DEV_SUSPEND, name, "", 0, 0, nil); err != nil {
+		return fmt.Errorf("resuming %q: %v", name, err)
+	}
+	return nil
+}
+
+// Suspend stops I/O to the device called name.
+func Suspend(name string) error {
+	if _, _, err := ioctl(_DM_DEV_SUSPEND, name, "", _DM_SUSPEND_FLAG, 0, nil); err != nil {
+		return fmt.Errorf("suspending %q: %v", name, err)
+	}
+	return nil
+}
+
+// RemoveDevice removes the mapped device called name.
+func RemoveDevice(name string) error {
+	if _, _, err := ioctl(_DM_DEV_REMOVE, name, "", 0, 0, nil); err != nil {
+		return fmt.Errorf("removing %q: %v", name, err)
+	}
+	return nil
+}
+
+// ClearTable drops the inactive table of the device called name.
+func ClearTable(name string) error {
+	if _, _, err := ioctl(_DM_TABLE_CLEAR, name, "", 0, 0, nil); err != nil {
+		return fmt.Errorf("clearing table of %q: %v", name, err)
+	}
+	return nil
+}
+
+// Version returns the version of the kernel's device-mapper interface.
+func Version() ([3]uint32, error) {
+	hdr, _, err := ioctl(_DM_VERSION, "", "", 0, 0, nil)
+	if err != nil {
+		return [3]uint32{}, err
+	}
+	return hdr.Version, nil
+}
+
+// Info describes a mapped device.
+type Info struct {
+	Name          string
+	UUID          string
+	Dev           uint64
+	OpenCount     int
+	TargetCount   int
+	EventNr       uint32
+	ReadOnly      bool
+	Suspended     bool
+	ActiveTable   bool
+	InactiveTable bool
+}
+
+func infoFromHeader(hdr *ioctlHeader) *Info {
+	return &Info{
+		Name:          cstring(hdr.Name[:]),
+		UUID:          cstring(hdr.UUID[:]),
+		Dev:           hdr.Dev,
+		OpenCount:     int(hdr.OpenCount),
+		TargetCount:   int(hdr.TargetCount),
+		EventNr:       hdr.EventNr,
+		ReadOnly:      hdr.Flags&_DM_READONLY_FLAG != 0,
+		Suspended:     hdr.Flags&_DM_SUSPEND_FLAG != 0,
+		ActiveTable:   hdr.Flags&_DM_ACTIVE_PRESENT_FLAG != 0,
+		InactiveTable: hdr.Flags&_DM_INACTIVE_PRESENT_FLAG != 0,
+	}
+}
+
+// DeviceInfo returns information about the device called name.
+func DeviceInfo(name string) (*Info, error) {
+	hdr, _, err := ioctl(_DM_DEV_STATUS, name, "", 0, 0, nil)
+	if err != nil {
+		return nil, fmt.Errorf("status of %q: %v", name, err)
+	}
+	return infoFromHeader(hdr), nil
+}
+
+// Status returns the target status lines of the device called name, e.g.
+// "V" or "C" for a verity target.
+func Status(name string) ([]Target, error) {
+	return tableStatus(name, 0)
+}
+
+// Table returns the active table of the device called name.
+func Table(name string) ([]Target, error) {
+	return tableStatus(name, _DM_STATUS_TABLE_FLAG)
+}
+
+func tableStatus(name string, flags uint32) ([]Target, error) {
+	hdr, data, err := ioctl(_DM_TABLE_STATUS, name, "", flags, 0, nil)
+	if err != nil {
+		return nil, fmt.Errorf("table of %q: %v", name, err)
+	}
+	return unmarshalTargets(data, int(hdr.TargetCount))
+}
+
+// List returns the names and device numbers of all mapped devices.
+func List() (map[string]uint64, error) {
+	_, data, err := ioctl(_DM_LIST_DEVICES, "", "", 0, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalNames(data)
+}
+
+// Create creates a device called name, loads targets and activates it. A
+// block device node is created in MapperDir, since there is usually no udev
+// in the initramfs to do it for us, and its path is returned.
+func Create(name, uuid string, readOnly bool, targets []Target) (string, error) {
+	dev, err := CreateDevice(name, uuid)
+	if err != nil {
+		return "", err
+	}
+	if err := LoadTable(name, readOnly, targets); err != nil {
+		RemoveDevice(name)
+		return "", err
+	}
+	if err := Resume(name); err != nil {
+		RemoveDevice(name)
+		return "", err
+	}
+	path := filepath.Join(MapperDir, name)
+	if err := mknod(path, dev); err != nil {
+		RemoveDevice(name)
+		return "", err
+	}
+	return path, nil
+}
+
+// Remove removes the device called name and its node in MapperDir.
+func Remove(name string) error {
+	if err := RemoveDevice(name); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(MapperDir, name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// mknod creates a block device node for dev at path, replacing whatever
+// was there.
+func mknod(path string, dev uint64) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// The kernel reports dev in the same encoding unix.Mkdev produces.
+	return unix.Mknod(path, unix.S_IFBLK|0600, int(dev))
+}
@@ -0,0 +1,159 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestMarshalTargets(t *testing.T) {
+	targets := []Target{
+		{Start: 0, Length: 100, Type: "linear", Params: "/dev/sda 0"},
+		{Start: 100, Length: 28, Type: "zero"},
+	}
+	b, err := marshalTargets(targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	off := 0
+	for i, want := range targets {
+		var spec targetSpec
+		if err := binary.Read(bytes.NewReader(b[off:]), binary.LittleEndian, &spec); err != nil {
+			t.Fatalf("target %d: %v", i, err)
+		}
+		if spec.SectorStart != want.Start || spec.Length != want.Length {
+			t.Errorf("target %d: got %d+%d, want %d+%d", i, spec.SectorStart, spec.Length, want.Start, want.Length)
+		}
+		if spec.Next%8 != 0 {
+			t.Errorf("target %d: next %d is not 8-byte aligned", i, spec.Next)
+		}
+		typ := string(bytes.TrimRight(spec.TargetType[:], "\x00"))
+		if typ != want.Type {
+			t.Errorf("target %d: got type %q, want %q", i, typ, want.Type)
+		}
+		params := b[off+specSize : off+int(spec.Next)]
+		if p := string(bytes.TrimRight(params, "\x00")); p != want.Params {
+			t.Errorf("target %d: got params %q, want %q", i, p, want.Params)
+		}
+		off += int(spec.Next)
+	}
+	if off != len(b) {
+		t.Errorf("got %d bytes, consumed %d", len(b), off)
+	}
+}
+
+func TestMarshalTargetsLongType(t *testing.T) {
+	if _, err := marshalTargets([]Target{{Type: "averyveryverylongtype"}}); err == nil {
+		t.Errorf("marshalTargets with long type: got nil, want error")
+	}
+}
+
+func TestTargetString(t *testing.T) {
+	tg := Target{Start: 0, Length: 8, Type: "linear", Params: "/dev/loop0 0"}
+	if got, want := tg.String(), "0 8 linear /dev/loop0 0"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+// statusBuffer builds a DM_TABLE_STATUS result, where Next is the offset of
+// the following spec from the start of the buffer.
+func statusBuffer(t *testing.T, targets []Target) []byte {
+	var b bytes.Buffer
+	for _, tg := range targets {
+		spec := targetSpec{SectorStart: tg.Start, Length: tg.Length}
+		copy(spec.TargetType[:], tg.Type)
+		spec.Next = uint32(align8(b.Len() + specSize + len(tg.Params) + 1))
+		if err := binary.Write(&b, binary.LittleEndian, spec); err != nil {
+			t.Fatal(err)
+		}
+		b.WriteString(tg.Params)
+		b.Write(make([]byte, int(spec.Next)-b.Len()))
+	}
+	return b.Bytes()
+}
+
+func TestUnmarshalTargets(t *testing.T) {
+	want := []Target{
+		{Start: 0, Length: 2048, Type: "verity", Params: "V"},
+		{Start: 2048, Length: 8, Type: "linear", Params: "7:0 2048"},
+	}
+	got, err := unmarshalTargets(statusBuffer(t, want), len(want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d targets, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("target %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	if _, err := unmarshalTargets(nil, 1); err == nil {
+		t.Errorf("unmarshalTargets of empty buffer: got nil, want error")
+	}
+}
+
+func TestUnmarshalNames(t *testing.T) {
+	entry := func(dev uint64, name string, last bool) []byte {
+		b := make([]byte, align8(12+len(name)+1))
+		binary.LittleEndian.PutUint64(b, dev)
+		if !last {
+			binary.LittleEndian.PutUint32(b[8:], uint32(len(b)))
+		}
+		copy(b[12:], name)
+		return b
+	}
+	data := append(entry(0xfd00, "root", false), entry(0xfd01, "swap", true)...)
+	got, err := unmarshalNames(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got["root"] != 0xfd00 || got["swap"] != 0xfd01 {
+		t.Errorf("unmarshalNames = %v, want map[root:0xfd00 swap:0xfd01]", got)
+	}
+
+	got, err = unmarshalNames(make([]byte, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("unmarshalNames of empty list = %v, want empty", got)
+	}
+}
+
+func TestParseTable(t *testing.T) {
+	table := `# root fs
+0 2048 linear /dev/sda 0
+
+2048 1024   zero
+`
+	got, err := ParseTable(strings.NewReader(table))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Target{
+		{Start: 0, Length: 2048, Type: "linear", Params: "/dev/sda 0"},
+		{Start: 2048, Length: 1024, Type: "zero"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ParseTable = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("target %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	for _, bad := range []string{"0 2048", "x 2048 linear", "0 x linear"} {
+		if _, err := ParseTable(strings.NewReader(bad)); err == nil {
+			t.Errorf("ParseTable(%q): got nil, want error", bad)
+		}
+	}
+}
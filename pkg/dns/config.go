@@ -0,0 +1,158 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dns
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Paths of the system resolver configuration.
+const (
+	ResolvConf = "/etc/resolv.conf"
+	HostsFile  = "/etc/hosts"
+)
+
+// Config is the resolver configuration, as read from resolv.conf.
+type Config struct {
+	// Servers are the name servers' host:port addresses.
+	Servers []string
+
+	// Search are the domains tried for relative names.
+	Search []string
+
+	// Ndots is the number of dots a name needs to be tried as is before
+	// the search domains.
+	Ndots int
+
+	// Timeout is how long to wait for a server to answer.
+	Timeout time.Duration
+
+	// Attempts is how many times each server is tried.
+	Attempts int
+
+	// UseTCP makes queries use TCP instead of UDP.
+	UseTCP bool
+}
+
+// DefaultConfig returns the configuration used without resolv.conf: a
+// server on localhost and the resolv.conf defaults.
+func DefaultConfig() *Config {
+	return &Config{
+		Servers:  []string{"127.0.0.1:53", "[::1]:53"},
+		Ndots:    1,
+		Timeout:  5 * time.Second,
+		Attempts: 2,
+	}
+}
+
+// ReadConfig reads a resolv.conf file.
+func ReadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseConfig(f)
+}
+
+// ParseConfig parses resolv.conf. It understands the nameserver, domain and
+// search keywords, and the ndots, timeout, attempts and use-vc options.
+// Servers default to localhost, as with the C library.
+func ParseConfig(r io.Reader) (*Config, error) {
+	c := DefaultConfig()
+	c.Servers = nil
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		f := strings.Fields(s.Text())
+		if len(f) == 0 || strings.HasPrefix(f[0], "#") || strings.HasPrefix(f[0], ";") {
+			continue
+		}
+		switch f[0] {
+		case "nameserver":
+			if len(f) > 1 && net.ParseIP(f[1]) != nil {
+				c.Servers = append(c.Servers, net.JoinHostPort(f[1], "53"))
+			}
+		case "domain":
+			if len(f) > 1 {
+				c.Search = []string{Fqdn(f[1])}
+			}
+		case "search":
+			c.Search = nil
+			for _, d := range f[1:] {
+				c.Search = append(c.Search, Fqdn(d))
+			}
+		case "options":
+			for _, o := range f[1:] {
+				c.option(o)
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(c.Servers) == 0 {
+		c.Servers = DefaultConfig().Servers
+	}
+	return c, nil
+}
+
+func (c *Config) option(o string) {
+	kv := strings.SplitN(o, ":", 2)
+	if len(kv) == 1 {
+		if o == "use-vc" || o == "tcp" {
+			c.UseTCP = true
+		}
+		return
+	}
+	n, err := strconv.Atoi(kv[1])
+	if err != nil || n < 0 {
+		return
+	}
+	// The C library caps these.
+	switch kv[0] {
+	case "ndots":
+		if n > 15 {
+			n = 15
+		}
+		c.Ndots = n
+	case "timeout":
+		if n < 1 {
+			n = 1
+		}
+		if n > 30 {
+			n = 30
+		}
+		c.Timeout = time.Duration(n) * time.Second
+	case "attempts":
+		if n < 1 {
+			n = 1
+		}
+		if n > 5 {
+			n = 5
+		}
+		c.Attempts = n
+	}
+}
+
+// NameList returns the absolute names to try for name, in order.
+func (c *Config) NameList(name string) []string {
+	if strings.HasSuffix(name, ".") {
+		return []string{name}
+	}
+	var names []string
+	for _, d := range c.Search {
+		names = append(names, name+"."+d)
+	}
+	if strings.Count(name, ".") >= c.Ndots {
+		return append([]string{name + "."}, names...)
+	}
+	return append(names, name+".")
+}
@@ -0,0 +1,78 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dns
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		conf string
+		want *Config
+	}{
+		{
+			name: "empty",
+			want: DefaultConfig(),
+		},
+		{
+			name: "full",
+			conf: `# generated by dhclient
+nameserver 10.0.0.53
+nameserver 2001:db8::53
+nameserver bogus
+domain ignored.example.
+search corp.example.com lab.example.com
+options ndots:2 timeout:1 attempts:9 use-vc rotate
+`,
+			want: &Config{
+				Servers:  []string{"10.0.0.53:53", "[2001:db8::53]:53"},
+				Search:   []string{"corp.example.com.", "lab.example.com."},
+				Ndots:    2,
+				Timeout:  time.Second,
+				Attempts: 5,
+				UseTCP:   true,
+			},
+		},
+		{
+			name: "domain",
+			conf: "search a.example\ndomain example.com\noptions timeout:0\n",
+			want: &Config{
+				Servers:  DefaultConfig().Servers,
+				Search:   []string{"example.com."},
+				Ndots:    1,
+				Timeout:  time.Second,
+				Attempts: 2,
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseConfig(strings.NewReader(tt.conf))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNameList(t *testing.T) {
+	c := &Config{Search: []string{"corp.example.", "example."}, Ndots: 1}
+	for name, want := range map[string][]string{
+		"boot":          {"boot.corp.example.", "boot.example.", "boot."},
+		"boot.lab":      {"boot.lab.", "boot.lab.corp.example.", "boot.lab.example."},
+		"boot.example.": {"boot.example."},
+	} {
+		if got := c.NameList(name); !reflect.DeepEqual(got, want) {
+			t.Errorf("NameList(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dns
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// Hosts is a parsed hosts file.
+type Hosts struct {
+	byName map[string][]net.IP
+	byAddr map[string][]string
+}
+
+// ReadHosts reads a hosts file.
+func ReadHosts(path string) (*Hosts, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseHosts(f)
+}
+
+// ParseHosts parses a hosts file: lines of an address followed by its
+// names. Zones on IPv6 addresses are ignored.
+func ParseHosts(r io.Reader) (*Hosts, error) {
+	h := &Hosts{
+		byName: make(map[string][]net.IP),
+		byAddr: make(map[string][]string),
+	}
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		f := strings.Fields(line)
+		if len(f) < 2 {
+			continue
+		}
+		ip := net.ParseIP(strings.SplitN(f[0], "%", 2)[0])
+		if ip == nil {
+			continue
+		}
+		for _, name := range f[1:] {
+			name = Fqdn(strings.ToLower(name))
+			h.byName[name] = append(h.byName[name], ip)
+			h.byAddr[ip.String()] = append(h.byAddr[ip.String()], name)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// LookupHost returns the addresses of name.
+func (h *Hosts) LookupHost(name string) []net.IP {
+	if h == nil {
+		return nil
+	}
+	return h.byName[Fqdn(strings.ToLower(name))]
+}
+
+// LookupAddr returns the names of ip.
+func (h *Hosts) LookupAddr(ip net.IP) []string {
+	if h == nil {
+		return nil
+	}
+	return h.byAddr[ip.String()]
+}
+
+// ReverseName returns the in-addr.arpa or ip6.arpa name of ip.
+func ReverseName(ip net.IP) (string, error) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", ip4[3], ip4[2], ip4[1], ip4[0]), nil
+	}
+	if len(ip) != net.IPv6len {
+		return "", fmt.Errorf("dns: bad address %v", ip)
+	}
+	var b strings.Builder
+	for i := len(ip) - 1; i >= 0; i-- {
+		fmt.Fprintf(&b, "%x.%x.", ip[i]&0xf, ip[i]>>4)
+	}
+	b.WriteString("ip6.arpa.")
+	return b.String(), nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dns
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHosts(t *testing.T) {
+	h, err := ParseHosts(strings.NewReader(`127.0.0.1	localhost
+::1		localhost ip6-localhost # loopback
+10.0.0.5	Boot.example.com boot
+fe80::1%eth0	router
+garbage
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string][]net.IP{
+		"localhost":         {net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+		"boot.example.com.": {net.ParseIP("10.0.0.5")},
+		"BOOT":              {net.ParseIP("10.0.0.5")},
+		"router":            {net.ParseIP("fe80::1")},
+		"garbage":           nil,
+	} {
+		if got := h.LookupHost(name); !reflect.DeepEqual(got, want) {
+			t.Errorf("LookupHost(%q) = %v, want %v", name, got, want)
+		}
+	}
+	if got, want := h.LookupAddr(net.ParseIP("10.0.0.5")), []string{"boot.example.com.", "boot."}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LookupAddr(10.0.0.5) = %v, want %v", got, want)
+	}
+
+	var nilHosts *Hosts
+	if got := nilHosts.LookupHost("localhost"); got != nil {
+		t.Errorf("nil LookupHost() = %v, want nil", got)
+	}
+}
+
+func TestReverseName(t *testing.T) {
+	for ip, want := range map[string]string{
+		"192.0.2.1":   "1.2.0.192.in-addr.arpa.",
+		"2001:db8::1": "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.",
+	} {
+		if got, err := ReverseName(net.ParseIP(ip)); err != nil || got != want {
+			t.Errorf("ReverseName(%s) = %q, %v, want %q", ip, got, err, want)
+		}
+	}
+	if _, err := ReverseName(net.IP{1, 2}); err == nil {
+		t.Errorf("ReverseName(bad) succeeded, want error")
+	}
+}
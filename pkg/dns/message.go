@@ -0,0 +1,525 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Type is a resource record type.
+type Type uint16
+
+// Record types.
+const (
+	TypeA     Type = 1
+	TypeNS    Type = 2
+	TypeCNAME Type = 5
+	TypeSOA   Type = 6
+	TypePTR   Type = 12
+	TypeMX    Type = 15
+	TypeTXT   Type = 16
+	TypeAAAA  Type = 28
+	TypeSRV   Type = 33
+	TypeANY   Type = 255
+)
+
+var typeNames = map[Type]string{
+	TypeA:     "A",
+	TypeNS:    "NS",
+	TypeCNAME: "CNAME",
+	TypeSOA:   "SOA",
+	TypePTR:   "PTR",
+	TypeMX:    "MX",
+	TypeTXT:   "TXT",
+	TypeAAAA:  "AAAA",
+	TypeSRV:   "SRV",
+	TypeANY:   "ANY",
+}
+
+// String implements fmt.Stringer.
+func (t Type) String() string {
+	if s, ok := typeNames[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("TYPE%d", uint16(t))
+}
+
+// ParseType parses a record type name, such as "AAAA" or "TYPE28".
+func ParseType(s string) (Type, error) {
+	s = strings.ToUpper(s)
+	for t, name := range typeNames {
+		if name == s {
+			return t, nil
+		}
+	}
+	if strings.HasPrefix(s, "TYPE") {
+		if n, err := strconv.ParseUint(s[4:], 10, 16); err == nil {
+			return Type(n), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown record type %q", s)
+}
+
+// ClassINET is the Internet class.
+const ClassINET = 1
+
+// RCode is a response code.
+type RCode uint8
+
+// Response codes.
+const (
+	RCodeSuccess        RCode = 0
+	RCodeFormatError    RCode = 1
+	RCodeServerFailure  RCode = 2
+	RCodeNameError      RCode = 3
+	RCodeNotImplemented RCode = 4
+	RCodeRefused        RCode = 5
+)
+
+var rcodeNames = map[RCode]string{
+	RCodeSuccess:        "NOERROR",
+	RCodeFormatError:    "FORMERR",
+	RCodeServerFailure:  "SERVFAIL",
+	RCodeNameError:      "NXDOMAIN",
+	RCodeNotImplemented: "NOTIMP",
+	RCodeRefused:        "REFUSED",
+}
+
+// String implements fmt.Stringer.
+func (r RCode) String() string {
+	if s, ok := rcodeNames[r]; ok {
+		return s
+	}
+	return fmt.Sprintf("RCODE%d", uint8(r))
+}
+
+const (
+	headerLen = 12
+	maxName   = 255
+	maxLabel  = 63
+	maxPtrs   = 16
+)
+
+var errShort = errors.New("dns: message too short")
+
+// Question is an entry in the question section.
+type Question struct {
+	Name  string
+	Type  Type
+	Class uint16
+}
+
+// SRV is the data of an SRV record.
+type SRV struct {
+	Priority uint16
+	Weight   uint16
+	Port     uint16
+	Target   string
+}
+
+// MX is the data of an MX record.
+type MX struct {
+	Pref uint16
+	Host string
+}
+
+// SOA is the data of an SOA record.
+type SOA struct {
+	MName   string
+	RName   string
+	Serial  uint32
+	Refresh uint32
+	Retry   uint32
+	Expire  uint32
+	Minimum uint32
+}
+
+// Record is a resource record. Which of the data fields is set depends on
+// Type; the data of types this package does not know is kept in Data.
+type Record struct {
+	Name  string
+	Type  Type
+	Class uint16
+	TTL   uint32
+
+	// IP is set for A and AAAA records.
+	IP net.IP
+	// Target is set for CNAME, NS and PTR records.
+	Target string
+	SRV    *SRV
+	MX     *MX
+	TXT    []string
+	SOA    *SOA
+	Data   []byte
+}
+
+// String formats r like a zone file line.
+func (r Record) String() string {
+	var data string
+	switch {
+	case r.IP != nil:
+		data = r.IP.String()
+	case r.Target != "":
+		data = r.Target
+	case r.SRV != nil:
+		data = fmt.Sprintf("%d %d %d %s", r.SRV.Priority, r.SRV.Weight, r.SRV.Port, r.SRV.Target)
+	case r.MX != nil:
+		data = fmt.Sprintf("%d %s", r.MX.Pref, r.MX.Host)
+	case r.TXT != nil:
+		q := make([]string, len(r.TXT))
+		for i, s := range r.TXT {
+			q[i] = strconv.Quote(s)
+		}
+		data = strings.Join(q, " ")
+	case r.SOA != nil:
+		data = fmt.Sprintf("%s %s %d %d %d %d %d", r.SOA.MName, r.SOA.RName,
+			r.SOA.Serial, r.SOA.Refresh, r.SOA.Retry, r.SOA.Expire, r.SOA.Minimum)
+	default:
+		data = fmt.Sprintf("\\# %d %x", len(r.Data), r.Data)
+	}
+	return fmt.Sprintf("%s\t%d\tIN\t%v\t%s", r.Name, r.TTL, r.Type, data)
+}
+
+// Message is a DNS message.
+type Message struct {
+	ID                 uint16
+	Response           bool
+	Opcode             uint8
+	Authoritative      bool
+	Truncated          bool
+	RecursionDesired   bool
+	RecursionAvailable bool
+	RCode              RCode
+
+	Questions   []Question
+	Answers     []Record
+	Authorities []Record
+	Additionals []Record
+}
+
+// Fqdn returns name with a trailing dot.
+func Fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+func appendName(b []byte, name string) ([]byte, error) {
+	name = Fqdn(name)
+	if len(name) > maxName {
+		return nil, fmt.Errorf("dns: name %q too long", name)
+	}
+	if name != "." {
+		for _, l := range strings.Split(name[:len(name)-1], ".") {
+			if len(l) == 0 || len(l) > maxLabel {
+				return nil, fmt.Errorf("dns: bad label in name %q", name)
+			}
+			b = append(b, byte(len(l)))
+			b = append(b, l...)
+		}
+	}
+	return append(b, 0), nil
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// Pack returns the wire format of m. Names are not compressed.
+func (m *Message) Pack() ([]byte, error) {
+	flags := uint16(m.Opcode&0xf)<<11 | uint16(m.RCode&0xf)
+	for _, f := range []struct {
+		set bool
+		bit uint16
+	}{
+		{m.Response, 1 << 15},
+		{m.Authoritative, 1 << 10},
+		{m.Truncated, 1 << 9},
+		{m.RecursionDesired, 1 << 8},
+		{m.RecursionAvailable, 1 << 7},
+	} {
+		if f.set {
+			flags |= f.bit
+		}
+	}
+
+	b := make([]byte, 0, 512)
+	b = appendUint16(b, m.ID)
+	b = appendUint16(b, flags)
+	for _, n := range []int{len(m.Questions), len(m.Answers), len(m.Authorities), len(m.Additionals)} {
+		b = appendUint16(b, uint16(n))
+	}
+
+	var err error
+	for _, q := range m.Questions {
+		if b, err = appendName(b, q.Name); err != nil {
+			return nil, err
+		}
+		b = appendUint16(b, uint16(q.Type))
+		b = appendUint16(b, q.Class)
+	}
+	for _, section := range [][]Record{m.Answers, m.Authorities, m.Additionals} {
+		for _, r := range section {
+			if b, err = appendRecord(b, r); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return b, nil
+}
+
+func appendRecord(b []byte, r Record) ([]byte, error) {
+	b, err := appendName(b, r.Name)
+	if err != nil {
+		return nil, err
+	}
+	b = appendUint16(b, uint16(r.Type))
+	b = appendUint16(b, r.Class)
+	b = appendUint32(b, r.TTL)
+
+	// Leave room for the data length.
+	lenOff := len(b)
+	b = append(b, 0, 0)
+	switch {
+	case r.Type == TypeA:
+		ip := r.IP.To4()
+		if ip == nil {
+			return nil, fmt.Errorf("dns: bad A record address %v", r.IP)
+		}
+		b = append(b, ip...)
+	case r.Type == TypeAAAA:
+		if len(r.IP) != net.IPv6len {
+			return nil, fmt.Errorf("dns: bad AAAA record address %v", r.IP)
+		}
+		b = append(b, r.IP...)
+	case r.Type == TypeCNAME || r.Type == TypeNS || r.Type == TypePTR:
+		b, err = appendName(b, r.Target)
+	case r.Type == TypeSRV && r.SRV != nil:
+		b = appendUint16(b, r.SRV.Priority)
+		b = appendUint16(b, r.SRV.Weight)
+		b = appendUint16(b, r.SRV.Port)
+		b, err = appendName(b, r.SRV.Target)
+	case r.Type == TypeMX && r.MX != nil:
+		b = appendUint16(b, r.MX.Pref)
+		b, err = appendName(b, r.MX.Host)
+	case r.Type == TypeTXT:
+		for _, s := range r.TXT {
+			if len(s) > 255 {
+				return nil, fmt.Errorf("dns: TXT string too long")
+			}
+			b = append(b, byte(len(s)))
+			b = append(b, s...)
+		}
+	case r.Type == TypeSOA && r.SOA != nil:
+		if b, err = appendName(b, r.SOA.MName); err != nil {
+			return nil, err
+		}
+		if b, err = appendName(b, r.SOA.RName); err != nil {
+			return nil, err
+		}
+		for _, v := range []uint32{r.SOA.Serial, r.SOA.Refresh, r.SOA.Retry, r.SOA.Expire, r.SOA.Minimum} {
+			b = appendUint32(b, v)
+		}
+	default:
+		b = append(b, r.Data...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	n := len(b) - lenOff - 2
+	if n > 0xffff {
+		return nil, fmt.Errorf("dns: record data too long")
+	}
+	binary.BigEndian.PutUint16(b[lenOff:], uint16(n))
+	return b, nil
+}
+
+// readName reads the possibly compressed name at off in msg. It returns
+// the name and the offset after it.
+func readName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for ptrs := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errShort
+		}
+		c := int(msg[off])
+		switch c & 0xc0 {
+		case 0x00:
+			if c == 0 {
+				if end < 0 {
+					end = off + 1
+				}
+				name := strings.Join(labels, ".") + "."
+				if len(name) > maxName {
+					return "", 0, fmt.Errorf("dns: name too long")
+				}
+				return name, end, nil
+			}
+			if off+1+c > len(msg) {
+				return "", 0, errShort
+			}
+			labels = append(labels, string(msg[off+1:off+1+c]))
+			off += 1 + c
+		case 0xc0:
+			if off+1 >= len(msg) {
+				return "", 0, errShort
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			if ptrs++; ptrs > maxPtrs {
+				return "", 0, fmt.Errorf("dns: too many compression pointers")
+			}
+			off = (c&0x3f)<<8 | int(msg[off+1])
+		default:
+			return "", 0, fmt.Errorf("dns: bad label type %#x", c)
+		}
+	}
+}
+
+// Unpack parses the wire format of a message into m.
+func (m *Message) Unpack(msg []byte) error {
+	if len(msg) < headerLen {
+		return errShort
+	}
+	flags := binary.BigEndian.Uint16(msg[2:])
+	*m = Message{
+		ID:                 binary.BigEndian.Uint16(msg),
+		Response:           flags&(1<<15) != 0,
+		Opcode:             uint8(flags>>11) & 0xf,
+		Authoritative:      flags&(1<<10) != 0,
+		Truncated:          flags&(1<<9) != 0,
+		RecursionDesired:   flags&(1<<8) != 0,
+		RecursionAvailable: flags&(1<<7) != 0,
+		RCode:              RCode(flags & 0xf),
+	}
+	var counts [4]int
+	for i := range counts {
+		counts[i] = int(binary.BigEndian.Uint16(msg[4+2*i:]))
+	}
+
+	off := headerLen
+	for i := 0; i < counts[0]; i++ {
+		name, n, err := readName(msg, off)
+		if err != nil {
+			return err
+		}
+		if n+4 > len(msg) {
+			return errShort
+		}
+		m.Questions = append(m.Questions, Question{
+			Name:  name,
+			Type:  Type(binary.BigEndian.Uint16(msg[n:])),
+			Class: binary.BigEndian.Uint16(msg[n+2:]),
+		})
+		off = n + 4
+	}
+
+	for i, section := range []*[]Record{&m.Answers, &m.Authorities, &m.Additionals} {
+		for j := 0; j < counts[i+1]; j++ {
+			r, n, err := readRecord(msg, off)
+			if err != nil {
+				return err
+			}
+			*section = append(*section, r)
+			off = n
+		}
+	}
+	return nil
+}
+
+func readRecord(msg []byte, off int) (Record, int, error) {
+	var r Record
+	name, off, err := readName(msg, off)
+	if err != nil {
+		return r, 0, err
+	}
+	if off+10 > len(msg) {
+		return r, 0, errShort
+	}
+	r.Name = name
+	r.Type = Type(binary.BigEndian.Uint16(msg[off:]))
+	r.Class = binary.BigEndian.Uint16(msg[off+2:])
+	r.TTL = binary.BigEndian.Uint32(msg[off+4:])
+	n := int(binary.BigEndian.Uint16(msg[off+8:]))
+	off += 10
+	end := off + n
+	if end > len(msg) {
+		return r, 0, errShort
+	}
+	data := msg[off:end]
+
+	// Names in the data may point anywhere in msg, so they are read from
+	// msg rather than data.
+	switch r.Type {
+	case TypeA, TypeAAAA:
+		if (r.Type == TypeA && n != net.IPv4len) || (r.Type == TypeAAAA && n != net.IPv6len) {
+			return r, 0, fmt.Errorf("dns: bad %v record length %d", r.Type, n)
+		}
+		r.IP = append(net.IP(nil), data...)
+	case TypeCNAME, TypeNS, TypePTR:
+		r.Target, _, err = readName(msg[:end], off)
+	case TypeSRV:
+		if n < 7 {
+			return r, 0, errShort
+		}
+		r.SRV = &SRV{
+			Priority: binary.BigEndian.Uint16(data),
+			Weight:   binary.BigEndian.Uint16(data[2:]),
+			Port:     binary.BigEndian.Uint16(data[4:]),
+		}
+		r.SRV.Target, _, err = readName(msg[:end], off+6)
+	case TypeMX:
+		if n < 3 {
+			return r, 0, errShort
+		}
+		r.MX = &MX{Pref: binary.BigEndian.Uint16(data)}
+		r.MX.Host, _, err = readName(msg[:end], off+2)
+	case TypeTXT:
+		r.TXT = []string{}
+		for i := 0; i < n; {
+			l := int(data[i])
+			if i+1+l > n {
+				return r, 0, errShort
+			}
+			r.TXT = append(r.TXT, string(data[i+1:i+1+l]))
+			i += 1 + l
+		}
+	case TypeSOA:
+		r.SOA = &SOA{}
+		var o int
+		if r.SOA.MName, o, err = readName(msg[:end], off); err != nil {
+			break
+		}
+		if r.SOA.RName, o, err = readName(msg[:end], o); err != nil {
+			break
+		}
+		if o+20 > end {
+			return r, 0, errShort
+		}
+		v := make([]uint32, 5)
+		for i := range v {
+			v[i] = binary.BigEndian.Uint32(msg[o+4*i:])
+		}
+		r.SOA.Serial, r.SOA.Refresh, r.SOA.Retry, r.SOA.Expire, r.SOA.Minimum = v[0], v[1], v[2], v[3], v[4]
+	default:
+		r.Data = append([]byte(nil), data...)
+	}
+	if err != nil {
+		return r, 0, err
+	}
+	return r, end, nil
+}
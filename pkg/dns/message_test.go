@@ -0,0 +1,121 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dns
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	m := &Message{
+		ID:                 0xbeef,
+		Response:           true,
+		Authoritative:      true,
+		RecursionDesired:   true,
+		RecursionAvailable: true,
+		RCode:              RCodeSuccess,
+		Questions:          []Question{{Name: "www.example.com.", Type: TypeA, Class: ClassINET}},
+		Answers: []Record{
+			{Name: "www.example.com.", Type: TypeCNAME, Class: ClassINET, TTL: 300, Target: "web.example.com."},
+			{Name: "web.example.com.", Type: TypeA, Class: ClassINET, TTL: 60, IP: net.IP{192, 0, 2, 1}},
+			{Name: "web.example.com.", Type: TypeAAAA, Class: ClassINET, TTL: 60, IP: net.ParseIP("2001:db8::1")},
+			{Name: "_http._tcp.example.com.", Type: TypeSRV, Class: ClassINET, TTL: 10, SRV: &SRV{Priority: 1, Weight: 2, Port: 80, Target: "web.example.com."}},
+			{Name: "example.com.", Type: TypeMX, Class: ClassINET, TTL: 10, MX: &MX{Pref: 10, Host: "mail.example.com."}},
+			{Name: "example.com.", Type: TypeTXT, Class: ClassINET, TTL: 10, TXT: []string{"v=spf1 -all", ""}},
+			{Name: "1.2.0.192.in-addr.arpa.", Type: TypePTR, Class: ClassINET, TTL: 10, Target: "web.example.com."},
+			{Name: "example.com.", Type: 99, Class: ClassINET, TTL: 10, Data: []byte{1, 2, 3}},
+		},
+		Authorities: []Record{
+			{Name: "example.com.", Type: TypeSOA, Class: ClassINET, TTL: 3600, SOA: &SOA{
+				MName: "ns.example.com.", RName: "hostmaster.example.com.",
+				Serial: 1, Refresh: 2, Retry: 3, Expire: 4, Minimum: 5,
+			}},
+		},
+	}
+	b, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Message
+	if err := got.Unpack(b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&got, m) {
+		t.Errorf("Unpack(Pack(m)) = %+v, want %+v", got, m)
+	}
+}
+
+func TestUnpackCompressed(t *testing.T) {
+	b := []byte{
+		0x12, 0x34, 0x81, 0x80, 0, 1, 0, 1, 0, 0, 0, 0,
+		// Question: example.com. A IN
+		7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 0, 1, 0, 1,
+		// Answer: www + pointer to example.com. (offset 12), CNAME to
+		// pointer to the answer name (offset 29).
+		3, 'w', 'w', 'w', 0xc0, 12, 0, 5, 0, 1, 0, 0, 0, 60, 0, 2, 0xc0, 29,
+	}
+	var m Message
+	if err := m.Unpack(b); err != nil {
+		t.Fatal(err)
+	}
+	want := Record{Name: "www.example.com.", Type: TypeCNAME, Class: ClassINET, TTL: 60, Target: "www.example.com."}
+	if len(m.Answers) != 1 || !reflect.DeepEqual(m.Answers[0], want) {
+		t.Errorf("Answers = %+v, want [%+v]", m.Answers, want)
+	}
+	if !m.Response || !m.RecursionDesired || !m.RecursionAvailable || m.ID != 0x1234 {
+		t.Errorf("header = %+v, want response with RD and RA, ID 0x1234", m)
+	}
+}
+
+func TestUnpackErrors(t *testing.T) {
+	header := []byte{0, 0, 0x80, 0, 0, 1, 0, 0, 0, 0, 0, 0}
+	for name, b := range map[string][]byte{
+		"short header":  {0, 1, 2},
+		"short name":    append(header, 3, 'f', 'o'),
+		"pointer loop":  append(header, 0xc0, 12, 0, 1, 0, 1),
+		"bad label":     append(header, 0x80, 0, 1, 0, 1),
+		"no question":   header,
+		"short A":       append([]byte{0, 0, 0x80, 0, 0, 0, 0, 1, 0, 0, 0, 0}, 0, 0, 1, 0, 1, 0, 0, 0, 0, 0, 2, 1, 2),
+		"short rdata":   append([]byte{0, 0, 0x80, 0, 0, 0, 0, 1, 0, 0, 0, 0}, 0, 0, 1, 0, 1, 0, 0, 0, 0, 0, 9, 1, 2),
+		"long TXT text": append([]byte{0, 0, 0x80, 0, 0, 0, 0, 1, 0, 0, 0, 0}, 0, 0, 16, 0, 1, 0, 0, 0, 0, 0, 2, 5, 'a'),
+	} {
+		var m Message
+		if err := m.Unpack(b); err == nil {
+			t.Errorf("%s: Unpack() = %+v, want error", name, m)
+		}
+	}
+}
+
+func TestPackErrors(t *testing.T) {
+	long := make([]byte, 64)
+	for i := range long {
+		long[i] = 'a'
+	}
+	for name, m := range map[string]*Message{
+		"long label":  {Questions: []Question{{Name: string(long) + ".com", Type: TypeA}}},
+		"empty label": {Questions: []Question{{Name: "foo..com", Type: TypeA}}},
+		"bad A":       {Answers: []Record{{Name: "a.", Type: TypeA, IP: net.ParseIP("::1")}}},
+	} {
+		if _, err := m.Pack(); err == nil {
+			t.Errorf("%s: Pack() succeeded, want error", name)
+		}
+	}
+}
+
+func TestParseType(t *testing.T) {
+	for s, want := range map[string]Type{"a": TypeA, "AAAA": TypeAAAA, "srv": TypeSRV, "TYPE99": 99} {
+		if got, err := ParseType(s); err != nil || got != want {
+			t.Errorf("ParseType(%q) = %v, %v, want %v", s, got, err, want)
+		}
+	}
+	if _, err := ParseType("BOGUS"); err == nil {
+		t.Errorf("ParseType(BOGUS) succeeded, want error")
+	}
+	if s := Type(99).String(); s != "TYPE99" {
+		t.Errorf("Type(99).String() = %q, want TYPE99", s)
+	}
+}
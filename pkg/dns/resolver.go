@@ -0,0 +1,450 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package dns implements a small caching DNS stub resolver.
+//
+// Unlike the net package's resolver, whose behaviour depends on how the
+// binary was built and on nsswitch.conf, a Resolver does exactly this: it
+// answers from a hosts file first, then asks the servers in its Config over
+// UDP, or TCP if the answer is truncated, trying search domains as
+// resolv.conf says, and caches answers for their TTL. Boot tools use it
+// explicitly so that netbooting by host name behaves the same everywhere,
+// and tests can point it at a fake server.
+package dns
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxUDP is the largest UDP message accepted. Queries don't advertise a
+// larger size with EDNS0, but some servers send larger answers anyway.
+const maxUDP = 4096
+
+// Error is a lookup error.
+type Error struct {
+	Name   string
+	Server string
+	Err    string
+
+	// NotFound is set if the name does not exist or has no records of
+	// the type asked for.
+	NotFound bool
+	timeout  bool
+}
+
+// Error implements error.Error.
+func (e *Error) Error() string {
+	s := "lookup " + e.Name
+	if e.Server != "" {
+		s += " on " + e.Server
+	}
+	return s + ": " + e.Err
+}
+
+// Timeout reports whether the lookup timed out.
+func (e *Error) Timeout() bool {
+	return e.timeout
+}
+
+// IsNotFound reports whether err is an Error for a name that was not found.
+func IsNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.NotFound
+}
+
+type cacheKey struct {
+	name string
+	typ  Type
+}
+
+type cacheEntry struct {
+	records []Record
+	// notFound caches NXDOMAIN and empty answers.
+	notFound bool
+	expires  time.Time
+}
+
+// Resolver is a caching DNS stub resolver. It is safe for concurrent use.
+type Resolver struct {
+	Config *Config
+
+	// Hosts, if not nil, is consulted before the servers.
+	Hosts *Hosts
+
+	mu    sync.Mutex
+	cache map[cacheKey]cacheEntry
+	now   func() time.Time
+}
+
+// New returns a Resolver using c and h, which may be nil.
+func New(c *Config, h *Hosts) *Resolver {
+	return &Resolver{
+		Config: c,
+		Hosts:  h,
+		cache:  make(map[cacheKey]cacheEntry),
+		now:    time.Now,
+	}
+}
+
+// NewSystem returns a Resolver using /etc/resolv.conf and /etc/hosts. A
+// missing file is treated as empty.
+func NewSystem() (*Resolver, error) {
+	c, err := ReadConfig(ResolvConf)
+	if os.IsNotExist(err) {
+		c, err = DefaultConfig(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	h, err := ReadHosts(HostsFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return New(c, h), nil
+}
+
+func newID() uint16 {
+	var b [2]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return uint16(time.Now().UnixNano())
+	}
+	return binary.BigEndian.Uint16(b[:])
+}
+
+// Exchange sends a recursive query for q to server, a host:port address,
+// and returns the response. It uses UDP unless useTCP is set or the UDP
+// response is truncated.
+func Exchange(ctx context.Context, server string, q Question, useTCP bool) (*Message, error) {
+	if q.Class == 0 {
+		q.Class = ClassINET
+	}
+	m := &Message{ID: newID(), RecursionDesired: true, Questions: []Question{q}}
+	b, err := m.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	if !useTCP {
+		resp, err := exchange(ctx, "udp", server, m, b)
+		if err != nil || !resp.Truncated {
+			return resp, err
+		}
+	}
+	return exchange(ctx, "tcp", server, m, b)
+}
+
+func exchange(ctx context.Context, network, server string, q *Message, query []byte) (*Message, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	for {
+		b, err := roundTrip(conn, network, query)
+		if err != nil {
+			return nil, err
+		}
+		var resp Message
+		if err := resp.Unpack(b); err != nil {
+			return nil, err
+		}
+		// Over UDP, ignore stray answers and wait for ours.
+		if resp.ID == q.ID && resp.Response && len(resp.Questions) == 1 && equalQuestion(resp.Questions[0], q.Questions[0]) {
+			return &resp, nil
+		}
+		if network == "tcp" {
+			return nil, fmt.Errorf("dns: response does not match query")
+		}
+		query = nil
+	}
+}
+
+// roundTrip sends query, unless it is nil, and reads a response.
+func roundTrip(conn net.Conn, network string, query []byte) ([]byte, error) {
+	if network == "udp" {
+		if query != nil {
+			if _, err := conn.Write(query); err != nil {
+				return nil, err
+			}
+		}
+		b := make([]byte, maxUDP)
+		n, err := conn.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+
+	b := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(b, uint16(len(query)))
+	copy(b[2:], query)
+	if _, err := conn.Write(b); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(conn, b[:2]); err != nil {
+		return nil, err
+	}
+	b = make([]byte, binary.BigEndian.Uint16(b))
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func equalQuestion(a, b Question) bool {
+	return a.Type == b.Type && a.Class == b.Class && equalName(a.Name, b.Name)
+}
+
+func equalName(a, b string) bool {
+	return strings.EqualFold(Fqdn(a), Fqdn(b))
+}
+
+// query asks the servers about the absolute name, trying each server
+// Config.Attempts times. It returns the first NOERROR or NXDOMAIN response.
+func (r *Resolver) query(ctx context.Context, name string, t Type) (*Message, string, error) {
+	var lastErr error
+	var server string
+	for i := 0; i < r.Config.Attempts; i++ {
+		for _, server = range r.Config.Servers {
+			tctx, cancel := context.WithTimeout(ctx, r.Config.Timeout)
+			m, err := Exchange(tctx, server, Question{Name: name, Type: t}, r.Config.UseTCP)
+			cancel()
+			if ctx.Err() != nil {
+				return nil, server, ctx.Err()
+			}
+			switch {
+			case err != nil:
+				lastErr = err
+			case m.RCode == RCodeSuccess || m.RCode == RCodeNameError:
+				return m, server, nil
+			default:
+				lastErr = fmt.Errorf("server failure: %v", m.RCode)
+			}
+		}
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no name servers")
+	}
+	return nil, server, lastErr
+}
+
+// minTTL returns the smallest TTL of records.
+func minTTL(records []Record) uint32 {
+	ttl := uint32(0)
+	for i, rr := range records {
+		if i == 0 || rr.TTL < ttl {
+			ttl = rr.TTL
+		}
+	}
+	return ttl
+}
+
+// negativeTTL returns how long to cache a negative answer: the SOA record's
+// minimum TTL, if the server sent one.
+func negativeTTL(m *Message) (uint32, bool) {
+	for _, rr := range m.Authorities {
+		if rr.SOA != nil {
+			if rr.SOA.Minimum < rr.TTL {
+				return rr.SOA.Minimum, true
+			}
+			return rr.TTL, true
+		}
+	}
+	return 0, false
+}
+
+// lookupName looks up records of type t for the absolute name, through the
+// cache.
+func (r *Resolver) lookupName(ctx context.Context, name string, t Type) ([]Record, string, error) {
+	key := cacheKey{name: strings.ToLower(name), typ: t}
+	r.mu.Lock()
+	e, ok := r.cache[key]
+	if ok && r.now().After(e.expires) {
+		delete(r.cache, key)
+		ok = false
+	}
+	r.mu.Unlock()
+	if ok {
+		if e.notFound {
+			return nil, "", &Error{Name: name, Err: "no such host", NotFound: true}
+		}
+		return e.records, "", nil
+	}
+
+	m, server, err := r.query(ctx, name, t)
+	if err != nil {
+		e := &Error{Name: name, Server: server, Err: err.Error()}
+		if ne, ok := err.(net.Error); ok && ne.Timeout() || err == context.DeadlineExceeded {
+			e.timeout = true
+		}
+		return nil, server, e
+	}
+
+	var records []Record
+	for _, rr := range m.Answers {
+		if rr.Type == t || t == TypeANY {
+			records = append(records, rr)
+		}
+	}
+	if len(records) == 0 {
+		if ttl, ok := negativeTTL(m); ok {
+			r.store(key, cacheEntry{notFound: true}, ttl)
+		}
+		return nil, server, &Error{Name: name, Server: server, Err: "no such host", NotFound: true}
+	}
+	r.store(key, cacheEntry{records: records}, minTTL(records))
+	return records, server, nil
+}
+
+func (r *Resolver) store(key cacheKey, e cacheEntry, ttl uint32) {
+	if ttl == 0 {
+		return
+	}
+	e.expires = r.now().Add(time.Duration(ttl) * time.Second)
+	r.mu.Lock()
+	r.cache[key] = e
+	r.mu.Unlock()
+}
+
+// Flush empties the cache.
+func (r *Resolver) Flush() {
+	r.mu.Lock()
+	r.cache = make(map[cacheKey]cacheEntry)
+	r.mu.Unlock()
+}
+
+// Lookup returns the records of type t for name, trying the search domains
+// for relative names. The hosts file is not consulted.
+func (r *Resolver) Lookup(ctx context.Context, name string, t Type) ([]Record, error) {
+	var err error
+	for _, n := range r.Config.NameList(name) {
+		var records []Record
+		records, _, err = r.lookupName(ctx, n, t)
+		if err == nil {
+			return records, nil
+		}
+		if !IsNotFound(err) {
+			return nil, err
+		}
+	}
+	return nil, &Error{Name: name, Err: "no such host", NotFound: true}
+}
+
+// LookupIP returns the IPv4 and IPv6 addresses of host, which may be an
+// address itself.
+func (r *Resolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	if ips := r.Hosts.LookupHost(host); len(ips) > 0 {
+		return ips, nil
+	}
+
+	var ips []net.IP
+	var lastErr error
+	for _, t := range []Type{TypeA, TypeAAAA} {
+		records, err := r.Lookup(ctx, host, t)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, rr := range records {
+			ips = append(ips, rr.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, lastErr
+	}
+	return ips, nil
+}
+
+// LookupAddr returns the names of ip.
+func (r *Resolver) LookupAddr(ctx context.Context, ip net.IP) ([]string, error) {
+	if names := r.Hosts.LookupAddr(ip); len(names) > 0 {
+		return names, nil
+	}
+	name, err := ReverseName(ip)
+	if err != nil {
+		return nil, err
+	}
+	records, err := r.Lookup(ctx, name, TypePTR)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, rr := range records {
+		names = append(names, rr.Target)
+	}
+	return names, nil
+}
+
+// LookupSRV returns the SRV records of _service._proto.name. With empty
+// service and proto, name is looked up directly.
+func (r *Resolver) LookupSRV(ctx context.Context, service, proto, name string) ([]*SRV, error) {
+	if service != "" || proto != "" {
+		name = "_" + service + "._" + proto + "." + name
+	}
+	records, err := r.Lookup(ctx, name, TypeSRV)
+	if err != nil {
+		return nil, err
+	}
+	var srvs []*SRV
+	for _, rr := range records {
+		srvs = append(srvs, rr.SRV)
+	}
+	return srvs, nil
+}
+
+// LookupTXT returns the TXT records of name, with each record's strings
+// joined.
+func (r *Resolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	records, err := r.Lookup(ctx, name, TypeTXT)
+	if err != nil {
+		return nil, err
+	}
+	var txts []string
+	for _, rr := range records {
+		var s string
+		for _, t := range rr.TXT {
+			s += t
+		}
+		txts = append(txts, s)
+	}
+	return txts, nil
+}
+
+// DialContext resolves the host in address with r and connects to its
+// addresses in turn. It can be used as an http.Transport's DialContext.
+func (r *Resolver) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := r.LookupIP(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	for _, ip := range ips {
+		var conn net.Conn
+		if conn, err = d.DialContext(ctx, network, net.JoinHostPort(ip.String(), port)); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,365 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer answers queries from a map over UDP and TCP on the same port.
+type fakeServer struct {
+	t    *testing.T
+	udp  net.PacketConn
+	tcp  net.Listener
+	addr string
+
+	records map[Question][]Record
+
+	mu      sync.Mutex
+	queries []string
+	// truncate makes UDP answers for these names truncated.
+	truncate map[string]bool
+}
+
+func newFakeServer(t *testing.T, records []Record) *fakeServer {
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	if err != nil {
+		udp.Close()
+		t.Skipf("cannot listen on TCP and UDP on the same port: %v", err)
+	}
+	s := &fakeServer{
+		t:        t,
+		udp:      udp,
+		tcp:      tcp,
+		addr:     udp.LocalAddr().String(),
+		truncate: make(map[string]bool),
+		records:  make(map[Question][]Record),
+	}
+	for _, rr := range records {
+		rr.Class = ClassINET
+		q := Question{Name: rr.Name, Type: rr.Type, Class: ClassINET}
+		s.records[q] = append(s.records[q], rr)
+	}
+	go s.serveUDP()
+	go s.serveTCP()
+	return s
+}
+
+func (s *fakeServer) Close() {
+	s.udp.Close()
+	s.tcp.Close()
+}
+
+func (s *fakeServer) answer(b []byte, network string) []byte {
+	var q Message
+	if err := q.Unpack(b); err != nil {
+		s.t.Errorf("server: bad query: %v", err)
+		return nil
+	}
+	name := q.Questions[0].Name
+	s.mu.Lock()
+	s.queries = append(s.queries, network+" "+name+" "+q.Questions[0].Type.String())
+	truncate := s.truncate[name]
+	s.mu.Unlock()
+
+	m := &Message{ID: q.ID, Response: true, RecursionAvailable: true, Questions: q.Questions}
+	switch {
+	case name == "servfail.example.":
+		m.RCode = RCodeServerFailure
+	case network == "udp" && truncate:
+		m.Truncated = true
+	default:
+		m.Answers = s.records[q.Questions[0]]
+		// Answer for a CNAME too, like a recursive server would.
+		for _, c := range s.records[Question{Name: name, Type: TypeCNAME, Class: ClassINET}] {
+			q := q.Questions[0]
+			q.Name = c.Target
+			m.Answers = append(append([]Record{c}, m.Answers...), s.records[q]...)
+		}
+		if len(m.Answers) == 0 {
+			m.Authorities = []Record{{Name: "example.", Type: TypeSOA, Class: ClassINET, TTL: 60, SOA: &SOA{
+				MName: "ns.example.", RName: "root.example.", Minimum: 30,
+			}}}
+			if !s.exists(name) {
+				m.RCode = RCodeNameError
+			}
+		}
+	}
+	resp, err := m.Pack()
+	if err != nil {
+		s.t.Errorf("server: %v", err)
+	}
+	return resp
+}
+
+func (s *fakeServer) exists(name string) bool {
+	for q := range s.records {
+		if q.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *fakeServer) serveUDP() {
+	b := make([]byte, 512)
+	for {
+		n, addr, err := s.udp.ReadFrom(b)
+		if err != nil {
+			return
+		}
+		if resp := s.answer(b[:n], "udp"); resp != nil {
+			s.udp.WriteTo(resp, addr)
+		}
+	}
+}
+
+func (s *fakeServer) serveTCP() {
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			var l [2]byte
+			if _, err := io.ReadFull(conn, l[:]); err != nil {
+				return
+			}
+			b := make([]byte, binary.BigEndian.Uint16(l[:]))
+			if _, err := io.ReadFull(conn, b); err != nil {
+				return
+			}
+			resp := s.answer(b, "tcp")
+			binary.BigEndian.PutUint16(l[:], uint16(len(resp)))
+			conn.Write(append(l[:], resp...))
+		}()
+	}
+}
+
+func (s *fakeServer) Queries() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := s.queries
+	s.queries = nil
+	return q
+}
+
+var testRecords = []Record{
+	{Name: "boot.example.", Type: TypeA, TTL: 300, IP: net.IP{10, 0, 0, 5}},
+	{Name: "boot.example.", Type: TypeAAAA, TTL: 300, IP: net.ParseIP("2001:db8::5")},
+	{Name: "www.example.", Type: TypeCNAME, TTL: 300, Target: "boot.example."},
+	{Name: "v4only.example.", Type: TypeA, TTL: 0, IP: net.IP{10, 0, 0, 6}},
+	{Name: "big.example.", Type: TypeTXT, TTL: 300, TXT: []string{"part one, ", "part two"}},
+	{Name: "_http._tcp.example.", Type: TypeSRV, TTL: 300, SRV: &SRV{Priority: 1, Weight: 5, Port: 8080, Target: "boot.example."}},
+	{Name: "5.0.0.10.in-addr.arpa.", Type: TypePTR, TTL: 300, Target: "boot.example."},
+}
+
+func newTestResolver(t *testing.T) (*Resolver, *fakeServer) {
+	s := newFakeServer(t, testRecords)
+	c := &Config{
+		Servers:  []string{s.addr},
+		Search:   []string{"example."},
+		Ndots:    1,
+		Timeout:  time.Second,
+		Attempts: 1,
+	}
+	return New(c, nil), s
+}
+
+func TestLookupIP(t *testing.T) {
+	r, s := newTestResolver(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	for _, tt := range []struct {
+		host    string
+		want    []net.IP
+		queries []string
+	}{
+		{
+			host:    "boot",
+			want:    []net.IP{{10, 0, 0, 5}, net.ParseIP("2001:db8::5")},
+			queries: []string{"udp boot.example. A", "udp boot.example. AAAA"},
+		},
+		{
+			// Cached.
+			host: "boot.example.",
+			want: []net.IP{{10, 0, 0, 5}, net.ParseIP("2001:db8::5")},
+		},
+		{
+			host:    "www",
+			want:    []net.IP{{10, 0, 0, 5}, net.ParseIP("2001:db8::5")},
+			queries: []string{"udp www.example. A", "udp www.example. AAAA"},
+		},
+		{
+			// The AAAA answers are cached as not found, the A answer
+			// has a zero TTL and is not cached.
+			host:    "v4only",
+			want:    []net.IP{{10, 0, 0, 6}},
+			queries: []string{"udp v4only.example. A", "udp v4only.example. AAAA", "udp v4only. AAAA"},
+		},
+		{
+			host:    "v4only",
+			want:    []net.IP{{10, 0, 0, 6}},
+			queries: []string{"udp v4only.example. A"},
+		},
+		{
+			host: "10.1.2.3",
+			want: []net.IP{net.ParseIP("10.1.2.3")},
+		},
+	} {
+		got, err := r.LookupIP(ctx, tt.host)
+		if err != nil {
+			t.Errorf("LookupIP(%q) = %v", tt.host, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("LookupIP(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+		if q := s.Queries(); !reflect.DeepEqual(q, tt.queries) {
+			t.Errorf("LookupIP(%q) sent %v, want %v", tt.host, q, tt.queries)
+		}
+	}
+}
+
+func TestLookupNotFound(t *testing.T) {
+	r, s := newTestResolver(t)
+	defer s.Close()
+
+	_, err := r.LookupIP(context.Background(), "nope")
+	if !IsNotFound(err) {
+		t.Fatalf("LookupIP(nope) = %v, want not found error", err)
+	}
+	want := []string{"udp nope.example. A", "udp nope. A", "udp nope.example. AAAA", "udp nope. AAAA"}
+	if q := s.Queries(); !reflect.DeepEqual(q, want) {
+		t.Errorf("LookupIP(nope) sent %v, want %v", q, want)
+	}
+
+	// Negative answers are cached.
+	if _, err := r.LookupIP(context.Background(), "nope"); !IsNotFound(err) {
+		t.Fatalf("LookupIP(nope) = %v, want not found error", err)
+	}
+	if q := s.Queries(); len(q) != 0 {
+		t.Errorf("LookupIP(nope) sent %v, want cached answer", q)
+	}
+
+	// Advance past the SOA minimum TTL.
+	r.now = func() time.Time { return time.Now().Add(time.Minute) }
+	r.LookupIP(context.Background(), "nope")
+	if q := s.Queries(); len(q) != 4 {
+		t.Errorf("LookupIP(nope) sent %v, want fresh queries", q)
+	}
+}
+
+func TestLookupTCPAndErrors(t *testing.T) {
+	r, s := newTestResolver(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	s.mu.Lock()
+	s.truncate["big.example."] = true
+	s.mu.Unlock()
+	txt, err := r.LookupTXT(ctx, "big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"part one, part two"}; !reflect.DeepEqual(txt, want) {
+		t.Errorf("LookupTXT(big) = %q, want %q", txt, want)
+	}
+	if q, want := s.Queries(), []string{"udp big.example. TXT", "tcp big.example. TXT"}; !reflect.DeepEqual(q, want) {
+		t.Errorf("LookupTXT(big) sent %v, want %v", q, want)
+	}
+
+	if _, err := r.Lookup(ctx, "servfail.example.", TypeA); err == nil || IsNotFound(err) || !strings.Contains(err.Error(), "SERVFAIL") {
+		t.Errorf("Lookup(servfail) = %v, want SERVFAIL error", err)
+	}
+
+	srv, err := r.LookupSRV(ctx, "http", "tcp", "example.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []*SRV{{Priority: 1, Weight: 5, Port: 8080, Target: "boot.example."}}; !reflect.DeepEqual(srv, want) {
+		t.Errorf("LookupSRV() = %v, want %v", srv, want)
+	}
+
+	names, err := r.LookupAddr(ctx, net.IP{10, 0, 0, 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"boot.example."}; !reflect.DeepEqual(names, want) {
+		t.Errorf("LookupAddr() = %v, want %v", names, want)
+	}
+}
+
+func TestLookupHostsFirst(t *testing.T) {
+	r, s := newTestResolver(t)
+	defer s.Close()
+	h, err := ParseHosts(strings.NewReader("10.9.9.9 boot\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Hosts = h
+
+	got, err := r.LookupIP(context.Background(), "boot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []net.IP{net.ParseIP("10.9.9.9")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LookupIP(boot) = %v, want %v", got, want)
+	}
+	if q := s.Queries(); len(q) != 0 {
+		t.Errorf("LookupIP(boot) sent %v, want no queries", q)
+	}
+}
+
+func TestLookupTimeout(t *testing.T) {
+	// A server that never answers.
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	r := New(&Config{Servers: []string{pc.LocalAddr().String()}, Timeout: 50 * time.Millisecond, Attempts: 2, Ndots: 1}, nil)
+	_, err = r.Lookup(context.Background(), "boot.example.", TypeA)
+	if e, ok := err.(*Error); !ok || !e.Timeout() {
+		t.Errorf("Lookup() = %v, want timeout", err)
+	}
+}
+
+func TestDialContext(t *testing.T) {
+	r, s := newTestResolver(t)
+	defer s.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	h, err := ParseHosts(strings.NewReader("127.0.0.1 svc.example\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Hosts = h
+
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	conn, err := r.DialContext(context.Background(), "tcp", net.JoinHostPort("svc.example", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,401 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package download fetches files over HTTP and HTTPS.
+//
+// A Downloader retries failed requests with exponential backoff and
+// resumes interrupted transfers with range requests, so that large images
+// can be fetched over unreliable links. DownloadFile continues partial
+// files left behind by earlier attempts.
+package download
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults for Downloader.
+const (
+	DefaultBackoff    = time.Second
+	DefaultMaxBackoff = time.Minute
+)
+
+// StatusError is returned for HTTP responses that are not successful.
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: %s", e.URL, e.Status)
+}
+
+// temporary reports whether the request may succeed when tried again.
+func (e *StatusError) temporary() bool {
+	switch e.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	}
+	return e.StatusCode >= 500
+}
+
+// Downloader fetches files. The zero value fetches with
+// http.DefaultClient without retrying.
+type Downloader struct {
+	// Client makes the requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// Header is added to every request.
+	Header http.Header
+
+	// Username and Password, if Username is set, are sent with basic
+	// authentication.
+	Username string
+	Password string
+
+	// Retries is how often a failed request is tried again, and how
+	// often an interrupted transfer is resumed in a row.
+	Retries int
+
+	// Backoff is the wait before the first retry, which doubles with
+	// each further retry up to MaxBackoff. They default to
+	// DefaultBackoff and DefaultMaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// Progress, if set, is called as data arrives with the bytes of the
+	// file received so far and its size, or -1 if it is unknown.
+	Progress func(done, total int64)
+}
+
+// NewClient returns an HTTP client that trusts the certificates in the
+// PEM files caFiles in addition to the system's. With insecure, server
+// certificates are not checked at all.
+func NewClient(caFiles []string, insecure bool) (*http.Client, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	for _, f := range caFiles {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("%s: no certificates found", f)
+		}
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = &tls.Config{
+		RootCAs:            pool,
+		InsecureSkipVerify: insecure,
+	}
+	return &http.Client{Transport: t}, nil
+}
+
+func (d *Downloader) client() *http.Client {
+	if d.Client != nil {
+		return d.Client
+	}
+	return http.DefaultClient
+}
+
+// sleep waits for the backoff before retry number n, which counts from 0.
+func (d *Downloader) sleep(ctx context.Context, n int) error {
+	b, max := d.Backoff, d.MaxBackoff
+	if b == 0 {
+		b = DefaultBackoff
+	}
+	if max == 0 {
+		max = DefaultMaxBackoff
+	}
+	for ; n > 0 && b < max; n-- {
+		b *= 2
+	}
+	if b > max {
+		b = max
+	}
+	t := time.NewTimer(b)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// get requests url from offset on, retrying temporary failures. If
+// validator is set, the range is only sent if the file has not changed.
+func (d *Downloader) get(ctx context.Context, url string, offset int64, validator string) (*http.Response, error) {
+	for n := 0; ; n++ {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(ctx)
+		for k, v := range d.Header {
+			req.Header[k] = v
+		}
+		if d.Username != "" {
+			req.SetBasicAuth(d.Username, d.Password)
+		}
+		if offset > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+			if validator != "" {
+				req.Header.Set("If-Range", validator)
+			}
+		}
+
+		resp, err := d.client().Do(req)
+		if err == nil && resp.StatusCode >= 300 && resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+			resp.Body.Close()
+			serr := &StatusError{URL: url, StatusCode: resp.StatusCode, Status: resp.Status}
+			if !serr.temporary() {
+				return nil, serr
+			}
+			err = serr
+		}
+		if err == nil {
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if n >= d.Retries {
+			return nil, err
+		}
+		if err := d.sleep(ctx, n); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// contentRange parses a Content-Range header of the form "bytes
+// FIRST-LAST/SIZE" or "bytes */SIZE". Unknown values are -1.
+func contentRange(s string) (first, size int64, err error) {
+	first, size = -1, -1
+	if !strings.HasPrefix(s, "bytes ") {
+		return 0, 0, fmt.Errorf("bad Content-Range %q", s)
+	}
+	s = strings.TrimPrefix(s, "bytes ")
+	i := strings.IndexByte(s, '/')
+	if i < 0 {
+		return 0, 0, fmt.Errorf("bad Content-Range %q", s)
+	}
+	if r := s[:i]; r != "*" {
+		j := strings.IndexByte(r, '-')
+		if j < 0 {
+			return 0, 0, fmt.Errorf("bad Content-Range %q", s)
+		}
+		if first, err = strconv.ParseInt(r[:j], 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("bad Content-Range %q", s)
+		}
+	}
+	if t := s[i+1:]; t != "*" {
+		if size, err = strconv.ParseInt(t, 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("bad Content-Range %q", s)
+		}
+	}
+	return first, size, nil
+}
+
+// Reader is the body of a file being downloaded. When the transfer is
+// interrupted, Read continues it where it stopped.
+type Reader struct {
+	d         *Downloader
+	ctx       context.Context
+	url       string
+	body      io.ReadCloser
+	start     int64
+	offset    int64
+	size      int64
+	validator string
+}
+
+// Open starts downloading url from offset on. If the server does not
+// support ranges, the download starts at 0 instead; Offset tells where it
+// starts.
+func (d *Downloader) Open(ctx context.Context, url string, offset int64) (*Reader, error) {
+	resp, err := d.get(ctx, url, offset, "")
+	if err != nil {
+		return nil, err
+	}
+	r := &Reader{d: d, ctx: ctx, url: url, body: resp.Body, size: -1}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		first, size, err := contentRange(resp.Header.Get("Content-Range"))
+		if err != nil || first != offset {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s: asked for bytes from %d, got %q", url, offset, resp.Header.Get("Content-Range"))
+		}
+		r.start, r.size = offset, size
+
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The file has been downloaded completely before.
+		resp.Body.Close()
+		if _, size, err := contentRange(resp.Header.Get("Content-Range")); err != nil || size != offset {
+			return nil, &StatusError{URL: url, StatusCode: resp.StatusCode, Status: resp.Status}
+		}
+		r.body = ioutil.NopCloser(strings.NewReader(""))
+		r.start, r.size = offset, offset
+
+	default:
+		r.size = resp.ContentLength
+	}
+	r.offset = r.start
+
+	// Only resume if the file will not have changed in the meantime.
+	if resp.Header.Get("Accept-Ranges") == "bytes" || resp.StatusCode == http.StatusPartialContent {
+		if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			r.validator = etag
+		} else {
+			r.validator = resp.Header.Get("Last-Modified")
+		}
+	}
+	return r, nil
+}
+
+// Offset returns where the download started.
+func (r *Reader) Offset() int64 {
+	return r.start
+}
+
+// Size returns the size of the whole file, or -1 if it is unknown.
+func (r *Reader) Size() int64 {
+	return r.size
+}
+
+// Read implements io.Reader.
+func (r *Reader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.body.Read(p)
+		r.offset += int64(n)
+		if n > 0 && r.d.Progress != nil {
+			r.d.Progress(r.offset, r.size)
+		}
+		if err == io.EOF && r.size >= 0 && r.offset < r.size {
+			err = io.ErrUnexpectedEOF
+		}
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+		if err := r.resume(err); err != nil {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// resume continues the download after err interrupted it.
+func (r *Reader) resume(err error) error {
+	if r.validator == "" || r.ctx.Err() != nil {
+		return err
+	}
+	r.body.Close()
+	r.body = ioutil.NopCloser(strings.NewReader(""))
+	for n := 0; n < r.d.Retries; n++ {
+		if err := r.d.sleep(r.ctx, n); err != nil {
+			return err
+		}
+		resp, gerr := r.d.get(r.ctx, r.url, r.offset, r.validator)
+		if gerr != nil {
+			err = gerr
+			continue
+		}
+		if resp.StatusCode != http.StatusPartialContent {
+			resp.Body.Close()
+			return fmt.Errorf("%s: cannot resume: %s", r.url, resp.Status)
+		}
+		if first, _, cerr := contentRange(resp.Header.Get("Content-Range")); cerr != nil || first != r.offset {
+			resp.Body.Close()
+			return fmt.Errorf("%s: cannot resume: asked for bytes from %d, got %q", r.url, r.offset, resp.Header.Get("Content-Range"))
+		}
+		r.body = resp.Body
+		return nil
+	}
+	return err
+}
+
+// Close closes the connection.
+func (r *Reader) Close() error {
+	return r.body.Close()
+}
+
+// Get writes the file at url to w.
+func (d *Downloader) Get(ctx context.Context, url string, w io.Writer) (int64, error) {
+	r, err := d.Open(ctx, url, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	return io.Copy(w, r)
+}
+
+// DownloadFile saves the file at url in file. With resume, the download
+// continues at the end of an existing file, if the server supports it.
+func (d *Downloader) DownloadFile(ctx context.Context, url, file string, resume bool) error {
+	var offset int64
+	if resume {
+		if fi, err := os.Stat(file); err == nil && fi.Mode().IsRegular() {
+			offset = fi.Size()
+		}
+	}
+	r, err := d.Open(ctx, url, offset)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+		if err := f.Truncate(r.Offset()); err != nil {
+			f.Close()
+			return err
+		}
+		if _, err := f.Seek(r.Offset(), io.SeekStart); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// CheckSHA256 reads r to the end and checks that its SHA-256 sum is the
+// hexadecimal sum.
+func CheckSHA256(r io.Reader, sum string) error {
+	want, err := hex.DecodeString(sum)
+	if err != nil || len(want) != sha256.Size {
+		return fmt.Errorf("bad SHA-256 sum %q", sum)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return err
+	}
+	if got := h.Sum(nil); string(got) != string(want) {
+		return fmt.Errorf("SHA-256 sum is %x, want %s", got, sum)
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package download
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var content = bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+
+// cutWriter aborts the connection after n bytes of the body.
+type cutWriter struct {
+	http.ResponseWriter
+	n int
+}
+
+func (w *cutWriter) Write(b []byte) (int, error) {
+	if len(b) > w.n {
+		w.ResponseWriter.Write(b[:w.n])
+		w.ResponseWriter.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	w.n -= len(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// testServer serves content at /file. The first fail requests get a 503,
+// and the first cut responses are cut off after 100000 bytes.
+type testServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	fail     int
+	cut      int
+	requests []*http.Request
+}
+
+func newTestServer(fail, cut int) *testServer {
+	s := &testServer{fail: fail, cut: cut}
+	modTime := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, r)
+		fail := s.fail > 0
+		if fail {
+			s.fail--
+		}
+		cut := !fail && s.cut > 0
+		if cut {
+			s.cut--
+		}
+		s.mu.Unlock()
+
+		if user, pass, ok := r.BasicAuth(); ok && (user != "user" || pass != "pass") {
+			http.Error(w, "bad password", http.StatusUnauthorized)
+			return
+		}
+		if fail {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		if cut {
+			w = &cutWriter{ResponseWriter: w, n: 100000}
+		}
+		switch r.URL.Path {
+		case "/file":
+			http.ServeContent(w, r, "file", modTime, bytes.NewReader(content))
+		case "/norange":
+			w.Write(content)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return s
+}
+
+func (s *testServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.requests)
+}
+
+func testDownloader() *Downloader {
+	return &Downloader{Retries: 3, Backoff: time.Millisecond, MaxBackoff: 4 * time.Millisecond}
+}
+
+func TestGet(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		fail, cut int
+		path      string
+		requests  int
+	}{
+		{name: "plain", path: "/file", requests: 1},
+		{name: "retry", fail: 2, path: "/file", requests: 3},
+		{name: "resume", cut: 2, path: "/file", requests: 3},
+		{name: "both", fail: 1, cut: 1, path: "/file", requests: 3},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(tt.fail, tt.cut)
+			defer s.Close()
+
+			var progress []int64
+			d := testDownloader()
+			d.Progress = func(done, total int64) {
+				if total != int64(len(content)) {
+					t.Errorf("Progress total = %d, want %d", total, len(content))
+				}
+				progress = append(progress, done)
+			}
+			var b bytes.Buffer
+			if _, err := d.Get(context.Background(), s.URL+tt.path, &b); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b.Bytes(), content) {
+				t.Errorf("Get() = %d bytes, want %d", b.Len(), len(content))
+			}
+			if got := s.count(); got != tt.requests {
+				t.Errorf("server got %d requests, want %d", got, tt.requests)
+			}
+			if len(progress) == 0 || progress[len(progress)-1] != int64(len(content)) {
+				t.Errorf("Progress ended at %v, want %d", progress, len(content))
+			}
+		})
+	}
+}
+
+func TestGetErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		fail, cut int
+		path      string
+		requests  int
+	}{
+		{name: "not found", path: "/missing", requests: 1},
+		{name: "too many failures", fail: 10, path: "/file", requests: 4},
+		{name: "no ranges", cut: 1, path: "/norange", requests: 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(tt.fail, tt.cut)
+			defer s.Close()
+			var b bytes.Buffer
+			if _, err := testDownloader().Get(context.Background(), s.URL+tt.path, &b); err == nil {
+				t.Errorf("Get() succeeded, want error")
+			}
+			if got := s.count(); got != tt.requests {
+				t.Errorf("server got %d requests, want %d", got, tt.requests)
+			}
+		})
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	s := newTestServer(0, 0)
+	defer s.Close()
+
+	d := testDownloader()
+	d.Header = http.Header{"X-Test": {"yes"}}
+	d.Username, d.Password = "user", "pass"
+	if _, err := d.Get(context.Background(), s.URL+"/file", ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	r := s.requests[0]
+	if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" || r.Header.Get("X-Test") != "yes" {
+		t.Errorf("request headers = %v, want basic auth and X-Test", r.Header)
+	}
+
+	d.Password = "wrong"
+	if _, err := d.Get(context.Background(), s.URL+"/file", ioutil.Discard); err == nil {
+		t.Errorf("Get() with a bad password succeeded, want error")
+	} else if serr, ok := err.(*StatusError); !ok || serr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Get() with a bad password = %v, want 401", err)
+	}
+}
+
+func TestDownloadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := newTestServer(0, 0)
+	defer s.Close()
+
+	for _, tt := range []struct {
+		name    string
+		path    string
+		partial []byte
+		resume  bool
+		offset  string
+	}{
+		{name: "new", path: "/file"},
+		{name: "resume", path: "/file", partial: content[:12345], resume: true, offset: "bytes=12345-"},
+		{name: "complete", path: "/file", partial: content, resume: true, offset: "bytes=1048576-"},
+		{name: "no resume", path: "/file", partial: []byte("garbage"), resume: false},
+		{name: "no ranges", path: "/norange", partial: []byte("garbage"), resume: true, offset: "bytes=7-"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(dir, strings.Replace(tt.name, " ", "_", -1))
+			if tt.partial != nil {
+				if err := ioutil.WriteFile(file, tt.partial, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			n := s.count()
+			if err := testDownloader().DownloadFile(context.Background(), s.URL+tt.path, file, tt.resume); err != nil {
+				t.Fatal(err)
+			}
+			if got := s.requests[n].Header.Get("Range"); got != tt.offset {
+				t.Errorf("Range = %q, want %q", got, tt.offset)
+			}
+			f, err := os.Open(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			sum := sha256.Sum256(content)
+			if err := CheckSHA256(f, hex.EncodeToString(sum[:])); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestContentRange(t *testing.T) {
+	for s, want := range map[string][2]int64{
+		"bytes 0-9/10":   {0, 10},
+		"bytes 5-9/*":    {5, -1},
+		"bytes */100":    {-1, 100},
+		"bytes 100-199/": {0, 0},
+		"items 0-9/10":   {0, 0},
+		"bytes 0-9":      {0, 0},
+	} {
+		first, size, err := contentRange(s)
+		if want == [2]int64{0, 0} {
+			if err == nil {
+				t.Errorf("contentRange(%q) succeeded, want error", s)
+			}
+			continue
+		}
+		if err != nil || first != want[0] || size != want[1] {
+			t.Errorf("contentRange(%q) = %d, %d, %v, want %d, %d", s, first, size, err, want[0], want[1])
+		}
+	}
+}
+
+func TestCheckSHA256(t *testing.T) {
+	const empty = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	if err := CheckSHA256(strings.NewReader(""), empty); err != nil {
+		t.Error(err)
+	}
+	if err := CheckSHA256(strings.NewReader("x"), empty); err == nil {
+		t.Errorf("CheckSHA256(wrong content) succeeded, want error")
+	}
+	if err := CheckSHA256(strings.NewReader(""), "abc"); err == nil {
+		t.Errorf("CheckSHA256(bad sum) succeeded, want error")
+	}
+}
+
+func TestProgress(t *testing.T) {
+	var b bytes.Buffer
+	p := NewProgress(&b)
+	now := time.Unix(0, 0)
+	p.now = func() time.Time { return now }
+	p.Update(0, 4<<20)
+	now = now.Add(100 * time.Millisecond)
+	p.Update(1<<20, 4<<20)
+	now = now.Add(900 * time.Millisecond)
+	p.Update(2<<20, 4<<20)
+	p.Done()
+	lines := strings.Split(b.String(), "\r")
+	// The update after 100ms is not drawn.
+	if len(lines) != 4 {
+		t.Fatalf("Progress drew %q, want 3 lines", b.String())
+	}
+	if got, want := strings.TrimSpace(lines[2]), "50% 2.0MiB / 4.0MiB 2.0MiB/s eta 1s"; got != want {
+		t.Errorf("Progress = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package download
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Progress shows the progress of a download on a terminal line.
+type Progress struct {
+	w        io.Writer
+	interval time.Duration
+	now      func() time.Time
+
+	start   time.Time
+	last    time.Time
+	first   int64
+	done    int64
+	total   int64
+	started bool
+}
+
+// NewProgress returns a Progress writing to w. Its Update method is meant
+// to be used as Downloader.Progress.
+func NewProgress(w io.Writer) *Progress {
+	return &Progress{w: w, interval: 200 * time.Millisecond, now: time.Now}
+}
+
+// size formats n bytes for humans.
+func size(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 4; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTP"[exp])
+}
+
+// Update records that done of total bytes have arrived. The line is
+// redrawn at most every 200ms.
+func (p *Progress) Update(done, total int64) {
+	now := p.now()
+	if !p.started {
+		p.start, p.first, p.started = now, done, true
+	}
+	p.done, p.total = done, total
+	if now.Sub(p.last) < p.interval && (total < 0 || done < total) {
+		return
+	}
+	p.last = now
+	p.draw(now)
+}
+
+func (p *Progress) draw(now time.Time) {
+	line := size(p.done)
+	if p.total >= 0 {
+		pct := int64(100)
+		if p.total > 0 {
+			pct = p.done * 100 / p.total
+		}
+		line = fmt.Sprintf("%3d%% %s / %s", pct, line, size(p.total))
+	}
+	if d := now.Sub(p.start); d > 0 {
+		rate := float64(p.done-p.first) / d.Seconds()
+		line += fmt.Sprintf(" %s/s", size(int64(rate)))
+		if p.total > p.done && rate > 0 {
+			eta := time.Duration(float64(p.total-p.done)/rate) * time.Second
+			line += fmt.Sprintf(" eta %v", eta.Round(time.Second))
+		}
+	}
+	fmt.Fprintf(p.w, "\r%-60s", line)
+}
+
+// Done finishes the line.
+func (p *Progress) Done() {
+	if p.started {
+		p.draw(p.now())
+		fmt.Fprintln(p.w)
+	}
+}
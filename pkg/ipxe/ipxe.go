@@ -55,6 +55,21 @@ func NewConfigWithSchemes(configURL *url.URL, s pxe.Schemes) (*Config, error) {
 	return c, nil
 }
 
+// ParseConfig parses the ipxe script `config`, getting the files it refers
+// to with schemes `s`.
+func ParseConfig(config string, s pxe.Schemes) (*Config, error) {
+	if !strings.HasPrefix(config, "#!ipxe") {
+		return nil, ErrNotIpxeScript
+	}
+	c := &Config{
+		schemes: s,
+	}
+	if err := c.parseIpxe(config); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
 // getAndParse parses the config file downloaded from `url` and fills in `c`.
 func (c *Config) getAndParseFile(u *url.URL) error {
 	r, err := c.schemes.LazyGetFile(u)
@@ -0,0 +1,244 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package iscsi
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// IBFTPath is where the kernel shows the iSCSI Boot Firmware Table.
+const IBFTPath = "/sys/firmware/ibft"
+
+// Flags of iBFT targets and NICs.
+const (
+	ibftValid        = 1 << 0
+	ibftBootSelected = 1 << 1
+)
+
+// IBFT is the iSCSI boot configuration of the firmware.
+type IBFT struct {
+	Initiator string
+	NICs      []IBFTNIC
+	Targets   []IBFTTarget
+}
+
+// IBFTNIC is a network interface of the iBFT.
+type IBFTNIC struct {
+	Index int
+	MAC   net.HardwareAddr
+	// IP is the address of the NIC, or nil if it was configured by DHCP.
+	IP      *net.IPNet
+	Gateway net.IP
+	DNS     []net.IP
+}
+
+// IBFTTarget is a target of the iBFT.
+type IBFTTarget struct {
+	Target
+
+	// NIC is the index of the NIC the target is reached by.
+	NIC int
+
+	// Boot is set for the target the firmware booted from.
+	Boot bool
+}
+
+// ReadIBFT reads the iBFT the kernel shows in dir, usually IBFTPath. Only
+// valid targets and NICs are returned.
+func ReadIBFT(dir string) (*IBFT, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, "initiator", "initiator-name"))
+	if err != nil {
+		return nil, fmt.Errorf("iscsi: no iBFT: %v", err)
+	}
+	initiator := strings.TrimSpace(string(b))
+	t := &IBFT{Initiator: initiator}
+
+	nics, _ := filepath.Glob(filepath.Join(dir, "ethernet*"))
+	for _, d := range nics {
+		n, err := readIBFTNIC(d)
+		if err != nil {
+			return nil, fmt.Errorf("iscsi: iBFT %s: %v", filepath.Base(d), err)
+		}
+		if n != nil {
+			t.NICs = append(t.NICs, *n)
+		}
+	}
+	targets, _ := filepath.Glob(filepath.Join(dir, "target*"))
+	for _, d := range targets {
+		tgt, err := readIBFTTarget(d)
+		if err != nil {
+			return nil, fmt.Errorf("iscsi: iBFT %s: %v", filepath.Base(d), err)
+		}
+		if tgt != nil {
+			t.Targets = append(t.Targets, *tgt)
+		}
+	}
+	return t, nil
+}
+
+// ibftString returns the contents of the file name in dir, or "" if the
+// firmware did not set it.
+func ibftString(dir, name string) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return strings.TrimSpace(string(b)), err
+}
+
+func ibftInt(dir, name string) (int, error) {
+	s, err := ibftString(dir, name)
+	if err != nil || s == "" {
+		return 0, err
+	}
+	return strconv.Atoi(s)
+}
+
+// ibftIP returns the address in the file name in dir, or nil if it is not
+// set. The firmware uses 0.0.0.0 for no address.
+func ibftIP(dir, name string) (net.IP, error) {
+	s, err := ibftString(dir, name)
+	if err != nil || s == "" {
+		return nil, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("bad %s %q", name, s)
+	}
+	if ip.IsUnspecified() {
+		return nil, nil
+	}
+	return ip, nil
+}
+
+func readIBFTNIC(dir string) (*IBFTNIC, error) {
+	flags, err := ibftInt(dir, "flags")
+	if err != nil || flags&ibftValid == 0 {
+		return nil, err
+	}
+	n := &IBFTNIC{}
+	if n.Index, err = ibftInt(dir, "index"); err != nil {
+		return nil, err
+	}
+	mac, err := ibftString(dir, "mac")
+	if err != nil {
+		return nil, err
+	}
+	if n.MAC, err = net.ParseMAC(mac); err != nil {
+		return nil, err
+	}
+
+	ip, err := ibftIP(dir, "ip-addr")
+	if err != nil {
+		return nil, err
+	}
+	if ip != nil {
+		bits := 8 * net.IPv4len
+		if ip.To4() == nil {
+			bits = 8 * net.IPv6len
+		}
+		prefix, err := ibftInt(dir, "prefix-len")
+		if err != nil {
+			return nil, err
+		}
+		if prefix == 0 {
+			// Older kernels only show the mask.
+			mask, err := ibftIP(dir, "subnet-mask")
+			if err != nil {
+				return nil, err
+			}
+			if mask != nil {
+				prefix, _ = net.IPMask(mask.To4()).Size()
+			}
+		}
+		n.IP = &net.IPNet{IP: ip, Mask: net.CIDRMask(prefix, bits)}
+	}
+	if n.Gateway, err = ibftIP(dir, "gateway"); err != nil {
+		return nil, err
+	}
+	for _, name := range []string{"primary-dns", "secondary-dns"} {
+		dns, err := ibftIP(dir, name)
+		if err != nil {
+			return nil, err
+		}
+		if dns != nil {
+			n.DNS = append(n.DNS, dns)
+		}
+	}
+	return n, nil
+}
+
+func readIBFTTarget(dir string) (*IBFTTarget, error) {
+	flags, err := ibftInt(dir, "flags")
+	if err != nil || flags&ibftValid == 0 {
+		return nil, err
+	}
+	t := &IBFTTarget{Boot: flags&ibftBootSelected != 0}
+	ip, err := ibftIP(dir, "ip-addr")
+	if err != nil {
+		return nil, err
+	}
+	if ip == nil {
+		return nil, fmt.Errorf("no ip-addr")
+	}
+	port, err := ibftInt(dir, "port")
+	if err != nil {
+		return nil, err
+	}
+	if port == 0 {
+		port = DefaultPort
+	}
+	t.Addr = net.JoinHostPort(ip.String(), strconv.Itoa(port))
+	if t.NIC, err = ibftInt(dir, "nic-assoc"); err != nil {
+		return nil, err
+	}
+	lun, err := ibftString(dir, "lun")
+	if err != nil {
+		return nil, err
+	}
+	if t.LUN, err = parseIBFTLUN(lun); err != nil {
+		return nil, err
+	}
+	for _, f := range []struct {
+		name string
+		v    *string
+	}{
+		{"target-name", &t.Name},
+		{"chap-name", &t.User},
+		{"chap-secret", &t.Secret},
+	} {
+		if *f.v, err = ibftString(dir, f.name); err != nil {
+			return nil, err
+		}
+	}
+	if t.Name == "" {
+		return nil, fmt.Errorf("no target-name")
+	}
+	return t, nil
+}
+
+// parseIBFTLUN parses the LUN as the kernel shows it: its 8 bytes, each
+// in hex without leading zeros. Only LUNs of the peripheral device
+// addressing method, below 256, are understood, which are 0 followed by
+// the LUN in the second byte.
+func parseIBFTLUN(s string) (int, error) {
+	if s == "" || strings.Trim(s, "0") == "" {
+		return 0, nil
+	}
+	if len(s) < 8 || s[0] != '0' || !strings.HasSuffix(s, "000000") {
+		return 0, fmt.Errorf("unsupported LUN %q", s)
+	}
+	n, err := strconv.ParseUint(s[1:len(s)-6], 16, 8)
+	if err != nil {
+		return 0, fmt.Errorf("unsupported LUN %q", s)
+	}
+	return int(n), nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package iscsi
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadIBFT(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ibft")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, v := range map[string]string{
+		"initiator/initiator-name": "iqn.2019-06.org.example:host\n",
+		"ethernet0/index":          "0\n",
+		"ethernet0/flags":          "3\n",
+		"ethernet0/mac":            "52:54:00:12:34:56\n",
+		"ethernet0/ip-addr":        "192.168.1.10\n",
+		"ethernet0/subnet-mask":    "255.255.255.0\n",
+		"ethernet0/gateway":        "192.168.1.1\n",
+		"ethernet0/primary-dns":    "192.168.1.2\n",
+		"ethernet0/secondary-dns":  "0.0.0.0\n",
+		"target0/flags":            "3\n",
+		"target0/ip-addr":          "192.168.1.20\n",
+		"target0/port":             "3260\n",
+		"target0/lun":              "02000000\n",
+		"target0/nic-assoc":        "0\n",
+		"target0/target-name":      "iqn.2019-06.org.example:disk\n",
+		"target0/chap-name":        "user\n",
+		"target0/chap-secret":      "secretsecret\n",
+		// Not valid.
+		"target1/flags": "0\n",
+	} {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(v), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ibft, err := ReadIBFT(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ibft.Initiator != "iqn.2019-06.org.example:host" {
+		t.Errorf("Initiator = %q", ibft.Initiator)
+	}
+	if len(ibft.NICs) != 1 {
+		t.Fatalf("NICs = %v, want one", ibft.NICs)
+	}
+	n := ibft.NICs[0]
+	if n.MAC.String() != "52:54:00:12:34:56" || n.IP.String() != "192.168.1.10/24" ||
+		n.Gateway.String() != "192.168.1.1" || len(n.DNS) != 1 {
+		t.Errorf("NIC = %+v", n)
+	}
+	want := []IBFTTarget{{
+		Target: Target{
+			Addr:   "192.168.1.20:3260",
+			Name:   "iqn.2019-06.org.example:disk",
+			LUN:    2,
+			User:   "user",
+			Secret: "secretsecret",
+		},
+		Boot: true,
+	}}
+	if !reflect.DeepEqual(ibft.Targets, want) {
+		t.Errorf("Targets = %+v, want %+v", ibft.Targets, want)
+	}
+
+	if _, err := ReadIBFT(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("ReadIBFT(missing) succeeded")
+	}
+}
+
+func TestParseIBFTLUN(t *testing.T) {
+	for s, want := range map[string]int{
+		"":          0,
+		"00000000":  0,
+		"01000000":  1,
+		"0ff000000": 255,
+	} {
+		if got, err := parseIBFTLUN(s); got != want || err != nil {
+			t.Errorf("parseIBFTLUN(%q) = %d, %v, want %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"40010000", "1000000"} {
+		if _, err := parseIBFTLUN(s); err == nil {
+			t.Errorf("parseIBFTLUN(%q) succeeded", s)
+		}
+	}
+}
@@ -0,0 +1,393 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package iscsi implements a minimal iSCSI initiator.
+//
+// The login, with CHAP if the target asks for it, is done here over TCP. On
+// Linux, the logged in connection is then handed to the kernel's iscsi_tcp
+// driver, which makes the target's logical units SCSI disks.
+//
+// See RFC 7143.
+package iscsi
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPort is the TCP port of iSCSI targets.
+const DefaultPort = 3260
+
+// Target is a target to log in to.
+type Target struct {
+	// Addr is the host:port of the target's portal.
+	Addr string
+
+	// Name is the iSCSI name of the target, such as
+	// iqn.2019-06.org.example:disk.
+	Name string
+
+	// LUN is the logical unit to use.
+	LUN int
+
+	// User and Secret are the CHAP credentials, if the target needs them.
+	User   string
+	Secret string
+}
+
+// Login stages.
+const (
+	securityStage    = 0
+	operationalStage = 1
+	fullFeatureStage = 3
+)
+
+// Login PDU opcodes and flags.
+const (
+	opLoginRequest  = 0x03
+	opLoginResponse = 0x23
+	opImmediate     = 0x40
+	flagTransit     = 0x80
+	flagContinue    = 0x40
+)
+
+// bhsLen is the length of the basic header segment of PDUs.
+const bhsLen = 48
+
+// maxRecvDataSegmentLength is the largest data segment we take.
+const maxRecvDataSegmentLength = 262144
+
+// operationalKeys are the keys offered in the operational stage. The target
+// answers with what is used.
+var operationalKeys = []string{
+	"HeaderDigest=None",
+	"DataDigest=None",
+	"MaxRecvDataSegmentLength=" + strconv.Itoa(maxRecvDataSegmentLength),
+	"MaxConnections=1",
+	"InitialR2T=No",
+	"ImmediateData=Yes",
+	"MaxBurstLength=16776192",
+	"FirstBurstLength=262144",
+	"DefaultTime2Wait=0",
+	"DefaultTime2Retain=0",
+	"MaxOutstandingR2T=1",
+	"DataPDUInOrder=Yes",
+	"DataSequenceInOrder=Yes",
+	"ErrorRecoveryLevel=0",
+}
+
+// Session is a logged in session of a single connection.
+type Session struct {
+	Target    *Target
+	Initiator string
+
+	// ISID and TSIH identify the session.
+	ISID [6]byte
+	TSIH uint16
+
+	// CmdSN is the next command sequence number, and ExpStatSN the next
+	// status sequence number.
+	CmdSN     uint32
+	ExpStatSN uint32
+
+	// Params are the negotiated operational keys, and those the target
+	// declared: TargetPortalGroupTag and, as
+	// TargetMaxRecvDataSegmentLength, its MaxRecvDataSegmentLength.
+	Params map[string]string
+
+	conn net.Conn
+}
+
+// Dial connects to the target t and logs in as initiator.
+func Dial(initiator string, t *Target, timeout time.Duration) (*Session, error) {
+	conn, err := net.DialTimeout("tcp", t.Addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	s, err := Login(conn, initiator, t)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return s, nil
+}
+
+// Login logs in to the target t as initiator over conn.
+func Login(conn net.Conn, initiator string, t *Target) (*Session, error) {
+	s := &Session{
+		Target:    t,
+		Initiator: initiator,
+		// An OUI format ISID, as open-iscsi uses.
+		ISID:   [6]byte{0x00, 0x02, 0x3d, 0x00, 0x00, 0x01},
+		Params: make(map[string]string),
+		conn:   conn,
+	}
+	for _, kv := range operationalKeys {
+		s.setParam(kv)
+	}
+	if err := s.login(); err != nil {
+		return nil, fmt.Errorf("iscsi: logging in to %s at %s: %v", t.Name, t.Addr, err)
+	}
+	return s, nil
+}
+
+func (s *Session) setParam(kv string) {
+	i := strings.IndexByte(kv, '=')
+	if i < 0 {
+		return
+	}
+	k, v := kv[:i], kv[i+1:]
+	switch v {
+	case "Irrelevant", "NotUnderstood", "Reject":
+		return
+	}
+	// The target's MaxRecvDataSegmentLength is declared, not negotiated.
+	if k == "MaxRecvDataSegmentLength" && s.Params[k] != "" {
+		k = "TargetMaxRecvDataSegmentLength"
+	}
+	s.Params[k] = v
+}
+
+// response is a login response.
+type response struct {
+	transit bool
+	nsg     uint8
+	keys    map[string]string
+}
+
+// send sends a login request with the text keys.
+func (s *Session) send(csg, nsg uint8, transit bool, keys []string) error {
+	var data []byte
+	for _, kv := range keys {
+		data = append(data, kv...)
+		data = append(data, 0)
+	}
+	pdu := make([]byte, bhsLen, bhsLen+len(data)+3)
+	pdu[0] = opImmediate | opLoginRequest
+	pdu[1] = csg << 2
+	if transit {
+		pdu[1] |= flagTransit | nsg
+	}
+	binary.BigEndian.PutUint32(pdu[4:], uint32(len(data)))
+	copy(pdu[8:14], s.ISID[:])
+	binary.BigEndian.PutUint16(pdu[14:], s.TSIH)
+	binary.BigEndian.PutUint32(pdu[24:], s.CmdSN)
+	binary.BigEndian.PutUint32(pdu[28:], s.ExpStatSN)
+	pdu = append(pdu, data...)
+	for len(pdu)%4 != 0 {
+		pdu = append(pdu, 0)
+	}
+	_, err := s.conn.Write(pdu)
+	return err
+}
+
+// recv reads a login response PDU, returning its flags and data.
+func (s *Session) recv() (byte, []byte, error) {
+	bhs := make([]byte, bhsLen)
+	if _, err := io.ReadFull(s.conn, bhs); err != nil {
+		return 0, nil, err
+	}
+	if bhs[0]&0x3f != opLoginResponse {
+		return 0, nil, fmt.Errorf("unexpected opcode %#x", bhs[0]&0x3f)
+	}
+	if class, detail := bhs[36], bhs[37]; class != 0 {
+		return 0, nil, &loginError{class: class, detail: detail}
+	}
+	s.TSIH = binary.BigEndian.Uint16(bhs[14:])
+	s.ExpStatSN = binary.BigEndian.Uint32(bhs[24:]) + 1
+	s.CmdSN = binary.BigEndian.Uint32(bhs[28:])
+
+	ahsLen := int(bhs[4]) * 4
+	dataLen := int(binary.BigEndian.Uint32(bhs[4:]) & 0xffffff)
+	rest := make([]byte, ahsLen+(dataLen+3)&^3)
+	if _, err := io.ReadFull(s.conn, rest); err != nil {
+		return 0, nil, err
+	}
+	return bhs[1], rest[ahsLen : ahsLen+dataLen], nil
+}
+
+// exchange sends a login request and reads the response, which the target
+// may split over several PDUs.
+func (s *Session) exchange(csg, nsg uint8, transit bool, keys []string) (*response, error) {
+	if err := s.send(csg, nsg, transit, keys); err != nil {
+		return nil, err
+	}
+	var data []byte
+	for {
+		flags, d, err := s.recv()
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, d...)
+		if flags&flagContinue == 0 {
+			r := &response{
+				transit: flags&flagTransit != 0,
+				nsg:     flags & 3,
+				keys:    make(map[string]string),
+			}
+			for _, kv := range bytes.Split(data, []byte{0}) {
+				if i := bytes.IndexByte(kv, '='); i > 0 {
+					r.keys[string(kv[:i])] = string(kv[i+1:])
+				}
+			}
+			return r, nil
+		}
+		if err := s.send(csg, nsg, false, nil); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (s *Session) login() error {
+	keys := []string{
+		"InitiatorName=" + s.Initiator,
+		"SessionType=Normal",
+		"TargetName=" + s.Target.Name,
+	}
+	r, err := s.security(keys)
+	if err != nil {
+		return err
+	}
+
+	// Only the first request of the operational stage offers the keys.
+	// Targets may also skip the stage.
+	offer := operationalKeys
+	for !r.transit || r.nsg != fullFeatureStage {
+		r, err = s.exchange(operationalStage, fullFeatureStage, true, offer)
+		if err != nil {
+			return err
+		}
+		for k, v := range r.keys {
+			s.setParam(k + "=" + v)
+		}
+		offer = nil
+	}
+	return nil
+}
+
+// security does the security stage, returning the response that ends it.
+func (s *Session) security(keys []string) (*response, error) {
+	chap := s.Target.User != ""
+	methods := "AuthMethod=None"
+	if chap {
+		methods = "AuthMethod=CHAP,None"
+	}
+	r, err := s.exchange(securityStage, operationalStage, !chap, append(keys, methods))
+	if err != nil {
+		return nil, err
+	}
+	if tag, ok := r.keys["TargetPortalGroupTag"]; ok {
+		s.Params["TargetPortalGroupTag"] = tag
+	}
+	switch r.keys["AuthMethod"] {
+	case "None":
+		if !r.transit {
+			r, err = s.exchange(securityStage, operationalStage, true, nil)
+		}
+		return r, err
+	case "CHAP":
+		if !chap {
+			return nil, fmt.Errorf("target wants CHAP, but no credentials were given")
+		}
+	default:
+		return nil, fmt.Errorf("no authentication method agreed: %q", r.keys["AuthMethod"])
+	}
+
+	r, err = s.exchange(securityStage, operationalStage, false, []string{"CHAP_A=5"})
+	if err != nil {
+		return nil, err
+	}
+	if r.keys["CHAP_A"] != "5" {
+		return nil, fmt.Errorf("target wants CHAP algorithm %q, not MD5", r.keys["CHAP_A"])
+	}
+	resp, err := chapResponse(r.keys["CHAP_I"], r.keys["CHAP_C"], s.Target.Secret)
+	if err != nil {
+		return nil, err
+	}
+	r, err = s.exchange(securityStage, operationalStage, true, []string{
+		"CHAP_N=" + s.Target.User,
+		"CHAP_R=" + resp,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for !r.transit {
+		if r, err = s.exchange(securityStage, operationalStage, true, nil); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// chapResponse returns the CHAP response to the challenge c with the
+// identifier id: MD5(id || secret || challenge), in hex.
+func chapResponse(id, c, secret string) (string, error) {
+	n, err := strconv.ParseUint(id, 10, 8)
+	if err != nil {
+		return "", fmt.Errorf("bad CHAP_I %q", id)
+	}
+	challenge, err := decodeBinary(c)
+	if err != nil || len(challenge) == 0 {
+		return "", fmt.Errorf("bad CHAP_C %q", c)
+	}
+	h := md5.New()
+	h.Write([]byte{byte(n)})
+	h.Write([]byte(secret))
+	h.Write(challenge)
+	return "0x" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// decodeBinary decodes a binary value, in hex with 0x or in base64 with 0b.
+func decodeBinary(v string) ([]byte, error) {
+	if len(v) < 2 {
+		return nil, fmt.Errorf("short binary value")
+	}
+	switch strings.ToLower(v[:2]) {
+	case "0x":
+		return hex.DecodeString(v[2:])
+	case "0b":
+		return base64.StdEncoding.DecodeString(v[2:])
+	}
+	return nil, fmt.Errorf("no 0x or 0b prefix")
+}
+
+// loginError is a login refused by the target.
+type loginError struct {
+	class  uint8
+	detail uint8
+}
+
+var loginErrors = map[uint16]string{
+	0x0101: "target moved temporarily",
+	0x0102: "target moved permanently",
+	0x0200: "initiator error",
+	0x0201: "authentication failure",
+	0x0202: "authorization failure",
+	0x0203: "target not found",
+	0x0204: "target removed",
+	0x0205: "unsupported version",
+	0x0206: "too many connections",
+	0x0207: "missing parameter",
+	0x0300: "target error",
+	0x0301: "service unavailable",
+	0x0302: "out of resources",
+}
+
+func (e *loginError) Error() string {
+	if s, ok := loginErrors[uint16(e.class)<<8|uint16(e.detail)]; ok {
+		return s
+	}
+	return fmt.Sprintf("login status %#02x%02x", e.class, e.detail)
+}
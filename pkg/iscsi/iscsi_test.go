@@ -0,0 +1,248 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package iscsi
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// target is the login side of an iSCSI target.
+type target struct {
+	name   string
+	user   string
+	secret string
+	// split sends responses in two PDUs.
+	split bool
+
+	statSN uint32
+	// requests are the keys of the requests, one string each.
+	requests []string
+}
+
+func (t *target) recv(conn net.Conn) (byte, map[string]string, error) {
+	bhs := make([]byte, bhsLen)
+	if _, err := io.ReadFull(conn, bhs); err != nil {
+		return 0, nil, err
+	}
+	if bhs[0] != opImmediate|opLoginRequest {
+		return 0, nil, fmt.Errorf("opcode %#x", bhs[0])
+	}
+	if got := binary.BigEndian.Uint32(bhs[28:]); got != t.statSN {
+		return 0, nil, fmt.Errorf("ExpStatSN %d, want %d", got, t.statSN)
+	}
+	n := binary.BigEndian.Uint32(bhs[4:])
+	data := make([]byte, (n+3)&^3)
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return 0, nil, err
+	}
+	keys := make(map[string]string)
+	var kv []string
+	for _, s := range strings.Split(string(data[:n]), "\x00") {
+		if i := strings.IndexByte(s, '='); i > 0 {
+			keys[s[:i]] = s[i+1:]
+			kv = append(kv, s)
+		}
+	}
+	t.requests = append(t.requests, strings.Join(kv, " "))
+	return bhs[1], keys, nil
+}
+
+func (t *target) send(conn net.Conn, flags byte, status uint16, keys ...string) error {
+	var data []byte
+	for _, kv := range keys {
+		data = append(data, kv...)
+		data = append(data, 0)
+	}
+	var parts [][]byte
+	if t.split && len(data) > 1 {
+		parts = [][]byte{data[:len(data)/2], data[len(data)/2:]}
+	} else {
+		parts = [][]byte{data}
+	}
+	for i, d := range parts {
+		pdu := make([]byte, bhsLen)
+		pdu[0] = opLoginResponse
+		pdu[1] = flags
+		if i < len(parts)-1 {
+			pdu[1] = flags&^(flagTransit|3) | flagContinue
+		}
+		binary.BigEndian.PutUint32(pdu[4:], uint32(len(d)))
+		binary.BigEndian.PutUint16(pdu[14:], 0x1234)
+		binary.BigEndian.PutUint32(pdu[24:], t.statSN)
+		binary.BigEndian.PutUint32(pdu[28:], 7)
+		binary.BigEndian.PutUint16(pdu[36:], status)
+		t.statSN++
+		pdu = append(pdu, d...)
+		for len(pdu)%4 != 0 {
+			pdu = append(pdu, 0)
+		}
+		if _, err := conn.Write(pdu); err != nil {
+			return err
+		}
+		if i < len(parts)-1 {
+			// The initiator asks for the rest.
+			if _, _, err := t.recv(conn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+const challenge = "0x0102030405060708090a0b0c0d0e0f10"
+
+func (t *target) serve(conn net.Conn) error {
+	defer conn.Close()
+	const sec, op = securityStage << 2, operationalStage << 2
+	const toOp, toFull = flagTransit | operationalStage, flagTransit | fullFeatureStage
+
+	_, keys, err := t.recv(conn)
+	if err != nil {
+		return err
+	}
+	if keys["TargetName"] != t.name {
+		return t.send(conn, 0, 0x0203)
+	}
+	if t.user == "" {
+		if err := t.send(conn, sec|toOp, 0, "TargetPortalGroupTag=1", "AuthMethod=None"); err != nil {
+			return err
+		}
+	} else {
+		if !strings.Contains(keys["AuthMethod"], "CHAP") {
+			return t.send(conn, 0, 0x0201)
+		}
+		if err := t.send(conn, sec, 0, "TargetPortalGroupTag=1", "AuthMethod=CHAP"); err != nil {
+			return err
+		}
+		if _, _, err := t.recv(conn); err != nil {
+			return err
+		}
+		if err := t.send(conn, sec, 0, "CHAP_A=5", "CHAP_I=42", "CHAP_C="+challenge); err != nil {
+			return err
+		}
+		_, keys, err := t.recv(conn)
+		if err != nil {
+			return err
+		}
+		c, _ := hex.DecodeString(challenge[2:])
+		sum := md5.Sum(append(append([]byte{42}, t.secret...), c...))
+		if keys["CHAP_N"] != t.user || keys["CHAP_R"] != "0x"+hex.EncodeToString(sum[:]) {
+			return t.send(conn, 0, 0x0201)
+		}
+		if err := t.send(conn, sec|toOp, 0); err != nil {
+			return err
+		}
+	}
+
+	flags, _, err := t.recv(conn)
+	if err != nil {
+		return err
+	}
+	if flags != op|toFull {
+		return fmt.Errorf("operational request flags %#x", flags)
+	}
+	return t.send(conn, op|toFull, 0,
+		"HeaderDigest=None", "DataDigest=None", "MaxRecvDataSegmentLength=65536",
+		"InitialR2T=Yes", "ImmediateData=Yes", "MaxBurstLength=262144",
+		"FirstBurstLength=65536", "DefaultTime2Wait=2", "DefaultTime2Retain=0",
+		"MaxOutstandingR2T=1", "DataPDUInOrder=Yes", "DataSequenceInOrder=Yes",
+		"ErrorRecoveryLevel=0", "MaxConnections=Irrelevant")
+}
+
+func login(t *testing.T, tgt *target, target *Target) (*Session, error) {
+	c, s := net.Pipe()
+	errs := make(chan error, 1)
+	go func() { errs <- tgt.serve(s) }()
+	sess, err := Login(c, "iqn.2019-06.org.u-root:initiator", target)
+	c.Close()
+	if terr := <-errs; terr != nil && err == nil {
+		t.Errorf("target: %v", terr)
+	}
+	return sess, err
+}
+
+func TestLogin(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		tgt    *target
+		target *Target
+	}{
+		{"none", &target{}, &Target{}},
+		{"chap", &target{user: "user", secret: "secretsecret"}, &Target{User: "user", Secret: "secretsecret"}},
+		{"split", &target{user: "user", secret: "s", split: true}, &Target{User: "user", Secret: "s"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.tgt.name = "iqn.2019-06.org.example:disk"
+			tt.target.Name = tt.tgt.name
+			s, err := login(t, tt.tgt, tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.TSIH != 0x1234 || s.CmdSN != 7 || s.ExpStatSN != tt.tgt.statSN {
+				t.Errorf("TSIH %#x, CmdSN %d, ExpStatSN %d, want 0x1234, 7, %d", s.TSIH, s.CmdSN, s.ExpStatSN, tt.tgt.statSN)
+			}
+			for k, want := range map[string]string{
+				"InitialR2T":                     "Yes",
+				"MaxBurstLength":                 "262144",
+				"MaxRecvDataSegmentLength":       "262144",
+				"TargetMaxRecvDataSegmentLength": "65536",
+				"TargetPortalGroupTag":           "1",
+				"MaxConnections":                 "1",
+			} {
+				if got := s.Params[k]; got != want {
+					t.Errorf("Params[%s] = %q, want %q", k, got, want)
+				}
+			}
+			first := tt.tgt.requests[0]
+			if !strings.Contains(first, "InitiatorName=iqn.2019-06.org.u-root:initiator") || !strings.Contains(first, "SessionType=Normal") {
+				t.Errorf("first request = %q", first)
+			}
+		})
+	}
+}
+
+func TestLoginRefused(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		tgt    *target
+		target *Target
+		want   string
+	}{
+		{"unknown target", &target{name: "iqn.2019-06.org.example:disk"}, &Target{Name: "other"}, "target not found"},
+		{"bad secret", &target{name: "t", user: "user", secret: "right"}, &Target{Name: "t", User: "user", Secret: "wrong"}, "authentication failure"},
+		{"no credentials", &target{name: "t", user: "user", secret: "right"}, &Target{Name: "t"}, "authentication failure"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := login(t, tt.tgt, tt.target)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Login = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestChapResponse(t *testing.T) {
+	c := []byte("challenge")
+	sum := md5.Sum(append([]byte("\x07secret"), c...))
+	want := "0x" + hex.EncodeToString(sum[:])
+	for _, enc := range []string{"0x" + hex.EncodeToString(c), "0b" + "Y2hhbGxlbmdl"} {
+		got, err := chapResponse("7", enc, "secret")
+		if err != nil || got != want {
+			t.Errorf("chapResponse(7, %s) = %q, %v, want %q", enc, got, err, want)
+		}
+	}
+	for _, bad := range [][2]string{{"300", "0x01"}, {"1", "01"}, {"1", "0x"}, {"1", "0xzz"}} {
+		if _, err := chapResponse(bad[0], bad[1], "s"); err == nil {
+			t.Errorf("chapResponse(%s, %s) succeeded", bad[0], bad[1])
+		}
+	}
+}
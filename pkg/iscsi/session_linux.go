@@ -0,0 +1,328 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package iscsi
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// Events of the kernel's iSCSI netlink interface, from scsi/iscsi_if.h.
+const (
+	eventCreateSession  = 11
+	eventDestroySession = 12
+	eventCreateConn     = 13
+	eventDestroyConn    = 14
+	eventBindConn       = 15
+	eventSetParam       = 16
+	eventStartConn      = 17
+	eventStopConn       = 18
+	eventIfError        = 101
+)
+
+// Parameters of sessions and connections.
+const (
+	paramMaxRecvDLength    = 0
+	paramMaxXmitDLength    = 1
+	paramHdrDgstEn         = 2
+	paramDataDgstEn        = 3
+	paramInitialR2TEn      = 4
+	paramMaxR2T            = 5
+	paramImmDataEn         = 6
+	paramFirstBurst        = 7
+	paramMaxBurst          = 8
+	paramPDUInOrderEn      = 9
+	paramDataSeqInOrderEn  = 10
+	paramERL               = 11
+	paramIFMarkerEn        = 12
+	paramOFMarkerEn        = 13
+	paramExpStatSN         = 14
+	paramTargetName        = 15
+	paramTPGT              = 16
+	paramPersistentAddress = 17
+	paramPersistentPort    = 18
+	paramISID              = 33
+	paramInitiatorName     = 34
+)
+
+// stopConnTerm terminates a connection.
+const stopConnTerm = 2
+
+// ueventLen is the size of struct iscsi_uevent: the type, an error, the
+// transport handle, and unions of requests and replies.
+const ueventLen = 56
+
+// transportHandle is the file with the handle of the iscsi_tcp transport.
+const transportHandle = "/sys/class/iscsi_transport/tcp/handle"
+
+// Host is a session served by the kernel, as a SCSI host.
+type Host struct {
+	*Session
+
+	// SID is the kernel's number of the session, and HostNo that of the
+	// SCSI host.
+	SID    uint32
+	HostNo uint32
+
+	handle uint64
+	sock   *nl.NetlinkSocket
+	// file is the TCP connection, which the kernel now uses.
+	file *os.File
+}
+
+// call sends the event typ with the request u, which may be followed by
+// data, and returns the reply union of the kernel's answer.
+func (h *Host) call(typ uint32, u []byte, data []byte) ([]byte, error) {
+	e := nl.NativeEndian()
+	ev := make([]byte, ueventLen, ueventLen+len(data))
+	e.PutUint32(ev, typ)
+	e.PutUint64(ev[8:], h.handle)
+	copy(ev[16:40], u)
+	ev = append(ev, data...)
+
+	req := nl.NewNetlinkRequest(int(typ), 0)
+	req.AddRawData(ev)
+	if err := h.sock.Send(req); err != nil {
+		return nil, err
+	}
+	for {
+		msgs, err := h.sock.Receive()
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range msgs {
+			// The kernel answers with the type of the request.
+			if m.Header.Type != uint16(typ) || len(m.Data) < ueventLen {
+				continue
+			}
+			if e.Uint32(m.Data) == eventIfError {
+				return nil, syscall.Errno(-int32(e.Uint32(m.Data[4:])))
+			}
+			return m.Data[40:56], nil
+		}
+	}
+}
+
+// check calls the event typ, whose reply is a return code.
+func (h *Host) check(typ uint32, u []byte, data []byte) error {
+	r, err := h.call(typ, u, data)
+	if err != nil {
+		return err
+	}
+	if rc := int32(nl.NativeEndian().Uint32(r)); rc != 0 {
+		return syscall.Errno(-rc)
+	}
+	return nil
+}
+
+func u32s(v ...uint32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, x := range v {
+		nl.NativeEndian().PutUint32(b[4*i:], x)
+	}
+	return b
+}
+
+func (h *Host) setParam(param uint32, value string) error {
+	v := append([]byte(value), 0)
+	err := h.check(eventSetParam, u32s(h.SID, 0, param, uint32(len(v))), v)
+	// Older kernels do not know some parameters.
+	if err == syscall.ENOSYS {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("setting parameter %d to %q: %v", param, value, err)
+	}
+	return nil
+}
+
+func yes(v string) string {
+	if v == "Yes" {
+		return "1"
+	}
+	return "0"
+}
+
+// params are the kernel's parameters for the session.
+func (s *Session) params() (map[uint32]string, error) {
+	host, port, err := net.SplitHostPort(s.Target.Addr)
+	if err != nil {
+		return nil, err
+	}
+	xmit := s.Params["TargetMaxRecvDataSegmentLength"]
+	if xmit == "" {
+		xmit = "8192"
+	}
+	tpgt := s.Params["TargetPortalGroupTag"]
+	if tpgt == "" {
+		tpgt = "1"
+	}
+	return map[uint32]string{
+		paramMaxRecvDLength:    strconv.Itoa(maxRecvDataSegmentLength),
+		paramMaxXmitDLength:    xmit,
+		paramHdrDgstEn:         "0",
+		paramDataDgstEn:        "0",
+		paramInitialR2TEn:      yes(s.Params["InitialR2T"]),
+		paramMaxR2T:            s.Params["MaxOutstandingR2T"],
+		paramImmDataEn:         yes(s.Params["ImmediateData"]),
+		paramFirstBurst:        s.Params["FirstBurstLength"],
+		paramMaxBurst:          s.Params["MaxBurstLength"],
+		paramPDUInOrderEn:      yes(s.Params["DataPDUInOrder"]),
+		paramDataSeqInOrderEn:  yes(s.Params["DataSequenceInOrder"]),
+		paramERL:               s.Params["ErrorRecoveryLevel"],
+		paramIFMarkerEn:        "0",
+		paramOFMarkerEn:        "0",
+		paramExpStatSN:         strconv.FormatUint(uint64(s.ExpStatSN), 10),
+		paramTargetName:        s.Target.Name,
+		paramTPGT:              tpgt,
+		paramPersistentAddress: host,
+		paramPersistentPort:    port,
+		paramISID:              fmt.Sprintf("%x", s.ISID[:]),
+		paramInitiatorName:     s.Initiator,
+	}, nil
+}
+
+// Attach hands the session to the kernel's iscsi_tcp driver, which must be
+// loaded, and scans the target for logical units. The session must not be
+// used afterwards.
+func (s *Session) Attach() (*Host, error) {
+	h, err := s.attach()
+	if err != nil {
+		return nil, fmt.Errorf("iscsi: attaching %s: %v", s.Target.Name, err)
+	}
+	return h, nil
+}
+
+func (s *Session) attach() (*Host, error) {
+	b, err := ioutil.ReadFile(transportHandle)
+	if err != nil {
+		return nil, fmt.Errorf("no iscsi_tcp transport: %v", err)
+	}
+	handle, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	params, err := s.params()
+	if err != nil {
+		return nil, err
+	}
+	tc, ok := s.conn.(*net.TCPConn)
+	if !ok {
+		return nil, fmt.Errorf("cannot attach a %T", s.conn)
+	}
+	f, err := tc.File()
+	if err != nil {
+		return nil, err
+	}
+	sock, err := nl.Subscribe(unix.NETLINK_ISCSI)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	sock.SetReceiveTimeout(&unix.Timeval{Sec: 10})
+	h := &Host{Session: s, handle: handle, sock: sock, file: f}
+
+	// struct msg_create_session: initial_cmdsn, cmds_max and queue_depth.
+	u := u32s(s.CmdSN, 0)
+	nl.NativeEndian().PutUint16(u[4:], 128)
+	nl.NativeEndian().PutUint16(u[6:], 32)
+	r, err := h.call(eventCreateSession, u, nil)
+	if err != nil {
+		h.close()
+		return nil, fmt.Errorf("creating session: %v", err)
+	}
+	h.SID = nl.NativeEndian().Uint32(r)
+	h.HostNo = nl.NativeEndian().Uint32(r[4:])
+
+	if err := h.start(params); err != nil {
+		h.call(eventDestroySession, u32s(h.SID), nil)
+		h.close()
+		return nil, err
+	}
+	s.conn.Close()
+
+	// Scan all channels, targets and LUNs of the host. Kernels that scan
+	// started sessions by themselves may refuse.
+	scan := fmt.Sprintf("/sys/class/scsi_host/host%d/scan", h.HostNo)
+	ioutil.WriteFile(scan, []byte("- - -"), 0200)
+	return h, nil
+}
+
+// start creates the connection, binds it to the socket and starts it.
+func (h *Host) start(params map[uint32]string) error {
+	if _, err := h.call(eventCreateConn, u32s(h.SID, 0), nil); err != nil {
+		return fmt.Errorf("creating connection: %v", err)
+	}
+	// struct msg_bind_conn: sid, cid, transport_eph and is_leading.
+	u := u32s(h.SID, 0, 0, 0, 1)
+	nl.NativeEndian().PutUint64(u[8:], uint64(h.file.Fd()))
+	if err := h.check(eventBindConn, u, nil); err != nil {
+		h.call(eventDestroyConn, u32s(h.SID, 0), nil)
+		return fmt.Errorf("binding connection: %v", err)
+	}
+	for p := uint32(0); p <= paramInitiatorName; p++ {
+		v, ok := params[p]
+		if !ok {
+			continue
+		}
+		if err := h.setParam(p, v); err != nil {
+			h.call(eventDestroyConn, u32s(h.SID, 0), nil)
+			return err
+		}
+	}
+	if err := h.check(eventStartConn, u32s(h.SID, 0), nil); err != nil {
+		h.call(eventDestroyConn, u32s(h.SID, 0), nil)
+		return fmt.Errorf("starting connection: %v", err)
+	}
+	return nil
+}
+
+func (h *Host) close() {
+	h.sock.Close()
+	h.file.Close()
+	h.conn.Close()
+}
+
+// Disk waits until the kernel has found the target's LUN, and returns its
+// block device, such as /dev/sdb.
+func (h *Host) Disk(timeout time.Duration) (string, error) {
+	pattern := fmt.Sprintf("/sys/class/iscsi_session/session%d/device/target*/*:*:*:%d/block/*", h.SID, h.Target.LUN)
+	for deadline := time.Now().Add(timeout); ; time.Sleep(100 * time.Millisecond) {
+		if m, _ := filepath.Glob(pattern); len(m) > 0 {
+			return filepath.Join("/dev", filepath.Base(m[0])), nil
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("iscsi: no disk for LUN %d of %s", h.Target.LUN, h.Target.Name)
+		}
+	}
+}
+
+// Detach stops the connection and destroys the session, removing its
+// disks.
+func (h *Host) Detach() error {
+	defer h.close()
+	// struct msg_stop_conn: sid, cid, conn_handle and flag.
+	u := u32s(h.SID, 0, 0, 0, stopConnTerm)
+	if _, err := h.call(eventStopConn, u, nil); err != nil {
+		return fmt.Errorf("iscsi: stopping connection: %v", err)
+	}
+	if _, err := h.call(eventDestroyConn, u32s(h.SID, 0), nil); err != nil {
+		return fmt.Errorf("iscsi: destroying connection: %v", err)
+	}
+	if _, err := h.call(eventDestroySession, u32s(h.SID), nil); err != nil {
+		return fmt.Errorf("iscsi: destroying session: %v", err)
+	}
+	return nil
+}
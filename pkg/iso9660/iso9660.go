@@ -0,0 +1,563 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package iso9660 reads ISO9660 file systems, the file systems of CD-ROMs
+// and installer images.
+//
+// Names and attributes come from the Rock Ridge extensions if the image has
+// them, else from the Joliet extensions, else from the plain ISO9660
+// records, whose names are lower cased and lose their version, as the
+// Linux kernel does. Symbolic links are only found with Rock Ridge.
+package iso9660
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	sectorSize = 2048
+
+	// firstDescriptor is the sector of the first volume descriptor.
+	firstDescriptor = 16
+
+	// maxLinks is how many symbolic links are followed in a lookup.
+	maxLinks = 40
+
+	// maxDirSize is the size of the largest directory read, which
+	// keeps corrupt images from making us allocate gigabytes.
+	maxDirSize = 64 << 20
+)
+
+// Volume descriptor types.
+const (
+	primaryVolume       = 1
+	supplementaryVolume = 2
+	terminator          = 255
+)
+
+// Directory record flags.
+const (
+	flagDir         = 1 << 1
+	flagMultiExtent = 1 << 7
+)
+
+var (
+	// ErrNotISO9660 is returned by Open for images without an ISO9660
+	// volume descriptor.
+	ErrNotISO9660 = errors.New("not an ISO9660 image")
+
+	// ErrNotDir is returned when a directory is expected.
+	ErrNotDir = errors.New("not a directory")
+)
+
+// FS is an ISO9660 file system.
+type FS struct {
+	r    io.ReaderAt
+	root *File
+
+	// rockRidge is true if the directory records have Rock Ridge entries,
+	// after skip bytes of their system use area.
+	rockRidge bool
+	skip      int
+
+	// joliet is true if root is the root of a Joliet volume.
+	joliet bool
+}
+
+// extent is a contiguous part of a file.
+type extent struct {
+	block uint32
+	size  int64
+}
+
+// File is a file or directory of an FS. It reads the file's contents.
+type File struct {
+	// Name is the name of the file in its directory.
+	Name string
+
+	// Mode has the type and permissions of the file. Without Rock Ridge,
+	// files are readable by all, and directories searchable too.
+	Mode os.FileMode
+
+	Size    int64
+	ModTime time.Time
+
+	// Target is the target of a symbolic link.
+	Target string
+
+	fs      *FS
+	extents []extent
+}
+
+// IsDir returns true if f is a directory.
+func (f *File) IsDir() bool {
+	return f.Mode.IsDir()
+}
+
+// ReadAt implements io.ReaderAt.
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	var n int
+	for _, e := range f.extents {
+		if off >= e.size {
+			off -= e.size
+			continue
+		}
+		m, err := io.NewSectionReader(f.fs.r, int64(e.block)*sectorSize, e.size).ReadAt(p[n:], off)
+		n += m
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+		if n == len(p) {
+			return n, nil
+		}
+		off = 0
+	}
+	return n, io.EOF
+}
+
+// String implements fmt.Stringer.
+func (f *File) String() string {
+	return fmt.Sprintf("%s %s %d", f.Mode, f.Name, f.Size)
+}
+
+// Open reads the file system of the image r.
+func Open(r io.ReaderAt) (*FS, error) {
+	fs := &FS{r: r}
+	var primary, joliet []byte
+	for s := int64(firstDescriptor); ; s++ {
+		d := make([]byte, sectorSize)
+		if _, err := r.ReadAt(d, s*sectorSize); err != nil {
+			if err == io.EOF {
+				return nil, ErrNotISO9660
+			}
+			return nil, err
+		}
+		if string(d[1:6]) != "CD001" {
+			return nil, ErrNotISO9660
+		}
+		if d[0] == terminator {
+			break
+		}
+		switch d[0] {
+		case primaryVolume:
+			if primary == nil {
+				primary = d
+			}
+		case supplementaryVolume:
+			// Joliet volumes are marked by the escape sequence of
+			// a UCS-2 level.
+			if esc := string(d[88:91]); esc == "%/@" || esc == "%/C" || esc == "%/E" {
+				joliet = d
+			}
+		}
+	}
+	if primary == nil {
+		return nil, ErrNotISO9660
+	}
+	if size := binary.LittleEndian.Uint16(primary[128:]); size != sectorSize {
+		return nil, fmt.Errorf("unsupported logical block size %d", size)
+	}
+
+	root, err := fs.record(primary[156:190])
+	if err != nil {
+		return nil, err
+	}
+	fs.root = root
+	// Rock Ridge images start the system use area of the root's "."
+	// record with an SP entry, which gives the bytes to skip in all
+	// others.
+	dot, err := fs.records(root, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(dot) > 0 {
+		su := systemUse(dot[0])
+		if len(su) >= 7 && string(su[:2]) == "SP" && su[4] == 0xbe && su[5] == 0xef {
+			fs.rockRidge, fs.skip = true, int(su[6])
+		}
+	}
+	if !fs.rockRidge && joliet != nil {
+		if fs.root, err = fs.record(joliet[156:190]); err != nil {
+			return nil, err
+		}
+		fs.joliet = true
+	}
+	fs.root.Name = "/"
+	return fs, nil
+}
+
+// systemUse returns the system use area of the directory record r.
+func systemUse(r []byte) []byte {
+	n := 33 + int(r[32])
+	if r[32]%2 == 0 {
+		// Padding.
+		n++
+	}
+	if n > len(r) {
+		return nil
+	}
+	return r[n:]
+}
+
+// records returns the directory records of dir. With dots, they include
+// the "." and ".." records.
+func (fs *FS) records(dir *File, dots bool) ([][]byte, error) {
+	if dir.Size > maxDirSize {
+		return nil, fmt.Errorf("directory %q of %d bytes is too large", dir.Name, dir.Size)
+	}
+	data := make([]byte, dir.Size)
+	if _, err := dir.ReadAt(data, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	var records [][]byte
+	for i := 0; i < len(data); {
+		n := int(data[i])
+		if n == 0 {
+			// Records do not cross sectors; the rest of this one
+			// is padding.
+			i = (i/sectorSize + 1) * sectorSize
+			continue
+		}
+		if n < 34 || i+n > len(data) || 33+int(data[i+32]) > n {
+			return nil, fmt.Errorf("bad directory record at %d", i)
+		}
+		r := data[i : i+n]
+		i += n
+		if r[32] == 1 && (r[33] == 0 || r[33] == 1) && !dots {
+			continue
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// recordTime returns the time of a directory record.
+func recordTime(b []byte) time.Time {
+	if b[0] == 0 {
+		return time.Time{}
+	}
+	zone := time.FixedZone("", int(int8(b[6]))*15*60)
+	return time.Date(1900+int(b[0]), time.Month(b[1]), int(b[2]), int(b[3]), int(b[4]), int(b[5]), 0, zone).UTC()
+}
+
+// record returns the file of the directory record r.
+func (fs *FS) record(r []byte) (*File, error) {
+	if len(r) < 34 || 33+int(r[32]) > len(r) {
+		return nil, fmt.Errorf("bad directory record %x", r)
+	}
+	f := &File{
+		fs:      fs,
+		Size:    int64(binary.LittleEndian.Uint32(r[10:])),
+		ModTime: recordTime(r[18:25]),
+		Mode:    0444,
+	}
+	f.extents = []extent{{block: binary.LittleEndian.Uint32(r[2:]), size: f.Size}}
+	if r[25]&flagDir != 0 {
+		f.Mode = os.ModeDir | 0555
+	}
+
+	name := r[33 : 33+int(r[32])]
+	switch {
+	case fs.joliet:
+		u := make([]uint16, len(name)/2)
+		for i := range u {
+			u[i] = binary.BigEndian.Uint16(name[2*i:])
+		}
+		f.Name = string(utf16.Decode(u))
+		if i := strings.LastIndex(f.Name, ";"); i >= 0 {
+			f.Name = f.Name[:i]
+		}
+	default:
+		f.Name = string(name)
+		if i := strings.LastIndex(f.Name, ";"); i >= 0 {
+			f.Name = f.Name[:i]
+		}
+		f.Name = strings.ToLower(strings.TrimSuffix(f.Name, "."))
+	}
+
+	if fs.rockRidge {
+		if err := fs.rockRidgeEntries(f, systemUse(r)); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// rockRidgeEntries sets the name, mode, and link target of f from the
+// Rock Ridge entries in su, following continuation areas.
+func (fs *FS) rockRidgeEntries(f *File, su []byte) error {
+	if len(su) < fs.skip {
+		return nil
+	}
+	su = su[fs.skip:]
+	var name, target strings.Builder
+	var hasName, linkDone bool
+	for areas := 0; areas < 16; areas++ {
+		var next []byte
+		for len(su) >= 4 {
+			n := int(su[2])
+			if n < 4 || n > len(su) {
+				break
+			}
+			e := su[:n]
+			su = su[n:]
+			switch string(e[:2]) {
+			case "NM":
+				if len(e) < 5 || e[4]&0x6 != 0 {
+					// The current or parent directory.
+					continue
+				}
+				name.Write(e[5:])
+				hasName = true
+			case "PX":
+				if len(e) >= 8 {
+					f.Mode = unixMode(binary.LittleEndian.Uint32(e[4:]))
+				}
+			case "SL":
+				if len(e) >= 5 {
+					linkDone = slComponents(&target, e[5:], linkDone)
+				}
+			case "CE":
+				if len(e) >= 28 {
+					block := binary.LittleEndian.Uint32(e[4:])
+					off := binary.LittleEndian.Uint32(e[12:])
+					size := binary.LittleEndian.Uint32(e[20:])
+					// Continuation areas are within a block.
+					if off >= sectorSize || size > sectorSize-off {
+						return fmt.Errorf("bad continuation area of %q", f.Name)
+					}
+					next = make([]byte, size)
+					if _, err := fs.r.ReadAt(next, int64(block)*sectorSize+int64(off)); err != nil {
+						return err
+					}
+				}
+			case "ST":
+				su = nil
+			}
+		}
+		if next == nil {
+			break
+		}
+		su = next
+	}
+	if hasName {
+		f.Name = name.String()
+	}
+	if f.Mode&os.ModeSymlink != 0 {
+		f.Target = target.String()
+	}
+	return nil
+}
+
+// slComponents appends the components of the data of an SL entry to the
+// target t. A component continues in the next entry if the last one ended
+// with its continue flag, which is returned as not done.
+func slComponents(t *strings.Builder, data []byte, done bool) bool {
+	for len(data) >= 2 {
+		flags, n := data[0], int(data[1])
+		if 2+n > len(data) {
+			break
+		}
+		c := string(data[2 : 2+n])
+		data = data[2+n:]
+		if t.Len() > 0 && done && !strings.HasSuffix(t.String(), "/") {
+			t.WriteString("/")
+		}
+		switch {
+		case flags&0x2 != 0:
+			c = "."
+		case flags&0x4 != 0:
+			c = ".."
+		case flags&0x8 != 0:
+			c = "/"
+		}
+		t.WriteString(c)
+		done = flags&0x1 == 0
+	}
+	return done
+}
+
+// unixMode converts the st_mode of a PX entry.
+func unixMode(m uint32) os.FileMode {
+	mode := os.FileMode(m & 0777)
+	switch m & 0170000 {
+	case 0040000:
+		mode |= os.ModeDir
+	case 0120000:
+		mode |= os.ModeSymlink
+	case 0010000:
+		mode |= os.ModeNamedPipe
+	case 0140000:
+		mode |= os.ModeSocket
+	case 0020000:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case 0060000:
+		mode |= os.ModeDevice
+	}
+	if m&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if m&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if m&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+// readDir returns the files of the directory dir.
+func (fs *FS) readDir(dir *File) ([]*File, error) {
+	if !dir.IsDir() {
+		return nil, ErrNotDir
+	}
+	records, err := fs.records(dir, false)
+	if err != nil {
+		return nil, err
+	}
+	var files []*File
+	var multi *File
+	for _, r := range records {
+		f, err := fs.record(r)
+		if err != nil {
+			return nil, err
+		}
+		// The extents of files over 4 GiB are in records of the
+		// same name, all but the last flagged.
+		if multi != nil {
+			multi.extents = append(multi.extents, f.extents...)
+			multi.Size += f.Size
+			if r[25]&flagMultiExtent == 0 {
+				multi = nil
+			}
+			continue
+		}
+		if r[25]&flagMultiExtent != 0 {
+			multi = f
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// lookup returns the file at name, following symbolic links if follow is
+// true or if they are not the last element.
+func (fs *FS) lookup(name string, follow bool) (*File, error) {
+	links := 0
+	var walk func(dir *File, dirName string, elems []string) (*File, error)
+	walk = func(dir *File, dirName string, elems []string) (*File, error) {
+		f := dir
+		for i, e := range elems {
+			files, err := fs.readDir(f)
+			if err != nil {
+				return nil, &os.PathError{Op: "lookup", Path: name, Err: err}
+			}
+			var next *File
+			for _, c := range files {
+				if c.Name == e {
+					next = c
+					break
+				}
+			}
+			if next == nil {
+				return nil, &os.PathError{Op: "lookup", Path: name, Err: os.ErrNotExist}
+			}
+			if next.Mode&os.ModeSymlink != 0 && (follow || i < len(elems)-1) {
+				if links++; links > maxLinks {
+					return nil, &os.PathError{Op: "lookup", Path: name, Err: errors.New("too many links")}
+				}
+				target := next.Target
+				if !path.IsAbs(target) {
+					target = path.Join(dirName, target)
+				}
+				if next, err = walk(fs.root, "/", split(target)); err != nil {
+					return nil, err
+				}
+				dirName = path.Clean(target)
+			} else {
+				dirName = path.Join(dirName, e)
+			}
+			f = next
+		}
+		return f, nil
+	}
+	return walk(fs.root, "/", split(name))
+}
+
+// split returns the elements of the path name.
+func split(name string) []string {
+	var elems []string
+	for _, e := range strings.Split(path.Clean("/"+name), "/") {
+		if e != "" {
+			elems = append(elems, e)
+		}
+	}
+	return elems
+}
+
+// Stat returns the file at name, following symbolic links.
+func (fs *FS) Stat(name string) (*File, error) {
+	return fs.lookup(name, true)
+}
+
+// Lstat returns the file at name. If it is a symbolic link, the link is
+// returned.
+func (fs *FS) Lstat(name string) (*File, error) {
+	return fs.lookup(name, false)
+}
+
+// ReadDir returns the files of the directory at name.
+func (fs *FS) ReadDir(name string) ([]*File, error) {
+	d, err := fs.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	files, err := fs.readDir(d)
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return files, nil
+}
+
+// inImage returns an error if an extent of f ends past the end of the image.
+func (f *File) inImage() error {
+	var b [1]byte
+	for _, e := range f.extents {
+		if e.size == 0 {
+			continue
+		}
+		if _, err := f.fs.r.ReadAt(b[:], int64(e.block)*sectorSize+e.size-1); err != nil {
+			return fmt.Errorf("extent of %d bytes at block %d is outside the image: %v", e.size, e.block, err)
+		}
+	}
+	return nil
+}
+
+// ReadFile returns the contents of the file at name.
+func (fs *FS) ReadFile(name string) ([]byte, error) {
+	f, err := fs.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if f.IsDir() {
+		return nil, &os.PathError{Op: "read", Path: name, Err: errors.New("is a directory")}
+	}
+	// Sizes come from the image; check them before allocating.
+	if err := f.inImage(); err != nil {
+		return nil, &os.PathError{Op: "read", Path: name, Err: err}
+	}
+	b := make([]byte, f.Size)
+	if _, err := f.ReadAt(b, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,205 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package iso9660
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The images in testdata were made by bsdtar from the same tree, with Rock
+// Ridge and Joliet, with Joliet only, and with neither. bsdtar leaves
+// symbolic links out of images without Rock Ridge.
+func readImage(t *testing.T, name string) []byte {
+	f, err := os.Open("testdata/" + name + ".iso.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	z, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func openImage(t *testing.T, name string) *FS {
+	fs, err := Open(bytes.NewReader(readImage(t, name)))
+	if err != nil {
+		t.Fatalf("Open(%s) = %v", name, err)
+	}
+	return fs
+}
+
+func names(files []*File) string {
+	var n []string
+	for _, f := range files {
+		n = append(n, f.Name)
+	}
+	sort.Strings(n)
+	return strings.Join(n, " ")
+}
+
+func TestNotISO9660(t *testing.T) {
+	for _, b := range [][]byte{nil, make([]byte, 64*1024)} {
+		if _, err := Open(bytes.NewReader(b)); err != ErrNotISO9660 {
+			t.Errorf("Open(%d zero bytes) = %v, want %v", len(b), err, ErrNotISO9660)
+		}
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	// The root directory record of the primary volume descriptor.
+	const root = firstDescriptor*sectorSize + 156
+	for _, tt := range []struct {
+		name   string
+		off    int
+		values []byte
+	}{
+		{name: "name length", off: root + 32, values: []byte{200}},
+		{name: "directory size", off: root + 10, values: []byte{0xff, 0xff, 0xff, 0xff}},
+	} {
+		b := readImage(t, "plain")
+		copy(b[tt.off:], tt.values)
+		if _, err := Open(bytes.NewReader(b)); err == nil {
+			t.Errorf("Open with a corrupt root %s succeeded", tt.name)
+		}
+	}
+}
+
+func TestReadFileTooLarge(t *testing.T) {
+	b := readImage(t, "plain")
+	// Make the directory record of casper/big.bin claim 4 GiB.
+	i := bytes.Index(b, []byte("BIG.BIN;1"))
+	if i < 33 {
+		t.Fatal("no record for casper/big.bin")
+	}
+	copy(b[i-33+10:], []byte{0xff, 0xff, 0xff, 0xff})
+	fs, err := Open(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.ReadFile("casper/big.bin"); err == nil {
+		t.Errorf("ReadFile of a file larger than the image succeeded")
+	}
+}
+
+func TestNames(t *testing.T) {
+	for _, tt := range []struct {
+		image string
+		root  string
+		file  string
+	}{
+		{"rr", "Long Directory Name boot casper isolinux vmlinuz", "/Long Directory Name/sub/MixedCase File.txt"},
+		{"joliet", "Long Directory Name boot casper isolinux", "/Long Directory Name/sub/MixedCase File.txt"},
+		{"plain", "boot casper isolinux long_dir", "/long_dir/sub/mixedcas.txt"},
+	} {
+		t.Run(tt.image, func(t *testing.T) {
+			fs := openImage(t, tt.image)
+			files, err := fs.ReadDir("/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := names(files); got != tt.root {
+				t.Errorf("ReadDir(/) = %q, want %q", got, tt.root)
+			}
+			b, err := fs.ReadFile(tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != "Hello, World\n" {
+				t.Errorf("ReadFile(%s) = %q", tt.file, b)
+			}
+			b, err = fs.ReadFile("isolinux/isolinux.cfg")
+			if err != nil || !strings.HasPrefix(string(b), "default live\n") {
+				t.Errorf("ReadFile(isolinux/isolinux.cfg) = %q, %v", b, err)
+			}
+			if _, err := fs.Stat("/casper/missing"); !os.IsNotExist(err) {
+				t.Errorf("Stat(/casper/missing) = %v, want not exist", err)
+			}
+			if _, err := fs.ReadDir("/casper/vmlinuz"); err == nil {
+				t.Errorf("ReadDir(/casper/vmlinuz) succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestRockRidge(t *testing.T) {
+	fs := openImage(t, "rr")
+
+	f, err := fs.Stat("casper/initrd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// bsdtar makes files readable by all in Rock Ridge images.
+	if f.Mode != 0444 || f.Size != 6 {
+		t.Errorf("Stat(casper/initrd) = %v, want mode 0444 and size 6", f)
+	}
+	f, err = fs.Stat("casper/vmlinuz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2019, 6, 1, 12, 34, 56, 0, time.UTC); !f.ModTime.Equal(want) {
+		t.Errorf("ModTime = %v, want %v", f.ModTime, want)
+	}
+
+	l, err := fs.Lstat("vmlinuz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Mode&os.ModeSymlink == 0 || l.Target != "casper/vmlinuz" {
+		t.Errorf("Lstat(vmlinuz) = %v -> %q, want link to casper/vmlinuz", l, l.Target)
+	}
+	for _, name := range []string{"vmlinuz", "boot/casper/vmlinuz", "boot/casper/../casper/initrd"} {
+		b, err := fs.ReadFile(name)
+		if err != nil {
+			t.Errorf("ReadFile(%s) = %v", name, err)
+			continue
+		}
+		if s := string(b); s != "KERNEL" && s != "INITRD" {
+			t.Errorf("ReadFile(%s) = %q", name, s)
+		}
+	}
+}
+
+func TestReadAt(t *testing.T) {
+	rr, plain := openImage(t, "rr"), openImage(t, "plain")
+	a, err := rr.ReadFile("casper/big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := plain.ReadFile("casper/big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a) != 5000 || !bytes.Equal(a, b) {
+		t.Fatalf("casper/big.bin differs between images, or is not 5000 bytes")
+	}
+
+	f, err := rr.Stat("casper/big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Split the file into two extents, as a file over 4 GiB would be.
+	e := f.extents[0]
+	f.extents = []extent{{e.block, 2048}, {e.block + 1, e.size - 2048}}
+	p := make([]byte, 100)
+	if n, err := f.ReadAt(p, 2000); n != 100 || err != nil || !bytes.Equal(p, a[2000:2100]) {
+		t.Errorf("ReadAt across extents = %d, %v", n, err)
+	}
+	if n, err := f.ReadAt(p, 4950); n != 50 || !bytes.Equal(p[:n], a[4950:]) {
+		t.Errorf("ReadAt at the end = %d, %v, want 50 bytes", n, err)
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package luks
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	// Register the hashes LUKS volumes commonly use.
+	_ "crypto/sha1"
+	_ "crypto/sha512"
+
+	_ "golang.org/x/crypto/ripemd160"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/xts"
+)
+
+var hashes = map[string]crypto.Hash{
+	"sha1":      crypto.SHA1,
+	"sha224":    crypto.SHA224,
+	"sha256":    crypto.SHA256,
+	"sha384":    crypto.SHA384,
+	"sha512":    crypto.SHA512,
+	"ripemd160": crypto.RIPEMD160,
+}
+
+func hashFunc(name string) (crypto.Hash, error) {
+	h, ok := hashes[strings.ToLower(name)]
+	if !ok || !h.Available() {
+		return 0, fmt.Errorf("unsupported hash %q", name)
+	}
+	return h, nil
+}
+
+func pbkdf2Key(password, salt []byte, iter, keyLen int, hash string) ([]byte, error) {
+	h, err := hashFunc(hash)
+	if err != nil {
+		return nil, err
+	}
+	return pbkdf2.Key(password, salt, iter, keyLen, h.New), nil
+}
+
+func argon2Key(kdf string, password, salt []byte, time, memory, threads, keyLen int) []byte {
+	if kdf == "argon2i" {
+		return argon2.Key(password, salt, uint32(time), uint32(memory), uint8(threads), uint32(keyLen))
+	}
+	return argon2.IDKey(password, salt, uint32(time), uint32(memory), uint8(threads), uint32(keyLen))
+}
+
+// afMerge recovers a key of keySize bytes from the output of the LUKS
+// anti-forensic splitter: all stripes but the last are XORed together with a
+// diffusion step after each one, and the last stripe is XORed in at the end.
+func afMerge(material []byte, keySize, stripes int, hash string) ([]byte, error) {
+	if len(material) < keySize*stripes {
+		return nil, fmt.Errorf("key material too short: %d < %d", len(material), keySize*stripes)
+	}
+	h, err := hashFunc(hash)
+	if err != nil {
+		return nil, err
+	}
+	d := make([]byte, keySize)
+	for i := 0; i < stripes-1; i++ {
+		xorBytes(d, material[i*keySize:(i+1)*keySize])
+		d = diffuse(d, h)
+	}
+	xorBytes(d, material[(stripes-1)*keySize:stripes*keySize])
+	return d, nil
+}
+
+// diffuse hashes src block by block, each block prefixed by its big-endian
+// index, so that every bit of a stripe influences the merged key.
+func diffuse(src []byte, h crypto.Hash) []byte {
+	dst := make([]byte, 0, len(src))
+	var iv [4]byte
+	for i := 0; len(dst) < len(src); i++ {
+		end := len(dst) + h.Size()
+		if end > len(src) {
+			end = len(src)
+		}
+		binary.BigEndian.PutUint32(iv[:], uint32(i))
+		s := h.New()
+		s.Write(iv[:])
+		s.Write(src[len(dst):end])
+		dst = append(dst, s.Sum(nil)[:end-len(dst)]...)
+	}
+	return dst
+}
+
+func xorBytes(dst, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
+
+// decryptSectors decrypts data in place. data is a run of sectorSize sectors,
+// the first of which has IV sector number first. spec is a dm-crypt cipher
+// specification such as "aes-xts-plain64" or "aes-cbc-essiv:sha256".
+func decryptSectors(spec string, key, data []byte, sectorSize int, first uint64) error {
+	if len(data)%sectorSize != 0 {
+		return fmt.Errorf("data length %d not a multiple of sector size %d", len(data), sectorSize)
+	}
+	f := strings.SplitN(spec, "-", 3)
+	if len(f) != 3 {
+		return fmt.Errorf("unsupported cipher %q", spec)
+	}
+	if f[0] != "aes" {
+		return fmt.Errorf("unsupported cipher %q", f[0])
+	}
+	// IV sector numbers count in 512-byte units regardless of sector size.
+	step := uint64(sectorSize / 512)
+
+	switch f[1] {
+	case "xts":
+		c, err := xts.NewCipher(aes.NewCipher, key)
+		if err != nil {
+			return err
+		}
+		var mask uint64 = ^uint64(0)
+		switch f[2] {
+		case "plain64":
+		case "plain":
+			mask = 0xffffffff
+		default:
+			return fmt.Errorf("unsupported IV mode %q", f[2])
+		}
+		for off, n := 0, first; off < len(data); off, n = off+sectorSize, n+step {
+			s := data[off : off+sectorSize]
+			c.Decrypt(s, s, n&mask)
+		}
+		return nil
+
+	case "cbc":
+		b, err := aes.NewCipher(key)
+		if err != nil {
+			return err
+		}
+		iv, err := ivGenerator(f[2], key)
+		if err != nil {
+			return err
+		}
+		for off, n := 0, first; off < len(data); off, n = off+sectorSize, n+step {
+			s := data[off : off+sectorSize]
+			cipher.NewCBCDecrypter(b, iv(n)).CryptBlocks(s, s)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported cipher mode %q", f[1])
+	}
+}
+
+// ivGenerator returns a function computing the CBC IV for a sector.
+func ivGenerator(mode string, key []byte) (func(uint64) []byte, error) {
+	switch mode {
+	case "plain", "plain64":
+		mask := ^uint64(0)
+		if mode == "plain" {
+			mask = 0xffffffff
+		}
+		return func(n uint64) []byte {
+			iv := make([]byte, aes.BlockSize)
+			binary.LittleEndian.PutUint64(iv, n&mask)
+			return iv
+		}, nil
+	case "essiv:sha256":
+		salt := sha256.Sum256(key)
+		e, err := aes.NewCipher(salt[:])
+		if err != nil {
+			return nil, err
+		}
+		return func(n uint64) []byte {
+			iv := make([]byte, aes.BlockSize)
+			binary.LittleEndian.PutUint64(iv, n)
+			e.Encrypt(iv, iv)
+			return iv
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported IV mode %q", mode)
+	}
+}
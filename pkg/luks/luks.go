@@ -0,0 +1,285 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package luks reads LUKS1 and LUKS2 encrypted volume headers and recovers
+// the volume key from a passphrase.
+//
+// The volume key can then be handed to dm-crypt (see Open) to expose the
+// decrypted contents as a block device, the same way cryptsetup does.
+package luks
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+)
+
+var (
+	// Magic is found at the start of every LUKS header.
+	Magic = []byte{'L', 'U', 'K', 'S', 0xba, 0xbe}
+
+	// ErrNotLUKS is returned when the header magic does not match.
+	ErrNotLUKS = errors.New("not a LUKS volume")
+
+	// ErrBadPassphrase is returned when no key slot can be opened with
+	// the passphrase.
+	ErrBadPassphrase = errors.New("no key available with this passphrase")
+)
+
+const (
+	// maxKeySize bounds the size of keys read from headers.
+	maxKeySize = 512
+
+	// maxKeyMaterial bounds the key material of a slot, which is at most
+	// the LUKS2 key slots area.
+	maxKeyMaterial = 128 << 20
+)
+
+// KeySlot describes one key slot of a volume.
+type KeySlot struct {
+	// ID is the slot number.
+	ID int
+
+	// KDF is the key derivation function, e.g. "pbkdf2" or "argon2id".
+	KDF string
+
+	// Hash is the hash used by PBKDF2.
+	Hash string
+
+	// AFHash is the hash used by the anti-forensic splitter.
+	AFHash string
+
+	// Cipher is the encryption of the key material, e.g. "aes-xts-plain64".
+	Cipher string
+
+	// KeySize is the size of the key protecting the key material, in bytes.
+	KeySize int
+
+	// Stripes is the anti-forensic splitter stripe count.
+	Stripes int
+
+	// Offset and Size locate the key material on disk, in bytes.
+	Offset int64
+	Size   int64
+
+	// Iterations is the PBKDF2 iteration count or Argon2 time cost.
+	Iterations int
+
+	// Memory (KiB) and Threads are Argon2 parameters.
+	Memory  int
+	Threads int
+
+	// Salt is the KDF salt.
+	Salt []byte
+}
+
+// Volume is a parsed LUKS header.
+type Volume struct {
+	// Version is 1 or 2.
+	Version int
+
+	// UUID is the volume UUID.
+	UUID string
+
+	// Label is the LUKS2 label, empty for LUKS1.
+	Label string
+
+	// Cipher is the data encryption in dm-crypt syntax, e.g. "aes-xts-plain64".
+	Cipher string
+
+	// KeySize is the size of the volume key, in bytes.
+	KeySize int
+
+	// PayloadOffset is where the encrypted data starts, in bytes.
+	PayloadOffset int64
+
+	// PayloadSize is the size of the encrypted data in bytes, or 0 if it
+	// extends to the end of the device.
+	PayloadSize int64
+
+	// SectorSize is the encryption sector size, in bytes.
+	SectorSize int
+
+	// IVTweak is added to the sector number to form the IV.
+	IVTweak uint64
+
+	// KeySlots are the active key slots.
+	KeySlots []KeySlot
+
+	// digests verify candidate volume keys.
+	digests []digest
+}
+
+// digest checks a candidate volume key against a stored PBKDF2 digest.
+type digest struct {
+	hash       string
+	iterations int
+	salt       []byte
+	digest     []byte
+
+	// slots are the key slots this digest applies to.
+	slots []int
+}
+
+// verify returns whether key matches the digest.
+func (d *digest) verify(key []byte) (bool, error) {
+	got, err := pbkdf2Key(key, d.salt, d.iterations, len(d.digest), d.hash)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(got, d.digest), nil
+}
+
+// covers returns whether the digest applies to key slot id.
+func (d *digest) covers(id int) bool {
+	for _, s := range d.slots {
+		if s == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Read parses the LUKS header at the start of r. If the primary header of
+// a LUKS2 volume has been wiped, the secondary header is used instead.
+func Read(r io.ReaderAt) (*Volume, error) {
+	var hdr [8]byte
+	if _, err := r.ReadAt(hdr[:], 0); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(hdr[:len(Magic)], Magic) {
+		if v, err := readLUKS2(r); err == nil {
+			return v, nil
+		}
+		return nil, ErrNotLUKS
+	}
+	switch v := int(hdr[6])<<8 | int(hdr[7]); v {
+	case 1:
+		return readLUKS1(r)
+	case 2:
+		return readLUKS2(r)
+	default:
+		return nil, fmt.Errorf("unsupported LUKS version %d", v)
+	}
+}
+
+// Unlock tries passphrase on every key slot and returns the volume key and
+// the number of the slot that opened. Slots that cannot be tried, such as
+// those with an unsupported KDF, are logged and skipped.
+func (v *Volume) Unlock(r io.ReaderAt, passphrase []byte) ([]byte, int, error) {
+	for _, ks := range v.KeySlots {
+		key, err := v.unlockSlot(r, &ks, passphrase)
+		if err != nil {
+			log.Printf("Skipping LUKS key slot %d: %v", ks.ID, err)
+			continue
+		}
+		if key != nil {
+			return key, ks.ID, nil
+		}
+	}
+	return nil, -1, ErrBadPassphrase
+}
+
+// unlockSlot returns the volume key stored in ks, or nil if passphrase does
+// not open it.
+func (v *Volume) unlockSlot(r io.ReaderAt, ks *KeySlot, passphrase []byte) ([]byte, error) {
+	if ks.Stripes <= 0 {
+		return nil, fmt.Errorf("bad stripe count %d", ks.Stripes)
+	}
+	if v.KeySize <= 0 || v.KeySize > maxKeySize || ks.KeySize <= 0 || ks.KeySize > maxKeySize {
+		return nil, fmt.Errorf("bad key size %d, key slot key size %d", v.KeySize, ks.KeySize)
+	}
+	if n := int64(v.KeySize) * int64(ks.Stripes); n > ks.Size || n > maxKeyMaterial {
+		return nil, fmt.Errorf("%d bytes of key material do not fit in an area of %d bytes", n, ks.Size)
+	}
+
+	var (
+		kek []byte
+		err error
+	)
+	switch ks.KDF {
+	case "pbkdf2":
+		kek, err = pbkdf2Key(passphrase, ks.Salt, ks.Iterations, ks.KeySize, ks.Hash)
+	case "argon2i", "argon2id":
+		kek = argon2Key(ks.KDF, passphrase, ks.Salt, ks.Iterations, ks.Memory, ks.Threads, ks.KeySize)
+	default:
+		err = fmt.Errorf("unsupported KDF %q", ks.KDF)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The key material is encrypted in whole 512-byte sectors.
+	n := int64(v.KeySize) * int64(ks.Stripes)
+	material := make([]byte, (n+511)&^511)
+	if _, err := r.ReadAt(material, ks.Offset); err != nil {
+		return nil, fmt.Errorf("reading key material: %v", err)
+	}
+	if err := decryptSectors(ks.Cipher, kek, material, 512, 0); err != nil {
+		return nil, err
+	}
+	key, err := afMerge(material[:n], v.KeySize, ks.Stripes, ks.AFHash)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range v.digests {
+		if !d.covers(ks.ID) {
+			continue
+		}
+		ok, err := d.verify(key)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return key, nil
+		}
+	}
+	return nil, nil
+}
+
+// Dump writes a human-readable description of the header to w, in the
+// spirit of cryptsetup luksDump.
+func (v *Volume) Dump(w io.Writer) {
+	fmt.Fprintf(w, "LUKS header information\n")
+	fmt.Fprintf(w, "Version:       \t%d\n", v.Version)
+	fmt.Fprintf(w, "UUID:          \t%s\n", v.UUID)
+	if v.Version == 2 {
+		fmt.Fprintf(w, "Label:         \t%s\n", orNone(v.Label))
+	}
+	fmt.Fprintf(w, "Cipher:        \t%s\n", v.Cipher)
+	fmt.Fprintf(w, "Key size:      \t%d bits\n", v.KeySize*8)
+	fmt.Fprintf(w, "Payload offset:\t%d\n", v.PayloadOffset)
+	if v.PayloadSize != 0 {
+		fmt.Fprintf(w, "Payload size:  \t%d\n", v.PayloadSize)
+	}
+	fmt.Fprintf(w, "Sector size:   \t%d\n", v.SectorSize)
+	fmt.Fprintf(w, "\nKeyslots:\n")
+	for _, ks := range v.KeySlots {
+		fmt.Fprintf(w, "  %d: %s\n", ks.ID, ks.KDF)
+		fmt.Fprintf(w, "\tCipher:     %s\n", ks.Cipher)
+		fmt.Fprintf(w, "\tKey size:   %d bits\n", ks.KeySize*8)
+		switch ks.KDF {
+		case "pbkdf2":
+			fmt.Fprintf(w, "\tHash:       %s\n", ks.Hash)
+			fmt.Fprintf(w, "\tIterations: %d\n", ks.Iterations)
+		default:
+			fmt.Fprintf(w, "\tTime cost:  %d\n", ks.Iterations)
+			fmt.Fprintf(w, "\tMemory:     %d\n", ks.Memory)
+			fmt.Fprintf(w, "\tThreads:    %d\n", ks.Threads)
+		}
+		fmt.Fprintf(w, "\tAF stripes: %d\n", ks.Stripes)
+		fmt.Fprintf(w, "\tAF hash:    %s\n", ks.AFHash)
+		fmt.Fprintf(w, "\tArea:       %d+%d\n", ks.Offset, ks.Size)
+	}
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "(no label)"
+	}
+	return s
+}
@@ -0,0 +1,110 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package luks
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+const (
+	luks1SlotCount   = 8
+	luks1SlotEnabled = 0x00ac71f3
+	luks1DigestSize  = 20
+)
+
+// luks1KeySlot is a key slot in the on-disk LUKS1 header.
+type luks1KeySlot struct {
+	Active            uint32
+	Iterations        uint32
+	Salt              [32]byte
+	KeyMaterialOffset uint32
+	Stripes           uint32
+}
+
+// luks1Header is the on-disk LUKS1 header. All integers are big endian.
+type luks1Header struct {
+	Magic         [6]byte
+	Version       uint16
+	CipherName    [32]byte
+	CipherMode    [32]byte
+	HashSpec      [32]byte
+	PayloadOffset uint32
+	KeyBytes      uint32
+	MKDigest      [luks1DigestSize]byte
+	MKDigestSalt  [32]byte
+	MKDigestIter  uint32
+	UUID          [40]byte
+	KeySlots      [luks1SlotCount]luks1KeySlot
+}
+
+func readLUKS1(r io.ReaderAt) (*Volume, error) {
+	var h luks1Header
+	if err := binary.Read(io.NewSectionReader(r, 0, int64(binary.Size(h))), binary.BigEndian, &h); err != nil {
+		return nil, err
+	}
+
+	hash := cstring(h.HashSpec[:])
+	cipher := cstring(h.CipherName[:]) + "-" + cstring(h.CipherMode[:])
+	v := &Volume{
+		Version:       1,
+		UUID:          cstring(h.UUID[:]),
+		Cipher:        cipher,
+		KeySize:       int(h.KeyBytes),
+		PayloadOffset: int64(h.PayloadOffset) * 512,
+		SectorSize:    512,
+	}
+	d := digest{
+		hash:       hash,
+		iterations: int(h.MKDigestIter),
+		salt:       h.MKDigestSalt[:],
+		digest:     h.MKDigest[:],
+	}
+	for i, s := range h.KeySlots {
+		if s.Active != luks1SlotEnabled {
+			continue
+		}
+		v.KeySlots = append(v.KeySlots, KeySlot{
+			ID:         i,
+			KDF:        "pbkdf2",
+			Hash:       hash,
+			AFHash:     hash,
+			Cipher:     cipher,
+			KeySize:    int(h.KeyBytes),
+			Stripes:    int(s.Stripes),
+			Offset:     int64(s.KeyMaterialOffset) * 512,
+			Size:       luks1AreaSize(&h, i),
+			Iterations: int(s.Iterations),
+			Salt:       append([]byte(nil), s.Salt[:]...),
+		})
+		d.slots = append(d.slots, i)
+	}
+	v.digests = []digest{d}
+	return v, nil
+}
+
+// luks1AreaSize returns the size of the area of key slot i, which extends
+// to the next slot's key material or to the payload. With a detached
+// header, it is the size of the key material.
+func luks1AreaSize(h *luks1Header, i int) int64 {
+	off, end := h.KeySlots[i].KeyMaterialOffset, h.PayloadOffset
+	for _, s := range h.KeySlots {
+		if s.KeyMaterialOffset > off && s.KeyMaterialOffset < end {
+			end = s.KeyMaterialOffset
+		}
+	}
+	if end <= off {
+		return int64(h.KeyBytes) * int64(h.KeySlots[i].Stripes)
+	}
+	return int64(end-off) * 512
+}
+
+func cstring(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
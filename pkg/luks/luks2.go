@@ -0,0 +1,270 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package luks
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+const (
+	luks2BinaryHeaderSize = 4096
+	luks2ChecksumOffset   = 448
+	luks2ChecksumSize     = 64
+)
+
+// luks2SecondaryOffsets are where the secondary header may live, tried in
+// order when the primary header is damaged.
+var luks2SecondaryOffsets = []int64{
+	0x4000, 0x8000, 0x10000, 0x20000, 0x40000, 0x80000, 0x100000, 0x200000, 0x400000,
+}
+
+// luks2SecondaryMagic starts the secondary LUKS2 header.
+var luks2SecondaryMagic = []byte{'S', 'K', 'U', 'L', 0xba, 0xbe}
+
+// luks2Header is the on-disk LUKS2 binary header. All integers are big
+// endian. The JSON metadata follows it.
+type luks2Header struct {
+	Magic       [6]byte
+	Version     uint16
+	HdrSize     uint64
+	SeqID       uint64
+	Label       [48]byte
+	ChecksumAlg [32]byte
+	Salt        [64]byte
+	UUID        [40]byte
+	Subsystem   [48]byte
+	HdrOffset   uint64
+	_           [184]byte
+	Checksum    [luks2ChecksumSize]byte
+}
+
+// luks2Metadata is the subset of the LUKS2 JSON metadata we use. Sizes and
+// offsets are JSON strings so they can hold full 64-bit values.
+type luks2Metadata struct {
+	Keyslots map[string]struct {
+		Type    string `json:"type"`
+		KeySize int    `json:"key_size"`
+		Area    struct {
+			Type       string `json:"type"`
+			Offset     string `json:"offset"`
+			Size       string `json:"size"`
+			Encryption string `json:"encryption"`
+			KeySize    int    `json:"key_size"`
+		} `json:"area"`
+		KDF struct {
+			Type       string `json:"type"`
+			Hash       string `json:"hash"`
+			Iterations int    `json:"iterations"`
+			Time       int    `json:"time"`
+			Memory     int    `json:"memory"`
+			CPUs       int    `json:"cpus"`
+			Salt       []byte `json:"salt"`
+		} `json:"kdf"`
+		AF struct {
+			Type    string `json:"type"`
+			Stripes int    `json:"stripes"`
+			Hash    string `json:"hash"`
+		} `json:"af"`
+	} `json:"keyslots"`
+	Segments map[string]struct {
+		Type       string `json:"type"`
+		Offset     string `json:"offset"`
+		Size       string `json:"size"`
+		IVTweak    string `json:"iv_tweak"`
+		Encryption string `json:"encryption"`
+		SectorSize int    `json:"sector_size"`
+	} `json:"segments"`
+	Digests map[string]struct {
+		Type       string   `json:"type"`
+		Keyslots   []string `json:"keyslots"`
+		Segments   []string `json:"segments"`
+		Hash       string   `json:"hash"`
+		Iterations int      `json:"iterations"`
+		Salt       []byte   `json:"salt"`
+		Digest     []byte   `json:"digest"`
+	} `json:"digests"`
+}
+
+func readLUKS2(r io.ReaderAt) (*Volume, error) {
+	h, meta, err := readLUKS2Header(r, 0)
+	if err != nil {
+		var err2 error
+		for _, off := range luks2SecondaryOffsets {
+			if h, meta, err2 = readLUKS2Header(r, off); err2 == nil {
+				break
+			}
+		}
+		if err2 != nil {
+			return nil, fmt.Errorf("primary header: %v", err)
+		}
+	}
+	return parseLUKS2(h, meta)
+}
+
+// readLUKS2Header reads and checksums the header at off. The primary header
+// at 0 starts with Magic, secondary headers with luks2SecondaryMagic.
+func readLUKS2Header(r io.ReaderAt, off int64) (*luks2Header, []byte, error) {
+	var h luks2Header
+	if err := binary.Read(io.NewSectionReader(r, off, luks2BinaryHeaderSize), binary.BigEndian, &h); err != nil {
+		return nil, nil, err
+	}
+	magic := Magic
+	if off != 0 {
+		magic = luks2SecondaryMagic
+	}
+	if !bytes.Equal(h.Magic[:], magic) || h.Version != 2 {
+		return nil, nil, ErrNotLUKS
+	}
+	if h.HdrOffset != uint64(off) {
+		return nil, nil, fmt.Errorf("header at %#x claims offset %#x", off, h.HdrOffset)
+	}
+	if h.HdrSize <= luks2BinaryHeaderSize || h.HdrSize > 4<<20 {
+		return nil, nil, fmt.Errorf("bad header size %d", h.HdrSize)
+	}
+	area := make([]byte, h.HdrSize)
+	if _, err := r.ReadAt(area, off); err != nil {
+		return nil, nil, err
+	}
+
+	alg := cstring(h.ChecksumAlg[:])
+	hf, err := hashFunc(alg)
+	if err != nil {
+		return nil, nil, err
+	}
+	copy(area[luks2ChecksumOffset:luks2ChecksumOffset+luks2ChecksumSize], make([]byte, luks2ChecksumSize))
+	sum := hf.New()
+	sum.Write(area)
+	if !bytes.Equal(sum.Sum(nil), h.Checksum[:hf.Size()]) {
+		return nil, nil, fmt.Errorf("header checksum mismatch")
+	}
+
+	meta := area[luks2BinaryHeaderSize:]
+	if i := bytes.IndexByte(meta, 0); i >= 0 {
+		meta = meta[:i]
+	}
+	return &h, meta, nil
+}
+
+func parseLUKS2(h *luks2Header, meta []byte) (*Volume, error) {
+	var m luks2Metadata
+	if err := json.Unmarshal(meta, &m); err != nil {
+		return nil, fmt.Errorf("parsing metadata: %v", err)
+	}
+
+	v := &Volume{
+		Version: 2,
+		UUID:    cstring(h.UUID[:]),
+		Label:   cstring(h.Label[:]),
+	}
+
+	seg, ok := m.Segments["0"]
+	if !ok {
+		return nil, fmt.Errorf("no data segment")
+	}
+	if seg.Type != "crypt" {
+		return nil, fmt.Errorf("unsupported segment type %q", seg.Type)
+	}
+	var err error
+	if v.PayloadOffset, err = strconv.ParseInt(seg.Offset, 10, 64); err != nil {
+		return nil, fmt.Errorf("segment offset: %v", err)
+	}
+	if seg.Size != "dynamic" {
+		if v.PayloadSize, err = strconv.ParseInt(seg.Size, 10, 64); err != nil {
+			return nil, fmt.Errorf("segment size: %v", err)
+		}
+	}
+	if seg.IVTweak != "" {
+		if v.IVTweak, err = strconv.ParseUint(seg.IVTweak, 10, 64); err != nil {
+			return nil, fmt.Errorf("segment iv_tweak: %v", err)
+		}
+	}
+	v.Cipher = seg.Encryption
+	v.SectorSize = seg.SectorSize
+	if v.SectorSize == 0 {
+		v.SectorSize = 512
+	}
+
+	for id, d := range m.Digests {
+		if d.Type != "pbkdf2" {
+			return nil, fmt.Errorf("digest %s: unsupported type %q", id, d.Type)
+		}
+		if !contains(d.Segments, "0") {
+			continue
+		}
+		dg := digest{
+			hash:       d.Hash,
+			iterations: d.Iterations,
+			salt:       d.Salt,
+			digest:     d.Digest,
+		}
+		for _, s := range d.Keyslots {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return nil, fmt.Errorf("digest %s: bad key slot %q", id, s)
+			}
+			dg.slots = append(dg.slots, n)
+		}
+		v.digests = append(v.digests, dg)
+	}
+
+	for id, k := range m.Keyslots {
+		if k.Type != "luks2" {
+			continue
+		}
+		n, err := strconv.Atoi(id)
+		if err != nil {
+			return nil, fmt.Errorf("bad key slot %q", id)
+		}
+		if v.KeySize == 0 {
+			v.KeySize = k.KeySize
+		}
+		if k.Area.Type != "raw" {
+			return nil, fmt.Errorf("key slot %s: unsupported area type %q", id, k.Area.Type)
+		}
+		if k.AF.Type != "luks1" {
+			return nil, fmt.Errorf("key slot %s: unsupported AF type %q", id, k.AF.Type)
+		}
+		ks := KeySlot{
+			ID:         n,
+			KDF:        k.KDF.Type,
+			Hash:       k.KDF.Hash,
+			AFHash:     k.AF.Hash,
+			Cipher:     k.Area.Encryption,
+			KeySize:    k.Area.KeySize,
+			Stripes:    k.AF.Stripes,
+			Iterations: k.KDF.Iterations,
+			Memory:     k.KDF.Memory,
+			Threads:    k.KDF.CPUs,
+			Salt:       k.KDF.Salt,
+		}
+		if ks.KDF != "pbkdf2" {
+			ks.Iterations = k.KDF.Time
+		}
+		if ks.Offset, err = strconv.ParseInt(k.Area.Offset, 10, 64); err != nil {
+			return nil, fmt.Errorf("key slot %s offset: %v", id, err)
+		}
+		if ks.Size, err = strconv.ParseInt(k.Area.Size, 10, 64); err != nil {
+			return nil, fmt.Errorf("key slot %s size: %v", id, err)
+		}
+		v.KeySlots = append(v.KeySlots, ks)
+	}
+	sort.Slice(v.KeySlots, func(i, j int) bool { return v.KeySlots[i].ID < v.KeySlots[j].ID })
+	return v, nil
+}
+
+func contains(l []string, s string) bool {
+	for _, e := range l {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package luks
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/u-root/u-root/pkg/dm"
+)
+
+// Open unlocks the LUKS volume on device with passphrase and sets up a
+// dm-crypt mapping called name for its payload. It returns the path of the
+// decrypted block device, /dev/mapper/name.
+func Open(device, name string, passphrase []byte, readOnly bool) (string, error) {
+	f, err := os.Open(device)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	v, err := Read(f)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", device, err)
+	}
+	key, _, err := v.Unlock(f, passphrase)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", device, err)
+	}
+
+	// Seeking to the end works for block devices as well as the regular
+	// files used in tests.
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", err
+	}
+	t, err := v.cryptTarget(device, key, size)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", device, err)
+	}
+	return dm.Create(name, "CRYPT-LUKS"+fmt.Sprint(v.Version)+"-"+hexUUID(v.UUID)+"-"+name, readOnly, []dm.Target{t})
+}
+
+// Close removes the dm-crypt mapping called name.
+func Close(name string) error {
+	return dm.Remove(name)
+}
+
+// cryptTarget returns the dm-crypt table line mapping the payload of a
+// device of devSize bytes with the volume key key.
+func (v *Volume) cryptTarget(device string, key []byte, devSize int64) (dm.Target, error) {
+	size := v.PayloadSize
+	if size == 0 {
+		size = devSize - v.PayloadOffset
+	}
+	if size <= 0 {
+		return dm.Target{}, fmt.Errorf("device too small for payload at offset %d", v.PayloadOffset)
+	}
+	params := fmt.Sprintf("%s %s %d %s %d", v.Cipher, hex.EncodeToString(key), v.IVTweak, device, v.PayloadOffset/dm.SectorSize)
+	if v.SectorSize != dm.SectorSize {
+		params += fmt.Sprintf(" 1 sector_size:%d", v.SectorSize)
+	}
+	return dm.Target{
+		Length: uint64(size / dm.SectorSize),
+		Type:   "crypt",
+		Params: params,
+	}, nil
+}
+
+// hexUUID strips the dashes from a UUID, as cryptsetup does for dm UUIDs.
+func hexUUID(uuid string) string {
+	b := make([]byte, 0, len(uuid))
+	for i := 0; i < len(uuid); i++ {
+		if uuid[i] != '-' {
+			b = append(b, uuid[i])
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,93 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package luks
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/dm"
+	"github.com/u-root/u-root/pkg/loop"
+)
+
+func TestCryptTarget(t *testing.T) {
+	v := &Volume{
+		Cipher:        "aes-xts-plain64",
+		PayloadOffset: 1 << 20,
+		SectorSize:    4096,
+	}
+	tg, err := v.cryptTarget("/dev/loop0", []byte{0xde, 0xad}, 2<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := dm.Target{
+		Length: 2048,
+		Type:   "crypt",
+		Params: "aes-xts-plain64 dead 0 /dev/loop0 2048 1 sector_size:4096",
+	}
+	if tg != want {
+		t.Errorf("cryptTarget = %v, want %v", tg, want)
+	}
+
+	if _, err := v.cryptTarget("/dev/loop0", nil, 1<<20); err == nil {
+		t.Errorf("cryptTarget on a device with no payload: got nil, want error")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Skipping, not root")
+	}
+	if _, err := os.Stat(dm.ControlPath); err != nil {
+		t.Skipf("Skipping, no device-mapper: %v", err)
+	}
+
+	key := randBytes(t, testKeySize)
+	img := formatLUKS1(t, key)
+	v, err := Read(bytes.NewReader(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Fill the payload with recognizable data encrypted with the volume key.
+	data := bytes.Repeat([]byte("u-root"), 4096/6+1)[:4096]
+	payload := append([]byte(nil), data...)
+	encryptSectors(t, key, payload)
+	copy(img[v.PayloadOffset:], payload)
+
+	dir, err := ioutil.TempDir("", "luks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "disk.img")
+	if err := ioutil.WriteFile(file, img, 0600); err != nil {
+		t.Fatal(err)
+	}
+	dev, err := loop.FindDevice()
+	if err != nil {
+		t.Skipf("Skipping, no loop device: %v", err)
+	}
+	if err := loop.SetFile(dev, file); err != nil {
+		t.Fatal(err)
+	}
+	defer loop.ClearFile(dev)
+
+	path, err := Open(dev, "uroot-luks-test", []byte(testPassphrase), true)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer Close("uroot-luks-test")
+
+	got, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("decrypted payload does not match")
+	}
+}
@@ -0,0 +1,394 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package luks
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/xts"
+)
+
+const (
+	testKeySize    = 64
+	testStripes    = 4000
+	testIterations = 1000
+	testPassphrase = "correct horse battery staple"
+)
+
+// afSplit is the inverse of afMerge.
+func afSplit(t *testing.T, key []byte, stripes int, hash string) []byte {
+	h, err := hashFunc(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make([]byte, len(key)*stripes)
+	if _, err := rand.Read(out[:len(key)*(stripes-1)]); err != nil {
+		t.Fatal(err)
+	}
+	d := make([]byte, len(key))
+	for i := 0; i < stripes-1; i++ {
+		xorBytes(d, out[i*len(key):(i+1)*len(key)])
+		d = diffuse(d, h)
+	}
+	last := out[(stripes-1)*len(key):]
+	copy(last, key)
+	xorBytes(last, d)
+	return out
+}
+
+// encryptSectors is the inverse of decryptSectors for aes-xts-plain64.
+func encryptSectors(t *testing.T, key, data []byte) {
+	c, err := xts.NewCipher(aes.NewCipher, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for off := 0; off < len(data); off += 512 {
+		s := data[off : off+512]
+		c.Encrypt(s, s, uint64(off/512))
+	}
+}
+
+func randBytes(t *testing.T, n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// keyMaterial returns the encrypted, split volume key for a slot protected
+// by kek, padded to whole sectors.
+func keyMaterial(t *testing.T, kek, key []byte) []byte {
+	split := afSplit(t, key, testStripes, "sha256")
+	m := make([]byte, (len(split)+511)&^511)
+	copy(m, split)
+	encryptSectors(t, kek, m)
+	return m
+}
+
+// formatLUKS1 builds a LUKS1 image with the passphrase in slot 1.
+func formatLUKS1(t *testing.T, key []byte) []byte {
+	var h luks1Header
+	copy(h.Magic[:], Magic)
+	h.Version = 1
+	copy(h.CipherName[:], "aes")
+	copy(h.CipherMode[:], "xts-plain64")
+	copy(h.HashSpec[:], "sha256")
+	copy(h.UUID[:], "0a3e4e55-3c07-4c39-a0b5-39e7e0f2f1a1")
+	h.KeyBytes = uint32(len(key))
+	h.MKDigestIter = testIterations
+	copy(h.MKDigestSalt[:], randBytes(t, 32))
+	d, err := pbkdf2Key(key, h.MKDigestSalt[:], testIterations, luks1DigestSize, "sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(h.MKDigest[:], d)
+
+	const slot = 1
+	s := &h.KeySlots[slot]
+	s.Active = luks1SlotEnabled
+	s.Iterations = testIterations
+	s.Stripes = testStripes
+	s.KeyMaterialOffset = 8
+	copy(s.Salt[:], randBytes(t, 32))
+	kek, err := pbkdf2Key([]byte(testPassphrase), s.Salt[:], testIterations, len(key), "sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := keyMaterial(t, kek, key)
+	h.PayloadOffset = s.KeyMaterialOffset + uint32(len(m)/512)
+
+	img := make([]byte, int(h.PayloadOffset)*512+4096)
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.BigEndian, h); err != nil {
+		t.Fatal(err)
+	}
+	copy(img, b.Bytes())
+	copy(img[s.KeyMaterialOffset*512:], m)
+	return img
+}
+
+// formatLUKS2 builds a LUKS2 image with the passphrase in slot 3, using kdf.
+func formatLUKS2(t *testing.T, key []byte, kdf string) []byte {
+	const (
+		hdrSize  = 16384
+		areaOff  = 2 * hdrSize
+		dataOff  = 1 << 20
+		sectorSz = 4096
+	)
+	salt := randBytes(t, 32)
+	var kek []byte
+	kdfJSON := map[string]interface{}{"type": kdf, "salt": salt}
+	switch kdf {
+	case "pbkdf2":
+		var err error
+		kek, err = pbkdf2Key([]byte(testPassphrase), salt, testIterations, len(key), "sha256")
+		if err != nil {
+			t.Fatal(err)
+		}
+		kdfJSON["hash"] = "sha256"
+		kdfJSON["iterations"] = testIterations
+	default:
+		kek = argon2Key(kdf, []byte(testPassphrase), salt, 1, 64, 1, len(key))
+		kdfJSON["time"] = 1
+		kdfJSON["memory"] = 64
+		kdfJSON["cpus"] = 1
+	}
+	m := keyMaterial(t, kek, key)
+
+	dsalt := randBytes(t, 32)
+	d, err := pbkdf2Key(key, dsalt, testIterations, 32, "sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := map[string]interface{}{
+		"keyslots": map[string]interface{}{
+			"3": map[string]interface{}{
+				"type":     "luks2",
+				"key_size": len(key),
+				"area": map[string]interface{}{
+					"type":       "raw",
+					"offset":     fmt.Sprint(areaOff),
+					"size":       fmt.Sprint(len(m)),
+					"encryption": "aes-xts-plain64",
+					"key_size":   len(key),
+				},
+				"kdf": kdfJSON,
+				"af": map[string]interface{}{
+					"type":    "luks1",
+					"stripes": testStripes,
+					"hash":    "sha256",
+				},
+			},
+		},
+		"segments": map[string]interface{}{
+			"0": map[string]interface{}{
+				"type":        "crypt",
+				"offset":      fmt.Sprint(dataOff),
+				"size":        "dynamic",
+				"iv_tweak":    "0",
+				"encryption":  "aes-xts-plain64",
+				"sector_size": sectorSz,
+			},
+		},
+		"digests": map[string]interface{}{
+			"0": map[string]interface{}{
+				"type":       "pbkdf2",
+				"keyslots":   []string{"3"},
+				"segments":   []string{"0"},
+				"hash":       "sha256",
+				"iterations": testIterations,
+				"salt":       dsalt,
+				"digest":     d,
+			},
+		},
+		"config": map[string]interface{}{
+			"json_size":     fmt.Sprint(hdrSize - luks2BinaryHeaderSize),
+			"keyslots_size": fmt.Sprint(dataOff - areaOff),
+		},
+	}
+	js, err := json.Marshal(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img := make([]byte, dataOff+sectorSz)
+	for _, off := range []int64{0, hdrSize} {
+		var h luks2Header
+		copy(h.Magic[:], Magic)
+		if off != 0 {
+			copy(h.Magic[:], luks2SecondaryMagic)
+		}
+		h.Version = 2
+		h.HdrSize = hdrSize
+		h.SeqID = 1
+		h.HdrOffset = uint64(off)
+		copy(h.Label[:], "testvol")
+		copy(h.ChecksumAlg[:], "sha256")
+		copy(h.UUID[:], "5d0a8f0e-8e1c-4bd8-8f50-3f3f2b6a2c11")
+		var b bytes.Buffer
+		if err := binary.Write(&b, binary.BigEndian, h); err != nil {
+			t.Fatal(err)
+		}
+		hdr := img[off : off+hdrSize]
+		copy(hdr, b.Bytes())
+		copy(hdr[luks2BinaryHeaderSize:], js)
+		sum := sha256.Sum256(hdr)
+		copy(hdr[luks2ChecksumOffset:], sum[:])
+	}
+	copy(img[areaOff:], m)
+	return img
+}
+
+func TestUnlock(t *testing.T) {
+	key := randBytes(t, testKeySize)
+	for _, tt := range []struct {
+		name    string
+		img     []byte
+		version int
+		slot    int
+	}{
+		{"luks1", formatLUKS1(t, key), 1, 1},
+		{"luks2-pbkdf2", formatLUKS2(t, key, "pbkdf2"), 2, 3},
+		{"luks2-argon2i", formatLUKS2(t, key, "argon2i"), 2, 3},
+		{"luks2-argon2id", formatLUKS2(t, key, "argon2id"), 2, 3},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bytes.NewReader(tt.img)
+			v, err := Read(r)
+			if err != nil {
+				t.Fatalf("Read: %v", err)
+			}
+			if v.Version != tt.version {
+				t.Errorf("Version = %d, want %d", v.Version, tt.version)
+			}
+			if v.Cipher != "aes-xts-plain64" {
+				t.Errorf("Cipher = %q, want aes-xts-plain64", v.Cipher)
+			}
+			if v.KeySize != testKeySize {
+				t.Errorf("KeySize = %d, want %d", v.KeySize, testKeySize)
+			}
+
+			got, slot, err := v.Unlock(r, []byte(testPassphrase))
+			if err != nil {
+				t.Fatalf("Unlock: %v", err)
+			}
+			if slot != tt.slot {
+				t.Errorf("Unlock slot = %d, want %d", slot, tt.slot)
+			}
+			if !bytes.Equal(got, key) {
+				t.Errorf("Unlock key = %x, want %x", got, key)
+			}
+
+			if _, _, err := v.Unlock(r, []byte("wrong")); err != ErrBadPassphrase {
+				t.Errorf("Unlock with wrong passphrase: got %v, want %v", err, ErrBadPassphrase)
+			}
+
+			// A slot that cannot be tried does not hide later ones.
+			v.KeySlots = append([]KeySlot{{ID: 7, KDF: "scrypt"}}, v.KeySlots...)
+			if _, slot, err := v.Unlock(r, []byte(testPassphrase)); err != nil || slot != tt.slot {
+				t.Errorf("Unlock after an unsupported slot = %d, %v, want %d", slot, err, tt.slot)
+			}
+			if _, _, err := v.Unlock(r, []byte("wrong")); err != ErrBadPassphrase {
+				t.Errorf("Unlock with wrong passphrase and an unsupported slot: got %v, want %v", err, ErrBadPassphrase)
+			}
+
+			var b bytes.Buffer
+			v.Dump(&b)
+			if !strings.Contains(b.String(), v.UUID) {
+				t.Errorf("Dump does not contain UUID %q:\n%s", v.UUID, b.String())
+			}
+		})
+	}
+}
+
+func TestUnlockBadKeySlot(t *testing.T) {
+	r := bytes.NewReader(formatLUKS1(t, randBytes(t, testKeySize)))
+	v, err := Read(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name   string
+		modify func(v *Volume, ks *KeySlot)
+	}{
+		{"no stripes", func(v *Volume, ks *KeySlot) { ks.Stripes = 0 }},
+		{"too many stripes", func(v *Volume, ks *KeySlot) { ks.Stripes = 1 << 30 }},
+		{"huge key", func(v *Volume, ks *KeySlot) { v.KeySize = 1 << 30 }},
+		{"no key", func(v *Volume, ks *KeySlot) { ks.KeySize = 0 }},
+	} {
+		w := *v
+		ks := v.KeySlots[0]
+		tt.modify(&w, &ks)
+		if key, err := w.unlockSlot(r, &ks, []byte(testPassphrase)); err == nil {
+			t.Errorf("%s: unlockSlot = %x, want error", tt.name, key)
+		}
+	}
+}
+
+func TestLUKS2SecondaryHeader(t *testing.T) {
+	key := randBytes(t, testKeySize)
+	for _, tt := range []struct {
+		name    string
+		corrupt func(img []byte)
+	}{
+		// The checksum must catch corrupted primary metadata.
+		{"metadata", func(img []byte) { img[luks2BinaryHeaderSize] ^= 0xff }},
+		{"magic", func(img []byte) { copy(img, make([]byte, len(Magic))) }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			img := formatLUKS2(t, key, "pbkdf2")
+			tt.corrupt(img)
+			r := bytes.NewReader(img)
+			v, err := Read(r)
+			if err != nil {
+				t.Fatalf("Read: %v", err)
+			}
+			if v.Label != "testvol" {
+				t.Errorf("Label = %q, want testvol", v.Label)
+			}
+			if v.SectorSize != 4096 {
+				t.Errorf("SectorSize = %d, want 4096", v.SectorSize)
+			}
+			got, _, err := v.Unlock(r, []byte(testPassphrase))
+			if err != nil {
+				t.Fatalf("Unlock: %v", err)
+			}
+			if !bytes.Equal(got, key) {
+				t.Errorf("Unlock = %x, want %x", got, key)
+			}
+		})
+	}
+}
+
+func TestReadNotLUKS(t *testing.T) {
+	if _, err := Read(bytes.NewReader(make([]byte, 4096))); err != ErrNotLUKS {
+		t.Errorf("Read: got %v, want %v", err, ErrNotLUKS)
+	}
+}
+
+func TestDecryptSectorsCBC(t *testing.T) {
+	key := randBytes(t, 32)
+	plain := randBytes(t, 1024)
+	for _, iv := range []string{"plain", "plain64", "essiv:sha256"} {
+		t.Run(iv, func(t *testing.T) {
+			gen, err := ivGenerator(iv, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := aes.NewCipher(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data := append([]byte(nil), plain...)
+			for off := 0; off < len(data); off += 512 {
+				s := data[off : off+512]
+				enc := cbcEncrypt(b, gen(uint64(off/512)), s)
+				copy(s, enc)
+			}
+			if err := decryptSectors("aes-cbc-"+iv, key, data, 512, 0); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, plain) {
+				t.Errorf("aes-cbc-%s round trip failed", iv)
+			}
+		})
+	}
+}
+
+func cbcEncrypt(b cipher.Block, iv, src []byte) []byte {
+	dst := make([]byte, len(src))
+	cipher.NewCBCEncrypter(b, iv).CryptBlocks(dst, src)
+	return dst
+}
@@ -0,0 +1,93 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package measure
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// program measures the program at path before it is executed. A program
+// that does not exist is left to fail to execute.
+func program(path string) error {
+	if err := Program(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Cmd is an exec.Cmd whose program is measured before it starts.
+type Cmd struct {
+	*exec.Cmd
+}
+
+// Command returns a Cmd like exec.Command.
+func Command(name string, arg ...string) *Cmd {
+	return &Cmd{exec.Command(name, arg...)}
+}
+
+// measure measures the program of c. exec.Command leaves Path without a
+// slash only if it could not find the program in $PATH; that error is
+// returned rather than measuring a file of the same name in the working
+// directory.
+func (c *Cmd) measure() error {
+	if !strings.Contains(c.Path, "/") {
+		if _, err := exec.LookPath(c.Path); err != nil {
+			return err
+		}
+	}
+	return program(c.Path)
+}
+
+// Start measures the program and starts it.
+func (c *Cmd) Start() error {
+	if err := c.measure(); err != nil {
+		return err
+	}
+	return c.Cmd.Start()
+}
+
+// Run measures the program, starts it and waits for it to finish.
+func (c *Cmd) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+// Output measures the program, runs it and returns its standard output.
+func (c *Cmd) Output() ([]byte, error) {
+	if err := c.measure(); err != nil {
+		return nil, err
+	}
+	return c.Cmd.Output()
+}
+
+// CombinedOutput measures the program, runs it and returns its combined
+// standard output and standard error.
+func (c *Cmd) CombinedOutput() ([]byte, error) {
+	if err := c.measure(); err != nil {
+		return nil, err
+	}
+	return c.Cmd.CombinedOutput()
+}
+
+// StartProcess measures the program and starts it like os.StartProcess.
+func StartProcess(name string, argv []string, attr *os.ProcAttr) (*os.Process, error) {
+	if err := program(name); err != nil {
+		return nil, err
+	}
+	return os.StartProcess(name, argv, attr)
+}
+
+// Exec measures the program and executes it like syscall.Exec.
+func Exec(argv0 string, argv []string, envv []string) error {
+	if err := program(argv0); err != nil {
+		return err
+	}
+	return syscall.Exec(argv0, argv, envv)
+}
@@ -0,0 +1,213 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package measure records every program u-root runs, in the manner of the
+// Linux Integrity Measurement Architecture (IMA).
+//
+// Before a program is executed, its contents are hashed. The hash and the
+// program's path form an IMA "ima" template entry, whose hash is extended
+// into a TPM PCR and which is appended to a log in the format of
+// /sys/kernel/security/ima/ascii_runtime_measurements. Replaying the log
+// reproduces the PCR value, so a verifier can tell exactly what ran before,
+// e.g., kexec.
+//
+// Busybox-mode commands are measured by the path they were invoked as, so
+// the log shows which bb sub-command ran even though they all share one
+// binary.
+//
+// Measurement is configured with environment variables, which init passes
+// on to everything it starts, and which can be set on the kernel command
+// line:
+//
+//	UROOT_MEASURE_PCR: the PCR to extend in the TPM at /dev/tpm0
+//	UROOT_MEASURE_LOG: the file to append measurements to
+//
+// If neither is set, nothing is measured.
+package measure
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/google/go-tpm/tpm"
+)
+
+const (
+	// PCREnv and LogEnv are the environment variables configuring the
+	// default Measurer.
+	PCREnv = "UROOT_MEASURE_PCR"
+	LogEnv = "UROOT_MEASURE_LOG"
+
+	// TPMDevice is the TPM the default Measurer extends.
+	TPMDevice = "/dev/tpm0"
+
+	// DefaultPCR is the PCR Linux IMA uses.
+	DefaultPCR = 10
+
+	// nameLen is the size of the file name field in an "ima" template.
+	nameLen = 256
+)
+
+// Measurer measures programs.
+//
+// A nil *Measurer measures nothing.
+type Measurer struct {
+	// PCR is the PCR extended with each measurement.
+	PCR uint32
+
+	// TPM, if not nil, is extended with each measurement.
+	TPM io.ReadWriter
+
+	// Log, if not nil, has each measurement appended.
+	Log io.Writer
+
+	mu sync.Mutex
+	// seen holds the template hashes already recorded, so running the
+	// same program again does not grow the log.
+	seen map[[sha1.Size]byte]bool
+}
+
+// Entry is a measurement.
+type Entry struct {
+	// Path is the program as it was executed.
+	Path string
+
+	// Digest is the SHA1 hash of the program's contents.
+	Digest [sha1.Size]byte
+}
+
+// TemplateHash is the hash of e's "ima" template data, which is what gets
+// extended into the PCR.
+func (e Entry) TemplateHash() [sha1.Size]byte {
+	var name [nameLen]byte
+	copy(name[:nameLen-1], e.Path)
+	h := sha1.New()
+	h.Write(e.Digest[:])
+	h.Write(name[:])
+	var s [sha1.Size]byte
+	copy(s[:], h.Sum(nil))
+	return s
+}
+
+// String formats e as a line of the IMA ASCII measurement log, without the
+// PCR.
+func (e Entry) String() string {
+	return fmt.Sprintf("%x ima %x %s", e.TemplateHash(), e.Digest, e.Path)
+}
+
+// Hash returns the entry for the program at path.
+func Hash(path string) (Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Entry{}, err
+	}
+	defer f.Close()
+	h := sha1.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return Entry{}, fmt.Errorf("measuring %s: %v", path, err)
+	}
+	e := Entry{Path: path}
+	copy(e.Digest[:], h.Sum(nil))
+	return e, nil
+}
+
+// Measure hashes the program at path, extends the PCR and logs it.
+func (m *Measurer) Measure(path string) error {
+	if m == nil {
+		return nil
+	}
+	e, err := Hash(path)
+	if err != nil {
+		return err
+	}
+	return m.Record(e)
+}
+
+// Record extends the PCR with e and logs it, unless it was recorded
+// before.
+func (m *Measurer) Record(e Entry) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	th := e.TemplateHash()
+	if m.seen[th] {
+		return nil
+	}
+	if m.TPM != nil {
+		if _, err := tpm.PcrExtend(m.TPM, m.PCR, th); err != nil {
+			return fmt.Errorf("extending PCR %d: %v", m.PCR, err)
+		}
+	}
+	if m.Log != nil {
+		// One write per entry, so that entries from several processes
+		// appending to the same file do not interleave.
+		if _, err := io.WriteString(m.Log, fmt.Sprintf("%d %s\n", m.PCR, e)); err != nil {
+			return fmt.Errorf("logging measurement: %v", err)
+		}
+	}
+	if m.seen == nil {
+		m.seen = make(map[[sha1.Size]byte]bool)
+	}
+	m.seen[th] = true
+	return nil
+}
+
+var (
+	defaultOnce     sync.Once
+	defaultMeasurer *Measurer
+	defaultErr      error
+)
+
+// FromEnv returns a Measurer configured by PCREnv and LogEnv, or nil if
+// neither is set.
+func FromEnv() (*Measurer, error) {
+	pcr, logFile := os.Getenv(PCREnv), os.Getenv(LogEnv)
+	if pcr == "" && logFile == "" {
+		return nil, nil
+	}
+	m := &Measurer{PCR: DefaultPCR}
+	if pcr != "" {
+		n, err := strconv.ParseUint(pcr, 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s: bad PCR %q", PCREnv, pcr)
+		}
+		m.PCR = uint32(n)
+		if m.TPM, err = tpm.OpenTPM(TPMDevice); err != nil {
+			return nil, err
+		}
+	}
+	if logFile != "" {
+		f, err := os.OpenFile(logFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		m.Log = f
+	}
+	return m, nil
+}
+
+// Default returns the Measurer configured by the environment, which is
+// set up on first use.
+func Default() (*Measurer, error) {
+	defaultOnce.Do(func() {
+		defaultMeasurer, defaultErr = FromEnv()
+	})
+	return defaultMeasurer, defaultErr
+}
+
+// Program measures the program at path with the default Measurer.
+func Program(path string) error {
+	m, err := Default()
+	if err != nil {
+		return fmt.Errorf("measurement: %v", err)
+	}
+	return m.Measure(path)
+}
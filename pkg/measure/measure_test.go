@@ -0,0 +1,121 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package measure
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "measure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	prog := filepath.Join(dir, "prog")
+	if err := ioutil.WriteFile(prog, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var log bytes.Buffer
+	m := &Measurer{PCR: DefaultPCR, Log: &log}
+	for i := 0; i < 2; i++ {
+		if err := m.Measure(prog); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	digest := sha1.Sum([]byte("#!/bin/sh\n"))
+	var name [nameLen]byte
+	copy(name[:], prog)
+	template := sha1.Sum(append(digest[:], name[:]...))
+	want := fmt.Sprintf("10 %x ima %x %s\n", template, digest, prog)
+	if got := log.String(); got != want {
+		t.Errorf("log = %q, want %q", got, want)
+	}
+
+	// A changed program is measured again.
+	if err := ioutil.WriteFile(prog, []byte("#!/bin/rush\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Measure(prog); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(log.String(), "\n"); n != 2 {
+		t.Errorf("log has %d entries after the program changed, want 2", n)
+	}
+}
+
+func TestNilMeasurer(t *testing.T) {
+	var m *Measurer
+	if err := m.Measure("/does/not/exist"); err != nil {
+		t.Errorf("nil Measurer: Measure() = %v, want nil", err)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "measure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The default Measurer is configured on first use.
+	logFile := filepath.Join(dir, "log")
+	os.Setenv(LogEnv, logFile)
+	defer os.Unsetenv(LogEnv)
+
+	prog := filepath.Join(dir, "true")
+	if err := ioutil.WriteFile(prog, []byte("#!/bin/sh\nexit 0\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := Command(prog).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := Command(filepath.Join(dir, "missing")).Run(); err == nil {
+		t.Errorf("running a missing program succeeded")
+	}
+
+	// A name that is not in $PATH is not measured from the working
+	// directory.
+	local := "measure-test-prog"
+	if err := ioutil.WriteFile(filepath.Join(dir, local), []byte("#!/bin/sh\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := Command(local).Run(); err == nil {
+		t.Errorf("running %s, which is not in $PATH, succeeded", local)
+	} else if _, ok := err.(*exec.Error); !ok {
+		t.Errorf("running %s = %v, want an *exec.Error", local, err)
+	}
+
+	b, err := ioutil.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := Hash(prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("10 %s\n", e); string(b) != want {
+		t.Errorf("log = %q, want %q", b, want)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nbd
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// ioctls of /dev/nbdX, from linux/nbd.h.
+const (
+	nbdSetSock       = 0xab00
+	nbdSetBlksize    = 0xab01
+	nbdDoIt          = 0xab03
+	nbdClearSock     = 0xab04
+	nbdClearQue      = 0xab05
+	nbdSetSizeBlocks = 0xab07
+	nbdDisconnect    = 0xab08
+	nbdSetTimeout    = 0xab09
+	nbdSetFlags      = 0xab0a
+)
+
+// Device is an NBD device served by the kernel.
+type Device struct {
+	// Path is the path of the device, such as /dev/nbd0.
+	Path string
+
+	f    *os.File
+	done chan error
+}
+
+// blockSize returns the block size to give the kernel, which takes powers
+// of two from 512 to the page size.
+func (c *Client) blockSize() uint32 {
+	bs := c.BlockSize
+	if bs < 512 || bs > uint32(os.Getpagesize()) || bs&(bs-1) != 0 {
+		return 512
+	}
+	return bs
+}
+
+// Attach hands the connection of c to the kernel, which serves the export
+// as the device dev, such as /dev/nbd0, until Detach is called or the
+// connection fails. The client must not be used afterwards.
+//
+// timeout is how long the kernel waits for replies, or forever if 0.
+func (c *Client) Attach(dev string, timeout time.Duration) (*Device, error) {
+	sc, ok := c.conn.(syscall.Conn)
+	if !ok {
+		return nil, fmt.Errorf("nbd: cannot attach a %T", c.conn)
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(dev, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	bs := c.blockSize()
+	var ierr error
+	err = rc.Control(func(sock uintptr) {
+		fd := int(f.Fd())
+		for _, s := range []struct {
+			req int
+			val int
+		}{
+			{nbdSetBlksize, int(bs)},
+			{nbdSetSizeBlocks, int(c.Size / uint64(bs))},
+			{nbdSetFlags, int(c.Flags)},
+			{nbdSetTimeout, int(timeout / time.Second)},
+			{nbdSetSock, int(sock)},
+		} {
+			if ierr = unix.IoctlSetInt(fd, uint(s.req), s.val); ierr != nil {
+				ierr = fmt.Errorf("ioctl %#x: %v", s.req, ierr)
+				return
+			}
+		}
+	})
+	if err == nil {
+		err = ierr
+	}
+	if err != nil {
+		unix.IoctlSetInt(int(f.Fd()), nbdClearSock, 0)
+		f.Close()
+		return nil, fmt.Errorf("nbd: attaching %s: %v", dev, err)
+	}
+
+	d := &Device{Path: dev, f: f, done: make(chan error, 1)}
+	go func() {
+		// NBD_DO_IT serves the device until it is disconnected.
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		fd := int(f.Fd())
+		err := unix.IoctlSetInt(fd, nbdDoIt, 0)
+		unix.IoctlSetInt(fd, nbdClearQue, 0)
+		unix.IoctlSetInt(fd, nbdClearSock, 0)
+		// The kernel holds its own reference to the socket.
+		c.conn.Close()
+		d.done <- err
+	}()
+	return d, nil
+}
+
+// Wait waits until the kernel stops serving the device.
+func (d *Device) Wait() error {
+	err := <-d.done
+	d.done <- err
+	return err
+}
+
+// Detach disconnects the device from the server and waits until the kernel
+// stops serving it.
+func (d *Device) Detach() error {
+	if err := unix.IoctlSetInt(int(d.f.Fd()), nbdDisconnect, 0); err != nil {
+		return fmt.Errorf("nbd: detaching %s: %v", d.Path, err)
+	}
+	d.Wait()
+	return d.f.Close()
+}
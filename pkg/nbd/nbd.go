@@ -0,0 +1,390 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package nbd implements a client of the Network Block Device protocol.
+//
+// Exports are opened with the fixed newstyle negotiation, and read and
+// written with simple replies. On Linux, a client's connection can be
+// handed to the kernel, which then serves the export as /dev/nbdX.
+//
+// See https://github.com/NetworkBlockDevice/nbd/blob/master/doc/proto.md.
+package nbd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+)
+
+// Magic numbers.
+const (
+	nbdMagic     = 0x4e42444d41474943 // "NBDMAGIC"
+	optMagic     = 0x49484156454f5054 // "IHAVEOPT"
+	oldMagic     = 0x00420281861253
+	replyMagic   = 0x3e889045565a9
+	requestMagic = 0x25609513
+	simpleMagic  = 0x67446698
+)
+
+// Handshake flags, of the server and the client.
+const (
+	flagFixedNewstyle = 1 << 0
+	flagNoZeroes      = 1 << 1
+)
+
+// Options.
+const (
+	optExportName = 1
+	optAbort      = 2
+	optGo         = 7
+)
+
+// Option replies. Errors have the top bit set.
+const (
+	repAck        = 1
+	repInfo       = 3
+	repErr        = 1 << 31
+	repErrUnsup   = repErr | 1
+	repErrUnknown = repErr | 6
+)
+
+// Information types of option replies.
+const (
+	infoExport    = 0
+	infoBlockSize = 3
+)
+
+// Transmission flags of an export.
+const (
+	FlagHasFlags   = 1 << 0
+	FlagReadOnly   = 1 << 1
+	FlagSendFlush  = 1 << 2
+	FlagSendFUA    = 1 << 3
+	FlagRotational = 1 << 4
+	FlagSendTrim   = 1 << 5
+)
+
+// Commands.
+const (
+	cmdRead  = 0
+	cmdWrite = 1
+	cmdDisc  = 2
+	cmdFlush = 3
+	cmdTrim  = 4
+)
+
+// maxRequest is the largest read or write of a single request.
+const maxRequest = 32 << 20
+
+// maxOptionReply is the largest option reply data read from a server.
+const maxOptionReply = 64 << 10
+
+var (
+	// ErrOldstyle is returned for servers that only speak the oldstyle
+	// negotiation.
+	ErrOldstyle = errors.New("server uses the oldstyle negotiation")
+
+	// ErrReadOnly is returned by writes to read-only exports.
+	ErrReadOnly = errors.New("export is read-only")
+
+	// ErrUnsupported is returned for commands the export does not take.
+	ErrUnsupported = errors.New("command not supported by the export")
+)
+
+// Export is an export of a server.
+type Export struct {
+	Name string
+
+	// Size is the size of the export in bytes.
+	Size uint64
+
+	// Flags are the transmission flags of the export.
+	Flags uint16
+
+	// BlockSize is the block size the server prefers, or 0.
+	BlockSize uint32
+}
+
+// Client is a connection to an export. Its methods may be called
+// concurrently, but requests are made one at a time.
+type Client struct {
+	Export
+
+	mu     sync.Mutex
+	conn   net.Conn
+	handle uint64
+}
+
+// Dial connects to the server at address on network, such as "tcp" and
+// "host:10809", or "unix" and a socket path, and opens the export name.
+func Dial(network, address, name string) (*Client, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewClient(conn, name)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewClient opens the export name of the server on conn.
+func NewClient(conn net.Conn, name string) (*Client, error) {
+	c := &Client{conn: conn, Export: Export{Name: name}}
+	if err := c.negotiate(); err != nil {
+		return nil, fmt.Errorf("nbd: %v", err)
+	}
+	return c, nil
+}
+
+func (c *Client) read(v ...interface{}) error {
+	for _, x := range v {
+		if err := binary.Read(c.conn, binary.BigEndian, x); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) write(v ...interface{}) error {
+	var b []byte
+	for _, x := range v {
+		switch x := x.(type) {
+		case uint16:
+			b = append(b, byte(x>>8), byte(x))
+		case uint32:
+			b = append(b, 0, 0, 0, 0)
+			binary.BigEndian.PutUint32(b[len(b)-4:], x)
+		case uint64:
+			b = append(b, 0, 0, 0, 0, 0, 0, 0, 0)
+			binary.BigEndian.PutUint64(b[len(b)-8:], x)
+		case []byte:
+			b = append(b, x...)
+		default:
+			panic(fmt.Sprintf("nbd: cannot write %T", x))
+		}
+	}
+	_, err := c.conn.Write(b)
+	return err
+}
+
+func (c *Client) negotiate() error {
+	var magic, opt uint64
+	var flags uint16
+	if err := c.read(&magic, &opt); err != nil {
+		return err
+	}
+	if magic != nbdMagic {
+		return fmt.Errorf("bad magic %#x", magic)
+	}
+	if opt == oldMagic {
+		return ErrOldstyle
+	}
+	if opt != optMagic {
+		return fmt.Errorf("bad option magic %#x", opt)
+	}
+	if err := c.read(&flags); err != nil {
+		return err
+	}
+	clientFlags := uint32(flags & (flagFixedNewstyle | flagNoZeroes))
+	if err := c.write(clientFlags); err != nil {
+		return err
+	}
+
+	// Only fixed newstyle servers answer other options than
+	// NBD_OPT_EXPORT_NAME.
+	if flags&flagFixedNewstyle != 0 {
+		err := c.optGo()
+		if err != errUnsupported {
+			return err
+		}
+	}
+	return c.optExportName(flags&flagNoZeroes != 0)
+}
+
+// errUnsupported is returned by optGo if the server does not know
+// NBD_OPT_GO.
+var errUnsupported = errors.New("NBD_OPT_GO not supported")
+
+// optGo opens the export with NBD_OPT_GO, asking for the block size too.
+func (c *Client) optGo() error {
+	name := []byte(c.Name)
+	if err := c.write(uint64(optMagic), uint32(optGo), uint32(4+len(name)+2+2), uint32(len(name)), name, uint16(1), uint16(infoBlockSize)); err != nil {
+		return err
+	}
+	for {
+		var magic uint64
+		var option, typ, length uint32
+		if err := c.read(&magic, &option, &typ, &length); err != nil {
+			return err
+		}
+		if magic != replyMagic || option != optGo {
+			return fmt.Errorf("bad option reply %#x for option %d", magic, option)
+		}
+		if length > maxOptionReply {
+			return fmt.Errorf("option reply of %d bytes is too large", length)
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(c.conn, data); err != nil {
+			return err
+		}
+		switch {
+		case typ == repAck:
+			if c.Flags&FlagHasFlags == 0 && c.Size == 0 {
+				return fmt.Errorf("no export information")
+			}
+			return nil
+		case typ == repErrUnsup:
+			return errUnsupported
+		case typ == repErrUnknown:
+			return fmt.Errorf("no export %q: %s", c.Name, data)
+		case typ&repErr != 0:
+			return fmt.Errorf("option error %#x: %s", typ, data)
+		case typ == repInfo && len(data) >= 2:
+			switch binary.BigEndian.Uint16(data) {
+			case infoExport:
+				if len(data) >= 12 {
+					c.Size = binary.BigEndian.Uint64(data[2:])
+					c.Flags = binary.BigEndian.Uint16(data[10:])
+				}
+			case infoBlockSize:
+				if len(data) >= 14 {
+					c.BlockSize = binary.BigEndian.Uint32(data[6:])
+				}
+			}
+		}
+	}
+}
+
+// optExportName opens the export with NBD_OPT_EXPORT_NAME, which servers
+// answer by closing the connection if they have no such export.
+func (c *Client) optExportName(noZeroes bool) error {
+	name := []byte(c.Name)
+	if err := c.write(uint64(optMagic), uint32(optExportName), uint32(len(name)), name); err != nil {
+		return err
+	}
+	if err := c.read(&c.Size, &c.Flags); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("no export %q", c.Name)
+		}
+		return err
+	}
+	if !noZeroes {
+		if _, err := io.ReadFull(c.conn, make([]byte, 124)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// request makes a request, writing data, and reads the reply into p.
+func (c *Client) request(cmd uint16, off uint64, length uint32, data, p []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handle++
+	if err := c.write(uint32(requestMagic), uint16(0), cmd, c.handle, off, length, data); err != nil {
+		return err
+	}
+	var magic, errno uint32
+	var handle uint64
+	if err := c.read(&magic, &errno, &handle); err != nil {
+		return err
+	}
+	if magic != simpleMagic {
+		return fmt.Errorf("nbd: bad reply magic %#x", magic)
+	}
+	if handle != c.handle {
+		return fmt.Errorf("nbd: reply to request %d, want %d", handle, c.handle)
+	}
+	if errno != 0 {
+		// The errors are those of Linux.
+		return syscall.Errno(errno)
+	}
+	if p != nil {
+		if _, err := io.ReadFull(c.conn, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadAt implements io.ReaderAt.
+func (c *Client) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("nbd: negative offset %d", off)
+	}
+	var err error
+	if uint64(off) >= c.Size {
+		return 0, io.EOF
+	}
+	if uint64(off)+uint64(len(p)) > c.Size {
+		p, err = p[:c.Size-uint64(off)], io.EOF
+	}
+	for n := 0; n < len(p); {
+		b := p[n:]
+		if len(b) > maxRequest {
+			b = b[:maxRequest]
+		}
+		if err := c.request(cmdRead, uint64(off)+uint64(n), uint32(len(b)), nil, b); err != nil {
+			return n, err
+		}
+		n += len(b)
+	}
+	return len(p), err
+}
+
+// WriteAt implements io.WriterAt.
+func (c *Client) WriteAt(p []byte, off int64) (int, error) {
+	if c.Flags&FlagReadOnly != 0 {
+		return 0, ErrReadOnly
+	}
+	if off < 0 || uint64(off)+uint64(len(p)) > c.Size {
+		return 0, fmt.Errorf("nbd: write of %d bytes at %d beyond the export's %d", len(p), off, c.Size)
+	}
+	for n := 0; n < len(p); {
+		b := p[n:]
+		if len(b) > maxRequest {
+			b = b[:maxRequest]
+		}
+		if err := c.request(cmdWrite, uint64(off)+uint64(n), uint32(len(b)), b, nil); err != nil {
+			return n, err
+		}
+		n += len(b)
+	}
+	return len(p), nil
+}
+
+// Flush makes the server write what it has been sent to its storage.
+func (c *Client) Flush() error {
+	if c.Flags&FlagSendFlush == 0 {
+		return nil
+	}
+	return c.request(cmdFlush, 0, 0, nil, nil)
+}
+
+// Trim tells the server the length bytes at off are no longer in use.
+func (c *Client) Trim(off int64, length uint32) error {
+	if c.Flags&FlagSendTrim == 0 {
+		return ErrUnsupported
+	}
+	return c.request(cmdTrim, uint64(off), length, nil, nil)
+}
+
+// Close disconnects from the server.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handle++
+	err := c.write(uint32(requestMagic), uint16(0), uint16(cmdDisc), c.handle, uint64(0), uint32(0))
+	if cerr := c.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,294 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nbd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// server is an NBD server of exports in memory.
+type server struct {
+	exports  map[string][]byte
+	readOnly bool
+	// flags are the handshake flags.
+	flags uint16
+	// noGo makes the server not know NBD_OPT_GO.
+	noGo bool
+	// hugeReply makes the server claim a reply of 2 GiB to NBD_OPT_GO.
+	hugeReply bool
+}
+
+func (s *server) serve(conn net.Conn) {
+	defer conn.Close()
+	be := binary.BigEndian
+	w := func(v ...interface{}) {
+		for _, x := range v {
+			binary.Write(conn, be, x)
+		}
+	}
+	w(uint64(nbdMagic), uint64(optMagic), s.flags)
+	var clientFlags uint32
+	if binary.Read(conn, be, &clientFlags) != nil {
+		return
+	}
+
+	var export []byte
+	var tflags uint16 = FlagHasFlags | FlagSendFlush | FlagSendTrim
+	if s.readOnly {
+		tflags |= FlagReadOnly
+	}
+	for export == nil {
+		var hdr struct {
+			Magic  uint64
+			Option uint32
+			Length uint32
+		}
+		if binary.Read(conn, be, &hdr) != nil {
+			return
+		}
+		data := make([]byte, hdr.Length)
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return
+		}
+		reply := func(typ uint32, data []byte) {
+			w(uint64(replyMagic), hdr.Option, typ, uint32(len(data)), data)
+		}
+		switch {
+		case hdr.Option == optExportName:
+			e, ok := s.exports[string(data)]
+			if !ok {
+				return
+			}
+			w(uint64(len(e)), tflags)
+			if clientFlags&flagNoZeroes == 0 {
+				w(make([]byte, 124))
+			}
+			export = e
+
+		case hdr.Option == optGo && s.hugeReply:
+			w(uint64(replyMagic), hdr.Option, uint32(repInfo), uint32(1<<31))
+			return
+
+		case hdr.Option == optGo && !s.noGo:
+			n := be.Uint32(data)
+			e, ok := s.exports[string(data[4:4+n])]
+			if !ok {
+				reply(repErrUnknown, []byte("unknown export"))
+				continue
+			}
+			info := make([]byte, 12)
+			be.PutUint64(info[2:], uint64(len(e)))
+			be.PutUint16(info[10:], tflags)
+			reply(repInfo, info)
+			bs := make([]byte, 14)
+			be.PutUint16(bs, infoBlockSize)
+			be.PutUint32(bs[2:], 1)
+			be.PutUint32(bs[6:], 4096)
+			be.PutUint32(bs[10:], 32<<20)
+			reply(repInfo, bs)
+			reply(repAck, nil)
+			export = e
+
+		default:
+			reply(repErrUnsup, nil)
+		}
+	}
+
+	for {
+		var req struct {
+			Magic  uint32
+			Flags  uint16
+			Type   uint16
+			Handle uint64
+			Offset uint64
+			Length uint32
+		}
+		if binary.Read(conn, be, &req) != nil {
+			return
+		}
+		var errno uint32
+		var data []byte
+		switch req.Type {
+		case cmdRead:
+			data = export[req.Offset : req.Offset+uint64(req.Length)]
+		case cmdWrite:
+			b := make([]byte, req.Length)
+			if _, err := io.ReadFull(conn, b); err != nil {
+				return
+			}
+			if s.readOnly {
+				errno = uint32(syscall.EPERM)
+			} else {
+				copy(export[req.Offset:], b)
+			}
+		case cmdTrim:
+			copy(export[req.Offset:req.Offset+uint64(req.Length)], make([]byte, req.Length))
+		case cmdDisc:
+			return
+		}
+		w(uint32(simpleMagic), errno, req.Handle)
+		if errno == 0 {
+			w(data)
+		}
+	}
+}
+
+// listen starts s on a unix socket, and returns its path and a function
+// that stops it.
+func listen(t *testing.T, s *server) (string, func()) {
+	dir, err := ioutil.TempDir("", "nbd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sock := filepath.Join(dir, "sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return sock, func() {
+		l.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		s         *server
+		blockSize uint32
+	}{
+		{"go", &server{flags: flagFixedNewstyle | flagNoZeroes}, 4096},
+		{"zeroes", &server{flags: flagFixedNewstyle}, 4096},
+		{"no go", &server{flags: flagFixedNewstyle, noGo: true}, 0},
+		{"not fixed", &server{}, 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.s.exports = map[string][]byte{"disk": make([]byte, 8192)}
+			sock, stop := listen(t, tt.s)
+			defer stop()
+			c, err := Dial("unix", sock, "disk")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			if c.Size != 8192 || c.Flags&FlagSendTrim == 0 || c.BlockSize != tt.blockSize {
+				t.Errorf("export = %+v, want size 8192, trim and block size %d", c.Export, tt.blockSize)
+			}
+			if _, err := c.ReadAt(make([]byte, 10), 0); err != nil {
+				t.Errorf("ReadAt = %v", err)
+			}
+		})
+	}
+}
+
+func TestUnknownExport(t *testing.T) {
+	for _, s := range []*server{
+		{flags: flagFixedNewstyle},
+		{flags: flagFixedNewstyle, noGo: true},
+	} {
+		s.exports = map[string][]byte{"disk": nil}
+		sock, stop := listen(t, s)
+		if c, err := Dial("unix", sock, "other"); err == nil {
+			c.Close()
+			t.Errorf("Dial(other) succeeded, want error")
+		}
+		stop()
+	}
+}
+
+func TestHugeOptionReply(t *testing.T) {
+	s := &server{flags: flagFixedNewstyle, hugeReply: true, exports: map[string][]byte{"disk": nil}}
+	sock, stop := listen(t, s)
+	defer stop()
+	c, err := Dial("unix", sock, "disk")
+	if err == nil {
+		c.Close()
+		t.Fatalf("Dial succeeded, want error")
+	}
+	if !strings.Contains(err.Error(), "too large") {
+		t.Errorf("Dial = %v, want a reply that is too large", err)
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	disk := make([]byte, 3<<20)
+	for i := range disk {
+		disk[i] = byte(i)
+	}
+	sock, stop := listen(t, &server{
+		flags:   flagFixedNewstyle | flagNoZeroes,
+		exports: map[string][]byte{"": disk},
+	})
+	defer stop()
+	c, err := Dial("unix", sock, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	p := make([]byte, 1000)
+	if n, err := c.ReadAt(p, 1<<20); n != 1000 || err != nil || !bytes.Equal(p, disk[1<<20:1<<20+1000]) {
+		t.Errorf("ReadAt = %d, %v", n, err)
+	}
+	if n, err := c.ReadAt(p, int64(len(disk))-10); n != 10 || err != io.EOF {
+		t.Errorf("ReadAt at the end = %d, %v, want 10, EOF", n, err)
+	}
+	if n, err := c.WriteAt([]byte("hello"), 100); n != 5 || err != nil {
+		t.Errorf("WriteAt = %d, %v", n, err)
+	}
+	if err := c.Flush(); err != nil {
+		t.Errorf("Flush = %v", err)
+	}
+	if n, err := c.ReadAt(p[:7], 99); n != 7 || err != nil || string(p[1:6]) != "hello" {
+		t.Errorf("ReadAt after WriteAt = %q, %v", p[:7], err)
+	}
+	if err := c.Trim(0, 4096); err != nil {
+		t.Errorf("Trim = %v", err)
+	}
+	if _, err := c.WriteAt(p, int64(len(disk))); err == nil {
+		t.Errorf("WriteAt beyond the end succeeded")
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	sock, stop := listen(t, &server{
+		flags:    flagFixedNewstyle,
+		exports:  map[string][]byte{"ro": make([]byte, 512)},
+		readOnly: true,
+	})
+	defer stop()
+	c, err := Dial("unix", sock, "ro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.WriteAt([]byte("x"), 0); err != ErrReadOnly {
+		t.Errorf("WriteAt = %v, want %v", err, ErrReadOnly)
+	}
+	// Servers refuse writes too.
+	c.Flags &^= FlagReadOnly
+	if _, err := c.WriteAt([]byte("x"), 0); err != syscall.EPERM {
+		t.Errorf("WriteAt = %v, want %v", err, syscall.EPERM)
+	}
+}
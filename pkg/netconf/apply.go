@@ -0,0 +1,389 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netconf
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/u-root/u-root/pkg/dhclient"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	dhcpTimeout = 5 * time.Second
+	dhcpRetries = 3
+)
+
+// Apply configures the network as c describes. DHCP is bounded by ctx.
+//
+// Apply does as much as it can: a failure to configure one interface does
+// not stop others from being configured. All failures are reported in the
+// returned error.
+func Apply(ctx context.Context, c *Config) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	var errs multiError
+
+	if c.Hostname != "" {
+		errs.add(unix.Sethostname([]byte(c.Hostname)), "setting host name")
+	}
+
+	// Bonds and bridges are created before VLANs, which may sit on them.
+	for _, kind := range []string{KindBond, KindBridge, KindVLAN} {
+		for _, i := range c.Interfaces {
+			if i.Kind == kind {
+				errs.add(createLink(i), "creating %s", i.Name)
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for _, i := range c.Interfaces {
+		links, err := configureLink(i)
+		if err != nil {
+			mu.Lock()
+			errs.add(err, "configuring %s", i.displayName())
+			mu.Unlock()
+			continue
+		}
+		if !i.DHCP4 && !i.DHCP6 {
+			continue
+		}
+		wg.Add(1)
+		go func(i Interface) {
+			defer wg.Done()
+			err := dhcp(ctx, links, i.DHCP4, i.DHCP6, i.Name == "")
+			mu.Lock()
+			defer mu.Unlock()
+			errs.add(err, "DHCP on %s", i.displayName())
+		}(i)
+	}
+	wg.Wait()
+
+	for _, r := range c.Routes {
+		errs.add(addRoute(r), "adding route to %s", r.Dst)
+	}
+
+	if len(c.Nameservers) > 0 {
+		var ips []net.IP
+		for _, ns := range c.Nameservers {
+			ips = append(ips, net.ParseIP(ns))
+		}
+		errs.add(dhclient.WriteResolvConf(ips, c.Search), "writing DNS settings")
+	}
+	return errs.err()
+}
+
+// Unconfigured returns c without the interfaces that already have their
+// addresses, such as those the kernel configured from ip= with IP_PNP, so
+// that applying it does not wait for DHCP again.
+func (c *Config) Unconfigured() *Config {
+	u := *c
+	u.Interfaces = nil
+	for _, i := range c.Interfaces {
+		if configured(i) {
+			log.Printf("%s is already configured", i.displayName())
+			continue
+		}
+		u.Interfaces = append(u.Interfaces, i)
+	}
+	return &u
+}
+
+// Link and address lookups of configured, which tests replace.
+var (
+	linkByName = netlink.LinkByName
+	linkList   = netlink.LinkList
+	addrList   = netlink.AddrList
+)
+
+// configured returns true if i's link has its static addresses and, with
+// DHCP, a global address of each family.
+func configured(i Interface) bool {
+	if len(i.Addresses) == 0 && !i.DHCP4 && !i.DHCP6 {
+		return false
+	}
+	var links []netlink.Link
+	if i.Name != "" {
+		l, err := linkByName(i.Name)
+		if err != nil {
+			return false
+		}
+		links = []netlink.Link{l}
+	} else {
+		all, err := linkList()
+		if err != nil {
+			return false
+		}
+		for _, l := range all {
+			if l.Attrs().Flags&net.FlagLoopback == 0 {
+				links = append(links, l)
+			}
+		}
+	}
+
+	var have []netlink.Addr
+	for _, l := range links {
+		addrs, err := addrList(l, netlink.FAMILY_ALL)
+		if err != nil {
+			return false
+		}
+		have = append(have, addrs...)
+	}
+	for _, a := range i.Addresses {
+		want, err := netlink.ParseAddr(a)
+		if err != nil {
+			return false
+		}
+		found := false
+		for _, h := range have {
+			found = found || h.Equal(*want)
+		}
+		if !found {
+			return false
+		}
+	}
+	var got4, got6 bool
+	for _, h := range have {
+		if h.IP.IsGlobalUnicast() {
+			got4 = got4 || h.IP.To4() != nil
+			got6 = got6 || h.IP.To4() == nil
+		}
+	}
+	return (!i.DHCP4 || got4) && (!i.DHCP6 || got6)
+}
+
+// multiError collects the failures of Apply.
+type multiError []string
+
+func (e *multiError) add(err error, format string, v ...interface{}) {
+	if err != nil {
+		*e = append(*e, fmt.Sprintf("%s: %v", fmt.Sprintf(format, v...), err))
+	}
+}
+
+func (e multiError) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s", strings.Join(e, "; "))
+}
+
+func (i *Interface) displayName() string {
+	if i.Name == "" {
+		return "any interface"
+	}
+	return i.Name
+}
+
+// createLink creates the virtual interface i, unless it exists, and
+// enslaves its members.
+func createLink(i Interface) error {
+	attrs := netlink.NewLinkAttrs()
+	attrs.Name = i.Name
+
+	var link netlink.Link
+	switch i.Kind {
+	case KindBond:
+		b := netlink.NewLinkBond(attrs)
+		if i.BondMode != "" {
+			b.Mode = netlink.BondMode(bondMode(i.BondMode))
+		}
+		if i.BondMiimon != 0 {
+			b.Miimon = i.BondMiimon
+		}
+		link = b
+	case KindBridge:
+		link = &netlink.Bridge{LinkAttrs: attrs}
+	case KindVLAN:
+		parent, err := netlink.LinkByName(i.Parent)
+		if err != nil {
+			return fmt.Errorf("parent: %v", err)
+		}
+		if err := netlink.LinkSetUp(parent); err != nil {
+			return fmt.Errorf("bringing up parent %s: %v", i.Parent, err)
+		}
+		attrs.ParentIndex = parent.Attrs().Index
+		link = &netlink.Vlan{LinkAttrs: attrs, VlanId: i.VLANID}
+	}
+
+	if _, err := netlink.LinkByName(i.Name); err != nil {
+		if err := netlink.LinkAdd(link); err != nil {
+			return err
+		}
+	}
+	master, err := netlink.LinkByName(i.Name)
+	if err != nil {
+		return err
+	}
+	for _, name := range i.Members {
+		m, err := netlink.LinkByName(name)
+		if err != nil {
+			return fmt.Errorf("member: %v", err)
+		}
+		if m.Attrs().MasterIndex == master.Attrs().Index {
+			continue
+		}
+		// Bonds only take interfaces that are down.
+		if err := netlink.LinkSetDown(m); err != nil {
+			return fmt.Errorf("member %s: %v", name, err)
+		}
+		if err := netlink.LinkSetMasterByIndex(m, master.Attrs().Index); err != nil {
+			return fmt.Errorf("member %s: %v", name, err)
+		}
+		if err := netlink.LinkSetUp(m); err != nil {
+			return fmt.Errorf("member %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// configureLink sets up i's link parameters and static addresses, and
+// returns the links to use DHCP on.
+func configureLink(i Interface) ([]netlink.Link, error) {
+	var links []netlink.Link
+	if i.Name != "" {
+		l, err := netlink.LinkByName(i.Name)
+		if err != nil {
+			return nil, err
+		}
+		links = []netlink.Link{l}
+	} else {
+		all, err := linkList()
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range all {
+			if l.Attrs().Flags&net.FlagLoopback == 0 && len(l.Attrs().HardwareAddr) > 0 {
+				links = append(links, l)
+			}
+		}
+		if len(links) == 0 {
+			return nil, fmt.Errorf("no network interfaces")
+		}
+		if !i.DHCP4 && !i.DHCP6 {
+			// Static settings go on the first interface.
+			links = links[:1]
+		}
+	}
+
+	for _, l := range links {
+		if err := configureOne(i, l); err != nil {
+			return nil, err
+		}
+	}
+	return links, nil
+}
+
+func configureOne(i Interface, l netlink.Link) error {
+	name := l.Attrs().Name
+	if i.MAC != "" {
+		mac, _ := net.ParseMAC(i.MAC)
+		if err := netlink.LinkSetHardwareAddr(l, mac); err != nil {
+			return fmt.Errorf("setting MAC address: %v", err)
+		}
+	}
+	if i.MTU != 0 {
+		if err := netlink.LinkSetMTU(l, i.MTU); err != nil {
+			return fmt.Errorf("setting MTU: %v", err)
+		}
+	}
+	if _, err := dhclient.IfUp(name); err != nil {
+		return err
+	}
+
+	for _, a := range i.Addresses {
+		addr, err := netlink.ParseAddr(a)
+		if err != nil {
+			return err
+		}
+		if err := netlink.AddrReplace(l, addr); err != nil {
+			return fmt.Errorf("add/replace %s: %v", addr, err)
+		}
+	}
+	if i.Gateway != "" {
+		r := &netlink.Route{
+			LinkIndex: l.Attrs().Index,
+			Gw:        net.ParseIP(i.Gateway),
+		}
+		if err := netlink.RouteReplace(r); err != nil {
+			return fmt.Errorf("add %s: %v", r, err)
+		}
+	}
+	return nil
+}
+
+// dhcp configures links with DHCP. If first is true, only the first lease
+// of each family is used.
+func dhcp(ctx context.Context, links []netlink.Link, ipv4, ipv6, first bool) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var got4, got6 bool
+	for result := range dhclient.SendRequests(ctx, links, dhcpTimeout, dhcpRetries, ipv4, ipv6) {
+		if result.Err != nil {
+			log.Printf("DHCP on %s: %v", result.Interface.Attrs().Name, result.Err)
+			continue
+		}
+		_, v4 := result.Lease.(*dhclient.Packet4)
+		if first && ((v4 && got4) || (!v4 && got6)) {
+			continue
+		}
+		if err := result.Lease.Configure(); err != nil {
+			log.Printf("Configuring %s: %v", result.Lease, err)
+			continue
+		}
+		log.Printf("Configured %s with %s", result.Interface.Attrs().Name, result.Lease)
+		if v4 {
+			got4 = true
+		} else {
+			got6 = true
+		}
+		if first && got4 == ipv4 && got6 == ipv6 {
+			cancel()
+		}
+	}
+
+	switch {
+	case ipv4 && !got4 && ipv6 && !got6:
+		return fmt.Errorf("no DHCPv4 or IPv6 configuration")
+	case ipv4 && !got4:
+		return fmt.Errorf("no DHCPv4 lease")
+	case ipv6 && !got6:
+		return fmt.Errorf("no IPv6 configuration")
+	}
+	return nil
+}
+
+func addRoute(r Route) error {
+	dst, err := parseDst(r.Dst)
+	if err != nil {
+		return err
+	}
+	route := &netlink.Route{
+		Dst:      dst,
+		Priority: r.Metric,
+	}
+	if r.Gateway != "" {
+		route.Gw = net.ParseIP(r.Gateway)
+	}
+	if r.Interface != "" {
+		l, err := netlink.LinkByName(r.Interface)
+		if err != nil {
+			return err
+		}
+		route.LinkIndex = l.Attrs().Index
+	}
+	return netlink.RouteReplace(route)
+}
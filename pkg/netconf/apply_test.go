@@ -0,0 +1,94 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netconf
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+// fakeLinks replaces the link and address lookups with links that have the
+// given addresses, and returns a function that restores them.
+func fakeLinks(t *testing.T, links map[string][]string, loopback string) func() {
+	addrs := make(map[string][]netlink.Addr)
+	var all []netlink.Link
+	for name, as := range links {
+		attrs := netlink.NewLinkAttrs()
+		attrs.Name = name
+		if name == loopback {
+			attrs.Flags = net.FlagLoopback
+		}
+		all = append(all, &netlink.Dummy{LinkAttrs: attrs})
+		for _, a := range as {
+			addr, err := netlink.ParseAddr(a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			addrs[name] = append(addrs[name], *addr)
+		}
+	}
+	oldByName, oldList, oldAddrs := linkByName, linkList, addrList
+	linkByName = func(name string) (netlink.Link, error) {
+		for _, l := range all {
+			if l.Attrs().Name == name {
+				return l, nil
+			}
+		}
+		return nil, fmt.Errorf("no link %q", name)
+	}
+	linkList = func() ([]netlink.Link, error) {
+		return all, nil
+	}
+	addrList = func(l netlink.Link, family int) ([]netlink.Addr, error) {
+		return addrs[l.Attrs().Name], nil
+	}
+	return func() {
+		linkByName, linkList, addrList = oldByName, oldList, oldAddrs
+	}
+}
+
+func TestUnconfigured(t *testing.T) {
+	defer fakeLinks(t, map[string][]string{
+		"lo":   {"127.0.0.1/8"},
+		"eth0": {"10.0.0.2/24"},
+		"eth1": {"fe80::1/64"},
+	}, "lo")()
+
+	c := &Config{
+		Interfaces: []Interface{
+			{Name: "eth0", Addresses: []string{"10.0.0.2/24"}},
+			{Name: "eth0", DHCP4: true},
+			{Name: "eth0", Addresses: []string{"10.0.0.2/24", "10.9.9.9/32"}},
+			{Name: "eth1", DHCP6: true},
+			{Name: "eth1", MTU: 1500},
+			{Name: "nonexistent0", Addresses: []string{"10.0.0.2/24"}},
+			// Without a name, any link but the loopback will do.
+			{DHCP4: true},
+			{Addresses: []string{"127.0.0.1/8"}},
+		},
+		Nameservers: []string{"10.0.0.1"},
+	}
+	u := c.Unconfigured()
+	var got []string
+	for _, i := range u.Interfaces {
+		got = append(got, fmt.Sprintf("%s %v", i.Name, i.Addresses))
+	}
+	want := []string{
+		"eth0 [10.0.0.2/24 10.9.9.9/32]",
+		"eth1 []",
+		"eth1 []",
+		"nonexistent0 [10.0.0.2/24]",
+		" [127.0.0.1/8]",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Unconfigured() = %q, want %q", got, want)
+	}
+	if len(u.Nameservers) != 1 || len(c.Interfaces) != 8 {
+		t.Errorf("Unconfigured() = %+v, changed %+v", u, c)
+	}
+}
@@ -0,0 +1,344 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netconf
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// CmdlineParams are the kernel command line parameters ParseCmdline
+// understands.
+var CmdlineParams = []string{"ip", "vlan", "bond", "bridge", "nameserver", "rd.route"}
+
+// ParseCmdline returns the configuration in a kernel command line.
+//
+// It understands the kernel's
+//
+//	ip=<client-ip>:<server-ip>:<gw-ip>:<netmask>:<hostname>:<device>:<autoconf>:<dns0-ip>:<dns1-ip>:<ntp0-ip>
+//
+// and dracut's additions to it:
+//
+//	ip={dhcp|on|any|dhcp6|auto6|either6}
+//	ip=<device>:<autoconf>[:[<mtu>][:<macaddr>]]
+//	ip=<client-ip>:[<peer>]:<gw-ip>:<netmask>:<hostname>:<device>:<autoconf>[:[<mtu>][:<macaddr>]]
+//	vlan=<vlanname>:<phys>
+//	bond=<bondname>[:<slaves>[:<options>[:<mtu>]]]
+//	bridge=<bridgename>:<ethnames>
+//	nameserver=<ip>
+//	rd.route=<net>/<netmask>:<gateway>[:<interface>]
+//
+// IPv6 addresses are written in brackets. With a client IP but no autoconf
+// method, the interface is configured statically. NTP servers and the
+// peer are ignored.
+func ParseCmdline(line string) (*Config, error) {
+	c := &Config{}
+	for _, f := range strings.Fields(line) {
+		kv := strings.SplitN(f, "=", 2)
+		v := ""
+		if len(kv) == 2 {
+			v = kv[1]
+		}
+		var err error
+		switch kv[0] {
+		case "ip":
+			err = c.parseIP(v)
+		case "vlan":
+			err = c.parseVLAN(v)
+		case "bond":
+			err = c.parseBond(v)
+		case "bridge":
+			err = c.parseBridge(v)
+		case "nameserver":
+			c.Nameservers = append(c.Nameservers, strings.Trim(v, "[]"))
+		case "rd.route":
+			err = c.parseRoute(v)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f, err)
+		}
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// HasCmdline reports whether line configures the network.
+func HasCmdline(line string) bool {
+	for _, f := range strings.Fields(line) {
+		k := strings.SplitN(f, "=", 2)[0]
+		for _, p := range CmdlineParams {
+			if k == p {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// splitFields splits s at colons outside of brackets, removing the
+// brackets.
+func splitFields(s string) []string {
+	var fields []string
+	var cur strings.Builder
+	var bracket bool
+	for _, r := range s {
+		switch {
+		case r == '[':
+			bracket = true
+		case r == ']':
+			bracket = false
+		case r == ':' && !bracket:
+			fields = append(fields, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	return append(fields, cur.String())
+}
+
+// joinMAC joins the last six fields back together if they are a MAC
+// address, whose colons splitFields split at.
+func joinMAC(f []string) []string {
+	if len(f) < 7 {
+		return f
+	}
+	mac := f[len(f)-6:]
+	for _, b := range mac {
+		if len(b) != 2 {
+			return f
+		}
+	}
+	if _, err := net.ParseMAC(strings.Join(mac, ":")); err != nil {
+		return f
+	}
+	return append(f[:len(f)-6], strings.Join(mac, ":"))
+}
+
+// autoconf applies an ip= autoconfiguration method to i.
+func (i *Interface) autoconf(method string) error {
+	switch method {
+	case "", "off", "none", "static", "link6", "link-local":
+	case "on", "any", "dhcp", "bootp", "rarp", "single-dhcp":
+		i.DHCP4 = true
+	case "dhcp6", "auto6", "either6":
+		i.DHCP6 = true
+	default:
+		return fmt.Errorf("unsupported autoconfiguration method %q", method)
+	}
+	return nil
+}
+
+func isAutoconf(method string) bool {
+	return method != "" && (&Interface{}).autoconf(method) == nil
+}
+
+func (c *Config) parseIP(v string) error {
+	f := joinMAC(splitFields(v))
+
+	// ip=<autoconf> and ip=<device>:<autoconf>[:[<mtu>][:<macaddr>]].
+	if len(f) == 1 && net.ParseIP(f[0]) == nil {
+		return c.Interface("").autoconf(f[0])
+	}
+	if len(f) >= 2 && len(f) <= 4 && isAutoconf(f[1]) && net.ParseIP(f[0]) == nil {
+		i := c.Interface(f[0])
+		if err := i.autoconf(f[1]); err != nil {
+			return err
+		}
+		if len(f) > 2 {
+			return i.parseMTUOrDNS(c, f[2:])
+		}
+		return nil
+	}
+
+	for len(f) < 10 {
+		f = append(f, "")
+	}
+	client, gw, mask, hostname, device, method := f[0], f[2], f[3], f[4], f[5], f[6]
+	i := c.Interface(device)
+	if err := i.autoconf(method); err != nil {
+		return err
+	}
+	if client != "" {
+		addr, err := clientAddress(client, mask)
+		if err != nil {
+			return err
+		}
+		i.Addresses = append(i.Addresses, addr)
+	}
+	if gw != "" {
+		i.Gateway = gw
+	}
+	if hostname != "" {
+		c.Hostname = hostname
+	}
+	return i.parseMTUOrDNS(c, f[7:9])
+}
+
+// parseMTUOrDNS parses the fields after the autoconf method, which are
+// either an MTU and MAC address (dracut) or two DNS servers (kernel).
+func (i *Interface) parseMTUOrDNS(c *Config, f []string) error {
+	for n, v := range f {
+		switch {
+		case v == "":
+		case net.ParseIP(v) != nil:
+			c.Nameservers = append(c.Nameservers, v)
+		case n == 0:
+			mtu, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("bad MTU %q", v)
+			}
+			i.MTU = mtu
+		case n == 1:
+			if _, err := net.ParseMAC(v); err != nil {
+				return err
+			}
+			i.MAC = v
+		default:
+			return fmt.Errorf("unexpected %q", v)
+		}
+	}
+	return nil
+}
+
+// clientAddress returns the CIDR address for an ip= client IP and netmask.
+// The netmask may be a prefix length; without one, the client IP's
+// default mask is used.
+func clientAddress(client, mask string) (string, error) {
+	if strings.Contains(client, "/") {
+		if _, _, err := net.ParseCIDR(client); err != nil {
+			return "", err
+		}
+		return client, nil
+	}
+	ip := net.ParseIP(client)
+	if ip == nil {
+		return "", fmt.Errorf("bad client IP %q", client)
+	}
+	var ones int
+	switch {
+	case mask == "" && ip.To4() != nil:
+		ones, _ = ip.DefaultMask().Size()
+	case mask == "":
+		ones = 64
+	case net.ParseIP(mask) != nil:
+		m := net.ParseIP(mask)
+		if m.To4() != nil {
+			m = m.To4()
+		}
+		var bits int
+		if ones, bits = net.IPMask(m).Size(); bits == 0 {
+			return "", fmt.Errorf("bad netmask %q", mask)
+		}
+	default:
+		n, err := strconv.Atoi(mask)
+		if err != nil {
+			return "", fmt.Errorf("bad netmask %q", mask)
+		}
+		ones = n
+	}
+	return fmt.Sprintf("%s/%d", ip, ones), nil
+}
+
+// vlanID returns the VLAN ID in a VLAN interface name, which is named
+// vlan<id> or <phys>.<id>, with optional leading zeros.
+func vlanID(name string) (int, error) {
+	id := strings.TrimPrefix(name, "vlan")
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		id = name[i+1:]
+	}
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return 0, fmt.Errorf("no VLAN ID in interface name %q", name)
+	}
+	return n, nil
+}
+
+func (c *Config) parseVLAN(v string) error {
+	f := strings.Split(v, ":")
+	if len(f) != 2 || f[0] == "" || f[1] == "" {
+		return fmt.Errorf("want vlan=<vlanname>:<phys>")
+	}
+	id, err := vlanID(f[0])
+	if err != nil {
+		return err
+	}
+	i := c.Interface(f[0])
+	i.Kind, i.Parent, i.VLANID = KindVLAN, f[1], id
+	return nil
+}
+
+func (c *Config) parseBond(v string) error {
+	f := strings.Split(v, ":")
+	if v == "" {
+		f = []string{"bond0", "eth0,eth1"}
+	}
+	i := c.Interface(f[0])
+	i.Kind = KindBond
+	i.Members = []string{"eth0", "eth1"}
+	if len(f) > 1 && f[1] != "" {
+		i.Members = strings.Split(f[1], ",")
+	}
+	if len(f) > 2 && f[2] != "" {
+		for _, o := range strings.Split(f[2], ",") {
+			kv := strings.SplitN(o, "=", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("bad bond option %q", o)
+			}
+			switch kv[0] {
+			case "mode":
+				i.BondMode = kv[1]
+			case "miimon":
+				n, err := strconv.Atoi(kv[1])
+				if err != nil {
+					return fmt.Errorf("bad miimon %q", kv[1])
+				}
+				i.BondMiimon = n
+			default:
+				return fmt.Errorf("unsupported bond option %q", kv[0])
+			}
+		}
+	}
+	if len(f) > 3 && f[3] != "" {
+		mtu, err := strconv.Atoi(f[3])
+		if err != nil {
+			return fmt.Errorf("bad MTU %q", f[3])
+		}
+		i.MTU = mtu
+	}
+	return nil
+}
+
+func (c *Config) parseBridge(v string) error {
+	f := strings.Split(v, ":")
+	if v == "" {
+		f = []string{"br0", "eth0"}
+	}
+	if len(f) != 2 || f[0] == "" || f[1] == "" {
+		return fmt.Errorf("want bridge=<bridgename>:<ethnames>")
+	}
+	i := c.Interface(f[0])
+	i.Kind, i.Members = KindBridge, strings.Split(f[1], ",")
+	return nil
+}
+
+func (c *Config) parseRoute(v string) error {
+	f := splitFields(v)
+	if len(f) < 2 || len(f) > 3 {
+		return fmt.Errorf("want rd.route=<net>/<netmask>:<gateway>[:<interface>]")
+	}
+	r := Route{Dst: f[0], Gateway: f[1]}
+	if len(f) == 3 {
+		r.Interface = f[2]
+	}
+	c.Routes = append(c.Routes, r)
+	return nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netconf
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCmdline(t *testing.T) {
+	for _, tt := range []struct {
+		line string
+		want *Config
+	}{
+		{
+			line: "console=ttyS0 ip=dhcp",
+			want: &Config{Interfaces: []Interface{{DHCP4: true}}},
+		},
+		{
+			line: "ip=eth0:dhcp ip=eth0:auto6:9000:52:54:00:12:34:56",
+			want: &Config{Interfaces: []Interface{{Name: "eth0", DHCP4: true, DHCP6: true, MTU: 9000, MAC: "52:54:00:12:34:56"}}},
+		},
+		{
+			// The kernel's form, with DNS servers and an NTP server.
+			line: "ip=10.0.0.2::10.0.0.1:255.255.255.0:box:eth0:off:10.0.0.53:10.0.0.54:10.0.0.123",
+			want: &Config{
+				Interfaces: []Interface{{
+					Name:      "eth0",
+					Addresses: []string{"10.0.0.2/24"},
+					Gateway:   "10.0.0.1",
+				}},
+				Nameservers: []string{"10.0.0.53", "10.0.0.54"},
+				Hostname:    "box",
+			},
+		},
+		{
+			// Kernel defaults: class A netmask, no device.
+			line: "ip=10.1.2.3",
+			want: &Config{Interfaces: []Interface{{Addresses: []string{"10.1.2.3/8"}}}},
+		},
+		{
+			line: "ip=[2001:db8::2]::[2001:db8::1]:64::eth0:none:1400 nameserver=[2001:db8::53]",
+			want: &Config{
+				Interfaces: []Interface{{
+					Name:      "eth0",
+					Addresses: []string{"2001:db8::2/64"},
+					Gateway:   "2001:db8::1",
+					MTU:       1400,
+				}},
+				Nameservers: []string{"2001:db8::53"},
+			},
+		},
+		{
+			line: "bond=bond0:eth0,eth1:mode=802.3ad,miimon=100:9000 vlan=bond0.0010:bond0 ip=bond0.0010:dhcp",
+			want: &Config{Interfaces: []Interface{
+				{Name: "bond0", Kind: KindBond, Members: []string{"eth0", "eth1"}, BondMode: "802.3ad", BondMiimon: 100, MTU: 9000},
+				{Name: "bond0.0010", Kind: KindVLAN, Parent: "bond0", VLANID: 10, DHCP4: true},
+			}},
+		},
+		{
+			line: "bond vlan=vlan7:eth2",
+			want: &Config{Interfaces: []Interface{
+				{Name: "bond0", Kind: KindBond, Members: []string{"eth0", "eth1"}},
+				{Name: "vlan7", Kind: KindVLAN, Parent: "eth2", VLANID: 7},
+			}},
+		},
+		{
+			line: "bridge=br0:eth0,eth1 ip=br0:dhcp6 rd.route=192.168.0.0/16:10.0.0.254 rd.route=[2001:db8:1::/48]:[2001:db8::1]:br0",
+			want: &Config{
+				Interfaces: []Interface{{Name: "br0", Kind: KindBridge, Members: []string{"eth0", "eth1"}, DHCP6: true}},
+				Routes: []Route{
+					{Dst: "192.168.0.0/16", Gateway: "10.0.0.254"},
+					{Dst: "2001:db8:1::/48", Gateway: "2001:db8::1", Interface: "br0"},
+				},
+			},
+		},
+		{
+			line: "root=/dev/sda1 quiet",
+			want: &Config{},
+		},
+	} {
+		t.Run(tt.line, func(t *testing.T) {
+			got, err := ParseCmdline(tt.line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseCmdline() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCmdlineErrors(t *testing.T) {
+	for _, line := range []string{
+		"ip=eth0:ibft",
+		"ip=10.0.0.300",
+		"ip=10.0.0.2::10.0.0.1:255.0.255.0",
+		"ip=eth0:dhcp:big",
+		"vlan=eth0",
+		"vlan=foo:eth0",
+		"bond=bond0:eth0:lacp_rate=fast",
+		"bond=bond0:eth0:mode=fastest",
+		"rd.route=10.0.0.0/8",
+		"nameserver=dns.example.com",
+	} {
+		t.Run(line, func(t *testing.T) {
+			if c, err := ParseCmdline(line); err == nil {
+				t.Errorf("ParseCmdline() = %+v, want error", c)
+			}
+		})
+	}
+}
+
+func TestHasCmdline(t *testing.T) {
+	for line, want := range map[string]bool{
+		"console=ttyS0 ip=dhcp":        true,
+		"rd.route=10.0.0.0/8:10.0.0.1": true,
+		"bond":                         true,
+		"console=ttyS0 ipv6.disable=1": false,
+	} {
+		if got := HasCmdline(line); got != want {
+			t.Errorf("HasCmdline(%q) = %v, want %v", line, got, want)
+		}
+	}
+}
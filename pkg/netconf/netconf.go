@@ -0,0 +1,263 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package netconf configures the network from a declarative description.
+//
+// A Config lists interfaces, including VLANs, bonds and bridges to create,
+// with their addresses, MTUs and whether to use DHCP, plus routes and DNS
+// settings. It is read from a JSON file, e.g.
+//
+//	{
+//		"interfaces": [
+//			{"name": "bond0", "kind": "bond", "members": ["eth0", "eth1"], "bond_mode": "active-backup"},
+//			{"name": "bond0.10", "kind": "vlan", "parent": "bond0", "vlan_id": 10,
+//			 "addresses": ["10.0.10.2/24"], "gateway": "10.0.10.1", "mtu": 9000},
+//			{"name": "eth2", "dhcp4": true, "dhcp6": true}
+//		],
+//		"routes": [{"dst": "192.168.0.0/16", "gateway": "10.0.10.254"}],
+//		"nameservers": ["10.0.10.53"],
+//		"search": ["example.com"]
+//	}
+//
+// or from the kernel command line, with the ip=, vlan=, bond=, bridge=,
+// nameserver= and rd.route= parameters understood by the kernel and
+// dracut. Apply applies a Config with netlink, using pkg/dhclient for
+// interfaces configured by DHCP.
+package netconf
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+)
+
+// Kinds of virtual interfaces.
+const (
+	KindVLAN   = "vlan"
+	KindBond   = "bond"
+	KindBridge = "bridge"
+)
+
+// Config is a network configuration.
+type Config struct {
+	Interfaces []Interface `json:"interfaces,omitempty"`
+	Routes     []Route     `json:"routes,omitempty"`
+
+	// Nameservers and Search go in /etc/resolv.conf. If Nameservers is
+	// empty, DNS settings come from DHCP, if any.
+	Nameservers []string `json:"nameservers,omitempty"`
+	Search      []string `json:"search,omitempty"`
+
+	// Hostname, if not empty, is set as the system's host name.
+	Hostname string `json:"hostname,omitempty"`
+}
+
+// Interface configures a network interface.
+type Interface struct {
+	// Name is the interface's name. An empty name means any interface,
+	// as in the kernel's ip=dhcp: DHCP is tried on all of them and the
+	// first lease is used, and static addresses go on the first one.
+	Name string `json:"name,omitempty"`
+
+	// Kind, if not empty, is the kind of virtual interface to create:
+	// "vlan", "bond" or "bridge".
+	Kind string `json:"kind,omitempty"`
+
+	// Parent and VLANID configure a VLAN interface.
+	Parent string `json:"parent,omitempty"`
+	VLANID int    `json:"vlan_id,omitempty"`
+
+	// Members are enslaved to a bond or bridge.
+	Members []string `json:"members,omitempty"`
+
+	// BondMode is a bond's mode, e.g. "active-backup" or "802.3ad".
+	// BondMiimon is its MII link monitoring interval in milliseconds.
+	BondMode   string `json:"bond_mode,omitempty"`
+	BondMiimon int    `json:"bond_miimon,omitempty"`
+
+	MTU int    `json:"mtu,omitempty"`
+	MAC string `json:"mac,omitempty"`
+
+	// Addresses are static addresses in CIDR notation. Gateway, if not
+	// empty, is the default route.
+	Addresses []string `json:"addresses,omitempty"`
+	Gateway   string   `json:"gateway,omitempty"`
+
+	// DHCP4 and DHCP6 request DHCPv4 and IPv6 configuration. IPv6 is
+	// configured with SLAAC or DHCPv6, as routers advertise.
+	DHCP4 bool `json:"dhcp4,omitempty"`
+	DHCP6 bool `json:"dhcp6,omitempty"`
+}
+
+// Route is a static route.
+type Route struct {
+	// Dst is the destination in CIDR notation, or "default" or
+	// "default6" for the IPv4 and IPv6 default routes.
+	Dst string `json:"dst"`
+
+	// Gateway is the next hop. If empty, Dst is reachable directly on
+	// Interface.
+	Gateway string `json:"gateway,omitempty"`
+
+	// Interface is the outgoing interface, if not implied by Gateway.
+	Interface string `json:"interface,omitempty"`
+
+	Metric int `json:"metric,omitempty"`
+}
+
+// Load reads the JSON configuration in file.
+func Load(file string) (*Config, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	c, err := Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return c, nil
+}
+
+// Parse parses and validates a JSON configuration.
+func Parse(b []byte) (*Config, error) {
+	var c Config
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Interface returns the configuration of the interface called name,
+// adding it if there is none.
+func (c *Config) Interface(name string) *Interface {
+	for i := range c.Interfaces {
+		if c.Interfaces[i].Name == name {
+			return &c.Interfaces[i]
+		}
+	}
+	c.Interfaces = append(c.Interfaces, Interface{Name: name})
+	return &c.Interfaces[len(c.Interfaces)-1]
+}
+
+// Validate checks that c can be applied.
+func (c *Config) Validate() error {
+	names := make(map[string]bool)
+	for _, i := range c.Interfaces {
+		if names[i.Name] {
+			return fmt.Errorf("interface %q configured twice", i.Name)
+		}
+		names[i.Name] = true
+		if err := i.validate(); err != nil {
+			if i.Name == "" {
+				return err
+			}
+			return fmt.Errorf("interface %s: %v", i.Name, err)
+		}
+	}
+	for _, r := range c.Routes {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("route %s: %v", r.Dst, err)
+		}
+	}
+	for _, ns := range c.Nameservers {
+		if net.ParseIP(ns) == nil {
+			return fmt.Errorf("bad nameserver %q", ns)
+		}
+	}
+	return nil
+}
+
+func (i *Interface) validate() error {
+	switch i.Kind {
+	case "":
+	case KindVLAN:
+		if i.Parent == "" {
+			return fmt.Errorf("VLAN needs a parent interface")
+		}
+		if i.VLANID < 1 || i.VLANID > 4094 {
+			return fmt.Errorf("bad VLAN ID %d", i.VLANID)
+		}
+	case KindBond:
+		if i.BondMode != "" && bondMode(i.BondMode) < 0 {
+			return fmt.Errorf("unknown bond mode %q", i.BondMode)
+		}
+	case KindBridge:
+	default:
+		return fmt.Errorf("unknown kind %q", i.Kind)
+	}
+	if i.Kind != "" && i.Name == "" {
+		return fmt.Errorf("%s interface needs a name", i.Kind)
+	}
+	if i.MTU < 0 {
+		return fmt.Errorf("bad MTU %d", i.MTU)
+	}
+	if i.MAC != "" {
+		if _, err := net.ParseMAC(i.MAC); err != nil {
+			return err
+		}
+	}
+	for _, a := range i.Addresses {
+		if _, _, err := net.ParseCIDR(a); err != nil {
+			return err
+		}
+	}
+	if i.Gateway != "" && net.ParseIP(i.Gateway) == nil {
+		return fmt.Errorf("bad gateway %q", i.Gateway)
+	}
+	return nil
+}
+
+func (r *Route) validate() error {
+	if _, err := parseDst(r.Dst); err != nil {
+		return err
+	}
+	if r.Gateway == "" && r.Interface == "" {
+		return fmt.Errorf("route needs a gateway or an interface")
+	}
+	if r.Gateway != "" && net.ParseIP(r.Gateway) == nil {
+		return fmt.Errorf("bad gateway %q", r.Gateway)
+	}
+	return nil
+}
+
+// parseDst parses a route destination. "default" is 0.0.0.0/0 and
+// "default6" is ::/0.
+func parseDst(dst string) (*net.IPNet, error) {
+	switch dst {
+	case "default":
+		return &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)}, nil
+	case "default6":
+		return &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}, nil
+	}
+	if !strings.Contains(dst, "/") {
+		ip := net.ParseIP(dst)
+		if ip == nil {
+			return nil, fmt.Errorf("bad destination %q", dst)
+		}
+		if ip.To4() != nil {
+			return &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, n, err := net.ParseCIDR(dst)
+	return n, err
+}
+
+var bondModes = []string{"balance-rr", "active-backup", "balance-xor", "broadcast", "802.3ad", "balance-tlb", "balance-alb"}
+
+// bondMode returns the kernel's number for the bond mode s, which may be
+// given by name or number, or -1.
+func bondMode(s string) int {
+	for n, m := range bondModes {
+		if s == m || s == fmt.Sprint(n) {
+			return n
+		}
+	}
+	return -1
+}
@@ -0,0 +1,83 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netconf
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	got, err := Parse([]byte(`{
+		"interfaces": [
+			{"name": "bond0", "kind": "bond", "members": ["eth0", "eth1"], "bond_mode": "active-backup"},
+			{"name": "bond0.10", "kind": "vlan", "parent": "bond0", "vlan_id": 10,
+			 "addresses": ["10.0.10.2/24"], "gateway": "10.0.10.1", "mtu": 9000},
+			{"name": "eth2", "dhcp4": true, "dhcp6": true}
+		],
+		"routes": [{"dst": "192.168.0.0/16", "gateway": "10.0.10.254", "metric": 10}],
+		"nameservers": ["10.0.10.53"],
+		"search": ["example.com"]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Config{
+		Interfaces: []Interface{
+			{Name: "bond0", Kind: KindBond, Members: []string{"eth0", "eth1"}, BondMode: "active-backup"},
+			{Name: "bond0.10", Kind: KindVLAN, Parent: "bond0", VLANID: 10, Addresses: []string{"10.0.10.2/24"}, Gateway: "10.0.10.1", MTU: 9000},
+			{Name: "eth2", DHCP4: true, DHCP6: true},
+		},
+		Routes:      []Route{{Dst: "192.168.0.0/16", Gateway: "10.0.10.254", Metric: 10}},
+		Nameservers: []string{"10.0.10.53"},
+		Search:      []string{"example.com"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse() = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		json string
+	}{
+		{"syntax", `{"interfaces": [}`},
+		{"twice", `{"interfaces": [{"name": "eth0"}, {"name": "eth0"}]}`},
+		{"kind", `{"interfaces": [{"name": "wg0", "kind": "wireguard"}]}`},
+		{"vlan parent", `{"interfaces": [{"name": "vlan5", "kind": "vlan", "vlan_id": 5}]}`},
+		{"vlan id", `{"interfaces": [{"name": "vlan5", "kind": "vlan", "parent": "eth0", "vlan_id": 4095}]}`},
+		{"unnamed bridge", `{"interfaces": [{"kind": "bridge"}]}`},
+		{"address", `{"interfaces": [{"name": "eth0", "addresses": ["10.0.0.2"]}]}`},
+		{"mac", `{"interfaces": [{"name": "eth0", "mac": "52:54"}]}`},
+		{"route dst", `{"routes": [{"dst": "somewhere", "gateway": "10.0.0.1"}]}`},
+		{"route via", `{"routes": [{"dst": "default"}]}`},
+		{"nameserver", `{"nameservers": ["ns.example.com"]}`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if c, err := Parse([]byte(tt.json)); err == nil {
+				t.Errorf("Parse() = %+v, want error", c)
+			}
+		})
+	}
+}
+
+func TestParseDst(t *testing.T) {
+	for dst, want := range map[string]string{
+		"default":       "0.0.0.0/0",
+		"default6":      "::/0",
+		"10.1.2.3":      "10.1.2.3/32",
+		"10.1.2.3/8":    "10.0.0.0/8",
+		"2001:db8::1":   "2001:db8::1/128",
+		"2001:db8::/32": "2001:db8::/32",
+	} {
+		got, err := parseDst(dst)
+		if err != nil {
+			t.Errorf("parseDst(%q) = %v", dst, err)
+		} else if got.String() != want {
+			t.Errorf("parseDst(%q) = %s, want %s", dst, got, want)
+		}
+	}
+}
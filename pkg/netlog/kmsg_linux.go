@@ -0,0 +1,101 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netlog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// kmsgRecord parses a record of /dev/kmsg: "PRI,SEQ,USEC,FLAGS;TEXT".
+// boot is the time the kernel's clock started at.
+func kmsgRecord(line string, boot time.Time) (message, error) {
+	i := strings.IndexByte(line, ';')
+	if i < 0 {
+		return message{}, fmt.Errorf("no ; in kmsg record %q", line)
+	}
+	f := strings.Split(line[:i], ",")
+	if len(f) < 3 {
+		return message{}, fmt.Errorf("bad kmsg record %q", line)
+	}
+	pri, err := strconv.Atoi(f[0])
+	if err != nil {
+		return message{}, fmt.Errorf("bad kmsg record %q", line)
+	}
+	usec, err := strconv.ParseInt(f[2], 10, 64)
+	if err != nil {
+		return message{}, fmt.Errorf("bad kmsg record %q", line)
+	}
+	since := time.Duration(usec) * time.Microsecond
+	return message{
+		time:     boot.Add(since),
+		facility: pri >> 3,
+		severity: pri & 7,
+		app:      "kernel",
+		text:     line[i+1:],
+		kernel:   since,
+	}, nil
+}
+
+// bootTime returns the time the monotonic clock, which kmsg uses, started
+// at.
+func bootTime() time.Time {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return time.Now()
+	}
+	return time.Now().Add(-time.Duration(ts.Nano()))
+}
+
+// forwardKmsg queues the records read from r.
+func (s *Sink) forwardKmsg(r io.Reader, boot time.Time) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		// Records overwritten before they were read are skipped.
+		if pathErr, ok := err.(*os.PathError); ok && pathErr.Err == syscall.EPIPE {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		// Continuation lines have properties of the record.
+		if strings.HasPrefix(line, " ") {
+			continue
+		}
+		m, err := kmsgRecord(strings.TrimSuffix(line, "\n"), boot)
+		if err != nil {
+			continue
+		}
+		s.mu.Lock()
+		s.add(m)
+		s.mu.Unlock()
+	}
+}
+
+// ForwardKmsg sends the kernel's messages too, from the start of its
+// buffer, until the sink is closed.
+func (s *Sink) ForwardKmsg() error {
+	f, err := os.Open("/dev/kmsg")
+	if err != nil {
+		return err
+	}
+	go func() {
+		go func() {
+			<-s.stop
+			f.Close()
+		}()
+		s.forwardKmsg(f, bootTime())
+	}()
+	return nil
+}
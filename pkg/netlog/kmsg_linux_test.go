@@ -0,0 +1,37 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netlog
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestForwardKmsg(t *testing.T) {
+	boot := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	r := strings.NewReader("6,1,0,-;Linux version 5.1.0\n" +
+		" SUBSYSTEM=acpi\n" +
+		"3,2,1500000,-;ata1: failed\n" +
+		"garbage\n" +
+		"14,3,2000000,-;init: hello\n")
+	s := &Sink{c: Config{BufferSize: 10}, kick: make(chan struct{}, 1)}
+	if err := s.forwardKmsg(r, boot); err != io.EOF {
+		t.Errorf("forwardKmsg = %v, want EOF", err)
+	}
+	for i, want := range []message{
+		{time: boot, facility: FacilityKern, severity: SeverityInfo, app: "kernel", text: "Linux version 5.1.0", seq: 1},
+		{time: boot.Add(1500 * time.Millisecond), facility: FacilityKern, severity: 3, app: "kernel", text: "ata1: failed", kernel: 1500 * time.Millisecond, seq: 2},
+		{time: boot.Add(2 * time.Second), facility: FacilityUser, severity: SeverityInfo, app: "kernel", text: "init: hello", kernel: 2 * time.Second, seq: 3},
+	} {
+		if i >= len(s.queue) {
+			t.Fatalf("queued %d messages, want 3", len(s.queue))
+		}
+		if got := s.queue[i]; got != want {
+			t.Errorf("message %d = %+v, want %+v", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,392 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package netlog sends log messages to a remote collector.
+//
+// Messages go to a syslog server, as RFC 5424 messages over UDP (RFC 5426)
+// or TCP (RFC 6587, with octet counting), or to a netconsole collector as
+// plain lines over UDP. They are buffered until they can be sent, such as
+// when the network is up, so logs of the whole boot reach the collector.
+//
+// The collector is given by a URL on the kernel command line:
+//
+//	uroot.netlog=udp://10.0.0.1         syslog over UDP, port 514
+//	uroot.netlog=tcp://10.0.0.1         syslog over TCP, port 601
+//	uroot.netlog=netconsole://10.0.0.1  netconsole, port 6666
+//
+// Mirror sends the standard logger's output there, and with
+// uroot.netlog.kmsg=1 init sends kernel messages too.
+package netlog
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/u-root/u-root/pkg/cmdline"
+)
+
+const (
+	// CmdlineFlag is the kernel command line parameter with the URL of
+	// the collector.
+	CmdlineFlag = "uroot.netlog"
+
+	// KmsgFlag, set to 1 on the kernel command line, makes init send
+	// kernel messages too.
+	KmsgFlag = "uroot.netlog.kmsg"
+)
+
+// Protocols, as URL schemes.
+const (
+	UDP        = "udp"
+	TCP        = "tcp"
+	Netconsole = "netconsole"
+)
+
+var defaultPorts = map[string]string{
+	UDP:        "514",
+	TCP:        "601",
+	Netconsole: "6666",
+}
+
+// Facilities and severities of syslog messages.
+const (
+	FacilityKern = 0
+	FacilityUser = 1
+
+	SeverityNotice = 5
+	SeverityInfo   = 6
+)
+
+// DefaultBufferSize is how many messages are kept until they are sent.
+const DefaultBufferSize = 1000
+
+// FlushTimeout is how long Flush waits for messages to be sent.
+const FlushTimeout = 5 * time.Second
+
+// retryInterval is how long to wait after the collector could not be
+// reached.
+var retryInterval = time.Second
+
+// Config is a collector to send messages to.
+type Config struct {
+	// Protocol is UDP, TCP or Netconsole.
+	Protocol string
+
+	// Addr is the host:port of the collector.
+	Addr string
+
+	// BufferSize is how many messages are kept until they are sent. The
+	// oldest are dropped when more are written.
+	BufferSize int
+}
+
+// Parse parses the URL of a collector. The scheme defaults to udp, and the
+// port to that of the protocol.
+func Parse(s string) (*Config, error) {
+	if !strings.Contains(s, "://") {
+		s = UDP + "://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	port, ok := defaultPorts[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("netlog: unknown protocol %q, want udp, tcp or netconsole", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("netlog: no host in %q", s)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	return &Config{
+		Protocol:   u.Scheme,
+		Addr:       net.JoinHostPort(u.Hostname(), port),
+		BufferSize: DefaultBufferSize,
+	}, nil
+}
+
+// message is a message to send.
+type message struct {
+	time     time.Time
+	facility int
+	severity int
+	app      string
+	pid      int
+	text     string
+
+	// kernel is the time since boot of kernel messages, which
+	// netconsole shows as the console does.
+	kernel time.Duration
+
+	// seq numbers queued messages, from 1.
+	seq uint64
+}
+
+// Sink sends what is written to it to a collector, a line a message.
+//
+// Writes never block or fail: messages are queued, and sent by a
+// goroutine, which keeps trying until the collector can be reached.
+type Sink struct {
+	c   Config
+	app string
+	pid int
+
+	mu      sync.Mutex
+	queue   []message
+	seq     uint64
+	dropped int
+	partial []byte
+
+	kick chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New returns a sink for the collector c, whose messages are from app.
+func New(c *Config, app string) *Sink {
+	s := &Sink{
+		c:    *c,
+		app:  app,
+		pid:  os.Getpid(),
+		kick: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	if s.c.BufferSize <= 0 {
+		s.c.BufferSize = DefaultBufferSize
+	}
+	go s.run()
+	return s
+}
+
+// Write queues the lines of p as messages of severity info. An incomplete
+// last line waits for the rest.
+func (s *Sink) Write(p []byte) (int, error) {
+	now := time.Now()
+	s.mu.Lock()
+	b := append(s.partial, p...)
+	for {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			break
+		}
+		s.add(message{
+			time:     now,
+			facility: FacilityUser,
+			severity: SeverityInfo,
+			app:      s.app,
+			pid:      s.pid,
+			text:     string(b[:i]),
+		})
+		b = b[i+1:]
+	}
+	s.partial = append([]byte(nil), b...)
+	s.mu.Unlock()
+	return len(p), nil
+}
+
+// add queues m. s.mu must be held.
+func (s *Sink) add(m message) {
+	if len(s.queue) >= s.c.BufferSize {
+		s.queue = s.queue[1:]
+		s.dropped++
+	}
+	s.seq++
+	m.seq = s.seq
+	s.queue = append(s.queue, m)
+	select {
+	case s.kick <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the next message to send. A notice of dropped messages
+// comes first, with seq 0.
+func (s *Sink) next() (message, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dropped > 0 {
+		return message{
+			time:     time.Now(),
+			facility: FacilityUser,
+			severity: SeverityNotice,
+			app:      "netlog",
+			pid:      s.pid,
+			text:     fmt.Sprintf("%d messages dropped", s.dropped),
+		}, true
+	}
+	if len(s.queue) == 0 {
+		return message{}, false
+	}
+	return s.queue[0], true
+}
+
+// sent removes m, which was sent, from the queue, unless it was dropped
+// meanwhile.
+func (s *Sink) sent(m message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case m.seq == 0:
+		s.dropped = 0
+	case len(s.queue) > 0 && s.queue[0].seq == m.seq:
+		s.queue = s.queue[1:]
+	}
+}
+
+func (s *Sink) run() {
+	defer close(s.done)
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	for {
+		m, ok := s.next()
+		if !ok {
+			select {
+			case <-s.kick:
+				continue
+			case <-s.stop:
+				return
+			}
+		}
+
+		var err error
+		if conn == nil {
+			network := s.c.Protocol
+			if network == Netconsole {
+				network = UDP
+			}
+			conn, err = net.DialTimeout(network, s.c.Addr, retryInterval)
+		}
+		if err == nil {
+			if _, err = conn.Write(s.format(m)); err != nil {
+				conn.Close()
+				conn = nil
+			}
+		}
+		if err != nil {
+			select {
+			case <-time.After(retryInterval):
+				continue
+			case <-s.stop:
+				return
+			}
+		}
+		s.sent(m)
+	}
+}
+
+// nilValue replaces empty fields of syslog messages.
+func nilValue(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return strings.Replace(s, " ", "_", -1)
+}
+
+// format returns m as it is sent.
+func (s *Sink) format(m message) []byte {
+	if s.c.Protocol == Netconsole {
+		if m.app == "kernel" {
+			return []byte(fmt.Sprintf("[%5d.%06d] %s\n", m.kernel/time.Second, m.kernel%time.Second/time.Microsecond, m.text))
+		}
+		return []byte(fmt.Sprintf("%s: %s\n", m.app, m.text))
+	}
+
+	host, _ := os.Hostname()
+	b := fmt.Sprintf("<%d>1 %s %s %s %d - - %s",
+		m.facility<<3|m.severity,
+		m.time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		nilValue(host), nilValue(m.app), m.pid, m.text)
+	if s.c.Protocol == TCP {
+		b = strconv.Itoa(len(b)) + " " + b
+	}
+	return []byte(b)
+}
+
+// Flush waits until all messages written so far are sent, for at most
+// timeout. It returns an error if some are not.
+func (s *Sink) Flush(timeout time.Duration) error {
+	if s == nil {
+		return nil
+	}
+	for deadline := time.Now().Add(timeout); ; time.Sleep(10 * time.Millisecond) {
+		s.mu.Lock()
+		n := len(s.queue)
+		s.mu.Unlock()
+		if n == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("netlog: %d messages not sent to %s", n, s.c.Addr)
+		}
+	}
+}
+
+// Close flushes the sink, for at most timeout, and stops it.
+func (s *Sink) Close(timeout time.Duration) error {
+	if s == nil {
+		return nil
+	}
+	err := s.Flush(timeout)
+	close(s.stop)
+	<-s.done
+	return err
+}
+
+var (
+	mirrorMu sync.Mutex
+	mirror   *Sink
+)
+
+// Mirror sends the output of the standard logger to the collector of the
+// kernel command line too, as messages of app. It returns the sink, or nil
+// if there is no collector.
+func Mirror(app string) *Sink {
+	v, ok := cmdline.Flag(CmdlineFlag)
+	if !ok {
+		return nil
+	}
+	c, err := Parse(v)
+	if err != nil {
+		log.Printf("%s: %v", CmdlineFlag, err)
+		return nil
+	}
+	if app == "" {
+		app = filepath.Base(os.Args[0])
+	}
+	s := New(c, app)
+	mirrorMu.Lock()
+	mirror = s
+	mirrorMu.Unlock()
+	log.SetOutput(io.MultiWriter(os.Stderr, s))
+	return s
+}
+
+// Flush flushes the sink of Mirror, if any, for at most FlushTimeout.
+// Commands call it before they exit or kexec.
+func Flush() {
+	mirrorMu.Lock()
+	s := mirror
+	mirrorMu.Unlock()
+	if err := s.Flush(FlushTimeout); err != nil {
+		// Not to the sink.
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netlog
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	retryInterval = 10 * time.Millisecond
+}
+
+func TestParse(t *testing.T) {
+	for s, want := range map[string]Config{
+		"10.0.0.1":                  {Protocol: UDP, Addr: "10.0.0.1:514"},
+		"udp://10.0.0.1:1514":       {Protocol: UDP, Addr: "10.0.0.1:1514"},
+		"tcp://logs.example":        {Protocol: TCP, Addr: "logs.example:601"},
+		"netconsole://[fd00::1]":    {Protocol: Netconsole, Addr: "[fd00::1]:6666"},
+		"netconsole://10.0.0.1:999": {Protocol: Netconsole, Addr: "10.0.0.1:999"},
+	} {
+		want.BufferSize = DefaultBufferSize
+		c, err := Parse(s)
+		if err != nil || *c != want {
+			t.Errorf("Parse(%q) = %+v, %v, want %+v", s, c, err, want)
+		}
+	}
+	for _, s := range []string{"http://10.0.0.1", "udp://", "udp://:514"} {
+		if c, err := Parse(s); err == nil {
+			t.Errorf("Parse(%q) = %+v, want error", s, c)
+		}
+	}
+}
+
+// listenUDP returns a local UDP listener and a channel of what it receives.
+func listenUDP(t *testing.T) (net.PacketConn, chan string) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := make(chan string, 10)
+	go func() {
+		b := make([]byte, 4096)
+		for {
+			n, _, err := conn.ReadFrom(b)
+			if err != nil {
+				close(c)
+				return
+			}
+			c <- string(b[:n])
+		}
+	}()
+	return conn, c
+}
+
+func receive(t *testing.T, c chan string) string {
+	select {
+	case m := <-c:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+	return ""
+}
+
+func TestSyslogUDP(t *testing.T) {
+	conn, c := listenUDP(t)
+	defer conn.Close()
+	s := New(&Config{Protocol: UDP, Addr: conn.LocalAddr().String()}, "init")
+	defer s.Close(time.Second)
+
+	l := log.New(s, "", 0)
+	l.Printf("Welcome to u-root!")
+	fmt.Fprintf(s, "part")
+	fmt.Fprintf(s, "ial\nsecond\n")
+
+	host, _ := os.Hostname()
+	re := regexp.MustCompile(`^<14>1 \d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}Z (\S+) init (\d+) - - (.*)$`)
+	for _, want := range []string{"Welcome to u-root!", "partial", "second"} {
+		m := receive(t, c)
+		f := re.FindStringSubmatch(m)
+		if f == nil || f[1] != nilValue(host) || f[2] != fmt.Sprint(os.Getpid()) || f[3] != want {
+			t.Errorf("got %q, want an RFC 5424 message %q", m, want)
+		}
+	}
+	if err := s.Flush(time.Second); err != nil {
+		t.Errorf("Flush = %v", err)
+	}
+}
+
+func TestNetconsole(t *testing.T) {
+	conn, c := listenUDP(t)
+	defer conn.Close()
+	s := New(&Config{Protocol: Netconsole, Addr: conn.LocalAddr().String()}, "pxeboot")
+	defer s.Close(time.Second)
+
+	fmt.Fprintln(s, "Got configuration")
+	s.mu.Lock()
+	s.add(message{app: "kernel", text: "Linux version 5.1", kernel: 1500 * time.Millisecond})
+	s.mu.Unlock()
+	for _, want := range []string{"pxeboot: Got configuration\n", "[    1.500000] Linux version 5.1\n"} {
+		if m := receive(t, c); m != want {
+			t.Errorf("got %q, want %q", m, want)
+		}
+	}
+}
+
+// TestBuffering writes before the collector listens, as is the case before
+// the network is up.
+func TestBuffering(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	s := New(&Config{Protocol: TCP, Addr: addr, BufferSize: 3}, "boot")
+	defer s.Close(time.Second)
+	for i := 0; i < 5; i++ {
+		fmt.Fprintf(s, "message %d\n", i)
+	}
+	if err := s.Flush(50 * time.Millisecond); err == nil {
+		t.Errorf("Flush without a collector succeeded")
+	}
+
+	l, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for _, want := range []string{"2 messages dropped", "message 2", "message 3", "message 4"} {
+		var n int
+		if _, err := fmt.Fscanf(r, "%d ", &n); err != nil {
+			t.Fatal(err)
+		}
+		b := make([]byte, n)
+		if _, err := r.Read(b); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(string(b), " - - "+want) {
+			t.Errorf("got %q, want %q", b, want)
+		}
+	}
+	if err := s.Flush(time.Second); err != nil {
+		t.Errorf("Flush = %v", err)
+	}
+}
+
+func TestNilSink(t *testing.T) {
+	var s *Sink
+	if err := s.Flush(time.Second); err != nil {
+		t.Errorf("Flush = %v", err)
+	}
+	if err := s.Close(time.Second); err != nil {
+		t.Errorf("Close = %v", err)
+	}
+}
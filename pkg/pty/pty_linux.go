@@ -18,6 +18,7 @@ import (
 	"unsafe"
 
 	"github.com/u-root/u-root/pkg/termios"
+	"golang.org/x/sys/unix"
 )
 
 // pty support. We used to import github.com/kr/pty but what we need is not that complex.
@@ -31,17 +32,32 @@ func New() (*Pty, error) {
 	if err != nil {
 		return nil, err
 	}
+	p, err := Open()
+	if err != nil {
+		return nil, err
+	}
+	p.TTY, p.Restorer = tty, restorer
+	return p, nil
+}
+
+// Open allocates a pty that is not tied to the terminal of this process,
+// as servers of remote sessions need, which may not have a terminal at
+// all. Start, Run and Wait, which relay that terminal, cannot be used on
+// it; start p.C instead and relay Ptm.
+func Open() (*Pty, error) {
 	ptm, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
 	if err != nil {
 		return nil, err
 	}
 
 	if err := ptsunlock(ptm); err != nil {
+		ptm.Close()
 		return nil, err
 	}
 
 	sname, err := ptsname(ptm)
 	if err != nil {
+		ptm.Close()
 		return nil, err
 	}
 
@@ -55,9 +71,16 @@ func New() (*Pty, error) {
 	}
 	pts, err := os.OpenFile(sname, os.O_RDWR|syscall.O_NOCTTY, 0)
 	if err != nil {
+		ptm.Close()
 		return nil, err
 	}
-	return &Pty{Ptm: ptm, Pts: pts, Sname: sname, Kid: -1, TTY: tty, Restorer: restorer}, nil
+	return &Pty{Ptm: ptm, Pts: pts, Sname: sname, Kid: -1}, nil
+}
+
+// SetWinSize sets the window size of the pty, which signals SIGWINCH to
+// its foreground processes. Pts need not be open.
+func (p *Pty) SetWinSize(w *unix.Winsize) error {
+	return termios.SetWinSize(p.Ptm.Fd(), w)
 }
 
 func ptsname(f *os.File) (string, error) {
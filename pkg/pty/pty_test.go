@@ -9,6 +9,9 @@ import (
 	"os"
 	"reflect"
 	"testing"
+
+	"github.com/u-root/u-root/pkg/termios"
+	"golang.org/x/sys/unix"
 )
 
 func TestNew(t *testing.T) {
@@ -62,3 +65,26 @@ func TestRunRestoreTTYMode(t *testing.T) {
 		t.Errorf("bogus returned data: got %q, want %q", string(b[:n]), "hi\r\n")
 	}
 }
+
+func TestOpenSetWinSize(t *testing.T) {
+	p, err := Open()
+	if os.IsNotExist(err) {
+		t.Skipf("No /dev/ptmx here.")
+	} else if err != nil {
+		t.Fatalf("Open pty: want nil, got %v", err)
+	}
+	defer p.Ptm.Close()
+	defer p.Pts.Close()
+
+	want := &unix.Winsize{Row: 24, Col: 132}
+	if err := p.SetWinSize(want); err != nil {
+		t.Fatalf("SetWinSize: want nil, got %v", err)
+	}
+	got, err := termios.GetWinSize(p.Pts.Fd())
+	if err != nil {
+		t.Fatalf("GetWinSize: want nil, got %v", err)
+	}
+	if got.Row != want.Row || got.Col != want.Col {
+		t.Errorf("Winsize of pts: got %v, want %v", got, want)
+	}
+}
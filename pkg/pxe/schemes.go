@@ -5,6 +5,7 @@
 package pxe
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -13,6 +14,7 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/u-root/u-root/pkg/download"
 	"github.com/u-root/u-root/pkg/uio"
 	"pack.ag/tftp"
 )
@@ -178,27 +180,30 @@ func (t *TFTPClient) GetFile(u *url.URL) (io.ReaderAt, error) {
 
 // HTTPClient implements FileScheme for HTTP files.
 type HTTPClient struct {
-	c *http.Client
+	d *download.Downloader
 }
 
+// httpRetries is how often HTTPClient tries failed requests again and
+// resumes interrupted transfers.
+const httpRetries = 3
+
 // NewHTTPClient returns a new HTTP FileScheme based on the given http.Client.
 func NewHTTPClient(c *http.Client) *HTTPClient {
 	return &HTTPClient{
-		c: c,
+		d: &download.Downloader{
+			Client:  c,
+			Retries: httpRetries,
+		},
 	}
 }
 
 // GetFile implements FileScheme.GetFile.
 func (h HTTPClient) GetFile(u *url.URL) (io.ReaderAt, error) {
-	resp, err := h.c.Get(u.String())
+	r, err := h.d.Open(context.Background(), u.String(), 0)
 	if err != nil {
 		return nil, err
 	}
-
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("HTTP server responded with code %d, want 200: response %v", resp.StatusCode, resp)
-	}
-	return uio.NewCachingReader(resp.Body), nil
+	return uio.NewCachingReader(r), nil
 }
 
 // LocalFileClient implements FileScheme for files on disk.
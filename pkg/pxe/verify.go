@@ -0,0 +1,248 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pxe
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/uio"
+	"golang.org/x/crypto/ed25519"
+)
+
+// Signatures use the same format as cmds/vboot: a detached ed25519
+// signature of the SHA256 digest of the file, verified with a raw 32-byte
+// ed25519 public key.
+
+var (
+	// ErrBadSignature is returned when a file's signature does not verify
+	// against any trusted key.
+	ErrBadSignature = errors.New("signature verification failed")
+
+	// ErrNotInManifest is returned for files not listed in the manifest.
+	ErrNotInManifest = errors.New("file not listed in manifest")
+
+	// ErrDigestMismatch is returned when a file's digest does not match
+	// the manifest.
+	ErrDigestMismatch = errors.New("digest does not match manifest")
+)
+
+// SignatureSuffix is appended to a file's URL path to find its signature.
+const SignatureSuffix = ".sig"
+
+// ReadPublicKey reads a raw ed25519 public key file, as used by vboot.
+func ReadPublicKey(file string) (ed25519.PublicKey, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%s: public key is %d bytes, want %d", file, len(b), ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(b), nil
+}
+
+// Sign returns the signature of content in the format VerifyingScheme
+// checks.
+func Sign(key ed25519.PrivateKey, content []byte) []byte {
+	digest := sha256.Sum256(content)
+	return ed25519.Sign(key, digest[:])
+}
+
+// verify returns whether sig is a valid signature of content by any of keys.
+func verify(keys []ed25519.PublicKey, content, sig []byte) bool {
+	digest := sha256.Sum256(content)
+	for _, k := range keys {
+		if ed25519.Verify(k, digest[:], sig) {
+			return true
+		}
+	}
+	return false
+}
+
+// signatureURL returns the URL of the signature of u.
+func signatureURL(u *url.URL) *url.URL {
+	s := *u
+	s.Path += SignatureSuffix
+	return &s
+}
+
+// VerifyingScheme is a FileScheme that only returns files whose detached
+// signature, fetched from the same place with SignatureSuffix appended,
+// verifies against one of its keys.
+type VerifyingScheme struct {
+	scheme FileScheme
+	keys   []ed25519.PublicKey
+}
+
+// NewVerifyingScheme returns a FileScheme that fetches files and their
+// signatures using fs and checks them against keys.
+func NewVerifyingScheme(fs FileScheme, keys ...ed25519.PublicKey) *VerifyingScheme {
+	return &VerifyingScheme{
+		scheme: fs,
+		keys:   keys,
+	}
+}
+
+// GetFile implements FileScheme.GetFile.
+//
+// The whole file is downloaded and checked before it is returned.
+func (v *VerifyingScheme) GetFile(u *url.URL) (io.ReaderAt, error) {
+	content, err := readURL(v.scheme, u)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := readURL(v.scheme, signatureURL(u))
+	if err != nil {
+		return nil, fmt.Errorf("getting signature: %v", err)
+	}
+	if !verify(v.keys, content, sig) {
+		return nil, ErrBadSignature
+	}
+	return bytes.NewReader(content), nil
+}
+
+func readURL(fs FileScheme, u *url.URL) ([]byte, error) {
+	r, err := fs.GetFile(u)
+	if err != nil {
+		return nil, err
+	}
+	return uio.ReadAll(r)
+}
+
+// Verifying returns a copy of s in which every scheme checks signatures
+// against keys.
+func (s Schemes) Verifying(keys ...ed25519.PublicKey) Schemes {
+	v := make(Schemes)
+	for name, fs := range s {
+		v[name] = NewVerifyingScheme(fs, keys...)
+	}
+	return v
+}
+
+// Manifest maps file names to their SHA256 digests.
+//
+// Names are either absolute URLs or paths relative to the directory the
+// manifest was fetched from.
+type Manifest struct {
+	// Base is the URL the manifest was fetched from.
+	Base *url.URL
+
+	// Digests maps file names to digests.
+	Digests map[string][sha256.Size]byte
+}
+
+// ParseManifest parses a manifest in the format written by sha256sum: one
+// file per line, as a hex digest followed by whitespace and the file name.
+// A '*' before the name (binary mode) is ignored.
+func ParseManifest(r io.Reader, base *url.URL) (*Manifest, error) {
+	m := &Manifest{
+		Base:    base,
+		Digests: make(map[string][sha256.Size]byte),
+	}
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		l := strings.TrimSpace(s.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		f := strings.Fields(l)
+		if len(f) != 2 {
+			return nil, fmt.Errorf("manifest line %d: want digest and name, got %q", n, l)
+		}
+		d, err := hex.DecodeString(f[0])
+		if err != nil || len(d) != sha256.Size {
+			return nil, fmt.Errorf("manifest line %d: bad SHA256 digest %q", n, f[0])
+		}
+		var digest [sha256.Size]byte
+		copy(digest[:], d)
+		m.Digests[strings.TrimPrefix(f[1], "*")] = digest
+	}
+	return m, s.Err()
+}
+
+// GetManifest fetches the manifest at u using s, checks its signature
+// against keys and parses it.
+func GetManifest(s Schemes, u *url.URL, keys ...ed25519.PublicKey) (*Manifest, error) {
+	r, err := s.Verifying(keys...).GetFile(u)
+	if err != nil {
+		return nil, err
+	}
+	return ParseManifest(uio.Reader(r), u)
+}
+
+// lookup returns the digest listed for u.
+func (m *Manifest) lookup(u *url.URL) ([sha256.Size]byte, bool) {
+	if d, ok := m.Digests[u.String()]; ok {
+		return d, true
+	}
+	if m.Base == nil || u.Scheme != m.Base.Scheme || u.Host != m.Base.Host {
+		return [sha256.Size]byte{}, false
+	}
+	dir := path.Dir(m.Base.Path)
+	if dir != "/" {
+		dir += "/"
+	}
+	p := path.Clean(u.Path)
+	if !strings.HasPrefix(p, dir) {
+		// Not below the manifest's directory.
+		return [sha256.Size]byte{}, false
+	}
+	d, ok := m.Digests[p[len(dir):]]
+	return d, ok
+}
+
+// ManifestScheme is a FileScheme that only returns files listed in a
+// manifest with a matching digest.
+type ManifestScheme struct {
+	scheme   FileScheme
+	manifest *Manifest
+}
+
+// NewManifestScheme returns a FileScheme that fetches files using fs and
+// checks them against m.
+func NewManifestScheme(fs FileScheme, m *Manifest) *ManifestScheme {
+	return &ManifestScheme{
+		scheme:   fs,
+		manifest: m,
+	}
+}
+
+// GetFile implements FileScheme.GetFile.
+//
+// Files not in the manifest are rejected without being downloaded.
+func (ms *ManifestScheme) GetFile(u *url.URL) (io.ReaderAt, error) {
+	want, ok := ms.manifest.lookup(u)
+	if !ok {
+		return nil, ErrNotInManifest
+	}
+	content, err := readURL(ms.scheme, u)
+	if err != nil {
+		return nil, err
+	}
+	if sha256.Sum256(content) != want {
+		return nil, ErrDigestMismatch
+	}
+	return bytes.NewReader(content), nil
+}
+
+// WithManifest returns a copy of s in which every scheme checks files
+// against m.
+func (s Schemes) WithManifest(m *Manifest) Schemes {
+	v := make(Schemes)
+	for name, fs := range s {
+		v[name] = NewManifestScheme(fs, m)
+	}
+	return v
+}
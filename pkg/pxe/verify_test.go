@@ -0,0 +1,156 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pxe
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/uio"
+	"golang.org/x/crypto/ed25519"
+)
+
+func genKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub, priv
+}
+
+func mustParseURL(t *testing.T, s string) *url.URL {
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestVerifyingScheme(t *testing.T) {
+	pub, priv := genKey(t)
+	otherPub, otherPriv := genKey(t)
+
+	m := NewMockScheme("tftp")
+	m.Add("1.2.3.4", "/boot/kernel", "kernel")
+	m.Add("1.2.3.4", "/boot/kernel.sig", string(Sign(priv, []byte("kernel"))))
+	m.Add("1.2.3.4", "/boot/initrd", "initrd")
+	m.Add("1.2.3.4", "/boot/initrd.sig", string(Sign(otherPriv, []byte("initrd"))))
+	m.Add("1.2.3.4", "/boot/tampered", "evil")
+	m.Add("1.2.3.4", "/boot/tampered.sig", string(Sign(priv, []byte("good"))))
+	m.Add("1.2.3.4", "/boot/unsigned", "unsigned")
+	s := Schemes{"tftp": m}
+
+	for _, tt := range []struct {
+		path string
+		keys []ed25519.PublicKey
+		want string
+		err  bool
+	}{
+		{path: "/boot/kernel", keys: []ed25519.PublicKey{pub}, want: "kernel"},
+		{path: "/boot/initrd", keys: []ed25519.PublicKey{pub}, err: true},
+		{path: "/boot/initrd", keys: []ed25519.PublicKey{pub, otherPub}, want: "initrd"},
+		{path: "/boot/tampered", keys: []ed25519.PublicKey{pub}, err: true},
+		{path: "/boot/unsigned", keys: []ed25519.PublicKey{pub}, err: true},
+		{path: "/boot/missing", keys: []ed25519.PublicKey{pub}, err: true},
+	} {
+		t.Run(tt.path, func(t *testing.T) {
+			u := mustParseURL(t, "tftp://1.2.3.4"+tt.path)
+			r, err := s.Verifying(tt.keys...).LazyGetFile(u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := uio.ReadAll(r)
+			if (err != nil) != tt.err {
+				t.Fatalf("ReadAll(%s) = %v, want error %t", u, err, tt.err)
+			}
+			if err == nil && string(got) != tt.want {
+				t.Errorf("ReadAll(%s) = %q, want %q", u, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestManifestScheme(t *testing.T) {
+	pub, priv := genKey(t)
+
+	manifest := fmt.Sprintf("%x  kernel\n%x *sub/initrd\n%x  http://other/extra\n",
+		sha256.Sum256([]byte("kernel")), sha256.Sum256([]byte("initrd")), sha256.Sum256([]byte("extra")))
+
+	m := NewMockScheme("http")
+	m.Add("server", "/boot/manifest", manifest)
+	m.Add("server", "/boot/manifest.sig", string(Sign(priv, []byte(manifest))))
+	m.Add("server", "/boot/kernel", "kernel")
+	m.Add("server", "/boot/sub/initrd", "tampered")
+	m.Add("server", "/boot/unlisted", "unlisted")
+	m.Add("server", "/kernel", "kernel")
+	m.Add("other", "/extra", "extra")
+	s := Schemes{"http": m}
+
+	mf, err := GetManifest(s, mustParseURL(t, "http://server/boot/manifest"), pub)
+	if err != nil {
+		t.Fatalf("GetManifest: %v", err)
+	}
+	ms := s.WithManifest(mf)
+
+	for _, tt := range []struct {
+		url  string
+		want string
+		err  error
+	}{
+		{url: "http://server/boot/kernel", want: "kernel"},
+		{url: "http://server/boot/../boot/kernel", want: "kernel"},
+		{url: "http://other/extra", want: "extra"},
+		{url: "http://server/boot/sub/initrd", err: ErrDigestMismatch},
+		{url: "http://server/boot/unlisted", err: ErrNotInManifest},
+		{url: "http://server/kernel", err: ErrNotInManifest},
+	} {
+		t.Run(tt.url, func(t *testing.T) {
+			got, err := ms.GetFile(mustParseURL(t, tt.url))
+			if tt.err != nil {
+				if err == nil || !strings.Contains(err.Error(), tt.err.Error()) {
+					t.Fatalf("GetFile(%s) = %v, want %v", tt.url, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetFile(%s) = %v", tt.url, err)
+			}
+			b, err := uio.ReadAll(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.want {
+				t.Errorf("GetFile(%s) = %q, want %q", tt.url, b, tt.want)
+			}
+		})
+	}
+
+	// The unlisted file must not even be downloaded.
+	if n := m.NumCalled(mustParseURL(t, "http://server/boot/unlisted")); n != 0 {
+		t.Errorf("unlisted file downloaded %d times, want 0", n)
+	}
+
+	// A manifest signed by someone else is rejected.
+	otherPub, _ := genKey(t)
+	if _, err := GetManifest(s, mustParseURL(t, "http://server/boot/manifest"), otherPub); err == nil {
+		t.Errorf("GetManifest with untrusted key: got nil, want error")
+	}
+}
+
+func TestParseManifestErrors(t *testing.T) {
+	for _, bad := range []string{
+		"abcd kernel",
+		"kernel",
+		fmt.Sprintf("%x a b", sha256.Sum256(nil)),
+	} {
+		if _, err := ParseManifest(strings.NewReader(bad), nil); err == nil {
+			t.Errorf("ParseManifest(%q): got nil, want error", bad)
+		}
+	}
+}
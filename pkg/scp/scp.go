@@ -0,0 +1,526 @@
+// Copyright 2012-2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package scp implements the SCP protocol, as spoken between scp -f, the
+// source of the files, and scp -t, their target.
+//
+// Both sides of a copy use the package: a server runs Source or Sink on
+// its standard input and output, and a client runs the other one on the
+// remote command's.
+//
+// The source sends a record for each file and directory, and the target
+// acknowledges each one with a zero byte:
+//
+//	T<mtime> 0 <atime> 0   the times of the next file or directory, with -p
+//	C<mode> <size> <name>  a file, followed by its contents and a zero byte
+//	D<mode> 0 <name>       a directory, followed by its entries
+//	E                      the end of the directory
+//
+// Instead of a zero byte, either side may send a one byte followed by an
+// error message, after which the copy goes on with the next file, or a two
+// byte and a message, after which it stops.
+package scp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	success = 0
+	warning = 1
+	fatal   = 2
+)
+
+// Options are the flags of scp that change what is copied.
+type Options struct {
+	// Recursive copies directories, as with -r.
+	Recursive bool
+	// Preserve keeps the modes and times of files, as with -p.
+	Preserve bool
+	// TargetDir requires the target to be a directory, as with -d. scp
+	// clients pass it when they copy more than one file.
+	TargetDir bool
+}
+
+// RemoteError is an error reported by the other side.
+type RemoteError struct {
+	Msg string
+	// Fatal is true if the other side stopped.
+	Fatal bool
+}
+
+func (e *RemoteError) Error() string {
+	return e.Msg
+}
+
+// readResponse reads a reply, and returns a *RemoteError for an error
+// reply.
+func readResponse(r *bufio.Reader) error {
+	b, err := r.ReadByte()
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+	switch b {
+	case success:
+		return nil
+	case warning, fatal:
+		msg, err := r.ReadString('\n')
+		if err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		return &RemoteError{Msg: strings.TrimSuffix(msg, "\n"), Fatal: b == fatal}
+	}
+	return fmt.Errorf("bad reply %q", b)
+}
+
+func reply(w io.Writer) error {
+	_, err := w.Write([]byte{success})
+	return err
+}
+
+// sendError sends err as a warning or a fatal error.
+func sendError(w io.Writer, level byte, err error) error {
+	msg := strings.Replace(err.Error(), "\n", " ", -1)
+	_, err = fmt.Fprintf(w, "%cscp: %s\n", level, msg)
+	return err
+}
+
+// atime returns the access time of fi.
+func atime(fi os.FileInfo) int64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return int64(st.Atim.Sec)
+	}
+	return fi.ModTime().Unix()
+}
+
+// mode returns the permission bits of fi, as sent in C and D records.
+func mode(fi os.FileInfo) uint32 {
+	m := uint32(fi.Mode().Perm())
+	if fi.Mode()&os.ModeSetuid != 0 {
+		m |= syscall.S_ISUID
+	}
+	if fi.Mode()&os.ModeSetgid != 0 {
+		m |= syscall.S_ISGID
+	}
+	if fi.Mode()&os.ModeSticky != 0 {
+		m |= syscall.S_ISVTX
+	}
+	return m
+}
+
+// fileMode converts the permission bits of a C or D record.
+func fileMode(m uint32) os.FileMode {
+	fm := os.FileMode(m & 0777)
+	if m&syscall.S_ISUID != 0 {
+		fm |= os.ModeSetuid
+	}
+	if m&syscall.S_ISGID != 0 {
+		fm |= os.ModeSetgid
+	}
+	if m&syscall.S_ISVTX != 0 {
+		fm |= os.ModeSticky
+	}
+	return fm
+}
+
+type source struct {
+	w io.Writer
+	r *bufio.Reader
+	o Options
+	// err is the first error of the files that were skipped.
+	err error
+}
+
+// Source sends the files at paths to the target, writing to w and reading
+// the target's replies from r. Files that cannot be sent are reported to
+// the target and skipped, and the first of their errors is returned once
+// the others are sent.
+func Source(w io.Writer, r io.Reader, paths []string, o Options) error {
+	s := &source{w: w, r: bufio.NewReader(r), o: o}
+	// The target starts by saying that it is ready.
+	if err := readResponse(s.r); err != nil {
+		return err
+	}
+	for _, p := range paths {
+		if err := s.send(p); err != nil {
+			return err
+		}
+	}
+	return s.err
+}
+
+// skip reports err to the target and remembers it.
+func (s *source) skip(err error) error {
+	if s.err == nil {
+		s.err = err
+	}
+	return sendError(s.w, warning, err)
+}
+
+// ack reads the reply to a record. It returns false if the target had an
+// error with it, which is then remembered, and an error if the copy must
+// stop.
+func (s *source) ack() (bool, error) {
+	err := readResponse(s.r)
+	if re, ok := err.(*RemoteError); ok && !re.Fatal {
+		if s.err == nil {
+			s.err = err
+		}
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// record sends a record and reads the reply, as ack does.
+func (s *source) record(format string, args ...interface{}) (bool, error) {
+	if _, err := fmt.Fprintf(s.w, format, args...); err != nil {
+		return false, err
+	}
+	return s.ack()
+}
+
+// times sends the times of fi, if they are kept.
+func (s *source) times(fi os.FileInfo) (bool, error) {
+	if !s.o.Preserve {
+		return true, nil
+	}
+	return s.record("T%d 0 %d 0\n", fi.ModTime().Unix(), atime(fi))
+}
+
+func (s *source) send(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return s.skip(err)
+	}
+	switch {
+	case fi.Mode().IsRegular():
+		return s.sendFile(path)
+	case fi.IsDir() && s.o.Recursive:
+		return s.sendDir(path, fi)
+	}
+	return s.skip(fmt.Errorf("%s: not a regular file", path))
+}
+
+// zeros pads the contents of files that could not be read.
+type zeros struct{}
+
+func (zeros) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = 0
+	}
+	return len(b), nil
+}
+
+func (s *source) sendFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return s.skip(err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return s.skip(err)
+	}
+	if ok, err := s.times(fi); !ok {
+		return err
+	}
+	if ok, err := s.record("C%04o %d %s\n", mode(fi), fi.Size(), filepath.Base(path)); !ok {
+		return err
+	}
+
+	// The target expects as many bytes as were announced, so a file that
+	// cannot be read to the end is padded and reported.
+	n, err := io.CopyN(s.w, f, fi.Size())
+	if err != nil {
+		if err == io.EOF {
+			err = fmt.Errorf("%s: file changed size", path)
+		}
+		if _, err := io.CopyN(s.w, zeros{}, fi.Size()-n); err != nil {
+			return err
+		}
+		if err := s.skip(err); err != nil {
+			return err
+		}
+	} else if err := reply(s.w); err != nil {
+		return err
+	}
+	_, err = s.ack()
+	return err
+}
+
+func (s *source) sendDir(path string, fi os.FileInfo) error {
+	if ok, err := s.times(fi); !ok {
+		return err
+	}
+	if ok, err := s.record("D%04o 0 %s\n", mode(fi), filepath.Base(path)); !ok {
+		return err
+	}
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		if err := s.skip(err); err != nil {
+			return err
+		}
+	}
+	for _, e := range entries {
+		if err := s.send(filepath.Join(path, e.Name())); err != nil {
+			return err
+		}
+	}
+	_, err = s.record("E\n")
+	return err
+}
+
+type sink struct {
+	w io.Writer
+	r *bufio.Reader
+	o Options
+	// err is the first error of the files that were skipped.
+	err error
+}
+
+// Sink receives files from the source, reading from r and replying on w,
+// and writes them to target. If target is a directory, the files are put
+// in it. Sink returns when the source is done; files that could not be
+// received are skipped, and the first of their errors is returned.
+func Sink(w io.Writer, r io.Reader, target string, o Options) error {
+	s := &sink{w: w, r: bufio.NewReader(r), o: o}
+	fi, err := os.Stat(target)
+	isDir := err == nil && fi.IsDir()
+	if o.TargetDir && !isDir {
+		return s.fatal(fmt.Errorf("%s: not a directory", target))
+	}
+	if err := reply(w); err != nil {
+		return err
+	}
+	if err := s.receive(target, isDir, false); err != nil {
+		return err
+	}
+	return s.err
+}
+
+// fatal reports err to the source, and returns it.
+func (s *sink) fatal(err error) error {
+	sendError(s.w, fatal, err)
+	return err
+}
+
+// skip reports err to the source and remembers it.
+func (s *sink) skip(err error) error {
+	if s.err == nil {
+		s.err = err
+	}
+	return sendError(s.w, warning, err)
+}
+
+// parseRecord parses the rest of a C or D record.
+func parseRecord(line string) (uint32, int64, string, error) {
+	f := strings.SplitN(line, " ", 3)
+	if len(f) != 3 {
+		return 0, 0, "", fmt.Errorf("bad record %q", line)
+	}
+	m, err := strconv.ParseUint(f[0], 8, 32)
+	if err != nil || m&^07777 != 0 {
+		return 0, 0, "", fmt.Errorf("bad mode in %q", line)
+	}
+	size, err := strconv.ParseInt(f[1], 10, 64)
+	if err != nil || size < 0 {
+		return 0, 0, "", fmt.Errorf("bad size in %q", line)
+	}
+	name := f[2]
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return 0, 0, "", fmt.Errorf("bad file name %q", name)
+	}
+	return uint32(m), size, name, nil
+}
+
+// parseTimes parses the rest of a T record.
+func parseTimes(line string) (mtime, atime time.Time, err error) {
+	var m, mu, a, au int64
+	if _, err := fmt.Sscanf(line, "%d %d %d %d", &m, &mu, &a, &au); err != nil {
+		return mtime, atime, fmt.Errorf("bad times %q", line)
+	}
+	return time.Unix(m, mu*1000), time.Unix(a, au*1000), nil
+}
+
+// times are the times of a file, from a T record.
+type times struct {
+	mtime, atime time.Time
+}
+
+// receive receives records until the end of the input, or of the
+// directory if inDir is true. Files are put in target if isDir is true,
+// and written to it if not.
+func (s *sink) receive(target string, isDir, inDir bool) error {
+	var t *times
+	for {
+		typ, err := s.r.ReadByte()
+		if err == io.EOF && !inDir {
+			return nil
+		}
+		if err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		line = strings.TrimSuffix(line, "\n")
+
+		switch typ {
+		case warning, fatal:
+			// The source skipped a file.
+			err := &RemoteError{Msg: line, Fatal: typ == fatal}
+			if err.Fatal {
+				return err
+			}
+			if s.err == nil {
+				s.err = err
+			}
+		case 'T':
+			mtime, atime, err := parseTimes(line)
+			if err != nil {
+				return s.fatal(err)
+			}
+			t = &times{mtime: mtime, atime: atime}
+			if err := reply(s.w); err != nil {
+				return err
+			}
+		case 'E':
+			if !inDir {
+				return s.fatal(fmt.Errorf("unexpected end of directory"))
+			}
+			return reply(s.w)
+		case 'C', 'D':
+			m, size, name, err := parseRecord(line)
+			if err != nil {
+				return s.fatal(err)
+			}
+			path := target
+			if isDir {
+				path = filepath.Join(target, name)
+			}
+			if typ == 'C' {
+				err = s.receiveFile(path, m, size, t)
+			} else {
+				err = s.receiveDir(path, m, t)
+			}
+			if err != nil {
+				return err
+			}
+			t = nil
+		default:
+			return s.fatal(fmt.Errorf("protocol error: unexpected record %q", string(typ)+line))
+		}
+	}
+}
+
+// setAttrs sets the mode and times of path, if they are kept.
+func (s *sink) setAttrs(path string, m uint32, t *times) error {
+	if !s.o.Preserve {
+		return nil
+	}
+	if err := os.Chmod(path, fileMode(m)); err != nil {
+		return err
+	}
+	if t != nil {
+		return os.Chtimes(path, t.atime, t.mtime)
+	}
+	return nil
+}
+
+// discardOnError writes to w until that fails, and then discards what it
+// gets, so that the rest of a file can be read.
+type discardOnError struct {
+	w   io.Writer
+	err error
+}
+
+func (d *discardOnError) Write(b []byte) (int, error) {
+	if d.err == nil {
+		_, d.err = d.w.Write(b)
+	}
+	return len(b), nil
+}
+
+func (s *sink) receiveFile(path string, m uint32, size int64, t *times) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, fileMode(m))
+	if err != nil {
+		// The source skips the contents.
+		return s.skip(err)
+	}
+	if err := reply(s.w); err != nil {
+		f.Close()
+		return err
+	}
+
+	d := &discardOnError{w: f}
+	if _, err := io.CopyN(d, s.r, size); err != nil {
+		f.Close()
+		return io.ErrUnexpectedEOF
+	}
+	err = d.err
+	if err == nil {
+		err = f.Truncate(size)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = s.setAttrs(path, m, t)
+	}
+
+	// The source says whether it could send it all.
+	if rerr := readResponse(s.r); rerr != nil {
+		if re, ok := rerr.(*RemoteError); !ok || re.Fatal {
+			return rerr
+		}
+		if s.err == nil {
+			s.err = rerr
+		}
+	}
+	if err != nil {
+		return s.skip(err)
+	}
+	return reply(s.w)
+}
+
+func (s *sink) receiveDir(path string, m uint32, t *times) error {
+	if !s.o.Recursive {
+		return s.fatal(fmt.Errorf("received a directory without -r"))
+	}
+	fi, err := os.Stat(path)
+	switch {
+	case os.IsNotExist(err):
+		// The directory must be writable while it is filled.
+		if err := os.Mkdir(path, fileMode(m)|0700); err != nil {
+			return s.fatal(err)
+		}
+	case err != nil:
+		return s.fatal(err)
+	case !fi.IsDir():
+		return s.fatal(fmt.Errorf("%s: not a directory", path))
+	}
+	if err := reply(s.w); err != nil {
+		return err
+	}
+	if err := s.receive(path, true, true); err != nil {
+		return err
+	}
+	if err := s.setAttrs(path, m, t); err != nil && s.err == nil {
+		s.err = err
+	}
+	return nil
+}
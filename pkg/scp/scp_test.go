@@ -0,0 +1,363 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScpSource(t *testing.T) {
+	var w bytes.Buffer
+	var r bytes.Buffer
+
+	tf, err := ioutil.TempFile("", "TestScpSource")
+	if err != nil {
+		t.Fatalf("creating temp file: %v", err)
+	}
+	defer os.Remove(tf.Name())
+	tf.Write([]byte("dummy-file-contents"))
+
+	// Ready, and then got the record and the contents.
+	r.Write([]byte{0, 0, 0})
+	err = Source(&w, &r, []string{tf.Name()}, Options{})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	expected := []byte(fmt.Sprintf("C0600 19 %s\ndummy-file-contents", path.Base(tf.Name())))
+	expected = append(expected, 0)
+	if string(expected) != w.String() {
+		t.Fatalf("Got: %v\nExpected: %v", w.Bytes(), expected)
+	}
+}
+
+func TestScpSink(t *testing.T) {
+	var w bytes.Buffer
+	var r bytes.Buffer
+
+	tf, err := ioutil.TempFile("", "TestScpSink")
+	if err != nil {
+		t.Fatalf("creating temp file: %v", err)
+	}
+	defer os.Remove(tf.Name())
+
+	r.Write([]byte(fmt.Sprintf("C0600 19 dummy\ndummy-file-contents")))
+	// Post IO-copy success status
+	r.Write([]byte{0})
+
+	err = Sink(&w, &r, tf.Name(), Options{})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	// 1: Initial SUCCESS post to start scp
+	// 2: Success opening file tf.Name()
+	// 3: Success writing file
+	expected := []byte{0, 0, 0}
+	if string(expected) != w.String() {
+		t.Fatalf("Got: %v\nExpected: %v", w.Bytes(), expected)
+	}
+
+	m := make([]byte, 19)
+	n, err := tf.Read(m)
+	if err != nil {
+		t.Fatalf("IO error: %v", err)
+	}
+	if n != 19 {
+		t.Fatalf("Expected 19 bytes, got %v", n)
+	}
+
+	// Ensure EOF
+	_, err = tf.Read(m)
+	if err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+
+	if string(m) != "dummy-file-contents" {
+		t.Fatalf("Expected 'dummy-file-contents', got '%v'", string(m))
+	}
+}
+
+// copyFiles copies paths to target, with a source and a sink connected by
+// pipes, and returns their errors.
+func copyFiles(paths []string, target string, so, to Options) (sourceErr, sinkErr error) {
+	sr, sw := io.Pipe()
+	tr, tw := io.Pipe()
+	errc := make(chan error)
+	go func() {
+		err := Sink(tw, sr, target, to)
+		// Let the source finish.
+		go io.Copy(ioutil.Discard, sr)
+		errc <- err
+	}()
+	sourceErr = Source(sw, tr, paths, so)
+	go io.Copy(ioutil.Discard, tr)
+	sw.Close()
+	return sourceErr, <-errc
+}
+
+// file is a file or directory in a tree.
+type file struct {
+	mode    os.FileMode
+	content string
+	mtime   time.Time
+}
+
+// readTree returns the files under dir, by their path relative to it.
+func readTree(t *testing.T, dir string) map[string]file {
+	files := map[string]file{}
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		f := file{mode: fi.Mode(), mtime: fi.ModTime()}
+		if fi.Mode().IsRegular() {
+			b, err := ioutil.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			f.content = string(b)
+		}
+		files[rel] = f
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// tempDir returns a new directory, and a function that removes it.
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "scp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestRoundTrip(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	tree := filepath.Join(dir, "tree")
+	mtime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	for _, f := range []struct {
+		path    string
+		mode    os.FileMode
+		content string
+	}{
+		{"", os.ModeDir | 0755, ""},
+		{"a", 0644, "alpha"},
+		{"empty", os.ModeDir | 0750, ""},
+		{"sub", os.ModeDir | 0700, ""},
+		{"sub/b", 0600, "beta"},
+		{"sub/x", 0755, "#!/bin/sh\n"},
+		{"sub/zero", 0640, ""},
+	} {
+		p := filepath.Join(tree, f.path)
+		var err error
+		if f.mode.IsDir() {
+			err = os.Mkdir(p, f.mode)
+		} else {
+			err = ioutil.WriteFile(p, []byte(f.content), f.mode)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(p, f.mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Directories last, since their entries change their times.
+	for _, p := range []string{"a", "sub/b", "sub/x", "sub/zero", "empty", "sub", ""} {
+		if err := os.Chtimes(filepath.Join(tree, p), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := readTree(t, tree)
+
+	// A new target is the copy itself.
+	o := Options{Recursive: true, Preserve: true}
+	if serr, terr := copyFiles([]string{tree}, filepath.Join(dir, "copy"), o, o); serr != nil || terr != nil {
+		t.Fatalf("Copy: source error %v, sink error %v", serr, terr)
+	}
+	if got := readTree(t, filepath.Join(dir, "copy")); !reflect.DeepEqual(got, want) {
+		t.Errorf("Copy is %v, want %v", got, want)
+	}
+
+	// A directory gets a copy in it, which replaces the files there.
+	into := filepath.Join(dir, "into")
+	if err := os.MkdirAll(filepath.Join(into, "tree"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(into, "tree", "a"), []byte("a longer file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if serr, terr := copyFiles([]string{tree}, into, o, o); serr != nil || terr != nil {
+		t.Fatalf("Copy: source error %v, sink error %v", serr, terr)
+	}
+	if got := readTree(t, filepath.Join(into, "tree")); !reflect.DeepEqual(got, want) {
+		t.Errorf("Copy is %v, want %v", got, want)
+	}
+
+	// Without -p, the times are those of the copy.
+	o.Preserve = false
+	if serr, terr := copyFiles([]string{filepath.Join(tree, "a")}, filepath.Join(dir, "a"), o, o); serr != nil || terr != nil {
+		t.Fatalf("Copy: source error %v, sink error %v", serr, terr)
+	}
+	fi, err := os.Stat(filepath.Join(dir, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.ModTime().Equal(mtime) {
+		t.Errorf("Copy without -p has the time of the original")
+	}
+}
+
+func TestMultipleSources(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	var paths []string
+	for _, n := range []string{"one", "two", "three"} {
+		p := filepath.Join(dir, n)
+		if err := ioutil.WriteFile(p, []byte(n), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, p)
+	}
+	target := filepath.Join(dir, "target")
+	if err := os.Mkdir(target, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	o := Options{TargetDir: true}
+	if serr, terr := copyFiles(paths, target, o, o); serr != nil || terr != nil {
+		t.Fatalf("Copy: source error %v, sink error %v", serr, terr)
+	}
+	for _, n := range []string{"one", "two", "three"} {
+		if b, err := ioutil.ReadFile(filepath.Join(target, n)); err != nil || string(b) != n {
+			t.Errorf("%s has %q, %v, want %q", n, b, err, n)
+		}
+	}
+
+	// With -d, the target must be a directory.
+	serr, terr := copyFiles(paths, paths[0], o, o)
+	if re, ok := serr.(*RemoteError); !ok || !re.Fatal || !strings.Contains(re.Msg, "not a directory") {
+		t.Errorf("Source error is %v, want a fatal error for the target", serr)
+	}
+	if terr == nil {
+		t.Errorf("Sink to a file with -d succeeded")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	one, two := filepath.Join(dir, "one"), filepath.Join(dir, "two")
+	for _, p := range []string{one, two} {
+		if err := ioutil.WriteFile(p, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files that the source cannot send are skipped, and reported on both
+	// sides.
+	target := filepath.Join(dir, "target")
+	if err := os.Mkdir(target, 0755); err != nil {
+		t.Fatal(err)
+	}
+	serr, terr := copyFiles([]string{one, filepath.Join(dir, "nosuch"), sub, two}, target, Options{}, Options{})
+	if !os.IsNotExist(serr) {
+		t.Errorf("Source error is %v, want the missing file", serr)
+	}
+	if re, ok := terr.(*RemoteError); !ok || re.Fatal || !strings.Contains(re.Msg, "nosuch") {
+		t.Errorf("Sink error is %v, want a warning for the missing file", terr)
+	}
+	for _, n := range []string{"one", "two"} {
+		if _, err := os.Stat(filepath.Join(target, n)); err != nil {
+			t.Errorf("%s was not copied: %v", n, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(target, "sub")); !os.IsNotExist(err) {
+		t.Errorf("Directory copied without -r: %v", err)
+	}
+
+	// Files that the sink cannot write are skipped too.
+	other := filepath.Join(dir, "other")
+	if err := os.MkdirAll(filepath.Join(other, "one"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	serr, terr = copyFiles([]string{one, two}, other, Options{}, Options{})
+	if re, ok := serr.(*RemoteError); !ok || re.Fatal || !strings.Contains(re.Msg, "is a directory") {
+		t.Errorf("Source error is %v, want a warning for the directory", serr)
+	}
+	if terr == nil {
+		t.Errorf("Sink onto a directory succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(other, "two")); err != nil {
+		t.Errorf("two was not copied: %v", err)
+	}
+
+	// A sink without -r stops at the first directory.
+	serr, terr = copyFiles([]string{sub, one}, filepath.Join(dir, "norecurse"), Options{Recursive: true}, Options{})
+	if re, ok := serr.(*RemoteError); !ok || !re.Fatal {
+		t.Errorf("Source error is %v, want a fatal error", serr)
+	}
+	if terr == nil {
+		t.Errorf("Sink of a directory without -r succeeded")
+	}
+}
+
+func TestSinkRecords(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	for _, tt := range []struct {
+		in    string
+		fatal bool
+	}{
+		{in: "C0644 5 ../x\n", fatal: true},
+		{in: "C0644 5 a/b\n", fatal: true},
+		{in: "C0644 5 .\n", fatal: true},
+		{in: "Cxyz 5 a\n", fatal: true},
+		{in: "C0644 -1 a\n", fatal: true},
+		{in: "C0644 5\n", fatal: true},
+		{in: "T1 0\n", fatal: true},
+		{in: "E\n", fatal: true},
+		{in: "D0755 0 d\n", fatal: true},
+		{in: "Q\n", fatal: true},
+		// Truncated files.
+		{in: "C0644 5 a\nabc"},
+		{in: "C0644 5 a"},
+		// A warning from the source.
+		{in: "\x01scp: oops\n"},
+	} {
+		var w bytes.Buffer
+		err := Sink(&w, strings.NewReader(tt.in), dir, Options{})
+		if err == nil {
+			t.Errorf("Sink(%q) succeeded", tt.in)
+		}
+		if fatal := strings.HasPrefix(w.String(), "\x00\x02scp: "); fatal != tt.fatal {
+			t.Errorf("Sink(%q) replied %q, want a fatal error: %v", tt.in, w.String(), tt.fatal)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uio
+
+import (
+	"io"
+)
+
+// closeWriter is implemented by connections that can be half closed, such
+// as TCP connections and SSH channels.
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// Relay copies what is read from a to b and from b to a. When one side
+// stops sending, the other is half closed, if it can be, so its peer sees
+// the end of the stream. Relay returns when both directions are done, after
+// closing a and b.
+func Relay(a, b io.ReadWriteCloser) {
+	done := make(chan struct{})
+	go func() {
+		io.Copy(a, b)
+		if cw, ok := a.(closeWriter); ok {
+			cw.CloseWrite()
+		}
+		close(done)
+	}()
+	io.Copy(b, a)
+	if cw, ok := b.(closeWriter); ok {
+		cw.CloseWrite()
+	}
+	<-done
+	a.Close()
+	b.Close()
+}
@@ -0,0 +1,57 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uio
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+// tcpPair returns both ends of a loopback TCP connection.
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, s
+}
+
+func TestRelay(t *testing.T) {
+	client, a := tcpPair(t)
+	b, server := tcpPair(t)
+	defer client.Close()
+	defer server.Close()
+	done := make(chan struct{})
+	go func() {
+		Relay(a, b)
+		close(done)
+	}()
+
+	// Each side sees the other's data and the end of its stream, while
+	// it can still answer.
+	client.Write([]byte("request"))
+	client.(*net.TCPConn).CloseWrite()
+	got, err := ioutil.ReadAll(server)
+	if err != nil || string(got) != "request" {
+		t.Errorf("server read %q, %v, want request", got, err)
+	}
+	server.Write([]byte("response"))
+	server.Close()
+	got, err = ioutil.ReadAll(client)
+	if err != nil || string(got) != "response" {
+		t.Errorf("client read %q, %v, want response", got, err)
+	}
+	<-done
+}
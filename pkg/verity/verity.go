@@ -0,0 +1,356 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package verity builds and checks dm-verity hash trees.
+//
+// A hash tree covers a read-only data device block by block. Each hash block
+// holds the hashes of the blocks (or hash blocks) below it, and the hash of
+// the single top block is the root hash. Given a trusted root hash, the
+// kernel's dm-verity target verifies every block as it is read; Verify does
+// the same for the whole device in user space, which needs no privileges.
+package verity
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	// Register the hashes dm-verity volumes commonly use.
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+var (
+	// Signature is found at the start of a verity superblock.
+	Signature = [8]byte{'v', 'e', 'r', 'i', 't', 'y', 0, 0}
+
+	// ErrNoSuperblock is returned when there is no verity superblock.
+	ErrNoSuperblock = errors.New("no verity superblock")
+
+	// ErrRootHashMismatch is returned when the hash tree does not match
+	// the expected root hash.
+	ErrRootHashMismatch = errors.New("root hash mismatch")
+)
+
+const superblockSize = 512
+
+// superblock is the on-disk veritysetup superblock. Integers are little
+// endian.
+type superblock struct {
+	Signature     [8]byte
+	Version       uint32
+	HashType      uint32
+	UUID          [16]byte
+	Algorithm     [32]byte
+	DataBlockSize uint32
+	HashBlockSize uint32
+	DataBlocks    uint64
+	SaltSize      uint16
+	_             [6]byte
+	Salt          [256]byte
+	_             [168]byte
+}
+
+var hashes = map[string]crypto.Hash{
+	"sha1":   crypto.SHA1,
+	"sha256": crypto.SHA256,
+	"sha512": crypto.SHA512,
+}
+
+// Params describe a hash tree.
+type Params struct {
+	// HashType is 1 for the normal format, 0 for the original Chrome OS
+	// format, which appends the salt rather than prepending it.
+	HashType uint32
+
+	// Algorithm is the hash algorithm, e.g. "sha256".
+	Algorithm string
+
+	// DataBlockSize and HashBlockSize are in bytes.
+	DataBlockSize uint32
+	HashBlockSize uint32
+
+	// DataBlocks is the number of data blocks covered by the tree.
+	DataBlocks uint64
+
+	// Salt is mixed into every hash.
+	Salt []byte
+
+	// UUID identifies the hash device.
+	UUID [16]byte
+
+	// HashOffset is the offset of the superblock on the hash device, or of
+	// the tree itself if NoSuperblock is set.
+	HashOffset int64
+
+	// NoSuperblock is set if there is no superblock before the tree.
+	NoSuperblock bool
+}
+
+// ReadSuperblock reads the superblock at offset off of the hash device.
+func ReadSuperblock(r io.ReaderAt, off int64) (*Params, error) {
+	var sb superblock
+	if err := binary.Read(io.NewSectionReader(r, off, superblockSize), binary.LittleEndian, &sb); err != nil {
+		return nil, err
+	}
+	if sb.Signature != Signature {
+		return nil, ErrNoSuperblock
+	}
+	if sb.Version != 1 {
+		return nil, fmt.Errorf("unsupported superblock version %d", sb.Version)
+	}
+	if int(sb.SaltSize) > len(sb.Salt) {
+		return nil, fmt.Errorf("bad salt size %d", sb.SaltSize)
+	}
+	p := &Params{
+		HashType:      sb.HashType,
+		Algorithm:     string(bytes.TrimRight(sb.Algorithm[:], "\x00")),
+		DataBlockSize: sb.DataBlockSize,
+		HashBlockSize: sb.HashBlockSize,
+		DataBlocks:    sb.DataBlocks,
+		Salt:          append([]byte(nil), sb.Salt[:sb.SaltSize]...),
+		UUID:          sb.UUID,
+		HashOffset:    off,
+	}
+	return p, p.check()
+}
+
+// WriteSuperblock writes the superblock for p at p.HashOffset.
+func (p *Params) WriteSuperblock(w io.WriterAt) error {
+	if err := p.check(); err != nil {
+		return err
+	}
+	sb := superblock{
+		Signature:     Signature,
+		Version:       1,
+		HashType:      p.HashType,
+		UUID:          p.UUID,
+		DataBlockSize: p.DataBlockSize,
+		HashBlockSize: p.HashBlockSize,
+		DataBlocks:    p.DataBlocks,
+		SaltSize:      uint16(len(p.Salt)),
+	}
+	copy(sb.Algorithm[:], p.Algorithm)
+	copy(sb.Salt[:], p.Salt)
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.LittleEndian, sb); err != nil {
+		return err
+	}
+	_, err := w.WriteAt(b.Bytes(), p.HashOffset)
+	return err
+}
+
+func (p *Params) check() error {
+	if _, ok := hashes[p.Algorithm]; !ok {
+		return fmt.Errorf("unsupported hash algorithm %q", p.Algorithm)
+	}
+	if p.HashType > 1 {
+		return fmt.Errorf("unsupported hash type %d", p.HashType)
+	}
+	for _, bs := range []uint32{p.DataBlockSize, p.HashBlockSize} {
+		if bs < 512 || bs&(bs-1) != 0 {
+			return fmt.Errorf("bad block size %d", bs)
+		}
+	}
+	if len(p.Salt) > 256 {
+		return fmt.Errorf("salt too long: %d bytes", len(p.Salt))
+	}
+	if p.DataBlocks == 0 {
+		return fmt.Errorf("no data blocks")
+	}
+	if p.hashesPerBlock() < 2 {
+		return fmt.Errorf("hash block size %d too small for %s", p.HashBlockSize, p.Algorithm)
+	}
+	return nil
+}
+
+func (p *Params) hash() crypto.Hash {
+	return hashes[p.Algorithm]
+}
+
+// digestStride is the space a hash takes up in a hash block. The normal
+// format pads each hash to a power of two.
+func (p *Params) digestStride() int {
+	n := p.hash().Size()
+	if p.HashType == 0 {
+		return n
+	}
+	s := 1
+	for s < n {
+		s <<= 1
+	}
+	return s
+}
+
+// hashesPerBlock is the fan-out of the tree, rounded down to a power of two
+// as the kernel does.
+func (p *Params) hashesPerBlock() uint64 {
+	n := uint64(p.HashBlockSize) / uint64(p.digestStride())
+	s := uint64(1)
+	for s*2 <= n {
+		s <<= 1
+	}
+	return s
+}
+
+// TreeOffset is the offset of the hash tree on the hash device.
+func (p *Params) TreeOffset() int64 {
+	if p.NoSuperblock {
+		return p.HashOffset
+	}
+	bs := int64(p.HashBlockSize)
+	return (p.HashOffset + superblockSize + bs - 1) / bs * bs
+}
+
+// levels returns the number of hash blocks in each level of the tree,
+// level 0 covering the data blocks. The top level is stored first.
+func (p *Params) levels() []uint64 {
+	var l []uint64
+	n := p.DataBlocks
+	for fan := p.hashesPerBlock(); n > 1; {
+		n = (n + fan - 1) / fan
+		l = append(l, n)
+	}
+	return l
+}
+
+// TreeSize is the size of the hash tree in bytes.
+func (p *Params) TreeSize() int64 {
+	var n uint64
+	for _, l := range p.levels() {
+		n += l
+	}
+	return int64(n) * int64(p.HashBlockSize)
+}
+
+func (p *Params) hashBlock(b []byte) []byte {
+	h := p.hash().New()
+	if p.HashType == 1 {
+		h.Write(p.Salt)
+		h.Write(b)
+	} else {
+		h.Write(b)
+		h.Write(p.Salt)
+	}
+	return h.Sum(nil)
+}
+
+// hashLevel hashes the n blocks of size bs in r and returns the resulting
+// level, padded to whole hash blocks.
+func (p *Params) hashLevel(r io.ReaderAt, off int64, n uint64, bs int) ([]byte, error) {
+	stride := p.digestStride()
+	fan := p.hashesPerBlock()
+	nblocks := (n + fan - 1) / fan
+	out := make([]byte, nblocks*uint64(p.HashBlockSize))
+	b := make([]byte, bs)
+	for i := uint64(0); i < n; i++ {
+		if _, err := r.ReadAt(b, off+int64(i)*int64(bs)); err != nil {
+			return nil, fmt.Errorf("reading block %d: %v", i, err)
+		}
+		pos := (i/fan)*uint64(p.HashBlockSize) + (i%fan)*uint64(stride)
+		copy(out[pos:], p.hashBlock(b))
+	}
+	return out, nil
+}
+
+// buildTree computes the tree for data, calling level for each level from
+// the bottom up with its hash blocks. It returns the root hash.
+func (p *Params) buildTree(data io.ReaderAt, level func(i int, b []byte) error) ([]byte, error) {
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	var (
+		r  io.ReaderAt = data
+		n              = p.DataBlocks
+		bs             = int(p.DataBlockSize)
+	)
+	if n == 1 {
+		b := make([]byte, bs)
+		if _, err := data.ReadAt(b, 0); err != nil {
+			return nil, fmt.Errorf("reading block 0: %v", err)
+		}
+		return p.hashBlock(b), nil
+	}
+	for i := range p.levels() {
+		b, err := p.hashLevel(r, 0, n, bs)
+		if err != nil {
+			return nil, err
+		}
+		if err := level(i, b); err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+		n = uint64(len(b)) / uint64(p.HashBlockSize)
+		bs = int(p.HashBlockSize)
+	}
+	top := make([]byte, p.HashBlockSize)
+	if _, err := r.ReadAt(top, 0); err != nil {
+		return nil, err
+	}
+	return p.hashBlock(top), nil
+}
+
+// levelOffset is where level i is stored on the hash device.
+func (p *Params) levelOffset(i int) int64 {
+	off := p.TreeOffset()
+	l := p.levels()
+	for j := len(l) - 1; j > i; j-- {
+		off += int64(l[j]) * int64(p.HashBlockSize)
+	}
+	return off
+}
+
+// Format computes the hash tree for data, writes it (and, unless
+// NoSuperblock is set, the superblock) to hash and returns the root hash.
+func Format(data io.ReaderAt, hash io.WriterAt, p *Params) ([]byte, error) {
+	root, err := p.buildTree(data, func(i int, b []byte) error {
+		_, err := hash.WriteAt(b, p.levelOffset(i))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !p.NoSuperblock {
+		if err := p.WriteSuperblock(hash); err != nil {
+			return nil, err
+		}
+	}
+	return root, nil
+}
+
+// Verify checks every block of data against the hash tree in hash and the
+// tree against root, as dm-verity would when reading the whole device.
+func Verify(data, hash io.ReaderAt, p *Params, root []byte) error {
+	got, err := p.buildTree(data, func(i int, b []byte) error {
+		stored := make([]byte, len(b))
+		if _, err := hash.ReadAt(stored, p.levelOffset(i)); err != nil {
+			return fmt.Errorf("reading hash level %d: %v", i, err)
+		}
+		if !bytes.Equal(stored, b) {
+			return fmt.Errorf("hash level %d: %v", i, errorAt(stored, b, int(p.HashBlockSize)))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, root) {
+		return ErrRootHashMismatch
+	}
+	return nil
+}
+
+// errorAt reports the first hash block where stored and computed differ.
+func errorAt(stored, computed []byte, bs int) error {
+	for i := 0; i < len(stored); i += bs {
+		if !bytes.Equal(stored[i:i+bs], computed[i:i+bs]) {
+			return fmt.Errorf("block %d does not match the data", i/bs)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package verity
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/u-root/u-root/pkg/dm"
+)
+
+// Target returns the dm-verity table line for a tree described by p,
+// covering dataDev with hashes on hashDev.
+func (p *Params) Target(dataDev, hashDev string, root []byte) (dm.Target, error) {
+	if err := p.check(); err != nil {
+		return dm.Target{}, err
+	}
+	salt := "-"
+	if len(p.Salt) > 0 {
+		salt = hex.EncodeToString(p.Salt)
+	}
+	return dm.Target{
+		Length: p.DataBlocks * uint64(p.DataBlockSize) / dm.SectorSize,
+		Type:   "verity",
+		Params: fmt.Sprintf("%d %s %s %d %d %d %d %s %s %s",
+			p.HashType, dataDev, hashDev, p.DataBlockSize, p.HashBlockSize,
+			p.DataBlocks, p.TreeOffset()/int64(p.HashBlockSize),
+			p.Algorithm, hex.EncodeToString(root), salt),
+	}, nil
+}
+
+// Open creates a read-only dm-verity device called name and returns its
+// path. Reads from it fail with EIO if the data does not match root.
+func Open(dataDev, hashDev, name string, p *Params, root []byte) (string, error) {
+	t, err := p.Target(dataDev, hashDev, root)
+	if err != nil {
+		return "", err
+	}
+	return dm.Create(name, "", true, []dm.Target{t})
+}
+
+// Close removes the dm-verity device called name.
+func Close(name string) error {
+	return dm.Remove(name)
+}
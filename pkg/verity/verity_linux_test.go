@@ -0,0 +1,28 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package verity
+
+import (
+	"testing"
+
+	"github.com/u-root/u-root/pkg/dm"
+)
+
+func TestTarget(t *testing.T) {
+	p := testParams(300)
+	root := []byte{0xab, 0xcd}
+	got, err := p.Target("/dev/vda2", "/dev/vda3", root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := dm.Target{
+		Length: 300 * 8,
+		Type:   "verity",
+		Params: "1 /dev/vda2 /dev/vda3 4096 4096 300 1 sha256 abcd 01020304",
+	}
+	if got != want {
+		t.Errorf("Target = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package verity
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// buffer is an in-memory io.ReaderAt and io.WriterAt.
+type buffer struct {
+	b []byte
+}
+
+func (b *buffer) ReadAt(p []byte, off int64) (int, error) {
+	return bytes.NewReader(b.b).ReadAt(p, off)
+}
+
+func (b *buffer) WriteAt(p []byte, off int64) (int, error) {
+	if end := int(off) + len(p); end > len(b.b) {
+		b.b = append(b.b, make([]byte, end-len(b.b))...)
+	}
+	return copy(b.b[off:], p), nil
+}
+
+func testData(blocks int) []byte {
+	d := make([]byte, blocks*4096)
+	for i := range d {
+		d[i] = byte(i * 7 / 4096)
+	}
+	return d
+}
+
+func testParams(blocks int) *Params {
+	return &Params{
+		HashType:      1,
+		Algorithm:     "sha256",
+		DataBlockSize: 4096,
+		HashBlockSize: 4096,
+		DataBlocks:    uint64(blocks),
+		Salt:          []byte{1, 2, 3, 4},
+	}
+}
+
+func TestFormatVerify(t *testing.T) {
+	for _, blocks := range []int{1, 2, 128, 129, 300} {
+		for _, typ := range []uint32{0, 1} {
+			data := testData(blocks)
+			p := testParams(blocks)
+			p.HashType = typ
+			var hash buffer
+			root, err := Format(bytes.NewReader(data), &hash, p)
+			if err != nil {
+				t.Fatalf("%d blocks, type %d: Format: %v", blocks, typ, err)
+			}
+			if err := Verify(bytes.NewReader(data), &hash, p, root); err != nil {
+				t.Errorf("%d blocks, type %d: Verify: %v", blocks, typ, err)
+			}
+
+			sb, err := ReadSuperblock(&hash, 0)
+			if err != nil {
+				t.Fatalf("%d blocks, type %d: ReadSuperblock: %v", blocks, typ, err)
+			}
+			if sb.DataBlocks != p.DataBlocks || sb.HashType != typ || !bytes.Equal(sb.Salt, p.Salt) {
+				t.Errorf("%d blocks, type %d: ReadSuperblock = %+v, want %+v", blocks, typ, sb, p)
+			}
+
+			data[len(data)-1] ^= 1
+			if err := Verify(bytes.NewReader(data), &hash, p, root); err == nil {
+				t.Errorf("%d blocks, type %d: Verify of corrupted data: got nil, want error", blocks, typ)
+			}
+		}
+	}
+}
+
+func TestRootHash(t *testing.T) {
+	// With two data blocks the tree is a single hash block holding both
+	// data hashes, so the root hash is easy to compute by hand.
+	data := testData(2)
+	p := testParams(2)
+	var top [4096]byte
+	for i := 0; i < 2; i++ {
+		s := sha256.New()
+		s.Write(p.Salt)
+		s.Write(data[i*4096 : (i+1)*4096])
+		copy(top[i*32:], s.Sum(nil))
+	}
+	s := sha256.New()
+	s.Write(p.Salt)
+	s.Write(top[:])
+	want := s.Sum(nil)
+
+	var hash buffer
+	root, err := Format(bytes.NewReader(data), &hash, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(root, want) {
+		t.Errorf("root hash = %x, want %x", root, want)
+	}
+	if err := Verify(bytes.NewReader(data), &hash, p, make([]byte, 32)); err != ErrRootHashMismatch {
+		t.Errorf("Verify with wrong root: got %v, want %v", err, ErrRootHashMismatch)
+	}
+}
+
+func TestTreeLayout(t *testing.T) {
+	p := testParams(300)
+	// 128 sha256 hashes fit in a 4096 byte block: 300 data blocks need 3
+	// level 0 blocks and 1 level 1 block, after the superblock.
+	if got, want := p.levels(), []uint64{3, 1}; len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("levels = %v, want %v", got, want)
+	}
+	if got := p.TreeOffset(); got != 4096 {
+		t.Errorf("TreeOffset = %d, want 4096", got)
+	}
+	if got := p.levelOffset(1); got != 4096 {
+		t.Errorf("levelOffset(1) = %d, want 4096", got)
+	}
+	if got := p.levelOffset(0); got != 8192 {
+		t.Errorf("levelOffset(0) = %d, want 8192", got)
+	}
+	if got := p.TreeSize(); got != 4*4096 {
+		t.Errorf("TreeSize = %d, want %d", got, 4*4096)
+	}
+}
+
+func TestFormatVerifyFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "verity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := filepath.Join(dir, "data")
+	if err := ioutil.WriteFile(data, testData(64), 0600); err != nil {
+		t.Fatal(err)
+	}
+	df, err := os.Open(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer df.Close()
+	hf, err := os.Create(filepath.Join(dir, "hash"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hf.Close()
+
+	p := testParams(64)
+	p.HashOffset = 1024
+	root, err := Format(df, hf, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sb, err := ReadSuperblock(hf, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(df, hf, sb, root); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
+
+func TestReadSuperblockMissing(t *testing.T) {
+	if _, err := ReadSuperblock(bytes.NewReader(make([]byte, 4096)), 0); err != ErrNoSuperblock {
+		t.Errorf("ReadSuperblock: got %v, want %v", err, ErrNoSuperblock)
+	}
+}
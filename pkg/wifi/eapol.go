@@ -0,0 +1,76 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wifi
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/u-root/u-root/pkg/wpa"
+	"golang.org/x/sys/unix"
+)
+
+// eapolConn sends and receives the EAPOL frames of the key handshakes
+// between a station and an access point.
+type eapolConn struct {
+	f *os.File
+	// sta and ap are the addresses of the station and the access point.
+	sta, ap net.HardwareAddr
+}
+
+// htons converts v from host to network byte order.
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+// dialEAPOL returns an eapolConn on the interface with index ifindex. It is
+// non-blocking, so that closing it stops reads in progress.
+func dialEAPOL(ifindex int, sta, ap net.HardwareAddr) (*eapolConn, error) {
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, int(htons(wpa.EtherType)))
+	if err != nil {
+		return nil, fmt.Errorf("EAPOL socket: %v", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(wpa.EtherType), Ifindex: ifindex}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("EAPOL socket: %v", err)
+	}
+	return &eapolConn{f: os.NewFile(uintptr(fd), "eapol"), sta: sta, ap: ap}, nil
+}
+
+// read returns the next EAPOL frame from the access point, without its
+// Ethernet header. A zero deadline means no deadline.
+func (c *eapolConn) read(deadline time.Time) ([]byte, error) {
+	if err := c.f.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+	b := make([]byte, 2048)
+	for {
+		n, err := c.f.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		// Packet sockets also get the frames we send.
+		if n > 14 && bytes.Equal(b[6:12], c.ap) {
+			return b[14:n], nil
+		}
+	}
+}
+
+// write sends an EAPOL frame to the access point.
+func (c *eapolConn) write(p []byte) error {
+	b := make([]byte, 14, 14+len(p))
+	copy(b, c.ap)
+	copy(b[6:], c.sta)
+	b[12], b[13] = wpa.EtherType>>8, wpa.EtherType&0xff
+	_, err := c.f.Write(append(b, p...))
+	return err
+}
+
+func (c *eapolConn) Close() error {
+	return c.f.Close()
+}
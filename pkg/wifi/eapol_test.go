@@ -0,0 +1,57 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wifi
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestEAPOLConn(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skip(err)
+	}
+	// The loopback address is all zeros, so the frames we send are
+	// frames from the access point.
+	c, err := dialEAPOL(lo.Index, lo.HardwareAddr, make(net.HardwareAddr, 6))
+	if err != nil {
+		t.Skipf("No packet sockets: %v", err)
+	}
+	defer c.Close()
+
+	want := []byte{1, 3, 0, 0}
+	if err := c.write(want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.read(time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Got %x, want %x", got, want)
+	}
+
+	if _, err := c.read(time.Now().Add(50 * time.Millisecond)); err == nil {
+		t.Errorf("Got a second frame, want a timeout")
+	}
+	done := make(chan error)
+	go func() {
+		_, err := c.read(time.Time{})
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	c.Close()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("Read on a closed connection succeeded, want error")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Close did not stop read")
+	}
+}
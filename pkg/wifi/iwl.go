@@ -6,11 +6,13 @@ package wifi
 
 import (
 	"context"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -23,6 +25,10 @@ const (
 		proto=RSN
 		key_mgmt=NONE
 	}`
+	psk = `network={
+		ssid="%s"
+		psk=%s
+	}`
 	eap = `network={
 		ssid="%s"
 		key_mgmt=WPA-EAP
@@ -38,6 +44,7 @@ var (
 	encKeyOptRE  = regexp.MustCompile("(?m)^\\s*Encryption key:(on|off)$")
 	wpa2RE       = regexp.MustCompile("(?m)^\\s*IE: IEEE 802.11i/WPA2 Version 1$")
 	authSuitesRE = regexp.MustCompile("(?m)^\\s*Authentication Suites .*$")
+	signalRE     = regexp.MustCompile("Signal level=(-?[0-9]+) dBm")
 )
 
 type SecProto int
@@ -49,6 +56,38 @@ const (
 	NotSupportedProto
 )
 
+var secProtoNames = map[SecProto]string{
+	NoEnc:             "none",
+	WpaPsk:            "WPA-PSK",
+	WpaEap:            "WPA-EAP",
+	NotSupportedProto: "unsupported",
+}
+
+func (p SecProto) String() string {
+	if s, ok := secProtoNames[p]; ok {
+		return s
+	}
+	return fmt.Sprintf("SecProto(%d)", int(p))
+}
+
+// MarshalText makes security protocols readable in JSON.
+func (p SecProto) MarshalText() ([]byte, error) {
+	if s, ok := secProtoNames[p]; ok {
+		return []byte(s), nil
+	}
+	return nil, fmt.Errorf("unknown security protocol %d", int(p))
+}
+
+func (p *SecProto) UnmarshalText(b []byte) error {
+	for k, v := range secProtoNames {
+		if v == string(b) {
+			*p = k
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown security protocol %q", b)
+}
+
 // IWLWorker implements the WiFi interface using the Intel Wireless LAN commands
 type IWLWorker struct {
 	Interface string
@@ -95,21 +134,25 @@ func parseIwlistOut(o []byte) []Option {
 			continue
 		}
 		knownEssids[essid] = true
+		start, end := cells[i][0], len(o)
+		if i != len(cells)-1 {
+			end = cells[i+1][0]
+		}
+		var signal int
+		if m := signalRE.FindSubmatch(o[start:end]); m != nil {
+			signal, _ = strconv.Atoi(string(m[1]))
+		}
 		encKeyOpt := strings.Trim(strings.Split(string(encKeyOpts[i]), ":")[1], "\n")
 		if encKeyOpt == "off" {
-			res = append(res, Option{essid, NoEnc})
+			res = append(res, Option{essid, NoEnc, signal})
 			continue
 		}
 		// Find the proper Authentication Suites
-		start, end := cells[i][0], len(o)
-		if i != len(cells)-1 {
-			end = cells[i+1][0]
-		}
 		// Narrow down the scope when looking for WPA Tag
 		wpa2SearchArea := o[start:end]
 		l := wpa2RE.FindIndex(wpa2SearchArea)
 		if l == nil {
-			res = append(res, Option{essid, NotSupportedProto})
+			res = append(res, Option{essid, NotSupportedProto, signal})
 			continue
 		}
 		// Narrow down the scope when looking for Authorization Suites
@@ -117,11 +160,11 @@ func parseIwlistOut(o []byte) []Option {
 		authSuites := strings.Trim(strings.Split(string(authSuitesRE.Find(authSearchArea)), ":")[1], "\n ")
 		switch authSuites {
 		case "PSK":
-			res = append(res, Option{essid, WpaPsk})
+			res = append(res, Option{essid, WpaPsk, signal})
 		case "802.1x":
-			res = append(res, Option{essid, WpaEap})
+			res = append(res, Option{essid, WpaEap, signal})
 		default:
-			res = append(res, Option{essid, NotSupportedProto})
+			res = append(res, Option{essid, NotSupportedProto, signal})
 		}
 	}
 	return res
@@ -183,6 +226,8 @@ func generateConfig(a ...string) (conf []byte, err error) {
 	switch {
 	case len(a) == 3:
 		conf = []byte(fmt.Sprintf(eap, a[0], a[2], a[1]))
+	case len(a) == 2 && isPSK(a[1]):
+		conf = []byte(fmt.Sprintf(psk, a[0], a[1]))
 	case len(a) == 2:
 		conf, err = passphrase.Run(a[0], a[1])
 		if err != nil {
@@ -195,3 +240,10 @@ func generateConfig(a ...string) (conf []byte, err error) {
 	}
 	return
 }
+
+// isPSK reports whether pass is a PSK of 64 hex digits rather than a
+// passphrase, which has at most 63 characters.
+func isPSK(pass string) bool {
+	_, err := hex.DecodeString(pass)
+	return len(pass) == 64 && err == nil
+}
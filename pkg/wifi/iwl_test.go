@@ -26,6 +26,7 @@ var (
 	IdStub          = "stub"
 	PassStub        = "123456789"
 	BadWpaPskPass   = "123"
+	PSKStub         = "e270ba95a72c6d922e902f65dfa23315f7ba43b69debc75167254acd778f2fe9"
 	expWpaPsk, _    = passphrase.Run(EssidStub, PassStub)
 	_, expWpaPskErr = passphrase.Run(EssidStub, BadWpaPskPass)
 
@@ -42,6 +43,12 @@ var (
 			exp:  expWpaPsk,
 			err:  nil,
 		},
+		{
+			name: "WPA-PSK with PSK",
+			args: []string{EssidStub, PSKStub},
+			exp:  []byte(fmt.Sprintf(psk, EssidStub, PSKStub)),
+			err:  nil,
+		},
 		{
 			name: "WPA-EAP",
 			args: []string{EssidStub, PassStub, IdStub},
@@ -208,7 +215,7 @@ wlan0    Scan completed :
                     IE: Unknown: 000000000000000000
 `)
 	exp = []Option{
-		{"stub-wpa-eap-1", WpaEap},
+		{"stub-wpa-eap-1", WpaEap, -23},
 	}
 	out = parseIwlistOut(o)
 	if !reflect.DeepEqual(out, exp) {
@@ -217,11 +224,11 @@ wlan0    Scan completed :
 
 	// Regular scenarios (many choices)
 	exp = []Option{
-		{"stub-wpa-eap-1", WpaEap},
-		{"stub-rsa-1", NoEnc},
-		{"stub-wpa-psk-1", WpaPsk},
-		{"stub-rsa-2", NoEnc},
-		{"stub-wpa-psk-2", WpaPsk},
+		{"stub-wpa-eap-1", WpaEap, -23},
+		{"stub-rsa-1", NoEnc, -60},
+		{"stub-wpa-psk-1", WpaPsk, -60},
+		{"stub-rsa-2", NoEnc, -62},
+		{"stub-wpa-psk-2", WpaPsk, -60},
 	}
 	o, err = ioutil.ReadFile("iwlistStubOutput.txt")
 	if err != nil {
@@ -5,34 +5,421 @@
 package wifi
 
 import (
+	"context"
+	"encoding/hex"
 	"fmt"
+	"net"
+	"sort"
+	"sync"
 	"syscall"
-	"unsafe"
+	"time"
+
+	"github.com/u-root/u-root/pkg/dhclient"
+	"github.com/u-root/u-root/pkg/wpa"
+	"github.com/u-root/u-root/pkg/wpa/passphrase"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+var _ = WiFi(&NativeWorker{})
+
+const (
+	scanTimeout      = 10 * time.Second
+	connectTimeout   = 10 * time.Second
+	handshakeTimeout = 10 * time.Second
 )
 
+// NativeWorker implements the WiFi interface with nl80211. It does the
+// WPA2-PSK key handshakes itself, so it needs no wpa_supplicant, and gets
+// an address with DHCP like the IWLWorker.
 type NativeWorker struct {
 	Interface string
-	FD        int
-	Range     IWRange
+
+	link netlink.Link
+	nl   *nl80211
+
+	mu sync.Mutex
+	// eapol is the EAPOL connection of a WPA2 network, which is kept
+	// for group key handshakes.
+	eapol *eapolConn
+}
+
+// Link is the state of the connection of a NativeWorker.
+type Link struct {
+	Essid string
+	BSSID net.HardwareAddr
+	// Frequency is in MHz, and Signal in dBm.
+	Frequency int
+	Signal    int
 }
 
 func NewNativeWorker(i string) (WiFi, error) {
-	s, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, syscall.IPPROTO_IP)
+	l, err := netlink.LinkByName(i)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", i, err)
+	}
+	if err := netlink.LinkSetUp(l); err != nil {
+		return nil, fmt.Errorf("%s: %v", i, err)
+	}
+	n, err := newNL80211()
+	if err != nil {
+		return nil, err
+	}
+	return &NativeWorker{Interface: i, link: l, nl: n}, nil
+}
+
+func (w *NativeWorker) ifindex() *nl.RtAttr {
+	return u32Attr(attrIfindex, uint32(w.link.Attrs().Index))
+}
+
+// scan scans for access points. The essids are probed for, to find hidden
+// networks.
+func (w *NativeWorker) scan(essids ...string) ([]*bss, error) {
+	s, err := w.nl.subscribe("scan")
 	if err != nil {
 		return nil, err
 	}
-	return &NativeWorker{FD: s, Interface: i}, nil
+	defer s.Close()
+
+	ssids := nl.NewRtAttr(attrScanSSIDs, nil)
+	for i, e := range append(essids, "") {
+		ssids.AddRtAttr(i+1, []byte(e))
+	}
+	// If a scan is in progress, its results are as good.
+	if _, err := w.nl.request(cmdTriggerScan, 0, w.ifindex(), ssids); err != nil && err != syscall.EBUSY {
+		return nil, fmt.Errorf("scan: %v", err)
+	}
+	cmd, _, err := w.nl.wait(s, w.link.Attrs().Index, scanTimeout, cmdNewScanResults, cmdScanAborted)
+	if err != nil {
+		return nil, fmt.Errorf("scan: %v", err)
+	}
+	if cmd == cmdScanAborted {
+		return nil, fmt.Errorf("scan aborted")
+	}
+	return w.scanResults()
+}
+
+// scanResults returns the access points of the last scan.
+func (w *NativeWorker) scanResults() ([]*bss, error) {
+	msgs, err := w.nl.request(cmdGetScan, syscall.NLM_F_DUMP, w.ifindex())
+	if err != nil {
+		return nil, fmt.Errorf("scan results: %v", err)
+	}
+	var res []*bss
+	for _, m := range msgs {
+		if b, err := parseBSS(m[attrBSS]); err == nil {
+			res = append(res, b)
+		}
+	}
+	// The strongest first.
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].signal > res[j].signal
+	})
+	return res, nil
 }
 
 func (w *NativeWorker) Scan() ([]Option, error) {
-	return nil, fmt.Errorf("Not Yet")
+	bsses, err := w.scan()
+	if err != nil {
+		return nil, err
+	}
+	var res []Option
+	knownEssids := make(map[string]bool)
+	for _, b := range bsses {
+		if b.essid == "" || knownEssids[b.essid] {
+			continue
+		}
+		knownEssids[b.essid] = true
+		res = append(res, Option{b.essid, b.authSuite, b.signal})
+	}
+	return res, nil
+}
+
+// Status returns the state of the connection, or nil if there is none.
+func (w *NativeWorker) Status() (*Link, error) {
+	bsses, err := w.scanResults()
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range bsses {
+		if b.associated {
+			return &Link{Essid: b.essid, BSSID: b.bssid, Frequency: int(b.frequency), Signal: b.signal}, nil
+		}
+	}
+	return nil, nil
 }
 
 func (w *NativeWorker) GetID() (string, error) {
-	return "", fmt.Errorf("Not Yet")
+	l, err := w.Status()
+	if err != nil {
+		return "", err
+	}
+	if l == nil {
+		return "", fmt.Errorf("%s: not connected", w.Interface)
+	}
+	return l.Essid, nil
+}
+
+// Disconnect disconnects from the network, if connected.
+func (w *NativeWorker) Disconnect() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.disconnect()
+}
+
+func (w *NativeWorker) disconnect() error {
+	if w.eapol != nil {
+		w.eapol.Close()
+		w.eapol = nil
+	}
+	_, err := w.nl.request(cmdDisconnect, 0, w.ifindex(), nl.NewRtAttr(attrReasonCode, nl.Uint16Attr(reasonLeaving)))
+	if err != nil && err != syscall.ENOTCONN {
+		return fmt.Errorf("disconnect: %v", err)
+	}
+	return nil
+}
+
+// pmk returns the PMK of a WPA2-PSK network, from a passphrase or a PSK of
+// 64 hex digits.
+func pmk(essid, pass string) ([]byte, error) {
+	if isPSK(pass) {
+		return hex.DecodeString(pass)
+	}
+	return passphrase.PSK(essid, pass)
 }
 
 func (w *NativeWorker) Connect(a ...string) error {
-	_, _, err := syscall.Syscall(syscall.SYS_IOCTL, uintptr(w.FD), SIOCGIWRANGE, uintptr(unsafe.Pointer(&w.Range)))
-	return err
+	// format of a: [essid, pass, id]
+	var pass string
+	switch len(a) {
+	case 1:
+	case 2:
+		pass = a[1]
+	case 3:
+		return fmt.Errorf("WPA-EAP is not supported by the native worker")
+	default:
+		return fmt.Errorf("Connect needs 1, 2, or 3 args")
+	}
+	essid := a[0]
+
+	bsses, err := w.scan(essid)
+	if err != nil {
+		return err
+	}
+	var b *bss
+	for _, c := range bsses {
+		if c.essid == essid {
+			b = c
+			break
+		}
+	}
+	switch {
+	case b == nil:
+		return fmt.Errorf("%s: not found", essid)
+	case b.authSuite == NoEnc && pass != "":
+		return fmt.Errorf("%s is an open network, and needs no passphrase", essid)
+	case b.authSuite == WpaPsk && pass == "":
+		return fmt.Errorf("%s needs a passphrase", essid)
+	case b.authSuite != NoEnc && b.authSuite != WpaPsk:
+		return fmt.Errorf("%s: security protocol is not supported", essid)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.disconnect(); err != nil {
+		return err
+	}
+	if err := w.connect(b, pass); err != nil {
+		return fmt.Errorf("%s: %v", essid, err)
+	}
+	return w.dhcp()
+}
+
+// connect associates with the access point b, and does the 4-way
+// handshake for WPA2.
+func (w *NativeWorker) connect(b *bss, pass string) error {
+	s, err := w.nl.subscribe("mlme")
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	attrs := []*nl.RtAttr{
+		w.ifindex(),
+		nl.NewRtAttr(attrSSID, []byte(b.essid)),
+		nl.NewRtAttr(attrMAC, b.bssid),
+		u32Attr(attrWiphyFreq, b.frequency),
+		u32Attr(attrAuthType, authTypeOpenSystem),
+	}
+	var sup *wpa.Supplicant
+	var c *eapolConn
+	var group uint32
+	if pass != "" {
+		k, err := pmk(b.essid, pass)
+		if err != nil {
+			return err
+		}
+		ap, err := wpa.ParseRSN(b.rsn[2:])
+		if err != nil {
+			return err
+		}
+		group = ap.GroupCipher
+		if !wpa.Has(ap.PairwiseCiphers, wpa.CipherCCMP) || (group != wpa.CipherCCMP && group != wpa.CipherTKIP) {
+			return fmt.Errorf("ciphers are not supported")
+		}
+		ie := (&wpa.RSN{GroupCipher: group, PairwiseCiphers: []uint32{wpa.CipherCCMP}, AKMs: []uint32{wpa.AKMPSK}}).Element()
+		attrs = append(attrs,
+			nl.NewRtAttr(attrIE, ie),
+			flagAttr(attrPrivacy),
+			u32Attr(attrWPAVersions, wpaVersion2),
+			u32Attr(attrCipherSuitesPairwise, wpa.CipherCCMP),
+			u32Attr(attrCipherSuiteGroup, group),
+			u32Attr(attrAKMSuites, wpa.AKMPSK),
+			// The kernel drops other frames until the handshake
+			// is done.
+			flagAttr(attrControlPort),
+		)
+		sta := w.link.Attrs().HardwareAddr
+		sup = wpa.NewSupplicant(k, b.bssid, sta, ie, b.rsn)
+		// Message 1 of the handshake follows the association, so
+		// this must be ready first.
+		if c, err = dialEAPOL(w.link.Attrs().Index, sta, b.bssid); err != nil {
+			return err
+		}
+	}
+
+	if err := w.associate(s, attrs); err != nil {
+		if c != nil {
+			c.Close()
+		}
+		return err
+	}
+	if sup == nil {
+		return nil
+	}
+	if err := w.handshake(c, sup, group); err != nil {
+		c.Close()
+		w.disconnect()
+		return err
+	}
+	w.eapol = c
+	go w.rekey(c, sup, group)
+	return nil
+}
+
+// associate sends the connect command, and waits for its result on s.
+func (w *NativeWorker) associate(s *nl.NetlinkSocket, attrs []*nl.RtAttr) error {
+	if _, err := w.nl.request(cmdConnect, 0, attrs...); err != nil {
+		return fmt.Errorf("connect: %v", err)
+	}
+	_, ev, err := w.nl.wait(s, w.link.Attrs().Index, connectTimeout, cmdConnect)
+	if err != nil {
+		return fmt.Errorf("connect: %v", err)
+	}
+	if ev[attrStatusCode] == nil {
+		return fmt.Errorf("connect: no answer from access point")
+	}
+	if st := u16(ev[attrStatusCode]); st != 0 {
+		return fmt.Errorf("connect: refused by access point with status %d", st)
+	}
+	return nil
+}
+
+// handshake does the 4-way handshake, and installs its keys.
+func (w *NativeWorker) handshake(c *eapolConn, sup *wpa.Supplicant, group uint32) error {
+	deadline := time.Now().Add(handshakeTimeout)
+	for {
+		f, err := c.read(deadline)
+		if err != nil {
+			return fmt.Errorf("4-way handshake: %v; is the passphrase right?", err)
+		}
+		reply, k, err := sup.Handle(f)
+		if err != nil {
+			// Bad frames are dropped, as the access point will
+			// try again.
+			continue
+		}
+		if err := c.write(reply); err != nil {
+			return fmt.Errorf("4-way handshake: %v", err)
+		}
+		if k == nil {
+			continue
+		}
+		if err := w.installKeys(k, c.ap, group); err != nil {
+			return err
+		}
+		if k.Pairwise != nil {
+			return w.authorize(c.ap)
+		}
+	}
+}
+
+// rekey does the group key handshakes of the access point, until c is
+// closed.
+func (w *NativeWorker) rekey(c *eapolConn, sup *wpa.Supplicant, group uint32) {
+	for {
+		f, err := c.read(time.Time{})
+		if err != nil {
+			return
+		}
+		reply, k, err := sup.Handle(f)
+		if err != nil {
+			continue
+		}
+		if c.write(reply) == nil && k != nil {
+			w.installKeys(k, c.ap, group)
+		}
+	}
+}
+
+// installKeys gives the keys of a handshake with the access point ap to
+// the kernel.
+func (w *NativeWorker) installKeys(k *wpa.Keys, ap net.HardwareAddr, group uint32) error {
+	if k.Pairwise != nil {
+		if _, err := w.nl.request(cmdNewKey, 0,
+			w.ifindex(),
+			nl.NewRtAttr(attrMAC, ap),
+			nl.NewRtAttr(attrKeyData, k.Pairwise),
+			nl.NewRtAttr(attrKeyIdx, nl.Uint8Attr(0)),
+			u32Attr(attrKeyCipher, wpa.CipherCCMP),
+		); err != nil {
+			return fmt.Errorf("installing pairwise key: %v", err)
+		}
+	}
+	if _, err := w.nl.request(cmdNewKey, 0,
+		w.ifindex(),
+		nl.NewRtAttr(attrKeyData, groupKey(k.Group, group)),
+		nl.NewRtAttr(attrKeyIdx, nl.Uint8Attr(uint8(k.GroupIndex))),
+		u32Attr(attrKeyCipher, group),
+		nl.NewRtAttr(attrKeySeq, k.GroupRSC),
+	); err != nil {
+		return fmt.Errorf("installing group key: %v", err)
+	}
+	return nil
+}
+
+// authorize lets the kernel send and receive frames other than EAPOL.
+func (w *NativeWorker) authorize(ap net.HardwareAddr) error {
+	if _, err := w.nl.request(cmdSetStation, 0,
+		w.ifindex(),
+		nl.NewRtAttr(attrMAC, ap),
+		nl.NewRtAttr(attrStaFlags2, staFlags(staFlagAuthorized)),
+	); err != nil {
+		return fmt.Errorf("authorizing: %v", err)
+	}
+	return nil
+}
+
+// dhcp configures the interface with DHCPv4.
+func (w *NativeWorker) dhcp() error {
+	// Like the IWLWorker, give it 30 seconds.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for r := range dhclient.SendRequests(ctx, []netlink.Link{w.link}, 5*time.Second, 3, true, false) {
+		if r.Err != nil {
+			return r.Err
+		}
+		return r.Lease.Configure()
+	}
+	return fmt.Errorf("%s: no DHCP lease", w.Interface)
 }
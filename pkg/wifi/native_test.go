@@ -5,7 +5,12 @@
 package wifi
 
 import (
+	"bytes"
+	"net"
 	"testing"
+
+	"github.com/u-root/u-root/pkg/wpa"
+	"github.com/vishvananda/netlink/nl"
 )
 
 func TestNative(t *testing.T) {
@@ -18,9 +23,104 @@ func TestNative(t *testing.T) {
 		return
 	}
 	t.Logf("Native is %v", w)
-	err = w.Connect()
+	o, err := w.Scan()
 	if err != nil {
 		t.Log(err)
 		return
 	}
+	t.Logf("Scan found %v", o)
+}
+
+// bssAttr returns the attrBSS of an access point with the information
+// elements ies.
+func bssAttr(bssid net.HardwareAddr, signal int32, capability uint16, associated bool, ies ...[]byte) []byte {
+	a := nl.NewRtAttr(attrBSS, nil)
+	a.AddRtAttr(bssBSSID, bssid)
+	a.AddRtAttr(bssFrequency, nl.Uint32Attr(2412))
+	a.AddRtAttr(bssCapability, nl.Uint16Attr(capability))
+	a.AddRtAttr(bssInformationElements, bytes.Join(ies, nil))
+	a.AddRtAttr(bssSignalMBM, nl.Uint32Attr(uint32(signal)))
+	if associated {
+		a.AddRtAttr(bssStatus, nl.Uint32Attr(bssStatusAssociated))
+	}
+	// Skip the header of attrBSS.
+	return a.Serialize()[4:]
+}
+
+func ssid(s string) []byte {
+	return append([]byte{ssidElementID, byte(len(s))}, s...)
+}
+
+func TestParseBSS(t *testing.T) {
+	mac := net.HardwareAddr{2, 0, 0, 0, 0, 1}
+	psk := (&wpa.RSN{GroupCipher: wpa.CipherCCMP, PairwiseCiphers: []uint32{wpa.CipherCCMP}, AKMs: []uint32{wpa.AKMPSK}}).Element()
+	eap := (&wpa.RSN{GroupCipher: wpa.CipherCCMP, PairwiseCiphers: []uint32{wpa.CipherCCMP}, AKMs: []uint32{wpa.AKM8021X}}).Element()
+	sae := (&wpa.RSN{GroupCipher: wpa.CipherCCMP, PairwiseCiphers: []uint32{wpa.CipherCCMP}, AKMs: []uint32{0x000fac08}}).Element()
+	wpa1 := []byte{vendorElementID, 6, 0x00, 0x50, 0xf2, 1, 1, 0}
+	rates := []byte{1, 4, 0x82, 0x84, 0x8b, 0x96}
+
+	for _, tt := range []struct {
+		name       string
+		attr       []byte
+		essid      string
+		auth       SecProto
+		associated bool
+	}{
+		{"open", bssAttr(mac, -4200, 0x401, true, ssid("open"), rates), "open", NoEnc, true},
+		{"PSK", bssAttr(mac, -4200, 0x411, false, ssid("psk"), rates, psk), "psk", WpaPsk, false},
+		{"EAP", bssAttr(mac, -4200, 0x411, false, ssid("eap"), eap), "eap", WpaEap, false},
+		{"SAE", bssAttr(mac, -4200, 0x411, false, ssid("sae"), sae), "sae", NotSupportedProto, false},
+		{"WPA", bssAttr(mac, -4200, 0x411, false, ssid("wpa"), wpa1), "wpa", NotSupportedProto, false},
+		{"WEP", bssAttr(mac, -4200, 0x411, false, ssid("wep")), "wep", NotSupportedProto, false},
+		{"hidden", bssAttr(mac, -4200, 0x401, false, ssid("")), "", NoEnc, false},
+		{"truncated", bssAttr(mac, -4200, 0x401, false, ssid("trunc"), []byte{48, 20, 1, 0}), "trunc", NoEnc, false},
+	} {
+		b, err := parseBSS(tt.attr)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if b.essid != tt.essid || b.authSuite != tt.auth || b.associated != tt.associated {
+			t.Errorf("%s: got %q, %v, associated %v, want %q, %v, associated %v", tt.name, b.essid, b.authSuite, b.associated, tt.essid, tt.auth, tt.associated)
+		}
+		if !bytes.Equal(b.bssid, mac) || b.signal != -42 || b.frequency != 2412 {
+			t.Errorf("%s: got BSSID %v, signal %d and frequency %d, want %v, -42 and 2412", tt.name, b.bssid, b.signal, b.frequency, mac)
+		}
+	}
+
+	if b, err := parseBSS(nl.NewRtAttr(bssFrequency, nl.Uint32Attr(2412)).Serialize()); err == nil {
+		t.Errorf("BSS without BSSID: got %+v, want error", b)
+	}
+}
+
+func TestPMK(t *testing.T) {
+	k, err := pmk("IEEE", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hex := "f42c6fc52df0ebef9ebb4b90b38a5f902e83fe1b135a70e23aed762e9710a12e"
+	k2, err := pmk("other", hex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(k, k2) {
+		t.Errorf("pmk(other, %s) = %x, want the PSK itself", hex, k2)
+	}
+	if _, err := pmk("IEEE", "short"); err == nil {
+		t.Errorf("pmk(IEEE, short) succeeded, want error")
+	}
+}
+
+func TestGroupKey(t *testing.T) {
+	k := make([]byte, 32)
+	for i := range k {
+		k[i] = byte(i)
+	}
+	if g := groupKey(k[:16], wpa.CipherCCMP); !bytes.Equal(g, k[:16]) {
+		t.Errorf("CCMP group key is %x, want %x", g, k[:16])
+	}
+	want := append(append(append([]byte{}, k[:16]...), k[24:]...), k[16:24]...)
+	if g := groupKey(k, wpa.CipherTKIP); !bytes.Equal(g, want) {
+		t.Errorf("TKIP group key is %x, want %x", g, want)
+	}
 }
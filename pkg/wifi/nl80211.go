@@ -0,0 +1,312 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wifi
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/u-root/u-root/pkg/wpa"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// This file has the parts of the nl80211 generic netlink interface of
+// linux/nl80211.h that the NativeWorker uses.
+
+// nl80211 commands.
+const (
+	cmdNewKey         = 11
+	cmdSetStation     = 18
+	cmdGetScan        = 32
+	cmdTriggerScan    = 33
+	cmdNewScanResults = 34
+	cmdScanAborted    = 35
+	cmdConnect        = 46
+	cmdDisconnect     = 48
+)
+
+// nl80211 attributes.
+const (
+	attrIfindex              = 3
+	attrMAC                  = 6
+	attrKeyData              = 7
+	attrKeyIdx               = 8
+	attrKeyCipher            = 9
+	attrKeySeq               = 10
+	attrWiphyFreq            = 38
+	attrIE                   = 42
+	attrScanSSIDs            = 45
+	attrBSS                  = 47
+	attrSSID                 = 52
+	attrAuthType             = 53
+	attrReasonCode           = 54
+	attrStaFlags2            = 67
+	attrControlPort          = 68
+	attrPrivacy              = 70
+	attrStatusCode           = 72
+	attrCipherSuitesPairwise = 73
+	attrCipherSuiteGroup     = 74
+	attrWPAVersions          = 75
+	attrAKMSuites            = 76
+)
+
+// Attributes of a BSS, nested in attrBSS.
+const (
+	bssBSSID               = 1
+	bssFrequency           = 2
+	bssCapability          = 5
+	bssInformationElements = 6
+	bssSignalMBM           = 7
+	bssStatus              = 9
+)
+
+const (
+	bssStatusAssociated = 1
+	authTypeOpenSystem  = 0
+	wpaVersion2         = 2
+	staFlagAuthorized   = 1
+	// reasonLeaving is the reason code of a station that leaves.
+	reasonLeaving = 3
+	// capabilityPrivacy is the bit of the capability information of
+	// access points that need encryption.
+	capabilityPrivacy = 1 << 4
+	// ssidElementID and vendorElementID are the IDs of the SSID and
+	// vendor specific information elements.
+	ssidElementID   = 0
+	vendorElementID = 221
+)
+
+// wpaOUI is the start of the vendor specific element of WPA version 1.
+var wpaOUI = []byte{0x00, 0x50, 0xf2, 1}
+
+// nl80211 is a connection to the nl80211 generic netlink family.
+type nl80211 struct {
+	family *netlink.GenlFamily
+}
+
+func newNL80211() (*nl80211, error) {
+	f, err := netlink.GenlFamilyGet("nl80211")
+	if err != nil {
+		return nil, fmt.Errorf("nl80211: %v", err)
+	}
+	return &nl80211{family: f}, nil
+}
+
+// request sends an nl80211 command, and returns the attributes of the
+// replies. Commands that are not dumps are acknowledged, so request
+// returns once they are done.
+func (n *nl80211) request(cmd uint8, flags int, attrs ...*nl.RtAttr) ([]map[uint16][]byte, error) {
+	if flags&unix.NLM_F_DUMP == 0 {
+		flags |= unix.NLM_F_ACK
+	}
+	req := nl.NewNetlinkRequest(int(n.family.ID), flags)
+	req.AddData(&nl.Genlmsg{Command: cmd})
+	for _, a := range attrs {
+		req.AddData(a)
+	}
+	msgs, err := req.Execute(unix.NETLINK_GENERIC, n.family.ID)
+	if err != nil {
+		return nil, err
+	}
+	var res []map[uint16][]byte
+	for _, m := range msgs {
+		if len(m) < nl.SizeofGenlmsg {
+			continue
+		}
+		a, err := parseAttrs(m[nl.SizeofGenlmsg:])
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, a)
+	}
+	return res, nil
+}
+
+// parseAttrs returns the netlink attributes in b by type.
+func parseAttrs(b []byte) (map[uint16][]byte, error) {
+	attrs, err := nl.ParseRouteAttr(b)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[uint16][]byte)
+	for _, a := range attrs {
+		m[a.Attr.Type&^(unix.NLA_F_NESTED|unix.NLA_F_NET_BYTEORDER)] = a.Value
+	}
+	return m, nil
+}
+
+// subscribe returns a socket that gets the events of the nl80211
+// multicast group.
+func (n *nl80211) subscribe(group string) (*nl.NetlinkSocket, error) {
+	for _, g := range n.family.Groups {
+		if g.Name != group {
+			continue
+		}
+		s, err := nl.Subscribe(unix.NETLINK_GENERIC)
+		if err != nil {
+			return nil, err
+		}
+		// The groups of generic netlink families do not fit in the
+		// bitmap of Subscribe.
+		if err := unix.SetsockoptInt(s.GetFd(), unix.SOL_NETLINK, unix.NETLINK_ADD_MEMBERSHIP, int(g.ID)); err != nil {
+			s.Close()
+			return nil, err
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("nl80211 has no multicast group %q", group)
+}
+
+// wait waits for one of the events cmds of the interface with index
+// ifindex, and returns its command and attributes.
+func (n *nl80211) wait(s *nl.NetlinkSocket, ifindex int, timeout time.Duration, cmds ...uint8) (uint8, map[uint16][]byte, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		left := time.Until(deadline)
+		if left <= 0 {
+			return 0, nil, fmt.Errorf("timed out after %v", timeout)
+		}
+		tv := unix.NsecToTimeval(left.Nanoseconds())
+		if err := s.SetReceiveTimeout(&tv); err != nil {
+			return 0, nil, err
+		}
+		msgs, err := s.Receive()
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		for _, m := range msgs {
+			if m.Header.Type != n.family.ID || len(m.Data) < nl.SizeofGenlmsg {
+				continue
+			}
+			attrs, err := parseAttrs(m.Data[nl.SizeofGenlmsg:])
+			if err != nil || u32(attrs[attrIfindex]) != uint32(ifindex) {
+				continue
+			}
+			for _, c := range cmds {
+				if m.Data[0] == c {
+					return c, attrs, nil
+				}
+			}
+		}
+	}
+}
+
+func u16(b []byte) uint16 {
+	if len(b) < 2 {
+		return 0
+	}
+	return nl.NativeEndian().Uint16(b)
+}
+
+func u32(b []byte) uint32 {
+	if len(b) < 4 {
+		return 0
+	}
+	return nl.NativeEndian().Uint32(b)
+}
+
+// bss is an access point found by a scan.
+type bss struct {
+	bssid      net.HardwareAddr
+	frequency  uint32
+	signal     int
+	essid      string
+	authSuite  SecProto
+	associated bool
+	// rsn is the RSN element of the access point, if it has one.
+	rsn []byte
+}
+
+// parseBSS parses the attributes of attrBSS.
+func parseBSS(b []byte) (*bss, error) {
+	attrs, err := parseAttrs(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(attrs[bssBSSID]) != 6 {
+		return nil, fmt.Errorf("BSS has no BSSID")
+	}
+	r := &bss{
+		bssid:      net.HardwareAddr(attrs[bssBSSID]),
+		frequency:  u32(attrs[bssFrequency]),
+		signal:     int(int32(u32(attrs[bssSignalMBM]))) / 100,
+		associated: attrs[bssStatus] != nil && u32(attrs[bssStatus]) == bssStatusAssociated,
+	}
+
+	var wpa1 bool
+	ies := attrs[bssInformationElements]
+	for len(ies) >= 2 {
+		id, l := ies[0], int(ies[1])
+		if len(ies) < 2+l {
+			break
+		}
+		e := ies[2 : 2+l]
+		switch {
+		case id == ssidElementID:
+			r.essid = string(e)
+		case id == wpa.RSNElementID:
+			r.rsn = ies[:2+l]
+		case id == vendorElementID && l >= 4 && string(e[:4]) == string(wpaOUI):
+			wpa1 = true
+		}
+		ies = ies[2+l:]
+	}
+
+	// Like parseIwlistOut, only WPA2 with PSK or 802.1X is supported.
+	privacy := u16(attrs[bssCapability])&capabilityPrivacy != 0
+	switch {
+	case r.rsn != nil:
+		r.authSuite = NotSupportedProto
+		if rsn, err := wpa.ParseRSN(r.rsn[2:]); err == nil {
+			if wpa.Has(rsn.AKMs, wpa.AKMPSK) {
+				r.authSuite = WpaPsk
+			} else if wpa.Has(rsn.AKMs, wpa.AKM8021X) {
+				r.authSuite = WpaEap
+			}
+		}
+	case wpa1, privacy:
+		r.authSuite = NotSupportedProto
+	default:
+		r.authSuite = NoEnc
+	}
+	return r, nil
+}
+
+// flagAttr returns a netlink flag attribute.
+func flagAttr(t int) *nl.RtAttr {
+	return nl.NewRtAttr(t, nil)
+}
+
+func u32Attr(t int, v uint32) *nl.RtAttr {
+	return nl.NewRtAttr(t, nl.Uint32Attr(v))
+}
+
+// staFlags returns the nl80211_sta_flag_update for attrStaFlags2 that sets
+// flag.
+func staFlags(flag uint) []byte {
+	b := make([]byte, 8)
+	nl.NativeEndian().PutUint32(b, 1<<flag)
+	nl.NativeEndian().PutUint32(b[4:], 1<<flag)
+	return b
+}
+
+// groupKey returns the group key of the 4-way handshake in the layout of
+// nl80211. For TKIP, the Michael MIC keys are swapped, as the handshake
+// has them from the point of view of the authenticator.
+func groupKey(k []byte, cipher uint32) []byte {
+	if cipher != wpa.CipherTKIP || len(k) != 32 {
+		return k
+	}
+	g := append([]byte{}, k[:16]...)
+	g = append(g, k[24:32]...)
+	return append(g, k[16:24]...)
+}
@@ -0,0 +1,61 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wifi
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// The generic netlink controller is always there, unlike nl80211, so it
+// stands in for it to test the netlink plumbing.
+func TestRequest(t *testing.T) {
+	ctrl, err := netlink.GenlFamilyGet(nl.GENL_CTRL_NAME)
+	if err != nil {
+		t.Skipf("No generic netlink: %v", err)
+	}
+	n := &nl80211{family: ctrl}
+
+	msgs, err := n.request(nl.GENL_CTRL_CMD_GETFAMILY, 0, nl.NewRtAttr(nl.GENL_CTRL_ATTR_FAMILY_NAME, nl.ZeroTerminated(nl.GENL_CTRL_NAME)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || u16(msgs[0][nl.GENL_CTRL_ATTR_FAMILY_ID]) != ctrl.ID {
+		t.Errorf("Got %v, want the family ID %d", msgs, ctrl.ID)
+	}
+
+	msgs, err = n.request(nl.GENL_CTRL_CMD_GETFAMILY, 0, nl.NewRtAttr(nl.GENL_CTRL_ATTR_FAMILY_NAME, nl.ZeroTerminated("nope")))
+	if err == nil {
+		t.Errorf("Got %v for a family that does not exist, want error", msgs)
+	}
+
+	msgs, err = n.request(nl.GENL_CTRL_CMD_GETFAMILY, syscall.NLM_F_DUMP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) < 2 {
+		t.Errorf("Dump has %d families, want at least 2", len(msgs))
+	}
+
+	if _, err := n.subscribe("nope"); err == nil {
+		t.Errorf("Subscribing to a group that does not exist succeeded, want error")
+	}
+	s, err := n.subscribe("notify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	start := time.Now()
+	if _, _, err := n.wait(s, 1, 100*time.Millisecond, cmdConnect); err == nil {
+		t.Errorf("Got an event, want a timeout")
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Errorf("Timed out after %v, want 100ms", d)
+	}
+}
@@ -4,11 +4,19 @@
 
 package wifi
 
+import "sync"
+
 var _ = WiFi(&StubWorker{})
 
+// StubWorker is a WiFi for tests. Connect always succeeds, and makes its
+// essid the ID.
 type StubWorker struct {
 	Options []Option
 	ID      string
+	// Connects are the arguments of the calls to Connect.
+	Connects [][]string
+
+	mu sync.Mutex
 }
 
 func (w *StubWorker) Scan() ([]Option, error) {
@@ -16,13 +24,28 @@ func (w *StubWorker) Scan() ([]Option, error) {
 }
 
 func (w *StubWorker) GetID() (string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	return w.ID, nil
 }
 
-func (*StubWorker) Connect(a ...string) error {
+func (w *StubWorker) Connect(a ...string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.Connects = append(w.Connects, a)
+	if len(a) > 0 {
+		w.ID = a[0]
+	}
 	return nil
 }
 
+// Disconnect simulates the loss of the connection.
+func (w *StubWorker) Disconnect() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.ID = ""
+}
+
 func NewStubWorker(id string, options ...Option) (WiFi, error) {
 	return &StubWorker{ID: id, Options: options}, nil
 }
@@ -5,8 +5,10 @@
 package wifi
 
 type Option struct {
-	Essid     string
-	AuthSuite SecProto
+	Essid     string   `json:"essid"`
+	AuthSuite SecProto `json:"security"`
+	// Signal is the signal level in dBm, or 0 if it is not known.
+	Signal int `json:"signal,omitempty"`
 }
 
 type WiFi interface {
@@ -0,0 +1,125 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wpa
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+)
+
+// EtherType is the EtherType of EAPOL frames.
+const EtherType = 0x888e
+
+const (
+	// eapolVersion is the EAPOL version of the frames we send. Like
+	// wpa_supplicant, we use version 1, which all authenticators know.
+	eapolVersion = 1
+	eapolKey     = 3
+
+	descriptorRSN = 2
+
+	// keyHeaderLen is the length of an EAPOL-Key frame without key data,
+	// including the EAPOL header.
+	keyHeaderLen = 99
+	micOffset    = 81
+	micLen       = 16
+)
+
+// Bits of the key information field.
+const (
+	keyVersionMask = 7
+	// keyVersionAES is the version of HMAC-SHA1 MICs and AES key wrap.
+	keyVersionAES = 2
+
+	keyPairwise  = 1 << 3
+	keyInstall   = 1 << 6
+	keyAck       = 1 << 7
+	keyMIC       = 1 << 8
+	keySecure    = 1 << 9
+	keyError     = 1 << 10
+	keyRequest   = 1 << 11
+	keyEncrypted = 1 << 12
+)
+
+// keyFrame is an EAPOL-Key frame, with an RSN key descriptor.
+type keyFrame struct {
+	info   uint16
+	length uint16
+	replay uint64
+	nonce  [32]byte
+	rsc    [8]byte
+	mic    [micLen]byte
+	data   []byte
+
+	// raw is the frame as received, without padding.
+	raw []byte
+}
+
+func parseKeyFrame(b []byte) (*keyFrame, error) {
+	if len(b) < 4 || b[1] != eapolKey {
+		return nil, fmt.Errorf("not an EAPOL-Key frame")
+	}
+	n := 4 + int(binary.BigEndian.Uint16(b[2:]))
+	if n < keyHeaderLen || len(b) < n {
+		return nil, fmt.Errorf("EAPOL-Key frame is too short")
+	}
+	b = b[:n]
+	if b[4] != descriptorRSN {
+		return nil, fmt.Errorf("EAPOL-Key descriptor type is %d, want %d", b[4], descriptorRSN)
+	}
+	f := &keyFrame{
+		info:   binary.BigEndian.Uint16(b[5:]),
+		length: binary.BigEndian.Uint16(b[7:]),
+		replay: binary.BigEndian.Uint64(b[9:]),
+		raw:    b,
+	}
+	copy(f.nonce[:], b[17:49])
+	copy(f.rsc[:], b[65:73])
+	copy(f.mic[:], b[micOffset:micOffset+micLen])
+	l := int(binary.BigEndian.Uint16(b[97:]))
+	if keyHeaderLen+l > n {
+		return nil, fmt.Errorf("EAPOL-Key data length %d is beyond the frame", l)
+	}
+	f.data = b[keyHeaderLen : keyHeaderLen+l]
+	return f, nil
+}
+
+// marshal returns the frame, with a MIC made with kck if it is not nil.
+func (f *keyFrame) marshal(kck []byte) []byte {
+	b := make([]byte, keyHeaderLen+len(f.data))
+	b[0], b[1] = eapolVersion, eapolKey
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)-4))
+	b[4] = descriptorRSN
+	binary.BigEndian.PutUint16(b[5:], f.info)
+	binary.BigEndian.PutUint16(b[7:], f.length)
+	binary.BigEndian.PutUint64(b[9:], f.replay)
+	copy(b[17:], f.nonce[:])
+	copy(b[65:], f.rsc[:])
+	binary.BigEndian.PutUint16(b[97:], uint16(len(f.data)))
+	copy(b[keyHeaderLen:], f.data)
+	if kck != nil {
+		copy(b[micOffset:], mic(kck, b))
+	}
+	return b
+}
+
+// mic returns the MIC of an EAPOL frame whose MIC field is zero.
+func mic(kck, b []byte) []byte {
+	h := hmac.New(sha1.New, kck)
+	h.Write(b)
+	return h.Sum(nil)[:micLen]
+}
+
+// checkMIC checks the MIC of a received frame.
+func (f *keyFrame) checkMIC(kck []byte) error {
+	b := append([]byte{}, f.raw...)
+	copy(b[micOffset:micOffset+micLen], make([]byte, micLen))
+	if !hmac.Equal(mic(kck, b), f.mic[:]) {
+		return fmt.Errorf("EAPOL-Key MIC check failed")
+	}
+	return nil
+}
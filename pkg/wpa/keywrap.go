@@ -0,0 +1,47 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wpa
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
+	"fmt"
+)
+
+// keyWrapIV is the initial value of RFC 3394.
+var keyWrapIV = []byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+
+// unwrapKey decrypts data that was wrapped with kek, as in RFC 3394. The
+// key data of EAPOL-Key frames is wrapped this way.
+func unwrapKey(kek, data []byte) ([]byte, error) {
+	if len(data)%8 != 0 || len(data) < 24 {
+		return nil, fmt.Errorf("wrapped key data is %d bytes, want a multiple of 8 of at least 24", len(data))
+	}
+	c, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	n := len(data)/8 - 1
+	a := make([]byte, 8)
+	copy(a, data[:8])
+	r := make([]byte, 8*n)
+	copy(r, data[8:])
+	b := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(b, binary.BigEndian.Uint64(a)^t)
+			copy(b[8:], r[8*(i-1):8*i])
+			c.Decrypt(b, b)
+			copy(a, b[:8])
+			copy(r[8*(i-1):8*i], b[8:])
+		}
+	}
+	if !bytes.Equal(a, keyWrapIV) {
+		return nil, fmt.Errorf("key data integrity check failed")
+	}
+	return r, nil
+}
@@ -33,7 +33,9 @@ func errorCheck(essid string, pass string) error {
 	return nil
 }
 
-func Run(essid string, pass string) ([]byte, error) {
+// PSK returns the pre-shared key for a passphrase, which is also the PMK of
+// WPA-PSK networks.
+func PSK(essid string, pass string) ([]byte, error) {
 	if err := errorCheck(essid, pass); err != nil {
 		return nil, err
 	}
@@ -42,7 +44,14 @@ func Run(essid string, pass string) ([]byte, error) {
 	// static and shared across access points. Thus this salt is not sufficiently random.
 	// This issue has been reported to the responsible parties. Since this matches the
 	// current implementation of wpa_passphrase.c, this will maintain until further notice.
-	pskBinary := pbkdf2.Key([]byte(pass), []byte(essid), 4096, 32, sha1.New)
+	return pbkdf2.Key([]byte(pass), []byte(essid), 4096, 32, sha1.New), nil
+}
+
+func Run(essid string, pass string) ([]byte, error) {
+	pskBinary, err := PSK(essid, pass)
+	if err != nil {
+		return nil, err
+	}
 	pskHexString := hex.EncodeToString(pskBinary)
 	return []byte(fmt.Sprintf(ResultFormat, essid, pass, pskHexString)), nil
 }
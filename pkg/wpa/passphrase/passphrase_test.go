@@ -5,6 +5,7 @@
 package passphrase
 
 import (
+	"encoding/hex"
 	"fmt"
 	"reflect"
 	"testing"
@@ -69,3 +70,18 @@ func TestRun(t *testing.T) {
 		}
 	}
 }
+
+func TestPSK(t *testing.T) {
+	// The test vector of IEEE 802.11i, Annex H.4.
+	want := "f42c6fc52df0ebef9ebb4b90b38a5f902e83fe1b135a70e23aed762e9710a12e"
+	psk, err := PSK("IEEE", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(psk); got != want {
+		t.Errorf("PSK(IEEE, password) = %s, want %s", got, want)
+	}
+	if _, err := PSK(essidStub, shortPass); err == nil {
+		t.Errorf("PSK(%q, %q) succeeded, want error", essidStub, shortPass)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wpa
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Cipher and AKM suite selectors, with the IEEE 802.11 OUI 00-0F-AC.
+const (
+	CipherTKIP = 0x000fac02
+	CipherCCMP = 0x000fac04
+
+	AKM8021X = 0x000fac01
+	AKMPSK   = 0x000fac02
+)
+
+// RSNElementID is the ID of the RSN information element.
+const RSNElementID = 48
+
+// RSN is the content of an RSN information element, which says which
+// ciphers and key management an access point or station uses.
+type RSN struct {
+	GroupCipher     uint32
+	PairwiseCiphers []uint32
+	AKMs            []uint32
+	Capabilities    uint16
+}
+
+// ParseRSN parses the body of an RSN information element. Fields missing
+// at the end of it get the defaults of IEEE 802.11: CCMP and 802.1X.
+func ParseRSN(b []byte) (*RSN, error) {
+	r := &RSN{GroupCipher: CipherCCMP, PairwiseCiphers: []uint32{CipherCCMP}, AKMs: []uint32{AKM8021X}}
+	if len(b) < 2 || binary.LittleEndian.Uint16(b) != 1 {
+		return nil, fmt.Errorf("RSN element is not version 1")
+	}
+	b = b[2:]
+	if len(b) < 4 {
+		return r, nil
+	}
+	r.GroupCipher, b = binary.BigEndian.Uint32(b), b[4:]
+
+	suites := func() ([]uint32, error) {
+		n := int(binary.LittleEndian.Uint16(b))
+		b = b[2:]
+		if n == 0 || len(b) < 4*n {
+			return nil, fmt.Errorf("RSN element has a bad suite count %d", n)
+		}
+		s := make([]uint32, n)
+		for i := range s {
+			s[i], b = binary.BigEndian.Uint32(b), b[4:]
+		}
+		return s, nil
+	}
+	var err error
+	if len(b) < 2 {
+		return r, nil
+	}
+	if r.PairwiseCiphers, err = suites(); err != nil {
+		return nil, err
+	}
+	if len(b) < 2 {
+		return r, nil
+	}
+	if r.AKMs, err = suites(); err != nil {
+		return nil, err
+	}
+	if len(b) >= 2 {
+		r.Capabilities = binary.LittleEndian.Uint16(b)
+	}
+	return r, nil
+}
+
+// Has reports whether suite is one of suites.
+func Has(suites []uint32, suite uint32) bool {
+	for _, s := range suites {
+		if s == suite {
+			return true
+		}
+	}
+	return false
+}
+
+// Element returns the RSN information element, with its ID and length.
+func (r *RSN) Element() []byte {
+	b := []byte{RSNElementID, 0, 1, 0}
+	b = appendSuite(b, r.GroupCipher)
+	b = append(b, byte(len(r.PairwiseCiphers)), byte(len(r.PairwiseCiphers)>>8))
+	for _, s := range r.PairwiseCiphers {
+		b = appendSuite(b, s)
+	}
+	b = append(b, byte(len(r.AKMs)), byte(len(r.AKMs)>>8))
+	for _, s := range r.AKMs {
+		b = appendSuite(b, s)
+	}
+	b = append(b, byte(r.Capabilities), byte(r.Capabilities>>8))
+	b[1] = byte(len(b) - 2)
+	return b
+}
+
+func appendSuite(b []byte, s uint32) []byte {
+	return append(b, byte(s>>24), byte(s>>16), byte(s>>8), byte(s))
+}
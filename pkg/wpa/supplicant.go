@@ -0,0 +1,234 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package wpa implements the supplicant side of the WPA2 key handshakes:
+// the 4-way handshake that gives the pairwise and group keys, and the group
+// key handshake that renews the group key. It only deals with EAPOL frames;
+// sending them and installing the keys is up to the caller.
+//
+// Only the pairwise CCMP cipher and the MIC and key wrap of key descriptor
+// version 2 are supported, which is what WPA2-PSK networks use.
+package wpa
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"fmt"
+	"net"
+)
+
+// Keys are the keys a handshake gives, to be installed once the reply to
+// its last frame is sent.
+type Keys struct {
+	// Pairwise is the temporal key for unicast frames. It is nil after
+	// a group key handshake.
+	Pairwise []byte
+	// Group is the key for broadcast frames, and GroupIndex its index.
+	Group      []byte
+	GroupIndex int
+	// GroupRSC is the receive sequence counter of the group key.
+	GroupRSC []byte
+}
+
+// Supplicant is the supplicant side of the handshakes with an
+// authenticator.
+type Supplicant struct {
+	pmk     []byte
+	aa, spa net.HardwareAddr
+	ie      []byte
+	apIE    []byte
+
+	anonce [32]byte
+	snonce [32]byte
+	// tptk is the PTK of the handshake in progress, and ptk the one of
+	// the last completed handshake.
+	tptk []byte
+	ptk  []byte
+
+	replay     uint64
+	haveReplay bool
+}
+
+// NewSupplicant returns a Supplicant for the authenticator aa, whose RSN
+// information element is apIE, for the station spa, which associated with
+// the RSN information element ie. If apIE is not nil, the RSN information
+// element of the authenticator must match it in the 4-way handshake.
+func NewSupplicant(pmk []byte, aa, spa net.HardwareAddr, ie, apIE []byte) *Supplicant {
+	return &Supplicant{pmk: pmk, aa: aa, spa: spa, ie: ie, apIE: apIE}
+}
+
+// prf is the PRF of IEEE 802.11i, with n bytes of output.
+func prf(key []byte, label string, data []byte, n int) []byte {
+	var out []byte
+	for i := byte(0); len(out) < n; i++ {
+		h := hmac.New(sha1.New, key)
+		h.Write([]byte(label))
+		h.Write([]byte{0})
+		h.Write(data)
+		h.Write([]byte{i})
+		out = h.Sum(out)
+	}
+	return out[:n]
+}
+
+// pairwiseKey returns the PTK of a 4-way handshake. With CCMP, it is the
+// KCK, the KEK and the TK, 16 bytes each.
+func pairwiseKey(pmk []byte, aa, spa net.HardwareAddr, anonce, snonce []byte) []byte {
+	var data []byte
+	if bytes.Compare(aa, spa) < 0 {
+		data = append(append(data, aa...), spa...)
+	} else {
+		data = append(append(data, spa...), aa...)
+	}
+	if bytes.Compare(anonce, snonce) < 0 {
+		data = append(append(data, anonce...), snonce...)
+	} else {
+		data = append(append(data, snonce...), anonce...)
+	}
+	return prf(pmk, "Pairwise key expansion", data, 48)
+}
+
+func kck(ptk []byte) []byte { return ptk[:16] }
+func kek(ptk []byte) []byte { return ptk[16:32] }
+func tk(ptk []byte) []byte  { return ptk[32:48] }
+
+// Handle handles an EAPOL frame from the authenticator. It returns the
+// frame to reply with, and the keys to install once the reply is sent, if
+// the frame completes a handshake. Frames that are not valid are refused
+// with an error, and should be dropped.
+func (s *Supplicant) Handle(frame []byte) ([]byte, *Keys, error) {
+	f, err := parseKeyFrame(frame)
+	if err != nil {
+		return nil, nil, err
+	}
+	if v := f.info & keyVersionMask; v != keyVersionAES {
+		return nil, nil, fmt.Errorf("EAPOL-Key descriptor version %d is not supported", v)
+	}
+	if f.info&keyAck == 0 || f.info&keyRequest != 0 {
+		return nil, nil, fmt.Errorf("EAPOL-Key frame is not from an authenticator")
+	}
+	if s.haveReplay && f.replay <= s.replay {
+		return nil, nil, fmt.Errorf("EAPOL-Key replay counter %d is not above %d", f.replay, s.replay)
+	}
+	switch {
+	case f.info&keyPairwise == 0:
+		return s.groupMessage1(f)
+	case f.info&keyMIC == 0:
+		return s.message1(f)
+	default:
+		return s.message3(f)
+	}
+}
+
+// message1 handles message 1 of the 4-way handshake, and returns message 2.
+func (s *Supplicant) message1(f *keyFrame) ([]byte, *Keys, error) {
+	if _, err := rand.Read(s.snonce[:]); err != nil {
+		return nil, nil, err
+	}
+	s.anonce = f.nonce
+	s.tptk = pairwiseKey(s.pmk, s.aa, s.spa, s.anonce[:], s.snonce[:])
+	r := &keyFrame{
+		info:   keyVersionAES | keyPairwise | keyMIC,
+		replay: f.replay,
+		nonce:  s.snonce,
+		data:   s.ie,
+	}
+	return r.marshal(kck(s.tptk)), nil, nil
+}
+
+// message3 handles message 3 of the 4-way handshake, and returns message 4.
+func (s *Supplicant) message3(f *keyFrame) ([]byte, *Keys, error) {
+	if s.tptk == nil {
+		return nil, nil, fmt.Errorf("got message 3 of the 4-way handshake before message 1")
+	}
+	if f.info&(keyInstall|keyEncrypted) != keyInstall|keyEncrypted {
+		return nil, nil, fmt.Errorf("message 3 of the 4-way handshake has bad key information %#x", f.info)
+	}
+	if f.nonce != s.anonce {
+		return nil, nil, fmt.Errorf("ANonce of message 3 of the 4-way handshake differs from message 1")
+	}
+	if err := f.checkMIC(kck(s.tptk)); err != nil {
+		return nil, nil, err
+	}
+	s.ptk, s.replay, s.haveReplay = s.tptk, f.replay, true
+
+	k, ie, err := s.keyData(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.apIE != nil && !bytes.Equal(ie, s.apIE) {
+		return nil, nil, fmt.Errorf("RSN element of message 3 of the 4-way handshake differs from the access point's")
+	}
+	k.Pairwise = tk(s.ptk)
+
+	r := &keyFrame{
+		info:   keyVersionAES | keyPairwise | keyMIC | keySecure,
+		replay: f.replay,
+	}
+	return r.marshal(kck(s.ptk)), k, nil
+}
+
+// groupMessage1 handles message 1 of the group key handshake, and returns
+// message 2.
+func (s *Supplicant) groupMessage1(f *keyFrame) ([]byte, *Keys, error) {
+	if s.ptk == nil {
+		return nil, nil, fmt.Errorf("got a group key message before the 4-way handshake")
+	}
+	if f.info&(keyMIC|keySecure|keyEncrypted) != keyMIC|keySecure|keyEncrypted {
+		return nil, nil, fmt.Errorf("group key message has bad key information %#x", f.info)
+	}
+	if err := f.checkMIC(kck(s.ptk)); err != nil {
+		return nil, nil, err
+	}
+	s.replay, s.haveReplay = f.replay, true
+
+	k, _, err := s.keyData(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := &keyFrame{
+		info:   keyVersionAES | keyMIC | keySecure,
+		replay: f.replay,
+	}
+	return r.marshal(kck(s.ptk)), k, nil
+}
+
+// keyData decrypts the key data of f, and returns the group key and the
+// RSN information element in it.
+func (s *Supplicant) keyData(f *keyFrame) (*Keys, []byte, error) {
+	data, err := unwrapKey(kek(s.ptk), f.data)
+	if err != nil {
+		return nil, nil, err
+	}
+	var k *Keys
+	var ie []byte
+	for len(data) >= 2 {
+		id, l := data[0], int(data[1])
+		if id == 0xdd && l == 0 {
+			// The rest is padding.
+			break
+		}
+		if len(data) < 2+l {
+			return nil, nil, fmt.Errorf("key data element %d is truncated", id)
+		}
+		switch e := data[2 : 2+l]; {
+		case id == RSNElementID && ie == nil:
+			ie = data[:2+l]
+		case id == 0xdd && l > 6 && bytes.Equal(e[:4], []byte{0x00, 0x0f, 0xac, 1}):
+			// The GTK KDE.
+			k = &Keys{
+				Group:      append([]byte{}, e[6:]...),
+				GroupIndex: int(e[4] & 3),
+				GroupRSC:   append([]byte{}, f.rsc[:6]...),
+			}
+		}
+		data = data[2+l:]
+	}
+	if k == nil {
+		return nil, nil, fmt.Errorf("key data has no group key")
+	}
+	return k, ie, nil
+}
@@ -0,0 +1,323 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wpa
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(strings.Replace(s, " ", "", -1))
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// wrapKey is the inverse of unwrapKey, for the authenticator of the tests.
+func wrapKey(kek, data []byte) []byte {
+	c, err := aes.NewCipher(kek)
+	if err != nil {
+		panic(err)
+	}
+	n := len(data) / 8
+	a := append([]byte{}, keyWrapIV...)
+	r := append([]byte{}, data...)
+	b := make([]byte, 16)
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(b, a)
+			copy(b[8:], r[8*(i-1):8*i])
+			c.Encrypt(b, b)
+			binary.BigEndian.PutUint64(a, binary.BigEndian.Uint64(b)^uint64(n*j+i))
+			copy(r[8*(i-1):8*i], b[8:])
+		}
+	}
+	return append(a, r...)
+}
+
+func TestPRF(t *testing.T) {
+	// The PRF test vector of IEEE 802.11i, Annex H.3.
+	want := mustHex("bcd4c650b30b9684951829e0d75f9d54b862175ed9f00606e17d8da35402ffee75df78c3d31e0f889f012120c0862beb67753e7439ae242edb8373698356cf5a")
+	if got := prf(bytes.Repeat([]byte{0x0b}, 20), "prefix", []byte("Hi There"), 64); !bytes.Equal(got, want) {
+		t.Errorf("prf = %x, want %x", got, want)
+	}
+}
+
+func TestUnwrapKey(t *testing.T) {
+	// The test vector of RFC 3394, section 4.1.
+	kek := mustHex("000102030405060708090a0b0c0d0e0f")
+	wrapped := mustHex("1fa68b0a8112b447 aef34bd8fb5a7b82 9d3e862371d2cfe5")
+	want := mustHex("00112233445566778899aabbccddeeff")
+	got, err := unwrapKey(kek, wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("unwrapKey = %x, want %x", got, want)
+	}
+	if got := wrapKey(kek, want); !bytes.Equal(got, wrapped) {
+		t.Errorf("wrapKey = %x, want %x", got, wrapped)
+	}
+
+	wrapped[10] ^= 1
+	if _, err := unwrapKey(kek, wrapped); err == nil {
+		t.Errorf("unwrapKey of corrupted data succeeded, want error")
+	}
+	if _, err := unwrapKey(kek, wrapped[:20]); err == nil {
+		t.Errorf("unwrapKey of 20 bytes succeeded, want error")
+	}
+}
+
+func TestParseRSN(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		ie   string
+		want *RSN
+	}{
+		{
+			name: "PSK",
+			ie:   "0100 000fac04 0100 000fac04 0100 000fac02 0c00",
+			want: &RSN{GroupCipher: CipherCCMP, PairwiseCiphers: []uint32{CipherCCMP}, AKMs: []uint32{AKMPSK}, Capabilities: 0xc},
+		},
+		{
+			name: "mixed",
+			ie:   "0100 000fac02 0200 000fac04 000fac02 0200 000fac01 000fac02 0000",
+			want: &RSN{GroupCipher: CipherTKIP, PairwiseCiphers: []uint32{CipherCCMP, CipherTKIP}, AKMs: []uint32{AKM8021X, AKMPSK}},
+		},
+		{
+			name: "defaults",
+			ie:   "0100 000fac02",
+			want: &RSN{GroupCipher: CipherTKIP, PairwiseCiphers: []uint32{CipherCCMP}, AKMs: []uint32{AKM8021X}},
+		},
+		{
+			name: "version 2",
+			ie:   "0200 000fac04",
+		},
+		{
+			name: "truncated",
+			ie:   "0100 000fac04 0200 000fac04",
+		},
+	} {
+		got, err := ParseRSN(mustHex(tt.ie))
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("%s: got %+v, want error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	ie := mustHex("3014 0100 000fac04 0100 000fac04 0100 000fac02 0c00")
+	r, err := ParseRSN(ie[2:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Element(); !bytes.Equal(got, ie) {
+		t.Errorf("Element = %x, want %x", got, ie)
+	}
+}
+
+// authenticator is the access point side of the handshakes.
+type authenticator struct {
+	t       *testing.T
+	pmk     []byte
+	aa, spa net.HardwareAddr
+	ie      []byte
+	replay  uint64
+	anonce  [32]byte
+	ptk     []byte
+	gtk     []byte
+}
+
+func (a *authenticator) message1() []byte {
+	a.replay++
+	copy(a.anonce[:], bytes.Repeat([]byte{0xa5}, 32))
+	f := &keyFrame{info: keyVersionAES | keyPairwise | keyAck, length: 16, replay: a.replay, nonce: a.anonce}
+	return f.marshal(nil)
+}
+
+// message2 checks message 2 from the supplicant, which associated with
+// the RSN element ie.
+func (a *authenticator) message2(b []byte, ie []byte) {
+	f, err := parseKeyFrame(b)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	if f.info != keyVersionAES|keyPairwise|keyMIC || f.replay != a.replay {
+		a.t.Errorf("Message 2 has key information %#x and replay counter %d, want %#x and %d", f.info, f.replay, keyVersionAES|keyPairwise|keyMIC, a.replay)
+	}
+	if !bytes.Equal(f.data, ie) {
+		a.t.Errorf("Message 2 has key data %x, want %x", f.data, ie)
+	}
+	a.ptk = pairwiseKey(a.pmk, a.aa, a.spa, a.anonce[:], f.nonce[:])
+	if err := f.checkMIC(kck(a.ptk)); err != nil {
+		a.t.Errorf("Message 2: %v", err)
+	}
+}
+
+// keyData returns the wrapped key data with the GTK KDE, as key 1.
+func (a *authenticator) keyData(ie []byte) []byte {
+	d := append([]byte{}, ie...)
+	d = append(d, 0xdd, byte(6+len(a.gtk)), 0x00, 0x0f, 0xac, 1, 1, 0)
+	d = append(d, a.gtk...)
+	if len(d)%8 != 0 {
+		d = append(d, 0xdd)
+	}
+	for len(d)%8 != 0 {
+		d = append(d, 0)
+	}
+	return wrapKey(kek(a.ptk), d)
+}
+
+func (a *authenticator) message3() []byte {
+	a.replay++
+	f := &keyFrame{
+		info:   keyVersionAES | keyPairwise | keyInstall | keyAck | keyMIC | keySecure | keyEncrypted,
+		length: 16,
+		replay: a.replay,
+		nonce:  a.anonce,
+		rsc:    [8]byte{1, 2, 3, 4, 5, 6},
+		data:   a.keyData(a.ie),
+	}
+	return f.marshal(kck(a.ptk))
+}
+
+func (a *authenticator) groupMessage1() []byte {
+	a.replay++
+	f := &keyFrame{
+		info:   keyVersionAES | keyAck | keyMIC | keySecure | keyEncrypted,
+		length: 16,
+		replay: a.replay,
+		rsc:    [8]byte{9},
+		data:   a.keyData(nil),
+	}
+	return f.marshal(kck(a.ptk))
+}
+
+// reply checks the reply to message 3 or a group message 1.
+func (a *authenticator) reply(name string, b []byte, info uint16) {
+	f, err := parseKeyFrame(b)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	if f.info != info || f.replay != a.replay || len(f.data) != 0 {
+		a.t.Errorf("%s has key information %#x, replay counter %d and key data %x, want %#x, %d and none", name, f.info, f.replay, f.data, info, a.replay)
+	}
+	if err := f.checkMIC(kck(a.ptk)); err != nil {
+		a.t.Errorf("%s: %v", name, err)
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	pmk := bytes.Repeat([]byte{0x42}, 32)
+	aa := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	spa := net.HardwareAddr{0x02, 0, 0, 0, 0, 2}
+	r := &RSN{GroupCipher: CipherCCMP, PairwiseCiphers: []uint32{CipherCCMP}, AKMs: []uint32{AKMPSK}}
+	ie := r.Element()
+	a := &authenticator{t: t, pmk: pmk, aa: aa, spa: spa, ie: ie, gtk: bytes.Repeat([]byte{0x77}, 16)}
+	s := NewSupplicant(pmk, aa, spa, ie, ie)
+
+	a.ptk = make([]byte, 48)
+	if _, _, err := s.Handle(a.groupMessage1()); err == nil {
+		t.Errorf("Group key message before the 4-way handshake succeeded, want error")
+	}
+	reply, k, err := s.Handle(a.message1())
+	if err != nil || k != nil {
+		t.Fatalf("Message 1: got keys %v and error %v, want neither", k, err)
+	}
+	a.message2(reply, ie)
+
+	m3 := a.message3()
+	reply, k, err = s.Handle(m3)
+	if err != nil {
+		t.Fatalf("Message 3: %v", err)
+	}
+	a.reply("Message 4", reply, keyVersionAES|keyPairwise|keyMIC|keySecure)
+	want := &Keys{Pairwise: tk(a.ptk), Group: a.gtk, GroupIndex: 1, GroupRSC: []byte{1, 2, 3, 4, 5, 6}}
+	if !reflect.DeepEqual(k, want) {
+		t.Errorf("Message 3: got keys %+v, want %+v", k, want)
+	}
+	if _, _, err := s.Handle(m3); err == nil {
+		t.Errorf("Replayed message 3 succeeded, want error")
+	}
+
+	a.gtk = bytes.Repeat([]byte{0x88}, 16)
+	reply, k, err = s.Handle(a.groupMessage1())
+	if err != nil {
+		t.Fatalf("Group message 1: %v", err)
+	}
+	a.reply("Group message 2", reply, keyVersionAES|keyMIC|keySecure)
+	want = &Keys{Group: a.gtk, GroupIndex: 1, GroupRSC: []byte{9, 0, 0, 0, 0, 0}}
+	if !reflect.DeepEqual(k, want) {
+		t.Errorf("Group message 1: got keys %+v, want %+v", k, want)
+	}
+}
+
+func TestHandshakeErrors(t *testing.T) {
+	pmk := bytes.Repeat([]byte{0x42}, 32)
+	aa := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	spa := net.HardwareAddr{0x02, 0, 0, 0, 0, 2}
+	ie := (&RSN{GroupCipher: CipherCCMP, PairwiseCiphers: []uint32{CipherCCMP}, AKMs: []uint32{AKMPSK}}).Element()
+	tkip := (&RSN{GroupCipher: CipherTKIP, PairwiseCiphers: []uint32{CipherCCMP}, AKMs: []uint32{AKMPSK}}).Element()
+
+	for _, tt := range []struct {
+		name string
+		// The PMK and RSN element of the authenticator.
+		pmk []byte
+		ie  []byte
+		// apIE is the RSN element of the beacon.
+		apIE []byte
+	}{
+		{name: "wrong passphrase", pmk: bytes.Repeat([]byte{0x43}, 32), ie: ie, apIE: ie},
+		{name: "downgrade", pmk: pmk, ie: tkip, apIE: ie},
+	} {
+		a := &authenticator{t: t, pmk: tt.pmk, aa: aa, spa: spa, ie: tt.ie, gtk: bytes.Repeat([]byte{0x77}, 16)}
+		s := NewSupplicant(pmk, aa, spa, ie, tt.apIE)
+		reply, _, err := s.Handle(a.message1())
+		if err != nil {
+			t.Fatalf("%s: message 1: %v", tt.name, err)
+		}
+		f, err := parseKeyFrame(reply)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.ptk = pairwiseKey(a.pmk, aa, spa, a.anonce[:], f.nonce[:])
+		if _, _, err := s.Handle(a.message3()); err == nil {
+			t.Errorf("%s: message 3 succeeded, want error", tt.name)
+		}
+	}
+
+	s := NewSupplicant(pmk, aa, spa, ie, nil)
+	for _, tt := range []struct {
+		name  string
+		frame []byte
+	}{
+		{"empty", nil},
+		{"EAP", []byte{2, 0, 0, 5, 1, 1, 0, 5, 1}},
+		{"short", []byte{2, 3, 0, 5, 2, 0, 0x8a, 0, 16}},
+		{"request", (&keyFrame{info: keyVersionAES | keyPairwise | keyRequest | keyMIC}).marshal(nil)},
+		{"TKIP", (&keyFrame{info: 1 | keyPairwise | keyAck}).marshal(nil)},
+		{"message 3 first", (&keyFrame{info: keyVersionAES | keyPairwise | keyInstall | keyAck | keyMIC | keySecure | keyEncrypted}).marshal(nil)},
+	} {
+		if _, _, err := s.Handle(tt.frame); err == nil {
+			t.Errorf("%s: succeeded, want error", tt.name)
+		}
+	}
+}
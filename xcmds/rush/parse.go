@@ -14,10 +14,11 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"os/exec"
 	"path"
 	"path/filepath"
 	"strings"
+
+	"github.com/u-root/u-root/pkg/measure"
 )
 
 type arg struct {
@@ -28,7 +29,7 @@ type arg struct {
 // The Command struct is initially filled in by the parser. The shell itself
 // adds to it as processing continues, and then uses it to creates os.Commands
 type Command struct {
-	*exec.Cmd
+	*measure.Cmd
 	// These are filled in by the parser.
 	args  []arg
 	fdmap map[int]string
@@ -14,10 +14,11 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
 	"syscall"
+
+	"github.com/u-root/u-root/pkg/measure"
 )
 
 type builtin func(c *Command) error
@@ -163,7 +164,7 @@ func doArgs(cmds []*Command) error {
 // and we may change it later.
 func commands(cmds []*Command) error {
 	for _, c := range cmds {
-		c.Cmd = exec.Command(c.cmd, c.argv[:]...)
+		c.Cmd = measure.Command(c.cmd, c.argv[:]...)
 		// this is a Very Special Case related to a Go issue.
 		// we're not able to unshare correctly in builtin.
 		// Not sure of the issue but this hack will have to do until
@@ -30,8 +30,9 @@ package main
 import (
 	"fmt"
 	"os"
-	"os/exec"
 	"time"
+
+	"github.com/u-root/u-root/pkg/measure"
 )
 
 func init() {
@@ -53,7 +54,7 @@ func runtime(c *Command) error {
 		// The result of the failed lookup remains in
 		// c.Cmd and will make start fail. We have to make
 		// a new Cmd.
-		nCmd := exec.Command(c.cmd, c.argv[:]...)
+		nCmd := measure.Command(c.cmd, c.argv[:]...)
 		nCmd.Stdin = c.Stdin
 		nCmd.Stdout = c.Stdout
 		nCmd.Stderr = c.Stderr